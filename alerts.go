@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Metrics for service level objectives. The success ratio of an endpoint
+// is 1 - miniwfs_http_requests_total{code="5xx"} / miniwfs_http_requests_total;
+// saturation is given by the in-flight requests and the latency histogram.
+var (
+	numHTTPRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "miniwfs_http_requests_total",
+		Help: "Total number of handled HTTP requests, by endpoint and status code class such as 2xx.",
+	},
+		[]string{"endpoint", "code"})
+	httpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "miniwfs_http_request_duration_seconds",
+		Help:    "Time to handle HTTP requests, by endpoint.",
+		Buckets: []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+	},
+		[]string{"endpoint"})
+	numHTTPRequestsInFlight = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "miniwfs_http_requests_in_flight",
+		Help: "Number of HTTP requests currently being handled.",
+	})
+)
+
+// A burst of server errors gets reported as an alert when at least
+// errorBurstThreshold responses with status 5xx happen within
+// errorBurstWindow.
+const errorBurstThreshold = 10
+const errorBurstWindow = time.Minute
+
+// maxAlerts is how many recent alerts are kept for /admin/alerts.
+const maxAlerts = 100
+
+// Kinds of alerts.
+const (
+	alertLoadFailure = "load_failure"
+	alertErrorBurst  = "error_burst"
+)
+
+// Alert is an event that operators should know about, such as a
+// collection that failed to load.
+type Alert struct {
+	Time       time.Time `json:"time"`
+	Kind       string    `json:"kind"`
+	Collection string    `json:"collection,omitempty"`
+	Message    string    `json:"message"`
+	Count      int       `json:"count,omitempty"` // for bursts, number of errors so far
+}
+
+// AlertLog keeps the most recent alerts in memory.
+type AlertLog struct {
+	mutex       sync.Mutex
+	alerts      []Alert
+	burstStart  time.Time
+	burstErrors int
+	burstAlert  int // index of the alert for the current burst, or -1
+}
+
+func MakeAlertLog() *AlertLog {
+	return &AlertLog{burstAlert: -1}
+}
+
+// recentAlerts collects alerts from all parts of the server, just like
+// Prometheus metrics are registered globally.
+var recentAlerts = MakeAlertLog()
+
+// Alerts returns the recent alerts, most recent first.
+func (a *AlertLog) Alerts() []Alert {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	result := make([]Alert, len(a.alerts))
+	for i, alert := range a.alerts {
+		result[len(a.alerts)-1-i] = alert
+	}
+	return result
+}
+
+// LoadFailed records that a collection could not be loaded.
+func (a *AlertLog) LoadFailed(collection string, err error, now time.Time) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.add(Alert{Time: now, Kind: alertLoadFailure, Collection: collection, Message: err.Error()})
+}
+
+// ServerError records a response with status 5xx, raising an alert
+// once the errors within errorBurstWindow reach errorBurstThreshold.
+// Further errors in the same burst only increase the alert's count.
+func (a *AlertLog) ServerError(now time.Time) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if now.Sub(a.burstStart) > errorBurstWindow {
+		a.burstStart, a.burstErrors, a.burstAlert = now, 0, -1
+	}
+	a.burstErrors++
+	if a.burstAlert >= 0 && a.burstAlert < len(a.alerts) {
+		a.alerts[a.burstAlert].Count = a.burstErrors
+	} else if a.burstErrors >= errorBurstThreshold {
+		a.add(Alert{
+			Time:    a.burstStart,
+			Kind:    alertErrorBurst,
+			Message: "burst of responses with status 5xx",
+			Count:   a.burstErrors,
+		})
+		a.burstAlert = len(a.alerts) - 1
+	}
+}
+
+// add appends an alert, dropping the oldest one if the log is full.
+// Must be called with the mutex held.
+func (a *AlertLog) add(alert Alert) {
+	if len(a.alerts) >= maxAlerts {
+		a.alerts = append(a.alerts[:0], a.alerts[1:]...)
+		a.burstAlert--
+	}
+	a.alerts = append(a.alerts, alert)
+}
+
+// statusRecordingResponseWriter remembers the status code of a response.
+type statusRecordingResponseWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusRecordingResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusRecordingResponseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// endpointName classifies a request path into a small set of endpoints,
+// so metric labels stay bounded no matter what clients request.
+func endpointName(path string) string {
+	switch {
+	case tilesRegexp.MatchString(path), tileFeatureInfoRegexp.MatchString(path),
+		styleRegexp.MatchString(path), spriteRegexp.MatchString(path):
+		return "tiles"
+	case collectionRegexp.MatchString(path):
+		return "items"
+	case itemRegexp.MatchString(path):
+		return "item"
+	case strings.HasPrefix(path, "/admin/"):
+		return "admin"
+	case strings.HasPrefix(path, "/collections"):
+		return "collections"
+	case path == "/wfs":
+		return "wfs"
+	case path == "/api" || path == "/conformance":
+		return "api"
+	default:
+		return "other"
+	}
+}
+
+// recordRequest updates the service level metrics after handling
+// a request.
+func recordRequest(endpoint string, status int, duration time.Duration, now time.Time) {
+	if status == 0 {
+		status = http.StatusOK
+	}
+	code := strconv.Itoa(status/100) + "xx"
+	numHTTPRequests.WithLabelValues(endpoint, code).Inc()
+	httpRequestDuration.WithLabelValues(endpoint).Observe(duration.Seconds())
+	if status >= 500 {
+		recentAlerts.ServerError(now)
+	}
+}
+
+func (s *WebServer) handleAlertsRequest(w http.ResponseWriter, req *http.Request) {
+	encoded, err := json.Marshal(struct {
+		Alerts []Alert `json:"alerts"`
+	}{recentAlerts.Alerts()})
+	if err != nil {
+		httpLog.Error("json.Marshal failed", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(encoded)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(encoded)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestAlertLog_ErrorBurst(t *testing.T) {
+	a := MakeAlertLog()
+	start := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	for i := 0; i < errorBurstThreshold-1; i++ {
+		a.ServerError(start.Add(time.Duration(i) * time.Second))
+	}
+	if n := len(a.Alerts()); n != 0 {
+		t.Fatalf("expected no alert below threshold, got %d", n)
+	}
+
+	for i := 0; i < 5; i++ {
+		a.ServerError(start.Add(30 * time.Second))
+	}
+	alerts := a.Alerts()
+	if len(alerts) != 1 || alerts[0].Kind != alertErrorBurst || alerts[0].Count != errorBurstThreshold+4 ||
+		!alerts[0].Time.Equal(start) {
+		t.Fatalf("expected one burst alert with %d errors, got %+v", errorBurstThreshold+4, alerts)
+	}
+
+	// After the window, errors start a new burst.
+	a.ServerError(start.Add(2 * errorBurstWindow))
+	if n := len(a.Alerts()); n != 1 {
+		t.Errorf("expected a single error not to raise an alert, got %d alerts", n)
+	}
+}
+
+func TestAlertLog_Capacity(t *testing.T) {
+	a := MakeAlertLog()
+	now := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	for i := 0; i < maxAlerts+10; i++ {
+		a.LoadFailed("lakes", os.ErrNotExist, now.Add(time.Duration(i)*time.Second))
+	}
+	alerts := a.Alerts()
+	if len(alerts) != maxAlerts {
+		t.Fatalf("expected %d alerts, got %d", maxAlerts, len(alerts))
+	}
+	if expected := now.Add(time.Duration(maxAlerts+9) * time.Second); !alerts[0].Time.Equal(expected) {
+		t.Errorf("expected most recent alert first, got %v", alerts[0].Time)
+	}
+}
+
+func TestEndpointName(t *testing.T) {
+	for path, expected := range map[string]string{
+		"/tiles/castles/12/2138/1420.png":      "tiles",
+		"/tiles/castles/style.json":            "tiles",
+		"/collections/castles/items":           "items",
+		"/collections/castles/items/N34729562": "item",
+		"/collections":                         "collections",
+		"/collections/castles/sync":            "collections",
+		"/admin/alerts":                        "admin",
+		"/wfs":                                 "wfs",
+		"/api":                                 "api",
+		"/no/such/thing":                       "other",
+	} {
+		if got := endpointName(path); got != expected {
+			t.Errorf("endpointName(%q): expected %q, got %q", path, expected, got)
+		}
+	}
+}
+
+func TestAlerts_LoadFailure(t *testing.T) {
+	index, s, path, cleanup := makeUploadServer(t)
+	defer cleanup()
+
+	if err := ioutil.WriteFile(path, []byte("{malformed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	future := time.Now().Add(time.Hour)
+	if err := os.Chtimes(path, future, future); err != nil {
+		t.Fatal(err)
+	}
+	index.ReloadCollection("lakes")
+
+	req, _ := http.NewRequest("GET", "/admin/alerts", nil)
+	resp := httptest.NewRecorder()
+	http.HandlerFunc(s.HandleRequest).ServeHTTP(resp, req)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", resp.Code)
+	}
+	var got struct {
+		Alerts []Alert `json:"alerts"`
+	}
+	if err := json.Unmarshal(resp.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Alerts) == 0 || got.Alerts[0].Kind != alertLoadFailure || got.Alerts[0].Collection != "lakes" {
+		t.Errorf("expected load failure alert for lakes, got %+v", got.Alerts)
+	}
+}
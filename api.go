@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// handleAPIRequest serves an OpenAPI 3.0 description of our API.
+// Output formats are taken from the encoder registry, so that newly
+// registered formats get advertised without touching this file.
+func (s *WebServer) handleAPIRequest(w http.ResponseWriter, req *http.Request) {
+	if len(s.negotiate(w, req, "json")) == 0 {
+		return
+	}
+
+	type object map[string]interface{}
+
+	encoders := GetEnabledOutputEncoders()
+	formats := make([]string, len(encoders))
+	content := make(object)
+	for i, e := range encoders {
+		formats[i] = e.Name()
+		content[e.MediaType()] = object{}
+	}
+	formatParam := object{
+		"name": "f", "in": "query", "required": false,
+		"description": "output format; overrides the Accept header",
+		"schema":      object{"type": "string", "enum": formats},
+	}
+	pageFormatParam := object{
+		"name": "f", "in": "query", "required": false,
+		"description": "output format; overrides the Accept header",
+		"schema":      object{"type": "string", "enum": []string{"json", "html"}, "default": "json"},
+	}
+	collectionParam := object{
+		"name": "collectionId", "in": "path", "required": true,
+		"schema": object{"type": "string"},
+	}
+
+	api := object{
+		"openapi": "3.0.2",
+		"info": object{
+			"title":   "MiniWFS",
+			"version": "1.0.0",
+		},
+		"servers": []object{{"url": s.publicPath(req)}},
+		"paths": object{
+			"/": object{
+				"get": object{
+					"summary": "landing page with links to the API resources",
+					"responses": object{"200": object{
+						"description": "landing page",
+						"content":     object{"application/json": object{}, "text/html": object{}},
+					}},
+				},
+			},
+			"/conformance": object{
+				"get": object{
+					"summary": "list the implemented conformance classes",
+					"responses": object{"200": object{
+						"description": "conformance classes",
+						"content":     object{"application/json": object{}},
+					}},
+				},
+			},
+			"/collections": object{
+				"get": object{
+					"summary":    "list the feature collections",
+					"parameters": []object{pageFormatParam},
+					"responses": object{"200": object{
+						"description": "collections",
+						"content":     object{"application/json": object{}, "text/html": object{}},
+					}},
+				},
+			},
+			"/collections/{collectionId}": object{
+				"get": object{
+					"summary":    "describe a feature collection",
+					"parameters": []object{collectionParam, pageFormatParam},
+					"responses": object{"200": object{
+						"description": "collection",
+						"content":     object{"application/json": object{}, "text/html": object{}},
+					}},
+				},
+			},
+			"/collections/{collectionId}/items": object{
+				"get": object{
+					"summary":     "fetch features",
+					"description": "Features are paged in the itemOrder of the collection. Orders id and hilbert are canonical and stay stable across reloads of identical data; order source follows the source file.",
+					"parameters": []object{
+						collectionParam,
+						{"name": "limit", "in": "query", "required": false,
+							"schema": object{"type": "integer", "minimum": 1, "maximum": MaxLimit, "default": DefaultLimit}},
+						{"name": "bbox", "in": "query", "required": false, "style": "form", "explode": false,
+							"schema": object{"type": "array", "minItems": 4, "maxItems": 6, "items": object{"type": "number"}}},
+						{"name": "start", "in": "query", "required": false,
+							"schema": object{"type": "integer", "minimum": 0}},
+						{"name": "startID", "in": "query", "required": false,
+							"schema": object{"type": "string"}},
+						{"name": "includeDeleted", "in": "query", "required": false,
+							"description": "list tombstones of deleted features on the first page",
+							"schema":      object{"type": "boolean", "default": false}},
+						{"name": "ids", "in": "query", "required": false, "style": "form", "explode": false,
+							"description": "only return the features with these IDs, such as ?ids=N123,W456",
+							"schema":      object{"type": "array", "items": object{"type": "string"}}},
+						{"name": "q", "in": "query", "required": false,
+							"description": "full-text search in property values, such as ?q=pahl; results are ranked by relevance",
+							"schema":      object{"type": "string"}},
+						{"name": "within", "in": "query", "required": false,
+							"description": "only return features intersecting the area of a feature in another collection, such as ?within=cantons:R1686344",
+							"schema":      object{"type": "string"}},
+						{"name": "properties", "in": "query", "required": false, "style": "form", "explode": true,
+							"description": "only return features whose properties have the given values, such as ?historic=castle",
+							"schema":      object{"type": "object", "additionalProperties": object{"type": "string"}}},
+						{"name": "filter", "in": "query", "required": false,
+							"description": "CQL2 Text expression, such as historic = 'castle' AND S_INTERSECTS(geometry, BBOX(10, 45, 12, 48))",
+							"schema":      object{"type": "string"}},
+						{"name": "filter-lang", "in": "query", "required": false,
+							"schema": object{"type": "string", "enum": []string{"cql2-text", "cql2-json"}, "default": "cql2-text"}},
+						{"name": "profile", "in": "query", "required": false,
+							"description": "variant of the features; minimal has only ID, name and geometry, osm-compatible has OpenStreetMap-style IDs and tags. Can also be negotiated with the Accept-Profile header",
+							"schema":      object{"type": "string", "enum": Profiles, "default": Profiles[0]}},
+						formatParam,
+					},
+					"responses": object{"200": object{"description": "features", "content": content}},
+				},
+			},
+			"/collections/{collectionId}/sync": object{
+				"get": object{
+					"summary": "fetch the features that were added, changed or removed since a version",
+					"parameters": []object{
+						collectionParam,
+						{"name": "since", "in": "query", "required": false,
+							"description": "version returned by a previous sync; if missing, all features are returned as added",
+							"schema":      object{"type": "string"}},
+					},
+					"responses": object{
+						"200": object{"description": "changes", "content": object{"application/json": object{}}},
+						"410": object{"description": "version too old; sync again without since"},
+					},
+				},
+			},
+			"/collections/{collectionId}/properties/{property}/values": object{
+				"get": object{
+					"summary": "suggest the most frequent values of a property, for example in filter forms",
+					"parameters": []object{
+						collectionParam,
+						{"name": "property", "in": "path", "required": true,
+							"schema": object{"type": "string"}},
+						{"name": "q", "in": "query", "required": false,
+							"description": "prefix of the values, ignoring case and diacritics",
+							"schema":      object{"type": "string"}},
+						{"name": "limit", "in": "query", "required": false,
+							"schema": object{"type": "integer", "minimum": 1, "maximum": MaxValuesLimit, "default": DefaultValuesLimit}},
+					},
+					"responses": object{"200": object{"description": "values with counts", "content": object{"application/json": object{}}}},
+				},
+			},
+			"/collections/{collectionId}/queryables": object{
+				"get": object{
+					"summary":    "describe the properties that filter expressions can refer to, as a JSON Schema",
+					"parameters": []object{collectionParam},
+					"responses":  object{"200": object{"description": "queryables", "content": object{"application/schema+json": object{}}}},
+				},
+			},
+			"/collections/{collectionId}/process": object{
+				"get": object{
+					"summary": "compute the buffer, convex hull or union of the features selected like in /items",
+					"parameters": []object{
+						collectionParam,
+						{"name": "op", "in": "query", "required": true,
+							"schema": object{"type": "string", "enum": []string{"buffer", "convexhull", "union"}}},
+						{"name": "distance", "in": "query", "required": false,
+							"description": "buffer distance in meters",
+							"schema":      object{"type": "number", "exclusiveMinimum": 0, "maximum": maxBufferDistance}},
+					},
+					"responses": object{
+						"200": object{"description": "the result as a single feature", "content": object{"application/geo+json": object{}}},
+						"400": object{"description": "bad parameters, too many features, or union of non-polygons"},
+					},
+				},
+			},
+			"/collections/{collectionId}/items/{featureId}": object{
+				"get": object{
+					"summary": "fetch a single feature",
+					"parameters": []object{
+						collectionParam,
+						{"name": "featureId", "in": "path", "required": true,
+							"schema": object{"type": "string"}},
+						formatParam,
+					},
+					"responses": object{"200": object{"description": "feature", "content": content}},
+				},
+			},
+		},
+	}
+
+	encoded, err := json.Marshal(api)
+	if err != nil {
+		log.Printf("json.Marshal failed: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Content-Type", "application/vnd.oai.openapi+json;version=3.0")
+	w.WriteHeader(http.StatusOK)
+	w.Write(encoded)
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AccessControl decides whether a request may access a collection.
+// Protected collections require either a valid API key, passed in the
+// X-API-Key or "Authorization: Bearer" header, or a signed URL whose
+// query carries an expiry time and an HMAC signature. Signed URLs can
+// be embedded into web maps without exposing long-lived API keys to
+// the browser.
+//
+// Unlisted collections are left out of listings and exports, but
+// remain reachable by direct URL. Private collections are only served
+// on the admin listener.
+//
+// IP filters restrict by client network, either for all requests or
+// for individual collections.
+type AccessControl struct {
+	apiKeys    map[string]bool
+	protected  map[string]bool
+	unlisted   map[string]bool
+	private    map[string]bool
+	signingKey []byte
+	ipFilters  map[string]*IPFilter // keyed by collection; "" for all requests
+}
+
+func MakeAccessControl(apiKeys []string, protected []string, signingKey []byte) *AccessControl {
+	ac := &AccessControl{
+		apiKeys:    make(map[string]bool),
+		protected:  make(map[string]bool),
+		unlisted:   make(map[string]bool),
+		private:    make(map[string]bool),
+		signingKey: signingKey,
+		ipFilters:  make(map[string]*IPFilter),
+	}
+	for _, key := range apiKeys {
+		ac.apiKeys[key] = true
+	}
+	for _, coll := range protected {
+		ac.protected[coll] = true
+	}
+	return ac
+}
+
+// ReadKeyFile reads a file containing one key per line. Empty lines
+// and lines starting with '#' are ignored.
+func ReadKeyFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var keys []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) > 0 && !strings.HasPrefix(line, "#") {
+			keys = append(keys, line)
+		}
+	}
+	return keys, scanner.Err()
+}
+
+func (ac *AccessControl) SetUnlisted(collections []string) {
+	for _, coll := range collections {
+		ac.unlisted[coll] = true
+	}
+}
+
+func (ac *AccessControl) SetPrivate(collections []string) {
+	for _, coll := range collections {
+		ac.private[coll] = true
+	}
+}
+
+// SetIPFilter restricts access to a collection by client IP address.
+// If the collection is the empty string, the filter applies to all
+// requests.
+func (ac *AccessControl) SetIPFilter(collection string, f *IPFilter) {
+	ac.ipFilters[collection] = f
+}
+
+// AllowsIP returns true if the IP filter for a collection, or the
+// global filter if the collection is the empty string, lets the client
+// of a request pass.
+func (ac *AccessControl) AllowsIP(req *http.Request, collection string) bool {
+	if ac == nil {
+		return true
+	}
+	f, ok := ac.ipFilters[collection]
+	if !ok || f.Allows(getClientIP(req)) {
+		return true
+	}
+	numIPRejectedRequests.WithLabelValues(collection).Inc()
+	return false
+}
+
+func (ac *AccessControl) IsProtected(collection string) bool {
+	return ac != nil && ac.protected[collection]
+}
+
+func (ac *AccessControl) IsPrivate(collection string) bool {
+	return ac != nil && ac.private[collection]
+}
+
+// IsUnlisted returns true if a collection is reachable by direct URL,
+// but should neither appear in listings nor get indexed by crawlers.
+func (ac *AccessControl) IsUnlisted(collection string) bool {
+	return ac != nil && ac.unlisted[collection]
+}
+
+// IsListed returns true if a collection should appear in listings.
+// The admin listener lists all collections.
+func (ac *AccessControl) IsListed(collection string, admin bool) bool {
+	if ac == nil || admin {
+		return true
+	}
+	return !ac.unlisted[collection] && !ac.private[collection]
+}
+
+// Authorize returns http.StatusOK if the request may access the
+// collection, or the HTTP status to send back otherwise.
+func (ac *AccessControl) Authorize(req *http.Request, collection string, now time.Time) int {
+	if !ac.IsProtected(collection) {
+		return http.StatusOK
+	}
+
+	if ac.HasValidAPIKey(req) {
+		return http.StatusOK
+	}
+
+	params := req.URL.Query()
+	signature := params.Get("signature")
+	if len(signature) == 0 {
+		return http.StatusUnauthorized
+	}
+
+	expires, err := strconv.ParseInt(params.Get("expires"), 10, 64)
+	if err != nil || now.Unix() > expires {
+		return http.StatusForbidden
+	}
+
+	if !ac.checkSignature(collection, expires, signature) {
+		return http.StatusForbidden
+	}
+	return http.StatusOK
+}
+
+func (ac *AccessControl) HasValidAPIKey(req *http.Request) bool {
+	return len(ac.GetAPIKey(req)) > 0
+}
+
+// GetAPIKey returns the API key passed with a request, or the empty
+// string if the request carries no valid key.
+func (ac *AccessControl) GetAPIKey(req *http.Request) string {
+	if ac == nil {
+		return ""
+	}
+	key := req.Header.Get("X-API-Key")
+	if auth := req.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		key = strings.TrimSpace(strings.TrimPrefix(auth, "Bearer "))
+	}
+	if len(key) == 0 || !ac.apiKeys[key] {
+		return ""
+	}
+	return key
+}
+
+// SignURLQuery returns query parameters that grant access to all
+// endpoints of a collection until the expiry time. The signature
+// covers the collection rather than a single path, so that tile URL
+// templates like /tiles/castles/{z}/{x}/{y}.png can be signed once.
+func (ac *AccessControl) SignURLQuery(collection string, expires time.Time) url.Values {
+	exp := expires.Unix()
+	q := make(url.Values)
+	q.Set("expires", strconv.FormatInt(exp, 10))
+	q.Set("signature", ac.computeSignature(collection, exp))
+	return q
+}
+
+func (ac *AccessControl) computeSignature(collection string, expires int64) string {
+	mac := hmac.New(sha256.New, ac.signingKey)
+	mac.Write([]byte(collection + "\n" + strconv.FormatInt(expires, 10)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (ac *AccessControl) checkSignature(collection string, expires int64, signature string) bool {
+	if len(ac.signingKey) == 0 {
+		return false
+	}
+	expected := ac.computeSignature(collection, expires)
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(signature)) == 1
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func makeTestAccessControl() *AccessControl {
+	return MakeAccessControl([]string{"secret-api-key"}, []string{"castles"}, []byte("signing-key"))
+}
+
+func TestAccessControl_Unprotected(t *testing.T) {
+	ac := makeTestAccessControl()
+	req, _ := http.NewRequest("GET", "/collections/lakes/items", nil)
+	if status := ac.Authorize(req, "lakes", time.Now()); status != http.StatusOK {
+		t.Errorf("expected %d, got %d", http.StatusOK, status)
+	}
+
+	var nilAccess *AccessControl
+	if status := nilAccess.Authorize(req, "lakes", time.Now()); status != http.StatusOK {
+		t.Errorf("expected %d for nil AccessControl, got %d", http.StatusOK, status)
+	}
+}
+
+func TestAccessControl_APIKey(t *testing.T) {
+	ac := makeTestAccessControl()
+	type testCase struct {
+		Status int
+		Header string
+		Value  string
+	}
+	tests := []testCase{
+		{401, "", ""},
+		{401, "X-API-Key", "wrong-key"},
+		{200, "X-API-Key", "secret-api-key"},
+		{200, "Authorization", "Bearer secret-api-key"},
+		{401, "Authorization", "Basic secret-api-key"},
+	}
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/collections/castles/items", nil)
+		if len(e.Header) > 0 {
+			req.Header.Set(e.Header, e.Value)
+		}
+		if status := ac.Authorize(req, "castles", time.Now()); status != e.Status {
+			t.Errorf("expected %d for %s: %s, got %d", e.Status, e.Header, e.Value, status)
+		}
+	}
+}
+
+func TestAccessControl_SignedURL(t *testing.T) {
+	ac := makeTestAccessControl()
+	now := time.Date(2019, time.April, 4, 16, 9, 3, 0, time.UTC)
+	query := ac.SignURLQuery("castles", now.Add(time.Hour)).Encode()
+
+	req, _ := http.NewRequest("GET", "/tiles/castles/1/2/3.png?"+query, nil)
+	if status := ac.Authorize(req, "castles", now); status != http.StatusOK {
+		t.Errorf("expected %d for valid signature, got %d", http.StatusOK, status)
+	}
+
+	if status := ac.Authorize(req, "castles", now.Add(2*time.Hour)); status != http.StatusForbidden {
+		t.Errorf("expected %d for expired signature, got %d", http.StatusForbidden, status)
+	}
+
+	other := ac.SignURLQuery("lakes", now.Add(time.Hour)).Encode()
+	req, _ = http.NewRequest("GET", "/tiles/castles/1/2/3.png?"+other, nil)
+	if status := ac.Authorize(req, "castles", now); status != http.StatusForbidden {
+		t.Errorf("expected %d for signature of other collection, got %d", http.StatusForbidden, status)
+	}
+}
+
+func TestSignCollection(t *testing.T) {
+	index, s := makeServer(t)
+	defer s.Shutdown()
+	defer index.Close()
+	s.access = makeTestAccessControl()
+	handler := http.HandlerFunc(s.HandleRequest)
+
+	query, _ := http.NewRequest("GET", "/collections/castles/items", nil)
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, query)
+	if status := resp.Result().StatusCode; status != http.StatusUnauthorized {
+		t.Errorf("expected %d without credentials, got %d", http.StatusUnauthorized, status)
+	}
+
+	query, _ = http.NewRequest("GET", "/collections/castles/sign?ttl=60", nil)
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, query)
+	if status := resp.Result().StatusCode; status != http.StatusUnauthorized {
+		t.Errorf("expected %d for signing without API key, got %d", http.StatusUnauthorized, status)
+	}
+
+	query.Header.Set("X-API-Key", "secret-api-key")
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, query)
+	if status := resp.Result().StatusCode; status != http.StatusOK {
+		t.Fatalf("expected %d for signing with API key, got %d", http.StatusOK, status)
+	}
+	var signed struct {
+		Query string `json:"query"`
+	}
+	if err := json.Unmarshal(resp.Body.Bytes(), &signed); err != nil {
+		t.Fatal(err)
+	}
+
+	query, _ = http.NewRequest("GET", "/collections/castles/items?"+signed.Query, nil)
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, query)
+	if status := resp.Result().StatusCode; status != http.StatusOK {
+		t.Errorf("expected %d with signed URL, got %d", http.StatusOK, status)
+	}
+}
+
+func TestSignCollection_FollowNextLink(t *testing.T) {
+	index, s := makeServer(t)
+	defer s.Shutdown()
+	defer index.Close()
+	s.access = makeTestAccessControl()
+	handler := http.HandlerFunc(s.HandleRequest)
+
+	signed := s.access.SignURLQuery("castles", time.Now().Add(time.Hour)).Encode()
+	path := "/collections/castles/items?limit=1&" + signed
+	for page := 0; len(path) > 0; page++ {
+		req, _ := http.NewRequest("GET", path, nil)
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+		if resp.Code != http.StatusOK {
+			t.Fatalf("page %d: GET %s: expected status %d, got %d", page, path, http.StatusOK, resp.Code)
+		}
+		var result struct {
+			Links []WFSLink `json:"links"`
+		}
+		if err := json.Unmarshal(resp.Body.Bytes(), &result); err != nil {
+			t.Fatal(err)
+		}
+		path = ""
+		for _, link := range result.Links {
+			if link.Rel == "next" {
+				path = strings.TrimPrefix(link.Href, "https://test.example.org/wfs")
+			}
+		}
+		if page > 0 && len(path) == 0 {
+			return
+		}
+	}
+	t.Fatal("expected first page to link to the next one")
+}
+
+func TestAccessControl_Visibility(t *testing.T) {
+	index, s := makeServer(t)
+	defer s.Shutdown()
+	defer index.Close()
+	access := MakeAccessControl(nil, nil, nil)
+	access.SetUnlisted([]string{"lakes"})
+	access.SetPrivate([]string{"castles"})
+	s.access = access
+	adminServer := MakeWebServer(index)
+	adminServer.access = access
+	adminServer.admin = true
+
+	type testCase struct {
+		Admin  bool
+		Path   string
+		Status int
+	}
+	tests := []testCase{
+		{false, "/collections/lakes/items/N123", 200},
+		{false, "/collections/castles/items", 404},
+		{false, "/collections/castles/items/W418392510", 404},
+		{false, "/tiles/castles/0/0/0.png", 404},
+		{false, "/f/castles/W418392510", 404},
+		{true, "/collections/castles/items", 200},
+		{true, "/tiles/castles/0/0/0.png", 200},
+	}
+	for _, e := range tests {
+		server := s
+		if e.Admin {
+			server = adminServer
+		}
+		query, _ := http.NewRequest("GET", e.Path, nil)
+		resp := httptest.NewRecorder()
+		http.HandlerFunc(server.HandleRequest).ServeHTTP(resp, query)
+		if status := resp.Result().StatusCode; status != e.Status {
+			t.Errorf("expected %d for %s (admin=%v), got %d", e.Status, e.Path, e.Admin, status)
+		}
+	}
+
+	for _, path := range []string{
+		"/collections/lakes",
+		"/collections/lakes/items",
+		"/collections/lakes/items/N123",
+		"/collections/lakes/items/N123?f=html",
+		"/tiles/lakes/0/0/0.png",
+	} {
+		query, _ := http.NewRequest("GET", path, nil)
+		resp := httptest.NewRecorder()
+		http.HandlerFunc(s.HandleRequest).ServeHTTP(resp, query)
+		if got := resp.Header().Get("X-Robots-Tag"); resp.Code != http.StatusOK || got != "noindex" {
+			t.Errorf("GET %s of unlisted collection: expected status 200 with X-Robots-Tag: noindex, got %d and %q",
+				path, resp.Code, got)
+		}
+	}
+	query, _ := http.NewRequest("GET", "/collections/castles/items", nil)
+	resp := httptest.NewRecorder()
+	http.HandlerFunc(adminServer.HandleRequest).ServeHTTP(resp, query)
+	if got := resp.Header().Get("X-Robots-Tag"); got != "" {
+		t.Errorf("expected no X-Robots-Tag for collection that is not unlisted, got %q", got)
+	}
+
+	for _, e := range []struct {
+		Server   *WebServer
+		Expected string
+	}{{s, ""}, {adminServer, "castles,lakes"}} {
+		query, _ := http.NewRequest("GET", "/collections", nil)
+		resp := httptest.NewRecorder()
+		http.HandlerFunc(e.Server.HandleRequest).ServeHTTP(resp, query)
+		var result struct {
+			Collections []struct {
+				Name string `json:"name"`
+			} `json:"collections"`
+		}
+		json.Unmarshal(resp.Body.Bytes(), &result)
+		names := make([]string, len(result.Collections))
+		for i, c := range result.Collections {
+			names[i] = c.Name
+		}
+		if got := strings.Join(names, ","); got != e.Expected {
+			t.Errorf("expected listed collections \"%s\" (admin=%v), got \"%s\"",
+				e.Expected, e.Server.admin, got)
+		}
+	}
+}
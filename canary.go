@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+)
+
+// Canary collections let us validate a new data drop with selected
+// clients before swapping it in for everyone. The canary of collection
+// "castles" gets loaded as collection "castles-next" from its own
+// source. Requests carrying the X-MiniWFS-Canary: true header, or the
+// ?canary=true query flag, are served from the canary; all other
+// requests keep getting the live data, and cannot reach the canary.
+
+// CanarySuffix is appended to a collection name to name its canary.
+const CanarySuffix = "-next"
+
+// CanaryHeader is the HTTP request header for asking for canary data.
+const CanaryHeader = "X-MiniWFS-Canary"
+
+var canaryPathRegexp = regexp.MustCompile(`^/(collections|tiles)/([^/]+)(/.*)?$`)
+
+func canaryName(collection string) string {
+	return collection + CanarySuffix
+}
+
+// parseCanaryCollections parses a list of collection=path for canary
+// sources, returning the sources keyed by the canary collection name
+// and the set of collections that have a canary.
+func parseCanaryCollections(canaries string) (map[string]string, map[string]bool) {
+	sources := make(map[string]string)
+	collections := make(map[string]bool)
+	if len(canaries) == 0 {
+		return sources, collections
+	}
+	for collection, path := range parseCollections(canaries) {
+		sources[canaryName(collection)] = path
+		collections[collection] = true
+	}
+	return sources, collections
+}
+
+// wantsCanary returns true if a request asks to be served canary data.
+func wantsCanary(req *http.Request) bool {
+	flag := req.Header.Get(CanaryHeader)
+	if len(flag) == 0 {
+		flag = req.URL.Query().Get("canary")
+	}
+	want, _ := strconv.ParseBool(flag)
+	return want
+}
+
+// isCanary returns true if a collection is the canary of another one.
+func (s *WebServer) isCanary(collection string) bool {
+	n := len(collection) - len(CanarySuffix)
+	return n > 0 && collection[n:] == CanarySuffix && s.canaries[collection[:n]]
+}
+
+// routingPath returns the path for routing a request. Requests that ask
+// for canary data get routed to the canary of their collection, if it
+// has one. Since the response depends on the header, caches get told
+// to vary on it. The returned path is escaped, see EscapeItemID.
+func (s *WebServer) routingPath(w http.ResponseWriter, req *http.Request) string {
+	path := req.URL.EscapedPath()
+	m := canaryPathRegexp.FindStringSubmatch(path)
+	if len(m) != 4 {
+		return path
+	}
+	collection, err := url.PathUnescape(m[2])
+	if err != nil || !s.canaries[collection] {
+		return path
+	}
+	w.Header().Add("Vary", CanaryHeader)
+	if !wantsCanary(req) {
+		return path
+	}
+	return "/" + m[1] + "/" + url.PathEscape(canaryName(collection)) + m[3]
+}
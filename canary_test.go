@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func makeCanaryServer(t *testing.T) (*Index, *WebServer) {
+	sources, canaries := parseCanaryCollections("castles=" + filepath.Join("testdata", "lakes.geojson"))
+	sources["castles"] = filepath.Join("testdata", "castles.geojson")
+	publicPath, _ := url.Parse("https://test.example.org/wfs/")
+	index, err := MakeIndex(sources, publicPath, IndexOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := MakeWebServer(index)
+	s.canaries = canaries
+	return index, s
+}
+
+func TestCanary(t *testing.T) {
+	index, s := makeCanaryServer(t)
+	defer index.Close()
+
+	get := func(path string, header string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", path, nil)
+		if len(header) > 0 {
+			req.Header.Set(CanaryHeader, header)
+		}
+		resp := httptest.NewRecorder()
+		http.HandlerFunc(s.HandleRequest).ServeHTTP(resp, req)
+		return resp
+	}
+
+	for _, tc := range []struct {
+		path, header string
+		expected     int
+	}{
+		{"/collections/castles/items/N34729562", "", http.StatusOK},
+		{"/collections/castles/items/N123", "", http.StatusNotFound},
+		{"/collections/castles/items/N123", "true", http.StatusOK},
+		{"/collections/castles/items/N123?canary=true", "", http.StatusOK},
+		{"/collections/castles/items/N34729562", "true", http.StatusNotFound},
+		{"/collections/castles/items/N123?canary=true", "false", http.StatusNotFound},
+		{"/collections/castles-next/items/N123", "", http.StatusNotFound},
+	} {
+		resp := get(tc.path, tc.header)
+		if resp.Code != tc.expected {
+			t.Errorf("GET %s with %s: %q: expected status %d, got %d", tc.path, CanaryHeader, tc.header, tc.expected, resp.Code)
+		}
+		vary := strings.Join(resp.Header()["Vary"], ", ")
+		if !strings.HasPrefix(tc.path, "/collections/castles-next/") && !strings.HasPrefix(vary, CanaryHeader) {
+			t.Errorf("GET %s: expected Vary: %s, got %q", tc.path, CanaryHeader, vary)
+		}
+	}
+
+	if body := getBody(get("/collections", "")); strings.Contains(body, "castles-next") {
+		t.Errorf("expected canary to be unlisted, got %s", body)
+	}
+}
+
+func TestCanary_Links(t *testing.T) {
+	index, s := makeCanaryServer(t)
+	defer index.Close()
+
+	for _, header := range []string{"", "true"} {
+		path := "/collections/castles/items?limit=1"
+		if len(header) == 0 {
+			path += "&canary=true"
+		}
+		req, _ := http.NewRequest("GET", path, nil)
+		if len(header) > 0 {
+			req.Header.Set(CanaryHeader, header)
+		}
+		resp := httptest.NewRecorder()
+		http.HandlerFunc(s.HandleRequest).ServeHTTP(resp, req)
+		var page WFSFeatureCollection
+		if err := json.Unmarshal(resp.Body.Bytes(), &page); err != nil {
+			t.Fatal(err)
+		}
+		if len(page.Links) == 0 {
+			t.Fatalf("GET %s: expected links, got %s", path, getBody(resp))
+		}
+		for _, link := range page.Links {
+			u, err := url.Parse(link.Href)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if u.Path != "/wfs/collections/castles/items" || u.Query().Get("canary") != "true" {
+				t.Errorf("GET %s: expected %s link to canary under public name, got %s", path, link.Rel, link.Href)
+			}
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"math"
+	"strconv"
+
+	"github.com/paulmach/go.geojson"
+)
+
+// Experimental CityJSON 1.1 encoder. Our data is two-dimensional, so
+// all features become GenericCityObjects at level of detail 0, with
+// a height of zero unless the source coordinates carry one.
+// https://www.cityjson.org/specs/1.1.3/
+
+func init() {
+	RegisterOutputEncoder(cityJSONEncoder{}, true)
+}
+
+// cityJSONScale is the precision of CityJSON vertices, in degrees.
+const cityJSONScale = 1e-7
+
+type cityJSONEncoder struct{}
+
+func (cityJSONEncoder) Name() string {
+	return "cityjson"
+}
+
+func (cityJSONEncoder) MediaType() string {
+	return "application/city+json"
+}
+
+type cityJSONGeometry struct {
+	Type       string      `json:"type"`
+	LOD        string      `json:"lod"`
+	Boundaries interface{} `json:"boundaries"`
+}
+
+type cityJSONObject struct {
+	Type       string                 `json:"type"`
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+	Geometry   []cityJSONGeometry     `json:"geometry"`
+}
+
+type cityJSONDocument struct {
+	Type      string `json:"type"`
+	Version   string `json:"version"`
+	Transform struct {
+		Scale     [3]float64 `json:"scale"`
+		Translate [3]float64 `json:"translate"`
+	} `json:"transform"`
+	Metadata struct {
+		ReferenceSystem string `json:"referenceSystem"`
+	} `json:"metadata"`
+	CityObjects map[string]*cityJSONObject `json:"CityObjects"`
+	Vertices    [][3]int64                 `json:"vertices"`
+}
+
+// cityJSONBuilder collects the vertices of a CityJSON document, which
+// are shared by all objects and referenced by index.
+type cityJSONBuilder struct {
+	doc    cityJSONDocument
+	origin [3]float64
+	index  map[[3]int64]int
+}
+
+func (e cityJSONEncoder) EncodeFeature(w io.Writer, collection string, feature RawFeature) error {
+	return e.EncodeFeatureCollection(w, collection, []RawFeature{feature})
+}
+
+func (cityJSONEncoder) EncodeFeatureCollection(w io.Writer, collection string, features []RawFeature) error {
+	b := &cityJSONBuilder{index: make(map[[3]int64]int)}
+	b.doc.Type = "CityJSON"
+	b.doc.Version = "1.1"
+	b.doc.Metadata.ReferenceSystem = "https://www.opengis.net/def/crs/OGC/1.3/CRS84"
+	b.doc.CityObjects = make(map[string]*cityJSONObject)
+	b.doc.Vertices = [][3]int64{}
+	b.origin = cityJSONOrigin(features)
+	b.doc.Transform.Scale = [3]float64{cityJSONScale, cityJSONScale, 0.001}
+	b.doc.Transform.Translate = b.origin
+
+	for i, f := range features {
+		id := getIDString(f.Feature.ID)
+		if len(id) == 0 {
+			id = collection + "-" + strconv.Itoa(i)
+		}
+		obj := &cityJSONObject{
+			Type:       "GenericCityObject",
+			Attributes: f.Feature.Properties,
+			Geometry:   []cityJSONGeometry{},
+		}
+		if g := b.convertGeometry(f.Feature.Geometry); g != nil {
+			obj.Geometry = append(obj.Geometry, *g)
+		}
+		b.doc.CityObjects[id] = obj
+	}
+
+	return json.NewEncoder(w).Encode(&b.doc)
+}
+
+func (b *cityJSONBuilder) convertGeometry(g *geojson.Geometry) *cityJSONGeometry {
+	if g == nil {
+		return nil
+	}
+	switch g.Type {
+	case geojson.GeometryPoint:
+		return &cityJSONGeometry{"MultiPoint", "0", b.vertices([][]float64{g.Point})}
+
+	case geojson.GeometryMultiPoint:
+		return &cityJSONGeometry{"MultiPoint", "0", b.vertices(g.MultiPoint)}
+
+	case geojson.GeometryLineString:
+		return &cityJSONGeometry{"MultiLineString", "0", [][]int{b.vertices(g.LineString)}}
+
+	case geojson.GeometryMultiLineString:
+		lines := make([][]int, len(g.MultiLineString))
+		for i, line := range g.MultiLineString {
+			lines[i] = b.vertices(line)
+		}
+		return &cityJSONGeometry{"MultiLineString", "0", lines}
+
+	case geojson.GeometryPolygon:
+		return &cityJSONGeometry{"MultiSurface", "0", [][][]int{b.rings(g.Polygon)}}
+
+	case geojson.GeometryMultiPolygon:
+		surfaces := make([][][]int, len(g.MultiPolygon))
+		for i, poly := range g.MultiPolygon {
+			surfaces[i] = b.rings(poly)
+		}
+		return &cityJSONGeometry{"MultiSurface", "0", surfaces}
+
+	default:
+		return nil
+	}
+}
+
+// rings converts polygon rings, dropping the closing vertex because
+// CityJSON rings are implicitly closed.
+func (b *cityJSONBuilder) rings(rings [][][]float64) [][]int {
+	result := make([][]int, len(rings))
+	for i, ring := range rings {
+		if n := len(ring); n > 1 && len(ring[0]) >= 2 && len(ring[n-1]) >= 2 &&
+			ring[0][0] == ring[n-1][0] && ring[0][1] == ring[n-1][1] {
+			ring = ring[:n-1]
+		}
+		result[i] = b.vertices(ring)
+	}
+	return result
+}
+
+func (b *cityJSONBuilder) vertices(points [][]float64) []int {
+	result := make([]int, 0, len(points))
+	for _, p := range points {
+		if len(p) < 2 {
+			continue
+		}
+		var v [3]int64
+		v[0] = int64(math.Round((p[0] - b.origin[0]) / cityJSONScale))
+		v[1] = int64(math.Round((p[1] - b.origin[1]) / cityJSONScale))
+		if len(p) >= 3 {
+			v[2] = int64(math.Round(p[2] / 0.001))
+		}
+		i, ok := b.index[v]
+		if !ok {
+			i = len(b.doc.Vertices)
+			b.doc.Vertices = append(b.doc.Vertices, v)
+			b.index[v] = i
+		}
+		result = append(result, i)
+	}
+	return result
+}
+
+// cityJSONOrigin returns the south-western corner of all features,
+// which keeps the integer vertex coordinates small.
+func cityJSONOrigin(features []RawFeature) [3]float64 {
+	var origin [3]float64
+	bounds := computeBounds(nil)
+	for _, f := range features {
+		bounds = bounds.Union(computeBounds(f.Feature.Geometry))
+	}
+	if !bounds.IsEmpty() {
+		origin[0] = bounds.Lo().Lng.Degrees()
+		origin[1] = bounds.Lo().Lat.Degrees()
+	}
+	return origin
+}
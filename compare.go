@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// maxComparedFeatures limits how many feature IDs a comparison lists
+// for each kind of change. The counts are always complete.
+const maxComparedFeatures = 1000
+
+// ComparedVersion summarizes one side of a comparison.
+type ComparedVersion struct {
+	Version     string    `json:"version"`
+	NumFeatures int       `json:"numFeatures"`
+	Bbox        []float64 `json:"bbox,omitempty"`
+}
+
+// FeatureChange tells how a feature differs between two versions.
+type FeatureChange struct {
+	ID         string   `json:"id"`
+	Geometry   bool     `json:"geometry"`             // true if the geometry has changed
+	Properties []string `json:"properties,omitempty"` // added, removed or changed properties
+}
+
+// Comparison reports how a candidate for the data of a collection
+// differs from the live version.
+type Comparison struct {
+	Collection       string          `json:"collection"`
+	Source           string          `json:"source"`
+	Live             ComparedVersion `json:"live"`
+	Candidate        ComparedVersion `json:"candidate"`
+	NumFeaturesDelta int             `json:"numFeaturesDelta"`
+	ExtentChanged    bool            `json:"extentChanged"`
+	NumAdded         int             `json:"numAdded"`
+	NumRemoved       int             `json:"numRemoved"`
+	NumChanged       int             `json:"numChanged"`
+	Added            []string        `json:"added"`
+	Removed          []string        `json:"removed"`
+	Changed          []FeatureChange `json:"changed"`
+}
+
+// CompareCollection loads a candidate source off to the side and
+// reports how it differs from the live data of a collection. Serving
+// is not affected; the candidate gets discarded afterwards.
+func (index *Index) CompareCollection(collection string, source string) (*Comparison, error) {
+	if !index.HasCollection(collection) {
+		return nil, NotFound
+	}
+
+	var t0 time.Time
+	candidate, err := readMigratedCollection(collection, source, t0, index.getLoadOptions(collection))
+	if err != nil {
+		return nil, err
+	}
+	defer candidate.Close()
+
+	index.mutex.RLock()
+	defer index.mutex.RUnlock()
+	live := index.Collections[collection]
+	if live == nil {
+		return nil, NotFound
+	}
+
+	diff := diffCollections(live, candidate)
+	changed := truncateIDs(diff.Changed)
+	result := &Comparison{
+		Collection:       collection,
+		Source:           source,
+		Live:             compareVersion(live),
+		Candidate:        compareVersion(candidate),
+		NumFeaturesDelta: candidate.numFeatures() - live.numFeatures(),
+		ExtentChanged:    live.metadata.Bbox != candidate.metadata.Bbox,
+		NumAdded:         len(diff.Added),
+		NumRemoved:       len(diff.Removed),
+		NumChanged:       len(diff.Changed),
+		Added:            truncateIDs(diff.Added),
+		Removed:          truncateIDs(diff.Removed),
+		Changed:          make([]FeatureChange, 0, len(changed)),
+	}
+	for _, id := range changed {
+		change, err := compareFeature(live, candidate, id)
+		if err != nil {
+			return nil, err
+		}
+		result.Changed = append(result.Changed, change)
+	}
+	return result, nil
+}
+
+func compareVersion(c *Collection) ComparedVersion {
+	return ComparedVersion{
+		Version:     c.metadata.Version,
+		NumFeatures: c.numFeatures(),
+		Bbox:        EncodeBbox(c.metadata.Bbox),
+	}
+}
+
+func truncateIDs(ids []string) []string {
+	if ids == nil {
+		return []string{}
+	}
+	if len(ids) > maxComparedFeatures {
+		return ids[:maxComparedFeatures]
+	}
+	return ids
+}
+
+// compareFeature tells how a feature differs between two collections.
+func compareFeature(old *Collection, new *Collection, id string) (FeatureChange, error) {
+	change := FeatureChange{ID: id}
+	i, _ := old.lookupID(id)
+	a, err := old.readFeature(i)
+	if err != nil {
+		return change, err
+	}
+	j, _ := new.lookupID(id)
+	b, err := new.readFeature(j)
+	if err != nil {
+		return change, err
+	}
+
+	ga, err := json.Marshal(a.Geometry)
+	if err != nil {
+		return change, err
+	}
+	gb, err := json.Marshal(b.Geometry)
+	if err != nil {
+		return change, err
+	}
+	change.Geometry = !bytes.Equal(ga, gb)
+
+	for key, value := range a.Properties {
+		if other, ok := b.Properties[key]; !ok || !reflect.DeepEqual(value, other) {
+			change.Properties = append(change.Properties, key)
+		}
+	}
+	for key := range b.Properties {
+		if _, ok := a.Properties[key]; !ok {
+			change.Properties = append(change.Properties, key)
+		}
+	}
+	sort.Strings(change.Properties)
+	return change, nil
+}
+
+// handleCompareRequest serves /admin/compare?collection=castles&candidate=/path/new.geojson.
+// It is only served on the admin listener.
+func (s *WebServer) handleCompareRequest(w http.ResponseWriter, req *http.Request) {
+	params := req.URL.Query()
+	collection, source := params.Get("collection"), params.Get("candidate")
+	if len(collection) == 0 || len(source) == 0 {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusBadRequest)
+		io.WriteString(w, "missing query parameters; pass something like ?collection=castles&candidate=/path/new.geojson\n")
+		return
+	}
+
+	result, err := s.index.CompareCollection(collection, source)
+	if err == NotFound {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Printf("comparing collection %s to %s failed: %v", collection, source, err)
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusBadRequest)
+		io.WriteString(w, err.Error()+"\n")
+		return
+	}
+
+	encoded, err := json.Marshal(result)
+	if err != nil {
+		log.Printf("json.Marshal failed: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(encoded)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(encoded)
+}
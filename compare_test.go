@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+)
+
+func TestCompare(t *testing.T) {
+	index, s, path, cleanup := makeUploadServer(t)
+	defer cleanup()
+
+	candidate := filepath.Join(filepath.Dir(path), "candidate.geojson")
+	content := `{"type":"FeatureCollection","features":[
+		{"type":"Feature","id":"N123","properties":{"natural":"water","name":"Katzensee"},
+		 "geometry":{"type":"Point","coordinates":[11.183468,47.910414]}},
+		{"type":"Feature","id":"N7","properties":{"name":"Greifensee"},
+		 "geometry":{"type":"Point","coordinates":[8.68,47.35]}}]}`
+	if err := ioutil.WriteFile(candidate, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	req, _ := http.NewRequest("GET", "/admin/compare?collection=lakes&candidate="+url.QueryEscape(candidate), nil)
+	resp := httptest.NewRecorder()
+	http.HandlerFunc(s.HandleRequest).ServeHTTP(resp, req)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", resp.Code, getBody(resp))
+	}
+
+	var result Comparison
+	if err := json.Unmarshal(resp.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	if result.NumFeaturesDelta != 1 || !result.ExtentChanged {
+		t.Errorf("expected one more feature and a changed extent, got %+v", result)
+	}
+	if len(result.Added) != 1 || result.Added[0] != "N7" || len(result.Removed) != 0 {
+		t.Errorf("expected N7 to be added, got %+v", result)
+	}
+	if len(result.Changed) != 1 || result.Changed[0].Geometry ||
+		len(result.Changed[0].Properties) != 1 || result.Changed[0].Properties[0] != "natural" {
+		t.Errorf("expected property natural of N123 to be changed, got %+v", result.Changed)
+	}
+
+	// Comparing does not affect serving.
+	if item, _ := index.GetItem("lakes", "N7"); item != nil {
+		t.Error("expected candidate not to be served")
+	}
+}
+
+func TestCompare_BadRequest(t *testing.T) {
+	_, s, _, cleanup := makeUploadServer(t)
+	defer cleanup()
+
+	for path, expected := range map[string]int{
+		"/admin/compare?collection=lakes":                                 http.StatusBadRequest,
+		"/admin/compare?collection=lakes&candidate=/no/such/file.geojson": http.StatusBadRequest,
+		"/admin/compare?collection=nosuchcollection&candidate=x.geojson":  http.StatusNotFound,
+	} {
+		req, _ := http.NewRequest("GET", path, nil)
+		resp := httptest.NewRecorder()
+		http.HandlerFunc(s.HandleRequest).ServeHTTP(resp, req)
+		if resp.Code != expected {
+			t.Errorf("GET %s: expected status %d, got %d", path, expected, resp.Code)
+		}
+	}
+}
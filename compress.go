@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// gzipWriters keeps compressors for reuse, since each one holds about
+// a megabyte of tables and buffers that would otherwise get allocated
+// for every compressed response.
+var gzipWriters = sync.Pool{
+	New: func() interface{} {
+		w, _ := gzip.NewWriterLevel(nil, gzip.DefaultCompression)
+		return w
+	},
+}
+
+// acceptsGzip tells whether a client accepts gzip-compressed responses.
+// https://www.rfc-editor.org/rfc/rfc9110#name-accept-encoding
+func acceptsGzip(req *http.Request) bool {
+	accepted := false
+	for _, item := range strings.Split(req.Header.Get("Accept-Encoding"), ",") {
+		parts := strings.Split(item, ";")
+		coding := strings.ToLower(strings.TrimSpace(parts[0]))
+		if coding != "gzip" && coding != "x-gzip" && coding != "*" {
+			continue
+		}
+		q := 1.0
+		for _, param := range parts[1:] {
+			kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+			if len(kv) == 2 && strings.TrimSpace(kv[0]) == "q" {
+				if v, err := strconv.ParseFloat(strings.TrimSpace(kv[1]), 64); err == nil {
+					q = v
+				}
+			}
+		}
+		// An explicit gzip;q=0 overrides a wildcard.
+		if coding != "*" && q == 0 {
+			return false
+		}
+		if q > 0 {
+			accepted = true
+		}
+	}
+	return accepted
+}
+
+// gzipBytes appends the gzip compression of data to a buffer.
+func gzipBytes(buf *bytes.Buffer, data []byte) error {
+	w := gzipWriters.Get().(*gzip.Writer)
+	defer gzipWriters.Put(w)
+	w.Reset(buf)
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	return w.Close()
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestAcceptsGzip(t *testing.T) {
+	for _, tc := range []struct {
+		header   string
+		expected bool
+	}{
+		{"", false},
+		{"gzip", true},
+		{"deflate, gzip;q=0.5", true},
+		{"GZIP", true},
+		{"x-gzip", true},
+		{"*", true},
+		{"br", false},
+		{"gzip;q=0", false},
+		{"*, gzip;q=0", false},
+		{"identity", false},
+	} {
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept-Encoding", tc.header)
+		if got := acceptsGzip(req); got != tc.expected {
+			t.Errorf("Accept-Encoding %q: expected %v, got %v", tc.header, tc.expected, got)
+		}
+	}
+}
+
+func TestGzipItems(t *testing.T) {
+	index, s := makeServer(t)
+	defer s.Shutdown()
+	defer index.Close()
+
+	get := func(acceptEncoding string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "/collections/castles/items", nil)
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+		resp := httptest.NewRecorder()
+		http.HandlerFunc(s.HandleRequest).ServeHTTP(resp, req)
+		return resp
+	}
+
+	plain := get("")
+	compressed := get("gzip")
+	if enc := compressed.Header().Get("Content-Encoding"); enc != "gzip" {
+		t.Fatalf("expected Content-Encoding: gzip, got %q", enc)
+	}
+	if cl := compressed.Header().Get("Content-Length"); cl != strconv.Itoa(compressed.Body.Len()) {
+		t.Errorf("expected Content-Length of compressed body %d, got %s", compressed.Body.Len(), cl)
+	}
+	if compressed.Body.Len() >= plain.Body.Len() {
+		t.Errorf("expected compressed body to be smaller than %d bytes, got %d", plain.Body.Len(), compressed.Body.Len())
+	}
+	if getBody(compressed) != plain.Body.String() {
+		t.Errorf("expected compressed body to decompress to %s, got %s", plain.Body.String(), getBody(compressed))
+	}
+	if compressed.Header().Get("ETag") == plain.Header().Get("ETag") {
+		t.Error("expected compressed response to have its own ETag")
+	}
+	for _, resp := range []*httptest.ResponseRecorder{plain, compressed} {
+		found := false
+		for _, v := range resp.Header()["Vary"] {
+			found = found || v == "Accept-Encoding"
+		}
+		if !found {
+			t.Errorf("expected Vary: Accept-Encoding, got %q", resp.Header()["Vary"])
+		}
+	}
+	if enc := plain.Header().Get("Content-Encoding"); enc != "" {
+		t.Errorf("expected no Content-Encoding without Accept-Encoding, got %q", enc)
+	}
+}
+
+func TestGzipBytes(t *testing.T) {
+	data := bytes.Repeat([]byte(`{"type":"Feature"},`), 1000)
+	for i := 0; i < 3; i++ { // pooled writers must be reset properly
+		var buf bytes.Buffer
+		buf.WriteString("prefix")
+		if err := gzipBytes(&buf, data); err != nil {
+			t.Fatal(err)
+		}
+		r, err := gzip.NewReader(bytes.NewReader(buf.Bytes()[len("prefix"):]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := ioutil.ReadAll(r)
+		if err != nil || !bytes.Equal(got, data) {
+			t.Errorf("expected round trip of %d bytes, got %d bytes, error %v", len(data), len(got), err)
+		}
+	}
+}
@@ -0,0 +1,664 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Configuration files, for deployments whose --collections argument
+// has grown unwieldy. A file defines collections plus server settings,
+// which are named like the command-line flags:
+//
+//	server:
+//	  port: 8080
+//	  pathPrefix: https://example.org/geo/
+//	  experimentalFormats: [kml, topojson]
+//	collections:
+//	  - name: castles
+//	    path: data/castles.geojson   # relative to the config file
+//	    title: Castles of Switzerland
+//	    group: heritage
+//	    license: https://opendatacommons.org/licenses/odbl/1-0/
+//	    style: {property: historic, categories: {ruins: {color: "#999"}}}
+//	  - name: lakes
+//	    path: postgres://db/gis?table=lakes
+//	    unlisted: true
+//
+// Flags given on the command line take precedence over the file, and
+// so do collections given by --collections and the files passed to
+// flags such as --collectionStyles. We read a subset of YAML that
+// covers block and flow mappings and sequences, plain and quoted
+// scalars, and literal and folded block scalars; anchors, tags and
+// multiple documents are not supported.
+
+// Config is the content of a configuration file.
+type Config struct {
+	Server      map[string]interface{} `json:"server"`
+	Collections []CollectionConfig     `json:"collections"`
+
+	commandLine map[string]bool // flags set on the command line
+}
+
+// CollectionConfig configures a single collection.
+type CollectionConfig struct {
+	Name        string              `json:"name"`
+	Path        string              `json:"path"`
+	Title       string              `json:"title"`
+	Description string              `json:"description"`
+	Group       string              `json:"group"`
+	License     string              `json:"license"`
+	Attribution string              `json:"attribution"`
+	Style       *CollectionStyle    `json:"style"`
+	Migrations  []PropertyMigration `json:"migrations"`
+
+	Protected            bool `json:"protected"`
+	Unlisted             bool `json:"unlisted"`
+	Private              bool `json:"private"`
+	SplitMultiGeometries bool `json:"splitMultiGeometries"`
+}
+
+// ReadConfig reads a configuration file. Relative collection paths
+// get resolved against the directory of the file.
+func ReadConfig(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	config, err := parseConfig(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	for i := range config.Collections {
+		c := &config.Collections[i]
+		if len(c.Path) > 0 && !strings.Contains(c.Path, "://") && !filepath.IsAbs(c.Path) {
+			c.Path = filepath.Join(filepath.Dir(path), c.Path)
+		}
+	}
+	return config, nil
+}
+
+func parseConfig(data []byte) (*Config, error) {
+	tree, err := parseYAML(data)
+	if err != nil {
+		return nil, err
+	}
+	if tree == nil {
+		return &Config{}, nil
+	}
+	if _, ok := tree.(map[string]interface{}); !ok {
+		return nil, fmt.Errorf("expected a mapping with server and collections")
+	}
+
+	// The tree consists of maps, slices and scalars that encoding/json
+	// understands, so we let it do the conversion to typed structs.
+	encoded, err := json.Marshal(tree)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(encoded))
+	decoder.DisallowUnknownFields()
+	decoder.UseNumber()
+	config := &Config{}
+	if err := decoder.Decode(config); err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool, len(config.Collections))
+	for i, c := range config.Collections {
+		if len(c.Name) == 0 || len(c.Path) == 0 {
+			return nil, fmt.Errorf("collection #%d needs a name and a path", i+1)
+		}
+		if seen[c.Name] {
+			return nil, fmt.Errorf("collection %s is defined more than once", c.Name)
+		}
+		seen[c.Name] = true
+		if c.Style != nil {
+			if err := checkCollectionStyle(c.Name, *c.Style); err != nil {
+				return nil, err
+			}
+		}
+		if err := checkCollectionMigrations(c.Name, c.Migrations); err != nil {
+			return nil, err
+		}
+	}
+	for name, value := range config.Server {
+		if _, err := configFlagValue(value); err != nil {
+			return nil, fmt.Errorf("server setting %s %v", name, err)
+		}
+	}
+	return config, nil
+}
+
+// ApplyFlags sets the flags for the server settings of the config,
+// unless they have been set on the command line. With strict, settings
+// for unknown flags are an error; otherwise they get ignored, so that
+// subcommands can share the configuration file of the server.
+func (c *Config) ApplyFlags(flags *flag.FlagSet, strict bool) error {
+	c.commandLine = make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) { c.commandLine[f.Name] = true })
+	for name, value := range c.Server {
+		if flags.Lookup(name) == nil || name == "config" {
+			if strict {
+				return fmt.Errorf("unknown server setting %s", name)
+			}
+			continue
+		}
+		if c.commandLine[name] {
+			continue
+		}
+		s, _ := configFlagValue(value)
+		if err := flags.Set(name, s); err != nil {
+			return fmt.Errorf("bad server setting %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// configFlagValue formats a setting like a command-line argument.
+// Lists become comma-separated.
+func configFlagValue(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case json.Number:
+		return v.String(), nil
+	case []interface{}:
+		items := make([]string, len(v))
+		for i, item := range v {
+			s, err := configFlagValue(item)
+			if err != nil || strings.Contains(s, ",") {
+				return "", fmt.Errorf("must be a list of scalars without commas")
+			}
+			items[i] = s
+		}
+		return strings.Join(items, ","), nil
+	}
+	return "", fmt.Errorf("must be a scalar or a list")
+}
+
+// MergeCollections adds the configured collections to the maps that
+// were built from command-line arguments. Entries already present win.
+// If the config defines any collections, the --collections flag is only
+// used if it was given on the command line; otherwise its default
+// would add example collections.
+func (c *Config) MergeCollections(sources map[string]string, groups map[string]string,
+	attributions map[string]CollectionAttribution, styles map[string]CollectionStyle,
+	migrations map[string][]PropertyMigration) {
+	if c == nil {
+		return
+	}
+	for _, coll := range c.Collections {
+		if _, ok := sources[coll.Name]; !ok {
+			sources[coll.Name] = coll.Path
+		}
+		if _, ok := groups[coll.Name]; !ok && len(coll.Group) > 0 {
+			groups[coll.Name] = coll.Group
+		}
+		if _, ok := attributions[coll.Name]; !ok && len(coll.License)+len(coll.Attribution) > 0 {
+			attributions[coll.Name] = CollectionAttribution{License: coll.License, Attribution: coll.Attribution}
+		}
+		if _, ok := styles[coll.Name]; !ok && coll.Style != nil {
+			styles[coll.Name] = *coll.Style
+		}
+		if _, ok := migrations[coll.Name]; !ok && len(coll.Migrations) > 0 {
+			migrations[coll.Name] = coll.Migrations
+		}
+	}
+}
+
+// DefinesCollections returns true if the config defines collections
+// and the --collections flag was not given on the command line.
+func (c *Config) DefinesCollections() bool {
+	return c != nil && len(c.Collections) > 0 && !c.commandLine["collections"]
+}
+
+// Descriptions returns the configured titles and descriptions, keyed
+// by collection name.
+func (c *Config) Descriptions() map[string]CollectionDescription {
+	result := make(map[string]CollectionDescription)
+	if c == nil {
+		return result
+	}
+	for _, coll := range c.Collections {
+		if len(coll.Title)+len(coll.Description) > 0 {
+			result[coll.Name] = CollectionDescription{Title: coll.Title, Description: coll.Description}
+		}
+	}
+	return result
+}
+
+// CollectionsWithOption returns the names of the configured
+// collections for which a boolean option, such as "unlisted", is true.
+func (c *Config) CollectionsWithOption(option string) []string {
+	var result []string
+	if c == nil {
+		return result
+	}
+	for _, coll := range c.Collections {
+		enabled := map[string]bool{
+			"protected":            coll.Protected,
+			"unlisted":             coll.Unlisted,
+			"private":              coll.Private,
+			"splitMultiGeometries": coll.SplitMultiGeometries,
+		}
+		if enabled[option] {
+			result = append(result, coll.Name)
+		}
+	}
+	return result
+}
+
+// yamlParser reads the YAML subset described above. Mappings become
+// map[string]interface{}, sequences []interface{}, and scalars
+// string, bool, json.Number or nil.
+type yamlParser struct {
+	lines []string
+	pos   int // index into lines
+}
+
+// yamlNumber matches the plain scalars we treat as numbers. This is
+// stricter than YAML, so that numbers are also valid JSON.
+var yamlNumber = regexp.MustCompile(`^-?(0|[1-9][0-9]*)(\.[0-9]+)?([eE][-+]?[0-9]+)?$`)
+
+func parseYAML(data []byte) (interface{}, error) {
+	text := strings.Replace(string(data), "\r\n", "\n", -1)
+	p := &yamlParser{lines: strings.Split(text, "\n")}
+	for i, line := range p.lines {
+		if strings.HasPrefix(strings.TrimLeft(line, " "), "\t") && len(strings.TrimSpace(line)) > 0 {
+			p.pos = i
+			return nil, p.errorf("tabs are not allowed for indentation")
+		}
+	}
+	if p.skipBlank() && strings.TrimRight(p.lines[p.pos], " ") == "---" {
+		p.pos++
+	}
+	if !p.skipBlank() {
+		return nil, nil
+	}
+	indent, _ := p.current()
+	value, err := p.block(indent)
+	if err != nil {
+		return nil, err
+	}
+	if p.skipBlank() {
+		return nil, p.errorf("unexpected indentation")
+	}
+	return value, nil
+}
+
+func (p *yamlParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("line %d: %s", p.pos+1, fmt.Sprintf(format, args...))
+}
+
+// skipBlank advances past empty and comment lines, returning false
+// at the end of the input.
+func (p *yamlParser) skipBlank() bool {
+	for ; p.pos < len(p.lines); p.pos++ {
+		if s := stripYAMLComment(p.lines[p.pos]); len(strings.TrimSpace(s)) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// current returns the indentation and content of the current line.
+func (p *yamlParser) current() (int, string) {
+	line := stripYAMLComment(p.lines[p.pos])
+	content := strings.TrimLeft(line, " ")
+	return len(line) - len(content), strings.TrimRight(content, " \t")
+}
+
+// block parses the mapping or sequence starting at the current line,
+// whose entries are indented by indent spaces.
+func (p *yamlParser) block(indent int) (interface{}, error) {
+	_, content := p.current()
+	if content == "-" || strings.HasPrefix(content, "- ") {
+		return p.sequence(indent)
+	}
+	if _, _, ok := splitYAMLKey(content); ok {
+		return p.mapping(indent)
+	}
+	value, err := parseYAMLFlow(content)
+	if err != nil {
+		return nil, p.errorf("%v", err)
+	}
+	p.pos++
+	return value, nil
+}
+
+func (p *yamlParser) sequence(indent int) (interface{}, error) {
+	result := []interface{}{}
+	for p.skipBlank() {
+		ind, content := p.current()
+		isEntry := content == "-" || strings.HasPrefix(content, "- ")
+		if ind < indent || (ind == indent && !isEntry) {
+			break
+		}
+		if ind > indent {
+			return nil, p.errorf("expected sequence entry")
+		}
+		rest := strings.TrimLeft(content[1:], " ")
+		if len(rest) == 0 {
+			p.pos++
+			item, err := p.nested(indent)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, item)
+			continue
+		}
+
+		// An entry like "- name: castles" starts a mapping whose keys
+		// are aligned with "name". We parse it by blanking out the dash.
+		itemIndent := ind + len(content) - len(rest)
+		p.lines[p.pos] = strings.Repeat(" ", itemIndent) + rest
+		item, err := p.block(itemIndent)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, item)
+	}
+	return result, nil
+}
+
+func (p *yamlParser) mapping(indent int) (interface{}, error) {
+	result := make(map[string]interface{})
+	for p.skipBlank() {
+		ind, content := p.current()
+		if ind < indent {
+			break
+		}
+		key, rest, ok := splitYAMLKey(content)
+		if ind > indent || !ok {
+			return nil, p.errorf("expected key: value")
+		}
+		if _, exists := result[key]; exists {
+			return nil, p.errorf("duplicate key %s", key)
+		}
+
+		var value interface{}
+		var err error
+		switch {
+		case len(rest) == 0:
+			p.pos++
+			value, err = p.nested(indent)
+		case rest[0] == '|' || rest[0] == '>':
+			value, err = p.blockScalar(indent, rest)
+		default:
+			if value, err = parseYAMLFlow(rest); err != nil {
+				err = p.errorf("%v", err)
+			}
+			p.pos++
+		}
+		if err != nil {
+			return nil, err
+		}
+		result[key] = value
+	}
+	return result, nil
+}
+
+// nested parses the value of a mapping key or sequence entry that
+// starts on the next line. Sequences may be indented like the key.
+func (p *yamlParser) nested(indent int) (interface{}, error) {
+	if !p.skipBlank() {
+		return nil, nil
+	}
+	ind, content := p.current()
+	isSequence := content == "-" || strings.HasPrefix(content, "- ")
+	if ind > indent || (ind == indent && isSequence) {
+		return p.block(ind)
+	}
+	return nil, nil
+}
+
+// blockScalar parses a literal (|) or folded (>) block scalar, with
+// an optional "-" to strip the final line break.
+func (p *yamlParser) blockScalar(indent int, header string) (interface{}, error) {
+	if len(header) > 2 || (len(header) == 2 && header[1] != '-') {
+		return nil, p.errorf("unsupported block scalar header %s", header)
+	}
+	p.pos++
+	var lines []string
+	blockIndent := -1
+	for ; p.pos < len(p.lines); p.pos++ {
+		line := strings.TrimRight(p.lines[p.pos], " \t")
+		content := strings.TrimLeft(line, " ")
+		ind := len(line) - len(content)
+		if len(content) == 0 {
+			lines = append(lines, "")
+			continue
+		}
+		if blockIndent < 0 {
+			blockIndent = ind
+		}
+		if ind <= indent || ind < blockIndent {
+			break
+		}
+		lines = append(lines, line[blockIndent:])
+	}
+	for len(lines) > 0 && len(lines[len(lines)-1]) == 0 {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) == 0 {
+		return "", nil
+	}
+
+	var buf strings.Builder
+	for i, line := range lines {
+		// Folded scalars join lines with spaces; empty lines
+		// become line breaks.
+		switch {
+		case i == 0:
+		case header[0] == '|' || len(line) == 0:
+			buf.WriteByte('\n')
+		case len(lines[i-1]) > 0:
+			buf.WriteByte(' ')
+		}
+		buf.WriteString(line)
+	}
+	if !strings.HasSuffix(header, "-") {
+		buf.WriteByte('\n')
+	}
+	return buf.String(), nil
+}
+
+// stripYAMLComment removes a comment from a line. A comment starts
+// with # at the beginning of the line or after a space, unless quoted.
+func stripYAMLComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else if c == '\\' && quote == '"' {
+				i++
+			}
+		case c == '"' || c == '\'':
+			if i == 0 || strings.IndexByte(" [{,:", line[i-1]) >= 0 {
+				quote = c
+			}
+		case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// splitYAMLKey splits "key: value" into key and value. The value may
+// be empty if it starts on the next line.
+func splitYAMLKey(content string) (string, string, bool) {
+	if len(content) > 0 && (content[0] == '"' || content[0] == '\'') {
+		end := yamlQuoteEnd(content)
+		if end < 0 || end+1 >= len(content) || content[end+1] != ':' {
+			return "", "", false
+		}
+		key, err := parseYAMLScalar(content[:end+1])
+		if err != nil {
+			return "", "", false
+		}
+		rest := content[end+2:]
+		if len(rest) > 0 && rest[0] != ' ' {
+			return "", "", false
+		}
+		return key.(string), strings.TrimSpace(rest), true
+	}
+	if len(content) > 0 && strings.IndexByte("[{", content[0]) >= 0 {
+		return "", "", false
+	}
+	for i := 0; i < len(content); i++ {
+		if content[i] == ':' && (i+1 == len(content) || content[i+1] == ' ') {
+			return strings.TrimSpace(content[:i]), strings.TrimSpace(content[i+1:]), i > 0
+		}
+	}
+	return "", "", false
+}
+
+// yamlQuoteEnd returns the index of the quote that closes the quoted
+// scalar at the start of s, or -1 if it is not closed.
+func yamlQuoteEnd(s string) int {
+	quote := s[0]
+	for i := 1; i < len(s); i++ {
+		switch {
+		case quote == '"' && s[i] == '\\':
+			i++
+		case s[i] == quote:
+			if quote == '\'' && i+1 < len(s) && s[i+1] == '\'' {
+				i++ // '' is an escaped quote
+				continue
+			}
+			return i
+		}
+	}
+	return -1
+}
+
+// parseYAMLFlow parses a value written on a single line, which may be
+// a flow sequence like [a, b] or a flow mapping like {a: 1}.
+func parseYAMLFlow(s string) (interface{}, error) {
+	value, rest, err := parseYAMLFlowValue(s, false)
+	if err != nil {
+		return nil, err
+	}
+	if len(strings.TrimSpace(rest)) > 0 {
+		return nil, fmt.Errorf("unexpected %s", rest)
+	}
+	return value, nil
+}
+
+func parseYAMLFlowValue(s string, inFlow bool) (interface{}, string, error) {
+	s = strings.TrimLeft(s, " ")
+	if len(s) == 0 {
+		return nil, s, nil
+	}
+	switch s[0] {
+	case '[':
+		result := []interface{}{}
+		s = strings.TrimLeft(s[1:], " ")
+		for len(s) > 0 && s[0] != ']' {
+			item, rest, err := parseYAMLFlowValue(s, true)
+			if err != nil {
+				return nil, "", err
+			}
+			result = append(result, item)
+			if s = strings.TrimLeft(rest, " "); len(s) > 0 && s[0] == ',' {
+				s = strings.TrimLeft(s[1:], " ")
+			}
+		}
+		if len(s) == 0 {
+			return nil, "", fmt.Errorf("unterminated flow sequence")
+		}
+		return result, s[1:], nil
+
+	case '{':
+		result := make(map[string]interface{})
+		s = strings.TrimLeft(s[1:], " ")
+		for len(s) > 0 && s[0] != '}' {
+			k, rest, err := parseYAMLFlowValue(s, true)
+			if err != nil {
+				return nil, "", err
+			}
+			key, ok := k.(string)
+			if rest = strings.TrimLeft(rest, " "); !ok || len(rest) == 0 || rest[0] != ':' {
+				return nil, "", fmt.Errorf("expected key: value in flow mapping")
+			}
+			value, rest, err := parseYAMLFlowValue(rest[1:], true)
+			if err != nil {
+				return nil, "", err
+			}
+			result[key] = value
+			if s = strings.TrimLeft(rest, " "); len(s) > 0 && s[0] == ',' {
+				s = strings.TrimLeft(s[1:], " ")
+			}
+		}
+		if len(s) == 0 {
+			return nil, "", fmt.Errorf("unterminated flow mapping")
+		}
+		return result, s[1:], nil
+
+	case '"', '\'':
+		end := yamlQuoteEnd(s)
+		if end < 0 {
+			return nil, "", fmt.Errorf("unterminated quoted string")
+		}
+		value, err := parseYAMLScalar(s[:end+1])
+		return value, s[end+1:], err
+	}
+
+	end := len(s)
+	if inFlow {
+		// Plain scalars in flow collections end at indicators; a colon
+		// only counts when followed by a space, as in URLs.
+		for i := 0; i < len(s); i++ {
+			if s[i] == ',' || s[i] == ']' || s[i] == '}' || (s[i] == ':' && (i+1 == len(s) || s[i+1] == ' ')) {
+				end = i
+				break
+			}
+		}
+	}
+	value, err := parseYAMLScalar(strings.TrimSpace(s[:end]))
+	return value, s[end:], err
+}
+
+// parseYAMLScalar interprets a scalar. Quoted scalars are strings;
+// plain ones may also be null, booleans or numbers.
+func parseYAMLScalar(s string) (interface{}, error) {
+	if len(s) >= 2 && s[0] == '\'' && s[len(s)-1] == '\'' {
+		return strings.Replace(s[1:len(s)-1], "''", "'", -1), nil
+	}
+	if len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' {
+		value, err := strconv.Unquote(s)
+		if err != nil {
+			return nil, fmt.Errorf("malformed quoted string %s", s)
+		}
+		return value, nil
+	}
+	switch s {
+	case "", "~", "null", "Null", "NULL":
+		return nil, nil
+	case "true", "True", "TRUE":
+		return true, nil
+	case "false", "False", "FALSE":
+		return false, nil
+	}
+	if yamlNumber.MatchString(s) {
+		return json.Number(s), nil
+	}
+	if strings.IndexByte("&*!%@`|>", s[0]) >= 0 {
+		return nil, fmt.Errorf("unsupported YAML syntax %s", s)
+	}
+	return s, nil
+}
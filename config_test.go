@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseYAML(t *testing.T) {
+	for _, tc := range []struct{ yaml, expected string }{
+		{"", `null`},
+		{"# just a comment\n", `null`},
+		{"---\na: 1\n", `{"a":1}`},
+		{"a: 1\nb: -2.5e3\nc: true\nd: null\ne: ~\nf:\n", `{"a":1,"b":-2.5e3,"c":true,"d":null,"e":null,"f":null}`},
+		{"a: 01\nb: 1.\nc: yes\nd: 'true'\n", `{"a":"01","b":"1.","c":"yes","d":"true"}`},
+		{"url: https://example.org/a#b  # comment\n", `{"url":"https://example.org/a#b"}`},
+		{"color: \"#1f78b4\"\nname: 'Zurich''s lakes'\n", `{"color":"#1f78b4","name":"Zurich's lakes"}`},
+		{"text: \"line\\nbreak \\u00e9\"\n", `{"text":"line\nbreak é"}`},
+		{"\"a: b\": c\n", `{"a: b":"c"}`},
+		{"a:\n  b:\n    c: 1\n  d: 2\n", `{"a":{"b":{"c":1},"d":2}}`},
+		{"- 1\n- two\n-\n  - 3\n", `[1,"two",[3]]`},
+		{"list:\n- a\n- b\nnext: c\n", `{"list":["a","b"],"next":"c"}`},
+		{"list:\n  - name: a\n    path: x\n  -   name: b\n      path: y\n", `{"list":[{"name":"a","path":"x"},{"name":"b","path":"y"}]}`},
+		{"a: [x, 1, 'y, z', [], {}]\n", `{"a":["x",1,"y, z",[],{}]}`},
+		{"a: {b: [1, 2], c: {d: https://x.org/}}\n", `{"a":{"b":[1,2],"c":{"d":"https://x.org/"}}}`},
+		{"a: |\n  one\n   two\n\n  three\nb: x\n", `{"a":"one\n two\n\nthree\n","b":"x"}`},
+		{"a: >-\n  one\n  two\n\n  three\n", `{"a":"one two\nthree"}`},
+		{"a: |\nb: x\n", `{"a":"","b":"x"}`},
+	} {
+		tree, err := parseYAML([]byte(tc.yaml))
+		if err != nil {
+			t.Errorf("parsing %q: %v", tc.yaml, err)
+			continue
+		}
+		got, _ := json.Marshal(tree)
+		if string(got) != tc.expected {
+			t.Errorf("parsing %q: expected %s, got %s", tc.yaml, tc.expected, got)
+		}
+	}
+}
+
+func TestParseYAML_Errors(t *testing.T) {
+	for _, tc := range []struct{ yaml, expected string }{
+		{"a: 1\n  b: 2\n", "line 2: expected key: value"},
+		{"a: 1\na: 2\n", "line 2: duplicate key a"},
+		{"a: [1, 2\n", "line 1: unterminated flow sequence"},
+		{"a: \"x\n", "line 1: unterminated quoted string"},
+		{"a: &anchor x\n", "line 1: unsupported YAML syntax &anchor x"},
+		{"a: |2\n  x\n", "line 1: unsupported block scalar header |2"},
+		{"- a\n - b\n", "line 2: expected sequence entry"},
+		{"a:\n\tb: 1\n", "line 2: tabs are not allowed for indentation"},
+	} {
+		_, err := parseYAML([]byte(tc.yaml))
+		if err == nil || err.Error() != tc.expected {
+			t.Errorf("parsing %q: expected error %q, got %v", tc.yaml, tc.expected, err)
+		}
+	}
+}
+
+func TestReadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "miniwfs-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "miniwfs.yaml")
+	ioutil.WriteFile(path, []byte(`
+# Test configuration.
+server:
+  port: 9090
+  pathPrefix: https://example.org/geo/
+  experimentalFormats: [kml, topojson]
+  tombstones: true
+collections:
+  - name: castles
+    path: castles.geojson
+    title: Castles
+    description: >
+      Castles, ruins and palaces
+      of Switzerland.
+    group: heritage
+    license: https://opendatacommons.org/licenses/odbl/1-0/
+    style: {property: historic, categories: {ruins: {color: "#999", symbol: triangle}}}
+    migrations:
+      - {op: rename, property: ele, to: elevation}
+    unlisted: true
+  - name: lakes
+    path: /data/lakes.geojson
+    splitMultiGeometries: true
+  - name: rivers
+    path: postgres://db/gis?table=rivers
+`), 0644)
+	config, err := ReadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	port := flags.Int("port", 8080, "")
+	prefix := flags.String("pathPrefix", "", "")
+	formats := flags.String("experimentalFormats", "", "")
+	tombstones := flags.Bool("tombstones", false, "")
+	collections := flags.String("collections", "castles=path/to/castles.geojson", "")
+	if err := flags.Parse([]string{"--pathPrefix=http://localhost/"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := config.ApplyFlags(flags, true); err != nil {
+		t.Fatal(err)
+	}
+	if *port != 9090 || *formats != "kml,topojson" || !*tombstones {
+		t.Errorf("expected server settings from config, got port=%d experimentalFormats=%s tombstones=%v", *port, *formats, *tombstones)
+	}
+	if *prefix != "http://localhost/" {
+		t.Errorf("expected command line to take precedence, got pathPrefix=%s", *prefix)
+	}
+	if !config.DefinesCollections() || *collections != "castles=path/to/castles.geojson" {
+		t.Errorf("expected config to define collections, leaving the flag alone")
+	}
+
+	sources := map[string]string{"rivers": "rivers.geojson"}
+	groups := map[string]string{}
+	attributions := map[string]CollectionAttribution{}
+	styles := map[string]CollectionStyle{}
+	migrations := map[string][]PropertyMigration{}
+	config.MergeCollections(sources, groups, attributions, styles, migrations)
+	expectedSources := map[string]string{
+		"castles": filepath.Join(dir, "castles.geojson"),
+		"lakes":   "/data/lakes.geojson",
+		"rivers":  "rivers.geojson",
+	}
+	if !reflect.DeepEqual(sources, expectedSources) {
+		t.Errorf("expected sources %v, got %v", expectedSources, sources)
+	}
+	if groups["castles"] != "heritage" || len(groups) != 1 {
+		t.Errorf("expected castles in group heritage, got %v", groups)
+	}
+	if a := attributions["castles"]; a.License != "https://opendatacommons.org/licenses/odbl/1-0/" || len(attributions) != 1 {
+		t.Errorf("expected license for castles, got %v", attributions)
+	}
+	if s := styles["castles"]; s.Property != "historic" || s.Categories["ruins"].Color != "#999" {
+		t.Errorf("expected style for castles, got %v", styles)
+	}
+	if m := migrations["castles"]; len(m) != 1 || m[0].To != "elevation" {
+		t.Errorf("expected migration for castles, got %v", migrations)
+	}
+	if d := config.Descriptions()["castles"]; d.Title != "Castles" || d.Description != "Castles, ruins and palaces of Switzerland.\n" {
+		t.Errorf("expected title and description for castles, got %v", d)
+	}
+	if got := config.CollectionsWithOption("unlisted"); !reflect.DeepEqual(got, []string{"castles"}) {
+		t.Errorf("expected castles to be unlisted, got %v", got)
+	}
+	if got := config.CollectionsWithOption("splitMultiGeometries"); !reflect.DeepEqual(got, []string{"lakes"}) {
+		t.Errorf("expected lakes to get split, got %v", got)
+	}
+}
+
+func TestReadConfig_Errors(t *testing.T) {
+	for _, tc := range []struct{ yaml, expected string }{
+		{"- a\n", "expected a mapping with server and collections"},
+		{"colections: []\n", `unknown field "colections"`},
+		{"collections:\n  - name: a\n", "collection #1 needs a name and a path"},
+		{"collections:\n  - {name: a, path: x}\n  - {name: a, path: y}\n", "collection a is defined more than once"},
+		{"collections:\n  - {name: a, path: x, style: {color: red}}\n", `collection a has bad color "red"`},
+		{"collections:\n  - {name: a, path: x, title: 2021}\n", "cannot unmarshal number"},
+		{"server:\n  port: {a: 1}\n", "server setting port must be a scalar or a list"},
+	} {
+		_, err := parseConfig([]byte(tc.yaml))
+		if err == nil || !strings.Contains(err.Error(), tc.expected) {
+			t.Errorf("parsing %q: expected error containing %q, got %v", tc.yaml, tc.expected, err)
+		}
+	}
+
+	config, _ := parseConfig([]byte("server:\n  prot: 9090\n"))
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags.Int("port", 8080, "")
+	if err := config.ApplyFlags(flags, true); err == nil || err.Error() != "unknown server setting prot" {
+		t.Errorf("expected error for unknown setting, got %v", err)
+	}
+	if err := config.ApplyFlags(flags, false); err != nil {
+		t.Errorf("expected unknown setting to be ignored, got %v", err)
+	}
+}
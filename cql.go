@@ -0,0 +1,756 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/golang/geo/s2"
+	"github.com/paulmach/go.geojson"
+)
+
+// CQLFilter is a filter expression in CQL2 Text, as specified by
+// OGC API Features Part 3, such as
+//
+//	historic = 'castle' AND NOT name LIKE 'Castello%'
+//	S_INTERSECTS(geometry, BBOX(10.5, 45.5, 11.5, 46.5))
+//
+// We support the comparison operators =, <>, <, <=, > and >=, LIKE,
+// IS NULL, AND, OR and NOT, and the spatial predicates S_INTERSECTS
+// and S_DISJOINT with POINT, LINESTRING, POLYGON and BBOX literals.
+// Spatial predicates compare bounding boxes, which is exact for points
+// and boxes, but approximate for other geometries.
+//
+// The same expressions can also be given in CQL2 JSON, such as
+// {"op": "=", "args": [{"property": "historic"}, "castle"]}.
+type CQLFilter struct {
+	Text string
+	Lang string // "cql2-text" or "cql2-json"
+	expr cqlExpr
+}
+
+// cqlFeature is what filter expressions get evaluated on.
+type cqlFeature struct {
+	properties map[string]interface{}
+	bounds     s2.Rect
+}
+
+type cqlExpr interface {
+	eval(f *cqlFeature) bool
+}
+
+type cqlOperand interface {
+	value(f *cqlFeature) interface{}
+}
+
+type cqlProperty string
+
+func (p cqlProperty) value(f *cqlFeature) interface{} {
+	return f.properties[string(p)]
+}
+
+type cqlLiteral struct {
+	v interface{}
+}
+
+func (l cqlLiteral) value(f *cqlFeature) interface{} {
+	return l.v
+}
+
+type cqlAnd struct{ a, b cqlExpr }
+type cqlOr struct{ a, b cqlExpr }
+type cqlNot struct{ a cqlExpr }
+
+func (e cqlAnd) eval(f *cqlFeature) bool { return e.a.eval(f) && e.b.eval(f) }
+func (e cqlOr) eval(f *cqlFeature) bool  { return e.a.eval(f) || e.b.eval(f) }
+func (e cqlNot) eval(f *cqlFeature) bool { return !e.a.eval(f) }
+
+type cqlComparison struct {
+	op   string
+	a, b cqlOperand
+}
+
+func (e cqlComparison) eval(f *cqlFeature) bool {
+	c, ok := compareCQLValues(e.a.value(f), e.b.value(f))
+	if !ok {
+		return false
+	}
+	switch e.op {
+	case "=":
+		return c == 0
+	case "<>":
+		return c != 0
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	case ">=":
+		return c >= 0
+	}
+	return false
+}
+
+// compareCQLValues compares two values of the same type. Values of
+// different types, and null values, cannot be compared.
+func compareCQLValues(a, b interface{}) (int, bool) {
+	switch x := a.(type) {
+	case float64:
+		if y, ok := b.(float64); ok {
+			if x < y {
+				return -1, true
+			} else if x > y {
+				return 1, true
+			}
+			return 0, true
+		}
+	case string:
+		if y, ok := b.(string); ok {
+			return strings.Compare(x, y), true
+		}
+	case bool:
+		if y, ok := b.(bool); ok {
+			if x == y {
+				return 0, true
+			} else if y {
+				return -1, true
+			}
+			return 1, true
+		}
+	}
+	return 0, false
+}
+
+type cqlLike struct {
+	a       cqlOperand
+	pattern *regexp.Regexp
+}
+
+func (e cqlLike) eval(f *cqlFeature) bool {
+	s, ok := e.a.value(f).(string)
+	return ok && e.pattern.MatchString(s)
+}
+
+type cqlIsNull struct {
+	a cqlOperand
+}
+
+func (e cqlIsNull) eval(f *cqlFeature) bool {
+	return e.a.value(f) == nil
+}
+
+type cqlSpatial struct {
+	op     string
+	bounds s2.Rect
+}
+
+func (e cqlSpatial) eval(f *cqlFeature) bool {
+	if f.bounds.IsEmpty() {
+		return false // features without geometry match no spatial predicate
+	}
+	intersects := f.bounds.Intersects(e.bounds)
+	if e.op == "S_DISJOINT" {
+		return !intersects
+	}
+	return intersects
+}
+
+// ParseCQL parses a filter expression in CQL2 Text.
+func ParseCQL(text string) (*CQLFilter, error) {
+	tokens, err := lexCQL(text)
+	if err != nil {
+		return nil, err
+	}
+	p := &cqlParser{tokens: tokens}
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != cqlEOF {
+		return nil, p.errorf(t, "unexpected %q", t.text)
+	}
+	return &CQLFilter{Text: text, Lang: "cql2-text", expr: expr}, nil
+}
+
+// ParseCQLJSON parses a filter expression in CQL2 JSON.
+func ParseCQLJSON(text string) (*CQLFilter, error) {
+	var node interface{}
+	if err := json.Unmarshal([]byte(text), &node); err != nil {
+		return nil, fmt.Errorf("cql2-json: %v", err)
+	}
+	expr, err := parseCQLJSONExpr(node)
+	if err != nil {
+		return nil, err
+	}
+	return &CQLFilter{Text: text, Lang: "cql2-json", expr: expr}, nil
+}
+
+// Matches returns true if a feature passes the filter.
+func (filter *CQLFilter) Matches(properties map[string]interface{}, bounds s2.Rect) bool {
+	return filter.expr.eval(&cqlFeature{properties: properties, bounds: bounds})
+}
+
+const (
+	cqlEOF = iota
+	cqlIdent
+	cqlString
+	cqlNumber
+	cqlPunct
+)
+
+type cqlToken struct {
+	kind int
+	text string
+	pos  int
+}
+
+func lexCQL(text string) ([]cqlToken, error) {
+	var tokens []cqlToken
+	i := 0
+	for i < len(text) {
+		c := text[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+
+		case c == '\'':
+			var s strings.Builder
+			start := i
+			i++
+			for {
+				if i >= len(text) {
+					return nil, fmt.Errorf("cql2: unterminated string at position %d", start)
+				}
+				if text[i] == '\'' {
+					if i+1 < len(text) && text[i+1] == '\'' {
+						s.WriteByte('\'')
+						i += 2
+						continue
+					}
+					i++
+					break
+				}
+				s.WriteByte(text[i])
+				i++
+			}
+			tokens = append(tokens, cqlToken{cqlString, s.String(), start})
+
+		case c == '"':
+			end := strings.IndexByte(text[i+1:], '"')
+			if end < 0 {
+				return nil, fmt.Errorf("cql2: unterminated identifier at position %d", i)
+			}
+			tokens = append(tokens, cqlToken{cqlIdent, text[i+1 : i+1+end], i})
+			i += end + 2
+
+		case c == '-' || c == '.' || (c >= '0' && c <= '9'):
+			start := i
+			i++
+			for i < len(text) && strings.IndexByte("0123456789.eE", text[i]) >= 0 ||
+				i < len(text) && (text[i] == '-' || text[i] == '+') && (text[i-1] == 'e' || text[i-1] == 'E') {
+				i++
+			}
+			tokens = append(tokens, cqlToken{cqlNumber, text[start:i], start})
+
+		case isCQLIdentChar(c, true):
+			start := i
+			for i < len(text) && isCQLIdentChar(text[i], false) {
+				i++
+			}
+			tokens = append(tokens, cqlToken{cqlIdent, text[start:i], start})
+
+		case c == '<' || c == '>':
+			start := i
+			i++
+			if i < len(text) && (text[i] == '=' || (c == '<' && text[i] == '>')) {
+				i++
+			}
+			tokens = append(tokens, cqlToken{cqlPunct, text[start:i], start})
+
+		case c == '=' || c == '(' || c == ')' || c == ',':
+			tokens = append(tokens, cqlToken{cqlPunct, text[i : i+1], i})
+			i++
+
+		default:
+			return nil, fmt.Errorf("cql2: unexpected character %q at position %d", c, i)
+		}
+	}
+	return append(tokens, cqlToken{cqlEOF, "", len(text)}), nil
+}
+
+func isCQLIdentChar(c byte, first bool) bool {
+	if c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c >= 0x80 {
+		return true
+	}
+	return !first && ((c >= '0' && c <= '9') || c == ':' || c == '.')
+}
+
+type cqlParser struct {
+	tokens []cqlToken
+	pos    int
+}
+
+func (p *cqlParser) peek() cqlToken {
+	return p.tokens[p.pos]
+}
+
+func (p *cqlParser) next() cqlToken {
+	t := p.tokens[p.pos]
+	if t.kind != cqlEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *cqlParser) errorf(t cqlToken, format string, args ...interface{}) error {
+	return fmt.Errorf("cql2: "+format+" at position %d", append(args, t.pos)...)
+}
+
+// keyword consumes the next token if it is the given keyword.
+func (p *cqlParser) keyword(kw string) bool {
+	if t := p.peek(); t.kind == cqlIdent && strings.EqualFold(t.text, kw) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+// punct consumes the next token if it is the given punctuation.
+func (p *cqlParser) punct(s string) bool {
+	if t := p.peek(); t.kind == cqlPunct && t.text == s {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *cqlParser) expect(s string) error {
+	if !p.punct(s) {
+		t := p.peek()
+		return p.errorf(t, "expected %q, got %q", s, t.text)
+	}
+	return nil
+}
+
+func (p *cqlParser) parseOr() (cqlExpr, error) {
+	a, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("OR") {
+		b, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		a = cqlOr{a, b}
+	}
+	return a, nil
+}
+
+func (p *cqlParser) parseAnd() (cqlExpr, error) {
+	a, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("AND") {
+		b, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		a = cqlAnd{a, b}
+	}
+	return a, nil
+}
+
+func (p *cqlParser) parseNot() (cqlExpr, error) {
+	if p.keyword("NOT") {
+		a, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return cqlNot{a}, nil
+	}
+	return p.parsePredicate()
+}
+
+func (p *cqlParser) parsePredicate() (cqlExpr, error) {
+	if p.punct("(") {
+		e, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		return e, p.expect(")")
+	}
+
+	if t := p.peek(); t.kind == cqlIdent {
+		op := strings.ToUpper(t.text)
+		if op == "S_INTERSECTS" || op == "S_DISJOINT" {
+			p.next()
+			return p.parseSpatial(op)
+		}
+	}
+
+	a, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.keyword("IS") {
+		negate := p.keyword("NOT")
+		if !p.keyword("NULL") {
+			t := p.peek()
+			return nil, p.errorf(t, "expected NULL, got %q", t.text)
+		}
+		if negate {
+			return cqlNot{cqlIsNull{a}}, nil
+		}
+		return cqlIsNull{a}, nil
+	}
+
+	negate := p.keyword("NOT")
+	if p.keyword("LIKE") {
+		t := p.next()
+		if t.kind != cqlString {
+			return nil, p.errorf(t, "expected pattern string, got %q", t.text)
+		}
+		var e cqlExpr = cqlLike{a, likePattern(t.text)}
+		if negate {
+			e = cqlNot{e}
+		}
+		return e, nil
+	} else if negate {
+		t := p.peek()
+		return nil, p.errorf(t, "expected LIKE, got %q", t.text)
+	}
+
+	t := p.next()
+	switch t.text {
+	case "=", "<>", "<", "<=", ">", ">=":
+		if t.kind != cqlPunct {
+			break
+		}
+		b, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		return cqlComparison{t.text, a, b}, nil
+	}
+	return nil, p.errorf(t, "expected comparison, got %q", t.text)
+}
+
+func (p *cqlParser) parseOperand() (cqlOperand, error) {
+	t := p.next()
+	switch t.kind {
+	case cqlString:
+		return cqlLiteral{t.text}, nil
+	case cqlNumber:
+		n, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, p.errorf(t, "malformed number %q", t.text)
+		}
+		return cqlLiteral{n}, nil
+	case cqlIdent:
+		if strings.EqualFold(t.text, "TRUE") {
+			return cqlLiteral{true}, nil
+		} else if strings.EqualFold(t.text, "FALSE") {
+			return cqlLiteral{false}, nil
+		}
+		return cqlProperty(t.text), nil
+	}
+	return nil, p.errorf(t, "expected property or literal, got %q", t.text)
+}
+
+// parseSpatial parses the arguments of a spatial predicate, which
+// compares the feature geometry to a geometry literal, in any order.
+func (p *cqlParser) parseSpatial(op string) (cqlExpr, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	var bounds s2.Rect
+	var haveProperty, haveGeometry bool
+	for i := 0; i < 2; i++ {
+		if i > 0 {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+		t := p.peek()
+		if t.kind != cqlIdent {
+			return nil, p.errorf(t, "expected geometry, got %q", t.text)
+		}
+		if isCQLGeometryKeyword(t.text) && !haveGeometry {
+			var err error
+			if bounds, err = p.parseGeometry(); err != nil {
+				return nil, err
+			}
+			haveGeometry = true
+		} else if !haveProperty {
+			p.next()
+			haveProperty = true
+		} else {
+			return nil, p.errorf(t, "expected geometry literal, got %q", t.text)
+		}
+	}
+	if err := p.expect(")"); err != nil {
+		return nil, err
+	}
+	return cqlSpatial{op, bounds}, nil
+}
+
+func isCQLGeometryKeyword(s string) bool {
+	switch strings.ToUpper(s) {
+	case "POINT", "LINESTRING", "POLYGON", "BBOX":
+		return true
+	}
+	return false
+}
+
+// parseGeometry parses a geometry literal, returning its bounds.
+func (p *cqlParser) parseGeometry() (s2.Rect, error) {
+	t := p.next()
+	bounds := s2.EmptyRect()
+	if err := p.expect("("); err != nil {
+		return bounds, err
+	}
+
+	switch strings.ToUpper(t.text) {
+	case "BBOX":
+		var n []float64
+		for len(n) == 0 || p.punct(",") {
+			v, err := p.parseNumber()
+			if err != nil {
+				return bounds, err
+			}
+			n = append(n, v)
+		}
+		if len(n) != 4 && len(n) != 6 {
+			return bounds, p.errorf(t, "BBOX needs 4 or 6 numbers, got %d", len(n))
+		}
+		k := len(n) / 2
+		bounds = bounds.AddPoint(s2.LatLngFromDegrees(n[1], n[0]))
+		bounds = bounds.AddPoint(s2.LatLngFromDegrees(n[k+1], n[k]))
+
+	case "POINT":
+		if err := p.parseCoordinates(&bounds, 1); err != nil {
+			return bounds, err
+		}
+
+	case "LINESTRING":
+		if err := p.parseCoordinates(&bounds, -1); err != nil {
+			return bounds, err
+		}
+
+	case "POLYGON":
+		for i := 0; i == 0 || p.punct(","); i++ {
+			if err := p.expect("("); err != nil {
+				return bounds, err
+			}
+			if err := p.parseCoordinates(&bounds, -1); err != nil {
+				return bounds, err
+			}
+			if err := p.expect(")"); err != nil {
+				return bounds, err
+			}
+		}
+	}
+	return bounds, p.expect(")")
+}
+
+// parseCoordinates parses a comma-separated list of "lon lat"
+// positions, adding them to bounds. If max is positive, at most max
+// positions are accepted.
+func (p *cqlParser) parseCoordinates(bounds *s2.Rect, max int) error {
+	for n := 0; n == 0 || ((max < 0 || n < max) && p.punct(",")); n++ {
+		lon, err := p.parseNumber()
+		if err != nil {
+			return err
+		}
+		lat, err := p.parseNumber()
+		if err != nil {
+			return err
+		}
+		if t := p.peek(); t.kind == cqlNumber {
+			p.next() // ignore elevation
+		}
+		*bounds = bounds.AddPoint(s2.LatLngFromDegrees(lat, lon))
+	}
+	return nil
+}
+
+func (p *cqlParser) parseNumber() (float64, error) {
+	t := p.next()
+	if t.kind == cqlNumber {
+		if n, err := strconv.ParseFloat(t.text, 64); err == nil {
+			return n, nil
+		}
+	}
+	return 0, p.errorf(t, "expected number, got %q", t.text)
+}
+
+// likePattern converts a LIKE pattern into a regular expression.
+// The wildcard % matches any sequence of characters, and _ matches a
+// single character; a backslash escapes the following character.
+func likePattern(pattern string) *regexp.Regexp {
+	var re strings.Builder
+	re.WriteString("(?s)^")
+	escaped := false
+	for _, c := range pattern {
+		switch {
+		case escaped:
+			re.WriteString(regexp.QuoteMeta(string(c)))
+			escaped = false
+		case c == '\\':
+			escaped = true
+		case c == '%':
+			re.WriteString(".*")
+		case c == '_':
+			re.WriteString(".")
+		default:
+			re.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	re.WriteString("$")
+	return regexp.MustCompile(re.String())
+}
+
+func parseCQLJSONExpr(node interface{}) (cqlExpr, error) {
+	m, ok := node.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("cql2-json: expected expression, got %v", node)
+	}
+	op, _ := m["op"].(string)
+	args, _ := m["args"].([]interface{})
+	switch op = strings.ToLower(op); op {
+	case "and", "or":
+		if len(args) < 2 {
+			return nil, fmt.Errorf("cql2-json: %q needs at least two arguments", op)
+		}
+		var result cqlExpr
+		for _, arg := range args {
+			e, err := parseCQLJSONExpr(arg)
+			if err != nil {
+				return nil, err
+			}
+			if result == nil {
+				result = e
+			} else if op == "and" {
+				result = cqlAnd{result, e}
+			} else {
+				result = cqlOr{result, e}
+			}
+		}
+		return result, nil
+
+	case "not":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("cql2-json: \"not\" needs one argument")
+		}
+		e, err := parseCQLJSONExpr(args[0])
+		if err != nil {
+			return nil, err
+		}
+		return cqlNot{e}, nil
+
+	case "=", "<>", "<", "<=", ">", ">=":
+		if len(args) != 2 {
+			return nil, fmt.Errorf("cql2-json: %q needs two arguments", op)
+		}
+		a, err := parseCQLJSONOperand(args[0])
+		if err != nil {
+			return nil, err
+		}
+		b, err := parseCQLJSONOperand(args[1])
+		if err != nil {
+			return nil, err
+		}
+		return cqlComparison{op, a, b}, nil
+
+	case "like":
+		if len(args) != 2 {
+			return nil, fmt.Errorf("cql2-json: \"like\" needs two arguments")
+		}
+		a, err := parseCQLJSONOperand(args[0])
+		if err != nil {
+			return nil, err
+		}
+		pattern, ok := args[1].(string)
+		if !ok {
+			return nil, fmt.Errorf("cql2-json: \"like\" needs a pattern string, got %v", args[1])
+		}
+		return cqlLike{a, likePattern(pattern)}, nil
+
+	case "isnull":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("cql2-json: \"isNull\" needs one argument")
+		}
+		a, err := parseCQLJSONOperand(args[0])
+		if err != nil {
+			return nil, err
+		}
+		return cqlIsNull{a}, nil
+
+	case "s_intersects", "s_disjoint":
+		if len(args) != 2 {
+			return nil, fmt.Errorf("cql2-json: %q needs two arguments", op)
+		}
+		// The feature geometry and the literal can come in any order.
+		geom := args[1]
+		if m, ok := args[1].(map[string]interface{}); ok {
+			if _, isProperty := m["property"]; isProperty {
+				geom = args[0]
+			}
+		}
+		bounds, err := parseCQLJSONGeometry(geom)
+		if err != nil {
+			return nil, err
+		}
+		return cqlSpatial{strings.ToUpper(op), bounds}, nil
+	}
+	return nil, fmt.Errorf("cql2-json: unsupported operator %q", op)
+}
+
+func parseCQLJSONOperand(node interface{}) (cqlOperand, error) {
+	switch v := node.(type) {
+	case string, float64, bool:
+		return cqlLiteral{v}, nil
+	case map[string]interface{}:
+		if p, ok := v["property"].(string); ok {
+			return cqlProperty(p), nil
+		}
+	}
+	return nil, fmt.Errorf("cql2-json: expected property or literal, got %v", node)
+}
+
+// parseCQLJSONGeometry parses a bbox, such as {"bbox": [10, 45, 11, 46]},
+// or a GeoJSON geometry, returning its bounds.
+func parseCQLJSONGeometry(node interface{}) (s2.Rect, error) {
+	m, _ := node.(map[string]interface{})
+	if bbox, ok := m["bbox"].([]interface{}); ok && (len(bbox) == 4 || len(bbox) == 6) {
+		n := make([]float64, len(bbox))
+		for i, v := range bbox {
+			if n[i], ok = v.(float64); !ok {
+				return s2.EmptyRect(), fmt.Errorf("cql2-json: malformed bbox %v", bbox)
+			}
+		}
+		k := len(n) / 2
+		bounds := s2.RectFromLatLng(s2.LatLngFromDegrees(n[1], n[0]))
+		return bounds.AddPoint(s2.LatLngFromDegrees(n[k+1], n[k])), nil
+	}
+	if _, ok := m["type"].(string); ok {
+		encoded, err := json.Marshal(m)
+		if err != nil {
+			return s2.EmptyRect(), err
+		}
+		var g geojson.Geometry
+		if err := json.Unmarshal(encoded, &g); err != nil {
+			return s2.EmptyRect(), fmt.Errorf("cql2-json: %v", err)
+		}
+		if bounds := computeBounds(&g); !bounds.IsEmpty() {
+			return bounds, nil
+		}
+	}
+	return s2.EmptyRect(), fmt.Errorf("cql2-json: expected bbox or GeoJSON geometry, got %v", node)
+}
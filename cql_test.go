@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/golang/geo/s2"
+)
+
+func TestParseCQL(t *testing.T) {
+	properties := map[string]interface{}{
+		"historic": "castle",
+		"name":     "Castello Scaligero",
+		"name:de":  "Skaligerburg",
+		"ele":      68.0,
+		"ruins":    false,
+		"note":     "it's 100%",
+		"wikidata": nil,
+	}
+	bounds := s2.RectFromLatLng(s2.LatLngFromDegrees(45.6076336, 10.6848117)).
+		AddPoint(s2.LatLngFromDegrees(45.6076897, 10.6850828))
+
+	for _, tc := range []struct {
+		filter   string
+		expected bool
+	}{
+		{"historic = 'castle'", true},
+		{"historic <> 'castle'", false},
+		{"ele > 50", true},
+		{"ele >= 68 AND ele <= 68", true},
+		{"ele < -1.5e2", false},
+		{"ele = '68'", false},
+		{"ruins = FALSE", true},
+		{"\"name:de\" = 'Skaligerburg'", true},
+		{"name LIKE 'Castello%'", true},
+		{"name like 'castello%'", false},
+		{"name NOT LIKE '%Scal_gero'", false},
+		{"note LIKE 'it''s 100\\%'", true},
+		{"wikidata IS NULL", true},
+		{"nosuchproperty IS NOT NULL", false},
+		{"nosuchproperty = 'x'", false},
+		{"NOT historic = 'ruins' AND (ele < 10 OR ruins = false)", true},
+		{"historic = 'ruins' OR historic = 'castle' AND ele > 100", false},
+		{"S_INTERSECTS(geometry, BBOX(10, 45, 11, 46))", true},
+		{"S_INTERSECTS(BBOX(10, 45, 11, 46), geometry)", true},
+		{"S_INTERSECTS(geometry, POINT(10.6848117 45.6076336))", true},
+		{"S_INTERSECTS(geometry, POLYGON((8 47, 9 47, 9 48, 8 47)))", false},
+		{"S_DISJOINT(geometry, LINESTRING(8 47, 9 48))", true},
+	} {
+		filter, err := ParseCQL(tc.filter)
+		if err != nil {
+			t.Errorf("ParseCQL(%q) failed: %v", tc.filter, err)
+			continue
+		}
+		if got := filter.Matches(properties, bounds); got != tc.expected {
+			t.Errorf("%q: expected %v, got %v", tc.filter, tc.expected, got)
+		}
+	}
+}
+
+func TestParseCQL_Errors(t *testing.T) {
+	for _, filter := range []string{
+		"",
+		"historic",
+		"historic = ",
+		"historic = 'castle",
+		"historic == 'castle'",
+		"(historic = 'castle'",
+		"historic = 'castle' AND",
+		"name NOT 'x'",
+		"name LIKE 7",
+		"ele IS 7",
+		"S_INTERSECTS(geometry, BBOX(1, 2, 3))",
+		"S_INTERSECTS(geometry, geometry)",
+		"ele = 7 #",
+	} {
+		if _, err := ParseCQL(filter); err == nil {
+			t.Errorf("ParseCQL(%q): expected error", filter)
+		}
+	}
+}
+
+func TestParseCQLJSON(t *testing.T) {
+	properties := map[string]interface{}{"historic": "castle", "name": "Castello Scaligero", "ele": 68.0}
+	bounds := s2.RectFromLatLng(s2.LatLngFromDegrees(45.6076336, 10.6848117))
+	for _, tc := range []struct {
+		filter   string
+		expected bool
+	}{
+		{`{"op": "=", "args": [{"property": "historic"}, "castle"]}`, true},
+		{`{"op": "and", "args": [
+			{"op": ">", "args": [{"property": "ele"}, 50]},
+			{"op": "like", "args": [{"property": "name"}, "Castello%"]},
+			{"op": "not", "args": [{"op": "isNull", "args": [{"property": "name"}]}]}]}`, true},
+		{`{"op": "or", "args": [
+			{"op": "<>", "args": [{"property": "historic"}, "castle"]},
+			{"op": "isNull", "args": [{"property": "wikidata"}]}]}`, true},
+		{`{"op": "s_intersects", "args": [{"property": "geometry"}, {"bbox": [10, 45, 11, 46]}]}`, true},
+		{`{"op": "s_intersects", "args": [{"type": "Polygon", "coordinates": [[[8, 47], [9, 47], [9, 48], [8, 47]]]}, {"property": "geometry"}]}`, false},
+		{`{"op": "s_disjoint", "args": [{"property": "geometry"}, {"type": "Point", "coordinates": [8, 47]}]}`, true},
+	} {
+		filter, err := ParseCQLJSON(tc.filter)
+		if err != nil {
+			t.Errorf("ParseCQLJSON(%s) failed: %v", tc.filter, err)
+			continue
+		}
+		if got := filter.Matches(properties, bounds); got != tc.expected {
+			t.Errorf("%s: expected %v, got %v", tc.filter, tc.expected, got)
+		}
+	}
+
+	for _, filter := range []string{
+		`"historic"`,
+		`{"op": "=", "args": [{"property": "historic"}]}`,
+		`{"op": "between", "args": [{"property": "ele"}, 1, 2]}`,
+		`{"op": "like", "args": [{"property": "name"}, 7]}`,
+		`{"op": "s_intersects", "args": [{"property": "geometry"}, "POINT(8 47)"]}`,
+		`{"op": "and", "args": [{"op": "=", "args": [{"property": "a"}, 1]}]}`,
+	} {
+		if _, err := ParseCQLJSON(filter); err == nil {
+			t.Errorf("ParseCQLJSON(%s): expected error", filter)
+		}
+	}
+}
+
+func TestCollection_CQLFilter(t *testing.T) {
+	index, s := makeServer(t)
+	defer s.Shutdown()
+	defer index.Close()
+
+	for filter, expected := range map[string]int{
+		"name LIKE 'Castello%' OR building = 'yes'":              2,
+		"S_INTERSECTS(geometry, BBOX(11, 47, 12, 48))":           1,
+		`{"op": "=", "args": [{"property": "building"}, "yes"]}`: 1,
+	} {
+		path := "/collections/castles/items?filter=" + url.QueryEscape(filter)
+		if strings.HasPrefix(filter, "{") {
+			path += "&filter-lang=cql2-json"
+		}
+		req, _ := http.NewRequest("GET", path, nil)
+		resp := httptest.NewRecorder()
+		http.HandlerFunc(s.HandleRequest).ServeHTTP(resp, req)
+		if resp.Code != http.StatusOK {
+			t.Errorf("GET %s: expected status 200, got %d", path, resp.Code)
+			continue
+		}
+		var got WFSFeatureCollection
+		if err := json.Unmarshal(resp.Body.Bytes(), &got); err != nil {
+			t.Fatal(err)
+		}
+		if len(got.Features) != expected {
+			t.Errorf("GET %s: expected %d features, got %d", path, expected, len(got.Features))
+		}
+	}
+
+	for _, path := range []string{
+		"/collections/castles/items?filter=" + url.QueryEscape("name LIKE"),
+		"/collections/castles/items?filter-lang=ecql&filter=" + url.QueryEscape("name = 'x'"),
+		"/collections/castles/items?filter-lang=cql2-json&filter=" + url.QueryEscape("name = 'x'"),
+	} {
+		req, _ := http.NewRequest("GET", path, nil)
+		resp := httptest.NewRecorder()
+		http.HandlerFunc(s.HandleRequest).ServeHTTP(resp, req)
+		if resp.Code != http.StatusBadRequest {
+			t.Errorf("GET %s: expected status 400, got %d", path, resp.Code)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/paulmach/go.geojson"
+)
+
+// CSV encoding of features, for pulling data straight into spreadsheets.
+// Each feature becomes a row with its ID, its geometry as Well-Known
+// Text, and one column per property. Nested objects are flattened into
+// columns such as "address.city"; arrays are written as JSON.
+
+func init() {
+	RegisterOutputEncoder(csvEncoder{}, false)
+}
+
+type csvEncoder struct{}
+
+func (csvEncoder) Name() string {
+	return "csv"
+}
+
+func (csvEncoder) MediaType() string {
+	return "text/csv; charset=utf-8"
+}
+
+func (csvEncoder) EncodeFeatureCollection(w io.Writer, collection string, features []RawFeature) error {
+	rows := make([]map[string]string, len(features))
+	columnSet := make(map[string]bool)
+	for i, f := range features {
+		rows[i] = make(map[string]string)
+		flattenProperties(rows[i], "", f.Feature.Properties)
+		for key := range rows[i] {
+			columnSet[key] = true
+		}
+	}
+	columns := make([]string, 0, len(columnSet))
+	for key := range columnSet {
+		columns = append(columns, key)
+	}
+	sort.Strings(columns)
+
+	out := csv.NewWriter(w)
+	record := make([]string, len(columns)+2)
+	record[0], record[1] = "id", "geometry"
+	copy(record[2:], columns)
+	if err := out.Write(record); err != nil {
+		return err
+	}
+	for i, f := range features {
+		record[0] = csvCell(featureIDString(f.Feature.ID))
+		record[1] = encodeWKT(f.Feature.Geometry)
+		for c, key := range columns {
+			record[c+2] = rows[i][key]
+		}
+		if err := out.Write(record); err != nil {
+			return err
+		}
+	}
+	out.Flush()
+	return out.Error()
+}
+
+func (e csvEncoder) EncodeFeature(w io.Writer, collection string, feature RawFeature) error {
+	return e.EncodeFeatureCollection(w, collection, []RawFeature{feature})
+}
+
+// flattenProperties stores the cells for a feature's properties in row,
+// keyed by column name.
+func flattenProperties(row map[string]string, prefix string, properties map[string]interface{}) {
+	for key, value := range properties {
+		column := prefix + key
+		switch v := value.(type) {
+		case nil:
+			row[column] = ""
+		case string:
+			row[column] = csvCell(v)
+		case float64:
+			row[column] = strconv.FormatFloat(v, 'f', -1, 64)
+		case bool:
+			row[column] = strconv.FormatBool(v)
+		case map[string]interface{}:
+			flattenProperties(row, column+".", v)
+		default:
+			if encoded, err := json.Marshal(v); err == nil {
+				row[column] = string(encoded)
+			}
+		}
+	}
+}
+
+// csvCell protects spreadsheet users against formula injection:
+// text that would otherwise be evaluated as a formula gets prefixed
+// with an apostrophe, which spreadsheets treat as a text marker.
+func csvCell(s string) string {
+	if len(s) > 0 && strings.IndexByte("=+-@\t\r", s[0]) >= 0 {
+		return "'" + s
+	}
+	return s
+}
+
+// encodeWKT returns the Well-Known Text representation of a geometry,
+// such as "POINT (8.5 47.3)", or the empty string for null geometries.
+func encodeWKT(g *geojson.Geometry) string {
+	if g == nil {
+		return ""
+	}
+	var b strings.Builder
+	writeWKT(&b, g)
+	return b.String()
+}
+
+func writeWKT(b *strings.Builder, g *geojson.Geometry) {
+	switch g.Type {
+	case geojson.GeometryPoint:
+		b.WriteString("POINT ")
+		writeWKTCoords(b, [][]float64{g.Point})
+	case geojson.GeometryMultiPoint:
+		b.WriteString("MULTIPOINT ")
+		writeWKTCoords(b, g.MultiPoint)
+	case geojson.GeometryLineString:
+		b.WriteString("LINESTRING ")
+		writeWKTCoords(b, g.LineString)
+	case geojson.GeometryMultiLineString:
+		b.WriteString("MULTILINESTRING ")
+		writeWKTRings(b, g.MultiLineString)
+	case geojson.GeometryPolygon:
+		b.WriteString("POLYGON ")
+		writeWKTRings(b, g.Polygon)
+	case geojson.GeometryMultiPolygon:
+		b.WriteString("MULTIPOLYGON ")
+		if len(g.MultiPolygon) == 0 {
+			b.WriteString("EMPTY")
+			return
+		}
+		b.WriteByte('(')
+		for i, poly := range g.MultiPolygon {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			writeWKTRings(b, poly)
+		}
+		b.WriteByte(')')
+	case geojson.GeometryCollection:
+		b.WriteString("GEOMETRYCOLLECTION ")
+		if len(g.Geometries) == 0 {
+			b.WriteString("EMPTY")
+			return
+		}
+		b.WriteByte('(')
+		for i, geom := range g.Geometries {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			if geom != nil {
+				writeWKT(b, geom)
+			}
+		}
+		b.WriteByte(')')
+	}
+}
+
+func writeWKTRings(b *strings.Builder, rings [][][]float64) {
+	if len(rings) == 0 {
+		b.WriteString("EMPTY")
+		return
+	}
+	b.WriteByte('(')
+	for i, ring := range rings {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		writeWKTCoords(b, ring)
+	}
+	b.WriteByte(')')
+}
+
+// writeWKTCoords writes a parenthesized list of coordinates, such as
+// "(8.5 47.3, 8.6 47.3)". Only longitude and latitude get written.
+func writeWKTCoords(b *strings.Builder, coords [][]float64) {
+	if len(coords) == 0 || len(coords[0]) < 2 {
+		b.WriteString("EMPTY")
+		return
+	}
+	b.WriteByte('(')
+	for i, c := range coords {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		if len(c) < 2 {
+			continue
+		}
+		b.WriteString(strconv.FormatFloat(c[0], 'f', -1, 64))
+		b.WriteByte(' ')
+		b.WriteString(strconv.FormatFloat(c[1], 'f', -1, 64))
+	}
+	b.WriteByte(')')
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/paulmach/go.geojson"
+)
+
+func TestCSVEncoder(t *testing.T) {
+	features, err := decodeRawFeatures([]byte(`{"features":[
+		{"type":"Feature","id":"a","geometry":{"type":"Point","coordinates":[8.5,47.25]},
+		 "properties":{"name":"Zürich, \"HB\"","height":12.5,"open":true,"address":{"city":"Zürich","zip":8001},"tags":["x","y"]}},
+		{"type":"Feature","id":"b","geometry":null,"properties":{"name":"=cmd()","note":null}}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out strings.Builder
+	if err := (csvEncoder{}).EncodeFeatureCollection(&out, "test", features); err != nil {
+		t.Fatal(err)
+	}
+	expected := "id,geometry,address.city,address.zip,height,name,note,open,tags\n" +
+		"a,POINT (8.5 47.25),Zürich,8001,12.5,\"Zürich, \"\"HB\"\"\",,true,\"[\"\"x\"\",\"\"y\"\"]\"\n" +
+		"b,,,,,'=cmd(),,,\n"
+	if got := out.String(); got != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, got)
+	}
+}
+
+func TestEncodeWKT(t *testing.T) {
+	for _, tc := range []struct {
+		geometry *geojson.Geometry
+		expected string
+	}{
+		{nil, ""},
+		{geojson.NewPointGeometry([]float64{1, 2, 3}), "POINT (1 2)"},
+		{geojson.NewMultiPointGeometry([]float64{1, 2}, []float64{3, 4}), "MULTIPOINT (1 2, 3 4)"},
+		{geojson.NewLineStringGeometry([][]float64{{1, 2}, {3, 4}}), "LINESTRING (1 2, 3 4)"},
+		{geojson.NewMultiLineStringGeometry([][]float64{{1, 2}, {3, 4}}, [][]float64{{5, 6}, {7, 8}}),
+			"MULTILINESTRING ((1 2, 3 4), (5 6, 7 8))"},
+		{geojson.NewPolygonGeometry([][][]float64{{{0, 0}, {1, 0}, {1, 1}, {0, 0}}}),
+			"POLYGON ((0 0, 1 0, 1 1, 0 0))"},
+		{geojson.NewMultiPolygonGeometry([][][]float64{{{0, 0}, {1, 0}, {1, 1}, {0, 0}}}, [][][]float64{}),
+			"MULTIPOLYGON (((0 0, 1 0, 1 1, 0 0)), EMPTY)"},
+		{geojson.NewCollectionGeometry(geojson.NewPointGeometry([]float64{-0.5, 1e-7})),
+			"GEOMETRYCOLLECTION (POINT (-0.5 0.0000001))"},
+		{geojson.NewLineStringGeometry(nil), "LINESTRING EMPTY"},
+	} {
+		if got := encodeWKT(tc.geometry); got != tc.expected {
+			t.Errorf("expected %q, got %q", tc.expected, got)
+		}
+	}
+}
+
+func TestItems_CSV(t *testing.T) {
+	index, s := makeServer(t)
+	defer s.Shutdown()
+	defer index.Close()
+
+	req, _ := http.NewRequest("GET", "/collections/castles/items?f=csv&ids=N34729562", nil)
+	resp := httptest.NewRecorder()
+	http.HandlerFunc(s.HandleRequest).ServeHTTP(resp, req)
+	if ct := resp.Header().Get("Content-Type"); ct != "text/csv; charset=utf-8" {
+		t.Errorf("expected Content-Type text/csv; charset=utf-8, got %q", ct)
+	}
+	lines := strings.Split(getBody(resp), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[0], "id,geometry,") ||
+		!strings.HasPrefix(lines[1], "N34729562,POINT (11.183468 47.910414),") {
+		t.Errorf("unexpected CSV: %q", lines)
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/paulmach/go.geojson"
+)
+
+// CSV input, since many small datasets get published as spreadsheets.
+// Each row becomes a point feature, located by its latitude and
+// longitude columns; the other columns become string properties.
+// Empty cells are left out. Rows without coordinates get a null
+// geometry. Files may use commas or, as is common in Europe,
+// semicolons as separators.
+
+func init() {
+	RegisterInputLoader(MakeCSVLoader(defaultCSVLatitude, defaultCSVLongitude, defaultCSVID), []string{".csv"}, nil)
+}
+
+// Default column names of CSV input, overridable by command-line flags.
+const (
+	defaultCSVLatitude  = "lat,latitude"
+	defaultCSVLongitude = "lon,lng,longitude"
+	defaultCSVID        = "id"
+)
+
+var MissingCSVCoordinates error = errors.New("CSV file lacks latitude or longitude column")
+
+// CSVLoader reads point features from CSV files. The column names are
+// comma-separated lists of candidates, matched case-insensitively,
+// such as "lat,latitude". If there is no ID column, features get
+// their row number as ID.
+type CSVLoader struct {
+	latitude, longitude, id []string
+}
+
+func MakeCSVLoader(latitude string, longitude string, id string) *CSVLoader {
+	return &CSVLoader{
+		latitude:  splitList(strings.ToLower(latitude)),
+		longitude: splitList(strings.ToLower(longitude)),
+		id:        splitList(strings.ToLower(id)),
+	}
+}
+
+func (*CSVLoader) Name() string {
+	return "csv"
+}
+
+func (*CSVLoader) ModTime(source string) (time.Time, error) {
+	return fileModTime(source)
+}
+
+func (l *CSVLoader) Load(source string) (*SourceData, error) {
+	data, err := readSource(source)
+	if err != nil {
+		return nil, err
+	}
+	data = bytes.TrimPrefix(data, []byte("\ufeff")) // byte order mark
+
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.FieldsPerRecord = -1
+	header := data
+	if i := bytes.IndexByte(data, '\n'); i >= 0 {
+		header = data[:i]
+	}
+	if bytes.Count(header, []byte(";")) > bytes.Count(header, []byte(",")) {
+		reader.Comma = ';'
+	}
+
+	columns, err := reader.Read()
+	if err == io.EOF {
+		return &SourceData{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	latCol, lonCol, idCol := findCSVColumn(columns, l.latitude), findCSVColumn(columns, l.longitude), findCSVColumn(columns, l.id)
+	if latCol < 0 || lonCol < 0 {
+		return nil, MissingCSVCoordinates
+	}
+
+	result := &SourceData{}
+	for row := 1; ; row++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		f := geojson.NewFeature(nil)
+		f.ID = strconv.Itoa(row)
+		for col, value := range record {
+			if col >= len(columns) || len(value) == 0 {
+				continue
+			}
+			switch col {
+			case latCol, lonCol:
+			case idCol:
+				f.ID = value
+			default:
+				f.Properties[columns[col]] = value
+			}
+		}
+
+		lat, lon := csvField(record, latCol), csvField(record, lonCol)
+		if len(lat) > 0 || len(lon) > 0 {
+			latValue, latErr := strconv.ParseFloat(lat, 64)
+			lonValue, lonErr := strconv.ParseFloat(lon, 64)
+			if latErr != nil || lonErr != nil || latValue < -90 || latValue > 90 || lonValue < -180 || lonValue > 180 {
+				return nil, fmt.Errorf("%s: row %d: bad coordinates %q, %q", source, row, lat, lon)
+			}
+			f.Geometry = geojson.NewPointGeometry([]float64{lonValue, latValue})
+		}
+		result.Features = append(result.Features, f)
+	}
+	return result, nil
+}
+
+// findCSVColumn returns the index of the first column whose name is
+// one of the candidates, ignoring case, or -1 if there is none.
+func findCSVColumn(header []string, candidates []string) int {
+	for _, name := range candidates {
+		for col, h := range header {
+			if strings.ToLower(strings.TrimSpace(h)) == name {
+				return col
+			}
+		}
+	}
+	return -1
+}
+
+// csvField returns the trimmed value of a cell, or the empty string
+// for cells missing in short rows.
+func csvField(record []string, col int) string {
+	if col >= len(record) {
+		return ""
+	}
+	return strings.TrimSpace(record[col])
+}
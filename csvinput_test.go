@@ -0,0 +1,88 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCSVLoader(t *testing.T) {
+	l, err := GetInputLoader(filepath.Join("testdata", "castles.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := l.Load(filepath.Join("testdata", "castles.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data.Features) != 2 {
+		t.Fatalf("expected 2 features, got %d", len(data.Features))
+	}
+
+	castle := data.Features[0]
+	if castle.ID != "N34729562" || castle.Properties["Name"] != "Burg Hohenwaldeck" || len(castle.Properties) != 1 {
+		t.Errorf("unexpected feature %v %v", castle.ID, castle.Properties)
+	}
+	if g := castle.Geometry; g == nil || !g.IsPoint() || g.Point[0] != 11.183468 || g.Point[1] != 47.910414 {
+		t.Errorf("expected point at 11.183468,47.910414, got %v", g)
+	}
+
+	ruin := data.Features[1]
+	if ruin.ID != "2" || ruin.Geometry != nil || ruin.Properties["Name"] != "Ruine; alt" || ruin.Properties["Note"] != "unlocated" {
+		t.Errorf("unexpected feature %v %v %v", ruin.ID, ruin.Geometry, ruin.Properties)
+	}
+}
+
+func TestCSVLoader_Columns(t *testing.T) {
+	path := writeTestCSV(t, "y,x,name\n47.5,8.5,Zürich\n")
+	defer os.RemoveAll(filepath.Dir(path))
+
+	if _, err := MakeCSVLoader(defaultCSVLatitude, defaultCSVLongitude, defaultCSVID).Load(path); err != MissingCSVCoordinates {
+		t.Errorf("expected MissingCSVCoordinates, got %v", err)
+	}
+
+	data, err := MakeCSVLoader("Y", "X", "name").Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f := data.Features[0]; f.ID != "Zürich" || f.Geometry.Point[0] != 8.5 || len(f.Properties) != 0 {
+		t.Errorf("unexpected feature %v %v %v", f.ID, f.Geometry, f.Properties)
+	}
+}
+
+func TestCSVLoader_BadCoordinates(t *testing.T) {
+	path := writeTestCSV(t, "lat,lon\n47.5,8.5\n95,8.5\n")
+	defer os.RemoveAll(filepath.Dir(path))
+
+	_, err := MakeCSVLoader(defaultCSVLatitude, defaultCSVLongitude, defaultCSVID).Load(path)
+	if err == nil || !strings.Contains(err.Error(), "row 2") {
+		t.Errorf("expected error for row 2, got %v", err)
+	}
+}
+
+func TestCSVLoader_Collection(t *testing.T) {
+	var t0 time.Time
+	coll, err := readCollection("castles", filepath.Join("testdata", "castles.csv"), t0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer coll.Close()
+	if len(coll.id) != 2 {
+		t.Errorf("expected 2 features, got %v", coll.id)
+	}
+}
+
+func writeTestCSV(t *testing.T, content string) string {
+	dir, err := ioutil.TempDir("", "miniwfs-csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "points.csv")
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
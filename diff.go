@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"strconv"
+	"time"
+)
+
+// CollectionDiff describes how the features of a collection have
+// changed between two versions. Features are matched by ID; features
+// without an ID are not taken into account.
+type CollectionDiff struct {
+	Added   []string
+	Removed []string
+	Changed []string
+}
+
+// ChangeEvent gets sent to change listeners after a reload has changed
+// the content of a collection.
+type ChangeEvent struct {
+	Collection      string    `json:"collection"`
+	Version         string    `json:"version"`
+	PreviousVersion string    `json:"previousVersion"`
+	Timestamp       time.Time `json:"timestamp"`
+	NumFeatures     int       `json:"numFeatures"`
+	NumAdded        int       `json:"numAdded"`
+	NumRemoved      int       `json:"numRemoved"`
+	NumChanged      int       `json:"numChanged"`
+	Deleted         []string  `json:"deleted,omitempty"` // only if tombstones are kept
+}
+
+// Tombstone records that a feature has been deleted from a collection,
+// so that clients syncing local copies can propagate the deletion.
+type Tombstone struct {
+	ID      string    `json:"id"`
+	Deleted time.Time `json:"deleted"`
+}
+
+// maxTombstones limits how many deletions we remember per collection.
+// When exceeded, the oldest tombstones are dropped.
+const maxTombstones = 10000
+
+func makeChangeEvent(old *Collection, new *Collection, diff CollectionDiff) *ChangeEvent {
+	return &ChangeEvent{
+		Collection:      new.metadata.Name,
+		Version:         new.metadata.Version,
+		PreviousVersion: old.metadata.Version,
+		Timestamp:       time.Now().UTC(),
+		NumFeatures:     new.numFeatures(),
+		NumAdded:        len(diff.Added),
+		NumRemoved:      len(diff.Removed),
+		NumChanged:      len(diff.Changed),
+	}
+}
+
+// SetKeepTombstones configures whether the index remembers features
+// that have been removed by a reload.
+func (index *Index) SetKeepTombstones(keep bool) {
+	index.mutex.Lock()
+	defer index.mutex.Unlock()
+	index.keepTombstones = keep
+	if !keep {
+		index.tombstones = nil
+	}
+}
+
+// updateTombstones records the features removed by a diff, and forgets
+// the tombstones of features that have been re-added. Must be called
+// with the mutex held.
+func (index *Index) updateTombstones(collection string, diff CollectionDiff, now time.Time) {
+	if index.tombstones == nil {
+		index.tombstones = make(map[string][]Tombstone)
+	}
+	added := make(map[string]bool, len(diff.Added))
+	for _, id := range diff.Added {
+		added[id] = true
+	}
+
+	old := index.tombstones[collection]
+	tombstones := make([]Tombstone, 0, len(old)+len(diff.Removed))
+	for _, t := range old {
+		if !added[t.ID] {
+			tombstones = append(tombstones, t)
+		}
+	}
+	for _, id := range diff.Removed {
+		tombstones = append(tombstones, Tombstone{ID: id, Deleted: now})
+	}
+	if len(tombstones) > maxTombstones {
+		tombstones = tombstones[len(tombstones)-maxTombstones:]
+	}
+	index.tombstones[collection] = tombstones
+}
+
+func diffCollections(old *Collection, new *Collection) CollectionDiff {
+	var diff CollectionDiff
+	for i := 0; i < new.numFeatures(); i++ {
+		id := new.featureID(i)
+		if len(id) == 0 {
+			continue
+		}
+		if j, ok := old.lookupID(id); !ok {
+			diff.Added = append(diff.Added, id)
+		} else if old.featureHash(j) != new.featureHash(i) {
+			diff.Changed = append(diff.Changed, id)
+		}
+	}
+	for i := 0; i < old.numFeatures(); i++ {
+		id := old.featureID(i)
+		if len(id) == 0 {
+			continue
+		}
+		if _, ok := new.lookupID(id); !ok {
+			diff.Removed = append(diff.Removed, id)
+		}
+	}
+	return diff
+}
+
+func hashFeature(encoded []byte) uint64 {
+	h := fnv.New64a()
+	h.Write(encoded)
+	return h.Sum64()
+}
+
+// computeVersion returns a version string that changes whenever the
+// content or the order of features changes.
+func computeVersion(ids []string, hashes []uint64) string {
+	h := fnv.New64a()
+	var buf [8]byte
+	for i, id := range ids {
+		h.Write([]byte(id))
+		binary.LittleEndian.PutUint64(buf[:], hashes[i])
+		h.Write(buf[:])
+	}
+	return strconv.FormatUint(h.Sum64(), 36)
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/golang/geo/s2"
+)
+
+func readTestCollection(t *testing.T, name string, content string) *Collection {
+	tmpfile, err := ioutil.TempFile("", "test.*.geojson")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+	tmpfile.Write([]byte(content))
+	tmpfile.Close()
+
+	var t0 time.Time
+	coll, err := readCollection(name, tmpfile.Name(), t0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return coll
+}
+
+func TestDiffCollections(t *testing.T) {
+	old := readTestCollection(t, "test", `{"type":"FeatureCollection","features":[
+		{"type":"Feature","id":"A","properties":{"name":"a"}},
+		{"type":"Feature","id":"B","properties":{"name":"b"}},
+		{"type":"Feature","id":"C","properties":{"name":"c"}}]}`)
+	defer old.Close()
+	new := readTestCollection(t, "test", `{"type":"FeatureCollection","features":[
+		{"type":"Feature","id":"A","properties":{"name":"a"}},
+		{"type":"Feature","id":"C","properties":{"name":"c2"}},
+		{"type":"Feature","id":"D","properties":{"name":"d"}}]}`)
+	defer new.Close()
+
+	got := diffCollections(old, new)
+	expected := CollectionDiff{
+		Added:   []string{"D"},
+		Removed: []string{"B"},
+		Changed: []string{"C"},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+
+	if old.metadata.Version == new.metadata.Version {
+		t.Errorf("expected different versions, got %s for both", old.metadata.Version)
+	}
+}
+
+func TestReplaceCollection_ChangeListener(t *testing.T) {
+	index := loadTestIndex(t)
+	defer index.Close()
+
+	var events []ChangeEvent
+	index.AddChangeListener(func(e ChangeEvent) { events = append(events, e) })
+
+	same := readTestCollection(t, "lakes", `{"features":[{
+		"geometry":{"coordinates":[11.183468,47.910414],"type":"Point"},
+		"id":"N123","properties":{"natural":"lake","name":"Katzensee"},"type":"Feature"}]}`)
+	index.replaceCollection(same)
+	if len(events) != 0 {
+		t.Fatalf("expected no change event for identical content, got %v", events)
+	}
+
+	changed := readTestCollection(t, "lakes", `{"features":[{
+		"geometry":{"coordinates":[11.183468,47.910414],"type":"Point"},
+		"id":"N123","properties":{"natural":"lake","name":"Katzensee"},"type":"Feature"},
+		{"id":"N124","properties":{"natural":"lake"},"type":"Feature"}]}`)
+	index.replaceCollection(changed)
+	if len(events) != 1 {
+		t.Fatalf("expected one change event, got %v", events)
+	}
+	e := events[0]
+	if e.Collection != "lakes" || e.NumFeatures != 2 || e.NumAdded != 1 || e.NumRemoved != 0 || e.NumChanged != 0 {
+		t.Errorf("unexpected change event %+v", e)
+	}
+}
+
+func TestReplaceCollection_Tombstones(t *testing.T) {
+	index := loadTestIndex(t)
+	defer index.Close()
+	index.SetKeepTombstones(true)
+
+	var events []ChangeEvent
+	index.AddChangeListener(func(e ChangeEvent) { events = append(events, e) })
+
+	index.replaceCollection(readTestCollection(t, "lakes", `{"features":[
+		{"id":"N124","properties":{"natural":"lake"},"type":"Feature"}]}`))
+	if len(events) != 1 || !reflect.DeepEqual(events[0].Deleted, []string{"N123"}) {
+		t.Fatalf("expected change event with deleted N123, got %v", events)
+	}
+
+	var buf bytes.Buffer
+	if _, _, err := index.GetItems("lakes", itemsQuery{itemsSelection: itemsSelection{bbox: s2.FullRect()}, limit: 10}, noTime, noTime, "", true, &buf); err != nil {
+		t.Fatal(err)
+	}
+	var page struct {
+		Deleted []Tombstone `json:"deleted"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &page); err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Deleted) != 1 || page.Deleted[0].ID != "N123" || page.Deleted[0].Deleted != events[0].Timestamp {
+		t.Errorf("expected tombstone for N123, got %v", page.Deleted)
+	}
+
+	// Without includeDeleted, tombstones are not listed.
+	buf.Reset()
+	if _, _, err := index.GetItems("lakes", itemsQuery{itemsSelection: itemsSelection{bbox: s2.FullRect()}, limit: 10}, noTime, noTime, "", false, &buf); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(buf.Bytes(), []byte(`"deleted"`)) {
+		t.Errorf("expected no tombstones without includeDeleted, got %s", buf.String())
+	}
+
+	// Re-adding a deleted feature removes its tombstone.
+	index.replaceCollection(readTestCollection(t, "lakes", `{"features":[
+		{"id":"N123","properties":{"natural":"lake"},"type":"Feature"},
+		{"id":"N124","properties":{"natural":"lake"},"type":"Feature"}]}`))
+	if got := index.tombstones["lakes"]; len(got) != 0 {
+		t.Errorf("expected no tombstones after re-adding, got %v", got)
+	}
+}
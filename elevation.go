@@ -0,0 +1,331 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/geo/s2"
+	"github.com/paulmach/go.geojson"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var numElevationLookupFailures = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "miniwfs_elevation_lookup_failures_total",
+	Help: "Total number of failed batches of elevation lookups.",
+})
+
+// elevationBatchSize is how many points get looked up at once.
+const elevationBatchSize = 100
+
+// maxElevationFailures is how many batches may fail while loading a
+// collection before we stop asking the elevation source. The collection
+// still gets loaded, with elevation for the points looked up so far.
+const maxElevationFailures = 3
+
+// maxElevationCacheEntries bounds the memory for cached elevations.
+// When the cache is full, it gets emptied.
+const maxElevationCacheEntries = 100000
+
+// ElevationSource looks up the terrain elevation at points, in meters.
+type ElevationSource interface {
+	// Elevations returns one elevation for each point, or NaN where
+	// the elevation is unknown.
+	Elevations(points []s2.LatLng) ([]float64, error)
+}
+
+// ElevationEnricher attaches elevation to point features when their
+// collection gets loaded, because several consumers of our data need
+// it. Features that already have the property are left alone. Lookups
+// are cached, so reloads only ask the source about new points.
+type ElevationEnricher struct {
+	source      ElevationSource
+	property    string          // such as "ele"
+	collections map[string]bool // nil for all collections
+
+	mutex sync.Mutex
+	cache map[s2.LatLng]float64
+}
+
+func MakeElevationEnricher(source ElevationSource, property string, collections []string) *ElevationEnricher {
+	e := &ElevationEnricher{source: source, property: property, cache: make(map[s2.LatLng]float64)}
+	if len(collections) > 0 {
+		e.collections = make(map[string]bool, len(collections))
+		for _, c := range collections {
+			e.collections[c] = true
+		}
+	}
+	return e
+}
+
+// appliesTo returns true if a collection gets enriched.
+func (e *ElevationEnricher) appliesTo(collection string) bool {
+	return e != nil && (e.collections == nil || e.collections[collection])
+}
+
+// Enrich sets the elevation property of point features lacking it.
+// Failures of the elevation source are logged, not returned, so that
+// collections still get loaded when the source is unavailable.
+func (e *ElevationEnricher) Enrich(collection string, features []*geojson.Feature) {
+	var missing []*geojson.Feature
+	var points []s2.LatLng
+	for _, f := range features {
+		if f.Geometry == nil || f.Geometry.Type != geojson.GeometryPoint || len(f.Geometry.Point) < 2 {
+			continue
+		}
+		if value, ok := f.Properties[e.property]; ok && value != nil {
+			continue
+		}
+		missing = append(missing, f)
+		points = append(points, s2.LatLngFromDegrees(f.Geometry.Point[1], f.Geometry.Point[0]))
+	}
+	if len(points) == 0 {
+		return
+	}
+
+	elevations, failures := e.lookup(points)
+	enriched := 0
+	for i, f := range missing {
+		if math.IsNaN(elevations[i]) {
+			continue
+		}
+		if f.Properties == nil {
+			f.Properties = make(map[string]interface{})
+		}
+		f.Properties[e.property] = elevations[i]
+		enriched++
+	}
+	loaderLog.Info("added elevation", "collection", collection,
+		"features", enriched, "missing", len(missing)-enriched)
+	if failures > 0 {
+		loaderLog.Warn("elevation lookups failed", "collection", collection, "batches", failures)
+	}
+}
+
+// lookup returns the elevations of points, taking them from the cache
+// where possible. Points that could not be looked up get NaN.
+func (e *ElevationEnricher) lookup(points []s2.LatLng) ([]float64, int) {
+	result := make([]float64, len(points))
+	var uncached []int
+	e.mutex.Lock()
+	for i, p := range points {
+		if ele, ok := e.cache[p]; ok {
+			result[i] = ele
+		} else {
+			result[i] = math.NaN()
+			uncached = append(uncached, i)
+		}
+	}
+	e.mutex.Unlock()
+
+	failures := 0
+	for start := 0; start < len(uncached) && failures < maxElevationFailures; start += elevationBatchSize {
+		end := start + elevationBatchSize
+		if end > len(uncached) {
+			end = len(uncached)
+		}
+		batch := make([]s2.LatLng, end-start)
+		for k, i := range uncached[start:end] {
+			batch[k] = points[i]
+		}
+		elevations, err := e.source.Elevations(batch)
+		if err == nil && len(elevations) != len(batch) {
+			err = fmt.Errorf("got %d elevations for %d points", len(elevations), len(batch))
+		}
+		if err != nil {
+			loaderLog.Warn("elevation lookup failed", "error", err)
+			numElevationLookupFailures.Inc()
+			failures++
+			continue
+		}
+
+		e.mutex.Lock()
+		if len(e.cache)+len(batch) > maxElevationCacheEntries {
+			e.cache = make(map[s2.LatLng]float64)
+		}
+		for k, i := range uncached[start:end] {
+			result[i] = elevations[k]
+			if !math.IsNaN(elevations[k]) {
+				e.cache[batch[k]] = elevations[k]
+			}
+		}
+		e.mutex.Unlock()
+	}
+	return result, failures
+}
+
+// ElevationService looks up elevations from a web service with the API
+// of Open-Elevation, https://open-elevation.com/, which is also offered
+// by other implementations.
+type ElevationService struct {
+	url    string // such as "https://api.open-elevation.com/api/v1/lookup"
+	client *http.Client
+}
+
+func MakeElevationService(url string) *ElevationService {
+	return &ElevationService{url: url, client: &http.Client{Timeout: 30 * time.Second}}
+}
+
+func (s *ElevationService) Elevations(points []s2.LatLng) ([]float64, error) {
+	type location struct {
+		Latitude  float64 `json:"latitude"`
+		Longitude float64 `json:"longitude"`
+	}
+	var request struct {
+		Locations []location `json:"locations"`
+	}
+	request.Locations = make([]location, len(points))
+	for i, p := range points {
+		request.Locations[i] = location{p.Lat.Degrees(), p.Lng.Degrees()}
+	}
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := s.client.Post(s.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("got HTTP status %d", resp.StatusCode)
+	}
+
+	var response struct {
+		Results []struct {
+			Elevation *float64 `json:"elevation"`
+		} `json:"results"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, err
+	}
+	result := make([]float64, len(response.Results))
+	for i, r := range response.Results {
+		if r.Elevation != nil {
+			result[i] = *r.Elevation
+		} else {
+			result[i] = math.NaN()
+		}
+	}
+	return result, nil
+}
+
+// DEM is a digital elevation model, read from a file in ESRI ASCII
+// grid format. Coordinates must be in WGS84 longitude and latitude.
+type DEM struct {
+	numCols, numRows int
+	west, south      float64 // lower left corner of the grid, in degrees
+	cellSize         float64 // in degrees
+	heights          []float64
+}
+
+var MalformedDEM error = errors.New("malformed DEM file; expected ESRI ASCII grid format")
+
+// ReadDEM reads a digital elevation model in ESRI ASCII grid format.
+func ReadDEM(path string) (*DEM, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Split(bufio.ScanWords)
+
+	// The header has lines such as "ncols 4", followed by the heights
+	// of the rows from north to south.
+	header := make(map[string]float64)
+	var values []string
+	for scanner.Scan() {
+		key := scanner.Text()
+		if _, err := strconv.ParseFloat(key, 64); err == nil {
+			values = append(values, key)
+			break
+		}
+		if !scanner.Scan() {
+			return nil, MalformedDEM
+		}
+		value, err := strconv.ParseFloat(scanner.Text(), 64)
+		if err != nil {
+			return nil, MalformedDEM
+		}
+		header[strings.ToLower(key)] = value
+	}
+
+	dem := &DEM{}
+	dem.numCols, dem.numRows = int(header["ncols"]), int(header["nrows"])
+	dem.cellSize = header["cellsize"]
+	dem.west, dem.south = header["xllcorner"], header["yllcorner"]
+	if _, centered := header["xllcenter"]; centered {
+		dem.west = header["xllcenter"] - dem.cellSize/2
+		dem.south = header["yllcenter"] - dem.cellSize/2
+	}
+	if dem.numCols <= 0 || dem.numRows <= 0 || !(dem.cellSize > 0) {
+		return nil, MalformedDEM
+	}
+
+	for scanner.Scan() {
+		values = append(values, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(values) != dem.numCols*dem.numRows {
+		return nil, MalformedDEM
+	}
+
+	noData, hasNoData := header["nodata_value"]
+	dem.heights = make([]float64, len(values))
+	for i, v := range values {
+		height, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return nil, MalformedDEM
+		}
+		if hasNoData && height == noData {
+			height = math.NaN()
+		}
+		dem.heights[i] = height
+	}
+	return dem, nil
+}
+
+// Elevations returns the height of the grid cells containing points,
+// or NaN for points outside the grid.
+func (dem *DEM) Elevations(points []s2.LatLng) ([]float64, error) {
+	result := make([]float64, len(points))
+	for i, p := range points {
+		col := int(math.Floor((p.Lng.Degrees() - dem.west) / dem.cellSize))
+		row := dem.numRows - 1 - int(math.Floor((p.Lat.Degrees()-dem.south)/dem.cellSize))
+		if col < 0 || col >= dem.numCols || row < 0 || row >= dem.numRows {
+			result[i] = math.NaN()
+		} else {
+			result[i] = dem.heights[row*dem.numCols+col]
+		}
+	}
+	return result, nil
+}
+
+// SetElevationEnricher configures how to add elevation to features when
+// loading collections, or disables it if e is nil. The affected
+// collections get reloaded right away.
+func (index *Index) SetElevationEnricher(e *ElevationEnricher) {
+	index.mutex.Lock()
+	old := index.elevation
+	index.elevation = e
+	index.mutex.Unlock()
+
+	index.reloadCollections(func(name string) bool {
+		return e.appliesTo(name) || old.appliesTo(name)
+	})
+}
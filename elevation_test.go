@@ -0,0 +1,188 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/golang/geo/s2"
+	"github.com/paulmach/go.geojson"
+)
+
+// fakeElevationSource returns a fixed elevation, or an error if height
+// is NaN, and counts how many points it was asked about.
+type fakeElevationSource struct {
+	height    float64
+	numCalls  int
+	numPoints int
+}
+
+func (f *fakeElevationSource) Elevations(points []s2.LatLng) ([]float64, error) {
+	f.numCalls++
+	f.numPoints += len(points)
+	if math.IsNaN(f.height) {
+		return nil, errors.New("service unavailable")
+	}
+	result := make([]float64, len(points))
+	for i := range result {
+		result[i] = f.height
+	}
+	return result, nil
+}
+
+func TestElevationEnricher(t *testing.T) {
+	source := &fakeElevationSource{height: 512}
+	e := MakeElevationEnricher(source, "ele", nil)
+	makeFeatures := func() []*geojson.Feature {
+		withEle := geojson.NewPointFeature([]float64{8.5, 47.4})
+		withEle.SetProperty("ele", 400)
+		return []*geojson.Feature{
+			geojson.NewPointFeature([]float64{8.5, 47.3}),
+			withEle,
+			geojson.NewLineStringFeature([][]float64{{8.5, 47.3}, {8.6, 47.3}}),
+		}
+	}
+
+	features := makeFeatures()
+	e.Enrich("test", features)
+	if got := features[0].Properties["ele"]; got != 512.0 {
+		t.Errorf("expected ele=512 for point without elevation, got %v", got)
+	}
+	if got := features[1].Properties["ele"]; got != 400 {
+		t.Errorf("expected ele=400 to be kept, got %v", got)
+	}
+	if _, ok := features[2].Properties["ele"]; ok {
+		t.Errorf("expected no elevation for line, got %v", features[2].Properties)
+	}
+
+	// Reloading the same data should not ask the source again.
+	features = makeFeatures()
+	e.Enrich("test", features)
+	if source.numCalls != 1 || features[0].Properties["ele"] != 512.0 {
+		t.Errorf("expected cached elevation, got %d calls and ele=%v", source.numCalls, features[0].Properties["ele"])
+	}
+}
+
+func TestElevationEnricher_Failure(t *testing.T) {
+	source := &fakeElevationSource{height: math.NaN()}
+	e := MakeElevationEnricher(source, "ele", nil)
+	features := make([]*geojson.Feature, 10*elevationBatchSize)
+	for i := range features {
+		features[i] = geojson.NewPointFeature([]float64{float64(i) / 1000, 0})
+	}
+	e.Enrich("test", features)
+	if source.numCalls != maxElevationFailures {
+		t.Errorf("expected to give up after %d failures, got %d calls", maxElevationFailures, source.numCalls)
+	}
+	for _, f := range features {
+		if _, ok := f.Properties["ele"]; ok {
+			t.Fatalf("expected no elevation after failures, got %v", f.Properties)
+		}
+	}
+}
+
+func TestElevationService(t *testing.T) {
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		if string(body) != `{"locations":[{"latitude":47.5,"longitude":8.25},{"latitude":0,"longitude":-20}]}` {
+			t.Errorf("unexpected request: %s", body)
+		}
+		w.WriteHeader(status)
+		fmt.Fprint(w, `{"results":[{"latitude":47.5,"longitude":8.25,"elevation":432.5},{"elevation":null}]}`)
+	}))
+	defer server.Close()
+
+	service := MakeElevationService(server.URL)
+	points := []s2.LatLng{s2.LatLngFromDegrees(47.5, 8.25), s2.LatLngFromDegrees(0, -20)}
+	got, err := service.Elevations(points)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0] != 432.5 || !math.IsNaN(got[1]) {
+		t.Errorf("expected [432.5 NaN], got %v", got)
+	}
+
+	status = http.StatusServiceUnavailable
+	if _, err := service.Elevations(points); err == nil {
+		t.Error("expected error for HTTP status 503")
+	}
+}
+
+func writeTempDEM(t *testing.T, content string) string {
+	file, err := ioutil.TempFile("", "miniwfs-*.asc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if _, err := file.WriteString(content); err != nil {
+		t.Fatal(err)
+	}
+	return file.Name()
+}
+
+func TestReadDEM(t *testing.T) {
+	path := writeTempDEM(t, "ncols 3\nnrows 2\nxllcorner 8.0\nyllcorner 47.0\ncellsize 0.5\nNODATA_value -9999\n"+
+		"410 420 430\n"+
+		"510 -9999 530\n")
+	defer os.Remove(path)
+	dem, err := ReadDEM(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		lng, lat, expected float64
+	}{
+		{8.1, 47.9, 410},
+		{9.4, 47.6, 430},
+		{8.1, 47.1, 510},
+		{8.7, 47.1, math.NaN()}, // no data
+		{9.6, 47.1, math.NaN()}, // outside
+		{8.1, 46.9, math.NaN()}, // outside
+	} {
+		got, _ := dem.Elevations([]s2.LatLng{s2.LatLngFromDegrees(tc.lat, tc.lng)})
+		if got[0] != tc.expected && !(math.IsNaN(got[0]) && math.IsNaN(tc.expected)) {
+			t.Errorf("elevation at %g,%g: expected %g, got %g", tc.lng, tc.lat, tc.expected, got[0])
+		}
+	}
+
+	for _, content := range []string{
+		"",
+		"ncols 3\nnrows 2\nxllcorner 8.0\nyllcorner 47.0\ncellsize 0.5\n1 2 3\n",
+		"ncols 3\nnrows 1\nxllcorner 8.0\nyllcorner 47.0\ncellsize 0.5\n1 x 3\n",
+		"ncols 3\nnrows 1\nxllcorner 8.0\nyllcorner 47.0\ncellsize\n",
+	} {
+		path := writeTempDEM(t, content)
+		defer os.Remove(path)
+		if _, err := ReadDEM(path); err != MalformedDEM {
+			t.Errorf("expected MalformedDEM for %q, got %v", content, err)
+		}
+	}
+}
+
+func TestIndex_SetElevationEnricher(t *testing.T) {
+	index := loadTestIndex(t)
+	defer index.Close()
+
+	index.SetElevationEnricher(MakeElevationEnricher(&fakeElevationSource{height: 622}, "ele", []string{"castles"}))
+	if f, _ := index.GetItem("castles", "N34729562"); f == nil || f.Properties["ele"] != 622.0 {
+		t.Errorf("expected castle N34729562 to have ele=622, got %v", f)
+	}
+	if f, _ := index.GetItem("castles", "W418392510"); f == nil || f.Properties["ele"] != nil {
+		t.Errorf("expected no elevation for non-point W418392510, got %v", f)
+	}
+	if f, _ := index.GetItem("lakes", "N123"); f == nil || f.Properties["ele"] != nil {
+		t.Errorf("expected no elevation for lakes, got %v", f)
+	}
+
+	index.SetElevationEnricher(nil)
+	if f, _ := index.GetItem("castles", "N34729562"); f == nil || f.Properties["ele"] != nil {
+		t.Errorf("expected elevation to be gone after disabling, got %v", f)
+	}
+}
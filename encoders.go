@@ -0,0 +1,276 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/paulmach/go.geojson"
+)
+
+// RawFeature gives output encoders access to a feature both in its
+// stored GeoJSON encoding, for formats that can copy it verbatim, and
+// decoded, for formats that need to convert the typed geometry.
+type RawFeature struct {
+	JSON    []byte
+	Feature *geojson.Feature
+}
+
+// OutputEncoder writes features in an output format. To add a format,
+// implement this interface in a new file and register it with
+// RegisterOutputEncoder from an init function; the items, single item
+// and export endpoints, content negotiation and the API description
+// all consult the registry. GeoJSON is registered like any other
+// format, but the web server serves it by a faster path that copies
+// stored bytes.
+type OutputEncoder interface {
+	// Name is the value of the f= query parameter, such as "gml".
+	// It is also used as file extension for exports.
+	Name() string
+
+	MediaType() string
+
+	EncodeFeatureCollection(w io.Writer, collection string, features []RawFeature) error
+
+	EncodeFeature(w io.Writer, collection string, feature RawFeature) error
+}
+
+var outputEncoders = struct {
+	sync.RWMutex
+	registered  map[string]OutputEncoder
+	byMediaType map[string]OutputEncoder
+	enabled     map[string]bool
+}{
+	registered:  make(map[string]OutputEncoder),
+	byMediaType: make(map[string]OutputEncoder),
+	enabled:     make(map[string]bool),
+}
+
+// RegisterOutputEncoder makes an encoder known. Experimental encoders
+// only get served after they have been enabled by EnableOutputEncoder.
+func RegisterOutputEncoder(e OutputEncoder, experimental bool) {
+	outputEncoders.Lock()
+	defer outputEncoders.Unlock()
+	outputEncoders.registered[e.Name()] = e
+	outputEncoders.byMediaType[baseMediaType(e.MediaType())] = e
+	outputEncoders.enabled[e.Name()] = !experimental
+}
+
+// EnableOutputEncoder enables an experimental encoder. Returns false
+// if no encoder has been registered under that name.
+func EnableOutputEncoder(name string) bool {
+	outputEncoders.Lock()
+	defer outputEncoders.Unlock()
+	if _, ok := outputEncoders.registered[name]; !ok {
+		return false
+	}
+	outputEncoders.enabled[name] = true
+	return true
+}
+
+// GetOutputEncoder returns the enabled encoder for a format name,
+// or nil if there is none.
+func GetOutputEncoder(name string) OutputEncoder {
+	outputEncoders.RLock()
+	defer outputEncoders.RUnlock()
+	if !outputEncoders.enabled[name] {
+		return nil
+	}
+	return outputEncoders.registered[name]
+}
+
+// GetOutputEncoderForMediaType returns the enabled encoder for a media
+// type, ignoring any parameters, or nil if there is none.
+func GetOutputEncoderForMediaType(mediaType string) OutputEncoder {
+	outputEncoders.RLock()
+	defer outputEncoders.RUnlock()
+	e := outputEncoders.byMediaType[baseMediaType(mediaType)]
+	if e == nil || !outputEncoders.enabled[e.Name()] {
+		return nil
+	}
+	return e
+}
+
+// GetEnabledOutputEncoders returns all enabled encoders, sorted by name.
+func GetEnabledOutputEncoders() []OutputEncoder {
+	var result []OutputEncoder
+	for _, name := range GetOutputEncoderNames() {
+		if e := GetOutputEncoder(name); e != nil {
+			result = append(result, e)
+		}
+	}
+	return result
+}
+
+// featureFormats returns the names of the enabled encoders, for
+// content negotiation on the endpoints that return features. GeoJSON
+// comes first, so clients get it unless they ask for something else.
+func featureFormats() []string {
+	formats := []string{"json"}
+	for _, e := range GetEnabledOutputEncoders() {
+		if e.Name() != "json" {
+			formats = append(formats, e.Name())
+		}
+	}
+	return formats
+}
+
+// parseAccept returns the media types of an HTTP Accept header,
+// most preferred first. Media types with q=0 are left out.
+func parseAccept(accept string) []string {
+	type entry struct {
+		mediaType string
+		q         float64
+	}
+	var entries []entry
+	for _, part := range strings.Split(accept, ",") {
+		fields := strings.Split(part, ";")
+		mediaType := baseMediaType(fields[0])
+		if len(mediaType) == 0 {
+			continue
+		}
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if v, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		if q > 0 {
+			entries = append(entries, entry{mediaType, q})
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].q > entries[j].q })
+	result := make([]string, len(entries))
+	for i, e := range entries {
+		result[i] = e.mediaType
+	}
+	return result
+}
+
+func baseMediaType(mediaType string) string {
+	if i := strings.IndexByte(mediaType, ';'); i >= 0 {
+		mediaType = mediaType[:i]
+	}
+	return strings.ToLower(strings.TrimSpace(mediaType))
+}
+
+// GetOutputEncoderNames returns the names of all registered encoders in
+// alphabetical order, including the ones that have not been enabled.
+func GetOutputEncoderNames() []string {
+	outputEncoders.RLock()
+	defer outputEncoders.RUnlock()
+	names := make([]string, 0, len(outputEncoders.registered))
+	for name := range outputEncoders.registered {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// jsonOnlyEncoder is implemented by output encoders that only look at
+// RawFeature.JSON, so features need not be decoded for them.
+type jsonOnlyEncoder interface {
+	OutputEncoder
+	jsonOnly()
+}
+
+// decodeRawFeatures decodes a GeoJSON FeatureCollection, as produced by
+// Index.GetItems, into features for output encoders.
+func decodeRawFeatures(data []byte) ([]RawFeature, error) {
+	result, err := splitRawFeatures(data)
+	if err != nil {
+		return nil, err
+	}
+	for i := range result {
+		if result[i].Feature, err = geojson.UnmarshalFeature(result[i].JSON); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// splitRawFeatures splits a GeoJSON FeatureCollection into features
+// like decodeRawFeatures, but leaves RawFeature.Feature nil. This is
+// enough for encoders implementing jsonOnlyEncoder.
+func splitRawFeatures(data []byte) ([]RawFeature, error) {
+	var fc struct {
+		Features []json.RawMessage `json:"features"`
+	}
+	if err := json.Unmarshal(data, &fc); err != nil {
+		return nil, err
+	}
+
+	result := make([]RawFeature, len(fc.Features))
+	for i, raw := range fc.Features {
+		result[i] = RawFeature{JSON: raw}
+	}
+	return result, nil
+}
+
+// transformRawFeatures replaces each feature of a GeoJSON
+// FeatureCollection by the result of calling fn on its encoding,
+// keeping the other members of the collection, such as links.
+func transformRawFeatures(data []byte, fn func([]byte) ([]byte, error)) ([]byte, error) {
+	var members map[string]json.RawMessage
+	if err := json.Unmarshal(data, &members); err != nil {
+		return nil, err
+	}
+	var features []json.RawMessage
+	if err := json.Unmarshal(members["features"], &features); err != nil {
+		return nil, err
+	}
+	for i, f := range features {
+		transformed, err := fn(f)
+		if err != nil {
+			return nil, err
+		}
+		features[i] = transformed
+	}
+	encoded, err := json.Marshal(features)
+	if err != nil {
+		return nil, err
+	}
+	members["features"] = encoded
+	return json.Marshal(members)
+}
+
+func init() {
+	RegisterOutputEncoder(geoJSONEncoder{}, false)
+}
+
+type geoJSONEncoder struct{}
+
+func (geoJSONEncoder) Name() string {
+	return "json"
+}
+
+func (geoJSONEncoder) MediaType() string {
+	return "application/geo+json"
+}
+
+func (geoJSONEncoder) EncodeFeatureCollection(w io.Writer, collection string, features []RawFeature) error {
+	if _, err := io.WriteString(w, `{"type":"FeatureCollection","features":[`); err != nil {
+		return err
+	}
+	for i, f := range features {
+		if i > 0 {
+			io.WriteString(w, ",")
+		}
+		if _, err := w.Write(f.JSON); err != nil {
+			return err
+		}
+	}
+	_, err := io.WriteString(w, "]}")
+	return err
+}
+
+func (geoJSONEncoder) EncodeFeature(w io.Writer, collection string, feature RawFeature) error {
+	_, err := w.Write(feature.JSON)
+	return err
+}
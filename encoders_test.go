@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type testEncoder struct{}
+
+func (testEncoder) Name() string {
+	return "test-format"
+}
+
+func (testEncoder) MediaType() string {
+	return "text/x-test"
+}
+
+func (testEncoder) EncodeFeatureCollection(w io.Writer, collection string, features []RawFeature) error {
+	for _, f := range features {
+		io.WriteString(w, getIDString(f.Feature.ID)+"\n")
+	}
+	return nil
+}
+
+func (e testEncoder) EncodeFeature(w io.Writer, collection string, feature RawFeature) error {
+	return e.EncodeFeatureCollection(w, collection, []RawFeature{feature})
+}
+
+func TestOutputEncoderRegistry(t *testing.T) {
+	RegisterOutputEncoder(testEncoder{}, true)
+	if e := GetOutputEncoder("test-format"); e != nil {
+		t.Errorf("expected experimental encoder to be disabled, got %v", e)
+	}
+	if !EnableOutputEncoder("test-format") {
+		t.Error("expected EnableOutputEncoder to succeed")
+	}
+	if e := GetOutputEncoder("test-format"); e == nil {
+		t.Error("expected enabled encoder, got nil")
+	}
+	if EnableOutputEncoder("no-such-format") {
+		t.Error("expected EnableOutputEncoder to fail for unknown format")
+	}
+	if names := strings.Join(GetOutputEncoderNames(), ","); !strings.Contains(names, "cityjson,csv,fgb,geojsonseq,gml") {
+		t.Errorf("expected cityjson, csv, fgb, geojsonseq and gml among registered encoders, got %s", names)
+	}
+
+	index, s := makeServer(t)
+	defer s.Shutdown()
+	defer index.Close()
+	query, _ := http.NewRequest("GET", "/collections/castles/items?f=test-format", nil)
+	resp := httptest.NewRecorder()
+	http.HandlerFunc(s.HandleRequest).ServeHTTP(resp, query)
+	if ct := resp.Header().Get("Content-Type"); ct != "text/x-test" {
+		t.Errorf("Expected Content-Type: text/x-test, got %s", ct)
+	}
+	if body := getBody(resp); body != "N34729562\nW418392510\nW24785843\n" {
+		t.Errorf("unexpected body: %s", body)
+	}
+
+	query, _ = http.NewRequest("GET", "/collections/castles/items?f=no-such-format", nil)
+	resp = httptest.NewRecorder()
+	http.HandlerFunc(s.HandleRequest).ServeHTTP(resp, query)
+	if status := resp.Result().StatusCode; status != http.StatusBadRequest {
+		t.Errorf("expected %d for unknown format, got %d", http.StatusBadRequest, status)
+	}
+}
+
+func TestCityJSONEncoder(t *testing.T) {
+	features, err := decodeRawFeatures([]byte(`{"type":"FeatureCollection","features":[
+		{"type":"Feature","id":"P","geometry":{"type":"Point","coordinates":[8.5,47.5]},"properties":{"name":"p"}},
+		{"type":"Feature","id":"A","geometry":{"type":"Polygon","coordinates":[[[8.5,47.5],[8.6,47.5],[8.6,47.6],[8.5,47.5]]]}}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf strings.Builder
+	if err := (cityJSONEncoder{}).EncodeFeatureCollection(&buf, "test", features); err != nil {
+		t.Fatal(err)
+	}
+
+	var doc struct {
+		Type        string `json:"type"`
+		CityObjects map[string]struct {
+			Geometry []struct {
+				Type       string          `json:"type"`
+				Boundaries json.RawMessage `json:"boundaries"`
+			} `json:"geometry"`
+		} `json:"CityObjects"`
+		Vertices [][3]int64 `json:"vertices"`
+	}
+	if err := json.Unmarshal([]byte(buf.String()), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.Type != "CityJSON" || len(doc.CityObjects) != 2 || len(doc.Vertices) != 3 {
+		t.Fatalf("unexpected CityJSON document: %s", buf.String())
+	}
+	polygon := doc.CityObjects["A"].Geometry[0]
+	if polygon.Type != "MultiSurface" || string(polygon.Boundaries) != "[[[0,1,2]]]" {
+		t.Errorf("expected MultiSurface [[[0,1,2]]], got %s %s", polygon.Type, polygon.Boundaries)
+	}
+}
+
+func TestNegotiateFormat_Features(t *testing.T) {
+	EnableOutputEncoder("gml")
+	type testCase struct {
+		Query    string
+		Accept   string
+		Expected string
+	}
+	tests := []testCase{
+		{"", "", "json"},
+		{"", "*/*", "json"},
+		{"", "application/json", "json"},
+		{"", "application/gml+xml; version=3.2", "gml"},
+		{"", "text/html, application/gml+xml;q=0.9, */*;q=0.8", "html"},
+		{"", "text/html;q=0.5, application/gml+xml;q=0.9", "gml"},
+		{"", "application/json;q=0.5, application/gml+xml", "gml"},
+		{"", "application/gml+xml;q=0", "json"},
+		{"f=geojson", "application/gml+xml", "json"},
+		{"f=gml", "application/geo+json", "gml"},
+		{"f=no-such-format", "", ""},
+	}
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/collections/castles/items?"+e.Query, nil)
+		if len(e.Accept) > 0 {
+			req.Header.Set("Accept", e.Accept)
+		}
+		got := negotiateFormat(req, featureFormats()...)
+		if got != e.Expected {
+			t.Errorf("expected \"%s\" for query \"%s\" and Accept: %s, got \"%s\"",
+				e.Expected, e.Query, e.Accept, got)
+		}
+	}
+}
+
+func TestItem_OutputFormat(t *testing.T) {
+	EnableOutputEncoder("gml")
+	index, s := makeServer(t)
+	defer s.Shutdown()
+	defer index.Close()
+	query, _ := http.NewRequest("GET", "/collections/lakes/items/N123", nil)
+	query.Header.Set("Accept", "application/gml+xml")
+	resp := httptest.NewRecorder()
+	http.HandlerFunc(s.HandleRequest).ServeHTTP(resp, query)
+	if ct := resp.Header().Get("Content-Type"); ct != "application/gml+xml; version=3.2" {
+		t.Errorf("Expected Content-Type: application/gml+xml; version=3.2, got %s", ct)
+	}
+	body := getBody(resp)
+	expectWellFormedXML(t, body)
+	if !strings.Contains(body, `<miniwfs:name>Katzensee</miniwfs:name>`) {
+		t.Errorf("expected GML feature, got %s", body)
+	}
+}
+
+func TestAPI(t *testing.T) {
+	index, s := makeServer(t)
+	defer s.Shutdown()
+	defer index.Close()
+	query, _ := http.NewRequest("GET", "/api", nil)
+	resp := httptest.NewRecorder()
+	http.HandlerFunc(s.HandleRequest).ServeHTTP(resp, query)
+	if ct := resp.Header().Get("Content-Type"); ct != "application/vnd.oai.openapi+json;version=3.0" {
+		t.Errorf("Expected OpenAPI Content-Type, got %s", ct)
+	}
+	var api struct {
+		Paths map[string]struct {
+			Get struct {
+				Responses map[string]struct {
+					Content map[string]interface{} `json:"content"`
+				} `json:"responses"`
+			} `json:"get"`
+		} `json:"paths"`
+	}
+	if err := json.Unmarshal(resp.Body.Bytes(), &api); err != nil {
+		t.Fatal(err)
+	}
+	content := api.Paths["/collections/{collectionId}/items"].Get.Responses["200"].Content
+	if _, ok := content["application/geo+json"]; !ok {
+		t.Errorf("expected application/geo+json to be advertised, got %v", content)
+	}
+}
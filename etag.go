@@ -0,0 +1,69 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"strings"
+)
+
+// featureETag returns an entity tag for the content of a feature,
+// given its encoding by json.Marshal. Computed from the feature as
+// stored, it changes whenever the feature gets edited, but not when
+// the server restarts or gets replicated. Editors send it back in
+// If-Match, so that they do not overwrite changes they have not seen.
+// https://www.rfc-editor.org/rfc/rfc9110#name-etag
+func featureETag(encoded []byte) string {
+	hash := sha256.Sum256(encoded)
+	return `"` + hex.EncodeToString(hash[:16]) + `"`
+}
+
+// itemETag returns an entity tag for a representation of a feature.
+// The full GeoJSON representation carries the feature's own tag, which
+// editors send back in If-Match; other formats, profiles and save-data
+// shapes get tags of their own, since their bytes differ.
+func itemETag(encoded []byte, format string, profile string, saveData bool) string {
+	etag := featureETag(encoded)
+	if format == "json" && profile == ProfileFull && !saveData {
+		return etag
+	}
+	return collectionETag(etag, format, profile, strconv.FormatBool(saveData))
+}
+
+// collectionETag returns an entity tag for a representation of items
+// from a collection. Version identifies the loaded generation of the
+// collection's data; variant are the parameters other than the URL
+// that shape the response, such as its format and profile, since
+// representations in different formats need different strong tags.
+func collectionETag(version string, variant ...string) string {
+	hash := sha256.Sum256([]byte(version + "\x00" + strings.Join(variant, "\x00")))
+	return `"` + hex.EncodeToString(hash[:16]) + `"`
+}
+
+// matchETag tells whether an If-Match header matches an entity tag.
+// As If-Match requires strong comparison, weak tags never match.
+// https://www.rfc-editor.org/rfc/rfc9110#name-if-match
+func matchETag(ifMatch string, etag string) bool {
+	for _, tag := range strings.Split(ifMatch, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || (tag == etag && !strings.HasPrefix(tag, "W/")) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchIfNoneMatch tells whether an If-None-Match header matches an
+// entity tag. As If-None-Match uses weak comparison, the W/ prefix of
+// weak tags gets ignored.
+// https://www.rfc-editor.org/rfc/rfc9110#name-if-none-match
+func matchIfNoneMatch(ifNoneMatch string, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, tag := range strings.Split(ifNoneMatch, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCollectionETag(t *testing.T) {
+	index, s := makeServer(t)
+	defer s.Shutdown()
+	defer index.Close()
+
+	get := func(path string, ifNoneMatch string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", path, nil)
+		if len(ifNoneMatch) > 0 {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		resp := httptest.NewRecorder()
+		http.HandlerFunc(s.HandleRequest).ServeHTTP(resp, req)
+		return resp
+	}
+
+	resp := get("/collections/castles/items", "")
+	etag := resp.Header().Get("ETag")
+	if resp.Code != http.StatusOK || len(etag) != 34 {
+		t.Fatalf("expected status %d with ETag, got %d and %q", http.StatusOK, resp.Code, etag)
+	}
+
+	for _, tc := range []struct {
+		path, ifNoneMatch string
+		status            int
+	}{
+		{"/collections/castles/items", etag, http.StatusNotModified},
+		{"/collections/castles/items", `"other", W/` + etag, http.StatusNotModified},
+		{"/collections/castles/items", "*", http.StatusNotModified},
+		{"/collections/castles/items", `"other"`, http.StatusOK},
+		{"/collections/castles/items?profile=minimal", etag, http.StatusOK},
+		{"/collections/castles/items?f=csv", etag, http.StatusOK},
+	} {
+		resp := get(tc.path, tc.ifNoneMatch)
+		if resp.Code != tc.status {
+			t.Errorf("GET %s with If-None-Match %s: expected status %d, got %d", tc.path, tc.ifNoneMatch, tc.status, resp.Code)
+		}
+		if resp.Code == http.StatusNotModified && resp.Header().Get("ETag") != etag {
+			t.Errorf("GET %s: expected ETag %s with status %d, got %q", tc.path, etag, resp.Code, resp.Header().Get("ETag"))
+		}
+	}
+}
+
+func TestItemETag(t *testing.T) {
+	index, s := makeServer(t)
+	defer s.Shutdown()
+	defer index.Close()
+
+	seen := make(map[string]string)
+	for _, path := range []string{
+		"/collections/castles/items/N34729562?f=json",
+		"/collections/castles/items/N34729562?f=html",
+		"/collections/castles/items/N34729562?f=csv",
+		"/collections/castles/items/N34729562?f=json&profile=minimal",
+	} {
+		req, _ := http.NewRequest("GET", path, nil)
+		resp := httptest.NewRecorder()
+		http.HandlerFunc(s.HandleRequest).ServeHTTP(resp, req)
+		etag := resp.Header().Get("ETag")
+		if resp.Code != http.StatusOK || len(etag) != 34 {
+			t.Fatalf("GET %s: expected status %d with ETag, got %d and %q", path, http.StatusOK, resp.Code, etag)
+		}
+		if other, ok := seen[etag]; ok {
+			t.Errorf("GET %s: expected its own ETag, got the same as %s", path, other)
+		}
+		seen[etag] = path
+	}
+
+	// Editors send back the ETag of the canonical GeoJSON representation.
+	feature, err := index.GetItem("castles", "N34729562")
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded, _ := json.Marshal(feature)
+	if path := seen[featureETag(encoded)]; path != "/collections/castles/items/N34729562?f=json" {
+		t.Errorf("expected the feature's own ETag on GeoJSON, got it on %q", path)
+	}
+}
+
+func TestMatchETag(t *testing.T) {
+	for _, tc := range []struct {
+		ifMatch, etag string
+		expected      bool
+	}{
+		{`"a"`, `"a"`, true},
+		{`"b"`, `"a"`, false},
+		{`"b", "a"`, `"a"`, true},
+		{`*`, `"a"`, true},
+		{`W/"a"`, `W/"a"`, false},
+		{``, `"a"`, false},
+	} {
+		if got := matchETag(tc.ifMatch, tc.etag); got != tc.expected {
+			t.Errorf("matchETag(%q, %q): expected %v, got %v", tc.ifMatch, tc.etag, tc.expected, got)
+		}
+	}
+}
+
+func TestMatchIfNoneMatch(t *testing.T) {
+	for _, tc := range []struct {
+		ifNoneMatch, etag string
+		expected          bool
+	}{
+		{`"a"`, `"a"`, true},
+		{`W/"a"`, `"a"`, true},
+		{`"b", "a"`, `"a"`, true},
+		{`*`, `"a"`, true},
+		{`"b"`, `"a"`, false},
+	} {
+		if got := matchIfNoneMatch(tc.ifNoneMatch, tc.etag); got != tc.expected {
+			t.Errorf("matchIfNoneMatch(%q, %q): expected %v, got %v", tc.ifNoneMatch, tc.etag, tc.expected, got)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"text/template"
+)
+
+// Example snippets for integrating a collection into web maps. URLs
+// are inserted as JSON string literals, which are valid JavaScript
+// and cannot break out of the surrounding script.
+
+var leafletExample = template.Must(template.New("leaflet").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<link rel="stylesheet" href="https://unpkg.com/leaflet@1.9.4/dist/leaflet.css">
+<script src="https://unpkg.com/leaflet@1.9.4/dist/leaflet.js"></script>
+</head>
+<body>
+<div id="map" style="height: 500px"></div>
+<script>
+var map = L.map('map').setView([0, 0], 2);
+L.tileLayer('https://tile.openstreetmap.org/{z}/{x}/{y}.png', {
+  attribution: '&copy; OpenStreetMap contributors'
+}).addTo(map);
+var layer = L.geoJSON().addTo(map);
+
+// Follow "next" links until all pages have been loaded.
+function loadPage(url) {
+  fetch(url).then(function(resp) { return resp.json(); }).then(function(page) {
+    layer.addData(page);
+    var next = (page.links || []).find(function(link) { return link.rel === 'next'; });
+    if (next) {
+      loadPage(next.href);
+    } else if (layer.getBounds().isValid()) {
+      map.fitBounds(layer.getBounds());
+    }
+  });
+}
+loadPage({{.ItemsURL}});
+</script>
+</body>
+</html>
+`))
+
+var openLayersExample = template.Must(template.New("openlayers").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<link rel="stylesheet" href="https://cdn.jsdelivr.net/npm/ol@v9.2.4/ol.css">
+<script src="https://cdn.jsdelivr.net/npm/ol@v9.2.4/dist/ol.js"></script>
+</head>
+<body>
+<div id="map" style="height: 500px"></div>
+<script>
+var map = new ol.Map({
+  target: 'map',
+  layers: [
+    new ol.layer.Tile({source: new ol.source.OSM()}),
+    new ol.layer.Tile({source: new ol.source.XYZ({url: {{.TilesURL}}})}),
+    new ol.layer.Vector({
+      source: new ol.source.Vector({
+        url: {{.ItemsURL}},
+        format: new ol.format.GeoJSON()
+      })
+    })
+  ],
+  view: new ol.View({center: [0, 0], zoom: 2})
+});
+</script>
+</body>
+</html>
+`))
+
+type exampleURLs struct {
+	ItemsURL string
+	TilesURL string
+}
+
+func (s *WebServer) handleExamplesRequest(w http.ResponseWriter, req *http.Request, collection string) {
+	if !s.authorize(w, req, collection) {
+		return
+	}
+	if !s.index.HasCollection(collection) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	prefix := s.publicPath(req)
+	itemsURL := prefix + "collections/" + url.PathEscape(collection) + "/items?limit=" + strconv.Itoa(MaxLimit)
+	tilesURL := prefix + "tiles/" + url.PathEscape(collection) + "/{z}/{x}/{y}.png"
+
+	quoted := exampleURLs{ItemsURL: jsString(itemsURL), TilesURL: jsString(tilesURL)}
+	var leaflet, openLayers bytes.Buffer
+	if err := leafletExample.Execute(&leaflet, quoted); err != nil {
+		log.Printf("leaflet example failed: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if err := openLayersExample.Execute(&openLayers, quoted); err != nil {
+		log.Printf("openlayers example failed: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	encoded, err := json.Marshal(map[string]interface{}{
+		"collection":    collection,
+		"maplibreStyle": s.makeMapLibreStyle(prefix, collection),
+		"leaflet":       leaflet.String(),
+		"openlayers":    openLayers.String(),
+	})
+	if err != nil {
+		log.Printf("json.Marshal failed: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(encoded)
+}
+
+// jsString quotes a string as a JavaScript string literal. We also
+// escape "<", so that the literal cannot close a surrounding <script>.
+func jsString(s string) string {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.Encode(s) // json.Encoder escapes <, > and & by default
+	return string(bytes.TrimSpace(buf.Bytes()))
+}
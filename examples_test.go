@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestExamples(t *testing.T) {
+	index, s := makeServer(t)
+	defer s.Shutdown()
+	defer index.Close()
+	query, _ := http.NewRequest("GET", "/collections/castles/examples", nil)
+	resp := httptest.NewRecorder()
+	http.HandlerFunc(s.HandleRequest).ServeHTTP(resp, query)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", resp.Code)
+	}
+	expectCORSHeader(t, resp.Header())
+
+	var examples struct {
+		MapLibreStyle struct {
+			Version int `json:"version"`
+			Sources map[string]struct {
+				Data string `json:"data"`
+			} `json:"sources"`
+		} `json:"maplibreStyle"`
+		Leaflet    string `json:"leaflet"`
+		OpenLayers string `json:"openlayers"`
+	}
+	if err := json.Unmarshal(resp.Body.Bytes(), &examples); err != nil {
+		t.Fatal(err)
+	}
+
+	itemsURL := "https://test.example.org/wfs/collections/castles/items?limit=10000"
+	tilesURL := "https://test.example.org/wfs/tiles/castles/{z}/{x}/{y}.png"
+	style := examples.MapLibreStyle
+	if style.Version != 8 || style.Sources["castles"].Data != itemsURL {
+		t.Errorf("unexpected MapLibre style: %+v", style)
+	}
+	if !strings.Contains(examples.Leaflet, `loadPage("`+itemsURL+`");`) {
+		t.Errorf("expected Leaflet example to load %s, got %s", itemsURL, examples.Leaflet)
+	}
+	if !strings.Contains(examples.OpenLayers, `url: "`+tilesURL+`"`) {
+		t.Errorf("expected OpenLayers example to use %s, got %s", tilesURL, examples.OpenLayers)
+	}
+
+	query, _ = http.NewRequest("GET", "/collections/nosuchcollection/examples", nil)
+	resp = httptest.NewRecorder()
+	http.HandlerFunc(s.HandleRequest).ServeHTTP(resp, query)
+	if resp.Code != http.StatusNotFound {
+		t.Errorf("expected status 404 for unknown collection, got %d", resp.Code)
+	}
+}
+
+func TestJSString(t *testing.T) {
+	if got := jsString(`a"b</script>`); got != `"a\"b\u003c/script\u003e"` {
+		t.Errorf("unexpected quoting: %s", got)
+	}
+}
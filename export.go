@@ -0,0 +1,356 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/golang/geo/r2"
+	"github.com/golang/geo/s2"
+)
+
+// Export writes a static snapshot of the entire API into directory dir,
+// so it can be served from object storage without running a server.
+// Static hosting cannot serve query parameters, so item pages link to
+// each other by file name, and links to collections point to their
+// exported documents. Tiles are written up to zoom level maxZoom,
+// skipping tiles that would be empty. Unlisted and private collections
+// are not exported, and neither are canaries. Items are also exported in every other enabled
+// output format, using the format name as file extension.
+//
+//	collections.json
+//	collections/{name}.json
+//	collections/{name}/queryables.json
+//	collections/{name}/items-{page}.geojson
+//	collections/{name}/items/{id}.geojson
+//	tiles/{name}/{zoom}/{x}/{y}.png
+func Export(server *WebServer, dir string, maxZoom int) error {
+	if maxZoom < 0 || maxZoom > 30 {
+		return fmt.Errorf("maxZoom out of range: %d", maxZoom)
+	}
+
+	// Links in exported documents start with the same prefix as in
+	// responses to exportRequest.
+	index := server.index
+	req, err := http.NewRequest("GET", "/", nil)
+	if err != nil {
+		return err
+	}
+	prefix := server.publicPath(req)
+	var exported []string
+	links := map[string]string{prefix + "collections": prefix + "collections.json"}
+	for _, md := range server.listedCollectionsByGroup() {
+		exported = append(exported, md.Name)
+		p := prefix + "collections/" + url.PathEscape(md.Name)
+		links[p] = p + ".json"
+		links[p+"/queryables"] = p + "/queryables.json"
+	}
+
+	collectionsJSON, err := exportRequest(server, "/collections")
+	if err != nil {
+		return err
+	}
+	if collectionsJSON, err = rewriteExportLinks(collectionsJSON, links, nil); err != nil {
+		return err
+	}
+	if err := writeExportFile(dir, "collections.json", collectionsJSON); err != nil {
+		return err
+	}
+
+	for _, name := range exported {
+		collectionJSON, err := exportRequest(server, "/collections/"+url.PathEscape(name))
+		if err != nil {
+			return err
+		}
+		items := &WFSLink{
+			Href:  prefix + "collections/" + url.PathEscape(name) + "/items-1.geojson",
+			Rel:   "items",
+			Type:  "application/geo+json",
+			Title: name,
+		}
+		if collectionJSON, err = rewriteExportLinks(collectionJSON, links, items); err != nil {
+			return err
+		}
+		if err := writeExportFile(dir, filepath.Join("collections", name+".json"), collectionJSON); err != nil {
+			return err
+		}
+		queryablesJSON, err := exportRequest(server, "/collections/"+url.PathEscape(name)+"/queryables")
+		if err != nil {
+			return err
+		}
+		if err := writeExportFile(dir, filepath.Join("collections", name, "queryables.json"), queryablesJSON); err != nil {
+			return err
+		}
+		if err := exportCollection(index, server, dir, name, prefix, maxZoom); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rewriteExportLinks replaces the targets of links in an exported JSON
+// document, and adds a link to the document if extra is not nil.
+func rewriteExportLinks(doc []byte, replace map[string]string, extra *WFSLink) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(doc))
+	decoder.UseNumber()
+	var parsed map[string]interface{}
+	if err := decoder.Decode(&parsed); err != nil {
+		return nil, err
+	}
+	var rewrite func(v interface{})
+	rewrite = func(v interface{}) {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			if href, ok := v["href"].(string); ok {
+				if r, ok := replace[href]; ok {
+					v["href"] = r
+				}
+			}
+			for _, child := range v {
+				rewrite(child)
+			}
+		case []interface{}:
+			for _, child := range v {
+				rewrite(child)
+			}
+		}
+	}
+	rewrite(parsed)
+	if extra != nil {
+		links, _ := parsed["links"].([]interface{})
+		parsed["links"] = append(links, extra)
+	}
+	return json.Marshal(parsed)
+}
+
+// exportPageLinks returns the links of an exported page of items.
+func exportPageLinks(prefix string, collection string, page int, numPages int) []*WFSLink {
+	link := func(rel, title string, p int) *WFSLink {
+		return &WFSLink{
+			Href:  fmt.Sprintf("%scollections/%s/items-%d.geojson", prefix, url.PathEscape(collection), p),
+			Rel:   rel,
+			Type:  "application/geo+json",
+			Title: title,
+		}
+	}
+	links := []*WFSLink{link("self", "self", page)}
+	if page > 1 {
+		links = append(links, link("prev", "previous", page-1))
+	}
+	if page < numPages {
+		links = append(links, link("next", "next", page+1))
+	}
+	return append(links, link("first", "first", 1), link("last", "last", numPages))
+}
+
+func exportCollection(index *Index, server *WebServer, dir string, collection string, prefix string, maxZoom int) error {
+	ids, points, numLocated := index.getExportData(collection)
+
+	// Besides GeoJSON, we export items in all other enabled formats.
+	var encoders []OutputEncoder
+	for _, e := range GetEnabledOutputEncoders() {
+		if _, isGeoJSON := e.(geoJSONEncoder); !isGeoJSON {
+			encoders = append(encoders, e)
+		}
+	}
+
+	preview, _, err := index.GetPreview(collection)
+	if err != nil {
+		return err
+	}
+	if err := writeExportFile(dir, filepath.Join("collections", collection, "preview.png"), preview); err != nil {
+		return err
+	}
+
+	var noTime time.Time
+	// Pages list the features with a geometry, like items requests
+	// without a bbox; the others can only be fetched by ID.
+	numPages := (numLocated + MaxLimit - 1) / MaxLimit
+	if numPages == 0 {
+		numPages = 1
+	}
+	for page, start := 1, 0; page <= numPages; page, start = page+1, start+MaxLimit {
+		var buf bytes.Buffer
+		includeDeleted := false
+		_, _, err := index.GetItems(collection, itemsQuery{itemsSelection: itemsSelection{bbox: s2.FullRect()}, start: start, limit: MaxLimit},
+			noTime, noTime, "", includeDeleted, &buf)
+		if err != nil {
+			return err
+		}
+
+		// Pages get generated without links, since their usual links
+		// have query parameters; we add links to the exported pages.
+		var members map[string]json.RawMessage
+		if err := json.Unmarshal(buf.Bytes(), &members); err != nil {
+			return err
+		}
+		links, err := json.Marshal(exportPageLinks(prefix, collection, page, numPages))
+		if err != nil {
+			return err
+		}
+		members["links"] = links
+		encodedPage, err := json.Marshal(members)
+		if err != nil {
+			return err
+		}
+		name := fmt.Sprintf("items-%d.geojson", page)
+		if err := writeExportFile(dir, filepath.Join("collections", collection, name), encodedPage); err != nil {
+			return err
+		}
+
+		if len(encoders) == 0 {
+			continue
+		}
+		features, err := decodeRawFeatures(buf.Bytes())
+		if err != nil {
+			return err
+		}
+		for _, e := range encoders {
+			var encoded bytes.Buffer
+			if err := e.EncodeFeatureCollection(&encoded, collection, features); err != nil {
+				return err
+			}
+			name := fmt.Sprintf("items-%d.%s", page, e.Name())
+			if err := writeExportFile(dir, filepath.Join("collections", collection, name), encoded.Bytes()); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, id := range ids {
+		if len(id) == 0 {
+			continue
+		}
+		p := "/collections/" + url.PathEscape(collection) + "/items/" + EscapeItemID(id)
+		item, err := exportRequest(server, p)
+		if err != nil {
+			return err
+		}
+		name := filepath.Join("collections", collection, "items", EscapeItemID(id)+".geojson")
+		if err := writeExportFile(dir, name, item); err != nil {
+			return err
+		}
+
+		for _, e := range encoders {
+			item, err := exportRequest(server, p+"?f="+url.QueryEscape(e.Name()))
+			if err != nil {
+				return err
+			}
+			name := filepath.Join("collections", collection, "items", EscapeItemID(id)+"."+e.Name())
+			if err := writeExportFile(dir, name, item); err != nil {
+				return err
+			}
+		}
+	}
+
+	for zoom := 0; zoom <= maxZoom; zoom++ {
+		for key := range getExportTiles(points, zoom) {
+			tile, _, err := index.GetTile(collection, key)
+			if err != nil {
+				return err
+			}
+			name := filepath.Join("tiles", collection, fmt.Sprint(zoom), fmt.Sprint(key.X), fmt.Sprintf("%d.png", key.Y))
+			if err := writeExportFile(dir, name, tile); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// getExportData returns the IDs and projected points of the features
+// in a collection, and how many of them have a geometry.
+func (index *Index) getExportData(collection string) ([]string, []r2.Point, int) {
+	index.mutex.RLock()
+	defer index.mutex.RUnlock()
+
+	coll := index.Collections[collection]
+	if coll == nil {
+		return nil, nil, 0
+	}
+	ids := make([]string, coll.numFeatures())
+	for i := range ids {
+		ids[i] = coll.featureID(i)
+	}
+	points := make([]r2.Point, len(ids))
+	copy(points, coll.webMercatorPoints())
+	return ids, points, coll.numLocated
+}
+
+// getExportTiles returns the keys of all tiles at the given zoom level
+// that contain at least one rendered point. Because points are drawn
+// with a radius, we also include neighboring tiles when a point is
+// close to the tile border.
+func getExportTiles(points []r2.Point, zoom int) map[TileKey]bool {
+	const margin = 4.0 // pixels
+	scale := math.Exp2(float64(zoom)) * TileSize
+	numTiles := int64(1) << uint(zoom)
+	tiles := make(map[TileKey]bool)
+	for _, p := range points {
+		px, py := p.X*scale, p.Y*scale
+		for _, dx := range []float64{-margin, 0, margin} {
+			for _, dy := range []float64{-margin, 0, margin} {
+				x := int64(math.Floor((px + dx) / TileSize))
+				y := int64(math.Floor((py + dy) / TileSize))
+				if x >= 0 && y >= 0 && x < numTiles && y < numTiles {
+					tiles[TileKey{X: uint32(x), Y: uint32(y), Zoom: uint8(zoom)}] = true
+				}
+			}
+		}
+	}
+	return tiles
+}
+
+// exportResponse is a minimal http.ResponseWriter that keeps the
+// response in memory, so we can export exactly what the server would
+// send to clients.
+type exportResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (r *exportResponse) Header() http.Header {
+	return r.header
+}
+
+func (r *exportResponse) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.body.Write(b)
+}
+
+func (r *exportResponse) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+}
+
+func exportRequest(server *WebServer, path string) ([]byte, error) {
+	req, err := http.NewRequest("GET", path, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp := &exportResponse{header: make(http.Header)}
+	server.HandleRequest(resp, req)
+	if resp.status != http.StatusOK {
+		return nil, fmt.Errorf("exporting %s: got HTTP status %d", path, resp.status)
+	}
+	return resp.body.Bytes(), nil
+}
+
+func writeExportFile(dir string, name string, content []byte) error {
+	path := filepath.Join(dir, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, content, 0644)
+}
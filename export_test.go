@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExport(t *testing.T) {
+	index := loadTestIndex(t)
+	defer index.Close()
+
+	dir, err := ioutil.TempDir("", "miniwfs-export-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	server := MakeWebServer(index)
+	server.access = MakeAccessControl(nil, nil, nil)
+	server.access.SetUnlisted([]string{"lakes"})
+	if err := Export(server, dir, 3); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{
+		"collections.json",
+		"collections/castles.json",
+		"collections/castles/items-1.geojson",
+		"collections/castles/items/W418392510.geojson",
+		"collections/castles/preview.png",
+		"collections/castles/queryables.json",
+		"tiles/castles/0/0/0.png",
+		"tiles/castles/3/4/2.png",
+	} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("expected exported file %s, got %v", name, err)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "collections/lakes")); err == nil {
+		t.Error("expected unlisted collection lakes to be skipped")
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "tiles/castles/3/0/0.png")); err == nil {
+		t.Error("expected empty tile tiles/castles/3/0/0.png to be skipped")
+	}
+
+	b, _ := ioutil.ReadFile(filepath.Join(dir, "collections/castles/items-1.geojson"))
+	var page WFSFeatureCollection
+	if err := json.Unmarshal(b, &page); err != nil {
+		t.Fatal(err)
+	}
+	if got := getFeatureIDs(page.Features); got != "N34729562,W418392510,W24785843" {
+		t.Errorf("expected all castles in first page, got %s", got)
+	}
+
+	// Links must point to exported files, so the snapshot can be
+	// navigated on static hosting.
+	var links []*WFSLink
+	for _, name := range []string{"collections.json", "collections/castles.json", "collections/castles/items-1.geojson"} {
+		var doc struct {
+			Links       []*WFSLink `json:"links"`
+			Collections []struct {
+				Links []*WFSLink `json:"links"`
+			} `json:"collections"`
+		}
+		b, _ := ioutil.ReadFile(filepath.Join(dir, name))
+		if err := json.Unmarshal(b, &doc); err != nil {
+			t.Fatal(err)
+		}
+		links = append(links, doc.Links...)
+		for _, c := range doc.Collections {
+			links = append(links, c.Links...)
+		}
+	}
+	if len(links) < 8 {
+		t.Errorf("expected links in exported documents, got %d", len(links))
+	}
+	for _, link := range links {
+		name := strings.TrimPrefix(link.Href, "https://test.example.org/wfs/")
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("link %s %s: expected exported file %s, got %v", link.Rel, link.Href, name, err)
+		}
+	}
+}
+
+func TestExport_FeaturesWithoutGeometry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "miniwfs-export-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Items pages skip features without geometry, so these must not
+	// make up pages of their own.
+	var buf bytes.Buffer
+	buf.WriteString(`{"type":"Feature","id":"N1","geometry":{"type":"Point","coordinates":[8.5,47.4]},"properties":{}}` + "\n")
+	for i := 0; i < MaxLimit; i++ {
+		buf.WriteString(`{"type":"Feature","geometry":null,"properties":{}}` + "\n")
+	}
+	path := filepath.Join(dir, "things.geojsonl")
+	if err := ioutil.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	publicPath, _ := url.Parse("https://test.example.org/wfs/")
+	index, err := MakeIndex(map[string]string{"things": path}, publicPath, IndexOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer index.Close()
+
+	out := filepath.Join(dir, "export")
+	if err := Export(MakeWebServer(index), out, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(out, "collections/things/items-1.geojson")); err != nil {
+		t.Errorf("expected first page, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(out, "collections/things/items-2.geojson")); err == nil {
+		t.Error("expected no second page, since all located features fit on the first")
+	}
+}
+
+func TestExport_Canary(t *testing.T) {
+	index, server := makeCanaryServer(t)
+	defer index.Close()
+	dir, err := ioutil.TempDir("", "miniwfs-export-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := Export(server, dir, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "collections/castles.json")); err != nil {
+		t.Errorf("expected collection castles to be exported, got %v", err)
+	}
+	for _, name := range []string{"collections/castles-next.json", "collections/castles-next", "tiles/castles-next"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			t.Errorf("expected canary to be skipped, but %s got exported", name)
+		}
+	}
+}
+
+func TestExportPageLinks(t *testing.T) {
+	got, _ := json.Marshal(exportPageLinks("https://example.org/", "castles", 2, 3))
+	expectJSON(t, string(got), `[
+		{"href": "https://example.org/collections/castles/items-2.geojson", "rel": "self", "type": "application/geo+json", "title": "self"},
+		{"href": "https://example.org/collections/castles/items-1.geojson", "rel": "prev", "type": "application/geo+json", "title": "previous"},
+		{"href": "https://example.org/collections/castles/items-3.geojson", "rel": "next", "type": "application/geo+json", "title": "next"},
+		{"href": "https://example.org/collections/castles/items-1.geojson", "rel": "first", "type": "application/geo+json", "title": "first"},
+		{"href": "https://example.org/collections/castles/items-3.geojson", "rel": "last", "type": "application/geo+json", "title": "last"}
+	]`)
+}
@@ -0,0 +1,563 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"math"
+	"sort"
+
+	"github.com/paulmach/go.geojson"
+)
+
+// FlatGeobuf encoding of features, https://flatgeobuf.org/, which QGIS
+// and GDAL can read in a streaming fashion. Features are written along
+// a Hilbert curve and preceded by a packed Hilbert R-tree, so clients
+// can fetch the parts they need with HTTP range requests. The format is
+// built from FlatBuffers; since we only write a handful of tables, we
+// encode them ourselves instead of pulling in the FlatBuffers compiler.
+
+func init() {
+	RegisterOutputEncoder(fgbEncoder{}, false)
+}
+
+var fgbMagic = []byte{'f', 'g', 'b', 3, 'f', 'g', 'b', 0}
+
+// fgbIndexNodeSize is the number of children per node of the spatial
+// index; 16 is the default of the reference implementation.
+const fgbIndexNodeSize = 16
+
+// FlatGeobuf geometry types.
+const (
+	fgbUnknown            = 0
+	fgbPoint              = 1
+	fgbLineString         = 2
+	fgbPolygon            = 3
+	fgbMultiPoint         = 4
+	fgbMultiLineString    = 5
+	fgbMultiPolygon       = 6
+	fgbGeometryCollection = 7
+)
+
+// FlatGeobuf column types, of which we use those for JSON values.
+const (
+	fgbColumnBool   = 2
+	fgbColumnDouble = 10
+	fgbColumnString = 11
+	fgbColumnJSON   = 12
+)
+
+type fgbEncoder struct{}
+
+func (fgbEncoder) Name() string {
+	return "fgb"
+}
+
+func (fgbEncoder) MediaType() string {
+	return "application/flatgeobuf"
+}
+
+type fgbColumn struct {
+	name  string
+	ctype uint8
+	isID  bool // true for the column holding feature IDs
+}
+
+func (fgbEncoder) EncodeFeatureCollection(w io.Writer, collection string, rawFeatures []RawFeature) error {
+	features := make([]*geojson.Feature, len(rawFeatures))
+	for i, f := range rawFeatures {
+		features[i] = f.Feature
+	}
+	sortFeatures(features, ItemOrderHilbert)
+
+	columns := fgbColumns(features)
+	geometryType := -1
+	hasIndex := len(features) > 0
+	extent := emptyFGBBounds()
+	bounds := make([]fgbBounds, len(features))
+	var data bytes.Buffer
+	offsets := make([]uint64, len(features))
+	for i, f := range features {
+		gt := fgbUnknown
+		if f.Geometry != nil {
+			gt = fgbGeometryType(f.Geometry)
+			bounds[i] = emptyFGBBounds()
+			forEachCoord(f.Geometry, bounds[i].add)
+		}
+		if bounds[i].isEmpty() {
+			hasIndex = false
+		} else {
+			extent.union(bounds[i])
+		}
+		if geometryType < 0 {
+			geometryType = gt
+		} else if geometryType != gt {
+			geometryType = fgbUnknown
+		}
+		offsets[i] = uint64(data.Len())
+		data.Write(fgbFeature(f, columns))
+	}
+	if geometryType < 0 {
+		geometryType = fgbUnknown
+	}
+
+	header := make(fbTable, 11)
+	header[0] = fbString(collection)
+	if !extent.isEmpty() {
+		header[1] = fbFloat64s([]float64{extent.minX, extent.minY, extent.maxX, extent.maxY})
+	}
+	header[2] = fbUint8(uint8(geometryType))
+	if len(columns) > 0 {
+		tables := make(fbTables, len(columns))
+		for i, c := range columns {
+			tables[i] = fbTable{fbString(c.name), fbUint8(c.ctype)}
+		}
+		header[7] = tables
+	}
+	header[8] = fbUint64(uint64(len(features)))
+	if hasIndex {
+		header[9] = fbUint16(fgbIndexNodeSize)
+	} else {
+		header[9] = fbUint16(0)
+	}
+	header[10] = fbTable{fbString("EPSG"), fbInt32(4326)}
+
+	if _, err := w.Write(fgbMagic); err != nil {
+		return err
+	}
+	if _, err := w.Write(fbFinish(header)); err != nil {
+		return err
+	}
+	if hasIndex {
+		if _, err := w.Write(fgbPackedRTree(bounds, offsets)); err != nil {
+			return err
+		}
+	}
+	_, err := w.Write(data.Bytes())
+	return err
+}
+
+func (e fgbEncoder) EncodeFeature(w io.Writer, collection string, feature RawFeature) error {
+	return e.EncodeFeatureCollection(w, collection, []RawFeature{feature})
+}
+
+// fgbColumns determines the columns for the properties of features.
+// Properties whose values are all strings, numbers or booleans get a
+// column of that type; anything else is written as JSON. Feature IDs go
+// into an "id" column, unless there is a property of that name.
+func fgbColumns(features []*geojson.Feature) []fgbColumn {
+	types := make(map[string]uint8)
+	hasIDs := false
+	for _, f := range features {
+		hasIDs = hasIDs || f.ID != nil
+		for key, value := range f.Properties {
+			var t uint8
+			switch value.(type) {
+			case nil:
+				continue
+			case string:
+				t = fgbColumnString
+			case float64:
+				t = fgbColumnDouble
+			case bool:
+				t = fgbColumnBool
+			default:
+				t = fgbColumnJSON
+			}
+			if old, ok := types[key]; ok && old != t {
+				t = fgbColumnJSON
+			}
+			types[key] = t
+		}
+	}
+
+	keys := make([]string, 0, len(types))
+	for key := range types {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var columns []fgbColumn
+	if _, ok := types["id"]; hasIDs && !ok {
+		columns = append(columns, fgbColumn{"id", fgbColumnString, true})
+	}
+	for _, key := range keys {
+		columns = append(columns, fgbColumn{key, types[key], false})
+	}
+	return columns
+}
+
+// fgbFeature encodes a feature into a size-prefixed FlatBuffer.
+func fgbFeature(f *geojson.Feature, columns []fgbColumn) []byte {
+	var props []byte
+	for i, c := range columns {
+		value := f.Properties[c.name]
+		if c.isID && f.ID != nil {
+			value = getIDString(f.ID)
+		}
+		if value == nil {
+			continue
+		}
+		props = appendUint16(props, uint16(i))
+		switch c.ctype {
+		case fgbColumnString:
+			props = appendFGBString(props, value.(string))
+		case fgbColumnDouble:
+			props = appendUint64(props, math.Float64bits(value.(float64)))
+		case fgbColumnBool:
+			if value.(bool) {
+				props = append(props, 1)
+			} else {
+				props = append(props, 0)
+			}
+		default:
+			encoded, _ := json.Marshal(value)
+			props = appendFGBString(props, string(encoded))
+		}
+	}
+
+	feature := make(fbTable, 2)
+	if f.Geometry != nil {
+		feature[0] = fgbGeometry(f.Geometry)
+	}
+	if len(props) > 0 {
+		feature[1] = fbVector{1, props}
+	}
+	return fbFinish(feature)
+}
+
+func fgbGeometryType(g *geojson.Geometry) int {
+	switch g.Type {
+	case geojson.GeometryPoint:
+		return fgbPoint
+	case geojson.GeometryLineString:
+		return fgbLineString
+	case geojson.GeometryPolygon:
+		return fgbPolygon
+	case geojson.GeometryMultiPoint:
+		return fgbMultiPoint
+	case geojson.GeometryMultiLineString:
+		return fgbMultiLineString
+	case geojson.GeometryMultiPolygon:
+		return fgbMultiPolygon
+	case geojson.GeometryCollection:
+		return fgbGeometryCollection
+	}
+	return fgbUnknown
+}
+
+// fgbGeometry builds the FlatBuffers table for a geometry. Coordinates
+// are flattened into xy, with the end of each ring or line in ends;
+// multipolygons and collections are made of parts.
+func fgbGeometry(g *geojson.Geometry) fbTable {
+	t := make(fbTable, 8)
+	var lines [][][]float64
+	switch g.Type {
+	case geojson.GeometryPoint:
+		lines = [][][]float64{{g.Point}}
+	case geojson.GeometryLineString:
+		lines = [][][]float64{g.LineString}
+	case geojson.GeometryMultiPoint:
+		lines = [][][]float64{g.MultiPoint}
+	case geojson.GeometryPolygon:
+		lines = g.Polygon
+	case geojson.GeometryMultiLineString:
+		lines = g.MultiLineString
+	case geojson.GeometryMultiPolygon:
+		parts := make(fbTables, len(g.MultiPolygon))
+		for i, poly := range g.MultiPolygon {
+			parts[i] = fgbGeometry(&geojson.Geometry{Type: geojson.GeometryPolygon, Polygon: poly})
+		}
+		t[7] = parts
+	case geojson.GeometryCollection:
+		parts := make(fbTables, 0, len(g.Geometries))
+		for _, part := range g.Geometries {
+			if part != nil {
+				parts = append(parts, fgbGeometry(part))
+			}
+		}
+		t[7] = parts
+	}
+
+	if lines != nil {
+		var xy []float64
+		var ends []uint32
+		for _, line := range lines {
+			for _, c := range line {
+				if len(c) >= 2 {
+					xy = append(xy, c[0], c[1])
+				}
+			}
+			ends = append(ends, uint32(len(xy)/2))
+		}
+		if len(ends) > 1 {
+			t[0] = fbUint32s(ends)
+		}
+		t[1] = fbFloat64s(xy)
+	}
+	t[6] = fbUint8(uint8(fgbGeometryType(g)))
+	return t
+}
+
+func appendFGBString(b []byte, s string) []byte {
+	b = appendUint32(b, uint32(len(s)))
+	return append(b, s...)
+}
+
+// fgbBounds is a planar bounding box in degrees.
+type fgbBounds struct {
+	minX, minY, maxX, maxY float64
+}
+
+func emptyFGBBounds() fgbBounds {
+	return fgbBounds{math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)}
+}
+
+func (b fgbBounds) isEmpty() bool {
+	return b.minX > b.maxX
+}
+
+func (b *fgbBounds) add(c []float64) {
+	if len(c) < 2 {
+		return
+	}
+	b.minX, b.maxX = math.Min(b.minX, c[0]), math.Max(b.maxX, c[0])
+	b.minY, b.maxY = math.Min(b.minY, c[1]), math.Max(b.maxY, c[1])
+}
+
+func (b *fgbBounds) union(o fgbBounds) {
+	if o.isEmpty() {
+		return
+	}
+	b.add([]float64{o.minX, o.minY})
+	b.add([]float64{o.maxX, o.maxY})
+}
+
+// fgbPackedRTree builds the spatial index of FlatGeobuf, a packed
+// R-tree stored level by level with the root first. Each node has a
+// bounding box and an offset: for leaves, the byte offset of a feature
+// within the feature data; for inner nodes, the position of their
+// first child in the node array. The features must already be sorted.
+func fgbPackedRTree(bounds []fgbBounds, offsets []uint64) []byte {
+	// Number of nodes per level, starting with the leaves.
+	levelSizes := []int{len(bounds)}
+	numNodes := len(bounds)
+	for n := len(bounds); ; {
+		n = (n + fgbIndexNodeSize - 1) / fgbIndexNodeSize
+		levelSizes = append(levelSizes, n)
+		numNodes += n
+		if n == 1 {
+			break
+		}
+	}
+	levelStarts := make([]int, len(levelSizes))
+	for i, end := 0, numNodes; i < len(levelSizes); i++ {
+		end -= levelSizes[i]
+		levelStarts[i] = end
+	}
+
+	nodes := make([]fgbBounds, numNodes)
+	nodeOffsets := make([]uint64, numNodes)
+	for i := range bounds {
+		nodes[levelStarts[0]+i] = bounds[i]
+		nodeOffsets[levelStarts[0]+i] = offsets[i]
+	}
+	for level := 0; level < len(levelSizes)-1; level++ {
+		parent := levelStarts[level+1]
+		end := levelStarts[level] + levelSizes[level]
+		for pos := levelStarts[level]; pos < end; parent++ {
+			nodes[parent] = emptyFGBBounds()
+			nodeOffsets[parent] = uint64(pos)
+			for j := 0; j < fgbIndexNodeSize && pos < end; j++ {
+				nodes[parent].union(nodes[pos])
+				pos++
+			}
+		}
+	}
+
+	result := make([]byte, 0, numNodes*40)
+	for i, n := range nodes {
+		for _, v := range []float64{n.minX, n.minY, n.maxX, n.maxY} {
+			result = appendUint64(result, math.Float64bits(v))
+		}
+		result = appendUint64(result, nodeOffsets[i])
+	}
+	return result
+}
+
+// Minimal FlatBuffers encoder. Unlike the reference implementation,
+// which builds buffers back to front, we write objects front to back:
+// each table is preceded by its vtable and followed by the strings,
+// vectors and tables it refers to, since references must point forward.
+// Alignment is relative to the start of the size prefix, as with the
+// reference implementation.
+
+// fbTable holds the field values of a table, indexed by field slot, or
+// nil for absent fields. Values are fbScalar, fbString, fbVector,
+// fbTables or fbTable.
+type fbTable []interface{}
+
+type fbScalar []byte // little-endian
+type fbString string
+type fbTables []fbTable
+
+type fbVector struct {
+	elemSize int
+	data     []byte // little-endian
+}
+
+func fbUint8(v uint8) fbScalar {
+	return fbScalar{v}
+}
+
+func fbUint16(v uint16) fbScalar {
+	return appendUint16(nil, v)
+}
+
+func fbInt32(v int32) fbScalar {
+	return appendUint32(nil, uint32(v))
+}
+
+func fbUint64(v uint64) fbScalar {
+	return appendUint64(nil, v)
+}
+
+func fbUint32s(values []uint32) fbVector {
+	data := make([]byte, 0, 4*len(values))
+	for _, v := range values {
+		data = appendUint32(data, v)
+	}
+	return fbVector{4, data}
+}
+
+func fbFloat64s(values []float64) fbVector {
+	data := make([]byte, 0, 8*len(values))
+	for _, v := range values {
+		data = appendUint64(data, math.Float64bits(v))
+	}
+	return fbVector{8, data}
+}
+
+// fbFinish encodes a root table into a size-prefixed buffer.
+func fbFinish(root fbTable) []byte {
+	b := &fbBuilder{buf: make([]byte, 8, 256)}
+	table := b.writeTable(root)
+	binary.LittleEndian.PutUint32(b.buf[4:], uint32(table-4))
+	b.pad(8)
+	binary.LittleEndian.PutUint32(b.buf, uint32(len(b.buf)-4))
+	return b.buf
+}
+
+type fbBuilder struct {
+	buf []byte
+}
+
+func (b *fbBuilder) pad(align int) {
+	for len(b.buf)%align != 0 {
+		b.buf = append(b.buf, 0)
+	}
+}
+
+func (b *fbBuilder) write(value interface{}) int {
+	switch v := value.(type) {
+	case fbString:
+		b.pad(4)
+		pos := len(b.buf)
+		b.buf = appendUint32(b.buf, uint32(len(v)))
+		b.buf = append(b.buf, v...)
+		b.buf = append(b.buf, 0)
+		return pos
+
+	case fbVector:
+		for len(b.buf)%4 != 0 || (len(b.buf)+4)%v.elemSize != 0 {
+			b.buf = append(b.buf, 0)
+		}
+		pos := len(b.buf)
+		b.buf = appendUint32(b.buf, uint32(len(v.data)/v.elemSize))
+		b.buf = append(b.buf, v.data...)
+		return pos
+
+	case fbTables:
+		b.pad(4)
+		pos := len(b.buf)
+		b.buf = appendUint32(b.buf, uint32(len(v)))
+		b.buf = append(b.buf, make([]byte, 4*len(v))...)
+		for i, t := range v {
+			ref := pos + 4 + 4*i
+			table := b.writeTable(t) // may grow b.buf, so call before indexing
+			binary.LittleEndian.PutUint32(b.buf[ref:], uint32(table-ref))
+		}
+		return pos
+
+	case fbTable:
+		return b.writeTable(v)
+	}
+	panic("unsupported FlatBuffers value")
+}
+
+func (b *fbBuilder) writeTable(t fbTable) int {
+	// Largest fields first, so they all stay aligned.
+	type field struct {
+		slot, size int
+	}
+	var fields []field
+	for slot, value := range t {
+		if value == nil {
+			continue
+		}
+		size := 4 // offset of referenced object
+		if s, ok := value.(fbScalar); ok {
+			size = len(s)
+		}
+		fields = append(fields, field{slot, size})
+	}
+	sort.SliceStable(fields, func(i, j int) bool { return fields[i].size > fields[j].size })
+	align := 4
+	if len(fields) > 0 && fields[0].size > align {
+		align = fields[0].size
+	}
+
+	b.pad(2)
+	vtable := len(b.buf)
+	b.buf = append(b.buf, make([]byte, 4+2*len(t))...)
+	for len(b.buf)%4 != 0 || (len(b.buf)+4)%align != 0 {
+		b.buf = append(b.buf, 0)
+	}
+	table := len(b.buf)
+	b.buf = appendUint32(b.buf, uint32(table-vtable))
+
+	type ref struct {
+		pos   int
+		value interface{}
+	}
+	var refs []ref
+	for _, f := range fields {
+		binary.LittleEndian.PutUint16(b.buf[vtable+4+2*f.slot:], uint16(len(b.buf)-table))
+		if s, ok := t[f.slot].(fbScalar); ok {
+			b.buf = append(b.buf, s...)
+		} else {
+			refs = append(refs, ref{len(b.buf), t[f.slot]})
+			b.buf = append(b.buf, 0, 0, 0, 0)
+		}
+	}
+	binary.LittleEndian.PutUint16(b.buf[vtable:], uint16(4+2*len(t)))
+	binary.LittleEndian.PutUint16(b.buf[vtable+2:], uint16(len(b.buf)-table))
+
+	for _, r := range refs {
+		pos := b.write(r.value)
+		binary.LittleEndian.PutUint32(b.buf[r.pos:], uint32(pos-r.pos))
+	}
+	return table
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v), byte(v>>8))
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
+}
+
+func appendUint64(b []byte, v uint64) []byte {
+	return appendUint32(appendUint32(b, uint32(v)), uint32(v>>32))
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/binary"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// fbReader reads FlatBuffers tables, for checking what we encode.
+type fbReader struct {
+	buf []byte
+	pos int // start of table
+}
+
+// fbRoot returns the root table of a size-prefixed buffer.
+func fbRoot(buf []byte) fbReader {
+	return fbReader{buf, 4 + int(binary.LittleEndian.Uint32(buf[4:]))}
+}
+
+func (r fbReader) field(slot int) int {
+	vtable := r.pos - int(int32(binary.LittleEndian.Uint32(r.buf[r.pos:])))
+	if 4+2*slot >= int(binary.LittleEndian.Uint16(r.buf[vtable:])) {
+		return -1
+	}
+	offset := int(binary.LittleEndian.Uint16(r.buf[vtable+4+2*slot:]))
+	if offset == 0 {
+		return -1
+	}
+	return r.pos + offset
+}
+
+func (r fbReader) deref(slot int) int {
+	pos := r.field(slot)
+	if pos < 0 {
+		return -1
+	}
+	return pos + int(binary.LittleEndian.Uint32(r.buf[pos:]))
+}
+
+func (r fbReader) uint8(slot int) uint8 {
+	if pos := r.field(slot); pos >= 0 {
+		return r.buf[pos]
+	}
+	return 0
+}
+
+func (r fbReader) uint64(slot int) uint64 {
+	if pos := r.field(slot); pos >= 0 {
+		return binary.LittleEndian.Uint64(r.buf[pos:])
+	}
+	return 0
+}
+
+func (r fbReader) string(slot int) string {
+	pos := r.deref(slot)
+	if pos < 0 {
+		return ""
+	}
+	n := int(binary.LittleEndian.Uint32(r.buf[pos:]))
+	return string(r.buf[pos+4 : pos+4+n])
+}
+
+func (r fbReader) table(slot int) fbReader {
+	return fbReader{r.buf, r.deref(slot)}
+}
+
+// vector returns the position of the first element and the length.
+func (r fbReader) vector(slot int) (int, int) {
+	pos := r.deref(slot)
+	if pos < 0 {
+		return -1, 0
+	}
+	return pos + 4, int(binary.LittleEndian.Uint32(r.buf[pos:]))
+}
+
+func (r fbReader) float64s(slot int) []float64 {
+	start, n := r.vector(slot)
+	if start%8 != 0 {
+		panic("misaligned vector of doubles")
+	}
+	result := make([]float64, n)
+	for i := range result {
+		result[i] = math.Float64frombits(binary.LittleEndian.Uint64(r.buf[start+8*i:]))
+	}
+	return result
+}
+
+func (r fbReader) tables(slot int) []fbReader {
+	start, n := r.vector(slot)
+	result := make([]fbReader, n)
+	for i := range result {
+		pos := start + 4*i
+		result[i] = fbReader{r.buf, pos + int(binary.LittleEndian.Uint32(r.buf[pos:]))}
+	}
+	return result
+}
+
+func TestItems_FlatGeobuf(t *testing.T) {
+	index, s := makeServer(t)
+	defer s.Shutdown()
+	defer index.Close()
+
+	req, _ := http.NewRequest("GET", "/collections/castles/items?f=fgb", nil)
+	resp := httptest.NewRecorder()
+	http.HandlerFunc(s.HandleRequest).ServeHTTP(resp, req)
+	if ct := resp.Header().Get("Content-Type"); ct != "application/flatgeobuf" {
+		t.Errorf("expected Content-Type application/flatgeobuf, got %q", ct)
+	}
+	data := resp.Body.Bytes()
+	if len(data) < 12 || string(data[:8]) != string(fgbMagic) {
+		t.Fatalf("expected FlatGeobuf magic bytes, got %q", data)
+	}
+
+	headerEnd := 12 + int(binary.LittleEndian.Uint32(data[8:]))
+	header := fbRoot(data[8:headerEnd])
+	if name := header.string(0); name != "castles" {
+		t.Errorf("expected name castles, got %q", name)
+	}
+	if n := header.uint64(8); n != 3 {
+		t.Errorf("expected features_count 3, got %d", n)
+	}
+	if gt := header.uint8(2); gt != fgbUnknown {
+		t.Errorf("expected geometry type Unknown for mixed geometries, got %d", gt)
+	}
+	var columns []string
+	for _, c := range header.tables(7) {
+		columns = append(columns, c.string(0))
+	}
+	if len(columns) == 0 || columns[0] != "id" {
+		t.Errorf("expected id as first column, got %v", columns)
+	}
+	if crs := header.table(10); crs.string(0) != "EPSG" {
+		t.Errorf("expected EPSG crs, got %q", crs.string(0))
+	}
+	envelope := header.float64s(1)
+	if len(envelope) != 4 || envelope[0] > 10.69 || envelope[3] < 47.91 {
+		t.Errorf("unexpected envelope %v", envelope)
+	}
+
+	// Three features give three leaves and the root.
+	indexEnd := headerEnd + 4*40
+	root := data[headerEnd : headerEnd+40]
+	for i, v := range envelope {
+		if got := math.Float64frombits(binary.LittleEndian.Uint64(root[8*i:])); got != v {
+			t.Errorf("expected root bounds %v, got %v at %d", envelope, got, i)
+		}
+	}
+
+	var ids []string
+	leafOffsets := make(map[int]bool)
+	for i := 1; i < 4; i++ {
+		leafOffsets[int(binary.LittleEndian.Uint64(data[headerEnd+40*i+32:]))] = true
+	}
+	for pos := indexEnd; pos < len(data); {
+		if !leafOffsets[pos-indexEnd] {
+			t.Errorf("no index leaf points to feature at offset %d", pos-indexEnd)
+		}
+		end := pos + 4 + int(binary.LittleEndian.Uint32(data[pos:]))
+		feature := fbRoot(data[pos:end])
+		if geometry := feature.table(0); geometry.uint8(6) == fgbUnknown {
+			t.Error("expected geometry type for feature")
+		}
+		start, n := feature.vector(1)
+		props := data[pos+start : pos+start+n]
+		if binary.LittleEndian.Uint16(props) != 0 {
+			t.Errorf("expected properties to start with id column, got %v", props)
+		}
+		idLen := int(binary.LittleEndian.Uint32(props[2:]))
+		ids = append(ids, string(props[6:6+idLen]))
+		pos = end
+	}
+	sort.Strings(ids)
+	if got := strings.Join(ids, " "); got != "N34729562 W24785843 W418392510" {
+		t.Errorf("unexpected feature IDs %q", got)
+	}
+}
+
+func TestFGBPackedRTree(t *testing.T) {
+	bounds := make([]fgbBounds, 17)
+	offsets := make([]uint64, 17)
+	for i := range bounds {
+		bounds[i] = fgbBounds{float64(i), 0, float64(i) + 0.5, 1}
+		offsets[i] = uint64(100 * i)
+	}
+	tree := fgbPackedRTree(bounds, offsets)
+	if len(tree) != 20*40 {
+		t.Fatalf("expected 20 nodes, got %d bytes", len(tree))
+	}
+	node := func(i int) (fgbBounds, uint64) {
+		v := func(k int) float64 { return math.Float64frombits(binary.LittleEndian.Uint64(tree[40*i+8*k:])) }
+		return fgbBounds{v(0), v(1), v(2), v(3)}, binary.LittleEndian.Uint64(tree[40*i+32:])
+	}
+	for i, expected := range []struct {
+		bounds fgbBounds
+		offset uint64
+	}{
+		{fgbBounds{0, 0, 16.5, 1}, 1},
+		{fgbBounds{0, 0, 15.5, 1}, 3},
+		{fgbBounds{16, 0, 16.5, 1}, 19},
+		{fgbBounds{0, 0, 0.5, 1}, 0},
+		{fgbBounds{16, 0, 16.5, 1}, 1600},
+	} {
+		n := i
+		if i >= 3 {
+			n = []int{3, 19}[i-3]
+		}
+		if b, o := node(n); b != expected.bounds || o != expected.offset {
+			t.Errorf("node %d: expected %v %d, got %v %d", n, expected.bounds, expected.offset, b, o)
+		}
+	}
+}
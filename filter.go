@@ -0,0 +1,151 @@
+package main
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// PropertyFilter selects features whose properties have certain values,
+// such as historic=castle. Values are compared in their string form,
+// so ?ele=12 matches the number 12.
+type PropertyFilter map[string]string
+
+// reservedItemsParams are the query parameters of the items endpoint
+// that have their own meaning, and therefore do not filter properties.
+var reservedItemsParams = map[string]bool{
+	"bbox":           true,
+	"canary":         true,
+	"debug":          true,
+	"expires":        true,
+	"explain":        true,
+	"f":              true,
+	"filter":         true,
+	"filter-lang":    true,
+	"ids":            true,
+	"includeDeleted": true,
+	"limit":          true,
+	"profile":        true,
+	"q":              true,
+	"signature":      true,
+	"since":          true,
+	"start":          true,
+	"startID":        true,
+	"within":         true,
+}
+
+// parsePropertyFilter returns the property filter for the query
+// parameters of an items request, or nil if there is none.
+func parsePropertyFilter(params url.Values) PropertyFilter {
+	var filter PropertyFilter
+	for key, values := range params {
+		if reservedItemsParams[key] || len(values) == 0 {
+			continue
+		}
+		if filter == nil {
+			filter = make(PropertyFilter)
+		}
+		filter[key] = values[0]
+	}
+	return filter
+}
+
+// encode returns the filter as query parameters, sorted by key.
+func (filter PropertyFilter) encode() []string {
+	keys := make([]string, 0, len(filter))
+	for key := range filter {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	params := make([]string, len(keys))
+	for i, key := range keys {
+		params[i] = url.QueryEscape(key) + "=" + url.QueryEscape(filter[key])
+	}
+	return params
+}
+
+// propertyIndex maps property names and values to the indices of the
+// features having them, in ascending order, so we can filter features
+// without decoding them. Only strings, numbers and booleans are indexed.
+type propertyIndex map[string]map[string][]int
+
+func (p propertyIndex) add(i int, properties map[string]interface{}) {
+	for key, value := range properties {
+		switch value.(type) {
+		case string, float64, bool:
+		default:
+			continue
+		}
+		values := p[key]
+		if values == nil {
+			values = make(map[string][]int)
+			p[key] = values
+		}
+		s := categoryString(value)
+		values[s] = append(values[s], i)
+	}
+}
+
+// estimateMemory returns the approximate size of the index, in bytes.
+func (p propertyIndex) estimateMemory() int64 {
+	var m int64
+	for key, values := range p {
+		m += int64(len(key)) + mapEntryMemory
+		for value, features := range values {
+			m += int64(len(value)) + mapEntryMemory + int64(8*cap(features))
+		}
+	}
+	return m
+}
+
+// matcher returns a predicate telling whether the i-th feature of a
+// collection passes a filter.
+func (c *Collection) matcher(filter PropertyFilter) func(i int) bool {
+	if len(filter) == 0 {
+		return func(i int) bool { return true }
+	}
+	lists := make([][]int, 0, len(filter))
+	for key, value := range filter {
+		features := c.properties[key][value]
+		if len(features) == 0 {
+			return func(i int) bool { return false }
+		}
+		lists = append(lists, features)
+	}
+	return func(i int) bool {
+		for _, features := range lists {
+			if k := sort.SearchInts(features, i); k >= len(features) || features[k] != i {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// parseIDs splits the comma-separated value of the ids query parameter,
+// such as "N123,W456", into feature IDs. Returns nil if there are none.
+func parseIDs(param string) []string {
+	var ids []string
+	for _, id := range strings.Split(param, ",") {
+		if id = strings.TrimSpace(id); len(id) > 0 {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// idMatcher returns a predicate telling whether the i-th feature of a
+// collection has one of the given IDs. If ids is empty, every feature
+// matches.
+func (c *Collection) idMatcher(ids []string) func(i int) bool {
+	if len(ids) == 0 {
+		return func(i int) bool { return true }
+	}
+	selected := make(map[int]bool, len(ids))
+	for _, id := range ids {
+		if i, ok := c.lookupID(id); ok {
+			selected[i] = true
+		}
+	}
+	return func(i int) bool { return selected[i] }
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Google Cloud Storage sources, such as gs://bucket/lakes.geojson, for
+// servers running on GKE. Objects get fetched like http:// URLs from
+// the XML API. To tell whether an object has changed, we compare its
+// generation number, which changes whenever the object gets written.
+// Access tokens come from the metadata server, which on GKE hands out
+// the credentials of the Workload Identity service account. Where no
+// metadata server is reachable, requests are anonymous, which works
+// for public buckets. For testing against an emulator, set
+// STORAGE_EMULATOR_HOST.
+
+func init() {
+	client := &http.Client{Timeout: 60 * time.Second}
+	store := &gcsStore{client: client}
+	RegisterInputLoader(&httpLoader{name: "gcs", client: client, store: store,
+		versionHeader: "X-Goog-Generation"}, nil, []string{"gs"})
+}
+
+// gcsMetadataHost is the metadata server of Google Cloud, unless
+// overridden by GCE_METADATA_HOST.
+const gcsMetadataHost = "metadata.google.internal"
+
+// gcsMetadataRetry is how long we make anonymous requests after failing
+// to reach the metadata server, before trying it again.
+const gcsMetadataRetry = time.Minute
+
+type gcsStore struct {
+	client *http.Client
+
+	mutex         sync.Mutex
+	token         string
+	expiration    time.Time
+	metadataError time.Time // when the metadata server was last unreachable
+}
+
+// parseGCSSource splits a source such as gs://bucket/path/key.geojson
+// into bucket and object name.
+func parseGCSSource(source string) (bucket string, object string, err error) {
+	if sourceScheme(source) == "gs" {
+		rest := source[len("gs://"):]
+		if i := strings.IndexByte(rest, '/'); i > 0 && i < len(rest)-1 {
+			return rest[:i], rest[i+1:], nil
+		}
+	}
+	return "", "", fmt.Errorf("malformed GCS source %s; expected gs://bucket/object", source)
+}
+
+func (s *gcsStore) objectURL(source string) (string, error) {
+	bucket, object, err := parseGCSSource(source)
+	if err != nil {
+		return "", err
+	}
+	endpoint := "https://storage.googleapis.com"
+	if emulator := os.Getenv("STORAGE_EMULATOR_HOST"); len(emulator) > 0 {
+		endpoint = strings.TrimSuffix(emulator, "/")
+		if !strings.Contains(endpoint, "://") {
+			endpoint = "http://" + endpoint
+		}
+	}
+	return endpoint + "/" + bucket + "/" + escapeObjectKey(object), nil
+}
+
+func (s *gcsStore) sign(req *http.Request) error {
+	if len(os.Getenv("STORAGE_EMULATOR_HOST")) > 0 {
+		return nil
+	}
+	token, err := s.getToken(time.Now())
+	if err != nil {
+		return err
+	}
+	if len(token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return nil
+}
+
+// getToken returns an OAuth2 access token from the metadata server,
+// or the empty string if there is no metadata server.
+func (s *gcsStore) getToken(now time.Time) (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// Tokens get refreshed well before they expire.
+	if len(s.token) > 0 && now.Add(5*time.Minute).Before(s.expiration) {
+		return s.token, nil
+	}
+	if now.Sub(s.metadataError) < gcsMetadataRetry {
+		return "", nil
+	}
+
+	host := os.Getenv("GCE_METADATA_HOST")
+	if len(host) == 0 {
+		host = gcsMetadataHost
+	}
+	req, err := http.NewRequest("GET", "http://"+host+"/computeMetadata/v1/instance/service-accounts/default/token", nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		loaderLog.Debug("no metadata server, accessing GCS anonymously", "error", err)
+		s.metadataError = now
+		return "", nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("fetching access token from metadata server: %s", resp.Status)
+	}
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", fmt.Errorf("fetching access token from metadata server: %v", err)
+	}
+	s.token = token.AccessToken
+	s.expiration = now.Add(time.Duration(token.ExpiresIn) * time.Second)
+	return s.token, nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestGCSStore_ObjectURL(t *testing.T) {
+	defer setenv(map[string]string{"STORAGE_EMULATOR_HOST": ""})()
+
+	s := &gcsStore{}
+	for source, expected := range map[string]string{
+		"gs://data/lakes.geojson":          "https://storage.googleapis.com/data/lakes.geojson",
+		"GS://data/2024/Lakes & Rivers.gz": "https://storage.googleapis.com/data/2024/Lakes%20%26%20Rivers.gz",
+	} {
+		if got, err := s.objectURL(source); err != nil || got != expected {
+			t.Errorf("objectURL(%q): expected %s, got %s, %v", source, expected, got, err)
+		}
+	}
+	for _, source := range []string{"gs://data", "gs://data/", "gs:///lakes"} {
+		if _, err := s.objectURL(source); err == nil {
+			t.Errorf("objectURL(%q): expected error", source)
+		}
+	}
+}
+
+func TestGCSLoader(t *testing.T) {
+	data, _ := ioutil.ReadFile(filepath.Join("testdata", "lakes.geojson"))
+	var mutex sync.Mutex
+	generation := "1722951000000000"
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		requests = append(requests, req.Method+" "+req.URL.Path)
+		w.Header().Set("X-Goog-Generation", generation)
+		w.Write(data)
+	}))
+	defer server.Close()
+	defer setenv(map[string]string{"STORAGE_EMULATOR_HOST": strings.TrimPrefix(server.URL, "http://")})()
+
+	source := "gs://data/lakes.geojson"
+	var t0 time.Time
+	coll, err := readCollection("lakes", source, t0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer coll.Close()
+	lastModified := coll.metadata.LastModified
+	if _, err := readCollection("lakes", source, lastModified); err != NotModified {
+		t.Errorf("expected NotModified for same generation, got %v", err)
+	}
+
+	mutex.Lock()
+	generation = "1722951000000001"
+	mutex.Unlock()
+	coll2, err := readCollection("lakes", source, lastModified)
+	if err != nil {
+		t.Fatalf("expected reload for new generation, got %v", err)
+	}
+	defer coll2.Close()
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	expected := "HEAD /data/lakes.geojson,GET /data/lakes.geojson,HEAD /data/lakes.geojson," +
+		"HEAD /data/lakes.geojson,GET /data/lakes.geojson"
+	if got := strings.Join(requests, ","); got != expected {
+		t.Errorf("expected requests %s, got %s", expected, got)
+	}
+}
+
+func TestGCSStore_Token(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		calls++
+		if req.Header.Get("Metadata-Flavor") != "Google" ||
+			req.URL.Path != "/computeMetadata/v1/instance/service-accounts/default/token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.Write([]byte(`{"access_token":"ya29.example","expires_in":3599,"token_type":"Bearer"}`))
+	}))
+	defer server.Close()
+	defer setenv(map[string]string{"STORAGE_EMULATOR_HOST": "",
+		"GCE_METADATA_HOST": strings.TrimPrefix(server.URL, "http://")})()
+
+	s := &gcsStore{client: server.Client()}
+	req, _ := http.NewRequest("HEAD", "https://storage.googleapis.com/data/lakes.geojson", nil)
+	if err := s.sign(req); err != nil {
+		t.Fatal(err)
+	}
+	if got := req.Header.Get("Authorization"); got != "Bearer ya29.example" {
+		t.Errorf("unexpected Authorization header %q", got)
+	}
+
+	now := time.Now()
+	if token, _ := s.getToken(now.Add(30 * time.Minute)); token != "ya29.example" || calls != 1 {
+		t.Errorf("expected cached token, got %q after %d calls", token, calls)
+	}
+	if _, err := s.getToken(now.Add(58 * time.Minute)); err != nil || calls != 2 {
+		t.Errorf("expected token to be refreshed, got %d calls, error %v", calls, err)
+	}
+
+	// Without metadata server, requests are anonymous.
+	server.Close()
+	s = &gcsStore{client: &http.Client{Timeout: time.Second}}
+	if token, err := s.getToken(now); token != "" || err != nil {
+		t.Errorf("expected anonymous access, got %q, %v", token, err)
+	}
+}
@@ -89,23 +89,39 @@ func EncodeBbox(r s2.Rect) []float64 {
 	}
 }
 
-func getTileBounds(zoom int, x int, y int) s2.Rect {
-	r := s2.RectFromLatLng(unprojectWebMercator(zoom, float64(x), float64(y)))
-	return r.AddPoint(unprojectWebMercator(zoom, float64(x+1), float64(y+1)))
-}
+// TileSize is the width and height of rendered tiles, in pixels.
+const TileSize = 256
 
+// projectWebMercator projects a point to normalized web mercator
+// coordinates (EPSG:3857), where the world spans [0, 1) in both axes.
+// X grows towards east, and Y grows towards south, like in tile and
+// pixel coordinates. The result does not depend on the zoom level;
+// use tilePixel to get pixel coordinates within a tile. Elevation
+// does not affect the projection.
 func projectWebMercator(p s2.LatLng) r2.Point {
 	siny := math.Sin(p.Lat.Radians())
 	siny = math.Min(math.Max(siny, -0.9999), 0.9999)
-	x := 256 * (0.5 + p.Lng.Degrees()/360)
-	y := 256 * (0.5 - math.Log((1+siny)/(1-siny))/(4*math.Pi))
+	x := 0.5 + p.Lng.Degrees()/360
+	y := 0.5 - math.Log((1+siny)/(1-siny))/(4*math.Pi)
 	return r2.Point{X: x, Y: y}
 }
 
-func unprojectWebMercator(zoom int, x float64, y float64) s2.LatLng {
+// unprojectWebMercator is the inverse of projectWebMercator.
+func unprojectWebMercator(p r2.Point) s2.LatLng {
 	// EPSG:3857 - https://epsg.io/3857
-	n := math.Pi - 2.0*math.Pi*y/math.Exp2(float64(zoom))
+	n := math.Pi - 2.0*math.Pi*p.Y
 	lat := 180.0 / math.Pi * math.Atan(0.5*(math.Exp(n)-math.Exp(-n)))
-	lng := x/math.Exp2(float64(zoom))*360.0 - 180.0
+	lng := p.X*360.0 - 180.0
 	return s2.LatLngFromDegrees(lat, lng)
 }
+
+// tilePixel converts normalized web mercator coordinates into pixel
+// coordinates relative to the top left corner of a tile. Points
+// outside the tile get coordinates outside [0, TileSize).
+func tilePixel(p r2.Point, tile TileKey) r2.Point {
+	scale := math.Exp2(float64(tile.Zoom))
+	return r2.Point{
+		X: (p.X*scale - float64(tile.X)) * TileSize,
+		Y: (p.Y*scale - float64(tile.Y)) * TileSize,
+	}
+}
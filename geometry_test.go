@@ -25,21 +25,79 @@ func TestEncodeBbox_Empty(t *testing.T) {
 	}
 }
 
-func TestGetTileBounds(t *testing.T) {
-	b := EncodeBbox(getTileBounds(12, 2148, 1436))
-	expectBbox("8.7890625,47.2195681,8.8769531,47.2792290", b, t)
-}
-
 func TestProjectWebMercator(t *testing.T) {
 	// https://developers.google.com/maps/documentation/javascript/examples/map-coordinates
+	// gives world coordinates in [0, 256); we normalize to [0, 1).
 	got := projectWebMercator(s2.LatLngFromDegrees(41.850, -87.650))
-	expected := r2.Point{X: 65.67111111111113, Y: 95.17492654697409}
+	expected := r2.Point{X: 65.67111111111113 / 256, Y: 95.17492654697409 / 256}
 	delta := got.Sub(expected)
-	if math.Abs(delta.X)+math.Abs(delta.Y) > 1e-9 {
+	if math.Abs(delta.X)+math.Abs(delta.Y) > 1e-12 {
 		t.Errorf("expected %v, got %v", expected, got)
 	}
 }
 
+func TestProjectWebMercator_Orientation(t *testing.T) {
+	nw := projectWebMercator(s2.LatLngFromDegrees(85.0511287798, -180))
+	se := projectWebMercator(s2.LatLngFromDegrees(-85.0511287798, 180))
+	if math.Abs(nw.X) > 1e-9 || math.Abs(nw.Y) > 1e-9 {
+		t.Errorf("expected north-western corner at (0, 0), got %v", nw)
+	}
+	if math.Abs(se.X-1) > 1e-9 || math.Abs(se.Y-1) > 1e-9 {
+		t.Errorf("expected south-eastern corner at (1, 1), got %v", se)
+	}
+
+	north := projectWebMercator(s2.LatLngFromDegrees(47.4, 8.5))
+	south := projectWebMercator(s2.LatLngFromDegrees(47.3, 8.5))
+	if !(north.Y < south.Y) {
+		t.Errorf("expected y to grow towards south, got north=%v south=%v", north, south)
+	}
+}
+
+func TestUnprojectWebMercator(t *testing.T) {
+	for _, lat := range []float64{-85, -47.3, 0, 0.001, 41.85, 85} {
+		for _, lng := range []float64{-179.9, -87.65, 0, 8.5, 179.9} {
+			p := s2.LatLngFromDegrees(lat, lng)
+			got := unprojectWebMercator(projectWebMercator(p))
+			if got.Distance(p).Degrees() > 1e-9 {
+				t.Errorf("expected round trip to return %v, got %v", p, got)
+			}
+		}
+	}
+}
+
+func TestTilePixel(t *testing.T) {
+	// Same example as in TestProjectWebMercator; tile coordinates are
+	// floor(world * 2^zoom / 256), and the pixel is the remainder.
+	world := projectWebMercator(s2.LatLngFromDegrees(41.850, -87.650))
+	for zoom := 0; zoom <= 22; zoom++ {
+		scale := math.Exp2(float64(zoom))
+		px, py := world.X*scale*TileSize, world.Y*scale*TileSize
+		tile := TileKey{
+			X:    uint32(math.Floor(px / TileSize)),
+			Y:    uint32(math.Floor(py / TileSize)),
+			Zoom: uint8(zoom),
+		}
+		got := tilePixel(world, tile)
+		if got.X < 0 || got.X >= TileSize || got.Y < 0 || got.Y >= TileSize {
+			t.Errorf("zoom %d: expected pixel inside tile %v, got %v", zoom, tile, got)
+		}
+		expected := r2.Point{X: px - float64(tile.X)*TileSize, Y: py - float64(tile.Y)*TileSize}
+		if delta := got.Sub(expected); math.Abs(delta.X)+math.Abs(delta.Y) > 1e-6 {
+			t.Errorf("zoom %d: expected %v, got %v", zoom, expected, got)
+		}
+		if !tile.Bounds().ContainsLatLng(unprojectWebMercator(world)) {
+			t.Errorf("zoom %d: expected tile %v to contain point", zoom, tile)
+		}
+	}
+
+	// Pixels in the tile south of the point's tile have negative y.
+	tile := TileKey{X: 262, Y: 381, Zoom: 10}
+	below := TileKey{X: tile.X, Y: tile.Y + 1, Zoom: tile.Zoom}
+	if p := tilePixel(world, below); p.Y >= 0 {
+		t.Errorf("expected negative y in tile south of point, got %v", p)
+	}
+}
+
 func expectBbox(expected string, got []float64, t *testing.T) {
 	e, err := parseBbox(expected)
 	if err != nil {
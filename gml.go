@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/paulmach/go.geojson"
+)
+
+// GML 3.2 encoding of GeoJSON features, used by the WFS 2.0
+// compatibility endpoint. Coordinates are written in the axis order
+// of urn:ogc:def:crs:EPSG::4326, which is latitude before longitude.
+
+const gmlNamespace = "http://www.opengis.net/gml/3.2"
+const gmlSRSName = "urn:ogc:def:crs:EPSG::4326"
+const miniwfsNamespace = "https://github.com/brawer/miniwfs"
+
+func init() {
+	RegisterOutputEncoder(gmlEncoder{}, true)
+}
+
+type gmlEncoder struct{}
+
+func (gmlEncoder) Name() string {
+	return "gml"
+}
+
+func (gmlEncoder) MediaType() string {
+	return "application/gml+xml; version=3.2"
+}
+
+func (gmlEncoder) EncodeFeatureCollection(w io.Writer, collection string, features []RawFeature) error {
+	out := bufio.NewWriter(w)
+	writeXMLHeader(out)
+	out.WriteString(`<wfs:FeatureCollection` +
+		` xmlns:wfs="http://www.opengis.net/wfs/2.0"` +
+		` xmlns:gml="` + gmlNamespace + `"` +
+		` xmlns:miniwfs="` + miniwfsNamespace + `"` +
+		` timeStamp="` + time.Now().UTC().Format(time.RFC3339) + `"` +
+		` numberMatched="unknown"` +
+		` numberReturned="` + strconv.Itoa(len(features)) + `">`)
+	for _, f := range features {
+		out.WriteString("<wfs:member>")
+		writeGMLFeature(out, collection, f.Feature, false)
+		out.WriteString("</wfs:member>")
+	}
+	out.WriteString("</wfs:FeatureCollection>")
+	return out.Flush()
+}
+
+func (gmlEncoder) EncodeFeature(w io.Writer, collection string, feature RawFeature) error {
+	out := bufio.NewWriter(w)
+	writeXMLHeader(out)
+	writeGMLFeature(out, collection, feature.Feature, true)
+	return out.Flush()
+}
+
+// writeGMLFeature writes a feature as GML. When the feature is the root
+// element of an XML document, we also need to declare namespaces.
+func writeGMLFeature(w *bufio.Writer, collection string, f *geojson.Feature, root bool) {
+	typeName := xmlName(collection)
+	w.WriteString("<miniwfs:" + typeName)
+	if root {
+		w.WriteString(` xmlns:gml="` + gmlNamespace + `" xmlns:miniwfs="` + miniwfsNamespace + `"`)
+	}
+	if id := getIDString(f.ID); len(id) > 0 {
+		w.WriteString(` gml:id="`)
+		xml.EscapeText(w, []byte(xmlName(collection+"."+id)))
+		w.WriteString(`"`)
+	}
+	w.WriteString(">")
+
+	keys := make([]string, 0, len(f.Properties))
+	for key := range f.Properties {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		name := xmlName(key)
+		w.WriteString("<miniwfs:" + name + ">")
+		xml.EscapeText(w, []byte(formatGMLValue(f.Properties[key])))
+		w.WriteString("</miniwfs:" + name + ">")
+	}
+
+	if f.Geometry != nil {
+		w.WriteString("<miniwfs:geometry>")
+		writeGMLGeometry(w, f.Geometry)
+		w.WriteString("</miniwfs:geometry>")
+	}
+	w.WriteString("</miniwfs:" + typeName + ">")
+}
+
+func writeGMLGeometry(w *bufio.Writer, g *geojson.Geometry) {
+	srs := ` srsName="` + gmlSRSName + `"`
+	switch g.Type {
+	case geojson.GeometryPoint:
+		w.WriteString("<gml:Point" + srs + "><gml:pos>")
+		writeGMLPositions(w, [][]float64{g.Point})
+		w.WriteString("</gml:pos></gml:Point>")
+
+	case geojson.GeometryMultiPoint:
+		w.WriteString("<gml:MultiPoint" + srs + ">")
+		for _, p := range g.MultiPoint {
+			w.WriteString("<gml:pointMember><gml:Point><gml:pos>")
+			writeGMLPositions(w, [][]float64{p})
+			w.WriteString("</gml:pos></gml:Point></gml:pointMember>")
+		}
+		w.WriteString("</gml:MultiPoint>")
+
+	case geojson.GeometryLineString:
+		w.WriteString("<gml:LineString" + srs + ">")
+		writeGMLPosList(w, g.LineString)
+		w.WriteString("</gml:LineString>")
+
+	case geojson.GeometryMultiLineString:
+		w.WriteString("<gml:MultiCurve" + srs + ">")
+		for _, line := range g.MultiLineString {
+			w.WriteString("<gml:curveMember><gml:LineString>")
+			writeGMLPosList(w, line)
+			w.WriteString("</gml:LineString></gml:curveMember>")
+		}
+		w.WriteString("</gml:MultiCurve>")
+
+	case geojson.GeometryPolygon:
+		w.WriteString("<gml:Polygon" + srs + ">")
+		writeGMLRings(w, g.Polygon)
+		w.WriteString("</gml:Polygon>")
+
+	case geojson.GeometryMultiPolygon:
+		w.WriteString("<gml:MultiSurface" + srs + ">")
+		for _, poly := range g.MultiPolygon {
+			w.WriteString("<gml:surfaceMember><gml:Polygon>")
+			writeGMLRings(w, poly)
+			w.WriteString("</gml:Polygon></gml:surfaceMember>")
+		}
+		w.WriteString("</gml:MultiSurface>")
+
+	case geojson.GeometryCollection:
+		w.WriteString("<gml:MultiGeometry" + srs + ">")
+		for _, member := range g.Geometries {
+			w.WriteString("<gml:geometryMember>")
+			writeGMLGeometry(w, member)
+			w.WriteString("</gml:geometryMember>")
+		}
+		w.WriteString("</gml:MultiGeometry>")
+	}
+}
+
+func writeGMLRings(w *bufio.Writer, rings [][][]float64) {
+	for i, ring := range rings {
+		tag := "gml:interior"
+		if i == 0 {
+			tag = "gml:exterior"
+		}
+		w.WriteString("<" + tag + "><gml:LinearRing>")
+		writeGMLPosList(w, ring)
+		w.WriteString("</gml:LinearRing></" + tag + ">")
+	}
+}
+
+func writeGMLPosList(w *bufio.Writer, points [][]float64) {
+	w.WriteString("<gml:posList>")
+	writeGMLPositions(w, points)
+	w.WriteString("</gml:posList>")
+}
+
+func writeGMLPositions(w *bufio.Writer, points [][]float64) {
+	first := true
+	for _, p := range points {
+		if len(p) < 2 {
+			continue
+		}
+		if !first {
+			w.WriteByte(' ')
+		}
+		first = false
+		w.WriteString(strconv.FormatFloat(p[1], 'f', -1, 64))
+		w.WriteByte(' ')
+		w.WriteString(strconv.FormatFloat(p[0], 'f', -1, 64))
+	}
+}
+
+func formatGMLValue(v interface{}) string {
+	switch val := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return val
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64)
+	default:
+		return fmt.Sprint(val)
+	}
+}
+
+// xmlName turns an arbitrary string, such as a GeoJSON property key,
+// into a valid XML name by replacing disallowed characters.
+func xmlName(s string) string {
+	var b strings.Builder
+	for i, c := range s {
+		valid := unicode.IsLetter(c) || c == '_' ||
+			(i > 0 && (unicode.IsDigit(c) || c == '-' || c == '.'))
+		if valid {
+			b.WriteRune(c)
+		} else {
+			b.WriteRune('_')
+		}
+	}
+	if b.Len() == 0 {
+		return "_"
+	}
+	return b.String()
+}
+
+func writeXMLHeader(w io.Writer) {
+	io.WriteString(w, xml.Header)
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"net/url"
+	"sort"
+	"strconv"
+)
+
+// HTML representation of collections and items, so people can browse
+// the server in a web browser. OGC API - Features recommends this as
+// the HTML conformance class. Data is passed to scripts through
+// html/template, which escapes it for the JavaScript context.
+
+func init() {
+	RegisterOutputEncoder(htmlEncoder{}, false)
+}
+
+var htmlTemplates = template.Must(template.New("html").Parse(`
+{{define "head"}}<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.}}</title>
+<link rel="stylesheet" href="https://unpkg.com/leaflet@1.9.4/dist/leaflet.css">
+<script src="https://unpkg.com/leaflet@1.9.4/dist/leaflet.js"></script>
+<style>
+body { font-family: sans-serif; margin: 1em 2em; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 0.2em 0.5em; text-align: left; vertical-align: top; }
+#map { height: 400px; margin-bottom: 1em; }
+</style>
+</head>
+<body>
+{{end}}
+
+{{define "map"}}<div id="map"></div>
+<script>
+var map = L.map('map').setView([0, 0], 2);
+L.tileLayer('https://tile.openstreetmap.org/{z}/{x}/{y}.png', {
+  attribution: '&copy; OpenStreetMap contributors'
+}).addTo(map);
+var layer = L.geoJSON(JSON.parse({{.}})).addTo(map);
+if (layer.getBounds().isValid()) {
+  map.fitBounds(layer.getBounds(), {maxZoom: 17});
+}
+</script>
+{{end}}
+
+{{define "collections"}}{{template "head" "Collections"}}<h1>Collections</h1>
+<p><a href="{{.JSONURL}}">JSON</a></p>
+{{range .Collections}}{{if and .StartsGroup .Group}}<h2>{{.Group}}</h2>{{end}}
+<p><a href="{{.URL}}">{{if .PreviewURL}}<img src="{{.PreviewURL}}" width="64" height="64" alt=""> {{end}}{{.Title}}</a></p>
+{{end}}</body>
+</html>
+{{end}}
+
+{{define "collection"}}{{template "head" .Title}}<h1>{{.Title}}</h1>
+{{if .Description}}<p>{{.Description}}</p>{{end}}
+{{if .PreviewURL}}<p><img src="{{.PreviewURL}}" width="256" height="256" alt=""></p>{{end}}
+<table>
+{{if .Bbox}}<tr><th>Bounding box</th><td>{{.Bbox}}</td></tr>{{end}}
+{{if .License}}<tr><th>License</th><td><a href="{{.License}}">{{.License}}</a></td></tr>{{end}}
+{{if .Attribution}}<tr><th>Attribution</th><td>{{.Attribution}}</td></tr>{{end}}
+</table>
+<p><a href="{{.ItemsURL}}">Items</a> · <a href="{{.JSONURL}}">JSON</a> · <a href="{{.CollectionsURL}}">All collections</a></p>
+</body>
+</html>
+{{end}}
+
+{{define "items"}}{{template "head" .Collection}}<h1>{{.Collection}}</h1>
+{{template "map" .GeoJSON}}
+<table>
+<tr><th>id</th>{{range .Columns}}<th>{{.}}</th>{{end}}</tr>
+{{range .Rows}}<tr><td><a href="{{.URL}}">{{.ID}}</a></td>{{range .Values}}<td>{{.}}</td>{{end}}</tr>
+{{end}}</table>
+</body>
+</html>
+{{end}}
+
+{{define "item"}}{{template "head" .ID}}<h1>{{.ID}}</h1>
+{{template "map" .GeoJSON}}
+<table>
+{{range .Properties}}<tr><th>{{.Key}}</th><td>{{.Value}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+{{end}}
+`))
+
+type htmlEncoder struct{}
+
+func (htmlEncoder) Name() string {
+	return "html"
+}
+
+func (htmlEncoder) MediaType() string {
+	return "text/html; charset=utf-8"
+}
+
+type htmlItemRow struct {
+	ID     string
+	URL    string
+	Values []string
+}
+
+func (htmlEncoder) EncodeFeatureCollection(w io.Writer, collection string, features []RawFeature) error {
+	columnSet := make(map[string]bool)
+	for _, f := range features {
+		for key := range f.Feature.Properties {
+			columnSet[key] = true
+		}
+	}
+	columns := make([]string, 0, len(columnSet))
+	for key := range columnSet {
+		columns = append(columns, key)
+	}
+	sort.Strings(columns)
+
+	rows := make([]htmlItemRow, len(features))
+	geoJSON := []byte(`{"type":"FeatureCollection","features":[`)
+	for i, f := range features {
+		if i > 0 {
+			geoJSON = append(geoJSON, ',')
+		}
+		geoJSON = append(geoJSON, f.JSON...)
+
+		id := featureIDString(f.Feature.ID)
+		// Relative to /collections/{collectionId}/items.
+		rows[i] = htmlItemRow{ID: id, URL: "items/" + EscapeItemID(id) + "?f=html"}
+		rows[i].Values = make([]string, len(columns))
+		for c, key := range columns {
+			if value, ok := f.Feature.Properties[key]; ok {
+				rows[i].Values[c] = htmlPropertyValue(value)
+			}
+		}
+	}
+	geoJSON = append(geoJSON, "]}"...)
+
+	return htmlTemplates.ExecuteTemplate(w, "items", struct {
+		Collection string
+		GeoJSON    string
+		Columns    []string
+		Rows       []htmlItemRow
+	}{collection, string(geoJSON), columns, rows})
+}
+
+type htmlProperty struct {
+	Key   string
+	Value string
+}
+
+func (htmlEncoder) EncodeFeature(w io.Writer, collection string, feature RawFeature) error {
+	keys := make([]string, 0, len(feature.Feature.Properties))
+	for key := range feature.Feature.Properties {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	properties := make([]htmlProperty, len(keys))
+	for i, key := range keys {
+		properties[i] = htmlProperty{key, htmlPropertyValue(feature.Feature.Properties[key])}
+	}
+
+	return htmlTemplates.ExecuteTemplate(w, "item", struct {
+		ID         string
+		GeoJSON    string
+		Properties []htmlProperty
+	}{featureIDString(feature.Feature.ID), string(feature.JSON), properties})
+}
+
+// featureIDString formats the ID of a feature for display, or returns
+// the empty string if the feature has no ID.
+func featureIDString(id interface{}) string {
+	if id == nil {
+		return ""
+	}
+	return fmt.Sprint(id)
+}
+
+// htmlPropertyValue formats a property value for display. Strings are
+// shown as they are, other values in their JSON encoding.
+func htmlPropertyValue(value interface{}) string {
+	if s, ok := value.(string); ok {
+		return s
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return ""
+	}
+	return string(encoded)
+}
+
+type htmlCollection struct {
+	Name        string
+	Title       string // Name if no title has been configured
+	Description string
+	Group       string
+	URL         string
+	PreviewURL  string // empty for protected collections
+	Bbox        string
+	License     string
+	Attribution string
+	StartsGroup bool // true if the previous collection is in another group
+}
+
+// writeCollectionsHTML writes the HTML page for /collections.
+func (s *WebServer) writeCollectionsHTML(w io.Writer, prefix string, collections []CollectionMetadata) error {
+	page := struct {
+		JSONURL     string
+		Collections []htmlCollection
+	}{JSONURL: prefix + "collections?f=json"}
+	for i, c := range collections {
+		hc := s.makeHTMLCollection(prefix, c)
+		hc.StartsGroup = i == 0 || c.Group != collections[i-1].Group
+		page.Collections = append(page.Collections, hc)
+	}
+	return htmlTemplates.ExecuteTemplate(w, "collections", page)
+}
+
+// writeCollectionHTML writes the HTML page for /collections/{collectionId}.
+func (s *WebServer) writeCollectionHTML(w io.Writer, prefix string, c CollectionMetadata) error {
+	collURL := prefix + "collections/" + url.PathEscape(c.Name)
+	return htmlTemplates.ExecuteTemplate(w, "collection", struct {
+		htmlCollection
+		ItemsURL       string
+		JSONURL        string
+		CollectionsURL string
+	}{
+		htmlCollection: s.makeHTMLCollection(prefix, c),
+		ItemsURL:       collURL + "/items?f=html",
+		JSONURL:        collURL + "?f=json",
+		CollectionsURL: prefix + "collections?f=html",
+	})
+}
+
+func (s *WebServer) makeHTMLCollection(prefix string, c CollectionMetadata) htmlCollection {
+	collURL := prefix + "collections/" + url.PathEscape(c.Name)
+	attribution := s.index.GetAttribution(c.Name)
+	result := htmlCollection{
+		Name:        c.Name,
+		Title:       c.Title,
+		Description: c.Description,
+		Group:       c.Group,
+		URL:         collURL + "?f=html",
+		License:     attribution.License,
+		Attribution: attribution.Attribution,
+	}
+	if len(result.Title) == 0 {
+		result.Title = c.Name
+	}
+	if !s.access.IsProtected(c.Name) {
+		result.PreviewURL = collURL + "/preview.png"
+	}
+	if bbox := EncodeBbox(c.Bbox); bbox != nil {
+		for i, v := range bbox {
+			if i > 0 {
+				result.Bbox += ", "
+			}
+			result.Bbox += strconv.FormatFloat(v, 'f', -1, 64)
+		}
+	}
+	return result
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHTML(t *testing.T) {
+	index, s := makeServer(t)
+	defer s.Shutdown()
+	defer index.Close()
+
+	for _, tc := range []struct {
+		url, accept string
+		html        bool
+		contains    string
+	}{
+		{"/collections", "", false, `"name":"castles"`},
+		{"/collections", "text/html,application/xhtml+xml,*/*;q=0.8", true, `<a href="https://test.example.org/wfs/collections/castles?f=html">`},
+		{"/collections?f=html", "application/json", true, "<h1>Collections</h1>"},
+		{"/collections/castles", "", false, `"name":"castles"`},
+		{"/collections/castles?f=html", "", true, `<a href="https://test.example.org/wfs/collections/castles/items?f=html">Items</a>`},
+		{"/collections/castles/items?f=html", "", true, `<td><a href="items/W24785843?f=html">W24785843</a></td>`},
+		{"/collections/castles/items", "text/html", true, "<th>wikipedia</th>"},
+		{"/collections/castles/items/N34729562?f=html", "", true, "<tr><th>name</th><td>Hochschloß Pähl</td></tr>"},
+	} {
+		req, _ := http.NewRequest("GET", tc.url, nil)
+		if len(tc.accept) > 0 {
+			req.Header.Set("Accept", tc.accept)
+		}
+		resp := httptest.NewRecorder()
+		http.HandlerFunc(s.HandleRequest).ServeHTTP(resp, req)
+		if resp.Code != http.StatusOK {
+			t.Errorf("GET %s: expected status 200, got %d", tc.url, resp.Code)
+			continue
+		}
+		isHTML := resp.Header().Get("Content-Type") == "text/html; charset=utf-8"
+		if isHTML != tc.html {
+			t.Errorf("GET %s with Accept: %s: expected HTML=%v, got Content-Type %s",
+				tc.url, tc.accept, tc.html, resp.Header().Get("Content-Type"))
+		}
+		if body := getBody(resp); !strings.Contains(body, tc.contains) {
+			t.Errorf("GET %s: expected body to contain %q, got %s", tc.url, tc.contains, body)
+		}
+	}
+
+	req, _ := http.NewRequest("GET", "/collections/nosuchcollection?f=html", nil)
+	resp := httptest.NewRecorder()
+	http.HandlerFunc(s.HandleRequest).ServeHTTP(resp, req)
+	if resp.Code != http.StatusNotFound {
+		t.Errorf("expected status 404 for unknown collection, got %d", resp.Code)
+	}
+}
+
+func TestHTMLEncoder_Escaping(t *testing.T) {
+	feature := []byte(`{"type":"Feature","id":"a</script>","geometry":{"type":"Point","coordinates":[1,2]},` +
+		`"properties":{"name":"<b>bold</b>","height":12.5}}`)
+	features, err := decodeRawFeatures([]byte(`{"features":[` + string(feature) + `]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out strings.Builder
+	if err := (htmlEncoder{}).EncodeFeatureCollection(&out, "test", features); err != nil {
+		t.Fatal(err)
+	}
+	got := out.String()
+	if strings.Contains(got, "<b>") || strings.Contains(got, "a</script>") {
+		t.Errorf("expected HTML to be escaped, got %s", got)
+	}
+	if !strings.Contains(got, "<td>12.5</td>") {
+		t.Errorf("expected number property in table, got %s", got)
+	}
+
+	// The embedded GeoJSON must survive the escaping for scripts.
+	start := strings.Index(got, "JSON.parse(") + len("JSON.parse(")
+	end := strings.Index(got[start:], ")") + start
+	var literal string
+	if err := json.Unmarshal([]byte(got[start:end]), &literal); err != nil {
+		t.Fatalf("cannot decode script literal %s: %v", got[start:end], err)
+	}
+	if !strings.Contains(literal, `"name":"<b>bold</b>"`) {
+		t.Errorf("expected embedded GeoJSON, got %s", literal)
+	}
+}
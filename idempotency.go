@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// maxIdempotencyKeys bounds the memory for remembered responses. When
+// it is reached, requests with new keys are processed without being
+// remembered.
+const maxIdempotencyKeys = 10000
+
+// IdempotencyCache remembers the responses to POST requests that carry
+// an Idempotency-Key header, so a client that retries a request after
+// losing the connection gets the original response again instead of
+// applying the same write twice. This follows the IETF draft on the
+// Idempotency-Key HTTP header field.
+type IdempotencyCache struct {
+	window time.Duration
+
+	mutex   sync.Mutex
+	entries map[string]*idempotentRequest
+}
+
+type idempotentRequest struct {
+	request  string          // method and path, to detect reuse of keys
+	response *cachedResponse // nil while the request is being processed
+}
+
+func MakeIdempotencyCache(window time.Duration) *IdempotencyCache {
+	return &IdempotencyCache{window: window, entries: make(map[string]*idempotentRequest)}
+}
+
+// Serve handles a request with handler, unless the same request has
+// already been handled within the window, in which case the remembered
+// response gets replayed. Server errors are not remembered, so clients
+// can retry them.
+func (c *IdempotencyCache) Serve(w http.ResponseWriter, req *http.Request, handler http.HandlerFunc) {
+	key := req.Header.Get("Idempotency-Key")
+	if c == nil || req.Method != "POST" || len(key) == 0 {
+		handler(w, req)
+		return
+	}
+
+	request := req.Method + " " + req.URL.Path
+	now := time.Now()
+	c.mutex.Lock()
+	entry := c.entries[key]
+	if entry != nil && entry.response != nil && now.After(entry.response.expires) {
+		delete(c.entries, key)
+		entry = nil
+	}
+	if entry == nil {
+		c.expire(now)
+		if len(c.entries) < maxIdempotencyKeys {
+			c.entries[key] = &idempotentRequest{request: request}
+		}
+	}
+	c.mutex.Unlock()
+
+	switch {
+	case entry != nil && entry.request != request:
+		writeIdempotencyError(w, http.StatusUnprocessableEntity, "Idempotency-Key has been used for another request")
+		return
+	case entry != nil && entry.response == nil:
+		writeIdempotencyError(w, http.StatusConflict, "request with this Idempotency-Key is still being processed")
+		return
+	case entry != nil:
+		w.Header().Set("Idempotent-Replayed", "true")
+		entry.response.WriteTo(w)
+		return
+	}
+
+	recorder := &responseRecordingWriter{ResponseWriter: w}
+	handler(recorder, req)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	entry = c.entries[key]
+	if entry == nil || entry.response != nil {
+		return // not remembered because the cache was full
+	}
+	if recorder.status == 0 || recorder.status >= 500 {
+		delete(c.entries, key)
+		return
+	}
+	response := &cachedResponse{
+		status:  recorder.status,
+		header:  make(http.Header),
+		body:    recorder.body.Bytes(),
+		expires: time.Now().Add(c.window),
+	}
+	for key, values := range w.Header() {
+		response.header[key] = append([]string(nil), values...)
+	}
+	entry.response = response
+}
+
+// expire removes remembered responses whose window has passed.
+// Must be called with the mutex held.
+func (c *IdempotencyCache) expire(now time.Time) {
+	for key, entry := range c.entries {
+		if entry.response != nil && now.After(entry.response.expires) {
+			delete(c.entries, key)
+		}
+	}
+}
+
+func writeIdempotencyError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(status)
+	io.WriteString(w, msg+"\n")
+}
+
+// responseRecordingWriter keeps a copy of the status and body of a
+// response while passing it on.
+type responseRecordingWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *responseRecordingWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *responseRecordingWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestIdempotencyKey(t *testing.T) {
+	_, s, _, cleanup := makeUploadServer(t)
+	defer cleanup()
+	s.idempotency = MakeIdempotencyCache(time.Hour)
+
+	post := func(path, key string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", path, nil)
+		req.Header.Set("X-API-Key", adminAPIKey)
+		if key != "" {
+			req.Header.Set("Idempotency-Key", key)
+		}
+		resp := httptest.NewRecorder()
+		http.HandlerFunc(s.HandleRequest).ServeHTTP(resp, req)
+		return resp
+	}
+
+	first := post("/admin/collections/lakes/uploads", "k1")
+	if first.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", first.Code, getBody(first))
+	}
+	retry := post("/admin/collections/lakes/uploads", "k1")
+	if retry.Code != http.StatusCreated || retry.Header().Get("Location") != first.Header().Get("Location") {
+		t.Errorf("expected retry to replay %s, got %d %s", first.Header().Get("Location"),
+			retry.Code, retry.Header().Get("Location"))
+	}
+	if retry.Header().Get("Idempotent-Replayed") != "true" {
+		t.Error("expected Idempotent-Replayed header on replayed response")
+	}
+
+	if other := post("/admin/collections/lakes/uploads", "k2"); other.Header().Get("Location") == first.Header().Get("Location") {
+		t.Error("expected a new upload session for another Idempotency-Key")
+	}
+	if other := post("/admin/collections/lakes/uploads", ""); other.Header().Get("Location") == first.Header().Get("Location") {
+		t.Error("expected a new upload session without Idempotency-Key")
+	}
+	if reused := post("/admin/collections/lakes/upload", "k1"); reused.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected status 422 for key reused on another path, got %d", reused.Code)
+	}
+}
+
+func TestIdempotencyKey_Expiry(t *testing.T) {
+	c := MakeIdempotencyCache(time.Millisecond)
+	calls := 0
+	handler := func(w http.ResponseWriter, req *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusNoContent)
+	}
+	serve := func() {
+		req, _ := http.NewRequest("POST", "/admin/x", nil)
+		req.Header.Set("Idempotency-Key", "k")
+		c.Serve(httptest.NewRecorder(), req, handler)
+	}
+	serve()
+	serve()
+	if calls != 1 {
+		t.Errorf("expected 1 call within window, got %d", calls)
+	}
+	time.Sleep(5 * time.Millisecond)
+	serve()
+	if calls != 2 {
+		t.Errorf("expected 2 calls after window, got %d", calls)
+	}
+}
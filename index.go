@@ -3,10 +3,8 @@ package main
 import (
 	"encoding/json"
 	"errors"
-	//"fmt"
+	"fmt"
 	"io"
-	"io/ioutil"
-	"log"
 	"net/url"
 	"os"
 	"path/filepath"
@@ -25,33 +23,102 @@ import (
 )
 
 type Index struct {
-	Collections map[string]*Collection
-	mutex       sync.RWMutex
-	PublicPath  *url.URL
-	watcher     *fsnotify.Watcher
+	Collections     map[string]*Collection
+	mutex           sync.RWMutex
+	PublicPath      *url.URL
+	watcher         *fsnotify.Watcher
+	changeListeners []func(ChangeEvent)
+	groups          map[string]string // collection name -> group name
+	attributions    map[string]CollectionAttribution
+	descriptions    map[string]CollectionDescription
+	styles          map[string]CollectionStyle
+	maxMemory       int64 // approximate limit in bytes, or 0 for unlimited
+	keepTombstones  bool
+	tombstones      map[string][]Tombstone // collection name -> deleted features
+	history         map[string][]versionChange
+	uploadMutex     sync.Mutex // serializes uploads
+	uploads         UploadSessions
+	migrations      map[string][]PropertyMigration
+	elevation       *ElevationEnricher // nil if not adding elevation
+	regions         *RegionTagger      // nil if not tagging countries and regions
+	itemOrder       string             // ItemOrderID, ItemOrderHilbert, or empty for source order
+	splitMulti      map[string]bool    // collections whose multi-part features get split
+	warmingUp       int32              // accessed atomically; 1 while filling caches
+	maintenance     MaintenanceMode
+	spillThreshold  int           // minimal number of features for spilling the index to disk, or 0
+	spillCache      *PageCache    // pages of spilled indexes
+	refreshInterval time.Duration // how often to check remote sources for changes
+	refreshChanged  chan struct{}
+	loadTimes       map[string]time.Duration // how long collections took to load at startup
 }
 
+// defaultRefreshInterval is how often we check remote sources, such as
+// https:// URLs, for changes unless configured otherwise.
+const defaultRefreshInterval = time.Minute
+
 type CollectionMetadata struct {
 	Name         string
 	Path         string
 	LastModified time.Time
+	Version      string  // hash over feature content, changes when data changes
+	Group        string  // such as "hydrography", or empty if ungrouped
+	Title        string  // human-readable name, or empty if not configured
+	Description  string  // empty if not configured
+	Bbox         s2.Rect // union of all feature bounding boxes
+}
+
+// CollectionAttribution tells clients under which terms they may use
+// a collection. License is a URL, such as
+// https://opendatacommons.org/licenses/odbl/1-0/.
+type CollectionAttribution struct {
+	License     string `json:"license,omitempty"`
+	Attribution string `json:"attribution,omitempty"`
+}
+
+// CollectionDescription tells humans what a collection is about.
+type CollectionDescription struct {
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
 }
 
+// Collection is an immutable version of a feature collection. Its
+// features are stored back to back in dataFile, which gets written once
+// while loading. Uploads and reloads build a new Collection with a new
+// file and swap it in, so data files never accumulate dead space and
+// need no compaction.
 type Collection struct {
-	metadata    CollectionMetadata
-	tileCache   *TileCache
-	dataFile    *os.File // temporary file, will be deleted
-	offset      []int64  // offset into dataFile
-	bbox        []s2.Rect
-	webMercator []r2.Point
-	id          []string
-	byID        map[string]int // "W77" -> 3 if Features[3].ID == "W77"
+	metadata      CollectionMetadata
+	tileCache     *TileCache
+	dataFile      *os.File // temporary file, will be deleted
+	offset        []int64  // offset into dataFile
+	bbox          []s2.Rect
+	webMercator   []r2.Point
+	id            []string
+	hash          []uint64       // hash of encoded feature, for detecting changes
+	byID          map[string]int // "W77" -> 3 if Features[3].ID == "W77"
+	byShortToken  map[string]int // "k3Jd9x_Q" -> 3 if ShortToken(name, "W77") == "k3Jd9x_Q"
+	properties    propertyIndex  // "historic" -> "castle" -> [0, 1, 2]
+	propertyTypes propertyTypes  // "historic" -> "string", for listing queryables
+	spatial       *spatialIndex  // grid for finding features by bbox
+	spilled       *spilledIndex  // replaces the above arrays and maps if not nil
+	numLocated    int            // features with a geometry, as listed without a bbox
+	text          *textIndex     // full-text index for ?q= searches
+	previewOnce   sync.Once
+	preview       []byte // PNG thumbnail, rendered on first use
+
+	categoriesOnce sync.Once
+	categories     []string // value of the style property, read on first use
+
+	dataMemory  int64 // approximate size of the index, in bytes
+	cacheMemory int64 // approximate size of preview and categories, accessed atomically
 }
 
 func (c *Collection) Close() {
 	if c.dataFile != nil {
-		c.dataFile.Close()
-		os.Remove(c.dataFile.Name())
+		removeTempFile(c.dataFile)
+	}
+	if c.spilled != nil {
+		c.spilled.close()
 	}
 }
 
@@ -81,6 +148,11 @@ var (
 		Help: "Number of features per collection.",
 	},
 		[]string{"collection"})
+	collectionReusedFeatures = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "miniwfs_collection_reused_features",
+		Help: "Number of features whose index entries were taken over from the previous version when the collection was last loaded.",
+	},
+		[]string{"collection"})
 	collectionTimestamp = promauto.NewGaugeVec(prometheus.GaugeOpts{
 		Name: "miniwfs_collection_timestamp",
 		Help: "Timestamp of the collection, in seconds since the Unix epoch.",
@@ -88,10 +160,36 @@ var (
 		[]string{"collection", "stage"})
 )
 
-func MakeIndex(collections map[string]string, publicPath *url.URL) (*Index, error) {
+// IndexOptions tells how MakeIndex prepares the features of
+// collections when loading them. Setting them here, rather than calling
+// SetItemOrder and friends afterwards, avoids loading every collection
+// twice at startup. The zero value loads collections as they are.
+type IndexOptions struct {
+	Migrations           map[string][]PropertyMigration
+	Elevation            *ElevationEnricher // nil for not adding elevation
+	Regions              *RegionTagger      // nil for not tagging countries and regions
+	ItemOrder            string             // ItemOrderSource, ItemOrderID or ItemOrderHilbert; empty for source order
+	SplitMultiGeometries []string           // collections whose multi-part features get split
+	SpillThreshold       int                // minimal number of features for spilling the index to disk, or 0
+	SpillCacheBytes      int64              // memory for caching pages of spilled indexes
+}
+
+func MakeIndex(collections map[string]string, publicPath *url.URL, opts IndexOptions) (*Index, error) {
 	index := &Index{
-		Collections: make(map[string]*Collection),
-		PublicPath:  publicPath,
+		Collections:     make(map[string]*Collection),
+		PublicPath:      publicPath,
+		refreshInterval: defaultRefreshInterval,
+		refreshChanged:  make(chan struct{}, 1),
+		loadTimes:       make(map[string]time.Duration, len(collections)),
+		migrations:      opts.Migrations,
+		elevation:       opts.Elevation,
+		regions:         opts.Regions,
+		itemOrder:       normalizeItemOrder(opts.ItemOrder),
+		splitMulti:      makeSplitSet(opts.SplitMultiGeometries),
+		spillThreshold:  opts.SpillThreshold,
+	}
+	if opts.SpillThreshold > 0 {
+		index.spillCache = MakePageCache(opts.SpillCacheBytes)
 	}
 
 	if watcher, err := fsnotify.NewWatcher(); err == nil {
@@ -101,17 +199,26 @@ func MakeIndex(collections map[string]string, publicPath *url.URL) (*Index, erro
 	}
 
 	go index.watchFiles()
+	go index.refreshRemoteSources()
 	for name, path := range collections {
 		var t0 time.Time // The zero value of type Time is January 1, year 1.
-		coll, err := readCollection(name, path, t0)
+		started := time.Now()
+		coll, err := readMigratedCollection(name, path, t0, index.getLoadOptions(name))
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("collection %s: %v", name, err)
 		}
 		index.Collections[name] = coll
+		index.loadTimes[name] = time.Since(started)
 	}
 
 	for _, c := range index.Collections {
-		dirPath := filepath.Dir(c.metadata.Path)
+		if !isLocalSource(c.metadata.Path) {
+			continue
+		}
+		dirPath := sourceWatchDirectory(c.metadata.Path)
+		if len(dirPath) == 0 {
+			continue
+		}
 		if err := index.watcher.Add(dirPath); err != nil {
 			return nil, err
 		}
@@ -121,13 +228,20 @@ func MakeIndex(collections map[string]string, publicPath *url.URL) (*Index, erro
 }
 
 func (index *Index) Close() {
+	index.uploads.closeAll()
 	index.mutex.Lock()
 	defer index.mutex.Unlock()
 	for _, c := range index.Collections {
 		c.Close()
-		index.watcher.Remove(filepath.Dir(c.metadata.Path))
+		if dirPath := sourceWatchDirectory(c.metadata.Path); len(dirPath) > 0 {
+			index.watcher.Remove(dirPath)
+		}
 	}
 	index.Collections = make(map[string]*Collection)
+
+	// Closing the watcher also ends watchFiles, which would otherwise
+	// hold on to an inotify instance for the lifetime of the process.
+	index.watcher.Close()
 }
 
 func (index *Index) GetCollections() []CollectionMetadata {
@@ -136,12 +250,61 @@ func (index *Index) GetCollections() []CollectionMetadata {
 
 	md := make([]CollectionMetadata, 0, len(index.Collections))
 	for _, coll := range index.Collections {
-		md = append(md, coll.metadata)
+		m := coll.metadata
+		m.Group = index.groups[m.Name]
+		m.Title = index.descriptions[m.Name].Title
+		m.Description = index.descriptions[m.Name].Description
+		md = append(md, m)
 	}
 	sort.Slice(md, func(i, j int) bool { return md[i].Name < md[j].Name })
 	return md
 }
 
+// SetCollectionAttributions configures license and attribution for
+// collections, keyed by collection name.
+func (index *Index) SetCollectionAttributions(attributions map[string]CollectionAttribution) {
+	index.mutex.Lock()
+	defer index.mutex.Unlock()
+	index.attributions = attributions
+}
+
+// GetAttribution returns the license and attribution of a collection.
+// The fields are empty if none have been configured.
+func (index *Index) GetAttribution(collection string) CollectionAttribution {
+	index.mutex.RLock()
+	defer index.mutex.RUnlock()
+	return index.attributions[collection]
+}
+
+// SetCollectionDescriptions configures title and description for
+// collections, keyed by collection name.
+func (index *Index) SetCollectionDescriptions(descriptions map[string]CollectionDescription) {
+	index.mutex.Lock()
+	defer index.mutex.Unlock()
+	index.descriptions = descriptions
+}
+
+// SetCollectionGroups organizes collections into named groups, such as
+// "hydrography" or "heritage". The argument maps collection names to
+// group names; collections that do not appear in it stay ungrouped.
+func (index *Index) SetCollectionGroups(groups map[string]string) {
+	index.mutex.Lock()
+	defer index.mutex.Unlock()
+	index.groups = groups
+}
+
+// GetMetadata returns the metadata of a collection, or NotFound.
+func (index *Index) GetMetadata(collection string) (CollectionMetadata, error) {
+	index.mutex.RLock()
+	defer index.mutex.RUnlock()
+
+	coll := index.Collections[collection]
+	if coll == nil {
+		return CollectionMetadata{}, NotFound
+	}
+	return coll.metadata, nil
+}
+
 func (index *Index) GetItem(collection string, id string) (*geojson.Feature, error) {
 	index.mutex.RLock()
 	defer index.mutex.RUnlock()
@@ -151,15 +314,18 @@ func (index *Index) GetItem(collection string, id string) (*geojson.Feature, err
 		return nil, nil
 	}
 
-	i, ok := coll.byID[id]
+	i, ok := coll.lookupID(id)
 	if !ok {
 		return nil, nil
 	}
 
-	offset := coll.offset[i]
-	jsonLen := int(coll.offset[i+1] - offset - 2)
-	b := make([]byte, jsonLen)
-	if _, err := coll.dataFile.ReadAt(b, offset); err != nil {
+	return coll.readFeature(i)
+}
+
+// readFeature reads the i-th feature from the collection's data file.
+func (c *Collection) readFeature(i int) (*geojson.Feature, error) {
+	b, err := c.readRawFeature(i)
+	if err != nil {
 		return nil, err
 	}
 
@@ -171,15 +337,79 @@ func (index *Index) GetItem(collection string, id string) (*geojson.Feature, err
 	return &result, nil
 }
 
-// We take both startID and startIndex to be more resilient when our
-// data changes while a client is iterating over paged results. If
-// startID is a known ID, we start the iteration there; otherwise, we
-// start the iteration at the feature whose index is startIndex.
+// readRawFeature reads the GeoJSON encoding of the i-th feature.
+func (c *Collection) readRawFeature(i int) ([]byte, error) {
+	offset, jsonLen := c.featureRange(i)
+	b := make([]byte, jsonLen)
+	if _, err := c.dataFile.ReadAt(b, offset); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func (index *Index) HasCollection(collection string) bool {
+	index.mutex.RLock()
+	defer index.mutex.RUnlock()
+
+	return index.Collections[collection] != nil
+}
+
+// HasItem returns true if the collection contains a feature with the given ID.
+func (index *Index) HasItem(collection string, id string) bool {
+	index.mutex.RLock()
+	defer index.mutex.RUnlock()
+
+	if coll := index.Collections[collection]; coll != nil {
+		_, ok := coll.lookupID(id)
+		return ok
+	}
+	return false
+}
+
+// ResolveShortToken returns the collection and feature ID for a token
+// that was computed by ShortToken. If the token is unknown, the
+// returned strings are empty.
+func (index *Index) ResolveShortToken(token string) (string, string) {
+	index.mutex.RLock()
+	defer index.mutex.RUnlock()
+
+	for name, coll := range index.Collections {
+		if i, ok := coll.lookupShortToken(token); ok {
+			return name, coll.featureID(i)
+		}
+	}
+	return "", ""
+}
+
+// itemsQuery tells which page of items to get from a collection.
+type itemsQuery struct {
+	itemsSelection
+	startID        string // feature at the start of the page, if known
+	start          int    // number of matching features before the page
+	limit          int
+	linkCollection string     // collection name in page links, if different
+	linkParams     url.Values // kept in page links, such as URL signatures
+}
+
+// We take both startID and start to be more resilient when our data
+// changes while a client is iterating over paged results. If startID
+// is a known ID, we start the iteration there; otherwise, we skip the
+// first start features that match the query. Either way, start counts
+// the matching features before the page, so that links to other pages
+// can be computed from it.
 //
 // If the collection has not been modified since time ifModifiedSince,
 // we return error NotModified (unless ifModifiedSince.IsZero() is true).
-func (index *Index) GetItems(collection string, startID string, startIndex int, limit int, bbox s2.Rect,
-	ifModifiedSince time.Time, ifUnmodifiedSince time.Time, includeLinks bool, out io.Writer) (CollectionMetadata, error) {
+//
+// If linkPrefix is not empty, such as "https://example.org/wfs/", the
+// page links to itself and its neighbours with URLs below linkPrefix.
+//
+// If includeDeleted is true, the first page also lists the tombstones
+// of deleted features, regardless of bbox.
+//
+// The returned plan tells how the features were found, see planQuery.
+func (index *Index) GetItems(collection string, q itemsQuery, ifModifiedSince time.Time, ifUnmodifiedSince time.Time,
+	linkPrefix string, includeDeleted bool, out io.Writer) (CollectionMetadata, QueryPlan, error) {
 	// We intentionally return CollectionMetadata and not *CollectionMetadata
 	// so that the metadata gets copied before unlocking the reader mutex.
 	// Otherwise, the metadata content could change after returning from
@@ -191,55 +421,95 @@ func (index *Index) GetItems(collection string, startID string, startIndex int,
 
 	coll := index.Collections[collection]
 	if coll == nil {
-		return CollectionMetadata{}, NotFound
+		return CollectionMetadata{}, QueryPlan{}, NotFound
 	}
 
-	lastModified := coll.metadata.LastModified.Round(time.Second).UTC()
+	// Last-Modified headers truncate to seconds, so we must do the same
+	// for clients to revalidate with the time we told them.
+	lastModified := coll.metadata.LastModified.Truncate(time.Second).UTC()
 	if !ifUnmodifiedSince.IsZero() && lastModified.After(ifUnmodifiedSince.Round(time.Second).UTC()) {
-		return coll.metadata, Modified
+		return coll.metadata, QueryPlan{}, Modified
 	}
 	if !ifModifiedSince.IsZero() && !lastModified.After(ifModifiedSince.Round(time.Second).UTC()) {
-		return coll.metadata, NotModified
+		return coll.metadata, QueryPlan{}, NotModified
 	}
 
-	if limit < 1 {
-		limit = 1
-	} else if limit > MaxLimit {
-		limit = MaxLimit
+	if q.limit < 1 {
+		q.limit = 1
+	} else if q.limit > MaxLimit {
+		q.limit = MaxLimit
 	}
-
-	if len(startID) > 0 {
-		if i, ok := coll.byID[startID]; ok {
-			startIndex = i
-		}
+	if q.start < 0 {
+		q.start = 0
 	}
 
-	if startIndex < 0 {
-		startIndex = 0
+	// Without a full-text query, features are returned in collection
+	// order; otherwise, in the order of relevance.
+	plan := coll.planQuery(q.bbox, q.filter, q.ids, q.query)
+
+	// When we know where the page starts, we need not skip over the
+	// matching features before it.
+	first, skip := 0, q.start
+	if len(q.startID) > 0 {
+		if i, ok := coll.lookupID(q.startID); ok {
+			switch {
+			case plan.features == nil:
+				first, skip = i, 0
+				// If nothing gets filtered out, the number of features
+				// before the page is the index of its first feature.
+				if q.itemsSelection.isEmpty() && coll.numLocated == coll.numFeatures() {
+					q.start = i
+				}
+			case plan.ranked:
+				for k, r := range plan.features {
+					if r == i {
+						first, skip = k, 0
+						break
+					}
+				}
+			default:
+				first, skip = sort.SearchInts(plan.features, i), 0
+			}
+		}
 	}
 
 	if _, err := out.Write([]byte(`{"type":"FeatureCollection","features":[`)); err != nil {
-		return CollectionMetadata{}, err
+		return CollectionMetadata{}, QueryPlan{}, err
 	}
 
-	// If we had more data, we could compute s2 cell coverages and only
-	// check the intersection for features inside the coverage area.
-	// But we operate on a few thousand features, so let's keep things simple
-	// for the time being.
 	bounds := s2.EmptyRect()
 	var nextID string
-	var nextIndex int
-	skip := startIndex
+	hasNext := false
 	numFeatures := 0
 	buffer := make([]byte, 0, 50*1024)
-	for i, featureBounds := range coll.bbox {
-		if !bbox.Intersects(featureBounds) {
+	matches, selected := coll.matcher(q.filter), coll.idMatcher(q.ids)
+	for k := first; k < plan.Candidates; k++ {
+		i := k
+		if plan.features != nil {
+			i = plan.features[k]
+		}
+		featureBounds := coll.featureBounds(i)
+		if !q.bbox.Intersects(featureBounds) || !matches(i) || !selected(i) {
+			continue
+		}
+		if q.join != nil && !q.join.region.RectBound().Intersects(featureBounds) {
 			continue
 		}
+		if q.cql != nil || q.join != nil {
+			f, err := coll.readFeature(i)
+			if err != nil {
+				return CollectionMetadata{}, QueryPlan{}, err
+			}
+			if q.cql != nil && !q.cql.Matches(f.Properties, featureBounds) {
+				continue
+			}
+			if q.join != nil && !q.join.intersects(f.Geometry) {
+				continue
+			}
+		}
 
-		if numFeatures >= limit {
-			nextID = coll.id[i]
-			nextIndex = i
+		if numFeatures >= q.limit {
+			nextID, hasNext = coll.featureID(i), true
 			break
 		}
 		if skip > 0 {
@@ -249,20 +519,20 @@ func (index *Index) GetItems(collection string, startID string, startIndex int,
 
 		if numFeatures > 0 {
 			if _, err := out.Write([]byte{','}); err != nil {
-				return CollectionMetadata{}, err
+				return CollectionMetadata{}, QueryPlan{}, err
 			}
 		}
 
 		b := buffer
-		jsonLen := int(coll.offset[i+1] - coll.offset[i] - 2)
+		offset, jsonLen := coll.featureRange(i)
 		if jsonLen > cap(b) {
 			b = make([]byte, 0, jsonLen)
 		}
-		if _, err := coll.dataFile.ReadAt(b[0:jsonLen], coll.offset[i]); err != nil {
-			return CollectionMetadata{}, err
+		if _, err := coll.dataFile.ReadAt(b[0:jsonLen], offset); err != nil {
+			return CollectionMetadata{}, QueryPlan{}, err
 		}
 		if _, err := out.Write(b[0:jsonLen]); err != nil {
-			return CollectionMetadata{}, err
+			return CollectionMetadata{}, QueryPlan{}, err
 		}
 
 		numFeatures += 1
@@ -270,47 +540,75 @@ func (index *Index) GetItems(collection string, startID string, startIndex int,
 	}
 
 	if _, err := out.Write([]byte(`],`)); err != nil {
-		return CollectionMetadata{}, err
+		return CollectionMetadata{}, QueryPlan{}, err
 	}
 
 	type Footer struct {
-		Links       []*WFSLink `json:"links,omitempty"`
-		BoundingBox []float64  `json:"bbox"`
+		Links       []*WFSLink  `json:"links,omitempty"`
+		BoundingBox []float64   `json:"bbox"`
+		Deleted     []Tombstone `json:"deleted,omitempty"`
+		CollectionAttribution
 	}
 	var footer Footer
-
-	pathPrefix := index.PublicPath.String()
-	selfLink := &WFSLink{
-		Rel:   "self",
-		Title: "self",
-		Type:  "application/geo+json",
+	footer.CollectionAttribution = index.attributions[collection]
+	if includeDeleted && q.start == 0 && len(q.startID) == 0 {
+		footer.Deleted = index.tombstones[collection]
 	}
 
 	footer.BoundingBox = EncodeBbox(bounds)
-	if includeLinks {
-		selfLink.Href = FormatItemsURL(pathPrefix, collection, startID, startIndex, limit, bbox)
-		footer.Links = append(footer.Links, selfLink)
-
-		if nextIndex > 0 {
-			nextLink := &WFSLink{
-				Rel:   "next",
-				Title: "next",
+	if len(linkPrefix) > 0 {
+		linkCollection := collection
+		if len(q.linkCollection) > 0 {
+			linkCollection = q.linkCollection
+		}
+		link := func(rel string, title string, startID string, start int) *WFSLink {
+			page := q
+			page.startID, page.start = startID, start
+			return &WFSLink{
+				Rel:   rel,
+				Title: title,
 				Type:  "application/geo+json",
+				Href:  FormatItemsURL(linkPrefix, linkCollection, page),
+			}
+		}
+
+		footer.Links = append(footer.Links, link("self", "self", q.startID, q.start))
+		if hasNext {
+			footer.Links = append(footer.Links, link("next", "next", nextID, q.start+numFeatures))
+		}
+		if q.start > 0 {
+			prevStart := q.start - q.limit
+			if prevStart < 0 {
+				prevStart = 0
+			}
+			footer.Links = append(footer.Links, link("prev", "previous", "", prevStart))
+		}
+		footer.Links = append(footer.Links, link("first", "first", "", 0))
+
+		// Counting the matches of a selective query would need a scan
+		// of the whole collection, so we only know the last page when
+		// nothing gets filtered out, or when we have just reached it.
+		switch {
+		case q.itemsSelection.isEmpty():
+			lastStart := 0
+			if coll.numLocated > 0 {
+				lastStart = (coll.numLocated - 1) / q.limit * q.limit
 			}
-			nextLink.Href = FormatItemsURL(pathPrefix, collection, nextID, nextIndex, limit, bbox)
-			footer.Links = append(footer.Links, nextLink)
+			footer.Links = append(footer.Links, link("last", "last", "", lastStart))
+		case !hasNext:
+			footer.Links = append(footer.Links, link("last", "last", "", q.start))
 		}
 	}
 
 	encodedFooter, err := json.Marshal(footer)
 	if err != nil {
-		return CollectionMetadata{}, err
+		return CollectionMetadata{}, QueryPlan{}, err
 	}
 	if _, err := out.Write(encodedFooter[1:]); err != nil {
-		return CollectionMetadata{}, err
+		return CollectionMetadata{}, QueryPlan{}, err
 	}
 
-	return coll.metadata, nil
+	return coll.metadata, *plan, nil
 }
 
 func (index *Index) watchFiles() {
@@ -323,34 +621,76 @@ func (index *Index) watchFiles() {
 		select {
 		case <-ticker.C:
 			for _, md := range index.GetCollections() {
-				index.reloadIfChanged(md)
+				if isLocalSource(md.Path) {
+					index.reloadIfChanged(md)
+				}
 			}
 
 		case event, ok := <-index.watcher.Events:
-			log.Printf("Watcher event: %v\n", event)
 			if !ok {
 				return
 			}
-			if event.Op&fsnotify.Remove == fsnotify.Remove {
-				return
+			watcherLog.Debug("watcher event", "event", event.String())
+
+			// Uploads and edits write temporary files next to the
+			// sources, so we ignore other files in watched directories.
+			// A removed source may get replaced soon, as editors often
+			// do; if not, we keep serving its last version.
+			md := index.getCollectionMetadata(event.Name)
+			if md == nil || event.Op&fsnotify.Remove == fsnotify.Remove {
+				continue
 			}
-			path := event.Name
-			md := index.getCollectionMetadata(path)
-			if md != nil {
-				index.reloadIfChanged(*md)
+			index.reloadIfChanged(*md)
+		}
+	}
+}
+
+// refreshRemoteSources checks collections loaded from URLs for changes,
+// since we cannot watch them. Loaders make this cheap by asking servers
+// whether the data has changed, for example with ETags.
+func (index *Index) refreshRemoteSources() {
+	for {
+		index.mutex.RLock()
+		interval := index.refreshInterval
+		index.mutex.RUnlock()
+
+		timer := time.NewTimer(interval)
+		select {
+		case <-timer.C:
+			for _, md := range index.GetCollections() {
+				if !isLocalSource(md.Path) {
+					index.reloadIfChanged(md)
+				}
 			}
+
+		case <-index.refreshChanged:
+			timer.Stop()
 		}
 	}
 }
 
-func (index *Index) GetTile(collection string, zoom int, x int, y int) ([]byte, CollectionMetadata, error) {
+// SetRefreshInterval configures how often collections loaded from URLs
+// get checked for changes.
+func (index *Index) SetRefreshInterval(interval time.Duration) {
+	if interval <= 0 {
+		interval = defaultRefreshInterval
+	}
+	index.mutex.Lock()
+	index.refreshInterval = interval
+	index.mutex.Unlock()
+	select {
+	case index.refreshChanged <- struct{}{}:
+	default:
+	}
+}
+
+func (index *Index) GetTile(collection string, tileKey TileKey) ([]byte, CollectionMetadata, error) {
 	index.mutex.RLock()
 	defer index.mutex.RUnlock()
 
-	if x < 0 || y < 0 || zoom < 0 || zoom > 30 {
+	if !tileKey.IsValid() {
 		return nil, CollectionMetadata{}, NotFound
 	}
-	tileKey := TileKey{X: uint32(x), Y: uint32(y), Zoom: uint8(zoom)}
 
 	coll := index.Collections[collection]
 	if coll == nil {
@@ -362,35 +702,166 @@ func (index *Index) GetTile(collection string, zoom int, x int, y int) ([]byte,
 		return cached, coll.metadata, nil
 	}
 
-	scale := 1 << uint8(zoom)
-	tileBounds := getTileBounds(zoom, x, y)
-	tileOrigin := r2.Point{X: float64(x) * 256.0 / float64(scale),
-		Y: float64(y) * 256.0 / float64(scale)}
-
-	var tile Tile
-	for i, featureBounds := range coll.bbox {
-		if !tileBounds.Intersects(featureBounds) {
-			continue
-		}
-		p := coll.webMercator[i].Sub(tileOrigin).Mul(float64(scale))
-		tile.DrawPoint(p)
-	}
+	tile, _ := index.renderTile(coll, collection, tileKey)
 	png := tile.ToPNG()
 	coll.tileCache.Put(tileKey, png)
+	collectionMemory.WithLabelValues(collection).Set(float64(coll.MemoryUsage()))
 	numTileCacheMisses.Inc()
 	return png, coll.metadata, nil
 }
 
-func (index *Index) reloadIfChanged(md CollectionMetadata) {
-	if coll, err := readCollection(md.Name, md.Path, md.LastModified); err == nil {
-		log.Printf("success reading collection %s from %s", md.Name, md.Path)
-		index.replaceCollection(coll)
-	} else if err == NotModified {
-		// log.Printf("no change in collection %s at %s",
-		//	md.Name, md.Path)
-	} else {
-		log.Printf("error reading collection %s at %s: %v",
-			md.Name, md.Path, err)
+// GetDebugTile renders a tile with an overlay showing the tile key,
+// the number of drawn features, the render time and the tile border.
+// Debug tiles bypass the tile cache, so the render time is accurate.
+func (index *Index) GetDebugTile(collection string, tileKey TileKey) ([]byte, CollectionMetadata, error) {
+	index.mutex.RLock()
+	defer index.mutex.RUnlock()
+
+	if !tileKey.IsValid() {
+		return nil, CollectionMetadata{}, NotFound
+	}
+
+	coll := index.Collections[collection]
+	if coll == nil {
+		return nil, CollectionMetadata{}, NotFound
+	}
+
+	start := time.Now()
+	tile, numFeatures := index.renderTile(coll, collection, tileKey)
+	tile.DrawDebugOverlay(tileKey, numFeatures, time.Since(start))
+	return tile.ToPNG(), coll.metadata, nil
+}
+
+// renderTile draws the features of a collection that intersect a tile,
+// returning the tile and the number of drawn features. Must be called
+// with the mutex held.
+func (index *Index) renderTile(coll *Collection, collection string, tileKey TileKey) (*Tile, int) {
+	tileBounds := tileKey.Bounds()
+	style := index.getStyle(collection)
+	var categories []string
+	if len(style.Property) > 0 {
+		categories = coll.getCategories(style.Property)
+	}
+	tile := &Tile{}
+	tile.Color, _ = parseHexColor(style.Color)
+	numFeatures := 0
+	for i := 0; i < coll.numFeatures(); i++ {
+		if !tileBounds.Intersects(coll.featureBounds(i)) {
+			continue
+		}
+		p := tilePixel(coll.featurePoint(i), tileKey)
+		if categories != nil {
+			tile.DrawMarker(p, style.getMarker(categories[i]))
+		} else {
+			tile.DrawPoint(p)
+		}
+		numFeatures++
+	}
+	return tile, numFeatures
+}
+
+// ReloadCollection reloads a collection if its source has changed.
+// Returns NotFound if there is no such collection.
+func (index *Index) ReloadCollection(collection string) error {
+	index.mutex.RLock()
+	coll := index.Collections[collection]
+	index.mutex.RUnlock()
+	if coll == nil {
+		return NotFound
+	}
+	index.reloadIfChanged(coll.metadata)
+	return nil
+}
+
+// reloadIfChanged reloads a collection if its source has been modified
+// after md.LastModified. Returns true if the collection got replaced.
+func (index *Index) reloadIfChanged(md CollectionMetadata) (bool, error) {
+	if index.GetMaintenanceMode().Enabled {
+		loaderLog.Debug("not reloading collection during maintenance", "collection", md.Name)
+		return false, nil
+	}
+	opts := index.getLoadOptions(md.Name)
+	coll, err := readMigratedCollection(md.Name, md.Path, md.LastModified, opts)
+	if err == NotModified {
+		loaderLog.Debug("no change in collection", "collection", md.Name, "path", md.Path)
+		return false, nil
+	} else if err != nil {
+		loaderLog.Error("reading collection failed", "collection", md.Name, "path", md.Path, "error", err)
+		recentAlerts.LoadFailed(md.Name, err, time.Now())
+		return false, err
+	}
+	loaderLog.Info("read collection", "collection", md.Name, "path", md.Path)
+	if err := index.replaceCollection(coll); err != nil {
+		loaderLog.Error("replacing collection failed", "collection", md.Name, "error", err)
+		recentAlerts.LoadFailed(md.Name, err, time.Now())
+		return false, err
+	}
+	return true, nil
+}
+
+// loadOptions tells how to prepare the features of a collection when
+// loading it: first migrations get applied, then enrichers, and finally
+// the features get sorted into the configured item order.
+type loadOptions struct {
+	migrations     []PropertyMigration
+	enrichers      []FeatureEnricher
+	order          string
+	splitMulti     bool        // split multi-part features, see splitMultiGeometry
+	spillThreshold int         // spill the index to disk if there are this many features, unless 0
+	spillCache     *PageCache  // for reading spilled indexes
+	previous       *Collection // version being replaced, for reusing unchanged entries; may be nil
+}
+
+func (index *Index) getLoadOptions(collection string) loadOptions {
+	opts := loadOptions{
+		migrations: index.getMigrations(collection),
+		enrichers:  index.getEnrichers(collection),
+	}
+	index.mutex.RLock()
+	opts.order = index.itemOrder
+	opts.splitMulti = index.splitMulti[collection]
+	opts.spillThreshold, opts.spillCache = index.spillThreshold, index.spillCache
+	opts.previous = index.Collections[collection]
+	index.mutex.RUnlock()
+	return opts
+}
+
+// FeatureEnricher adds properties to features when their collection
+// gets loaded, such as elevation or country codes.
+type FeatureEnricher interface {
+	Enrich(collection string, features []*geojson.Feature)
+}
+
+// getEnrichers returns the enrichers that apply to a collection.
+func (index *Index) getEnrichers(collection string) []FeatureEnricher {
+	index.mutex.RLock()
+	defer index.mutex.RUnlock()
+	var result []FeatureEnricher
+	if index.elevation.appliesTo(collection) {
+		result = append(result, index.elevation)
+	}
+	if index.regions.appliesTo(collection) {
+		result = append(result, index.regions)
+	}
+	return result
+}
+
+// reloadCollections reloads the collections whose name matches,
+// even if their source has not changed, because the way we load
+// them has changed.
+func (index *Index) reloadCollections(matches func(name string) bool) {
+	index.mutex.RLock()
+	var reload []CollectionMetadata
+	for name, coll := range index.Collections {
+		if matches(name) {
+			reload = append(reload, coll.metadata)
+		}
+	}
+	index.mutex.RUnlock()
+
+	for _, md := range reload {
+		md.LastModified = time.Time{}
+		index.reloadIfChanged(md)
 	}
 }
 
@@ -399,62 +870,139 @@ func (index *Index) getCollectionMetadata(path string) *CollectionMetadata {
 	defer index.mutex.Unlock()
 
 	for _, c := range index.Collections {
-		if path == c.metadata.Path {
+		if sourceIncludesFile(c.metadata.Path, path) {
 			return &c.metadata
 		}
 	}
 	return nil
 }
 
-func (index *Index) replaceCollection(c *Collection) {
+// AddChangeListener registers a function that gets called after a
+// reload has changed the content of a collection. Listeners are called
+// without holding the index lock, so they may call back into the index.
+func (index *Index) AddChangeListener(f func(ChangeEvent)) {
 	index.mutex.Lock()
 	defer index.mutex.Unlock()
+	index.changeListeners = append(index.changeListeners, f)
+}
+
+// replaceCollection swaps in a newly loaded collection, unless this would
+// exceed the memory limit, in which case it returns MemoryExceeded and
+// keeps the previous version.
+func (index *Index) replaceCollection(c *Collection) error {
+	index.mutex.Lock()
+	if err := index.checkMemory(c); err != nil {
+		index.mutex.Unlock()
+		c.Close()
+		return err
+	}
+	var event *ChangeEvent
 	if old := index.Collections[c.metadata.Name]; old != nil {
+		if old.metadata.Version != c.metadata.Version {
+			diff := diffCollections(old, c)
+			event = makeChangeEvent(old, c, diff)
+			index.recordVersionChange(c.metadata.Name, old.metadata.Version, c.metadata.Version, diff)
+			if index.keepTombstones {
+				index.updateTombstones(c.metadata.Name, diff, event.Timestamp)
+				event.Deleted = diff.Removed
+			}
+		}
 		old.Close()
 	}
 	index.Collections[c.metadata.Name] = c
+	listeners := index.changeListeners
+	index.mutex.Unlock()
+
+	if event != nil {
+		for _, listener := range listeners {
+			listener(*event)
+		}
+	}
+	return nil
 }
 
 var Modified error = errors.New("FeatureCollection has been modified")
 var NotFound error = errors.New("FeatureCollection not found")
 var NotModified error = errors.New("FeatureCollection not modified")
+var MemoryExceeded error = errors.New("FeatureCollection would exceed memory limit")
 
 // Returns NotModified if the collection has not been modfied since time ifModifiedSince.
 func readCollection(name string, path string, ifModifiedSince time.Time) (*Collection, error) {
-	absPath, err := filepath.Abs(path)
+	return readMigratedCollection(name, path, ifModifiedSince, loadOptions{})
+}
+
+// enrichBatchSize is how many streamed features get passed to enrichers
+// at once.
+var enrichBatchSize = 10000
+
+// readMigratedCollection reads a collection like readCollection, and
+// prepares its features as told by opts before storing them.
+func readMigratedCollection(name string, path string, ifModifiedSince time.Time, opts loadOptions) (*Collection, error) {
+	loader, err := GetInputLoader(path)
 	if err != nil {
 		numDataLoadErrors.Inc()
 		return nil, err
 	}
 
-	stat, err := os.Stat(absPath)
+	source := path
+	if isLocalSource(path) {
+		if source, err = filepath.Abs(path); err != nil {
+			numDataLoadErrors.Inc()
+			return nil, err
+		}
+	}
+
+	modTime, err := loader.ModTime(source)
 	if err != nil {
 		numDataLoadErrors.Inc()
 		return nil, err
 	}
 
-	if !stat.ModTime().After(ifModifiedSince) {
+	if !modTime.After(ifModifiedSince) {
 		return nil, NotModified
 	}
 
-	data, err := ioutil.ReadFile(absPath)
-	if err != nil {
-		numDataLoadErrors.Inc()
-		return nil, err
+	// Item orders need all features at once. Otherwise, streaming
+	// loaders pass features one by one, and we split, migrate and
+	// enrich them on the way, so the source does not need to fit in
+	// memory.
+	streamer, streaming := loader.(StreamingInputLoader)
+	if len(opts.order) > 0 && opts.order != ItemOrderSource {
+		streaming = false
 	}
 
-	coll := &Collection{tileCache: NewTileCache(10000)}
-	coll.metadata.LastModified = stat.ModTime()
-	coll.metadata.Name = name
-	coll.metadata.Path = absPath
+	// While we are loading, the previous version keeps serving, so
+	// both are in memory at the same time. Its tile cache is the
+	// largest part that we can drop without breaking requests; tiles
+	// get rendered again on demand.
+	if opts.previous != nil {
+		opts.previous.tileCache.Clear()
+	}
 
-	var features geojson.FeatureCollection
-	if err := json.Unmarshal(data, &features); err != nil {
-		numDataLoadErrors.Inc()
-		return nil, err
+	var data *SourceData
+	if !streaming {
+		if data, err = loader.Load(source); err != nil {
+			numDataLoadErrors.Inc()
+			return nil, err
+		}
+		if opts.splitMulti {
+			data.Features = splitMultiGeometries(data.Features)
+		}
+		if len(opts.migrations) > 0 {
+			migrateFeatures(name, data.Features, opts.migrations)
+		}
+		for _, e := range opts.enrichers {
+			e.Enrich(name, data.Features)
+		}
+		sortFeatures(data.Features, opts.order)
 	}
 
-	dataFile, err := ioutil.TempFile("", "miniwfs-*.geojson")
+	coll := &Collection{tileCache: NewTileCache(10000)}
+	coll.metadata.LastModified = modTime
+	coll.metadata.Name = name
+	coll.metadata.Path = source
+
+	dataFile, err := createTempFile("miniwfs-*.geojson")
 	if err != nil {
 		return nil, err
 	}
@@ -467,89 +1015,214 @@ func readCollection(name string, path string, ifModifiedSince time.Time) (*Colle
 	}
 	pos := int64(headerSize)
 
-	numFeatures := len(features.Features)
-	coll.bbox = make([]s2.Rect, numFeatures)
-	coll.id = make([]string, numFeatures)
-	coll.webMercator = make([]r2.Point, numFeatures)
-	coll.offset = make([]int64, numFeatures+1)
 	coll.byID = make(map[string]int)
+	coll.byShortToken = make(map[string]int)
+	coll.properties = make(propertyIndex)
+	coll.propertyTypes = make(propertyTypes)
+	coll.text = makeTextIndex()
+	coll.metadata.Bbox = s2.EmptyRect()
+
+	// When reloading, features that are unchanged since the previous
+	// version, as told by their ID and hash, take over its bounds, which
+	// are costly to compute for large geometries. This does not lower
+	// the memory peak of reloading: we still decode and encode every
+	// feature to find its hash, and the new version gets its own index,
+	// see BenchmarkReload. Spilled indexes are not reused, since the
+	// previous version may get closed while we are still loading.
+	previous := opts.previous
+	if previous != nil && previous.spilled != nil {
+		previous = nil
+	}
+	numReused := 0
+
+	add := func(f *geojson.Feature) error {
+		encoded, err := json.Marshal(f)
+		if err != nil {
+			return err
+		}
+		hash := hashFeature(encoded)
+
+		i := len(coll.id)
+		id := getIDString(f.ID)
+		var bounds s2.Rect
+		var point r2.Point
+		if j, ok := previous.reusableEntry(id, hash); ok {
+			id, bounds, point = previous.id[j], previous.bbox[j], previous.webMercator[j]
+			numReused++
+		} else {
+			bounds = computeBounds(f.Geometry)
+			point = projectWebMercator(bounds.Center())
+		}
 
-	for i, f := range features.Features {
-		if id := getIDString(f.ID); len(id) > 0 {
-			coll.id[i] = id
+		coll.id = append(coll.id, id)
+		if len(id) > 0 {
 			coll.byID[id] = i
+			coll.byShortToken[ShortToken(name, id)] = i
 		}
 
-		coll.bbox[i] = computeBounds(f.Geometry)
-		center := coll.bbox[i].Center()
-		coll.webMercator[i] = projectWebMercator(center)
+		coll.properties.add(i, f.Properties)
+		coll.propertyTypes.add(f.Properties)
+		coll.text.add(i, f.Properties)
+		coll.bbox = append(coll.bbox, bounds)
+		if !bounds.IsEmpty() {
+			coll.numLocated++
+		}
+		coll.metadata.Bbox = coll.metadata.Bbox.Union(bounds)
+		coll.webMercator = append(coll.webMercator, point)
+		coll.hash = append(coll.hash, hash)
 
 		if i > 0 {
-			if _, err := dataFile.Write([]byte(",\n")); err == nil {
-				pos += 2
-			} else {
-				coll.Close()
-				return nil, err
+			if _, err := dataFile.Write([]byte(",\n")); err != nil {
+				return err
 			}
+			pos += 2
 		}
-		coll.offset[i] = pos
+		coll.offset = append(coll.offset, pos)
 
-		encoded, err := json.Marshal(f)
-		if err != nil {
-			coll.Close()
-			return nil, err
+		numBytes, err := dataFile.Write(encoded)
+		pos = pos + int64(numBytes)
+		return err
+	}
+
+	if streaming {
+		var migrator *featureMigrator
+		if len(opts.migrations) > 0 {
+			migrator = makeFeatureMigrator(name, opts.migrations)
 		}
 
-		if numBytes, err := dataFile.Write(encoded); err == nil {
-			pos = pos + int64(numBytes)
-		} else {
+		// Enrichers look up features in batches, which is much faster
+		// than one by one for remote elevation sources.
+		var batch []*geojson.Feature
+		flush := func() error {
+			if len(batch) == 0 {
+				return nil
+			}
+			for _, e := range opts.enrichers {
+				e.Enrich(name, batch)
+			}
+			for k, f := range batch {
+				if err := add(f); err != nil {
+					return err
+				}
+				batch[k] = nil
+			}
+			batch = batch[:0]
+			return nil
+		}
+		prepare := func(f *geojson.Feature) error {
+			if migrator != nil {
+				migrator.migrate(f)
+			}
+			if len(opts.enrichers) == 0 {
+				return add(f)
+			}
+			batch = append(batch, f)
+			if len(batch) >= enrichBatchSize {
+				return flush()
+			}
+			return nil
+		}
+		emit := prepare
+		if opts.splitMulti {
+			emit = func(f *geojson.Feature) error {
+				for _, part := range splitMultiGeometry(f) {
+					if err := prepare(part); err != nil {
+						return err
+					}
+				}
+				return nil
+			}
+		}
+		data = &SourceData{}
+		if data.Properties, err = streamer.Stream(source, emit); err == nil {
+			err = flush()
+		}
+		if err != nil {
+			numDataLoadErrors.Inc()
 			coll.Close()
 			return nil, err
 		}
+		if migrator != nil {
+			migrator.logStats()
+		}
+	} else {
+		// Decoded features take much more memory than their index
+		// entries, so we release each one once it has been added.
+		for k, f := range data.Features {
+			if err := add(f); err != nil {
+				coll.Close()
+				return nil, err
+			}
+			data.Features[k] = nil
+		}
 	}
+	numFeatures := len(coll.id)
+	coll.offset = append(coll.offset, 0)
 	coll.offset[len(coll.offset)-1] = pos + 2 // 2 = len(",\n")
+	coll.text.finish()
+	coll.spatial = makeSpatialIndex(coll.bbox, coll.metadata.Bbox)
+	coll.metadata.Version = computeVersion(coll.id, coll.hash)
 	if _, err := dataFile.Write([]byte("\n]}\n")); err != nil {
 		coll.Close()
 		return nil, err
 	}
-
-	// RFC 7946 does not define a "properties" member on FeatureCollection,
-	// only on Feature. We still recognize certain collection properties,
-	// which is is allowed as per RFC 7946 section 6.1 (Foreign Members).
-	type collectionProperties struct {
-		Properties map[string]interface{} `json:"properties"`
+	if opts.spillThreshold > 0 && numFeatures >= opts.spillThreshold {
+		if err := coll.spill(opts.spillCache); err != nil {
+			coll.Close()
+			return nil, err
+		}
 	}
-	var props collectionProperties
-	if err := json.Unmarshal(data, &props); err == nil {
-		for prop, val := range props.Properties {
-			if strings.HasSuffix(prop, "_timestamp") {
-				if s, ok := val.(string); ok {
-					if t, err := time.Parse(time.RFC3339, s); err == nil {
-						propName := strings.TrimSuffix(prop, "_timestamp")
-						if len(propName) > 0 {
-							collectionTimestamp.WithLabelValues(name, propName).Set(float64(t.UTC().Unix()))
 
-						}
+	for prop, val := range data.Properties {
+		if strings.HasSuffix(prop, "_timestamp") {
+			if s, ok := val.(string); ok {
+				if t, err := time.Parse(time.RFC3339, s); err == nil {
+					propName := strings.TrimSuffix(prop, "_timestamp")
+					if len(propName) > 0 {
+						collectionTimestamp.WithLabelValues(name, propName).Set(float64(t.UTC().Unix()))
 					}
 				}
 			}
 		}
 	}
 
+	coll.dataMemory = coll.estimateDataMemory()
+	collectionMemory.WithLabelValues(name).Set(float64(coll.dataMemory))
 	lastDataLoad.SetToCurrentTime()
 	numDataLoads.Inc()
 	collectionTimestamp.WithLabelValues(name, "last_modified").Set(float64(coll.metadata.LastModified.UTC().Unix()))
 	collectionTimestamp.WithLabelValues(name, "loaded").Set(float64(time.Now().UTC().Unix()))
 	collectionFeaturesCount.WithLabelValues(name).Set(float64(numFeatures))
+	collectionReusedFeatures.WithLabelValues(name).Set(float64(numReused))
+	if numReused > 0 {
+		loaderLog.Debug("reused unchanged features", "collection", name, "reused", numReused, "features", numFeatures)
+	}
 
 	return coll, nil
 }
 
+// reusableEntry returns the position of a feature in a previously
+// loaded collection, if it has the same ID and hash. Must only be
+// called for collections whose index has not been spilled. Returns
+// false if c is nil.
+func (c *Collection) reusableEntry(id string, hash uint64) (int, bool) {
+	if c == nil || len(id) == 0 {
+		return 0, false
+	}
+	if j, ok := c.byID[id]; ok && c.hash[j] == hash {
+		return j, true
+	}
+	return 0, false
+}
+
 func getIDString(s interface{}) string {
 	if str, ok := s.(string); ok {
 		return str
 	} else if i, ok := s.(int64); ok {
 		return strconv.FormatInt(i, 10)
+	} else if f, ok := s.(float64); ok {
+		// encoding/json decodes all numbers to float64.
+		return strconv.FormatFloat(f, 'f', -1, 64)
 	} else {
 		return ""
 	}
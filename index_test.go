@@ -3,11 +3,16 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
+	"math"
 	"net/url"
 	"os"
 	"path/filepath"
 	"reflect"
+	"runtime"
+	"runtime/metrics"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
@@ -23,7 +28,7 @@ func loadTestIndex(t *testing.T) *Index {
 
 	publicPath, _ := url.Parse("https://test.example.org/wfs/")
 	index, err := MakeIndex(map[string]string{"castles": p1, "lakes": p2},
-		publicPath)
+		publicPath, IndexOptions{})
 	if index == nil || err != nil {
 		t.Fatalf("failed making index: %s", err)
 	}
@@ -46,6 +51,35 @@ func TestGetCollections(t *testing.T) {
 	}
 }
 
+func TestMakeIndex_Options(t *testing.T) {
+	publicPath, _ := url.Parse("https://test.example.org/wfs/")
+	index, err := MakeIndex(map[string]string{
+		"castles": filepath.Join("testdata", "castles.geojson"),
+		"lakes":   filepath.Join("testdata", "lakes.geojson"),
+	}, publicPath, IndexOptions{
+		Migrations: map[string][]PropertyMigration{
+			"castles": {{Op: "rename", Property: "name", To: "title"}},
+		},
+		ItemOrder: ItemOrderID,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer index.Close()
+
+	// The options must apply to the first load, without any reload.
+	if got := index.GetItemOrder(); got != ItemOrderID {
+		t.Errorf("expected item order %q, got %q", ItemOrderID, got)
+	}
+	f, _ := index.GetItem("castles", "W418392510")
+	if f == nil || f.Properties["title"] != "Castello Scaligero" || f.Properties["name"] != nil {
+		t.Errorf("expected migrated W418392510, got %v", f)
+	}
+	if f, _ := index.GetItem("lakes", "N123"); f == nil || f.Properties["name"] != "Katzensee" {
+		t.Errorf("expected N123 of lakes to be unmigrated, got %v", f)
+	}
+}
+
 func TestGetItem_ExistingItem(t *testing.T) {
 	index := loadTestIndex(t)
 	defer index.Close()
@@ -74,10 +108,11 @@ func TestGetItem_NoSuchItem(t *testing.T) {
 }
 
 func getItems(index *Index, collection string, startID string, startIndex int, limit int, bbox s2.Rect) (*WFSFeatureCollection, *CollectionMetadata, error) {
-	includeLinks := true
+	includeDeleted := false
 	var buf bytes.Buffer
-	md, err := index.GetItems(collection, startID, startIndex, limit, bbox,
-		noTime, noTime, includeLinks, &buf)
+	md, _, err := index.GetItems(collection,
+		itemsQuery{itemsSelection: itemsSelection{bbox: bbox}, startID: startID, start: startIndex, limit: limit},
+		noTime, noTime, index.PublicPath.String(), includeDeleted, &buf)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -102,6 +137,16 @@ func TestGetItems_EmptyBbox(t *testing.T) {
 			"rel": "self",
 			"type": "application/geo+json",
 			"title": "self"
+		}, {
+			"href": "https://test.example.org/wfs/collections/castles/items",
+			"rel": "first",
+			"type": "application/geo+json",
+			"title": "first"
+		}, {
+			"href": "https://test.example.org/wfs/collections/castles/items",
+			"rel": "last",
+			"type": "application/geo+json",
+			"title": "last"
 		}],
 		"features": []
 	}`)
@@ -126,6 +171,24 @@ func TestGetItems_KnownStartID(t *testing.T) {
             "rel": "self",
             "type": "application/geo+json",
             "title": "self"
+          },
+          {
+            "href": "https://test.example.org/wfs/collections/castles/items?limit=2",
+            "rel": "prev",
+            "type": "application/geo+json",
+            "title": "previous"
+          },
+          {
+            "href": "https://test.example.org/wfs/collections/castles/items?limit=2",
+            "rel": "first",
+            "type": "application/geo+json",
+            "title": "first"
+          },
+          {
+            "href": "https://test.example.org/wfs/collections/castles/items?start=2\u0026limit=2",
+            "rel": "last",
+            "type": "application/geo+json",
+            "title": "last"
           }
         ]`)
 }
@@ -147,6 +210,24 @@ func TestGetItems_UnknownStartID(t *testing.T) {
             "rel": "self",
             "type": "application/geo+json",
             "title": "self"
+          },
+          {
+            "href": "https://test.example.org/wfs/collections/castles/items?limit=2",
+            "rel": "prev",
+            "type": "application/geo+json",
+            "title": "previous"
+          },
+          {
+            "href": "https://test.example.org/wfs/collections/castles/items?limit=2",
+            "rel": "first",
+            "type": "application/geo+json",
+            "title": "first"
+          },
+          {
+            "href": "https://test.example.org/wfs/collections/castles/items?start=2\u0026limit=2",
+            "rel": "last",
+            "type": "application/geo+json",
+            "title": "last"
           }
         ]`)
 }
@@ -168,6 +249,24 @@ func TestGetItems_NoStartID(t *testing.T) {
             "rel": "self",
             "type": "application/geo+json",
             "title": "self"
+          },
+          {
+            "href": "https://test.example.org/wfs/collections/castles/items?limit=2",
+            "rel": "prev",
+            "type": "application/geo+json",
+            "title": "previous"
+          },
+          {
+            "href": "https://test.example.org/wfs/collections/castles/items?limit=2",
+            "rel": "first",
+            "type": "application/geo+json",
+            "title": "first"
+          },
+          {
+            "href": "https://test.example.org/wfs/collections/castles/items?start=2\u0026limit=2",
+            "rel": "last",
+            "type": "application/geo+json",
+            "title": "last"
           }
         ]`)
 }
@@ -195,6 +294,18 @@ func TestGetItems_LimitExceeded(t *testing.T) {
             "rel": "next",
             "type": "application/geo+json",
             "title": "next"
+          },
+          {
+            "href": "https://test.example.org/wfs/collections/castles/items?limit=2",
+            "rel": "first",
+            "type": "application/geo+json",
+            "title": "first"
+          },
+          {
+            "href": "https://test.example.org/wfs/collections/castles/items?start=2\u0026limit=2",
+            "rel": "last",
+            "type": "application/geo+json",
+            "title": "last"
           }
         ]`)
 }
@@ -208,6 +319,91 @@ func TestGetItems_NoSuchCollection(t *testing.T) {
 	}
 }
 
+func TestGetItems_FilteredPaging(t *testing.T) {
+	dir, err := ioutil.TempDir("", "miniwfs-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var features []string
+	for i := 0; i < 12; i++ {
+		kind := "b"
+		if i%3 == 0 {
+			kind = "a"
+		}
+		features = append(features, fmt.Sprintf(
+			`{"type":"Feature","id":"F%d","geometry":{"type":"Point","coordinates":[8.%d,47.%d]},"properties":{"kind":%q}}`,
+			i, i, i, kind))
+	}
+	path := filepath.Join(dir, "things.geojson")
+	data := `{"type":"FeatureCollection","features":[` + strings.Join(features, ",") + `]}`
+	if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	publicPath, _ := url.Parse("https://test.example.org/wfs/")
+	index, err := MakeIndex(map[string]string{"things": path}, publicPath, IndexOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer index.Close()
+
+	getPage := func(q itemsQuery) (string, map[string]itemsQuery) {
+		var buf bytes.Buffer
+		if _, _, err := index.GetItems("things", q, noTime, noTime, publicPath.String(), false, &buf); err != nil {
+			t.Fatal(err)
+		}
+		var page WFSFeatureCollection
+		if err := json.Unmarshal(buf.Bytes(), &page); err != nil {
+			t.Fatal(err)
+		}
+		links := make(map[string]itemsQuery)
+		for _, link := range page.Links {
+			u, err := url.Parse(link.Href)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if u.Query().Get("kind") != "a" {
+				t.Errorf("%s link %s has lost the filter", link.Rel, link.Href)
+			}
+			start, _ := strconv.Atoi(u.Query().Get("start"))
+			links[link.Rel] = itemsQuery{itemsSelection: q.itemsSelection,
+				startID: u.Query().Get("startID"), start: start, limit: q.limit}
+		}
+		return getFeatureIDs(page.Features), links
+	}
+
+	selection := itemsSelection{bbox: s2.FullRect(), filter: PropertyFilter{"kind": "a"}}
+	ids, links := getPage(itemsQuery{itemsSelection: selection, limit: 2})
+	if ids != "F0,F3" {
+		t.Fatalf("first page: expected F0,F3, got %s", ids)
+	}
+	if _, ok := links["last"]; ok {
+		t.Error("first page: expected no last link, since counting matches needs a scan")
+	}
+	ids, links = getPage(links["next"])
+	if ids != "F6,F9" {
+		t.Fatalf("second page: expected F6,F9, got %s", ids)
+	}
+	if _, ok := links["next"]; ok {
+		t.Error("second page: expected no next link")
+	}
+	if last := links["last"]; last.start != 2 {
+		t.Errorf("second page: expected last link to start=2, got start=%d", last.start)
+	}
+
+	// Without startID, start counts the matching features to skip.
+	prev := links["prev"]
+	if prev.start != 0 || len(prev.startID) != 0 {
+		t.Errorf("second page: expected prev link to start=0, got startID=%q start=%d", prev.startID, prev.start)
+	}
+	if ids, _ := getPage(itemsQuery{itemsSelection: selection, start: 1, limit: 2}); ids != "F3,F6" {
+		t.Errorf("start=1: expected F3,F6, got %s", ids)
+	}
+	if ids, _ := getPage(links["last"]); ids != "F6,F9" {
+		t.Errorf("last page: expected F6,F9, got %s", ids)
+	}
+}
+
 func TestGetItems_Metadata(t *testing.T) {
 	index := loadTestIndex(t)
 	defer index.Close()
@@ -252,6 +448,187 @@ func TestReadCollection_CollectionMetrics(t *testing.T) {
 	}
 }
 
+func TestReadCollection_ReusesUnchangedFeatures(t *testing.T) {
+	dir, err := ioutil.TempDir("", "miniwfs-reuse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "points.geojson")
+	point := func(id string, lng float64) string {
+		return `{"type":"Feature","id":"` + id + `","geometry":{"type":"Point","coordinates":[` +
+			strconv.FormatFloat(lng, 'f', -1, 64) + `,47]},"properties":{}}`
+	}
+
+	var t0 time.Time
+	ioutil.WriteFile(path, []byte(`{"type":"FeatureCollection","features":[`+
+		point("A", 8)+","+point("B", 9)+","+point("C", 10)+"]}"), 0644)
+	old, err := readCollection("points", path, t0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer old.Close()
+
+	ioutil.WriteFile(path, []byte(`{"type":"FeatureCollection","features":[`+
+		point("A", 8)+","+point("B", 11)+","+point("D", 12)+"]}"), 0644)
+	coll, err := readMigratedCollection("points", path, t0, loadOptions{previous: old})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer coll.Close()
+
+	m, _ := collectionReusedFeatures.GetMetricWithLabelValues("points")
+	if got := promtest.ToFloat64(m); got != 1 {
+		t.Errorf("expected 1 reused feature, got %v", got)
+	}
+	if got := strings.Join(coll.id, ","); got != "A,B,D" {
+		t.Errorf("expected features A,B,D, got %s", got)
+	}
+	for i, lng := range []float64{8, 11, 12} {
+		if got := coll.featureBounds(i).Center().Lng.Degrees(); math.Abs(got-lng) > 1e-9 {
+			t.Errorf("feature %d: expected longitude %v, got %v", i, lng, got)
+		}
+	}
+	if coll.metadata.Version == old.metadata.Version {
+		t.Error("expected version to change")
+	}
+}
+
+// batchRecorder is a FeatureEnricher that marks features, and records
+// how many it got at once.
+type batchRecorder struct {
+	batches []int
+}
+
+func (r *batchRecorder) Enrich(collection string, features []*geojson.Feature) {
+	r.batches = append(r.batches, len(features))
+	for _, f := range features {
+		f.Properties["enriched"] = true
+	}
+}
+
+func TestReadMigratedCollection_Streaming(t *testing.T) {
+	dir, err := ioutil.TempDir("", "miniwfs-stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "points.geojsonl")
+	ioutil.WriteFile(path, []byte(
+		`{"type":"Feature","id":"A","geometry":{"type":"Point","coordinates":[8,47]},"properties":{"nom":"a"}}`+"\n"+
+			`{"type":"Feature","id":"B","geometry":{"type":"MultiPoint","coordinates":[[9,47],[10,47]]},"properties":{"nom":"b"}}`+"\n"+
+			`{"type":"Feature","id":"C","geometry":{"type":"Point","coordinates":[11,47]},"properties":{"nom":"c"}}`+"\n"), 0644)
+
+	defer func(n int) { enrichBatchSize = n }(enrichBatchSize)
+	enrichBatchSize = 3
+	enricher := &batchRecorder{}
+	var t0 time.Time
+	coll, err := readMigratedCollection("points", path, t0, loadOptions{
+		migrations: []PropertyMigration{{Op: "rename", Property: "nom", To: "name"}},
+		enrichers:  []FeatureEnricher{enricher},
+		splitMulti: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer coll.Close()
+
+	// Streamed features get enriched in batches, not all at once.
+	if got := fmt.Sprint(enricher.batches); got != "[3 1]" {
+		t.Errorf("expected batches [3 1], got %s", got)
+	}
+	if got := strings.Join(coll.id, ","); got != "A,B#1,B#2,C" {
+		t.Errorf("expected features A,B#1,B#2,C, got %s", got)
+	}
+	for i := range coll.id {
+		f, _ := coll.readFeature(i)
+		if f == nil || f.Properties["name"] == nil || f.Properties["nom"] != nil || f.Properties["enriched"] != true {
+			t.Errorf("feature %d: expected migrated and enriched properties, got %v", i, f)
+		}
+	}
+}
+
+// BenchmarkReload reports the peak heap while reloading a collection
+// whose previous version is still in memory, for streamed sources and
+// for an item order that needs all features at once.
+func BenchmarkReload(b *testing.B) {
+	dir, err := ioutil.TempDir("", "miniwfs-bench")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "points.geojsonl")
+	var buf bytes.Buffer
+	for i := 0; i < 50000; i++ {
+		fmt.Fprintf(&buf, `{"type":"Feature","id":"N%d","geometry":{"type":"Point","coordinates":[%f,47]},"properties":{"nom":"Point %d"}}`+"\n",
+			i, float64(i%3600)/10, i)
+	}
+	ioutil.WriteFile(path, buf.Bytes(), 0644)
+
+	// Reloads with a previous version may take over its index entries
+	// for unchanged features; we compare them to loading from scratch.
+	for _, order := range []string{ItemOrderSource, ItemOrderID} {
+		for _, reuse := range []bool{false, true} {
+			name := order + "/fresh"
+			if reuse {
+				name = order + "/reuse"
+			}
+			b.Run(name, func(b *testing.B) {
+				var t0 time.Time
+				opts := loadOptions{
+					migrations: []PropertyMigration{{Op: "rename", Property: "nom", To: "name"}},
+					order:      order,
+				}
+				previous, err := readMigratedCollection("points", path, t0, opts)
+				if err != nil {
+					b.Fatal(err)
+				}
+				defer previous.Close()
+				if reuse {
+					opts.previous = previous
+				}
+
+				var peak uint64
+				for i := 0; i < b.N; i++ {
+					runtime.GC()
+					stop := make(chan struct{})
+					sampled := make(chan uint64)
+					go sampleHeap(stop, sampled)
+					coll, err := readMigratedCollection("points", path, t0, opts)
+					close(stop)
+					if p := <-sampled; p > peak {
+						peak = p
+					}
+					if err != nil {
+						b.Fatal(err)
+					}
+					coll.Close()
+				}
+				b.ReportMetric(float64(peak), "peak-heap-bytes")
+			})
+		}
+	}
+}
+
+// sampleHeap sends the largest size of live heap objects that it has
+// seen until stop gets closed.
+func sampleHeap(stop <-chan struct{}, peak chan<- uint64) {
+	sample := []metrics.Sample{{Name: "/memory/classes/heap/objects:bytes"}}
+	var max uint64
+	for {
+		metrics.Read(sample)
+		if v := sample[0].Value.Uint64(); v > max {
+			max = v
+		}
+		select {
+		case <-stop:
+			peak <- max
+			return
+		case <-time.After(100 * time.Microsecond):
+		}
+	}
+}
+
 func TestReadCollection_IfModifiedSince(t *testing.T) {
 	tmpfile, _ := ioutil.TempFile("", "test.*.geojson")
 	defer os.Remove(tmpfile.Name())
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	numIPRejectedRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "miniwfs_ip_rejected_requests_total",
+		Help: "Total number of requests rejected by IP filtering, by collection; empty for the global filter.",
+	},
+		[]string{"collection"})
+)
+
+// IPFilter decides by client IP address whether to serve a request.
+// Denied networks take precedence over allowed ones. If any networks
+// are allowed, clients from other networks are rejected.
+type IPFilter struct {
+	allow []*net.IPNet
+	deny  []*net.IPNet
+}
+
+// MakeIPFilter parses allowed and denied networks in CIDR notation,
+// such as "10.0.0.0/8". Single addresses are accepted as well.
+func MakeIPFilter(allow []string, deny []string) (*IPFilter, error) {
+	f := &IPFilter{}
+	var err error
+	if f.allow, err = parseNetworks(allow); err != nil {
+		return nil, err
+	}
+	if f.deny, err = parseNetworks(deny); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func parseNetworks(networks []string) ([]*net.IPNet, error) {
+	result := make([]*net.IPNet, 0, len(networks))
+	for _, s := range networks {
+		if !strings.Contains(s, "/") {
+			if ip := net.ParseIP(s); ip != nil && ip.To4() != nil {
+				s = s + "/32"
+			} else {
+				s = s + "/128"
+			}
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("malformed network %q: %v", s, err)
+		}
+		result = append(result, n)
+	}
+	return result, nil
+}
+
+// Allows returns true if the filter lets a client IP address pass.
+func (f *IPFilter) Allows(ip net.IP) bool {
+	if f == nil {
+		return true
+	}
+	if ip == nil {
+		return len(f.allow) == 0 && len(f.deny) == 0
+	}
+	for _, n := range f.deny {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	if len(f.allow) == 0 {
+		return true
+	}
+	for _, n := range f.allow {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// getClientIP returns the IP address of the peer that sent a request.
+// We do not look at X-Forwarded-For, which clients can forge.
+func getClientIP(req *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+	return net.ParseIP(host)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIPFilter(t *testing.T) {
+	f, err := MakeIPFilter([]string{"10.0.0.0/8", "2001:db8::/32"}, []string{"10.6.6.6", "10.7.0.0/16"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for ip, expected := range map[string]bool{
+		"10.1.2.3":      true,
+		"10.6.6.6":      false,
+		"10.6.6.7":      true,
+		"10.7.1.1":      false,
+		"192.168.1.1":   false,
+		"2001:db8::1":   true,
+		"2001:db9::1":   false,
+		"::ffff:10.1.2": false,
+	} {
+		if got := f.Allows(net.ParseIP(ip)); got != expected {
+			t.Errorf("expected Allows(%s)=%v, got %v", ip, expected, got)
+		}
+	}
+
+	denyOnly, _ := MakeIPFilter(nil, []string{"192.168.0.0/16"})
+	if !denyOnly.Allows(net.ParseIP("10.1.2.3")) || denyOnly.Allows(net.ParseIP("192.168.1.1")) {
+		t.Error("expected deny-only filter to reject denied networks only")
+	}
+
+	if _, err := MakeIPFilter([]string{"10.0.0.0/99"}, nil); err == nil {
+		t.Error("expected error for malformed network")
+	}
+}
+
+func TestIPFilter_WebServer(t *testing.T) {
+	index, s := makeServer(t)
+	defer s.Shutdown()
+	defer index.Close()
+	s.access = MakeAccessControl(nil, nil, nil)
+	global, _ := MakeIPFilter(nil, []string{"203.0.113.0/24"})
+	partners, _ := MakeIPFilter([]string{"10.0.0.0/8"}, nil)
+	s.access.SetIPFilter("", global)
+	s.access.SetIPFilter("lakes", partners)
+
+	for _, e := range []struct {
+		path       string
+		remoteAddr string
+		expected   int
+	}{
+		{"/collections", "198.51.100.1:1234", 200},
+		{"/collections", "203.0.113.7:1234", 403},
+		{"/collections/castles/items", "198.51.100.1:1234", 200},
+		{"/collections/lakes/items", "198.51.100.1:1234", 403},
+		{"/collections/lakes/items", "10.1.2.3:1234", 200},
+		{"/collections/lakes/items/N123", "198.51.100.1:1234", 403},
+	} {
+		query, _ := http.NewRequest("GET", e.path, nil)
+		query.RemoteAddr = e.remoteAddr
+		resp := httptest.NewRecorder()
+		http.HandlerFunc(s.HandleRequest).ServeHTTP(resp, query)
+		if resp.Code != e.expected {
+			t.Errorf("expected status %d for %s from %s, got %d",
+				e.expected, e.path, e.remoteAddr, resp.Code)
+		}
+	}
+}
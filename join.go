@@ -0,0 +1,182 @@
+package main
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+
+	"github.com/golang/geo/s2"
+	"github.com/paulmach/go.geojson"
+)
+
+var MalformedJoin error = errors.New("malformed within parameter; pass something like ?within=cantons:R1686344")
+var NotAnArea error = errors.New("feature in within parameter is not a polygon or multipolygon")
+
+// SpatialJoin restricts items to the features intersecting an area
+// that is given by a feature of another collection, such as the
+// castles within a canton boundary.
+type SpatialJoin struct {
+	Collection string
+	ID         string
+	region     *s2.Polygon
+}
+
+// encode returns the join as the value of the within query parameter.
+func (j *SpatialJoin) encode() string {
+	return url.QueryEscape(j.Collection) + ":" + url.QueryEscape(j.ID)
+}
+
+// ResolveSpatialJoin looks up the area for a within query parameter,
+// such as "cantons:R1686344". Returns NotFound if there is no such
+// collection or feature.
+func (index *Index) ResolveSpatialJoin(param string) (*SpatialJoin, error) {
+	sep := strings.IndexByte(param, ':')
+	if sep <= 0 || sep == len(param)-1 {
+		return nil, MalformedJoin
+	}
+	join := &SpatialJoin{Collection: param[:sep], ID: param[sep+1:]}
+
+	feature, err := index.GetItem(join.Collection, join.ID)
+	if err != nil {
+		return nil, err
+	}
+	if feature == nil {
+		return nil, NotFound
+	}
+	if join.region = polygonFromGeometry(feature.Geometry); join.region == nil {
+		return nil, NotAnArea
+	}
+	return join, nil
+}
+
+// intersects returns true if a geometry has a point inside the area.
+func (j *SpatialJoin) intersects(g *geojson.Geometry) bool {
+	if g == nil {
+		return false
+	}
+	switch g.Type {
+	case geojson.GeometryPoint:
+		return j.region.ContainsPoint(s2PointFromCoord(g.Point))
+
+	case geojson.GeometryMultiPoint:
+		for _, p := range g.MultiPoint {
+			if j.region.ContainsPoint(s2PointFromCoord(p)) {
+				return true
+			}
+		}
+
+	case geojson.GeometryLineString:
+		return j.intersectsLine(g.LineString)
+
+	case geojson.GeometryMultiLineString:
+		for _, line := range g.MultiLineString {
+			if j.intersectsLine(line) {
+				return true
+			}
+		}
+
+	case geojson.GeometryPolygon:
+		return j.intersectsPolygon(g.Polygon)
+
+	case geojson.GeometryMultiPolygon:
+		for _, poly := range g.MultiPolygon {
+			if j.intersectsPolygon(poly) {
+				return true
+			}
+		}
+
+	case geojson.GeometryCollection:
+		for _, geom := range g.Geometries {
+			if j.intersects(geom) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// intersectsLine returns true if a line has a vertex inside the area,
+// or crosses its boundary.
+func (j *SpatialJoin) intersectsLine(line [][]float64) bool {
+	points := make([]s2.Point, len(line))
+	for i, c := range line {
+		points[i] = s2PointFromCoord(c)
+		if j.region.ContainsPoint(points[i]) {
+			return true
+		}
+	}
+	for i := 1; i < len(points); i++ {
+		for e := 0; e < j.region.NumEdges(); e++ {
+			edge := j.region.Edge(e)
+			if s2.CrossingSign(points[i-1], points[i], edge.V0, edge.V1) == s2.Cross {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (j *SpatialJoin) intersectsPolygon(rings [][][]float64) bool {
+	if poly := polygonFromRings(rings); poly != nil {
+		return j.region.Intersects(poly)
+	}
+	for _, ring := range rings {
+		if j.intersectsLine(ring) {
+			return true
+		}
+	}
+	return false
+}
+
+// polygonFromGeometry converts a GeoJSON Polygon or MultiPolygon into
+// an s2 polygon, or returns nil for other geometries.
+func polygonFromGeometry(g *geojson.Geometry) *s2.Polygon {
+	if g == nil {
+		return nil
+	}
+	switch g.Type {
+	case geojson.GeometryPolygon:
+		return polygonFromRings(g.Polygon)
+	case geojson.GeometryMultiPolygon:
+		var rings [][][]float64
+		for _, poly := range g.MultiPolygon {
+			rings = append(rings, poly...)
+		}
+		return polygonFromRings(rings)
+	}
+	return nil
+}
+
+// polygonFromRings builds an s2 polygon from GeoJSON rings. The nesting
+// of shells and holes is determined by s2, so ring orientation does
+// not matter. Returns nil if there are no rings with an area.
+func polygonFromRings(rings [][][]float64) *s2.Polygon {
+	loops := make([]*s2.Loop, 0, len(rings))
+	for _, ring := range rings {
+		// GeoJSON repeats the first vertex at the end, s2 does not.
+		if n := len(ring); n > 1 && ring[0][0] == ring[n-1][0] && ring[0][1] == ring[n-1][1] {
+			ring = ring[:n-1]
+		}
+		if len(ring) < 3 {
+			continue
+		}
+		points := make([]s2.Point, len(ring))
+		for i, c := range ring {
+			points[i] = s2PointFromCoord(c)
+		}
+		loop := s2.LoopFromPoints(points)
+		loop.Normalize()
+		loops = append(loops, loop)
+	}
+	if len(loops) == 0 {
+		return nil
+	}
+	return s2.PolygonFromLoops(loops)
+}
+
+func s2PointFromCoord(c []float64) s2.Point {
+	if len(c) < 2 {
+		return s2.Point{}
+	}
+	return s2.PointFromLatLng(s2.LatLngFromDegrees(c[1], c[0]))
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSpatialJoin(t *testing.T) {
+	dir, err := ioutil.TempDir("", "miniwfs-join")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	regions := filepath.Join(dir, "regions.geojson")
+	content := `{"type":"FeatureCollection","features":[
+		{"type":"Feature","id":"R1","properties":{},"geometry":{"type":"Polygon",
+		 "coordinates":[[[11,47.5],[11.5,47.5],[11.5,48.2],[11,48.2],[11,47.5]]]}},
+		{"type":"Feature","id":"R2","properties":{},"geometry":{"type":"MultiPolygon",
+		 "coordinates":[[[[10.6849,45.6],[10.685,45.6],[10.685,45.61],[10.6849,45.61],[10.6849,45.6]]],
+		                [[[11.12,46.06],[11.13,46.06],[11.13,46.07],[11.12,46.07],[11.12,46.06]]]]}},
+		{"type":"Feature","id":"R3","properties":{},"geometry":{"type":"Polygon",
+		 "coordinates":[[[11,47.5],[11.5,47.5],[11.5,48.2],[11,48.2],[11,47.5]],
+		                [[11.1,47.8],[11.1,48.0],[11.3,48.0],[11.3,47.8],[11.1,47.8]]]}},
+		{"type":"Feature","id":"N1","properties":{},"geometry":{"type":"Point","coordinates":[11,47]}}]}`
+	if err := ioutil.WriteFile(regions, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	publicPath, _ := url.Parse("https://test.example.org/wfs/")
+	index, err := MakeIndex(map[string]string{
+		"castles": filepath.Join("testdata", "castles.geojson"),
+		"regions": regions,
+	}, publicPath, IndexOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer index.Close()
+	s := MakeWebServer(index)
+	defer s.Shutdown()
+
+	for within, expected := range map[string]string{
+		"regions:R1": "N34729562",
+		"regions:R2": "W418392510 W24785843",
+		"regions:R3": "",
+	} {
+		path := "/collections/castles/items?within=" + within
+		req, _ := http.NewRequest("GET", path, nil)
+		resp := httptest.NewRecorder()
+		http.HandlerFunc(s.HandleRequest).ServeHTTP(resp, req)
+		if resp.Code != http.StatusOK {
+			t.Errorf("GET %s: expected status 200, got %d", path, resp.Code)
+			continue
+		}
+		var got WFSFeatureCollection
+		if err := json.Unmarshal(resp.Body.Bytes(), &got); err != nil {
+			t.Fatal(err)
+		}
+		ids := make([]string, len(got.Features))
+		for i, f := range got.Features {
+			ids[i] = f.ID.(string)
+		}
+		if strings.Join(ids, " ") != expected {
+			t.Errorf("GET %s: expected %q, got %q", path, expected, strings.Join(ids, " "))
+		}
+		if body := getBody(resp); !strings.Contains(body, "within="+within) {
+			t.Errorf("GET %s: expected links to keep the within parameter, got %s", path, body)
+		}
+	}
+
+	for within, expected := range map[string]int{
+		"regions:N1":         http.StatusBadRequest,
+		"regions":            http.StatusBadRequest,
+		"regions:":           http.StatusBadRequest,
+		"regions:R9":         http.StatusNotFound,
+		"nosuchcollection:1": http.StatusNotFound,
+	} {
+		path := "/collections/castles/items?within=" + within
+		req, _ := http.NewRequest("GET", path, nil)
+		resp := httptest.NewRecorder()
+		http.HandlerFunc(s.HandleRequest).ServeHTTP(resp, req)
+		if resp.Code != expected {
+			t.Errorf("GET %s: expected status %d, got %d", path, expected, resp.Code)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bufio"
+	"encoding/xml"
+	"io"
+	"sort"
+	"strconv"
+
+	"github.com/paulmach/go.geojson"
+)
+
+// KML encoding of features, so collections can be opened in Google
+// Earth. Each feature becomes a Placemark named after its "name"
+// property, or its ID if it has none; all properties are attached as
+// ExtendedData. Placemarks share one style, which draws lines and area
+// outlines like our map previews.
+
+const kmlNamespace = "http://www.opengis.net/kml/2.2"
+
+// kmlStyle colors are in KML's aabbggrr notation.
+const kmlStyle = `<Style id="feature">` +
+	`<LineStyle><color>ffff8833</color><width>3</width></LineStyle>` +
+	`<PolyStyle><color>66ff8833</color></PolyStyle>` +
+	`</Style>`
+
+func init() {
+	RegisterOutputEncoder(kmlEncoder{}, false)
+}
+
+type kmlEncoder struct{}
+
+func (kmlEncoder) Name() string {
+	return "kml"
+}
+
+func (kmlEncoder) MediaType() string {
+	return "application/vnd.google-earth.kml+xml"
+}
+
+func (kmlEncoder) EncodeFeatureCollection(w io.Writer, collection string, features []RawFeature) error {
+	out := bufio.NewWriter(w)
+	writeXMLHeader(out)
+	out.WriteString(`<kml xmlns="` + kmlNamespace + `"><Document><name>`)
+	xml.EscapeText(out, []byte(collection))
+	out.WriteString("</name>" + kmlStyle)
+	for _, f := range features {
+		writeKMLPlacemark(out, f.Feature)
+	}
+	out.WriteString("</Document></kml>")
+	return out.Flush()
+}
+
+func (e kmlEncoder) EncodeFeature(w io.Writer, collection string, feature RawFeature) error {
+	return e.EncodeFeatureCollection(w, collection, []RawFeature{feature})
+}
+
+func writeKMLPlacemark(w *bufio.Writer, f *geojson.Feature) {
+	w.WriteString("<Placemark")
+	id := getIDString(f.ID)
+	if len(id) > 0 {
+		w.WriteString(` id="`)
+		xml.EscapeText(w, []byte(xmlName(id)))
+		w.WriteString(`"`)
+	}
+	w.WriteString(">")
+
+	name, ok := f.Properties["name"].(string)
+	if !ok {
+		name = id
+	}
+	if len(name) > 0 {
+		w.WriteString("<name>")
+		xml.EscapeText(w, []byte(name))
+		w.WriteString("</name>")
+	}
+	w.WriteString("<styleUrl>#feature</styleUrl>")
+
+	if len(f.Properties) > 0 {
+		keys := make([]string, 0, len(f.Properties))
+		for key := range f.Properties {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		w.WriteString("<ExtendedData>")
+		for _, key := range keys {
+			w.WriteString(`<Data name="`)
+			xml.EscapeText(w, []byte(key))
+			w.WriteString(`"><value>`)
+			xml.EscapeText(w, []byte(formatGMLValue(f.Properties[key])))
+			w.WriteString("</value></Data>")
+		}
+		w.WriteString("</ExtendedData>")
+	}
+
+	if f.Geometry != nil {
+		writeKMLGeometry(w, f.Geometry)
+	}
+	w.WriteString("</Placemark>")
+}
+
+func writeKMLGeometry(w *bufio.Writer, g *geojson.Geometry) {
+	switch g.Type {
+	case geojson.GeometryPoint:
+		w.WriteString("<Point>")
+		writeKMLCoordinates(w, [][]float64{g.Point})
+		w.WriteString("</Point>")
+
+	case geojson.GeometryMultiPoint:
+		w.WriteString("<MultiGeometry>")
+		for _, p := range g.MultiPoint {
+			w.WriteString("<Point>")
+			writeKMLCoordinates(w, [][]float64{p})
+			w.WriteString("</Point>")
+		}
+		w.WriteString("</MultiGeometry>")
+
+	case geojson.GeometryLineString:
+		w.WriteString("<LineString>")
+		writeKMLCoordinates(w, g.LineString)
+		w.WriteString("</LineString>")
+
+	case geojson.GeometryMultiLineString:
+		w.WriteString("<MultiGeometry>")
+		for _, line := range g.MultiLineString {
+			w.WriteString("<LineString>")
+			writeKMLCoordinates(w, line)
+			w.WriteString("</LineString>")
+		}
+		w.WriteString("</MultiGeometry>")
+
+	case geojson.GeometryPolygon:
+		writeKMLPolygon(w, g.Polygon)
+
+	case geojson.GeometryMultiPolygon:
+		w.WriteString("<MultiGeometry>")
+		for _, poly := range g.MultiPolygon {
+			writeKMLPolygon(w, poly)
+		}
+		w.WriteString("</MultiGeometry>")
+
+	case geojson.GeometryCollection:
+		w.WriteString("<MultiGeometry>")
+		for _, member := range g.Geometries {
+			if member != nil {
+				writeKMLGeometry(w, member)
+			}
+		}
+		w.WriteString("</MultiGeometry>")
+	}
+}
+
+func writeKMLPolygon(w *bufio.Writer, rings [][][]float64) {
+	w.WriteString("<Polygon>")
+	for i, ring := range rings {
+		tag := "innerBoundaryIs"
+		if i == 0 {
+			tag = "outerBoundaryIs"
+		}
+		w.WriteString("<" + tag + "><LinearRing>")
+		writeKMLCoordinates(w, ring)
+		w.WriteString("</LinearRing></" + tag + ">")
+	}
+	w.WriteString("</Polygon>")
+}
+
+// writeKMLCoordinates writes points as "lon,lat" or "lon,lat,height"
+// tuples, which is the axis order of KML.
+func writeKMLCoordinates(w *bufio.Writer, points [][]float64) {
+	w.WriteString("<coordinates>")
+	first := true
+	for _, p := range points {
+		if len(p) < 2 {
+			continue
+		}
+		if !first {
+			w.WriteByte(' ')
+		}
+		first = false
+		for i, v := range p {
+			if i > 2 {
+				break
+			}
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			w.WriteString(strconv.FormatFloat(v, 'f', -1, 64))
+		}
+	}
+	w.WriteString("</coordinates>")
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestKMLEncoder(t *testing.T) {
+	features, err := decodeRawFeatures([]byte(`{"features":[
+		{"type":"Feature","id":"a","properties":{"name":"Lake <1>","depth":12.5},
+		 "geometry":{"type":"Polygon","coordinates":[[[0,0],[4,0],[4,4],[0,0]],[[1,1],[2,1],[2,2],[1,1]]]}},
+		{"type":"Feature","id":"b","properties":{},"geometry":{"type":"Point","coordinates":[8.5,47.25,410]}}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out strings.Builder
+	if err := (kmlEncoder{}).EncodeFeatureCollection(&out, "lakes", features); err != nil {
+		t.Fatal(err)
+	}
+	got := out.String()
+	for _, expected := range []string{
+		`<kml xmlns="http://www.opengis.net/kml/2.2"><Document><name>lakes</name><Style id="feature">`,
+		`<Placemark id="a"><name>Lake &lt;1&gt;</name><styleUrl>#feature</styleUrl>`,
+		`<ExtendedData><Data name="depth"><value>12.5</value></Data><Data name="name"><value>Lake &lt;1&gt;</value></Data></ExtendedData>`,
+		`<Polygon><outerBoundaryIs><LinearRing><coordinates>0,0 4,0 4,4 0,0</coordinates></LinearRing></outerBoundaryIs>` +
+			`<innerBoundaryIs><LinearRing><coordinates>1,1 2,1 2,2 1,1</coordinates></LinearRing></innerBoundaryIs></Polygon>`,
+		`<Placemark id="b"><name>b</name><styleUrl>#feature</styleUrl><Point><coordinates>8.5,47.25,410</coordinates></Point></Placemark>`,
+	} {
+		if !strings.Contains(got, expected) {
+			t.Errorf("expected %s in:\n%s", expected, got)
+		}
+	}
+}
+
+func TestItem_KML(t *testing.T) {
+	index, s := makeServer(t)
+	defer s.Shutdown()
+	defer index.Close()
+
+	req, _ := http.NewRequest("GET", "/collections/castles/items/N34729562?f=kml", nil)
+	resp := httptest.NewRecorder()
+	http.HandlerFunc(s.HandleRequest).ServeHTTP(resp, req)
+	if ct := resp.Header().Get("Content-Type"); ct != "application/vnd.google-earth.kml+xml" {
+		t.Errorf("expected KML Content-Type, got %q", ct)
+	}
+	if body := getBody(resp); !strings.Contains(body, `<Point><coordinates>11.183468,47.910414</coordinates></Point>`) {
+		t.Errorf("unexpected KML: %s", body)
+	}
+}
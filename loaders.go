@@ -0,0 +1,452 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/paulmach/go.geojson"
+)
+
+// SourceData is what an input loader reads from a collection source.
+// Properties holds collection-level properties, which GeoJSON carries
+// as a foreign member of the FeatureCollection (RFC 7946 section 6.1).
+type SourceData struct {
+	Features   []*geojson.Feature
+	Properties map[string]interface{}
+}
+
+// InputLoader reads collections from a type of source. To support
+// another source type, implement this interface in a new file and
+// register it with RegisterInputLoader from an init function.
+type InputLoader interface {
+	Name() string
+
+	// ModTime returns when the source was last modified. We only
+	// reload sources whose modification time has advanced.
+	ModTime(source string) (time.Time, error)
+
+	Load(source string) (*SourceData, error)
+}
+
+// StreamingInputLoader is implemented by loaders that can pass the
+// features of a source one by one, so that sources larger than memory
+// can be loaded. Stream returns the collection-level properties.
+type StreamingInputLoader interface {
+	InputLoader
+	Stream(source string, emit func(*geojson.Feature) error) (map[string]interface{}, error)
+}
+
+var inputLoaders = struct {
+	sync.RWMutex
+	byExtension map[string]InputLoader
+	byScheme    map[string]InputLoader
+}{
+	byExtension: make(map[string]InputLoader),
+	byScheme:    make(map[string]InputLoader),
+}
+
+// RegisterInputLoader makes a loader responsible for local files with
+// the given extensions, such as ".geojson", and for sources whose URI
+// has one of the given schemes, such as "https".
+func RegisterInputLoader(l InputLoader, extensions []string, schemes []string) {
+	inputLoaders.Lock()
+	defer inputLoaders.Unlock()
+	for _, ext := range extensions {
+		inputLoaders.byExtension[strings.ToLower(ext)] = l
+	}
+	for _, scheme := range schemes {
+		inputLoaders.byScheme[strings.ToLower(scheme)] = l
+	}
+}
+
+// GetInputLoader returns the loader for a collection source. If no
+// loader has been registered for the source type, the error message
+// lists the ones that are available.
+func GetInputLoader(source string) (InputLoader, error) {
+	inputLoaders.RLock()
+	defer inputLoaders.RUnlock()
+	if scheme := sourceScheme(source); len(scheme) > 0 {
+		if l, ok := inputLoaders.byScheme[scheme]; ok {
+			return l, nil
+		}
+	} else if isMultiFileSource(source) {
+		return multiFileLoader{}, nil
+	} else if l, ok := inputLoaders.byExtension[sourceExtension(source)]; ok {
+		return l, nil
+	}
+	return nil, fmt.Errorf("no loader for %s; supported are %s",
+		source, strings.Join(describeInputLoaders(), ", "))
+}
+
+// describeInputLoaders returns a line for each registered loader,
+// such as "geojson (.geojson, .json)". Must be called with the lock held.
+func describeInputLoaders() []string {
+	keys := make(map[string][]string)
+	for ext, l := range inputLoaders.byExtension {
+		keys[l.Name()] = append(keys[l.Name()], ext)
+	}
+	for scheme, l := range inputLoaders.byScheme {
+		keys[l.Name()] = append(keys[l.Name()], scheme+"://")
+	}
+	result := make([]string, 0, len(keys))
+	for name, k := range keys {
+		sort.Strings(k)
+		result = append(result, fmt.Sprintf("%s (%s)", name, strings.Join(k, ", ")))
+	}
+	sort.Strings(result)
+	return result
+}
+
+// sourceScheme returns the lowercased URI scheme of a collection source,
+// or the empty string for local file paths.
+func sourceScheme(source string) string {
+	if i := strings.Index(source, "://"); i > 0 {
+		return strings.ToLower(source[:i])
+	}
+	return ""
+}
+
+// sourceExtension returns the lowercased extension of a local file
+// that tells its format, looking through compression: for both
+// lakes.geojson and lakes.geojson.gz, it returns ".geojson". A suffix
+// that selects part of a file, as in osm.gpkg#buildings, is ignored.
+func sourceExtension(source string) string {
+	if i := strings.LastIndexByte(source, '#'); i >= 0 && !strings.ContainsAny(source[i:], `./\`) {
+		source = source[:i]
+	}
+	ext := strings.ToLower(filepath.Ext(source))
+	if ext == ".gz" {
+		ext = strings.ToLower(filepath.Ext(source[:len(source)-len(ext)]))
+	}
+	return ext
+}
+
+// isLocalSource returns true if a collection source is a local file,
+// which we resolve to an absolute path and watch for changes.
+func isLocalSource(source string) bool {
+	return len(sourceScheme(source)) == 0
+}
+
+func init() {
+	RegisterInputLoader(geoJSONLoader{}, []string{".geojson", ".json"}, nil)
+	RegisterInputLoader(geoJSONLinesLoader{}, []string{".geojsonl", ".geojsons", ".ndjson"}, nil)
+	RegisterInputLoader(&httpLoader{name: "http", client: &http.Client{Timeout: 60 * time.Second},
+		versionHeader: "ETag", ifNoneMatchHeader: "If-None-Match"},
+		nil, []string{"http", "https"})
+}
+
+// openSource opens a local file for reading. Files starting with the
+// gzip magic number get decompressed while reading, whatever their
+// name, so pipelines can hand us compressed data without doubling
+// the storage needed for unpacking it.
+func openSource(path string) (io.ReadCloser, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	buffered := bufio.NewReader(file)
+	if magic, _ := buffered.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(buffered)
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		return gzipFile{gz, file}, nil
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{buffered, file}, nil
+}
+
+// readSource reads a local file like ioutil.ReadFile, decompressing
+// it if it is gzipped.
+func readSource(path string) ([]byte, error) {
+	r, err := openSource(path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return data, nil
+}
+
+// gzipFile decompresses a file, closing both on Close.
+type gzipFile struct {
+	*gzip.Reader
+	file *os.File
+}
+
+func (g gzipFile) Close() error {
+	g.Reader.Close()
+	return g.file.Close()
+}
+
+func fileModTime(path string) (time.Time, error) {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return stat.ModTime(), nil
+}
+
+// parseGeoJSON decodes a GeoJSON FeatureCollection with its foreign
+// "properties" member.
+func parseGeoJSON(data []byte) (*SourceData, error) {
+	var features geojson.FeatureCollection
+	if err := json.Unmarshal(data, &features); err != nil {
+		return nil, err
+	}
+
+	var props struct {
+		Properties map[string]interface{} `json:"properties"`
+	}
+	json.Unmarshal(data, &props)
+	return &SourceData{Features: features.Features, Properties: props.Properties}, nil
+}
+
+type geoJSONLoader struct{}
+
+func (geoJSONLoader) Name() string {
+	return "geojson"
+}
+
+func (geoJSONLoader) ModTime(source string) (time.Time, error) {
+	return fileModTime(source)
+}
+
+func (geoJSONLoader) Load(source string) (*SourceData, error) {
+	data, err := readSource(source)
+	if err != nil {
+		return nil, err
+	}
+	return parseGeoJSON(data)
+}
+
+// geoJSONLinesLoader reads newline-delimited GeoJSON, which has one
+// Feature per line. Such files can be appended to without rewriting.
+// Since we decode one feature at a time, they can be larger than memory.
+type geoJSONLinesLoader struct{}
+
+func (geoJSONLinesLoader) Name() string {
+	return "geojsonl"
+}
+
+func (geoJSONLinesLoader) ModTime(source string) (time.Time, error) {
+	return fileModTime(source)
+}
+
+func (l geoJSONLinesLoader) Load(source string) (*SourceData, error) {
+	result := &SourceData{}
+	_, err := l.Stream(source, func(f *geojson.Feature) error {
+		result.Features = append(result.Features, f)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (geoJSONLinesLoader) Stream(source string, emit func(*geojson.Feature) error) (map[string]interface{}, error) {
+	file, err := openSource(source)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	decoder := json.NewDecoder(recordSeparatorReader{file})
+	for n := 1; ; n++ {
+		var f geojson.Feature
+		if err := decoder.Decode(&f); err == io.EOF {
+			return nil, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("%s: feature %d: %v", source, n, err)
+		}
+		if err := emit(&f); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// recordSeparatorReader turns the record separators that RFC 8142 puts
+// before each feature into spaces, which JSON decoders skip. Inside
+// JSON text, control characters are always escaped, so this is safe.
+type recordSeparatorReader struct {
+	r io.Reader
+}
+
+func (r recordSeparatorReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	for i, c := range p[:n] {
+		if c == 0x1e {
+			p[i] = ' '
+		}
+	}
+	return n, err
+}
+
+// httpLoader fetches GeoJSON from a web server. To tell whether the
+// data has changed, we send a HEAD request with the ETag and
+// Last-Modified of the previously seen version, so servers can answer
+// 304 Not Modified. If the server sends neither header, every check
+// fetches the data again. Data starting with the gzip magic number
+// gets decompressed.
+type httpLoader struct {
+	name   string
+	client *http.Client
+	store  objectStore // nil for fetching sources as plain URLs
+
+	// Response header identifying the version of a source, such as
+	// ETag, and the request header for asking whether it is still
+	// current, such as If-None-Match. Without the latter, we compare
+	// versions ourselves.
+	versionHeader, ifNoneMatchHeader string
+
+	mutex sync.Mutex
+	seen  map[string]httpVersion // keyed by source URL
+}
+
+// objectStore tells httpLoader how to access the sources of an object
+// store, such as s3://bucket/key, over HTTP.
+type objectStore interface {
+	// objectURL returns the HTTP URL of an object.
+	objectURL(source string) (string, error)
+
+	// sign authenticates a request, if the store needs credentials.
+	sign(req *http.Request) error
+}
+
+// httpVersion identifies the version of a remote source that we have
+// seen last, and the modification time we reported for it.
+type httpVersion struct {
+	version      string // such as the ETag
+	lastModified string
+	modTime      time.Time
+}
+
+func (l *httpLoader) Name() string {
+	return l.name
+}
+
+// newRequest makes a request for a source. For object stores, do signs
+// it when sending, after the caller has set its headers.
+func (l *httpLoader) newRequest(method string, source string) (*http.Request, error) {
+	u := source
+	if l.store != nil {
+		var err error
+		if u, err = l.store.objectURL(source); err != nil {
+			return nil, err
+		}
+	}
+	return http.NewRequest(method, u, nil)
+}
+
+// do signs and sends a request.
+func (l *httpLoader) do(req *http.Request) (*http.Response, error) {
+	if l.store != nil {
+		if err := l.store.sign(req); err != nil {
+			return nil, err
+		}
+	}
+	return l.client.Do(req)
+}
+
+func (l *httpLoader) ModTime(source string) (time.Time, error) {
+	req, err := l.newRequest("HEAD", source)
+	if err != nil {
+		return time.Time{}, err
+	}
+	l.mutex.Lock()
+	seen, haveSeen := l.seen[source]
+	l.mutex.Unlock()
+	if haveSeen {
+		if len(seen.version) > 0 && len(l.ifNoneMatchHeader) > 0 {
+			req.Header.Set(l.ifNoneMatchHeader, seen.version)
+		}
+		if len(seen.lastModified) > 0 {
+			req.Header.Set("If-Modified-Since", seen.lastModified)
+		}
+	}
+
+	resp, err := l.do(req)
+	if err != nil {
+		return time.Time{}, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified && haveSeen {
+		return seen.modTime, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return time.Time{}, fmt.Errorf("HEAD %s: %s", source, resp.Status)
+	}
+
+	// Some servers ignore conditional requests, so we compare versions
+	// ourselves. If the version changed within the second resolution
+	// of Last-Modified, we still want to reload.
+	current := httpVersion{version: resp.Header.Get(l.versionHeader), lastModified: resp.Header.Get("Last-Modified")}
+	if haveSeen && len(current.version) > 0 && current.version == seen.version {
+		return seen.modTime, nil
+	}
+	if t, err := http.ParseTime(current.lastModified); err == nil {
+		current.modTime = t
+	} else {
+		current.modTime = time.Now()
+	}
+	if haveSeen && len(current.version) > 0 && !current.modTime.After(seen.modTime) {
+		current.modTime = time.Now()
+	}
+
+	l.mutex.Lock()
+	if l.seen == nil {
+		l.seen = make(map[string]httpVersion)
+	}
+	l.seen[source] = current
+	l.mutex.Unlock()
+	return current.modTime, nil
+}
+
+func (l *httpLoader) Load(source string) (*SourceData, error) {
+	req, err := l.newRequest("GET", source)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := l.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", source, resp.Status)
+	}
+
+	body := bufio.NewReader(resp.Body)
+	var r io.Reader = body
+	if magic, _ := body.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(body)
+		if err != nil {
+			return nil, fmt.Errorf("GET %s: %v", source, err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return parseGeoJSON(data)
+}
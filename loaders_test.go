@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestGetInputLoader(t *testing.T) {
+	for source, expected := range map[string]string{
+		"testdata/castles.geojson":         "geojson",
+		"/data/LAKES.JSON":                 "geojson",
+		"/data/lakes.geojsonl":             "geojsonl",
+		"/data/parks.SHP":                  "shapefile",
+		"/data/trees.csv":                  "csv",
+		"/data/lakes.geojson.gz":           "geojson",
+		"/data/trees.CSV.GZ":               "csv",
+		"https://example.org/lakes":        "http",
+		"HTTP://example.org/lakes.geojson": "http",
+		"s3://data/lakes.geojson":          "s3",
+		"gs://data/lakes.geojson":          "gcs",
+		"postgres://db/gis?table=lakes":    "postgis",
+	} {
+		l, err := GetInputLoader(source)
+		if err != nil {
+			t.Errorf("GetInputLoader(%q) failed: %v", source, err)
+		} else if l.Name() != expected {
+			t.Errorf("GetInputLoader(%q): expected %s, got %s", source, expected, l.Name())
+		}
+	}
+}
+
+func TestGetInputLoader_Unsupported(t *testing.T) {
+	for _, source := range []string{"lakes.kmz", "ftp://example.org/lakes.geojson"} {
+		_, err := GetInputLoader(source)
+		if err == nil {
+			t.Errorf("expected error for %s", source)
+			continue
+		}
+		msg := err.Error()
+		if !strings.Contains(msg, source) || !strings.Contains(msg, "geojson (.geojson, .json)") ||
+			!strings.Contains(msg, "http (http://, https://)") {
+			t.Errorf("expected error message to list supported loaders, got %q", msg)
+		}
+	}
+}
+
+func TestGeoJSONLinesLoader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "miniwfs-loaders")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "lakes.geojsonl")
+	content := `{"type":"Feature","id":"A","geometry":{"type":"Point","coordinates":[8.5,47.4]},"properties":{}}` + "\n\n" +
+		"\x1e" + `{"type":"Feature","id":"B","geometry":{"type":"Point","coordinates":[8.6,47.5]},"properties":{}}` + "\n"
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var t0 time.Time
+	coll, err := readCollection("lakes", path, t0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer coll.Close()
+	if got := strings.Join(coll.id, ","); got != "A,B" {
+		t.Errorf("expected features A,B, got %s", got)
+	}
+}
+
+func TestGeoJSONLinesLoader_Stream(t *testing.T) {
+	dir, err := ioutil.TempDir("", "miniwfs-loaders")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Features may span several lines, as written by pretty-printers.
+	path := filepath.Join(dir, "lakes.geojsonl")
+	content := `{"type":"Feature","id":"A","geometry":null,"properties":{"nom":"Léman"}}` + "\n" +
+		"{\n  \"type\": \"Feature\",\n  \"id\": \"B\",\n  \"geometry\": null,\n  \"properties\": {\"nom\": \"Bodensee\"}\n}\n"
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Migrations get applied while streaming.
+	var t0 time.Time
+	rename := []PropertyMigration{{Property: "nom", Op: "rename", To: "name"}}
+	for _, opts := range []loadOptions{{}, {migrations: rename}} {
+		coll, err := readMigratedCollection("lakes", path, t0, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer coll.Close()
+		if got := strings.Join(coll.id, ","); got != "A,B" {
+			t.Errorf("expected features A,B, got %s", got)
+		}
+		if f, _ := coll.readFeature(1); f == nil || (f.Properties["nom"] == nil) == (opts.migrations == nil) {
+			t.Errorf("unexpected properties %v", f.Properties)
+		}
+	}
+
+	if err := ioutil.WriteFile(path, []byte(content+"{]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readCollection("lakes", path, t0); err == nil || !strings.Contains(err.Error(), "feature 3") {
+		t.Errorf("expected error for feature 3, got %v", err)
+	}
+}
+
+func TestGzippedInput(t *testing.T) {
+	dir, err := ioutil.TempDir("", "miniwfs-loaders")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	original, err := ioutil.ReadFile("testdata/castles.geojson")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	gz.Write(original)
+	gz.Close()
+
+	// Compressed data gets recognized by its magic number, so it does
+	// not matter whether the file name ends in .gz.
+	var t0 time.Time
+	for _, name := range []string{"castles.geojson.gz", "castles.geojson"} {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, compressed.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+		coll, err := readCollection("castles", path, t0)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		defer coll.Close()
+		if n := coll.numFeatures(); n != 3 {
+			t.Errorf("%s: expected 3 features, got %d", name, n)
+		}
+	}
+
+	path := filepath.Join(dir, "broken.geojson.gz")
+	if err := ioutil.WriteFile(path, compressed.Bytes()[:compressed.Len()/2], 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readCollection("broken", path, t0); err == nil {
+		t.Error("expected error for truncated gzip file")
+	}
+}
+
+func TestHTTPLoader(t *testing.T) {
+	data, _ := ioutil.ReadFile(filepath.Join("testdata", "lakes.geojson"))
+	lastModified := time.Date(2019, 3, 1, 12, 0, 0, 0, time.UTC)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Last-Modified", lastModified.Format(http.TimeFormat))
+		w.Write(data)
+	}))
+	defer server.Close()
+
+	source := server.URL + "/lakes"
+	var t0 time.Time
+	coll, err := readCollection("lakes", source, t0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer coll.Close()
+	if coll.metadata.Path != source {
+		t.Errorf("expected path %s, got %s", source, coll.metadata.Path)
+	}
+	if !coll.metadata.LastModified.Equal(lastModified) {
+		t.Errorf("expected LastModified %v, got %v", lastModified, coll.metadata.LastModified)
+	}
+	if _, err := readCollection("lakes", source, lastModified); err != NotModified {
+		t.Errorf("expected NotModified, got %v", err)
+	}
+}
+
+func TestHTTPLoader_ETag(t *testing.T) {
+	data, _ := ioutil.ReadFile(filepath.Join("testdata", "lakes.geojson"))
+	etag, gets, conditional := `"v1"`, 0, 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("ETag", etag)
+		if req.Header.Get("If-None-Match") == etag {
+			conditional++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		if req.Method == "GET" {
+			gets++
+		}
+		w.Write(data)
+	}))
+	defer server.Close()
+
+	source := server.URL + "/lakes-etag"
+	var t0 time.Time
+	coll, err := readCollection("lakes", source, t0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer coll.Close()
+
+	lastModified := coll.metadata.LastModified
+	if _, err := readCollection("lakes", source, lastModified); err != NotModified {
+		t.Errorf("expected NotModified, got %v", err)
+	}
+	if gets != 1 || conditional != 1 {
+		t.Errorf("expected 1 GET and 1 conditional request, got %d and %d", gets, conditional)
+	}
+
+	etag = `"v2"`
+	coll2, err := readCollection("lakes", source, lastModified)
+	if err != nil {
+		t.Fatalf("expected reload after ETag change, got %v", err)
+	}
+	defer coll2.Close()
+	if gets != 2 {
+		t.Errorf("expected 2 GETs, got %d", gets)
+	}
+}
+
+func TestIndex_RefreshRemoteSources(t *testing.T) {
+	data, _ := ioutil.ReadFile(filepath.Join("testdata", "lakes.geojson"))
+	var mutex sync.Mutex
+	etag := `"v1"`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		w.Header().Set("ETag", etag)
+		w.Write(data)
+	}))
+	defer server.Close()
+
+	index, err := MakeIndex(map[string]string{"lakes": server.URL + "/lakes-refresh"}, nil, IndexOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer index.Close()
+	before := index.GetCollections()[0].LastModified
+
+	index.SetRefreshInterval(10 * time.Millisecond)
+	mutex.Lock()
+	etag = `"v2"`
+	mutex.Unlock()
+	for i := 0; i < 200; i++ {
+		if index.GetCollections()[0].LastModified.After(before) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Error("expected collection to be refreshed after ETag change")
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Subsystems whose log level can be changed at runtime, through
+// the /admin/loglevels endpoint.
+const (
+	logSubsystemWatcher = "watcher" // file system events
+	logSubsystemLoader  = "loader"  // reading and replacing collections
+	logSubsystemHTTP    = "http"    // serving requests
+)
+
+var logLevels = map[string]*slog.LevelVar{
+	logSubsystemWatcher: new(slog.LevelVar),
+	logSubsystemLoader:  new(slog.LevelVar),
+	logSubsystemHTTP:    new(slog.LevelVar),
+}
+
+var (
+	watcherLog = makeSubsystemLogger(logSubsystemWatcher)
+	loaderLog  = makeSubsystemLogger(logSubsystemLoader)
+	httpLog    = makeSubsystemLogger(logSubsystemHTTP)
+)
+
+// logJSON is true if logs get written as JSON, one object per line,
+// for log collectors in container environments.
+var logJSON = false
+
+// makeSubsystemLogger returns a structured logger that writes to
+// standard error, tagging each record with its subsystem.
+func makeSubsystemLogger(subsystem string) *slog.Logger {
+	options := &slog.HandlerOptions{Level: logLevels[subsystem]}
+	var handler slog.Handler = slog.NewTextHandler(os.Stderr, options)
+	if logJSON {
+		handler = slog.NewJSONHandler(os.Stderr, options)
+	}
+	return slog.New(handler).With("subsystem", subsystem)
+}
+
+// setLogFormat switches logging to "text" or "json". In JSON format,
+// messages of the standard log package also get written as JSON.
+// Must be called before starting to serve.
+func setLogFormat(format string) error {
+	switch format {
+	case "text":
+		logJSON = false
+	case "json":
+		logJSON = true
+	default:
+		return fmt.Errorf("unknown log format %q; supported are text, json", format)
+	}
+
+	watcherLog = makeSubsystemLogger(logSubsystemWatcher)
+	loaderLog = makeSubsystemLogger(logSubsystemLoader)
+	httpLog = makeSubsystemLogger(logSubsystemHTTP)
+	if logJSON {
+		slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, nil)))
+	}
+	return nil
+}
+
+// getLogLevels returns the current log level of every subsystem.
+func getLogLevels() map[string]string {
+	result := make(map[string]string, len(logLevels))
+	for subsystem, level := range logLevels {
+		result[subsystem] = level.Level().String()
+	}
+	return result
+}
+
+// setLogLevels changes the log level of subsystems, such as
+// {"loader": "DEBUG"}. Nothing gets changed if any subsystem
+// or level is unknown.
+func setLogLevels(levels map[string]string) error {
+	parsed := make(map[string]slog.Level, len(levels))
+	for subsystem, name := range levels {
+		if logLevels[subsystem] == nil {
+			return fmt.Errorf("unknown subsystem %q; known are %s", subsystem, knownLogSubsystems())
+		}
+		var level slog.Level
+		if err := level.UnmarshalText([]byte(name)); err != nil {
+			return err
+		}
+		parsed[subsystem] = level
+	}
+	for subsystem, level := range parsed {
+		logLevels[subsystem].Set(level)
+	}
+	return nil
+}
+
+func knownLogSubsystems() string {
+	names := make([]string, 0, len(logLevels))
+	for subsystem := range logLevels {
+		names = append(names, subsystem)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// handleLogLevelsRequest returns the log levels of all subsystems.
+// A PUT request with a JSON body such as {"loader": "DEBUG"} and a valid
+// API key changes the levels of the given subsystems.
+func (s *WebServer) handleLogLevelsRequest(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case "GET", "HEAD":
+	case "PUT":
+		if !s.authorizeWrite(w, req) {
+			return
+		}
+		var levels map[string]string
+		err := json.NewDecoder(req.Body).Decode(&levels)
+		if err == nil {
+			err = setLogLevels(levels)
+		}
+		if err != nil {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, err.Error()+"\n")
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, HEAD, PUT")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	encoded, err := json.Marshal(getLogLevels())
+	if err != nil {
+		httpLog.Error("json.Marshal failed", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(encoded)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(encoded)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLogLevels(t *testing.T) {
+	_, s, _, cleanup := makeUploadServer(t)
+	defer cleanup()
+	defer logLevels[logSubsystemLoader].Set(slog.LevelInfo)
+
+	putWithKey := func(apiKey, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("PUT", "/admin/loglevels", strings.NewReader(body))
+		if len(apiKey) > 0 {
+			req.Header.Set("X-API-Key", apiKey)
+		}
+		resp := httptest.NewRecorder()
+		http.HandlerFunc(s.HandleRequest).ServeHTTP(resp, req)
+		return resp
+	}
+	put := func(body string) *httptest.ResponseRecorder {
+		return putWithKey(adminAPIKey, body)
+	}
+
+	for apiKey, expected := range map[string]int{"": http.StatusUnauthorized, "wrong": http.StatusForbidden} {
+		if resp := putWithKey(apiKey, `{"loader": "debug"}`); resp.Code != expected {
+			t.Errorf("API key %q: expected status %d, got %d", apiKey, expected, resp.Code)
+		}
+	}
+	if logLevels[logSubsystemLoader].Level() != slog.LevelInfo {
+		t.Fatal("expected log levels to stay unchanged without valid API key")
+	}
+
+	resp := put(`{"loader": "debug"}`)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", resp.Code, getBody(resp))
+	}
+	var levels map[string]string
+	if err := json.Unmarshal(resp.Body.Bytes(), &levels); err != nil {
+		t.Fatal(err)
+	}
+	if levels["loader"] != "DEBUG" || levels["http"] != "INFO" || levels["watcher"] != "INFO" {
+		t.Errorf("unexpected log levels: %v", levels)
+	}
+	if !loaderLog.Enabled(context.Background(), slog.LevelDebug) {
+		t.Error("expected loader to log debug messages")
+	}
+
+	for _, body := range []string{`{"nosuchsubsystem": "info"}`, `{"http": "chatty"}`, `[]`} {
+		if resp := put(body); resp.Code != http.StatusBadRequest {
+			t.Errorf("PUT %s: expected status 400, got %d", body, resp.Code)
+		}
+	}
+	if logLevels[logSubsystemHTTP].Level() != slog.LevelInfo {
+		t.Error("rejected requests should not change log levels")
+	}
+
+	index, public := makeServer(t)
+	defer public.Shutdown()
+	defer index.Close()
+	req, _ := http.NewRequest("GET", "/admin/loglevels", nil)
+	resp = httptest.NewRecorder()
+	http.HandlerFunc(public.HandleRequest).ServeHTTP(resp, req)
+	if resp.Code != http.StatusNotFound {
+		t.Errorf("expected status 404 on public server, got %d", resp.Code)
+	}
+}
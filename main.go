@@ -1,61 +1,721 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
+	"fmt"
+	"io/ioutil"
 	"log"
 	"net/http"
 	"net/url"
 	"os"
-	"os/signal"
 	"strconv"
 	"strings"
-	"syscall"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "export" {
+		runExport(os.Args[2:])
+		return
+	}
+
+	configFile := flag.String("config", "", "path to a YAML file defining collections and server settings; flags given on the command line take precedence")
 	collections := flag.String("collections", "castles=path/to/castles.geojson,lakes=path/to/lakes.geojson",
-		"comma-separated list of collection=filepath, each being a GeoJSON feature collection that will be served to clients")
+		"comma-separated list of collection=filepath, each being a GeoJSON feature collection that will be served to clients; filepath may also be a directory or glob pattern such as data/roads/*.geojson, whose files get merged")
+	canaryCollections := flag.String("canaryCollections", "",
+		"comma-separated list of collection=filepath for staged rollouts; requests with header "+CanaryHeader+": true or ?canary=true get served from the canary source")
 	port := flag.Int("port", 8080, "TCP port for serving requests")
+	listeners := flag.Int("listeners", 1, "number of sockets accepting requests on --port, bound with SO_REUSEPORT if more than one; Linux only")
 	publicPathPrefix := flag.String("pathPrefix", "http://localhost:8080/",
-		"externally accessible http path to this server")
+		"externally accessible http path to this server, or empty to derive it from requests")
+	protectedCollections := flag.String("protectedCollections", "",
+		"comma-separated list of collections that can only be accessed with an API key or a signed URL")
+	apiKeysFile := flag.String("apiKeys", "", "path to a file with one API key per line")
+	signingKeyFile := flag.String("urlSigningKey", "", "path to a file with the secret key for signing URLs")
+	unlistedCollections := flag.String("unlistedCollections", "",
+		"comma-separated list of collections that are reachable by direct URL, but not listed")
+	privateCollections := flag.String("privateCollections", "",
+		"comma-separated list of collections that are only served on the admin port")
+	adminPort := flag.Int("adminPort", 0, "TCP port for serving admin requests, or 0 for no admin listener")
+	webhooks := flag.String("webhooks", "",
+		"comma-separated list of URLs that get notified when a collection has changed")
+	natsAddress := flag.String("nats", "", "address of a NATS server for change notifications, such as nats://localhost:4222")
+	natsSubject := flag.String("natsSubject", "miniwfs.changes", "NATS subject for change notifications")
+	tombstones := flag.Bool("tombstones", false, "remember features removed by reloads, for ?includeDeleted=true and change notifications")
+	natsSubscribe := flag.Bool("natsSubscribe", false,
+		"whether to check for reloads when another replica announces a change on NATS")
+	upstream := flag.String("upstream", "",
+		"base URL of an OGC API Features server that receives requests for unknown collections and features")
+	upstreamCacheTTL := flag.Duration("upstreamCacheTTL", 0, "how long to cache upstream responses, or 0 for no caching")
+	experimentalFormats := flag.String("experimentalFormats", "",
+		"comma-separated list of experimental output formats to enable, such as gml,cityjson")
+	webhookSecretFile := flag.String("webhookSecret", "", "path to a file with the secret key for signing webhook payloads")
+	quotaDailyRequests := flag.Int64("quotaDailyRequests", 0, "maximal number of requests per API key and day, or 0 for unlimited")
+	quotaMonthlyRequests := flag.Int64("quotaMonthlyRequests", 0, "maximal number of requests per API key and month, or 0 for unlimited")
+	quotaDailyBytes := flag.Int64("quotaDailyBytes", 0, "maximal number of response bytes per API key and day, or 0 for unlimited")
+	quotaMonthlyBytes := flag.Int64("quotaMonthlyBytes", 0, "maximal number of response bytes per API key and month, or 0 for unlimited")
+	quotaState := flag.String("quotaState", "", "path to a file for persisting quota usage across restarts")
+	shedLatency := flag.Duration("shedLatency", 0, "if the 99th percentile of handler latency exceeds this, reject expensive requests with 503, or 0 to disable")
+	shedCPU := flag.Float64("shedCPU", 0, "if CPU usage exceeds this fraction of all cores, such as 0.9, reject expensive requests with 503, or 0 to disable")
+	collectionPriorities := flag.String("collectionPriorities", "",
+		"comma-separated list of collection=priority, such as basemap=high,buildings=low; under load, requests for low-priority collections get shed first, and those for high-priority collections never")
+	idempotencyWindow := flag.Duration("idempotencyWindow", 24*time.Hour, "how long to remember responses to admin POST requests with an Idempotency-Key header, so retries do not apply writes twice, or 0 to ignore the header")
+	standbyOf := flag.String("standbyOf", "",
+		"base URL of an active server; if set, this server is its warm standby, serving only /healthz until promoted via /admin/promote or losing the heartbeat of the active server")
+	heartbeatInterval := flag.Duration("heartbeatInterval", 5*time.Second, "how often a standby checks the /healthz of the active server")
+	failoverTimeout := flag.Duration("failoverTimeout", 30*time.Second, "how long the active server may be unhealthy before a standby takes over")
+	maxMemory := flag.Int64("maxMemory", 0, "approximate maximal memory in bytes for collections and their caches; reloads that would exceed it are refused, or 0 for unlimited")
+	spillThreshold := flag.Int("spillThreshold", 0, "keep the index of collections with at least this many features on disk instead of in memory, trading latency for memory, or 0 to keep all indexes in memory")
+	spillCacheSize := flag.Int64("spillCacheSize", 64<<20, "bytes of memory for caching pages of on-disk indexes, see --spillThreshold")
+	tempDir := flag.String("tempDir", "", "directory for temporary files with collection data, or empty for the system default; files left behind by crashed processes get removed at startup")
+	slowQueryLatency := flag.Duration("slowQueryLatency", 0, "log items requests taking at least this long, keeping the most recent ones at /admin/slowlog, or 0 to disable")
+	slowQueryScanned := flag.Int("slowQueryScanned", 0, "log items requests examining at least this many features, keeping the most recent ones at /admin/slowlog, or 0 to disable")
+	refreshInterval := flag.Duration("refreshInterval", defaultRefreshInterval, "how often to check collections loaded from http:// or https:// URLs for changes")
+	allowIPs := flag.String("allowIPs", "",
+		"comma-separated list of networks in CIDR notation, such as 10.0.0.0/8; if set, only clients from these networks are served")
+	denyIPs := flag.String("denyIPs", "", "comma-separated list of networks in CIDR notation whose clients are not served")
+	collectionAllowIPs := flag.String("collectionAllowIPs", "",
+		"comma-separated list of collection=network, such as partners=10.0.0.0/8, restricting collections to client networks")
+	collectionDenyIPs := flag.String("collectionDenyIPs", "",
+		"comma-separated list of collection=network whose clients may not access the collection")
+	collectionAttributions := flag.String("collectionAttributions", "",
+		"path to a JSON file mapping collection names to {\"license\": URL, \"attribution\": text}")
+	collectionStyles := flag.String("collectionStyles", "",
+		"path to a JSON file mapping collection names to {\"color\": \"#rrggbb\", \"property\": name, \"categories\": {value: {\"color\", \"symbol\"}}}, for rendering tiles and map styles")
+	warmUp := flag.Bool("warmUp", false, "render previews and low-zoom tiles after startup, reporting /readyz as unavailable until done")
+	migrations := flag.String("migrations", "",
+		"path to a JSON file mapping collection names to lists of {\"op\": \"rename\" or \"convert\", \"property\": name, \"to\": name or type}, applied to features when loading")
+	elevationSource := flag.String("elevationSource", "",
+		"URL of an elevation service with the Open-Elevation API, or path to a DEM in ESRI ASCII grid format, for adding elevation to point features when loading")
+	elevationProperty := flag.String("elevationProperty", "ele", "property for the elevation added by --elevationSource")
+	elevationCollections := flag.String("elevationCollections", "",
+		"comma-separated list of collections that get elevation from --elevationSource, or empty for all")
+	regionBoundaries := flag.String("regionBoundaries", "",
+		"path to a GeoJSON file with areas tagged ISO3166-1 or ISO3166-2, for adding country and region codes to features when loading")
+	regionCollections := flag.String("regionCollections", "",
+		"comma-separated list of collections that get tagged with --regionBoundaries, or empty for all")
+	splitMultiGeometries := flag.String("splitMultiGeometries", "",
+		"comma-separated list of collections whose MultiPoint, MultiLineString and MultiPolygon features get split into one feature per part, with IDs like W123#1 and the parent ID in property \""+SplitParentProperty+"\"")
+	itemOrder := flag.String("itemOrder", ItemOrderSource,
+		"order of items when paging: source for the order of the source files, or id or hilbert for an order that stays stable across reloads of identical data")
+	collectionGroups := flag.String("collectionGroups", "",
+		"comma-separated list of collection=group, such as lakes=hydrography,castles=heritage, for organizing collections in listings")
+	csvLatitude := flag.String("csvLatitude", defaultCSVLatitude, "comma-separated candidate names of the latitude column in CSV sources, matched case-insensitively")
+	csvLongitude := flag.String("csvLongitude", defaultCSVLongitude, "comma-separated candidate names of the longitude column in CSV sources, matched case-insensitively")
+	csvID := flag.String("csvID", defaultCSVID, "comma-separated candidate names of the ID column in CSV sources; without one, rows are numbered")
+	saveData := flag.String("saveData", "auto",
+		"shaping of items responses for low bandwidth: auto for clients sending \"Save-Data: on\", always for all clients, or off")
+	saveDataPrecision := flag.Int("saveDataPrecision", 5, "decimal digits of coordinates in responses shaped for low bandwidth; 5 is about a meter")
+	saveDataLimit := flag.Int("saveDataLimit", 5, "default number of items per page in responses shaped for low bandwidth")
+	saveDataProperties := flag.String("saveDataProperties", "",
+		"comma-separated list of collection=property, such as castles=name,castles=historic, telling which properties to keep in responses shaped for low bandwidth; collections not listed keep all properties")
+	logFormat := flag.String("logFormat", "text", "format of log messages, text or json")
 	flag.Parse()
+	config := readConfig(*configFile, flag.CommandLine, true)
 
-	coll := make(map[string]string)
-	for _, s := range strings.Split(*collections, ",") {
-		p := strings.SplitN(s, "=", 2)
-		if p == nil || len(p) != 2 {
-			log.Fatal("malformed --collections command-line argument; pass something like --collections=castles=path/to/c.geojson,lakes=path/to/l.geojson")
-		}
-		coll[p[0]] = p[1]
+	// Without any flags, run in zero-config mode for containers.
+	zeroConfig := len(os.Args) == 1
+	if zeroConfig {
+		*publicPathPrefix = ""
+		*logFormat = "json"
+	}
+	if err := setLogFormat(*logFormat); err != nil {
+		log.Fatal(err)
 	}
 
+	if err := SetTempDirectory(*tempDir); err != nil {
+		log.Fatal(err)
+	}
+	enableExperimentalFormats(*experimentalFormats)
+	RegisterInputLoader(MakeCSVLoader(*csvLatitude, *csvLongitude, *csvID), []string{".csv"}, nil)
+	var coll map[string]string
+	if zeroConfig {
+		scanned, err := scanDataDirectory(dataDirectory)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Zero-config mode: serving %d collections from %s", len(scanned), dataDirectory)
+		coll = scanned
+	} else {
+		coll = make(map[string]string)
+		if !config.DefinesCollections() {
+			coll = parseCollections(*collections)
+		}
+	}
+	groups := parseCollectionGroups(*collectionGroups)
+	attributions := readCollectionAttributions(*collectionAttributions)
+	styles := readCollectionStyles(*collectionStyles)
+	collMigrations := readCollectionMigrations(*migrations)
+	config.MergeCollections(coll, groups, attributions, styles, collMigrations)
+	canarySources, canaries := parseCanaryCollections(*canaryCollections)
+	for name, path := range canarySources {
+		coll[name] = path
+	}
 	publicPath, err := url.Parse(*publicPathPrefix)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	index, err := MakeIndex(coll, publicPath)
+	// Check what commonly breaks deployments before loading, so that
+	// the report tells why if loading fails.
+	startup := MakeStartupReport(time.Now())
+	startup.CheckConfig(coll, publicPath)
+	startup.CheckSources(coll)
+	startup.CheckTempDir()
+	startup.CheckPort("port", *port, *listeners)
+	if *adminPort > 0 {
+		startup.CheckPort("adminPort", *adminPort, 1)
+	}
+
+	opts := IndexOptions{
+		Migrations:           collMigrations,
+		ItemOrder:            *itemOrder,
+		SplitMultiGeometries: append(splitList(*splitMultiGeometries), config.CollectionsWithOption("splitMultiGeometries")...),
+		SpillThreshold:       *spillThreshold,
+		SpillCacheBytes:      *spillCacheSize,
+	}
+	configureLoadOptions(&opts, *elevationSource, *elevationProperty, *elevationCollections, *regionBoundaries, *regionCollections)
+	index, err := MakeIndex(coll, publicPath, opts)
 	if err != nil {
+		startup.add("collections", StartupFailed, err.Error())
+		startup.Log()
 		log.Fatal(err)
 	}
 	defer index.Close()
+	startup.add("collections", StartupOK, "")
+	startup.AddCollections(index)
+	startup.Log()
+	index.SetCollectionGroups(groups)
+	index.SetCollectionDescriptions(config.Descriptions())
+	index.SetCollectionAttributions(attributions)
+	index.SetCollectionStyles(styles)
+	index.SetMaxMemory(*maxMemory)
+	index.SetRefreshInterval(*refreshInterval)
+	index.SetKeepTombstones(*tombstones)
+	if *warmUp {
+		index.StartWarmUp()
+	}
+
+	access := makeAccessControl(append(splitList(*protectedCollections), config.CollectionsWithOption("protected")...),
+		*apiKeysFile, *signingKeyFile)
+	access.SetUnlisted(append(splitList(*unlistedCollections), config.CollectionsWithOption("unlisted")...))
+	access.SetPrivate(append(splitList(*privateCollections), config.CollectionsWithOption("private")...))
+	setIPFilters(access, *allowIPs, *denyIPs, *collectionAllowIPs, *collectionDenyIPs)
 
+	var notifier *WebhookNotifier
+	if urls := splitList(*webhooks); len(urls) > 0 {
+		var secret []byte
+		if len(*webhookSecretFile) > 0 {
+			secret = readSecret(*webhookSecretFile)
+		}
+		notifier = MakeWebhookNotifier(urls, secret)
+		index.AddChangeListener(notifier.Notify)
+	}
+
+	if len(*natsAddress) > 0 {
+		nats, err := DialNATS(*natsAddress)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer nats.Close()
+		index.AddChangeListener(nats.PublishChanges(*natsSubject))
+		if *natsSubscribe {
+			err := nats.Subscribe(*natsSubject, func(payload []byte) {
+				var event ChangeEvent
+				if err := json.Unmarshal(payload, &event); err == nil {
+					go index.ReloadCollection(event.Collection)
+				}
+			})
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+
+	var upstreamProxy *UpstreamProxy
+	if len(*upstream) > 0 {
+		u, err := url.Parse(*upstream)
+		if err != nil {
+			log.Fatal(err)
+		}
+		upstreamProxy = MakeUpstreamProxy(u, *upstreamCacheTTL)
+	}
+
+	var standby *Standby
+	if len(*standbyOf) > 0 {
+		u, err := url.Parse(*standbyOf)
+		if err != nil {
+			log.Fatal(err)
+		}
+		standby = MakeStandby(u, *heartbeatInterval, *failoverTimeout)
+		standby.Start()
+		defer standby.Stop()
+	}
+
+	quotaLimits := QuotaLimits{
+		DailyRequests:   *quotaDailyRequests,
+		MonthlyRequests: *quotaMonthlyRequests,
+		DailyBytes:      *quotaDailyBytes,
+		MonthlyBytes:    *quotaMonthlyBytes,
+	}
+	var quotas *QuotaTracker
+	if quotaLimits != (QuotaLimits{}) {
+		quotas = MakeQuotaTracker(quotaLimits)
+		if len(*quotaState) > 0 {
+			if err := quotas.Load(*quotaState); err != nil {
+				log.Fatal(err)
+			}
+			go func() {
+				for range time.Tick(time.Minute) {
+					if err := quotas.Save(*quotaState); err != nil {
+						log.Printf("saving quota state failed: %v", err)
+					}
+				}
+			}()
+		}
+	}
+
+	usage := MakeUsageRecorder()
+	var slowlog *SlowQueryLog
+	if *slowQueryLatency > 0 || *slowQueryScanned > 0 {
+		slowlog = MakeSlowQueryLog(*slowQueryLatency, *slowQueryScanned)
+	}
 	server := MakeWebServer(index)
+	server.access = access
+	server.upstream = upstreamProxy
+	server.quotas = quotas
+	server.usage = usage
+	server.canaries = canaries
+	server.listeners = *listeners
+	server.standby = standby
+	server.slowlog = slowlog
+	server.saveData = makeSaveDataPolicy(*saveData, *saveDataPrecision, *saveDataLimit, *saveDataProperties)
+	if *shedLatency > 0 || *shedCPU > 0 {
+		server.shedder = MakeLoadShedder(*shedLatency, *shedCPU, parseCollectionPriorities(*collectionPriorities))
+		server.shedder.Start()
+		defer server.shedder.Stop()
+	}
 	http.Handle("/metrics", promhttp.Handler())
-	http.HandleFunc("/collections", server.HandleRequest)
-	http.HandleFunc("/collections/", server.HandleRequest)
-	http.HandleFunc("/tiles/", server.HandleRequest)
+	registerHandlers(http.DefaultServeMux, server)
+
+	var adminServer *WebServer
+	if *adminPort > 0 {
+		adminServer = MakeWebServer(index)
+		adminServer.access = access
+		adminServer.admin = true
+		adminServer.webhooks = notifier
+		adminServer.upstream = upstreamProxy
+		adminServer.usage = usage
+		adminServer.canaries = canaries
+		adminServer.standby = standby
+		adminServer.slowlog = slowlog
+		adminServer.startup = startup
+		if *idempotencyWindow > 0 {
+			adminServer.idempotency = MakeIdempotencyCache(*idempotencyWindow)
+		}
+		adminMux := http.NewServeMux()
+		adminMux.Handle("/metrics", promhttp.Handler())
+		registerHandlers(adminMux, adminServer)
+		adminMux.HandleFunc("/admin/", adminServer.HandleRequest)
+		log.Printf("Listening for admin requests on port %v\n", strconv.Itoa(*adminPort))
+		go func() {
+			if err := adminServer.ListenAndServe(*adminPort, adminMux); err != http.ErrServerClosed {
+				log.Fatal(err)
+			}
+		}()
+	}
+
 	log.Printf("Listening for requests on port %v\n", strconv.Itoa(*port))
-	go func() { // Gracefully shut down server upon SIGINT, so we do not lose queries.
-		sigint := make(chan os.Signal, 1)
-		signal.Notify(sigint, syscall.SIGINT, syscall.SIGTERM)
-		<-sigint
+	stop := shutdownSignal()
+	go func() { // Gracefully shut down server, so we do not lose queries.
+		<-stop
+		if adminServer != nil {
+			adminServer.Shutdown()
+		}
 		server.Shutdown()
 	}()
-	if err := server.ListenAndServe(*port); err != http.ErrServerClosed {
+	if err := server.ListenAndServe(*port, nil); err != http.ErrServerClosed {
 		log.Fatal(err)
 	}
+	if quotas != nil && len(*quotaState) > 0 {
+		if err := quotas.Save(*quotaState); err != nil {
+			log.Printf("saving quota state failed: %v", err)
+		}
+	}
 	log.Printf("Server has shut down.\n")
+	serviceStopped()
+}
+
+func registerHandlers(mux *http.ServeMux, server *WebServer) {
+	mux.HandleFunc("/collections", server.HandleRequest)
+	mux.HandleFunc("/collections/", server.HandleRequest)
+	mux.HandleFunc("/tiles/", server.HandleRequest)
+	mux.HandleFunc("/f/", server.HandleRequest)
+	mux.HandleFunc("/wfs", server.HandleRequest)
+	mux.HandleFunc("/api", server.HandleRequest)
+	mux.HandleFunc("/conformance", server.HandleRequest)
+	mux.HandleFunc("/readyz", server.HandleRequest)
+	mux.HandleFunc("/healthz", server.HandleRequest)
+
+	// The pattern "/" matches every path that no other pattern does,
+	// but only the landing page itself should get served there.
+	mux.HandleFunc("/", func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/" {
+			http.NotFound(w, req)
+			return
+		}
+		server.HandleRequest(w, req)
+	})
+}
+
+// runExport implements the "export" subcommand, which writes a static
+// snapshot of the API to a local directory and exits.
+//
+//	$ miniwfs export --collections=castles=castles.geojson \
+//	    --pathPrefix=https://example.org/castles/ --out=/tmp/castles --maxZoom=8
+func runExport(args []string) {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	configFile := flags.String("config", "", "path to a YAML file defining collections and server settings; flags given on the command line take precedence")
+	collections := flags.String("collections", "castles=path/to/castles.geojson,lakes=path/to/lakes.geojson",
+		"comma-separated list of collection=filepath, each being a GeoJSON feature collection that will be exported")
+	publicPathPrefix := flags.String("pathPrefix", "http://localhost:8080/",
+		"externally accessible http path where the exported files will be hosted")
+	out := flags.String("out", "", "directory where the exported files will be written")
+	maxZoom := flags.Int("maxZoom", 8, "maximal zoom level for exported tiles")
+	experimentalFormats := flags.String("experimentalFormats", "",
+		"comma-separated list of experimental output formats to export, such as gml,cityjson")
+	unlistedCollections := flags.String("unlistedCollections", "",
+		"comma-separated list of collections that will not be exported")
+	privateCollections := flags.String("privateCollections", "",
+		"comma-separated list of collections that will not be exported")
+	collectionAttributions := flags.String("collectionAttributions", "",
+		"path to a JSON file mapping collection names to {\"license\": URL, \"attribution\": text}")
+	collectionStyles := flags.String("collectionStyles", "",
+		"path to a JSON file mapping collection names to {\"color\": \"#rrggbb\", \"property\": name, \"categories\": {value: {\"color\", \"symbol\"}}}, for rendering tiles and map styles")
+	migrations := flags.String("migrations", "",
+		"path to a JSON file mapping collection names to lists of {\"op\": \"rename\" or \"convert\", \"property\": name, \"to\": name or type}, applied to features when loading")
+	elevationSource := flags.String("elevationSource", "",
+		"URL of an elevation service with the Open-Elevation API, or path to a DEM in ESRI ASCII grid format, for adding elevation to point features when loading")
+	elevationProperty := flags.String("elevationProperty", "ele", "property for the elevation added by --elevationSource")
+	elevationCollections := flags.String("elevationCollections", "",
+		"comma-separated list of collections that get elevation from --elevationSource, or empty for all")
+	regionBoundaries := flags.String("regionBoundaries", "",
+		"path to a GeoJSON file with areas tagged ISO3166-1 or ISO3166-2, for adding country and region codes to features when loading")
+	regionCollections := flags.String("regionCollections", "",
+		"comma-separated list of collections that get tagged with --regionBoundaries, or empty for all")
+	splitMultiGeometries := flags.String("splitMultiGeometries", "",
+		"comma-separated list of collections whose MultiPoint, MultiLineString and MultiPolygon features get split into one feature per part, with IDs like W123#1 and the parent ID in property \""+SplitParentProperty+"\"")
+	itemOrder := flags.String("itemOrder", ItemOrderSource,
+		"order of items when paging: source for the order of the source files, or id or hilbert for an order that stays stable across reloads of identical data")
+	collectionGroups := flags.String("collectionGroups", "",
+		"comma-separated list of collection=group, such as lakes=hydrography,castles=heritage, for organizing collections in listings")
+	flags.Parse(args)
+	config := readConfig(*configFile, flags, false)
+
+	enableExperimentalFormats(*experimentalFormats)
+	if len(*out) == 0 {
+		log.Fatal("missing --out command-line argument; pass something like --out=path/to/export")
+	}
+
+	publicPath, err := url.Parse(*publicPathPrefix)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	coll := make(map[string]string)
+	if !config.DefinesCollections() {
+		coll = parseCollections(*collections)
+	}
+	groups := parseCollectionGroups(*collectionGroups)
+	attributions := readCollectionAttributions(*collectionAttributions)
+	styles := readCollectionStyles(*collectionStyles)
+	collMigrations := readCollectionMigrations(*migrations)
+	config.MergeCollections(coll, groups, attributions, styles, collMigrations)
+
+	opts := IndexOptions{
+		Migrations:           collMigrations,
+		ItemOrder:            *itemOrder,
+		SplitMultiGeometries: append(splitList(*splitMultiGeometries), config.CollectionsWithOption("splitMultiGeometries")...),
+	}
+	configureLoadOptions(&opts, *elevationSource, *elevationProperty, *elevationCollections, *regionBoundaries, *regionCollections)
+	index, err := MakeIndex(coll, publicPath, opts)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer index.Close()
+	index.SetCollectionGroups(groups)
+	index.SetCollectionDescriptions(config.Descriptions())
+	index.SetCollectionAttributions(attributions)
+	index.SetCollectionStyles(styles)
+
+	server := MakeWebServer(index)
+	server.access = MakeAccessControl(nil, nil, nil)
+	server.access.SetUnlisted(append(splitList(*unlistedCollections), config.CollectionsWithOption("unlisted")...))
+	server.access.SetPrivate(append(splitList(*privateCollections), config.CollectionsWithOption("private")...))
+	if err := Export(server, *out, *maxZoom); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Exported to %s\n", *out)
+}
+
+// configureLoadOptions checks --itemOrder, and sets up the elevation
+// and region enrichers of the index as told by the command-line flags.
+func configureLoadOptions(opts *IndexOptions, elevationSource, elevationProperty, elevationCollections, regionBoundaries, regionCollections string) {
+	if !isValidItemOrder(opts.ItemOrder) {
+		log.Fatalf("unsupported --itemOrder=%s; supported are %s", opts.ItemOrder, strings.Join(ItemOrders, ", "))
+	}
+	if len(elevationSource) > 0 {
+		opts.Elevation = makeElevationEnricher(elevationSource, elevationProperty, elevationCollections)
+	}
+	if len(regionBoundaries) > 0 {
+		regions, err := ReadRegionTagger(regionBoundaries, splitList(regionCollections))
+		if err != nil {
+			log.Fatalf("cannot read --regionBoundaries %s: %v", regionBoundaries, err)
+		}
+		opts.Regions = regions
+	}
+}
+
+// readConfig reads the --config file, if any, and applies its server
+// settings to the flags that were not given on the command line.
+func readConfig(path string, flags *flag.FlagSet, strict bool) *Config {
+	if len(path) == 0 {
+		return nil
+	}
+	config, err := ReadConfig(path)
+	if err != nil {
+		log.Fatalf("cannot read --config file: %v", err)
+	}
+	if err := config.ApplyFlags(flags, strict); err != nil {
+		log.Fatalf("malformed --config file %s: %v", path, err)
+	}
+	return config
+}
+
+func makeAccessControl(protectedCollections []string, apiKeysFile string, signingKeyFile string) *AccessControl {
+	var apiKeys []string
+	if len(apiKeysFile) > 0 {
+		var err error
+		if apiKeys, err = ReadKeyFile(apiKeysFile); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	var signingKey []byte
+	if len(signingKeyFile) > 0 {
+		signingKey = readSecret(signingKeyFile)
+	}
+
+	return MakeAccessControl(apiKeys, protectedCollections, signingKey)
+}
+
+// setIPFilters configures the global IP filter, and the filters for
+// collections that appear in collection=network command-line arguments.
+func setIPFilters(access *AccessControl, allow string, deny string, collectionAllow string, collectionDeny string) {
+	if f := makeIPFilter(splitList(allow), splitList(deny)); f != nil {
+		access.SetIPFilter("", f)
+	}
+
+	allowByColl := parseCollectionNetworks("collectionAllowIPs", collectionAllow)
+	denyByColl := parseCollectionNetworks("collectionDenyIPs", collectionDeny)
+	for coll, networks := range allowByColl {
+		access.SetIPFilter(coll, makeIPFilter(networks, denyByColl[coll]))
+	}
+	for coll, networks := range denyByColl {
+		if _, ok := allowByColl[coll]; !ok {
+			access.SetIPFilter(coll, makeIPFilter(nil, networks))
+		}
+	}
+}
+
+func makeIPFilter(allow []string, deny []string) *IPFilter {
+	if len(allow) == 0 && len(deny) == 0 {
+		return nil
+	}
+	f, err := MakeIPFilter(allow, deny)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return f
+}
+
+func parseCollectionNetworks(flagName string, arg string) map[string][]string {
+	result := make(map[string][]string)
+	for _, s := range splitList(arg) {
+		p := strings.SplitN(s, "=", 2)
+		if len(p) != 2 || len(p[0]) == 0 || len(p[1]) == 0 {
+			log.Fatalf("malformed --%s command-line argument; pass something like --%s=partners=10.0.0.0/8,partners=192.168.0.0/16",
+				flagName, flagName)
+		}
+		result[p[0]] = append(result[p[0]], p[1])
+	}
+	return result
+}
+
+func enableExperimentalFormats(formats string) {
+	for _, format := range splitList(formats) {
+		if !EnableOutputEncoder(format) {
+			log.Fatalf("unknown output format %s; known formats are %s",
+				format, strings.Join(GetOutputEncoderNames(), ","))
+		}
+	}
+}
+
+// readSecret reads a file that contains a single secret key.
+func readSecret(path string) []byte {
+	keys, err := ReadKeyFile(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(keys) != 1 {
+		log.Fatalf("expected exactly one key in %s, got %d", path, len(keys))
+	}
+	return []byte(keys[0])
+}
+
+// splitList splits a comma-separated command-line argument.
+func splitList(s string) []string {
+	var result []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); len(item) > 0 {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+func parseCollections(collections string) map[string]string {
+	coll := make(map[string]string)
+	for _, s := range strings.Split(collections, ",") {
+		p := strings.SplitN(s, "=", 2)
+		if p == nil || len(p) != 2 {
+			log.Fatal("malformed --collections command-line argument; pass something like --collections=castles=path/to/c.geojson,lakes=path/to/l.geojson")
+		}
+		coll[p[0]] = p[1]
+	}
+	return coll
+}
+
+// readCollectionAttributions reads the license and attribution of
+// collections from a JSON file, such as
+// {"castles": {"license": "https://opendatacommons.org/licenses/odbl/1-0/", "attribution": "© OpenStreetMap contributors"}}
+func readCollectionAttributions(path string) map[string]CollectionAttribution {
+	result := make(map[string]CollectionAttribution)
+	if len(path) == 0 {
+		return result
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		log.Fatalf("malformed --collectionAttributions file %s: %v", path, err)
+	}
+	return result
+}
+
+// readCollectionStyles reads the rendering styles of collections
+// from a JSON file, such as {"lakes": {"color": "#1f78b4"}} or
+// {"castles": {"property": "historic", "categories": {"ruins": {"color": "#999", "symbol": "triangle"}}}}.
+func readCollectionStyles(path string) map[string]CollectionStyle {
+	result := make(map[string]CollectionStyle)
+	if len(path) == 0 {
+		return result
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		log.Fatalf("malformed --collectionStyles file %s: %v", path, err)
+	}
+	for name, style := range result {
+		if err := checkCollectionStyle(name, style); err != nil {
+			log.Fatalf("malformed --collectionStyles file %s: %v", path, err)
+		}
+	}
+	return result
+}
+
+func checkCollectionStyle(name string, style CollectionStyle) error {
+	if _, ok := parseHexColor(style.Color); !ok && len(style.Color) > 0 {
+		return fmt.Errorf("collection %s has bad color %q", name, style.Color)
+	}
+	for value, cat := range style.Categories {
+		if _, ok := parseHexColor(cat.Color); !ok && len(cat.Color) > 0 {
+			return fmt.Errorf("collection %s, category %s has bad color %q", name, value, cat.Color)
+		}
+		if !isSymbol(cat.Symbol) && len(cat.Symbol) > 0 {
+			return fmt.Errorf("collection %s, category %s has unknown symbol %q; supported are %s",
+				name, value, cat.Symbol, strings.Join(Symbols, ", "))
+		}
+	}
+	return nil
+}
+
+// readCollectionMigrations reads how to rewrite the features of
+// collections from a JSON file, such as
+// {"lakes": [{"op": "rename", "property": "ele", "to": "elevation"}]}.
+func readCollectionMigrations(path string) map[string][]PropertyMigration {
+	result := make(map[string][]PropertyMigration)
+	if len(path) == 0 {
+		return result
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		log.Fatalf("malformed --migrations file %s: %v", path, err)
+	}
+	for name, migrations := range result {
+		if err := checkCollectionMigrations(name, migrations); err != nil {
+			log.Fatalf("malformed --migrations file %s: %v", path, err)
+		}
+	}
+	return result
+}
+
+func checkCollectionMigrations(name string, migrations []PropertyMigration) error {
+	for _, m := range migrations {
+		if !m.isValid() {
+			return fmt.Errorf("collection %s has bad migration %q; supported ops are %s, and types are %s",
+				name, m.String(), strings.Join(MigrationOps, ", "), strings.Join(MigrationTypes, ", "))
+		}
+	}
+	return nil
+}
+
+// makeElevationEnricher sets up adding elevation to features, either
+// from a web service if source is a URL, or else from a local DEM file.
+func makeElevationEnricher(source string, property string, collections string) *ElevationEnricher {
+	if len(property) == 0 {
+		log.Fatal("empty --elevationProperty command-line argument; pass something like --elevationProperty=ele")
+	}
+	var elevation ElevationSource
+	if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+		elevation = MakeElevationService(source)
+	} else {
+		dem, err := ReadDEM(source)
+		if err != nil {
+			log.Fatalf("cannot read --elevationSource %s: %v", source, err)
+		}
+		elevation = dem
+	}
+	return MakeElevationEnricher(elevation, property, splitList(collections))
+}
+
+func parseCollectionGroups(groups string) map[string]string {
+	result := make(map[string]string)
+	for _, s := range splitList(groups) {
+		p := strings.SplitN(s, "=", 2)
+		if len(p) != 2 || len(p[0]) == 0 || len(p[1]) == 0 {
+			log.Fatal("malformed --collectionGroups command-line argument; pass something like --collectionGroups=lakes=hydrography,castles=heritage")
+		}
+		result[p[0]] = p[1]
+	}
+	return result
 }
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+// defaultMaintenanceRetryAfter is how many seconds clients get told to
+// wait during maintenance, unless configured otherwise.
+const defaultMaintenanceRetryAfter = 300
+
+// MaintenanceMode tells whether the server is down for maintenance,
+// such as a planned data migration. During maintenance, collections do
+// not get reloaded from their sources and uploads are rejected. Public
+// reads either fail with 503 Service Unavailable, or keep getting served
+// from the data loaded before maintenance if ReadOnly is set. Once
+// maintenance ends, all collections get reloaded.
+type MaintenanceMode struct {
+	Enabled    bool   `json:"enabled"`
+	Message    string `json:"message,omitempty"`
+	ReadOnly   bool   `json:"readOnly"`
+	RetryAfter int    `json:"retryAfter"` // seconds
+}
+
+var InvalidMaintenanceMode error = errors.New("invalid maintenance mode; retryAfter must not be negative")
+
+// SetMaintenanceMode enters or leaves maintenance mode.
+func (index *Index) SetMaintenanceMode(m MaintenanceMode) error {
+	if m.RetryAfter < 0 {
+		return InvalidMaintenanceMode
+	}
+	if m.Enabled && m.RetryAfter == 0 {
+		m.RetryAfter = defaultMaintenanceRetryAfter
+	}
+	if !m.Enabled {
+		m = MaintenanceMode{}
+	}
+
+	index.mutex.Lock()
+	ended := index.maintenance.Enabled && !m.Enabled
+	index.maintenance = m
+	index.mutex.Unlock()
+
+	if ended {
+		index.reloadCollections(func(name string) bool { return true })
+	}
+	return nil
+}
+
+// GetMaintenanceMode returns the current maintenance mode.
+func (index *Index) GetMaintenanceMode() MaintenanceMode {
+	index.mutex.RLock()
+	defer index.mutex.RUnlock()
+	return index.maintenance
+}
+
+// rejectForMaintenance responds with 503 Service Unavailable and returns
+// true if a request must not be served during maintenance. Health checks
+// and the admin API stay available, except for uploads, edits and reloads.
+func (s *WebServer) rejectForMaintenance(w http.ResponseWriter, req *http.Request, path string) bool {
+	m := s.index.GetMaintenanceMode()
+	if !m.Enabled || path == "/healthz" || path == "/readyz" {
+		return false
+	}
+	if s.admin {
+		isUpload := uploadRegexp.MatchString(path) || startUploadRegexp.MatchString(path) ||
+			uploadSessionRegexp.MatchString(path)
+		isEdit := (req.Method == "POST" && collectionRegexp.MatchString(path)) ||
+			((req.Method == "PUT" || req.Method == "PATCH") && itemRegexp.MatchString(req.URL.EscapedPath()))
+		if !isUpload && !isEdit && path != "/admin/reload" {
+			return false
+		}
+	} else if m.ReadOnly {
+		return false
+	}
+
+	msg := m.Message
+	if len(msg) == 0 {
+		msg = "down for maintenance"
+	}
+	msg += "\n"
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Content-Length", strconv.Itoa(len(msg)))
+	w.Header().Set("Retry-After", strconv.Itoa(m.RetryAfter))
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusServiceUnavailable)
+	io.WriteString(w, msg)
+	return true
+}
+
+// handleMaintenanceRequest returns the maintenance mode. A PUT request
+// with a JSON body such as {"enabled": true, "message": "migrating data"}
+// and a valid API key changes it.
+func (s *WebServer) handleMaintenanceRequest(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case "GET", "HEAD":
+	case "PUT":
+		if !s.authorizeWrite(w, req) {
+			return
+		}
+		var m MaintenanceMode
+		err := json.NewDecoder(req.Body).Decode(&m)
+		if err == nil {
+			err = s.index.SetMaintenanceMode(m)
+		}
+		if err != nil {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, err.Error()+"\n")
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, HEAD, PUT")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	writeMaintenanceJSON(w, s.index.GetMaintenanceMode())
+}
+
+// handleHealthRequest reports that the server is alive, whether it is
+// in maintenance mode, and its role in a failover pair. Unlike /readyz,
+// it always succeeds.
+func (s *WebServer) handleHealthRequest(w http.ResponseWriter, req *http.Request) {
+	m := s.index.GetMaintenanceMode()
+	status := "ok"
+	if m.Enabled {
+		status = "maintenance"
+	}
+	writeMaintenanceJSON(w, struct {
+		Status      string          `json:"status"`
+		Role        string          `json:"role,omitempty"`
+		Maintenance MaintenanceMode `json:"maintenance"`
+	}{status, s.standby.role(), m})
+}
+
+func writeMaintenanceJSON(w http.ResponseWriter, value interface{}) {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		httpLog.Error("json.Marshal failed", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(encoded)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(encoded)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMaintenanceMode(t *testing.T) {
+	index, s := makeServer(t)
+	defer s.Shutdown()
+	defer index.Close()
+	admin := MakeWebServer(index)
+	admin.admin = true
+	admin.access = MakeAccessControl([]string{adminAPIKey}, nil, nil)
+
+	request := func(s *WebServer, method, path, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		if method == "PUT" {
+			req.Header.Set("X-API-Key", adminAPIKey)
+		}
+		resp := httptest.NewRecorder()
+		http.HandlerFunc(s.HandleRequest).ServeHTTP(resp, req)
+		return resp
+	}
+
+	for apiKey, expected := range map[string]int{"": http.StatusUnauthorized, "wrong": http.StatusForbidden} {
+		req, _ := http.NewRequest("PUT", "/admin/maintenance", strings.NewReader(`{"enabled": true}`))
+		if len(apiKey) > 0 {
+			req.Header.Set("X-API-Key", apiKey)
+		}
+		resp := httptest.NewRecorder()
+		http.HandlerFunc(admin.HandleRequest).ServeHTTP(resp, req)
+		if resp.Code != expected {
+			t.Errorf("API key %q: expected status %d, got %d", apiKey, expected, resp.Code)
+		}
+	}
+	if index.GetMaintenanceMode().Enabled {
+		t.Fatal("expected maintenance mode to stay off without valid API key")
+	}
+
+	resp := request(admin, "PUT", "/admin/maintenance", `{"enabled": true, "message": "migrating data"}`)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", resp.Code, getBody(resp))
+	}
+	expectJSON(t, getBody(resp), `{"enabled": true, "message": "migrating data", "readOnly": false, "retryAfter": 300}`)
+
+	resp = request(s, "GET", "/collections/castles/items", "")
+	if resp.Code != http.StatusServiceUnavailable || resp.Header().Get("Retry-After") != "300" ||
+		getBody(resp) != "migrating data\n" {
+		t.Errorf("expected 503 with Retry-After during maintenance, got %d %q %q",
+			resp.Code, resp.Header().Get("Retry-After"), getBody(resp))
+	}
+	if resp := request(admin, "POST", "/admin/collections/castles/uploads", ""); resp.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected uploads to be rejected during maintenance, got %d", resp.Code)
+	}
+	if resp := request(admin, "GET", "/collections/castles/items", ""); resp.Code != http.StatusOK {
+		t.Errorf("expected admin reads during maintenance, got %d", resp.Code)
+	}
+
+	resp = request(s, "GET", "/healthz", "")
+	if resp.Code != http.StatusOK {
+		t.Errorf("expected /healthz to succeed during maintenance, got %d", resp.Code)
+	}
+	expectJSON(t, getBody(resp), `{"status": "maintenance", "maintenance":
+		{"enabled": true, "message": "migrating data", "readOnly": false, "retryAfter": 300}}`)
+
+	request(admin, "PUT", "/admin/maintenance", `{"enabled": true, "readOnly": true, "retryAfter": 60}`)
+	if resp := request(s, "GET", "/collections/castles/items", ""); resp.Code != http.StatusOK {
+		t.Errorf("expected reads in read-only maintenance, got %d", resp.Code)
+	}
+
+	request(admin, "PUT", "/admin/maintenance", `{"enabled": false}`)
+	if resp := request(s, "GET", "/collections/castles/items", ""); resp.Code != http.StatusOK {
+		t.Errorf("expected reads after maintenance, got %d", resp.Code)
+	}
+	expectJSON(t, getBody(request(s, "GET", "/healthz", "")), `{"status": "ok", "maintenance":
+		{"enabled": false, "readOnly": false, "retryAfter": 0}}`)
+
+	if resp := request(admin, "PUT", "/admin/maintenance", `{"enabled": true, "retryAfter": -1}`); resp.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for negative retryAfter, got %d", resp.Code)
+	}
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+)
+
+// MBTiles output, for taking tiles to places without connectivity.
+// MBTiles files are SQLite databases, which we write by encoding the
+// documented file format, like we read GeoPackages in sqlite.go. The
+// writer is minimal: it builds a database of plain tables in memory,
+// without indexes, free pages or journal, which is all the MBTiles
+// specification requires.
+// https://github.com/mapbox/mbtiles-spec/blob/master/1.3/spec.md
+// https://www.sqlite.org/fileformat2.html
+
+// MBTilesMetadata holds the rows of the MBTiles metadata table, such
+// as "name", "format", "bounds", "minzoom" and "maxzoom".
+type MBTilesMetadata map[string]string
+
+// MBTile is a single tile in an MBTiles file.
+type MBTile struct {
+	Key  TileKey
+	Data []byte
+}
+
+// mbtilesApplicationID marks SQLite databases in MBTiles format.
+const mbtilesApplicationID = 0x4d504258 // "MPBX"
+
+// WriteMBTiles writes tiles and metadata as MBTiles database.
+func WriteMBTiles(w io.Writer, metadata MBTilesMetadata, tiles []MBTile) error {
+	names := make([]string, 0, len(metadata))
+	for name := range metadata {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	metadataRows := make([][]interface{}, len(names))
+	for i, name := range names {
+		metadataRows[i] = []interface{}{name, metadata[name]}
+	}
+
+	// MBTiles count tile rows from the south, like TMS.
+	tileRows := make([][]interface{}, len(tiles))
+	for i, t := range tiles {
+		row := (int64(1) << t.Key.Zoom) - 1 - int64(t.Key.Y)
+		tileRows[i] = []interface{}{int64(t.Key.Zoom), int64(t.Key.X), row, t.Data}
+	}
+
+	db := &sqliteWriter{pageSize: sqliteWriterPageSize}
+	return db.write(w, mbtilesApplicationID, []sqliteWriterTable{
+		{"metadata", "CREATE TABLE metadata (name text, value text)", metadataRows},
+		{"tiles", "CREATE TABLE tiles (zoom_level integer, tile_column integer, tile_row integer, tile_data blob)", tileRows},
+	})
+}
+
+// sqliteWriterPageSize is the page size of the databases we write.
+const sqliteWriterPageSize = 4096
+
+type sqliteWriterTable struct {
+	name string
+	sql  string
+	rows [][]interface{} // int64, string, []byte or nil
+}
+
+// sqliteWriter builds a database in memory, page by page. Page 1 holds
+// the file header and the schema table; the other pages get allocated
+// as tables are written.
+type sqliteWriter struct {
+	pageSize int
+	pages    [][]byte // pages[0] is page number 1
+}
+
+func (db *sqliteWriter) write(w io.Writer, applicationID uint32, tables []sqliteWriterTable) error {
+	db.pages = [][]byte{make([]byte, db.pageSize)}
+	schema := make([][]byte, len(tables))
+	for i, t := range tables {
+		records := make([][]byte, len(t.rows))
+		for j, row := range t.rows {
+			records[j] = encodeSQLiteRecord(row)
+		}
+		root := db.writeTree(records)
+		schema[i] = encodeSQLiteRecord([]interface{}{"table", t.name, t.name, int64(root), t.sql})
+	}
+
+	// The schema table has its root on page 1, after the file header.
+	cells := make([][]byte, len(schema))
+	for i, record := range schema {
+		cells[i] = db.leafCell(int64(i+1), record)
+	}
+	if db.leafCapacity(100) < db.leafSize(cells) {
+		return fmt.Errorf("too many tables for SQLite schema page")
+	}
+	db.fillLeaf(db.pages[0], 100, cells)
+
+	header := db.pages[0][:100]
+	copy(header, "SQLite format 3\x00")
+	binary.BigEndian.PutUint16(header[16:], uint16(db.pageSize))
+	header[18], header[19] = 1, 1 // legacy journal mode
+	header[21], header[22], header[23] = 64, 32, 32
+	binary.BigEndian.PutUint32(header[24:], 1) // file change counter
+	binary.BigEndian.PutUint32(header[28:], uint32(len(db.pages)))
+	binary.BigEndian.PutUint32(header[40:], 1) // schema cookie
+	binary.BigEndian.PutUint32(header[44:], 4) // schema format
+	binary.BigEndian.PutUint32(header[56:], 1) // UTF-8
+	binary.BigEndian.PutUint32(header[68:], applicationID)
+	binary.BigEndian.PutUint32(header[92:], 1)       // version-valid-for
+	binary.BigEndian.PutUint32(header[96:], 3031001) // SQLite version
+
+	for _, page := range db.pages {
+		if _, err := w.Write(page); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// allocPage appends an empty page, returning its page number.
+func (db *sqliteWriter) allocPage() uint32 {
+	db.pages = append(db.pages, make([]byte, db.pageSize))
+	return uint32(len(db.pages))
+}
+
+// writeTree writes a table b-tree whose rows get numbered from 1,
+// returning the number of its root page.
+func (db *sqliteWriter) writeTree(records [][]byte) uint32 {
+	// Fill leaf pages, then add levels of interior pages until there
+	// is a single root.
+	type child struct {
+		page   uint32
+		maxKey int64
+	}
+	var level []child
+	var cells [][]byte
+	flush := func(maxKey int64) {
+		page := db.allocPage()
+		db.fillLeaf(db.pages[page-1], 0, cells)
+		level = append(level, child{page, maxKey})
+		cells = nil
+	}
+	for i, record := range records {
+		cell := db.leafCell(int64(i+1), record)
+		if len(cells) > 0 && db.leafSize(append(cells, cell)) > db.leafCapacity(0) {
+			flush(int64(i))
+		}
+		cells = append(cells, cell)
+	}
+	if len(cells) > 0 || len(level) == 0 {
+		flush(int64(len(records)))
+	}
+
+	for len(level) > 1 {
+		var parents []child
+		for start := 0; start < len(level); {
+			// An interior page has a 12 byte header, and a 2 byte
+			// pointer plus a cell of at most 4+9 bytes per child,
+			// except for the right-most child.
+			end := start + (db.pageSize-12)/(2+4+9) + 1
+			if end > len(level) {
+				end = len(level)
+			} else if end == len(level)-1 {
+				end-- // leave no interior page with a single child
+			}
+			page := db.allocPage()
+			data := db.pages[page-1]
+			data[0] = 0x05
+			binary.BigEndian.PutUint16(data[3:], uint16(end-start-1))
+			binary.BigEndian.PutUint32(data[8:], level[end-1].page)
+			pos := db.pageSize
+			for i, c := range level[start : end-1] {
+				cell := make([]byte, 4, 4+9)
+				binary.BigEndian.PutUint32(cell, c.page)
+				cell = appendSQLiteVarint(cell, c.maxKey)
+				pos -= len(cell)
+				copy(data[pos:], cell)
+				binary.BigEndian.PutUint16(data[12+2*i:], uint16(pos))
+			}
+			binary.BigEndian.PutUint16(data[5:], uint16(pos))
+			parents = append(parents, child{page, level[end-1].maxKey})
+			start = end
+		}
+		level = parents
+	}
+	return level[0].page
+}
+
+// leafCapacity returns how many bytes are available for the header,
+// cell pointers and cells of a leaf page that starts at offset.
+func (db *sqliteWriter) leafCapacity(offset int) int {
+	return db.pageSize - offset
+}
+
+// leafSize returns how many bytes a leaf page with cells needs.
+func (db *sqliteWriter) leafSize(cells [][]byte) int {
+	size := 8
+	for _, c := range cells {
+		size += 2 + len(c)
+	}
+	return size
+}
+
+func (db *sqliteWriter) fillLeaf(page []byte, offset int, cells [][]byte) {
+	page[offset] = 0x0d
+	binary.BigEndian.PutUint16(page[offset+3:], uint16(len(cells)))
+	pos := db.pageSize
+	for i, cell := range cells {
+		pos -= len(cell)
+		copy(page[pos:], cell)
+		binary.BigEndian.PutUint16(page[offset+8+2*i:], uint16(pos))
+	}
+	binary.BigEndian.PutUint16(page[offset+5:], uint16(pos%65536))
+}
+
+// leafCell encodes a row of a table b-tree. Payload that does not fit
+// into the cell goes to a chain of overflow pages, split the way the
+// SQLite file format prescribes.
+func (db *sqliteWriter) leafCell(rowid int64, payload []byte) []byte {
+	cell := appendSQLiteVarint(nil, int64(len(payload)))
+	cell = appendSQLiteVarint(cell, rowid)
+	u := db.pageSize
+	x := u - 35
+	local := len(payload)
+	if local > x {
+		m := ((u-12)*32/255 - 23)
+		k := m + (len(payload)-m)%(u-4)
+		if k <= x {
+			local = k
+		} else {
+			local = m
+		}
+	}
+	cell = append(cell, payload[:local]...)
+	if local == len(payload) {
+		return cell
+	}
+
+	rest := payload[local:]
+	first := db.allocPage()
+	cell = append(cell, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(cell[len(cell)-4:], first)
+	for page := first; len(rest) > 0; {
+		n := len(rest)
+		if n > u-4 {
+			n = u - 4
+		}
+		copy(db.pages[page-1][4:], rest[:n])
+		rest = rest[n:]
+		if len(rest) > 0 {
+			next := db.allocPage()
+			binary.BigEndian.PutUint32(db.pages[page-1], next)
+			page = next
+		}
+	}
+	return cell
+}
+
+// encodeSQLiteRecord encodes a row in the SQLite record format.
+func encodeSQLiteRecord(values []interface{}) []byte {
+	var types, body []byte
+	for _, v := range values {
+		switch v := v.(type) {
+		case nil:
+			types = appendSQLiteVarint(types, 0)
+		case int64:
+			switch {
+			case v == 0:
+				types = appendSQLiteVarint(types, 8)
+			case v == 1:
+				types = appendSQLiteVarint(types, 9)
+			default:
+				// Serial types 1 to 6 hold integers of 1, 2, 3, 4, 6
+				// and 8 bytes.
+				sizes := []int{1, 2, 3, 4, 6, 8}
+				for i, size := range sizes {
+					if size == 8 || (v >= -(1<<uint(8*size-1)) && v < 1<<uint(8*size-1)) {
+						types = appendSQLiteVarint(types, int64(i+1))
+						var buf [8]byte
+						binary.BigEndian.PutUint64(buf[:], uint64(v))
+						body = append(body, buf[8-size:]...)
+						break
+					}
+				}
+			}
+		case string:
+			types = appendSQLiteVarint(types, int64(13+2*len(v)))
+			body = append(body, v...)
+		case []byte:
+			types = appendSQLiteVarint(types, int64(12+2*len(v)))
+			body = append(body, v...)
+		default:
+			panic("unsupported SQLite value: " + strconv.Quote(fmt.Sprint(v)))
+		}
+	}
+
+	// The header size includes its own varint.
+	headerSize := len(types) + 1
+	for len(appendSQLiteVarint(nil, int64(headerSize)))+len(types) != headerSize {
+		headerSize++
+	}
+	record := appendSQLiteVarint(make([]byte, 0, headerSize+len(body)), int64(headerSize))
+	record = append(record, types...)
+	return append(record, body...)
+}
+
+// appendSQLiteVarint appends a SQLite varint, which is big-endian with
+// 7 bits per byte, except for a ninth byte that holds 8 bits.
+func appendSQLiteVarint(buf []byte, v int64) []byte {
+	u := uint64(v)
+	if u&(0xff<<56) != 0 {
+		var b [9]byte
+		b[8] = byte(u)
+		u >>= 8
+		for i := 7; i >= 0; i-- {
+			b[i] = byte(u&0x7f) | 0x80
+			u >>= 7
+		}
+		return append(buf, b[:]...)
+	}
+	var b [8]byte
+	n := 0
+	for {
+		b[7-n] = byte(u & 0x7f)
+		if n > 0 {
+			b[7-n] |= 0x80
+		}
+		n++
+		u >>= 7
+		if u == 0 {
+			break
+		}
+	}
+	return append(buf, b[8-n:]...)
+}
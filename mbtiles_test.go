@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestWriteMBTiles(t *testing.T) {
+	// Enough large tiles for several levels of interior pages, plus
+	// tiles that need overflow pages.
+	var tiles []MBTile
+	for i := 0; i < 600; i++ {
+		key := TileKey{Zoom: 20, X: uint32(i * 1747), Y: uint32(i)}
+		data := bytes.Repeat([]byte{byte(i)}, 3000)
+		if i%100 == 7 {
+			data = bytes.Repeat([]byte(fmt.Sprint(i)), 5000)
+		}
+		tiles = append(tiles, MBTile{Key: key, Data: data})
+	}
+	tiles = append(tiles, MBTile{Key: TileKey{Zoom: 0}, Data: []byte{}})
+	metadata := MBTilesMetadata{"name": "castles", "format": "png", "bounds": "8.1,46.2,9.9,47.5"}
+
+	var buf bytes.Buffer
+	if err := WriteMBTiles(&buf, metadata, tiles); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := openSQLite(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	table, err := db.table("metadata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(MBTilesMetadata)
+	db.scanRows(table, func(rowid int64, values []interface{}) error {
+		got[values[0].(string)] = values[1].(string)
+		return nil
+	})
+	if fmt.Sprint(got) != fmt.Sprint(metadata) {
+		t.Errorf("expected metadata %v, got %v", metadata, got)
+	}
+
+	table, err = db.table("tiles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	i := 0
+	err = db.scanRows(table, func(rowid int64, values []interface{}) error {
+		if i >= len(tiles) {
+			return fmt.Errorf("too many rows")
+		}
+		key := tiles[i].Key
+		expectedRow := int64(1)<<key.Zoom - 1 - int64(key.Y)
+		data, _ := values[3].([]byte)
+		if rowid != int64(i+1) || values[0] != int64(key.Zoom) || values[1] != int64(key.X) ||
+			values[2] != expectedRow || !bytes.Equal(data, tiles[i].Data) {
+			return fmt.Errorf("row %d: expected tile %v, got %v, %v, %v and %d bytes", rowid, key, values[0], values[1], values[2], len(data))
+		}
+		i++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i != len(tiles) {
+		t.Errorf("expected %d tiles, got %d", len(tiles), i)
+	}
+}
+
+func TestEncodeSQLiteRecord(t *testing.T) {
+	values := []interface{}{nil, int64(0), int64(1), int64(-1), int64(300), int64(-70000), int64(1 << 40), int64(-1 << 62), "Zürich", []byte{1, 2}}
+	got, err := decodeSQLiteRecord(encodeSQLiteRecord(values))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(got) != fmt.Sprint(values) {
+		t.Errorf("expected %v, got %v", values, got)
+	}
+
+	for _, v := range []int64{0, 1, 127, 128, 16383, 16384, 1 << 56, -1} {
+		encoded := appendSQLiteVarint(nil, v)
+		if decoded, n := sqliteVarint(encoded, 0); decoded != v || n != len(encoded) {
+			t.Errorf("varint %d: encoded as %x, decoded as %d with %d bytes", v, encoded, decoded, n)
+		}
+	}
+}
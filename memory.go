@@ -0,0 +1,101 @@
+package main
+
+import (
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Memory accounting is approximate. Instead of inspecting the Go heap,
+// which also contains garbage and is expensive to measure, we estimate
+// the size of the data structures kept for each collection. Features
+// themselves live in a temporary file, so they do not count.
+const (
+	featureMemory  = 32 + 16 + 8 + 8 + 16 // bbox, webMercator, offset, hash, id
+	mapEntryMemory = 48                   // per entry in byID and byShortToken
+)
+
+var (
+	collectionMemory = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "miniwfs_collection_memory_bytes",
+		Help: "Approximate memory used by a collection and its caches.",
+	},
+		[]string{"collection"})
+	numReloadsRefused = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "miniwfs_reloads_refused_total",
+		Help: "Total number of reloads refused because they would exceed --maxMemory.",
+	},
+		[]string{"collection"})
+)
+
+// estimateDataMemory returns the approximate size of the in-memory
+// index of a collection, not counting caches.
+func (c *Collection) estimateDataMemory() int64 {
+	m := int64(len(c.id)) * featureMemory
+	for _, id := range c.id {
+		if len(id) > 0 {
+			m += int64(len(id)) + 2*mapEntryMemory
+		}
+	}
+	return m + c.properties.estimateMemory() + c.text.estimateMemory() + c.spatial.estimateMemory()
+}
+
+// MemoryUsage returns the approximate memory used by a collection,
+// including its tile cache, preview and other lazily computed data.
+func (c *Collection) MemoryUsage() int64 {
+	return c.dataMemory + atomic.LoadInt64(&c.cacheMemory) + c.tileCache.Bytes()
+}
+
+// addCacheMemory accounts for lazily computed data kept with the collection.
+func (c *Collection) addCacheMemory(n int64) {
+	m := atomic.AddInt64(&c.cacheMemory, n)
+	collectionMemory.WithLabelValues(c.metadata.Name).Set(float64(c.dataMemory + m + c.tileCache.Bytes()))
+}
+
+// SetMaxMemory configures the approximate memory limit for collections
+// and their caches. Reloads that would exceed it get refused, keeping
+// the previous data. Zero means unlimited.
+func (index *Index) SetMaxMemory(maxMemory int64) {
+	index.mutex.Lock()
+	defer index.mutex.Unlock()
+	index.maxMemory = maxMemory
+	if usage := index.memoryUsage(); maxMemory > 0 && usage > maxMemory {
+		loaderLog.Warn("collections already exceed --maxMemory", "memory", usage, "maxMemory", maxMemory)
+	}
+}
+
+// MemoryUsage returns the approximate memory used by all collections.
+func (index *Index) MemoryUsage() int64 {
+	index.mutex.RLock()
+	defer index.mutex.RUnlock()
+	return index.memoryUsage()
+}
+
+// memoryUsage is like MemoryUsage, but must be called with the mutex held.
+func (index *Index) memoryUsage() int64 {
+	var total int64
+	for _, c := range index.Collections {
+		total += c.MemoryUsage()
+	}
+	return total
+}
+
+// checkMemory returns MemoryExceeded if replacing the current version
+// of a collection by c would exceed the memory limit. Until the swap,
+// and while requests are still reading the current version, both
+// versions are in memory, so we count them together. Must be called
+// with the mutex held.
+func (index *Index) checkMemory(c *Collection) error {
+	if index.maxMemory <= 0 {
+		return nil
+	}
+	total := index.memoryUsage() + c.MemoryUsage()
+	if total > index.maxMemory {
+		numReloadsRefused.WithLabelValues(c.metadata.Name).Inc()
+		loaderLog.Error("refusing to reload collection because it would exceed --maxMemory; keeping previous data",
+			"collection", c.metadata.Name, "memory", total, "maxMemory", index.maxMemory)
+		return MemoryExceeded
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"testing"
+
+	promtest "github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMemoryUsage(t *testing.T) {
+	index := loadTestIndex(t)
+	defer index.Close()
+
+	before := index.MemoryUsage()
+	if before <= 0 {
+		t.Fatalf("expected positive memory usage, got %d", before)
+	}
+	if _, _, err := index.GetTile("castles", TileKey{Zoom: 0}); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := index.GetPreview("castles"); err != nil {
+		t.Fatal(err)
+	}
+	if after := index.MemoryUsage(); after <= before {
+		t.Errorf("expected cached tile and preview to be accounted, got %d bytes before and %d after", before, after)
+	}
+}
+
+func TestReplaceCollection_MaxMemory(t *testing.T) {
+	index := loadTestIndex(t)
+	defer index.Close()
+	refused := promtest.ToFloat64(numReloadsRefused.WithLabelValues("lakes"))
+
+	// While swapping, the old and the new version are both in memory.
+	same := readTestCollection(t, "lakes", `{"features":[{
+		"geometry":{"coordinates":[11.183468,47.910414],"type":"Point"},
+		"id":"N123","properties":{"natural":"lake","name":"Katzensee"},"type":"Feature"}]}`)
+	index.SetMaxMemory(index.MemoryUsage() + same.MemoryUsage() - 1)
+	if err := index.replaceCollection(same); err != MemoryExceeded {
+		t.Fatalf("expected MemoryExceeded when both versions do not fit, got %v", err)
+	}
+	same = readTestCollection(t, "lakes", `{"features":[{
+		"geometry":{"coordinates":[11.183468,47.910414],"type":"Point"},
+		"id":"N123","properties":{"natural":"lake","name":"Katzensee"},"type":"Feature"}]}`)
+	index.SetMaxMemory(index.MemoryUsage() + same.MemoryUsage() + 100)
+	if err := index.replaceCollection(same); err != nil {
+		t.Fatalf("expected reload within limit to succeed, got %v", err)
+	}
+
+	bigger := readTestCollection(t, "lakes", `{"features":[
+		{"id":"N123","properties":{"natural":"lake"},"type":"Feature"},
+		{"id":"N124","properties":{"natural":"lake"},"type":"Feature"},
+		{"id":"N125","properties":{"natural":"lake"},"type":"Feature"}]}`)
+	if err := index.replaceCollection(bigger); err != MemoryExceeded {
+		t.Fatalf("expected MemoryExceeded, got %v", err)
+	}
+	if index.Collections["lakes"] != same {
+		t.Error("expected previous collection to be kept")
+	}
+	if got := promtest.ToFloat64(numReloadsRefused.WithLabelValues("lakes")); got != refused+2 {
+		t.Errorf("expected refused reload to be counted, got %v", got-refused)
+	}
+
+	index.SetMaxMemory(0)
+	bigger = readTestCollection(t, "lakes", `{"features":[
+		{"id":"N123","properties":{"natural":"lake"},"type":"Feature"},
+		{"id":"N124","properties":{"natural":"lake"},"type":"Feature"}]}`)
+	if err := index.replaceCollection(bigger); err != nil {
+		t.Errorf("expected reload without limit to succeed, got %v", err)
+	}
+}
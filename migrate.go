@@ -0,0 +1,191 @@
+package main
+
+import (
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/paulmach/go.geojson"
+)
+
+// PropertyMigration describes how to rewrite a property of every
+// feature in a collection when the collection gets loaded, such as
+// {"op": "rename", "property": "ele", "to": "elevation"} or
+// {"op": "convert", "property": "ele", "to": "number"}.
+//
+// Migrations only change the data we serve, never the source file.
+// To revert a migration, remove it from the configuration; the
+// original data will be served again after the next load.
+type PropertyMigration struct {
+	Op       string `json:"op"`
+	Property string `json:"property"`
+	To       string `json:"to"`
+}
+
+// MigrationOps are the supported migration operations.
+var MigrationOps = []string{"rename", "convert"}
+
+// MigrationTypes are the types that properties can be converted to.
+var MigrationTypes = []string{"string", "number", "boolean"}
+
+func (m PropertyMigration) String() string {
+	return m.Op + " " + m.Property + " to " + m.To
+}
+
+// isValid returns true if the migration can be applied.
+func (m PropertyMigration) isValid() bool {
+	if len(m.Property) == 0 || len(m.To) == 0 {
+		return false
+	}
+	switch m.Op {
+	case "rename":
+		return true
+	case "convert":
+		for _, t := range MigrationTypes {
+			if m.To == t {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// SetCollectionMigrations configures how to rewrite the features of
+// collections when loading them. Collections with migrations get
+// reloaded right away, and so are collections whose migrations have
+// been removed, so the changed data is served immediately.
+func (index *Index) SetCollectionMigrations(migrations map[string][]PropertyMigration) {
+	index.mutex.Lock()
+	old := index.migrations
+	index.migrations = migrations
+	var reload []CollectionMetadata
+	for name, coll := range index.Collections {
+		if len(migrations[name]) > 0 || len(old[name]) > 0 {
+			reload = append(reload, coll.metadata)
+		}
+	}
+	index.mutex.Unlock()
+
+	for _, md := range reload {
+		md.LastModified = time.Time{}
+		index.reloadIfChanged(md)
+	}
+}
+
+func (index *Index) getMigrations(collection string) []PropertyMigration {
+	index.mutex.RLock()
+	defer index.mutex.RUnlock()
+	return index.migrations[collection]
+}
+
+// migrateFeatures applies migrations to the properties of features,
+// logging how many features have been changed by each step.
+func migrateFeatures(collection string, features []*geojson.Feature, migrations []PropertyMigration) {
+	m := makeFeatureMigrator(collection, migrations)
+	for _, f := range features {
+		m.migrate(f)
+	}
+	m.logStats()
+}
+
+// featureMigrator applies migrations to features one by one, so that
+// streamed collections can be migrated without holding all their
+// features in memory. It counts the changes for logging them once.
+type featureMigrator struct {
+	collection string
+	migrations []PropertyMigration
+	changed    []int // features changed by each migration
+	failed     []int // features that could not be converted by each migration
+}
+
+func makeFeatureMigrator(collection string, migrations []PropertyMigration) *featureMigrator {
+	return &featureMigrator{
+		collection: collection,
+		migrations: migrations,
+		changed:    make([]int, len(migrations)),
+		failed:     make([]int, len(migrations)),
+	}
+}
+
+func (m *featureMigrator) migrate(f *geojson.Feature) {
+	for i, migration := range m.migrations {
+		value, ok := f.Properties[migration.Property]
+		if !ok {
+			continue
+		}
+		switch migration.Op {
+		case "rename":
+			delete(f.Properties, migration.Property)
+			f.Properties[migration.To] = value
+			m.changed[i]++
+		case "convert":
+			if converted, ok := convertProperty(value, migration.To); ok {
+				f.Properties[migration.Property] = converted
+				m.changed[i]++
+			} else {
+				m.failed[i]++
+			}
+		}
+	}
+}
+
+// logStats logs how many features have been changed by each migration.
+func (m *featureMigrator) logStats() {
+	for i, migration := range m.migrations {
+		log.Printf("collection %s: migration %q changed %d features", m.collection, migration.String(), m.changed[i])
+		if m.failed[i] > 0 {
+			log.Printf("collection %s: migration %q could not convert %d features, keeping their values", m.collection, migration.String(), m.failed[i])
+		}
+	}
+}
+
+// convertProperty converts a JSON property value to another type.
+// Null values are kept as they are.
+func convertProperty(value interface{}, to string) (interface{}, bool) {
+	if value == nil {
+		return nil, true
+	}
+	switch to {
+	case "string":
+		switch v := value.(type) {
+		case string:
+			return v, true
+		case float64:
+			return strconv.FormatFloat(v, 'f', -1, 64), true
+		case bool:
+			return strconv.FormatBool(v), true
+		}
+
+	case "number":
+		switch v := value.(type) {
+		case float64:
+			return v, true
+		case string:
+			if n, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil {
+				return n, true
+			}
+		case bool:
+			if v {
+				return 1.0, true
+			}
+			return 0.0, true
+		}
+
+	case "boolean":
+		switch v := value.(type) {
+		case bool:
+			return v, true
+		case string:
+			switch strings.ToLower(strings.TrimSpace(v)) {
+			case "true", "yes", "1":
+				return true, true
+			case "false", "no", "0":
+				return false, true
+			}
+		case float64:
+			return v != 0, true
+		}
+	}
+	return value, false
+}
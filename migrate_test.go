@@ -0,0 +1,75 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestSetCollectionMigrations(t *testing.T) {
+	index := loadTestIndex(t)
+	defer index.Close()
+
+	index.SetCollectionMigrations(map[string][]PropertyMigration{
+		"castles": {
+			{Op: "rename", Property: "historic", To: "kind"},
+			{Op: "convert", Property: "kind", To: "boolean"}, // fails, values are kept
+		},
+	})
+	item, err := index.GetItem("castles", "N34729562")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := item.Properties["historic"]; ok {
+		t.Errorf("expected property historic to be renamed, got %v", item.Properties)
+	}
+	if kind := item.Properties["kind"]; kind != "castle" {
+		t.Errorf("expected kind=castle, got %v", kind)
+	}
+
+	// Removing the migration restores the original data.
+	index.SetCollectionMigrations(map[string][]PropertyMigration{})
+	if item, _ := index.GetItem("castles", "N34729562"); item.Properties["historic"] != "castle" {
+		t.Errorf("expected original data after removing migration, got %v", item.Properties)
+	}
+}
+
+func TestConvertProperty(t *testing.T) {
+	for _, tc := range []struct {
+		value    interface{}
+		to       string
+		expected interface{}
+		ok       bool
+	}{
+		{"12.5", "number", 12.5, true},
+		{" 7 ", "number", 7.0, true},
+		{"abc", "number", "abc", false},
+		{true, "number", 1.0, true},
+		{12.5, "string", "12.5", true},
+		{false, "string", "false", true},
+		{"yes", "boolean", true, true},
+		{"0", "boolean", false, true},
+		{"maybe", "boolean", "maybe", false},
+		{nil, "number", nil, true},
+	} {
+		got, ok := convertProperty(tc.value, tc.to)
+		if got != tc.expected || ok != tc.ok {
+			t.Errorf("convertProperty(%#v, %q): expected %#v %v, got %#v %v", tc.value, tc.to, tc.expected, tc.ok, got, ok)
+		}
+	}
+}
+
+func TestPropertyMigration_IsValid(t *testing.T) {
+	for _, tc := range []struct {
+		m        PropertyMigration
+		expected bool
+	}{
+		{PropertyMigration{Op: "rename", Property: "a", To: "b"}, true},
+		{PropertyMigration{Op: "convert", Property: "a", To: "number"}, true},
+		{PropertyMigration{Op: "convert", Property: "a", To: "date"}, false},
+		{PropertyMigration{Op: "drop", Property: "a", To: "b"}, false},
+		{PropertyMigration{Op: "rename", Property: "", To: "b"}, false},
+	} {
+		if got := tc.m.isValid(); got != tc.expected {
+			t.Errorf("%q: expected isValid()=%v, got %v", tc.m.String(), tc.expected, got)
+		}
+	}
+}
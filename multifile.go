@@ -0,0 +1,198 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/paulmach/go.geojson"
+)
+
+// Collections whose source is a directory or a glob pattern, such as
+// data/roads or data/roads/*.geojson, get merged from all matching
+// files, which may be of different formats. This is for data that
+// exporters shard into many files. Files without a registered loader,
+// such as README.md, are skipped, and so are hidden files, so that
+// partially written files like .upload-123.geojson do not get read.
+// If several files have features with the same ID, lookups by ID find
+// the last one, in the order of file names.
+
+type multiFileLoader struct{}
+
+// isMultiFileSource returns true if a local collection source is a
+// glob pattern or a directory.
+func isMultiFileSource(source string) bool {
+	if !isLocalSource(source) {
+		return false
+	}
+	if stat, err := os.Stat(source); err == nil {
+		return stat.IsDir()
+	}
+	return strings.ContainsAny(source, "*?[")
+}
+
+func (multiFileLoader) Name() string {
+	return "files"
+}
+
+// ModTime returns the latest modification time of the matching files
+// and their directories. Adding, removing or renaming a file changes
+// the modification time of its directory.
+func (multiFileLoader) ModTime(source string) (time.Time, error) {
+	files, err := sourceFiles(source)
+	if err != nil {
+		return time.Time{}, err
+	}
+	var result time.Time
+	dirs := make(map[string]bool)
+	if dir := sourceWatchDirectory(source); len(dir) > 0 {
+		dirs[dir] = true
+	}
+	for _, f := range files {
+		dirs[filepath.Dir(f)] = true
+		l, err := GetInputLoader(f)
+		if err != nil {
+			return time.Time{}, err
+		}
+		t, err := l.ModTime(f)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if t.After(result) {
+			result = t
+		}
+	}
+	for dir := range dirs {
+		if t, err := fileModTime(dir); err == nil && t.After(result) {
+			result = t
+		}
+	}
+	return result, nil
+}
+
+func (l multiFileLoader) Load(source string) (*SourceData, error) {
+	result := &SourceData{}
+	props, err := l.Stream(source, func(f *geojson.Feature) error {
+		result.Features = append(result.Features, f)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	result.Properties = props
+	return result, nil
+}
+
+// Stream passes the features of all matching files, one file after
+// the other. The collection-level properties are those of the first
+// file that has any.
+func (multiFileLoader) Stream(source string, emit func(*geojson.Feature) error) (map[string]interface{}, error) {
+	files, err := sourceFiles(source)
+	if err != nil {
+		return nil, err
+	}
+	var result map[string]interface{}
+	for _, f := range files {
+		l, err := GetInputLoader(f)
+		if err != nil {
+			return nil, err
+		}
+		var props map[string]interface{}
+		if streamer, ok := l.(StreamingInputLoader); ok {
+			props, err = streamer.Stream(f, emit)
+		} else {
+			var data *SourceData
+			if data, err = l.Load(f); err == nil {
+				props = data.Properties
+				for k, feature := range data.Features {
+					if err = emit(feature); err != nil {
+						break
+					}
+					data.Features[k] = nil
+				}
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", f, err)
+		}
+		if result == nil {
+			result = props
+		}
+	}
+	return result, nil
+}
+
+// sourceFiles returns the sorted paths of the files that make up a
+// directory or glob source.
+func sourceFiles(source string) ([]string, error) {
+	var candidates []string
+	if stat, err := os.Stat(source); err == nil && stat.IsDir() {
+		entries, err := ioutil.ReadDir(source)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			candidates = append(candidates, filepath.Join(source, e.Name()))
+		}
+	} else {
+		matches, err := filepath.Glob(source)
+		if err != nil {
+			return nil, err
+		}
+		candidates = matches
+	}
+
+	var files []string
+	for _, path := range candidates {
+		if strings.HasPrefix(filepath.Base(path), ".") {
+			continue
+		}
+		if stat, err := os.Stat(path); err != nil || stat.IsDir() {
+			continue
+		}
+		if _, err := GetInputLoader(path); err != nil {
+			continue
+		}
+		files = append(files, path)
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no files match %s", source)
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// sourceWatchDirectory returns the directory to watch for changes to a
+// local collection source. For glob patterns with wildcards in their
+// directory part, it returns the empty string; we cannot watch those,
+// and rely on periodic checks instead.
+func sourceWatchDirectory(source string) string {
+	if stat, err := os.Stat(source); err == nil && stat.IsDir() {
+		return source
+	}
+	dir := filepath.Dir(source)
+	if strings.ContainsAny(dir, "*?[") {
+		return ""
+	}
+	return dir
+}
+
+// sourceIncludesFile returns true if a file, such as one reported by
+// the file system watcher, belongs to a collection source.
+func sourceIncludesFile(source string, path string) bool {
+	if path == source {
+		return true
+	}
+	if !isMultiFileSource(source) || strings.HasPrefix(filepath.Base(path), ".") {
+		return false
+	}
+	if filepath.Dir(path) == source {
+		return true
+	}
+	matched, _ := filepath.Match(source, path)
+	return matched
+}
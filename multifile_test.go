@@ -0,0 +1,131 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeShardedCollection(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "miniwfs-shards")
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"roads-0001.geojson": `{"type":"FeatureCollection","properties":{"source":"export"},"features":[` +
+			`{"type":"Feature","id":"A","geometry":{"type":"Point","coordinates":[8.1,47.1]},"properties":{}},` +
+			`{"type":"Feature","id":"B","geometry":{"type":"Point","coordinates":[8.2,47.2]},"properties":{}}]}`,
+		"roads-0002.geojsonl": `{"type":"Feature","id":"C","geometry":{"type":"Point","coordinates":[8.3,47.3]},"properties":{}}` + "\n",
+		".upload-1.geojson":   `not yet complete`,
+		"README.md":           "# Roads\n",
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, "old.geojson"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestMultiFileLoader(t *testing.T) {
+	dir := writeShardedCollection(t)
+	defer os.RemoveAll(dir)
+
+	for source, expected := range map[string]string{
+		dir:                                      "A,B,C",
+		filepath.Join(dir, "*"):                  "A,B,C",
+		filepath.Join(dir, "roads-*.geojson"):    "A,B",
+		filepath.Join(dir, "roads-000[2]*"):      "C",
+		filepath.Join(dir, "roads-0001.geojson"): "A,B",
+	} {
+		var t0 time.Time
+		coll, err := readCollection("roads", source, t0)
+		if err != nil {
+			t.Errorf("%s: %v", source, err)
+			continue
+		}
+		if got := strings.Join(coll.id, ","); got != expected {
+			t.Errorf("%s: expected features %s, got %s", source, expected, got)
+		}
+		coll.Close()
+	}
+
+	data, err := multiFileLoader{}.Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data.Properties["source"] != "export" {
+		t.Errorf("expected collection properties of first file, got %v", data.Properties)
+	}
+
+	if _, err := readCollection("roads", filepath.Join(dir, "*.csv"), time.Time{}); err == nil ||
+		!strings.Contains(err.Error(), "no files match") {
+		t.Errorf("expected error for glob without matches, got %v", err)
+	}
+}
+
+func TestMultiFileLoader_ModTime(t *testing.T) {
+	dir := writeShardedCollection(t)
+	defer os.RemoveAll(dir)
+
+	old := time.Now().Add(-time.Hour)
+	for _, name := range []string{".", "roads-0001.geojson", "roads-0002.geojsonl"} {
+		os.Chtimes(filepath.Join(dir, name), old, old)
+	}
+	source := filepath.Join(dir, "*.geojson*")
+	before, err := multiFileLoader{}.ModTime(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Removing a file must trigger a reload, although no remaining
+	// file has changed.
+	if err := os.Remove(filepath.Join(dir, "roads-0002.geojsonl")); err != nil {
+		t.Fatal(err)
+	}
+	after, err := multiFileLoader{}.ModTime(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !after.After(before) {
+		t.Errorf("expected modification time to advance after removing a file, got %v then %v", before, after)
+	}
+}
+
+func TestSourceIncludesFile(t *testing.T) {
+	dir := writeShardedCollection(t)
+	defer os.RemoveAll(dir)
+
+	glob := filepath.Join(dir, "*.geojson")
+	for _, tc := range []struct {
+		source, path string
+		expected     bool
+	}{
+		{dir, filepath.Join(dir, "roads-0003.geojson"), true},
+		{dir, filepath.Join(dir, ".upload-2.geojson"), false},
+		{glob, filepath.Join(dir, "roads-0003.geojson"), true},
+		{glob, filepath.Join(dir, "roads-0003.csv"), false},
+		{"/data/lakes.geojson", "/data/lakes.geojson", true},
+		{"/data/lakes.geojson", "/data/rivers.geojson", false},
+	} {
+		if got := sourceIncludesFile(tc.source, tc.path); got != tc.expected {
+			t.Errorf("sourceIncludesFile(%q, %q): expected %v, got %v", tc.source, tc.path, tc.expected, got)
+		}
+	}
+
+	if got := sourceWatchDirectory(glob); got != dir {
+		t.Errorf("expected to watch %s, got %s", dir, got)
+	}
+	if got := sourceWatchDirectory(dir); got != dir {
+		t.Errorf("expected to watch %s, got %s", dir, got)
+	}
+	if got := sourceWatchDirectory("/data/*/roads.geojson"); got != "" {
+		t.Errorf("expected no directory to watch, got %s", got)
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// NATSClient is a minimal client for the NATS text protocol, just
+// enough to publish change events and to subscribe to them, so that
+// several replicas can coordinate their reloads without polling.
+// If the connection breaks, the client reconnects and re-subscribes.
+// https://docs.nats.io/reference/reference-protocols/nats-protocol
+type NATSClient struct {
+	address string
+
+	mutex         sync.Mutex
+	conn          net.Conn
+	writer        *bufio.Writer
+	subscriptions map[string]func([]byte) // subscription ID -> handler
+	subjects      map[string]string       // subscription ID -> subject
+	nextID        int
+	closed        bool
+}
+
+var natsClosed = errors.New("NATS connection closed")
+
+// DialNATS connects to a NATS server, given an address such as
+// "nats://localhost:4222".
+func DialNATS(address string) (*NATSClient, error) {
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "nats" {
+		return nil, fmt.Errorf("unsupported NATS address: %s", address)
+	}
+
+	host := u.Host
+	if len(u.Port()) == 0 {
+		host = net.JoinHostPort(u.Hostname(), "4222")
+	}
+
+	c := &NATSClient{
+		address:       host,
+		subscriptions: make(map[string]func([]byte)),
+		subjects:      make(map[string]string),
+	}
+	conn, err := c.connect()
+	if err != nil {
+		return nil, err
+	}
+	go c.readLoop(conn)
+	return c, nil
+}
+
+func (c *NATSClient) connect() (net.Conn, error) {
+	conn, err := net.DialTimeout("tcp", c.address, 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.conn = conn
+	c.writer = bufio.NewWriter(conn)
+	c.writer.WriteString(`CONNECT {"verbose":false,"pedantic":false,"name":"miniwfs"}` + "\r\n")
+	for sid, subject := range c.subjects {
+		fmt.Fprintf(c.writer, "SUB %s %s\r\n", subject, sid)
+	}
+	if err := c.writer.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+func (c *NATSClient) Close() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.closed = true
+	if c.conn != nil {
+		c.conn.Close()
+	}
+}
+
+func (c *NATSClient) Publish(subject string, payload []byte) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.closed {
+		return natsClosed
+	}
+	fmt.Fprintf(c.writer, "PUB %s %d\r\n", subject, len(payload))
+	c.writer.Write(payload)
+	c.writer.WriteString("\r\n")
+	return c.writer.Flush()
+}
+
+func (c *NATSClient) Subscribe(subject string, handler func([]byte)) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.closed {
+		return natsClosed
+	}
+	c.nextID++
+	sid := strconv.Itoa(c.nextID)
+	c.subscriptions[sid] = handler
+	c.subjects[sid] = subject
+	fmt.Fprintf(c.writer, "SUB %s %s\r\n", subject, sid)
+	return c.writer.Flush()
+}
+
+// PublishChanges returns a change listener that publishes change
+// events as JSON to a NATS subject.
+func (c *NATSClient) PublishChanges(subject string) func(ChangeEvent) {
+	return func(event ChangeEvent) {
+		payload, err := json.Marshal(event)
+		if err != nil {
+			log.Printf("json.Marshal failed: %v", err)
+			return
+		}
+		if err := c.Publish(subject, payload); err != nil {
+			log.Printf("publishing to NATS subject %s failed: %v", subject, err)
+		}
+	}
+}
+
+func (c *NATSClient) readLoop(conn net.Conn) {
+	backoff := time.Second
+	for {
+		err := c.read(conn)
+		c.mutex.Lock()
+		closed := c.closed
+		c.mutex.Unlock()
+		if closed {
+			return
+		}
+
+		log.Printf("NATS connection to %s broken: %v", c.address, err)
+		for {
+			time.Sleep(backoff)
+			if backoff < time.Minute {
+				backoff *= 2
+			}
+			if conn, err = c.connect(); err == nil {
+				backoff = time.Second
+				break
+			}
+			log.Printf("reconnecting to NATS at %s failed: %v", c.address, err)
+		}
+	}
+}
+
+func (c *NATSClient) read(conn net.Conn) error {
+	r := bufio.NewReader(conn)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		op := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch op {
+		case "PING":
+			c.mutex.Lock()
+			c.writer.WriteString("PONG\r\n")
+			err = c.writer.Flush()
+			c.mutex.Unlock()
+			if err != nil {
+				return err
+			}
+
+		case "MSG":
+			// MSG <subject> <sid> [reply-to] <#bytes>
+			args := strings.Fields(line)
+			if len(args) < 4 {
+				return fmt.Errorf("malformed NATS message: %s", line)
+			}
+			size, err := strconv.Atoi(args[len(args)-1])
+			if err != nil {
+				return err
+			}
+			payload := make([]byte, size+2) // 2 = len("\r\n")
+			if _, err := io.ReadFull(r, payload); err != nil {
+				return err
+			}
+			c.mutex.Lock()
+			handler := c.subscriptions[args[2]]
+			c.mutex.Unlock()
+			if handler != nil {
+				handler(payload[:size])
+			}
+
+		case "-ERR":
+			log.Printf("NATS error: %s", line)
+		}
+	}
+}
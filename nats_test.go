@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeNATSServer accepts one connection, echoes each published message
+// to subscription "1", and reports the protocol lines it has received.
+func fakeNATSServer(t *testing.T) (string, chan string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := make(chan string, 10)
+	go func() {
+		defer listener.Close()
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		conn.Write([]byte("PING\r\n"))
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimRight(line, "\r\n")
+			lines <- line
+			if strings.HasPrefix(line, "PUB ") {
+				payload, _ := r.ReadString('\n')
+				args := strings.Fields(line)
+				conn.Write([]byte("MSG " + args[1] + " 1 " + args[2] + "\r\n" + payload))
+			}
+		}
+	}()
+	return "nats://" + listener.Addr().String(), lines
+}
+
+func TestNATSClient(t *testing.T) {
+	address, lines := fakeNATSServer(t)
+	c, err := DialNATS(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	received := make(chan string, 1)
+	if err := c.Subscribe("miniwfs.changes", func(p []byte) { received <- string(p) }); err != nil {
+		t.Fatal(err)
+	}
+	c.PublishChanges("miniwfs.changes")(ChangeEvent{Collection: "castles"})
+
+	select {
+	case msg := <-received:
+		if !strings.Contains(msg, `"collection":"castles"`) {
+			t.Errorf("expected change event for castles, got %s", msg)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message received")
+	}
+
+	var got []string
+	for len(got) < 4 {
+		select {
+		case line := <-lines:
+			got = append(got, strings.SplitN(line, " ", 2)[0])
+		case <-time.After(5 * time.Second):
+			t.Fatalf("expected 4 protocol operations, got %v", got)
+		}
+	}
+	sort.Strings(got)
+	if expected := "CONNECT,PONG,PUB,SUB"; strings.Join(got, ",") != expected {
+		t.Errorf("expected protocol operations %s, got %v", expected, got)
+	}
+}
+
+func TestDialNATS_BadAddress(t *testing.T) {
+	if _, err := DialNATS("kafka://localhost:9092"); err == nil {
+		t.Error("expected error for non-NATS address")
+	}
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/geo/r2"
+	"github.com/golang/geo/s2"
+)
+
+// Offline packages, for survey crews heading into areas without
+// connectivity. GET /collections/{name}/offline?bbox=...&maxZoom=...
+// returns a zip archive with the tiles of the area as MBTiles database,
+// which offline map apps can display, and the features of the area as
+// GeoJSON. Like in exports, tiles without any features are left out.
+// Packages are expensive to build, so their size is capped and each
+// client may only fetch one package per offlineInterval.
+
+var offlineRegexp = regexp.MustCompile(`^/collections/([^/]+)/offline$`)
+
+const (
+	// MaxOfflineTiles is the maximal number of tiles in a package.
+	MaxOfflineTiles = 20000
+
+	// MaxOfflineZoom is the highest zoom level clients may request.
+	MaxOfflineZoom = 18
+
+	defaultOfflineZoom = 14
+	maxOfflineBytes    = 128 << 20 // uncompressed size of tiles and features
+	offlineInterval    = time.Minute
+	maxOfflineBuilds   = 2 // packages being built at the same time
+)
+
+func (s *WebServer) handleOfflineRequest(w http.ResponseWriter, req *http.Request, collection string) {
+	if req.Method != "GET" && req.Method != "HEAD" {
+		w.Header().Set("Allow", "GET, HEAD")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.authorize(w, req, collection) {
+		return
+	}
+
+	params := req.URL.Query()
+	if len(strings.TrimSpace(params.Get("bbox"))) == 0 {
+		replyOfflineError(w, http.StatusBadRequest, "missing bbox; pass something like ?bbox=8.4,47.3,8.6,47.4")
+		return
+	}
+	bbox, err := parseBbox(params.Get("bbox"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	maxZoom := defaultOfflineZoom
+	if z := params.Get("maxZoom"); len(z) > 0 {
+		if maxZoom, err = strconv.Atoi(z); err != nil || maxZoom < 0 || maxZoom > MaxOfflineZoom {
+			replyOfflineError(w, http.StatusBadRequest, fmt.Sprintf("maxZoom must be between 0 and %d", MaxOfflineZoom))
+			return
+		}
+	}
+
+	numFeatures, points, err := s.index.getOfflineData(collection, bbox)
+	if status := getHTTPStatus(err); status != http.StatusOK {
+		w.WriteHeader(status)
+		return
+	}
+	if numFeatures > MaxLimit {
+		replyOfflineError(w, http.StatusBadRequest,
+			fmt.Sprintf("area has %d features, but packages are limited to %d; pass a smaller bbox", numFeatures, MaxLimit))
+		return
+	}
+	keys := getOfflineTiles(points, bbox, maxZoom)
+	if len(keys) > MaxOfflineTiles {
+		replyOfflineError(w, http.StatusBadRequest,
+			fmt.Sprintf("area has more than %d tiles; pass a smaller bbox or maxZoom", MaxOfflineTiles))
+		return
+	}
+
+	client := s.access.GetAPIKey(req)
+	if len(client) == 0 {
+		client = getClientIP(req).String()
+	}
+	if retry, ok := s.offline.acquire(client, time.Now()); !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(retry.Seconds()+0.5)))
+		replyOfflineError(w, http.StatusTooManyRequests, "too many offline packages; try again later")
+		return
+	}
+	defer s.offline.release()
+
+	tiles := make([]MBTile, 0, len(keys))
+	size := 0
+	var metadata CollectionMetadata
+	for _, key := range keys {
+		var tile []byte
+		tile, metadata, err = s.index.GetTile(collection, key)
+		if status := getHTTPStatus(err); status != http.StatusOK {
+			w.WriteHeader(status)
+			return
+		}
+		tiles = append(tiles, MBTile{Key: key, Data: tile})
+		size += len(tile)
+	}
+
+	var features bytes.Buffer
+	var noTime time.Time
+	includeDeleted := false
+	metadata, _, err = s.index.GetItems(collection,
+		itemsQuery{itemsSelection: itemsSelection{bbox: bbox}, limit: MaxLimit},
+		noTime, noTime, "", includeDeleted, &features)
+	if status := getHTTPStatus(err); status != http.StatusOK {
+		w.WriteHeader(status)
+		return
+	}
+	if size+features.Len() > maxOfflineBytes {
+		replyOfflineError(w, http.StatusBadRequest, "package would be too large; pass a smaller bbox or maxZoom")
+		return
+	}
+
+	lo, hi := bbox.Lo(), bbox.Hi()
+	info := MBTilesMetadata{
+		"name":    collection,
+		"format":  "png",
+		"type":    "overlay",
+		"version": "1",
+		"minzoom": "0",
+		"maxzoom": strconv.Itoa(maxZoom),
+		"bounds": fmt.Sprintf("%s,%s,%s,%s", formatDegrees(lo.Lng.Degrees()), formatDegrees(lo.Lat.Degrees()),
+			formatDegrees(hi.Lng.Degrees()), formatDegrees(hi.Lat.Degrees())),
+	}
+	if attribution := s.index.GetAttribution(collection).Attribution; len(attribution) > 0 {
+		info["attribution"] = attribution
+	}
+
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	err = writeOfflineFile(archive, collection+".mbtiles", metadata.LastModified, func(w io.Writer) error {
+		return WriteMBTiles(w, info, tiles)
+	})
+	if err == nil {
+		err = writeOfflineFile(archive, collection+".geojson", metadata.LastModified, func(w io.Writer) error {
+			_, err := features.WriteTo(w)
+			return err
+		})
+	}
+	if err == nil {
+		err = archive.Close()
+	}
+	if err != nil {
+		httpLog.Error("building offline package failed", "collection", collection, "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	header := w.Header()
+	header.Set("Access-Control-Allow-Origin", "*")
+	header.Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", collection+".zip"))
+	header.Set("Content-Length", strconv.Itoa(buf.Len()))
+	header.Set("Content-Type", "application/zip")
+	header.Set("Last-Modified", metadata.LastModified.UTC().Format(http.TimeFormat))
+	s.setLicenseLink(header, collection)
+	s.usage.Record(time.Now(), collection, "offline", bboxSizeBucket(bbox))
+	w.WriteHeader(http.StatusOK)
+	buf.WriteTo(w)
+}
+
+func writeOfflineFile(archive *zip.Writer, name string, modified time.Time, write func(io.Writer) error) error {
+	w, err := archive.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: modified})
+	if err != nil {
+		return err
+	}
+	return write(w)
+}
+
+func replyOfflineError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(status)
+	io.WriteString(w, msg+"\n")
+}
+
+func formatDegrees(d float64) string {
+	return strconv.FormatFloat(d, 'f', -1, 64)
+}
+
+// getOfflineData returns the number of features intersecting bbox,
+// and the web mercator points of those whose point is inside it.
+func (index *Index) getOfflineData(collection string, bbox s2.Rect) (int, []r2.Point, error) {
+	index.mutex.RLock()
+	defer index.mutex.RUnlock()
+
+	coll := index.Collections[collection]
+	if coll == nil {
+		return 0, nil, NotFound
+	}
+	n := 0
+	var points []r2.Point
+	for i := 0; i < coll.numFeatures(); i++ {
+		if coll.featureBounds(i).Intersects(bbox) {
+			n++
+			points = append(points, coll.featurePoint(i))
+		}
+	}
+	return n, points, nil
+}
+
+// getOfflineTiles returns the keys of the non-empty tiles within bbox,
+// up to maxZoom, sorted by zoom level. We stop early once there are
+// more than MaxOfflineTiles.
+func getOfflineTiles(points []r2.Point, bbox s2.Rect, maxZoom int) []TileKey {
+	var result []TileKey
+	for zoom := 0; zoom <= maxZoom && len(result) <= MaxOfflineTiles; zoom++ {
+		start := len(result)
+		for key := range getExportTiles(points, zoom) {
+			if key.Bounds().Intersects(bbox) {
+				result = append(result, key)
+			}
+		}
+		level := result[start:]
+		sort.Slice(level, func(i, j int) bool {
+			return level[i].X < level[j].X || (level[i].X == level[j].X && level[i].Y < level[j].Y)
+		})
+	}
+	return result
+}
+
+// offlineLimiter rate-limits the building of offline packages, both
+// per client and overall.
+type offlineLimiter struct {
+	mutex   sync.Mutex
+	last    map[string]time.Time // client -> when its last package was started
+	running int
+}
+
+// acquire returns true if a client may build a package now. Otherwise,
+// it tells how long the client should wait. Callers that got true must
+// call release when done.
+func (l *offlineLimiter) acquire(client string, now time.Time) (time.Duration, bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	for c, t := range l.last {
+		if now.Sub(t) >= offlineInterval {
+			delete(l.last, c)
+		}
+	}
+	if t, ok := l.last[client]; ok {
+		return offlineInterval - now.Sub(t), false
+	}
+	if l.running >= maxOfflineBuilds {
+		return time.Second, false
+	}
+	if l.last == nil {
+		l.last = make(map[string]time.Time)
+	}
+	l.last[client] = now
+	l.running++
+	return 0, true
+}
+
+func (l *offlineLimiter) release() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.running--
+}
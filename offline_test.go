@@ -0,0 +1,136 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestOffline(t *testing.T) {
+	index, s := makeServer(t)
+	defer s.Shutdown()
+	defer index.Close()
+
+	url := "/collections/castles/offline?bbox=11.0,47.8,11.3,48.0&maxZoom=10"
+	req, _ := http.NewRequest("GET", url, nil)
+	resp := httptest.NewRecorder()
+	http.HandlerFunc(s.HandleRequest).ServeHTTP(resp, req)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, resp.Code)
+	}
+	if ct := resp.Header().Get("Content-Type"); ct != "application/zip" {
+		t.Errorf("expected Content-Type application/zip, got %s", ct)
+	}
+	if cd := resp.Header().Get("Content-Disposition"); cd != `attachment; filename="castles.zip"` {
+		t.Errorf("unexpected Content-Disposition: %s", cd)
+	}
+
+	body := resp.Body.Bytes()
+	archive, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string][]byte)
+	for _, f := range archive.File {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[f.Name], _ = ioutil.ReadAll(r)
+		r.Close()
+	}
+
+	db, err := openSQLite(bytes.NewReader(files["castles.mbtiles"]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	table, err := db.table("tiles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Like exports, packages contain the tiles around features, so
+	// that icons at tile edges do not get cut off.
+	var zooms []int64
+	db.scanRows(table, func(rowid int64, values []interface{}) error {
+		zooms = append(zooms, values[0].(int64))
+		return nil
+	})
+	for i, z := range zooms {
+		if (i == 0 && z != 0) || (i > 0 && z != zooms[i-1] && z != zooms[i-1]+1) {
+			t.Fatalf("expected tiles for each zoom level, got %v", zooms)
+		}
+	}
+	if len(zooms) == 0 || zooms[len(zooms)-1] != 10 {
+		t.Errorf("expected tiles up to zoom level 10, got %v", zooms)
+	}
+
+	var features struct {
+		Features []struct {
+			ID string `json:"id"`
+		} `json:"features"`
+	}
+	if err := json.Unmarshal(files["castles.geojson"], &features); err != nil {
+		t.Fatal(err)
+	}
+	if len(features.Features) != 1 || features.Features[0].ID != "N34729562" {
+		t.Errorf("expected feature N34729562, got %+v", features.Features)
+	}
+
+	// Each client may only fetch one package per minute.
+	resp = httptest.NewRecorder()
+	http.HandlerFunc(s.HandleRequest).ServeHTTP(resp, req)
+	if resp.Code != http.StatusTooManyRequests || len(resp.Header().Get("Retry-After")) == 0 {
+		t.Errorf("expected status %d with Retry-After, got %d", http.StatusTooManyRequests, resp.Code)
+	}
+}
+
+func TestOffline_Errors(t *testing.T) {
+	index, s := makeServer(t)
+	defer s.Shutdown()
+	defer index.Close()
+
+	for _, tc := range []struct {
+		method, url string
+		status      int
+	}{
+		{"GET", "/collections/castles/offline", http.StatusBadRequest},
+		{"GET", "/collections/castles/offline?bbox=foo", http.StatusBadRequest},
+		{"GET", "/collections/castles/offline?bbox=11,47,12,48&maxZoom=19", http.StatusBadRequest},
+		{"GET", "/collections/castles/offline?bbox=11,47,12,48&maxZoom=x", http.StatusBadRequest},
+		{"GET", "/collections/nosuchcollection/offline?bbox=11,47,12,48", http.StatusNotFound},
+		{"POST", "/collections/castles/offline?bbox=11,47,12,48", http.StatusMethodNotAllowed},
+	} {
+		req, _ := http.NewRequest(tc.method, tc.url, nil)
+		resp := httptest.NewRecorder()
+		http.HandlerFunc(s.HandleRequest).ServeHTTP(resp, req)
+		if resp.Code != tc.status {
+			t.Errorf("%s %s: expected status %d, got %d", tc.method, tc.url, tc.status, resp.Code)
+		}
+	}
+}
+
+func TestOfflineLimiter(t *testing.T) {
+	var l offlineLimiter
+	now := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+	if _, ok := l.acquire("alice", now); !ok {
+		t.Fatal("expected first package of alice to be allowed")
+	}
+	l.release()
+	if retry, ok := l.acquire("alice", now.Add(20*time.Second)); ok || retry != 40*time.Second {
+		t.Errorf("expected alice to retry in 40s, got %v, %v", retry, ok)
+	}
+	if _, ok := l.acquire("alice", now.Add(offlineInterval)); !ok {
+		t.Error("expected alice to be allowed again after a minute")
+	}
+	if _, ok := l.acquire("bob", now.Add(offlineInterval)); !ok {
+		t.Error("expected bob to be allowed")
+	}
+	if _, ok := l.acquire("carol", now.Add(offlineInterval)); ok {
+		t.Errorf("expected carol to wait while %d packages are being built", maxOfflineBuilds)
+	}
+}
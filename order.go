@@ -0,0 +1,114 @@
+package main
+
+import (
+	"sort"
+
+	"github.com/golang/geo/s2"
+	"github.com/paulmach/go.geojson"
+)
+
+// Item orders. By default, items are served in the order of the source
+// file, which may change whenever a data pipeline re-creates the file.
+// The other orders are canonical: reloading identical data, no matter
+// how it is ordered in the source, gives the same paging order.
+const (
+	ItemOrderSource  = "source"
+	ItemOrderID      = "id"
+	ItemOrderHilbert = "hilbert" // along a Hilbert curve, so neighbors tend to be on the same page
+)
+
+// ItemOrders are the supported item orders.
+var ItemOrders = []string{ItemOrderSource, ItemOrderID, ItemOrderHilbert}
+
+func isValidItemOrder(order string) bool {
+	for _, o := range ItemOrders {
+		if order == o {
+			return true
+		}
+	}
+	return false
+}
+
+// SetItemOrder configures the order in which items get served. If the
+// order changes, all collections get reloaded right away.
+func (index *Index) SetItemOrder(order string) {
+	order = normalizeItemOrder(order)
+	index.mutex.Lock()
+	changed := index.itemOrder != order
+	index.itemOrder = order
+	index.mutex.Unlock()
+
+	if changed {
+		index.reloadCollections(func(name string) bool { return true })
+	}
+}
+
+// normalizeItemOrder returns how we store an item order internally,
+// where the empty string stands for the order of the source files.
+func normalizeItemOrder(order string) string {
+	if order == ItemOrderSource {
+		return ""
+	}
+	return order
+}
+
+// GetItemOrder returns the order in which items get served.
+func (index *Index) GetItemOrder() string {
+	index.mutex.RLock()
+	defer index.mutex.RUnlock()
+	if len(index.itemOrder) == 0 {
+		return ItemOrderSource
+	}
+	return index.itemOrder
+}
+
+// sortFeatures sorts features into an item order. Features with equal
+// keys are sorted by ID, so the order only depends on the data. For
+// the Hilbert order, the key is the s2 cell of a feature's bounding box
+// center; s2 numbers its cells along a Hilbert curve.
+func sortFeatures(features []*geojson.Feature, order string) {
+	switch order {
+	case ItemOrderID:
+		ids := make([]string, len(features))
+		for i, f := range features {
+			ids[i] = getIDString(f.ID)
+		}
+		sort.Stable(featureSorter{features, ids, nil})
+
+	case ItemOrderHilbert:
+		ids := make([]string, len(features))
+		cells := make([]s2.CellID, len(features))
+		for i, f := range features {
+			ids[i] = getIDString(f.ID)
+			if bounds := computeBounds(f.Geometry); !bounds.IsEmpty() {
+				cells[i] = s2.CellIDFromLatLng(bounds.Center())
+			}
+		}
+		sort.Stable(featureSorter{features, ids, cells})
+	}
+}
+
+type featureSorter struct {
+	features []*geojson.Feature
+	ids      []string
+	cells    []s2.CellID // nil when sorting by ID only
+}
+
+func (s featureSorter) Len() int {
+	return len(s.features)
+}
+
+func (s featureSorter) Less(i, j int) bool {
+	if s.cells != nil && s.cells[i] != s.cells[j] {
+		return s.cells[i] < s.cells[j]
+	}
+	return s.ids[i] < s.ids[j]
+}
+
+func (s featureSorter) Swap(i, j int) {
+	s.features[i], s.features[j] = s.features[j], s.features[i]
+	s.ids[i], s.ids[j] = s.ids[j], s.ids[i]
+	if s.cells != nil {
+		s.cells[i], s.cells[j] = s.cells[j], s.cells[i]
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/paulmach/go.geojson"
+)
+
+func featureIDs(features []*geojson.Feature) string {
+	ids := make([]string, len(features))
+	for i, f := range features {
+		ids[i] = getIDString(f.ID)
+	}
+	return strings.Join(ids, " ")
+}
+
+func TestSortFeatures(t *testing.T) {
+	makeFeatures := func(ids ...string) []*geojson.Feature {
+		coords := map[string][]float64{
+			"a": {8.5, 47.3}, "b": {-70.6, -33.4}, "c": {8.5001, 47.3001}, "d": {139.7, 35.7}, "e": {8.5, 47.3},
+		}
+		result := make([]*geojson.Feature, len(ids))
+		for i, id := range ids {
+			result[i] = geojson.NewPointFeature(coords[id])
+			result[i].ID = id
+		}
+		return result
+	}
+
+	for _, order := range []string{ItemOrderID, ItemOrderHilbert} {
+		first := makeFeatures("d", "a", "e", "c", "b")
+		second := makeFeatures("b", "c", "e", "a", "d")
+		sortFeatures(first, order)
+		sortFeatures(second, order)
+		if featureIDs(first) != featureIDs(second) {
+			t.Errorf("order %s: expected same order for same data, got %q and %q",
+				order, featureIDs(first), featureIDs(second))
+		}
+	}
+
+	features := makeFeatures("d", "a", "e", "c", "b")
+	sortFeatures(features, ItemOrderID)
+	if got := featureIDs(features); got != "a b c d e" {
+		t.Errorf("expected order by ID, got %q", got)
+	}
+
+	// Features at the same location get ordered by ID, and nearby
+	// features stay close together along the Hilbert curve.
+	features = makeFeatures("d", "e", "b", "c", "a")
+	sortFeatures(features, ItemOrderHilbert)
+	if got := featureIDs(features); !strings.Contains(got, "a e c") {
+		t.Errorf("expected a, e and c to be neighbors in Hilbert order, got %q", got)
+	}
+
+	features = makeFeatures("d", "a", "e", "c", "b")
+	sortFeatures(features, "")
+	if got := featureIDs(features); got != "d a e c b" {
+		t.Errorf("expected source order to be kept, got %q", got)
+	}
+}
+
+func TestIndex_SetItemOrder(t *testing.T) {
+	index, s := makeServer(t)
+	defer s.Shutdown()
+	defer index.Close()
+
+	getIDs := func() string {
+		req, _ := http.NewRequest("GET", "/collections/castles/items", nil)
+		resp := httptest.NewRecorder()
+		http.HandlerFunc(s.HandleRequest).ServeHTTP(resp, req)
+		var got WFSFeatureCollection
+		if err := json.Unmarshal(resp.Body.Bytes(), &got); err != nil {
+			t.Fatal(err)
+		}
+		ids := make([]string, len(got.Features))
+		for i, f := range got.Features {
+			ids[i] = f.ID.(string)
+		}
+		return strings.Join(ids, " ")
+	}
+
+	if got := getIDs(); got != "N34729562 W418392510 W24785843" {
+		t.Errorf("expected source order, got %q", got)
+	}
+	index.SetItemOrder(ItemOrderID)
+	if got := getIDs(); got != "N34729562 W24785843 W418392510" {
+		t.Errorf("expected order by ID, got %q", got)
+	}
+	if got := index.GetItemOrder(); got != ItemOrderID {
+		t.Errorf("expected GetItemOrder to return %q, got %q", ItemOrderID, got)
+	}
+	index.SetItemOrder(ItemOrderSource)
+	if got := getIDs(); got != "N34729562 W418392510 W24785843" {
+		t.Errorf("expected source order after reset, got %q", got)
+	}
+}
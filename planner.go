@@ -0,0 +1,336 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/golang/geo/s2"
+)
+
+// Query planning. Without help, GetItems scans every feature of a
+// collection and checks its bounding box and properties. For selective
+// queries, such as a tiny bbox or a filter on a rare property value,
+// it is cheaper to only look at the candidates found in an index. The
+// planner estimates how many candidates each index would yield and
+// picks the smallest, unless that is not much smaller than a scan.
+
+// Query strategies, as reported in QueryPlan.Strategy.
+const (
+	planScan     = "scan"     // look at every feature
+	planSpatial  = "spatial"  // features in grid cells overlapping the bbox
+	planProperty = "property" // features with the filtered property value
+	planIDs      = "ids"      // features with the requested IDs
+	planText     = "text"     // features matching the full-text query, by relevance
+)
+
+// PlanHeader is the response header telling how an items request with
+// ?debug=1 was executed.
+const PlanHeader = "X-MiniWFS-Plan"
+
+// planScanFraction is how selective an index must be to be used: if it
+// yields more than this fraction of all features, we scan instead,
+// since sequential access is cheaper than collecting candidates.
+const planScanFraction = 0.5
+
+// QueryPlan tells how GetItems finds the features of a query.
+type QueryPlan struct {
+	Strategy   string         `json:"strategy"`
+	Property   string         `json:"property,omitempty"` // for planProperty
+	Candidates int            `json:"candidates"`         // features to be examined
+	Estimates  map[string]int `json:"estimates"`          // candidates of every applicable strategy
+
+	features []int // candidates in collection order, nil for scans
+	ranked   bool  // true if features are ordered by relevance
+}
+
+// String formats the plan for PlanHeader, such as
+// "property=historic candidates=12 scan=1000 spatial=40 property=12".
+func (p *QueryPlan) String() string {
+	s := p.Strategy
+	if len(p.Property) > 0 {
+		s += "=" + p.Property
+	}
+	s += fmt.Sprintf(" candidates=%d", p.Candidates)
+	strategies := make([]string, 0, len(p.Estimates))
+	for strategy := range p.Estimates {
+		strategies = append(strategies, strategy)
+	}
+	sort.Strings(strategies)
+	for _, strategy := range strategies {
+		s += fmt.Sprintf(" %s=%d", strategy, p.Estimates[strategy])
+	}
+	return s
+}
+
+// planQuery decides how to find the features matching a query. Must be
+// called with the index mutex held.
+func (c *Collection) planQuery(bbox s2.Rect, filter PropertyFilter, ids []string, query string) *QueryPlan {
+	n := c.numFeatures()
+	plan := &QueryPlan{Strategy: planScan, Candidates: n, Estimates: map[string]int{planScan: n}}
+
+	// Full-text results must come in order of relevance, so there is
+	// no choice when searching.
+	if len(query) > 0 {
+		plan.Strategy, plan.ranked = planText, true
+		plan.features = c.text.search(query)
+		plan.Candidates = len(plan.features)
+		plan.Estimates[planText] = plan.Candidates
+		return plan
+	}
+
+	best, bestProperty, bestEstimate := planScan, "", n
+	consider := func(strategy string, property string, estimate int) {
+		plan.Estimates[strategy] = estimate
+		if estimate < bestEstimate && float64(estimate) <= float64(n)*planScanFraction {
+			best, bestProperty, bestEstimate = strategy, property, estimate
+		}
+	}
+
+	if len(ids) > 0 {
+		consider(planIDs, "", len(ids))
+	}
+	for key, value := range filter {
+		estimate := len(c.properties[key][value])
+		if e, ok := plan.Estimates[planProperty]; !ok || estimate < e {
+			consider(planProperty, key, estimate)
+		}
+	}
+	if c.spatial != nil && !bbox.IsFull() {
+		if estimate, ok := c.spatial.estimate(bbox); ok {
+			consider(planSpatial, "", estimate)
+		}
+	}
+
+	plan.Strategy, plan.Property = best, bestProperty
+	switch best {
+	case planIDs:
+		for _, id := range ids {
+			if i, ok := c.lookupID(id); ok {
+				plan.features = append(plan.features, i)
+			}
+		}
+		plan.features = sortedUnique(plan.features)
+	case planProperty:
+		plan.features = c.properties[bestProperty][filter[bestProperty]]
+	case planSpatial:
+		plan.features = c.spatial.search(bbox)
+	}
+	if best != planScan {
+		plan.Candidates = len(plan.features)
+		if plan.features == nil {
+			plan.features = []int{}
+		}
+	}
+	return plan
+}
+
+// PlanQuery returns the plan that GetItems would use for a query,
+// without executing it.
+func (index *Index) PlanQuery(collection string, bbox s2.Rect, filter PropertyFilter, ids []string, query string) (QueryPlan, error) {
+	index.mutex.RLock()
+	defer index.mutex.RUnlock()
+
+	coll := index.Collections[collection]
+	if coll == nil {
+		return QueryPlan{}, NotFound
+	}
+	return *coll.planQuery(bbox, filter, ids, query), nil
+}
+
+// spatialIndex buckets features into a grid over the collection's
+// extent. Features that would occupy too many cells, or that cross
+// the antimeridian, go into a list that is always searched.
+type spatialIndex struct {
+	extent s2.Rect
+	size   int     // the grid has size × size cells
+	cells  [][]int // row-major, features in ascending order
+	large  []int
+}
+
+// maxSpatialIndexCells is how many grid cells a feature may occupy
+// before we put it into the large list.
+const maxSpatialIndexCells = 16
+
+func makeSpatialIndex(bounds []s2.Rect, extent s2.Rect) *spatialIndex {
+	size := int(math.Sqrt(float64(len(bounds)) / 4))
+	if size < 1 {
+		size = 1
+	} else if size > 1024 {
+		size = 1024
+	}
+	if extent.IsEmpty() || extent.Lng.IsInverted() {
+		size = 1
+	}
+	s := &spatialIndex{extent: extent, size: size, cells: make([][]int, size*size)}
+	for i, r := range bounds {
+		if r.IsEmpty() {
+			continue
+		}
+		x0, y0, x1, y1, ok := s.cellRange(r)
+		if !ok {
+			s.large = append(s.large, i)
+			continue
+		}
+		if (x1-x0+1)*(y1-y0+1) > maxSpatialIndexCells {
+			s.large = append(s.large, i)
+			continue
+		}
+		for y := y0; y <= y1; y++ {
+			for x := x0; x <= x1; x++ {
+				s.cells[y*size+x] = append(s.cells[y*size+x], i)
+			}
+		}
+	}
+	return s
+}
+
+// cellRange returns the grid cells overlapped by a rectangle. Returns
+// false if the rectangle crosses the antimeridian.
+func (s *spatialIndex) cellRange(r s2.Rect) (x0, y0, x1, y1 int, ok bool) {
+	if r.Lng.IsInverted() {
+		return 0, 0, 0, 0, false
+	}
+	cell := func(v, lo, length float64) int {
+		if length <= 0 {
+			return 0
+		}
+		c := int(math.Floor((v - lo) / length * float64(s.size)))
+		if c < 0 {
+			return 0
+		} else if c >= s.size {
+			return s.size - 1
+		}
+		return c
+	}
+	latLength, lngLength := s.extent.Lat.Length(), s.extent.Lng.Length()
+	x0 = cell(r.Lng.Lo, s.extent.Lng.Lo, lngLength)
+	x1 = cell(r.Lng.Hi, s.extent.Lng.Lo, lngLength)
+	y0 = cell(r.Lat.Lo, s.extent.Lat.Lo, latLength)
+	y1 = cell(r.Lat.Hi, s.extent.Lat.Lo, latLength)
+	return x0, y0, x1, y1, true
+}
+
+// estimate returns an upper bound for the number of features whose
+// bounds intersect bbox. Returns false if the index cannot tell.
+func (s *spatialIndex) estimate(bbox s2.Rect) (int, bool) {
+	if !bbox.Intersects(s.extent) {
+		return 0, true
+	}
+	x0, y0, x1, y1, ok := s.cellRange(bbox)
+	if !ok {
+		return 0, false
+	}
+	n := len(s.large)
+	for y := y0; y <= y1; y++ {
+		for x := x0; x <= x1; x++ {
+			n += len(s.cells[y*s.size+x])
+		}
+	}
+	return n, true
+}
+
+// search returns the features in the grid cells overlapping bbox,
+// in ascending order. The caller still needs to check their bounds.
+func (s *spatialIndex) search(bbox s2.Rect) []int {
+	if !bbox.Intersects(s.extent) {
+		return nil
+	}
+	x0, y0, x1, y1, _ := s.cellRange(bbox)
+	result := append([]int(nil), s.large...)
+	for y := y0; y <= y1; y++ {
+		for x := x0; x <= x1; x++ {
+			result = append(result, s.cells[y*s.size+x]...)
+		}
+	}
+	return sortedUnique(result)
+}
+
+// estimateMemory returns the approximate size of the index, in bytes.
+func (s *spatialIndex) estimateMemory() int64 {
+	if s == nil {
+		return 0
+	}
+	m := int64(24*len(s.cells) + 8*cap(s.large))
+	for _, c := range s.cells {
+		m += int64(8 * cap(c))
+	}
+	return m
+}
+
+// sortedUnique sorts a list of feature indices and removes duplicates.
+func sortedUnique(features []int) []int {
+	sort.Ints(features)
+	result := features[:0]
+	for _, i := range features {
+		if len(result) == 0 || i != result[len(result)-1] {
+			result = append(result, i)
+		}
+	}
+	return result
+}
+
+// QueryExplanation describes how GetItems would execute a query, for
+// clients diagnosing slow requests with ?explain=true.
+type QueryExplanation struct {
+	Collection string    `json:"collection"`
+	Plan       QueryPlan `json:"plan"`
+
+	// Filters are checked for every candidate. If any of them needs
+	// the feature geometry or properties, candidates get read from
+	// disk and decoded, which is much slower than checking the index.
+	Filters         []string `json:"filters"`
+	DecodesFeatures bool     `json:"decodesFeatures"`
+
+	// For collections whose index is spilled to disk, how many pages
+	// of the index file the candidates occupy, and how many of these
+	// are currently in the page cache.
+	IndexPages       int `json:"indexPages,omitempty"`
+	CachedIndexPages int `json:"cachedIndexPages,omitempty"`
+}
+
+// ExplainQuery tells how GetItems would execute a query, without
+// executing it.
+func (index *Index) ExplainQuery(collection string, bbox s2.Rect, filter PropertyFilter, ids []string, query string,
+	join *SpatialJoin, cql *CQLFilter) (QueryExplanation, error) {
+	index.mutex.RLock()
+	defer index.mutex.RUnlock()
+
+	coll := index.Collections[collection]
+	if coll == nil {
+		return QueryExplanation{}, NotFound
+	}
+
+	plan := coll.planQuery(bbox, filter, ids, query)
+	result := QueryExplanation{Collection: collection, Plan: *plan, Filters: []string{}}
+	if !bbox.IsFull() {
+		result.Filters = append(result.Filters, "bbox")
+	}
+	if len(filter) > 0 {
+		result.Filters = append(result.Filters, "property")
+	}
+	if len(ids) > 0 {
+		result.Filters = append(result.Filters, "ids")
+	}
+	if join != nil {
+		result.Filters = append(result.Filters, "within")
+	}
+	if cql != nil {
+		result.Filters = append(result.Filters, "filter")
+	}
+	result.DecodesFeatures = join != nil || cql != nil
+
+	if s := coll.spilled; s != nil {
+		pages := make(map[int64]bool)
+		for k := 0; k < plan.Candidates; k++ {
+			i := k
+			if plan.features != nil {
+				i = plan.features[k]
+			}
+			pages[s.record(i)/spillPageSize] = true
+		}
+		result.IndexPages = len(pages)
+		result.CachedIndexPages = s.cache.countCached(s.fileID, pages)
+	}
+	return result, nil
+}
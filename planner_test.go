@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/golang/geo/s2"
+)
+
+// writeGridCollection writes a GeoJSON file with 20×20 points spaced
+// 0.01 degrees apart. Every 100th point is a castle, the others are
+// ruins.
+func writeGridCollection(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "miniwfs-planner")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var features []string
+	for i := 0; i < 400; i++ {
+		kind := "ruins"
+		if i%100 == 0 {
+			kind = "castle"
+		}
+		features = append(features, fmt.Sprintf(
+			`{"type":"Feature","id":"P%d","geometry":{"type":"Point","coordinates":[%g,%g]},"properties":{"historic":%q}}`,
+			i, 8+float64(i%20)/100, 47+float64(i/20)/100, kind))
+	}
+	path := filepath.Join(dir, "grid.geojson")
+	content := `{"type":"FeatureCollection","features":[` + strings.Join(features, ",") + `]}`
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestPlanQuery(t *testing.T) {
+	path := writeGridCollection(t)
+	defer os.RemoveAll(filepath.Dir(path))
+	coll, err := readCollection("grid", path, noTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer coll.Close()
+
+	tiny := s2.RectFromLatLng(s2.LatLngFromDegrees(47.05, 8.05))
+	for _, tc := range []struct {
+		bbox       s2.Rect
+		filter     PropertyFilter
+		ids        []string
+		query      string
+		strategy   string
+		candidates int
+	}{
+		{s2.FullRect(), nil, nil, "", planScan, 400},
+		{coll.metadata.Bbox, nil, nil, "", planScan, 400},
+		{tiny, nil, nil, "", planSpatial, 4},
+		{s2.FullRect(), PropertyFilter{"historic": "castle"}, nil, "", planProperty, 4},
+		{s2.FullRect(), PropertyFilter{"historic": "ruins"}, nil, "", planScan, 400},
+		{s2.FullRect(), PropertyFilter{"historic": "fort"}, nil, "", planProperty, 0},
+		{tiny, PropertyFilter{"historic": "ruins"}, nil, "", planSpatial, 4},
+		{s2.FullRect(), nil, []string{"P7", "P3", "P7", "X"}, "", planIDs, 2},
+		{s2.FullRect(), nil, nil, "castle", planText, 4},
+	} {
+		plan := coll.planQuery(tc.bbox, tc.filter, tc.ids, tc.query)
+		if plan.Strategy != tc.strategy || plan.Candidates != tc.candidates {
+			t.Errorf("bbox=%v filter=%v ids=%v q=%q: expected %s with %d candidates, got %s",
+				tc.bbox, tc.filter, tc.ids, tc.query, tc.strategy, tc.candidates, plan)
+		}
+	}
+}
+
+func TestPlanQuery_SameResults(t *testing.T) {
+	path := writeGridCollection(t)
+	defer os.RemoveAll(filepath.Dir(path))
+	index, err := MakeIndex(map[string]string{"grid": path}, nil, IndexOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer index.Close()
+
+	bbox := s2.RectFromLatLng(s2.LatLngFromDegrees(47.02, 8.02))
+	bbox = bbox.AddPoint(s2.LatLngFromDegrees(47.05, 8.04))
+	for start := 0; start < 12; start += 5 {
+		var buf bytes.Buffer
+		if _, _, err := index.GetItems("grid", itemsQuery{itemsSelection: itemsSelection{bbox: bbox}, start: start, limit: 5}, noTime, noTime, "", false, &buf); err != nil {
+			t.Fatal(err)
+		}
+		var page struct {
+			Features []struct {
+				ID string `json:"id"`
+			} `json:"features"`
+		}
+		if err := json.Unmarshal(buf.Bytes(), &page); err != nil {
+			t.Fatal(err)
+		}
+
+		// Scan all features to find the expected page.
+		var expected []string
+		coll := index.Collections["grid"]
+		for i := 0; i < coll.numFeatures(); i++ {
+			if bbox.Intersects(coll.featureBounds(i)) {
+				expected = append(expected, coll.featureID(i))
+			}
+		}
+		if end := start + 5; end < len(expected) {
+			expected = expected[:end]
+		}
+		expected = expected[start:]
+		var got []string
+		for _, f := range page.Features {
+			got = append(got, f.ID)
+		}
+		if strings.Join(got, ",") != strings.Join(expected, ",") {
+			t.Errorf("start=%d: expected %v, got %v", start, expected, got)
+		}
+	}
+}
+
+func TestItems_PlanHeader(t *testing.T) {
+	index, s := makeServer(t)
+	defer s.Shutdown()
+	defer index.Close()
+
+	for _, tc := range []struct {
+		url      string
+		expected string
+	}{
+		{"/collections/castles/items", ""},
+		{"/collections/castles/items?debug=1", "scan candidates=3 scan=3"},
+		{"/collections/castles/items?debug=1&ids=W24785843", "ids candidates=1 ids=1 scan=3"},
+	} {
+		req := httptest.NewRequest("GET", tc.url, nil)
+		resp := httptest.NewRecorder()
+		http.HandlerFunc(s.HandleRequest).ServeHTTP(resp, req)
+		if got := resp.Header().Get(PlanHeader); got != tc.expected {
+			t.Errorf("%s: expected %s %q, got %q", tc.url, PlanHeader, tc.expected, got)
+		}
+	}
+}
+
+func TestItems_Explain(t *testing.T) {
+	index, s := makeServer(t)
+	defer s.Shutdown()
+	defer index.Close()
+
+	req := httptest.NewRequest("GET", "/collections/castles/items?explain=true&name=Castello+Scaligero&bbox=11.1,47.9,11.2,48.0&filter=name%3D%27x%27", nil)
+	resp := httptest.NewRecorder()
+	http.HandlerFunc(s.HandleRequest).ServeHTTP(resp, req)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", resp.Code)
+	}
+	expectJSON(t, getBody(resp), `{
+		"collection": "castles",
+		"plan": {"strategy": "property", "property": "name", "candidates": 1,
+			"estimates": {"property": 1, "scan": 3, "spatial": 3}},
+		"filters": ["bbox", "property", "filter"],
+		"decodesFeatures": true
+	}`)
+
+	req = httptest.NewRequest("GET", "/collections/unknown/items?explain=true", nil)
+	resp = httptest.NewRecorder()
+	http.HandlerFunc(s.HandleRequest).ServeHTTP(resp, req)
+	if resp.Code != http.StatusNotFound {
+		t.Errorf("expected status 404 for unknown collection, got %d", resp.Code)
+	}
+}
+
+func TestExplainQuery_Spilled(t *testing.T) {
+	index := loadTestIndex(t)
+	defer index.Close()
+	index.SetSpillThreshold(1, 1<<20)
+
+	explain := func() QueryExplanation {
+		e, err := index.ExplainQuery("castles", s2.FullRect(), nil, nil, "", nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return e
+	}
+	if e := explain(); e.IndexPages != 1 || e.CachedIndexPages != 0 {
+		t.Errorf("expected 1 uncached page, got %d of %d cached", e.CachedIndexPages, e.IndexPages)
+	}
+	index.HasItem("castles", "W24785843")
+	if e := explain(); e.IndexPages != 1 || e.CachedIndexPages != 1 {
+		t.Errorf("expected 1 cached page, got %d of %d cached", e.CachedIndexPages, e.IndexPages)
+	}
+}
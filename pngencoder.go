@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"io"
+)
+
+// encodePNG encodes an image as PNG, so that identical images always
+// yield identical bytes. Tiles get cached by CDNs and compared by
+// tests, but image/png picks filters row by row with heuristics that
+// have changed between Go versions, and its choice of color type
+// depends on the image contents. Here, every image is 8-bit RGBA with
+// the Sub filter on all rows, compressed at a fixed zlib level, with
+// no ancillary chunks such as timestamps or gamma. TestEncodePNG pins
+// the output, so we notice if a Go release changes compress/flate.
+func encodePNG(w io.Writer, img image.Image) error {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+
+	var buf bytes.Buffer
+	buf.WriteString("\x89PNG\r\n\x1a\n")
+	var header [13]byte
+	binary.BigEndian.PutUint32(header[0:4], uint32(width))
+	binary.BigEndian.PutUint32(header[4:8], uint32(height))
+	header[8] = 8 // bit depth
+	header[9] = 6 // color type RGBA; compression, filter and interlace are 0
+	writePNGChunk(&buf, "IHDR", header[:])
+
+	var data bytes.Buffer
+	z, err := zlib.NewWriterLevel(&data, zlib.DefaultCompression)
+	if err != nil {
+		return err
+	}
+	pixels := make([]byte, 4*width)
+	row := make([]byte, 1+4*width)
+	row[0] = 1 // filter type Sub
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		readNRGBARow(img, y, pixels)
+		for i, p := range pixels {
+			if i < 4 {
+				row[1+i] = p
+			} else {
+				row[1+i] = p - pixels[i-4]
+			}
+		}
+		if _, err := z.Write(row); err != nil {
+			return err
+		}
+	}
+	if err := z.Close(); err != nil {
+		return err
+	}
+	writePNGChunk(&buf, "IDAT", data.Bytes())
+	writePNGChunk(&buf, "IEND", nil)
+
+	_, err = buf.WriteTo(w)
+	return err
+}
+
+// readNRGBARow reads a row of pixels as non-premultiplied RGBA, with
+// fully transparent pixels always as zero bytes.
+func readNRGBARow(img image.Image, y int, pixels []byte) {
+	bounds := img.Bounds()
+	if rgba, ok := img.(*image.RGBA); ok {
+		src := rgba.Pix[rgba.PixOffset(bounds.Min.X, y):]
+		for i := 0; i < len(pixels); i += 4 {
+			r, g, b, a := uint32(src[i]), uint32(src[i+1]), uint32(src[i+2]), uint32(src[i+3])
+			switch a {
+			case 0:
+				r, g, b = 0, 0, 0
+			case 0xff:
+			default: // same rounding as color.NRGBAModel
+				a16 := a * 0x101
+				r, g, b = r*0x101*0xffff/a16>>8, g*0x101*0xffff/a16>>8, b*0x101*0xffff/a16>>8
+			}
+			pixels[i], pixels[i+1], pixels[i+2], pixels[i+3] = uint8(r), uint8(g), uint8(b), uint8(a)
+		}
+		return
+	}
+	for x := bounds.Min.X; x < bounds.Max.X; x++ {
+		c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+		if c.A == 0 {
+			c = color.NRGBA{}
+		}
+		i := 4 * (x - bounds.Min.X)
+		pixels[i], pixels[i+1], pixels[i+2], pixels[i+3] = c.R, c.G, c.B, c.A
+	}
+}
+
+func writePNGChunk(buf *bytes.Buffer, chunkType string, data []byte) {
+	var length [4]byte
+	binary.BigEndian.PutUint32(length[:], uint32(len(data)))
+	buf.Write(length[:])
+	crc := crc32.NewIEEE()
+	crc.Write([]byte(chunkType))
+	crc.Write(data)
+	buf.WriteString(chunkType)
+	buf.Write(data)
+	var sum [4]byte
+	binary.BigEndian.PutUint32(sum[:], crc.Sum32())
+	buf.Write(sum[:])
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"strings"
+	"testing"
+
+	"github.com/golang/geo/r2"
+)
+
+func TestEncodePNG(t *testing.T) {
+	var tile Tile
+	tile.DrawPoint(r2.Point{X: 7.02, Y: 22.95})
+	tile.DrawMarker(r2.Point{X: 100, Y: 120}, Marker{Color: color.NRGBA{R: 30, G: 144, B: 255, A: 128}})
+	encoded := tile.ToPNG()
+
+	// The same drawing must always be encoded to the same bytes.
+	// If this fails after upgrading Go, compress/flate has changed;
+	// try to find settings that keep producing the old output.
+	if got := fmt.Sprintf("%x", sha256.Sum256(encoded)); got != "1ac19b1140b81cfb07d8dc4075179c46078674726f6baba92f248b212c23dff4" {
+		t.Errorf("expected stable encoding, got sha256 %s", got)
+	}
+
+	var chunks []string
+	for pos := 8; pos+8 <= len(encoded); {
+		length := int(binary.BigEndian.Uint32(encoded[pos:]))
+		chunks = append(chunks, string(encoded[pos+4:pos+8]))
+		pos += 12 + length
+	}
+	if got := strings.Join(chunks, ","); got != "IHDR,IDAT,IEND" {
+		t.Errorf("expected no ancillary chunks, got %s", got)
+	}
+
+	decoded, err := png.Decode(bytes.NewReader(encoded))
+	if err != nil {
+		t.Fatal(err)
+	}
+	img := tile.dc.Image()
+	for y := img.Bounds().Min.Y; y < img.Bounds().Max.Y; y++ {
+		for x := img.Bounds().Min.X; x < img.Bounds().Max.X; x++ {
+			expected := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			if expected.A == 0 {
+				expected = color.NRGBA{}
+			}
+			if got := decoded.At(x, y).(color.NRGBA); got != expected {
+				t.Fatalf("pixel (%d, %d): expected %v, got %v", x, y, expected, got)
+			}
+		}
+	}
+}
+
+func TestEncodePNG_NonRGBA(t *testing.T) {
+	img := image.NewGray(image.Rect(10, 20, 13, 22))
+	img.SetGray(11, 21, color.Gray{Y: 200})
+	var buf bytes.Buffer
+	if err := encodePNG(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := png.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := decoded.Bounds(); b.Dx() != 3 || b.Dy() != 2 {
+		t.Errorf("expected 3x2 image, got %v", b)
+	}
+	if got := decoded.At(1, 1).(color.NRGBA); got != (color.NRGBA{200, 200, 200, 255}) {
+		t.Errorf("expected gray pixel, got %v", got)
+	}
+}
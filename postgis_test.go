@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMakePostGISQuery(t *testing.T) {
+	params := url.Values{"table": {`osm.Castles`}, "geometry": {"way"}, "id": {"osm_id"}, "bbox": {"5.9, 45.8,10.5,47.8"}}
+	got, err := makePostGISQuery(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `SELECT t."osm_id"::text, ST_AsGeoJSON(ST_Transform(t."way", 4326))::text, ` +
+		`(to_jsonb(t) - 'way' - 'osm_id')::text FROM "osm"."Castles" AS t ` +
+		`WHERE ST_Transform(t."way", 4326) && ST_MakeEnvelope(5.9, 45.8, 10.5, 47.8, 4326)`
+	if got != expected {
+		t.Errorf("expected %s, got %s", expected, got)
+	}
+
+	got, _ = makePostGISQuery(url.Values{"query": {"SELECT * FROM castles WHERE open"}})
+	expected = `SELECT NULL::text, ST_AsGeoJSON(ST_Transform(t."geom", 4326))::text, ` +
+		`(to_jsonb(t) - 'geom')::text FROM (SELECT * FROM castles WHERE open) AS t`
+	if got != expected {
+		t.Errorf("expected %s, got %s", expected, got)
+	}
+
+	for _, params := range []url.Values{{}, {"table": {"t"}, "bbox": {"1,2,3"}}, {"table": {"t"}, "bbox": {"1,2,3,x"}}} {
+		if _, err := makePostGISQuery(params); err == nil {
+			t.Errorf("expected error for %v", params)
+		}
+	}
+}
+
+func TestSCRAMClient(t *testing.T) {
+	// Test vector from RFC 7677, section 3.
+	s := &scramClient{user: "user", password: "pencil", nonce: "rOprNGfwEbeRWgbNEkqO"}
+	if got := s.clientFirst(); got != "n,,n=user,r=rOprNGfwEbeRWgbNEkqO" {
+		t.Errorf("unexpected client-first message %q", got)
+	}
+	serverFirst := "r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096"
+	got, err := s.clientFinal(serverFirst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "c=biws,r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,p=dHzbZapWIk4jUhN+Ute9ytag9zjfMHgsqmmiz7AndVQ="
+	if got != expected {
+		t.Errorf("expected client-final message %q, got %q", expected, got)
+	}
+	if !s.verifyServerFinal("v=6rriTRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4=") {
+		t.Error("expected server signature to be accepted")
+	}
+	if s.verifyServerFinal("v=AAAATRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4=") {
+		t.Error("expected wrong server signature to be rejected")
+	}
+}
+
+func TestMD5Password(t *testing.T) {
+	if got := md5Password("alice", "secret", []byte{1, 2, 3, 4}); len(got) != 35 || !strings.HasPrefix(got, "md5") {
+		t.Errorf("unexpected MD5 password %q", got)
+	}
+}
+
+func TestPostGISLoader(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	queries := make(chan string, 1)
+	go servePostgres(t, ln, queries, [][]*string{
+		{strp("Q1"), strp(`{"type":"Point","coordinates":[8.5,47.4]}`), strp(`{"name":"Castle A","year":1200}`)},
+		{nil, nil, strp(`{"name":"Castle B"}`)},
+	})
+
+	source := "postgres://alice@" + ln.Addr().String() + "/gis?table=castles&id=wikidata&bbox=5.9%2C45.8%2C10.5%2C47.8&sslmode=disable"
+	l, err := GetInputLoader(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.Name() != "postgis" {
+		t.Errorf("expected postgis loader, got %s", l.Name())
+	}
+	data, err := l.Load(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case query := <-queries:
+		if !strings.Contains(query, `FROM "castles" AS t WHERE ST_Transform(t."geom", 4326) && ST_MakeEnvelope(5.9, 45.8, 10.5, 47.8, 4326)`) {
+			t.Errorf("expected bbox filter in query, got %s", query)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("server received no query")
+	}
+
+	if len(data.Features) != 2 {
+		t.Fatalf("expected 2 features, got %d", len(data.Features))
+	}
+	a, b := data.Features[0], data.Features[1]
+	if a.ID != "Q1" || a.Geometry == nil || !a.Geometry.IsPoint() || a.Properties["name"] != "Castle A" {
+		t.Errorf("unexpected first feature %+v", a)
+	}
+	if b.ID != nil || b.Geometry != nil || b.Properties["name"] != "Castle B" {
+		t.Errorf("unexpected second feature %+v", b)
+	}
+}
+
+func TestPostGISLoader_Error(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go servePostgres(t, ln, make(chan string, 1), nil)
+
+	source := "postgres://alice:secret@" + ln.Addr().String() + "/gis?table=missing&sslmode=disable"
+	_, err = postgisLoader{}.Load(source)
+	if err == nil || !strings.Contains(err.Error(), `relation "missing" does not exist`) {
+		t.Errorf("expected error from server, got %v", err)
+	}
+	if strings.Contains(err.Error(), "secret") {
+		t.Errorf("error message should not reveal password: %v", err)
+	}
+}
+
+func strp(s string) *string {
+	return &s
+}
+
+// servePostgres accepts one connection, trusts the client, and answers
+// its query with the given rows. Without rows, it responds with an error.
+func servePostgres(t *testing.T, ln net.Listener, queries chan<- string, rows [][]*string) {
+	conn, err := ln.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return
+	}
+	startup := make([]byte, binary.BigEndian.Uint32(header[:])-4)
+	if _, err := io.ReadFull(r, startup); err != nil {
+		return
+	}
+	if !strings.Contains(string(startup), "user\x00alice\x00database\x00gis\x00") {
+		t.Errorf("unexpected startup message %q", startup)
+	}
+
+	write := func(typ byte, body []byte) {
+		conn.Write(append(appendInt32([]byte{typ}, uint32(len(body)+4)), body...))
+	}
+	write('R', appendInt32(nil, 0))
+	write('S', []byte("server_version\x0016.2\x00"))
+	write('Z', []byte{'I'})
+
+	typ, err := r.ReadByte()
+	if err != nil || typ != 'Q' {
+		return
+	}
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return
+	}
+	query := make([]byte, binary.BigEndian.Uint32(header[:])-4)
+	if _, err := io.ReadFull(r, query); err != nil {
+		return
+	}
+	queries <- strings.TrimSuffix(string(query), "\x00")
+
+	if rows == nil {
+		write('E', []byte("SERROR\x00C42P01\x00Mrelation \"missing\" does not exist\x00\x00"))
+	}
+	for _, row := range rows {
+		body := []byte{0, byte(len(row))}
+		for _, col := range row {
+			if col == nil {
+				body = appendInt32(body, 0xffffffff)
+			} else {
+				body = append(appendInt32(body, uint32(len(*col))), *col...)
+			}
+		}
+		write('D', body)
+	}
+	write('C', []byte("SELECT 2\x00"))
+	write('Z', []byte{'I'})
+}
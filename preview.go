@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"image/color"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/fogleman/gg"
+	"github.com/golang/geo/r2"
+)
+
+// PreviewSize is the width and height of collection previews, in pixels.
+const PreviewSize = 512
+
+// previewMargin keeps rendered points away from the image border.
+const previewMargin = 16
+
+// GetPreview returns a thumbnail image of a collection, rendering its
+// full extent. The image gets computed on first use and then kept with
+// the collection, so it is regenerated when the collection is reloaded.
+func (index *Index) GetPreview(collection string) ([]byte, CollectionMetadata, error) {
+	index.mutex.RLock()
+	defer index.mutex.RUnlock()
+
+	coll := index.Collections[collection]
+	if coll == nil {
+		return nil, CollectionMetadata{}, NotFound
+	}
+
+	coll.previewOnce.Do(func() {
+		c, _ := parseHexColor(index.getStyle(collection).Color)
+		coll.preview = renderPreview(coll.webMercatorPoints(), c)
+		coll.addCacheMemory(int64(len(coll.preview)))
+	})
+	return coll.preview, coll.metadata, nil
+}
+
+// renderPreview draws points, given in normalized web mercator
+// coordinates, into an image that fits their extent.
+func renderPreview(points []r2.Point, c color.Color) []byte {
+	dc := gg.NewContext(PreviewSize, PreviewSize)
+	dc.SetRGBA255(255, 255, 255, 0)
+	dc.Clear()
+
+	if len(points) > 0 {
+		bounds := r2.RectFromPoints(points...)
+		size := bounds.Size()
+		span := math.Max(size.X, size.Y)
+		if span <= 0 {
+			span = math.Exp2(-MaxTileZoom) // a single location
+		}
+		scale := (PreviewSize - 2*previewMargin) / span
+		center := bounds.Center()
+		dc.SetColor(c)
+		for _, p := range points {
+			x := PreviewSize/2 + (p.X-center.X)*scale
+			y := PreviewSize/2 + (p.Y-center.Y)*scale
+			dc.DrawCircle(x, y, 3)
+			dc.Fill()
+		}
+	}
+
+	var png bytes.Buffer
+	encodePNG(&png, dc.Image())
+	return png.Bytes()
+}
+
+func (s *WebServer) handlePreviewRequest(w http.ResponseWriter, req *http.Request, collection string) {
+	if !s.authorize(w, req, collection) {
+		return
+	}
+
+	preview, metadata, err := s.index.GetPreview(collection)
+	if status := getHTTPStatus(err); status != http.StatusOK {
+		w.WriteHeader(status)
+		return
+	}
+
+	header := w.Header()
+	header.Set("Access-Control-Allow-Origin", "*")
+	header.Set("Content-Length", strconv.Itoa(len(preview)))
+	header.Set("Content-Type", "image/png")
+	header.Set("Last-Modified", metadata.LastModified.UTC().Format(http.TimeFormat))
+	s.setLicenseLink(header, collection)
+	w.WriteHeader(http.StatusOK)
+	w.Write(preview)
+}
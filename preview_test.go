@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/golang/geo/r2"
+	"github.com/golang/geo/s2"
+)
+
+func TestRenderPreview(t *testing.T) {
+	// The extent of the points is taller than wide, so they should end
+	// up at the top and bottom margins, with north at the top.
+	points := []r2.Point{
+		projectWebMercator(s2.LatLngFromDegrees(47.5, 8.4)),
+		projectWebMercator(s2.LatLngFromDegrees(47.3, 8.6)),
+	}
+	img := decodePreview(t, renderPreview(points, color.Black))
+	if !hasOpaquePixel(img, previewMargin, 0, PreviewSize/2) {
+		t.Error("expected north-western point to be drawn at top left")
+	}
+	if !hasOpaquePixel(img, PreviewSize-previewMargin, PreviewSize/2, PreviewSize) {
+		t.Error("expected south-eastern point to be drawn at bottom right")
+	}
+	if isOpaque(img, PreviewSize/2, PreviewSize/2) {
+		t.Error("expected empty center")
+	}
+
+	single := decodePreview(t, renderPreview(points[:1], color.Black))
+	if !isOpaque(single, PreviewSize/2, PreviewSize/2) {
+		t.Error("expected single point to be drawn at center")
+	}
+
+	empty := decodePreview(t, renderPreview(nil, color.Black))
+	if isOpaque(empty, PreviewSize/2, PreviewSize/2) {
+		t.Error("expected empty preview to be transparent")
+	}
+}
+
+func TestPreview(t *testing.T) {
+	index, s := makeServer(t)
+	defer s.Shutdown()
+	defer index.Close()
+	query, _ := http.NewRequest("GET", "/collections/castles/preview.png", nil)
+	resp := httptest.NewRecorder()
+	http.HandlerFunc(s.HandleRequest).ServeHTTP(resp, query)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", resp.Code)
+	}
+	if ct := resp.Header().Get("Content-Type"); ct != "image/png" {
+		t.Errorf("expected Content-Type: image/png, got %s", ct)
+	}
+	expectCORSHeader(t, resp.Header())
+	decodePreview(t, resp.Body.Bytes())
+
+	query, _ = http.NewRequest("GET", "/collections/nosuchcollection/preview.png", nil)
+	resp = httptest.NewRecorder()
+	http.HandlerFunc(s.HandleRequest).ServeHTTP(resp, query)
+	if resp.Code != http.StatusNotFound {
+		t.Errorf("expected status 404 for unknown collection, got %d", resp.Code)
+	}
+}
+
+func decodePreview(t *testing.T, data []byte) image.Image {
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := img.Bounds(); b.Dx() != PreviewSize || b.Dy() != PreviewSize {
+		t.Fatalf("expected %dx%d image, got %v", PreviewSize, PreviewSize, b)
+	}
+	return img
+}
+
+func isOpaque(img image.Image, x int, y int) bool {
+	_, _, _, alpha := img.At(x, y).RGBA()
+	return alpha != 0
+}
+
+// hasOpaquePixel returns true if any pixel in row y between columns
+// minX (inclusive) and maxX (exclusive) is not fully transparent.
+func hasOpaquePixel(img image.Image, y int, minX int, maxX int) bool {
+	for x := minX; x < maxX; x++ {
+		if isOpaque(img, x, y) {
+			return true
+		}
+	}
+	return false
+}
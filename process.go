@@ -0,0 +1,465 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/paulmach/go.geojson"
+)
+
+// Limits for the /process endpoint, which computes geometries from
+// query results. The computations are quadratic in the worst case,
+// so we only accept small inputs.
+const (
+	maxProcessFeatures = 1000
+	maxProcessVertices = 20000
+	maxBufferDistance  = 100000 // meters
+)
+
+// bufferCircleSegments is the number of segments approximating a circle
+// when buffering.
+const bufferCircleSegments = 24
+
+// metersPerDegree is the length of one degree latitude on a spherical
+// earth with the mean radius.
+const metersPerDegree = 6371008.8 * math.Pi / 180
+
+var TooManyFeatures error = fmt.Errorf("query matches more than %d features, or more than %d vertices; narrow it down with bbox or filters", maxProcessFeatures, maxProcessVertices)
+var NotPolygonal error = errors.New("union needs polygons; buffer points and lines instead")
+
+// Geoprocessing operations. Their computations are planar, in degrees
+// for convexhull and union, and in meters of a local projection for
+// buffer, which is fine for the small areas we accept.
+var processOperations = map[string]func(features []*geojson.Feature, distance float64) (*geojson.Geometry, error){
+	"buffer":     bufferFeatures,
+	"convexhull": convexHullFeatures,
+	"union":      unionFeatures,
+}
+
+func (s *WebServer) handleProcessRequest(w http.ResponseWriter, req *http.Request, collection string) {
+	if !s.authorize(w, req, collection) {
+		return
+	}
+	if len(s.negotiate(w, req, "json")) == 0 {
+		return
+	}
+
+	params := req.URL.Query()
+	opName := params.Get("op")
+	op := processOperations[opName]
+	distance := 0.0
+	var err error
+	if op == nil {
+		err = errors.New("unsupported op; supported are buffer, convexhull and union")
+	} else if opName == "buffer" {
+		distance, err = strconv.ParseFloat(params.Get("distance"), 64)
+		if err != nil || !(distance > 0 && distance <= maxBufferDistance) {
+			err = fmt.Errorf("buffer needs a distance in meters, greater than 0 and at most %d", maxBufferDistance)
+		}
+	}
+	if err != nil {
+		writeProcessError(w, err)
+		return
+	}
+
+	sel, ok := s.parseItemsSelection(w, req)
+	if !ok {
+		return
+	}
+	// The parameters of the operation are not property filters.
+	delete(sel.filter, "op")
+	delete(sel.filter, "distance")
+
+	var buf bytes.Buffer
+	includeDeleted := false
+	var always time.Time
+	metadata, _, err := s.index.GetItems(collection,
+		itemsQuery{itemsSelection: *sel, limit: maxProcessFeatures + 1},
+		always, always, "", includeDeleted, &buf)
+	if status := getHTTPStatus(err); status != http.StatusOK {
+		w.WriteHeader(status)
+		return
+	}
+	raw, err := decodeRawFeatures(buf.Bytes())
+	if err != nil {
+		httpLog.Error("decoding features failed", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	features := make([]*geojson.Feature, len(raw))
+	numVertices := 0
+	for i, f := range raw {
+		features[i] = f.Feature
+		numVertices += countVertices(f.Feature.Geometry)
+	}
+	if len(features) > maxProcessFeatures || numVertices > maxProcessVertices {
+		writeProcessError(w, TooManyFeatures)
+		return
+	}
+
+	geometry, err := op(features, distance)
+	if err != nil {
+		writeProcessError(w, err)
+		return
+	}
+
+	result := geojson.NewFeatureCollection()
+	if geometry != nil {
+		f := geojson.NewFeature(geometry)
+		f.SetProperty("operation", opName)
+		f.SetProperty("numFeatures", len(features))
+		result.AddFeature(f)
+	}
+	encoded, err := json.Marshal(result)
+	if err != nil {
+		httpLog.Error("json.Marshal failed", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	header := w.Header()
+	header.Set("Access-Control-Allow-Origin", "*")
+	header.Set("Content-Length", strconv.Itoa(len(encoded)))
+	header.Set("Content-Type", "application/geo+json")
+	header.Set("Last-Modified", metadata.LastModified.UTC().Format(http.TimeFormat))
+	s.setLicenseLink(header, collection)
+	w.WriteHeader(http.StatusOK)
+	w.Write(encoded)
+}
+
+func writeProcessError(w http.ResponseWriter, err error) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusBadRequest)
+	io.WriteString(w, err.Error()+"\n")
+}
+
+func countVertices(g *geojson.Geometry) int {
+	if g == nil {
+		return 0
+	}
+	n := 0
+	forEachCoord(g, func(c []float64) { n++ })
+	return n
+}
+
+// forEachCoord calls fn for every vertex of a geometry.
+func forEachCoord(g *geojson.Geometry, fn func(c []float64)) {
+	switch g.Type {
+	case geojson.GeometryPoint:
+		fn(g.Point)
+	case geojson.GeometryMultiPoint:
+		for _, c := range g.MultiPoint {
+			fn(c)
+		}
+	case geojson.GeometryLineString:
+		for _, c := range g.LineString {
+			fn(c)
+		}
+	case geojson.GeometryMultiLineString:
+		for _, line := range g.MultiLineString {
+			for _, c := range line {
+				fn(c)
+			}
+		}
+	case geojson.GeometryPolygon:
+		for _, ring := range g.Polygon {
+			for _, c := range ring {
+				fn(c)
+			}
+		}
+	case geojson.GeometryMultiPolygon:
+		for _, poly := range g.MultiPolygon {
+			for _, ring := range poly {
+				for _, c := range ring {
+					fn(c)
+				}
+			}
+		}
+	case geojson.GeometryCollection:
+		for _, geom := range g.Geometries {
+			if geom != nil {
+				forEachCoord(geom, fn)
+			}
+		}
+	}
+}
+
+// convexHullFeatures returns the convex hull of all feature vertices.
+func convexHullFeatures(features []*geojson.Feature, distance float64) (*geojson.Geometry, error) {
+	var points []planarPoint
+	for _, f := range features {
+		if f.Geometry != nil {
+			forEachCoord(f.Geometry, func(c []float64) {
+				if len(c) >= 2 {
+					points = append(points, planarPoint{c[0], c[1]})
+				}
+			})
+		}
+	}
+	hull := convexHull(points)
+	switch len(hull) {
+	case 0:
+		return nil, nil
+	case 1:
+		return geojson.NewPointGeometry([]float64{hull[0].X, hull[0].Y}), nil
+	case 2:
+		return geojson.NewLineStringGeometry([][]float64{{hull[0].X, hull[0].Y}, {hull[1].X, hull[1].Y}}), nil
+	default:
+		return polygonsToGeometry([]planarPolygon{{hull}}), nil
+	}
+}
+
+// convexHull returns the convex hull of points in counterclockwise
+// order, using Andrew's monotone chain algorithm.
+func convexHull(points []planarPoint) planarRing {
+	sorted := make([]planarPoint, len(points))
+	copy(sorted, points)
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].X != sorted[j].X {
+			return sorted[i].X < sorted[j].X
+		}
+		return sorted[i].Y < sorted[j].Y
+	})
+	unique := sorted[:0]
+	for i, p := range sorted {
+		if i == 0 || p != sorted[i-1] {
+			unique = append(unique, p)
+		}
+	}
+	if len(unique) < 3 {
+		return planarRing(unique)
+	}
+
+	cross := func(o, a, b planarPoint) float64 {
+		return (a.X-o.X)*(b.Y-o.Y) - (a.Y-o.Y)*(b.X-o.X)
+	}
+	hull := make(planarRing, 0, 2*len(unique))
+	for _, p := range unique {
+		for len(hull) >= 2 && cross(hull[len(hull)-2], hull[len(hull)-1], p) <= 0 {
+			hull = hull[:len(hull)-1]
+		}
+		hull = append(hull, p)
+	}
+	lower := len(hull) + 1
+	for i := len(unique) - 2; i >= 0; i-- {
+		p := unique[i]
+		for len(hull) >= lower && cross(hull[len(hull)-2], hull[len(hull)-1], p) <= 0 {
+			hull = hull[:len(hull)-1]
+		}
+		hull = append(hull, p)
+	}
+	return hull[:len(hull)-1]
+}
+
+// unionFeatures returns the union of polygonal features.
+func unionFeatures(features []*geojson.Feature, distance float64) (*geojson.Geometry, error) {
+	var polygons []planarPolygon
+	for _, f := range features {
+		if f.Geometry == nil {
+			continue
+		}
+		polys, ok := geometryToPolygons(f.Geometry, func(c []float64) planarPoint {
+			return planarPoint{c[0], c[1]}
+		})
+		if !ok {
+			return nil, NotPolygonal
+		}
+		polygons = append(polygons, polys...)
+	}
+	return polygonsToGeometry(unionPolygons(polygons)), nil
+}
+
+// geometryToPolygons converts polygonal geometries. Returns false if
+// the geometry has points or lines.
+func geometryToPolygons(g *geojson.Geometry, project func([]float64) planarPoint) ([]planarPolygon, bool) {
+	convert := func(rings [][][]float64) planarPolygon {
+		poly := make(planarPolygon, 0, len(rings))
+		for _, ring := range rings {
+			poly = append(poly, ringToPlanar(ring, project))
+		}
+		return poly
+	}
+	switch g.Type {
+	case geojson.GeometryPolygon:
+		return []planarPolygon{convert(g.Polygon)}, true
+	case geojson.GeometryMultiPolygon:
+		result := make([]planarPolygon, 0, len(g.MultiPolygon))
+		for _, rings := range g.MultiPolygon {
+			result = append(result, convert(rings))
+		}
+		return result, true
+	case geojson.GeometryCollection:
+		var result []planarPolygon
+		for _, geom := range g.Geometries {
+			if geom == nil {
+				continue
+			}
+			polys, ok := geometryToPolygons(geom, project)
+			if !ok {
+				return nil, false
+			}
+			result = append(result, polys...)
+		}
+		return result, true
+	}
+	return nil, false
+}
+
+// ringToPlanar converts a GeoJSON ring, dropping the closing vertex.
+func ringToPlanar(ring [][]float64, project func([]float64) planarPoint) planarRing {
+	result := make(planarRing, 0, len(ring))
+	for _, c := range ring {
+		if len(c) >= 2 {
+			result = append(result, project(c))
+		}
+	}
+	if n := len(result); n > 1 && result[0] == result[n-1] {
+		result = result[:n-1]
+	}
+	return result
+}
+
+// bufferFeatures returns the area within distance meters of the features.
+func bufferFeatures(features []*geojson.Feature, distance float64) (*geojson.Geometry, error) {
+	extent := emptyPlanarBounds()
+	for _, f := range features {
+		if f.Geometry != nil {
+			forEachCoord(f.Geometry, func(c []float64) {
+				if len(c) >= 2 {
+					extent.add(planarPoint{c[0], c[1]})
+				}
+			})
+		}
+	}
+	if extent.MinX > extent.MaxX {
+		return nil, nil
+	}
+
+	// Local equirectangular projection around the center of the input.
+	lat0 := (extent.MinY + extent.MaxY) / 2 * math.Pi / 180
+	scaleX := metersPerDegree * math.Cos(lat0)
+	project := func(c []float64) planarPoint {
+		return planarPoint{c[0] * scaleX, c[1] * metersPerDegree}
+	}
+
+	var polygons []planarPolygon
+	for _, f := range features {
+		if f.Geometry != nil {
+			polygons = appendBuffers(polygons, f.Geometry, distance, project)
+		}
+	}
+
+	union := unionPolygons(polygons)
+	for _, poly := range union {
+		for _, ring := range poly {
+			for i, p := range ring {
+				ring[i] = planarPoint{p.X / scaleX, p.Y / metersPerDegree}
+			}
+		}
+	}
+	return polygonsToGeometry(union), nil
+}
+
+// appendBuffers appends polygons whose union is the buffer of a geometry:
+// circles around points, stadiums around line segments and polygon
+// edges, and the polygons themselves.
+func appendBuffers(result []planarPolygon, g *geojson.Geometry, distance float64,
+	project func([]float64) planarPoint) []planarPolygon {
+	line := func(coords [][]float64) {
+		points := ringToPlanar(coords, project)
+		if len(coords) > 1 && len(points) < len(coords) {
+			points = append(points, points[0]) // closed ring, keep last edge
+		}
+		if len(points) == 1 {
+			result = append(result, planarPolygon{bufferCircle(points[0], distance)})
+		}
+		for i := 1; i < len(points); i++ {
+			stadium := convexHull(append(bufferCircle(points[i-1], distance), bufferCircle(points[i], distance)...))
+			result = append(result, planarPolygon{stadium})
+		}
+	}
+
+	switch g.Type {
+	case geojson.GeometryPoint:
+		line([][]float64{g.Point})
+	case geojson.GeometryMultiPoint:
+		for _, c := range g.MultiPoint {
+			line([][]float64{c})
+		}
+	case geojson.GeometryLineString:
+		line(g.LineString)
+	case geojson.GeometryMultiLineString:
+		for _, l := range g.MultiLineString {
+			line(l)
+		}
+	case geojson.GeometryPolygon, geojson.GeometryMultiPolygon:
+		polys, _ := geometryToPolygons(g, project)
+		result = append(result, polys...)
+		forEachRing(g, line)
+	case geojson.GeometryCollection:
+		for _, geom := range g.Geometries {
+			if geom != nil {
+				result = appendBuffers(result, geom, distance, project)
+			}
+		}
+	}
+	return result
+}
+
+func forEachRing(g *geojson.Geometry, fn func(ring [][]float64)) {
+	switch g.Type {
+	case geojson.GeometryPolygon:
+		for _, ring := range g.Polygon {
+			fn(ring)
+		}
+	case geojson.GeometryMultiPolygon:
+		for _, poly := range g.MultiPolygon {
+			for _, ring := range poly {
+				fn(ring)
+			}
+		}
+	}
+}
+
+func bufferCircle(center planarPoint, radius float64) planarRing {
+	ring := make(planarRing, bufferCircleSegments)
+	for i := range ring {
+		angle := 2 * math.Pi * float64(i) / bufferCircleSegments
+		ring[i] = planarPoint{center.X + radius*math.Cos(angle), center.Y + radius*math.Sin(angle)}
+	}
+	return ring
+}
+
+// polygonsToGeometry converts polygons into a GeoJSON Polygon or
+// MultiPolygon, or nil if there are none.
+func polygonsToGeometry(polygons []planarPolygon) *geojson.Geometry {
+	coords := make([][][][]float64, 0, len(polygons))
+	for _, poly := range polygons {
+		rings := make([][][]float64, 0, len(poly))
+		for _, ring := range poly {
+			r := make([][]float64, 0, len(ring)+1)
+			for _, p := range ring {
+				r = append(r, []float64{p.X, p.Y})
+			}
+			r = append(r, r[0])
+			rings = append(rings, r)
+		}
+		coords = append(coords, rings)
+	}
+	switch len(coords) {
+	case 0:
+		return nil
+	case 1:
+		return geojson.NewPolygonGeometry(coords[0])
+	default:
+		return geojson.NewMultiPolygonGeometry(coords...)
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/paulmach/go.geojson"
+)
+
+func square(x0, y0, x1, y1 float64) planarPolygon {
+	return planarPolygon{{{x0, y0}, {x1, y0}, {x1, y1}, {x0, y1}}}
+}
+
+func totalArea(polygons []planarPolygon) float64 {
+	var area float64
+	for _, poly := range polygons {
+		for _, ring := range poly {
+			area += ring.signedArea()
+		}
+	}
+	return area
+}
+
+func TestUnionPolygons(t *testing.T) {
+	frame := []planarPolygon{
+		square(0, 0, 4, 1), square(0, 3, 4, 4),
+		square(0, 1, 1, 3), square(3, 1, 4, 3),
+	}
+	for _, tc := range []struct {
+		name        string
+		input       []planarPolygon
+		numPolygons int
+		numRings    int
+		area        float64
+	}{
+		{"overlapping", []planarPolygon{square(0, 0, 2, 2), square(1, 1, 3, 3)}, 1, 1, 7},
+		{"disjoint", []planarPolygon{square(0, 0, 1, 1), square(2, 2, 3, 3)}, 2, 2, 2},
+		{"adjacent", []planarPolygon{square(0, 0, 1, 1), square(1, 0, 2, 1)}, 1, 1, 2},
+		{"contained", []planarPolygon{square(0, 0, 4, 4), square(1, 1, 2, 2)}, 1, 1, 16},
+		{"clockwise", []planarPolygon{{square(0, 0, 2, 2)[0].reversed()}}, 1, 1, 4},
+		{"frame", frame, 1, 2, 12},
+		{"corner", []planarPolygon{square(0, 0, 1, 1), square(1, 1, 2, 2)}, 2, 2, 2},
+		{"hole filled", []planarPolygon{
+			{{{0, 0}, {4, 0}, {4, 4}, {0, 4}}, {{1, 1}, {1, 3}, {3, 3}, {3, 1}}},
+			square(0.5, 0.5, 3.5, 3.5)}, 1, 1, 16},
+	} {
+		got := unionPolygons(tc.input)
+		numRings := 0
+		for _, poly := range got {
+			numRings += len(poly)
+		}
+		if len(got) != tc.numPolygons || numRings != tc.numRings || math.Abs(totalArea(got)-tc.area) > 1e-6 {
+			t.Errorf("%s: expected %d polygons with %d rings and area %g, got %d polygons with %d rings and area %g: %v",
+				tc.name, tc.numPolygons, tc.numRings, tc.area, len(got), numRings, totalArea(got), got)
+		}
+	}
+}
+
+func TestConvexHull(t *testing.T) {
+	got := convexHull([]planarPoint{{0, 0}, {2, 0}, {1, 1}, {2, 2}, {0, 2}, {1, 0}, {0, 0}})
+	if len(got) != 4 || got.signedArea() != 4 {
+		t.Errorf("expected counterclockwise square, got %v", got)
+	}
+	if got := convexHull([]planarPoint{{0, 0}, {1, 1}, {2, 2}}); len(got) != 2 {
+		t.Errorf("expected collinear points to give a segment, got %v", got)
+	}
+}
+
+func TestProcess(t *testing.T) {
+	index, s := makeServer(t)
+	defer s.Shutdown()
+	defer index.Close()
+
+	process := func(query string) (*httptest.ResponseRecorder, *geojson.Feature) {
+		req, _ := http.NewRequest("GET", "/collections/castles/process?"+query, nil)
+		resp := httptest.NewRecorder()
+		http.HandlerFunc(s.HandleRequest).ServeHTTP(resp, req)
+		if resp.Code != http.StatusOK {
+			return resp, nil
+		}
+		fc, err := geojson.UnmarshalFeatureCollection(resp.Body.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(fc.Features) != 1 {
+			t.Fatalf("GET %s: expected one feature, got %d", query, len(fc.Features))
+		}
+		return resp, fc.Features[0]
+	}
+
+	// A buffer of 100 meters around the castle of Pähl spans 200 meters.
+	if _, f := process("op=buffer&distance=100&ids=N34729562"); f == nil || f.Geometry.Type != geojson.GeometryPolygon {
+		t.Errorf("expected buffer to be a polygon, got %v", f)
+	} else {
+		bounds := computeBounds(f.Geometry)
+		height := bounds.Size().Lat.Degrees() * metersPerDegree
+		if math.Abs(height-200) > 1 {
+			t.Errorf("expected buffer to be 200 meters high, got %f", height)
+		}
+	}
+
+	if _, f := process("op=buffer&distance=5&ids=W418392510,W24785843"); f == nil || f.Geometry.Type != geojson.GeometryMultiPolygon {
+		t.Errorf("expected buffer of two distant features to be a multipolygon, got %v", f)
+	}
+
+	if _, f := process("op=convexhull"); f == nil || f.Geometry.Type != geojson.GeometryPolygon {
+		t.Errorf("expected convex hull to be a polygon, got %v", f)
+	} else if n := f.PropertyMustInt("numFeatures"); n != 3 {
+		t.Errorf("expected convex hull of 3 features, got %d", n)
+	}
+
+	if _, f := process("op=union&building=yes"); f == nil || f.Geometry.Type != geojson.GeometryPolygon {
+		t.Errorf("expected union of a polygon to be a polygon, got %v", f)
+	}
+
+	for _, query := range []string{
+		"op=union",
+		"op=nosuchop",
+		"op=buffer",
+		"op=buffer&distance=-1",
+		"op=buffer&distance=1e9",
+		"op=convexhull&bbox=1,2",
+	} {
+		if resp, _ := process(query); resp.Code != http.StatusBadRequest {
+			t.Errorf("GET %s: expected status 400, got %d", query, resp.Code)
+		}
+	}
+
+	req, _ := http.NewRequest("GET", "/collections/castles/process?op=convexhull&ids=N0", nil)
+	resp := httptest.NewRecorder()
+	http.HandlerFunc(s.HandleRequest).ServeHTTP(resp, req)
+	var empty struct {
+		Features []interface{} `json:"features"`
+	}
+	if err := json.Unmarshal(resp.Body.Bytes(), &empty); err != nil || len(empty.Features) != 0 {
+		t.Errorf("expected no features for empty input, got %s", getBody(resp))
+	}
+}
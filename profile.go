@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Profiles are variants of the same features for different classes of
+// clients, which share URLs but want differently sized payloads. Map
+// widgets may only need the minimal profile with ID, name and geometry;
+// tools built for OpenStreetMap data want the osm-compatible profile.
+// Clients select profiles by ?profile=minimal, or by content
+// negotiation with the Accept-Profile header, which takes profile URIs
+// such as <urn:miniwfs:profile:minimal>. Responses tell their profile
+// in the Content-Profile header, and unless they are in the default
+// profile, in a Link with rel="profile".
+// https://www.w3.org/TR/dx-prof-conneg/
+const (
+	ProfileFull          = "full"
+	ProfileMinimal       = "minimal"
+	ProfileOSMCompatible = "osm-compatible"
+)
+
+// Profiles lists the supported profiles; the first one is the default.
+var Profiles = []string{ProfileFull, ProfileMinimal, ProfileOSMCompatible}
+
+// profileURIPrefix is prepended to profile names to form their URIs.
+const profileURIPrefix = "urn:miniwfs:profile:"
+
+// negotiateProfile returns the profile of the response to an items
+// request. Unknown profiles in ?profile= are an error, for which we
+// write a response and return false. For unknown profiles in the
+// Accept-Profile header, clients get the default, as the W3C
+// recommendation suggests; they can tell from Content-Profile.
+func negotiateProfile(w http.ResponseWriter, req *http.Request) (string, bool) {
+	w.Header().Add("Vary", "Accept-Profile")
+	if p := req.URL.Query().Get("profile"); len(p) > 0 {
+		if !isProfile(p) {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, "unsupported profile; supported are "+strings.Join(Profiles, ", ")+"\n")
+			return "", false
+		}
+		return p, true
+	}
+	if p := parseAcceptProfile(req.Header.Get("Accept-Profile")); len(p) > 0 {
+		return p, true
+	}
+	return Profiles[0], true
+}
+
+func isProfile(name string) bool {
+	for _, p := range Profiles {
+		if p == name {
+			return true
+		}
+	}
+	return false
+}
+
+// parseAcceptProfile returns the supported profile with the highest
+// quality in an Accept-Profile header, or the empty string if there
+// is none. Among profiles of equal quality, the first one wins.
+func parseAcceptProfile(header string) string {
+	best, bestQ := "", 0.0
+	for _, item := range strings.Split(header, ",") {
+		parts := strings.Split(item, ";")
+		uri := strings.TrimSpace(parts[0])
+		uri = strings.TrimSuffix(strings.TrimPrefix(uri, "<"), ">")
+		name := strings.TrimPrefix(uri, profileURIPrefix)
+		if name == uri || !isProfile(name) {
+			continue
+		}
+		q := 1.0
+		for _, param := range parts[1:] {
+			kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+			if len(kv) == 2 && strings.TrimSpace(kv[0]) == "q" {
+				if v, err := strconv.ParseFloat(strings.TrimSpace(kv[1]), 64); err == nil {
+					q = v
+				}
+			}
+		}
+		if q > bestQ {
+			best, bestQ = name, q
+		}
+	}
+	return best
+}
+
+// setProfileHeaders tells clients which profile a response has. For
+// the default profile, Content-Profile is enough; this keeps the Link
+// header of most responses free for license links.
+func setProfileHeaders(header http.Header, profile string) {
+	uri := "<" + profileURIPrefix + profile + ">"
+	header.Set("Content-Profile", uri)
+	if profile != Profiles[0] {
+		header.Add("Link", uri+`; rel="profile"`)
+	}
+}
+
+// shapeProfile transforms an encoded GeoJSON Feature to a profile.
+func shapeProfile(profile string, feature []byte) ([]byte, error) {
+	switch profile {
+	case ProfileMinimal:
+		return shapeMinimalProfile(feature)
+	case ProfileOSMCompatible:
+		return shapeOSMProfile(feature)
+	default:
+		return feature, nil
+	}
+}
+
+// shapeMinimalProfile keeps the ID, name and geometry of a feature.
+func shapeMinimalProfile(feature []byte) ([]byte, error) {
+	var f struct {
+		ID         json.RawMessage `json:"id,omitempty"`
+		Type       string          `json:"type"`
+		Geometry   json.RawMessage `json:"geometry"`
+		Properties struct {
+			Name json.RawMessage `json:"name,omitempty"`
+		} `json:"properties"`
+	}
+	if err := json.Unmarshal(feature, &f); err != nil {
+		return nil, err
+	}
+	if len(f.Geometry) == 0 {
+		f.Geometry = json.RawMessage("null")
+	}
+	return json.Marshal(f)
+}
+
+var osmIDRegexp = regexp.MustCompile(`^([NWR])([1-9][0-9]*)$`)
+
+var osmTypes = map[string]string{"N": "node", "W": "way", "R": "relation"}
+
+// shapeOSMProfile shapes a feature like osmtogeojson does for data
+// from OpenStreetMap: IDs such as W123 become way/123, repeated in an
+// "@id" property, and properties become string-valued tags. Booleans
+// turn into "yes" and "no", null values get dropped, and arrays and
+// objects get encoded as JSON.
+func shapeOSMProfile(feature []byte) ([]byte, error) {
+	var members map[string]json.RawMessage
+	if err := json.Unmarshal(feature, &members); err != nil {
+		return nil, err
+	}
+	var props map[string]json.RawMessage
+	if raw, ok := members["properties"]; ok {
+		if err := json.Unmarshal(raw, &props); err != nil {
+			return nil, err
+		}
+	}
+
+	tags := make(map[string]string, len(props)+1)
+	for key, value := range props {
+		switch text := string(value); text {
+		case "null":
+		case "true":
+			tags[key] = "yes"
+		case "false":
+			tags[key] = "no"
+		default:
+			var s string
+			if json.Unmarshal(value, &s) == nil {
+				tags[key] = s
+			} else {
+				tags[key] = text
+			}
+		}
+	}
+
+	var id string
+	if raw, ok := members["id"]; ok && json.Unmarshal(raw, &id) == nil {
+		if m := osmIDRegexp.FindStringSubmatch(id); m != nil {
+			id = osmTypes[m[1]] + "/" + m[2]
+		}
+		tags["@id"] = id
+		members["id"], _ = json.Marshal(id)
+	}
+
+	encoded, err := json.Marshal(tags)
+	if err != nil {
+		return nil, err
+	}
+	members["properties"] = encoded
+	return json.Marshal(members)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestProfile(t *testing.T) {
+	index, s := makeServer(t)
+	defer s.Shutdown()
+	defer index.Close()
+
+	get := func(path string, acceptProfile string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", path, nil)
+		if len(acceptProfile) > 0 {
+			req.Header.Set("Accept-Profile", acceptProfile)
+		}
+		resp := httptest.NewRecorder()
+		http.HandlerFunc(s.HandleRequest).ServeHTTP(resp, req)
+		return resp
+	}
+
+	resp := get("/collections/castles/items/N34729562", "<urn:miniwfs:profile:minimal>")
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, resp.Code)
+	}
+	if cp := resp.Header().Get("Content-Profile"); cp != "<urn:miniwfs:profile:minimal>" {
+		t.Errorf("expected Content-Profile <urn:miniwfs:profile:minimal>, got %q", cp)
+	}
+	if link := resp.Header().Get("Link"); link != `<urn:miniwfs:profile:minimal>; rel="profile"` {
+		t.Errorf("expected Link to profile, got %q", link)
+	}
+	expectJSON(t, getBody(resp), `{
+		"id": "N34729562",
+		"type": "Feature",
+		"geometry": {"type": "Point", "coordinates": [11.183468, 47.910414]},
+		"properties": {"name": "Hochschloß Pähl"}
+	}`)
+
+	resp = get("/collections/castles/items?ids=N34729562&profile=osm-compatible", "")
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, resp.Code)
+	}
+	features, err := decodeRawFeatures([]byte(getBody(resp)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(features) != 1 || features[0].Feature.ID != "node/34729562" ||
+		features[0].Feature.Properties["@id"] != "node/34729562" ||
+		features[0].Feature.Properties["historic"] != "castle" {
+		t.Errorf("expected feature in osm-compatible profile, got %s", getBody(resp))
+	}
+
+	// Without profile, or with an unknown one in Accept-Profile, we
+	// serve the default.
+	for _, accept := range []string{"", "<http://example.org/unknown>"} {
+		resp = get("/collections/castles/items", accept)
+		if cp := resp.Header().Get("Content-Profile"); resp.Code != http.StatusOK || cp != "<urn:miniwfs:profile:full>" {
+			t.Errorf("Accept-Profile %q: expected full profile, got status %d and %q", accept, resp.Code, cp)
+		}
+	}
+
+	if resp := get("/collections/castles/items?profile=unknown", ""); resp.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d for unknown profile, got %d", http.StatusBadRequest, resp.Code)
+	}
+}
+
+func TestParseAcceptProfile(t *testing.T) {
+	for _, tc := range []struct{ header, expected string }{
+		{"", ""},
+		{"<urn:miniwfs:profile:minimal>", "minimal"},
+		{"urn:miniwfs:profile:minimal", "minimal"},
+		{"<http://example.org/p>, <urn:miniwfs:profile:osm-compatible>", "osm-compatible"},
+		{"<urn:miniwfs:profile:minimal>;q=0.5, <urn:miniwfs:profile:full>;q=0.9", "full"},
+		{"<urn:miniwfs:profile:minimal>, <urn:miniwfs:profile:full>", "minimal"},
+		{"<urn:miniwfs:profile:minimal>;q=0", ""},
+		{"<urn:miniwfs:profile:unknown>", ""},
+	} {
+		if got := parseAcceptProfile(tc.header); got != tc.expected {
+			t.Errorf("parseAcceptProfile(%q): expected %q, got %q", tc.header, tc.expected, got)
+		}
+	}
+}
+
+func TestShapeOSMProfile(t *testing.T) {
+	got, err := shapeOSMProfile([]byte(`{"type":"Feature","id":"W7","geometry":null,` +
+		`"properties":{"name":"Lake","depth":8.5,"fishing":true,"swimming":false,"note":null,"names":["a","b"]}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectJSON(t, string(got), `{
+		"geometry": null,
+		"id": "way/7",
+		"properties": {"@id": "way/7", "depth": "8.5", "fishing": "yes", "name": "Lake", "names": "[\"a\",\"b\"]", "swimming": "no"},
+		"type": "Feature"
+	}`)
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"container/list"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	numUpstreamRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "miniwfs_upstream_requests_total",
+		Help: "Total number of requests forwarded to the upstream server, by outcome.",
+	},
+		[]string{"outcome"})
+)
+
+// UpstreamProxy forwards requests for collections and features that we
+// do not have locally to an upstream OGC API Features server, such as
+// a legacy GeoServer that is being migrated to miniwfs. Successful
+// responses can optionally be cached for a while.
+type UpstreamProxy struct {
+	base   *url.URL
+	client *http.Client
+	ttl    time.Duration // zero if responses are not cached
+	cache  *responseCache
+}
+
+// forwardedRequestHeaders are passed on from clients to the upstream server.
+var forwardedRequestHeaders = []string{"Accept", "Accept-Language"}
+
+// forwardedResponseHeaders are passed on from the upstream server to clients.
+var forwardedResponseHeaders = []string{"Content-Type", "Content-Language", "Last-Modified", "ETag"}
+
+// MaxUpstreamResponseSize is the largest upstream response we forward.
+const MaxUpstreamResponseSize = 64 << 20
+
+func MakeUpstreamProxy(base *url.URL, cacheTTL time.Duration) *UpstreamProxy {
+	p := &UpstreamProxy{
+		base:   base,
+		client: &http.Client{Timeout: 60 * time.Second},
+		ttl:    cacheTTL,
+	}
+	if cacheTTL > 0 {
+		p.cache = newResponseCache(1000)
+	}
+	return p
+}
+
+// Forward sends the request to the upstream server and relays the
+// response to the client.
+func (p *UpstreamProxy) Forward(w http.ResponseWriter, req *http.Request) {
+	target := p.base.ResolveReference(&url.URL{
+		Path:     strings.TrimPrefix(req.URL.Path, "/"),
+		RawQuery: req.URL.RawQuery,
+	}).String()
+
+	if p.cache != nil {
+		if cached := p.cache.Get(target, time.Now()); cached != nil {
+			numUpstreamRequests.WithLabelValues("cached").Inc()
+			cached.WriteTo(w)
+			return
+		}
+	}
+
+	upstreamReq, err := http.NewRequest("GET", target, nil)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	for _, h := range forwardedRequestHeaders {
+		if v := req.Header.Get(h); len(v) > 0 {
+			upstreamReq.Header.Set(h, v)
+		}
+	}
+
+	resp, err := p.client.Do(upstreamReq)
+	if err != nil {
+		log.Printf("upstream request to %s failed: %v", target, err)
+		numUpstreamRequests.WithLabelValues("error").Inc()
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(nil, resp.Body, MaxUpstreamResponseSize))
+	if err != nil {
+		log.Printf("reading upstream response from %s failed: %v", target, err)
+		numUpstreamRequests.WithLabelValues("error").Inc()
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+
+	cached := &cachedResponse{
+		status:  resp.StatusCode,
+		header:  make(http.Header),
+		body:    body,
+		expires: time.Now().Add(p.ttl),
+	}
+	for _, h := range forwardedResponseHeaders {
+		if v := resp.Header.Get(h); len(v) > 0 {
+			cached.header.Set(h, v)
+		}
+	}
+	if p.cache != nil && resp.StatusCode == http.StatusOK {
+		p.cache.Put(target, cached)
+	}
+	numUpstreamRequests.WithLabelValues(strconv.Itoa(resp.StatusCode)).Inc()
+	cached.WriteTo(w)
+}
+
+type cachedResponse struct {
+	status  int
+	header  http.Header
+	body    []byte
+	expires time.Time
+}
+
+func (r *cachedResponse) WriteTo(w http.ResponseWriter) {
+	header := w.Header()
+	for key, values := range r.header {
+		header[key] = values
+	}
+	header.Set("Access-Control-Allow-Origin", "*")
+	header.Set("Content-Length", strconv.Itoa(len(r.body)))
+	w.WriteHeader(r.status)
+	w.Write(r.body)
+}
+
+// responseCache is a least-recently-used cache of upstream responses.
+type responseCache struct {
+	mutex   sync.Mutex
+	lru     list.List
+	content map[string]*list.Element
+	maxSize int
+}
+
+type responseCacheEntry struct {
+	key   string
+	value *cachedResponse
+}
+
+func newResponseCache(maxSize int) *responseCache {
+	return &responseCache{content: make(map[string]*list.Element), maxSize: maxSize}
+}
+
+func (c *responseCache) Get(key string, now time.Time) *cachedResponse {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	e, hit := c.content[key]
+	if !hit {
+		return nil
+	}
+	entry := e.Value.(*responseCacheEntry)
+	if now.After(entry.value.expires) {
+		c.lru.Remove(e)
+		delete(c.content, key)
+		return nil
+	}
+	c.lru.MoveToFront(e)
+	return entry.value
+}
+
+func (c *responseCache) Put(key string, value *cachedResponse) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if e, hit := c.content[key]; hit {
+		e.Value.(*responseCacheEntry).value = value
+		c.lru.MoveToFront(e)
+		return
+	}
+	c.content[key] = c.lru.PushFront(&responseCacheEntry{key, value})
+	if c.lru.Len() > c.maxSize {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.content, oldest.Value.(*responseCacheEntry).key)
+	}
+}
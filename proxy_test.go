@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestUpstreamProxy(t *testing.T) {
+	var requests []string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		requests = append(requests, req.URL.String())
+		if req.URL.Path != "/geoserver/ogc/collections/rivers/items" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/geo+json")
+		w.Write([]byte(`{"type":"FeatureCollection","features":[]}`))
+	}))
+	defer upstream.Close()
+
+	index, s := makeServer(t)
+	defer s.Shutdown()
+	defer index.Close()
+	base, _ := url.Parse(upstream.URL + "/geoserver/ogc/")
+	s.upstream = MakeUpstreamProxy(base, time.Minute)
+	handler := http.HandlerFunc(s.HandleRequest)
+
+	for i := 0; i < 2; i++ {
+		query, _ := http.NewRequest("GET", "/collections/rivers/items?limit=5", nil)
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, query)
+		if status := resp.Result().StatusCode; status != http.StatusOK {
+			t.Errorf("expected %d, got %d", http.StatusOK, status)
+		}
+		if ct := resp.Header().Get("Content-Type"); ct != "application/geo+json" {
+			t.Errorf("Expected Content-Type: application/geo+json, got %s", ct)
+		}
+		expectCORSHeader(t, resp.Header())
+		expectJSON(t, getBody(resp), `{"type":"FeatureCollection","features":[]}`)
+	}
+	if len(requests) != 1 || requests[0] != "/geoserver/ogc/collections/rivers/items?limit=5" {
+		t.Errorf("expected one upstream request due to caching, got %v", requests)
+	}
+
+	query, _ := http.NewRequest("GET", "/collections/lakes/items/unknown-id", nil)
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, query)
+	if status := resp.Result().StatusCode; status != http.StatusNotFound {
+		t.Errorf("expected upstream status %d, got %d", http.StatusNotFound, status)
+	}
+	if len(requests) != 2 {
+		t.Errorf("expected unknown item to be forwarded, got %v", requests)
+	}
+
+	query, _ = http.NewRequest("GET", "/collections/lakes/items/N123", nil)
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, query)
+	if status := resp.Result().StatusCode; status != http.StatusOK || len(requests) != 2 {
+		t.Errorf("expected local item to be served locally, got %d and %v", status, requests)
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// QueryablesRel is the link relation from a collection to its
+// queryables, as specified by OGC API Features Part 3.
+const QueryablesRel = "http://www.opengis.net/def/rel/ogc/1.0/queryables"
+
+// propertyTypes tells the JSON Schema type of each feature property,
+// such as "name" -> "string", for listing the queryables of a
+// collection. Properties whose values differ in type map to "".
+type propertyTypes map[string]string
+
+func (p propertyTypes) add(properties map[string]interface{}) {
+	for key, value := range properties {
+		var t string
+		switch value.(type) {
+		case nil:
+			continue
+		case string:
+			t = "string"
+		case float64:
+			t = "number"
+		case bool:
+			t = "boolean"
+		case []interface{}:
+			t = "array"
+		case map[string]interface{}:
+			t = "object"
+		}
+		if old, ok := p[key]; ok && old != t {
+			t = ""
+		}
+		p[key] = t
+	}
+}
+
+// GetQueryables returns the types of the properties that filters can
+// refer to, keyed by property name.
+func (index *Index) GetQueryables(collection string) (map[string]string, CollectionMetadata, error) {
+	index.mutex.RLock()
+	defer index.mutex.RUnlock()
+
+	coll := index.Collections[collection]
+	if coll == nil {
+		return nil, CollectionMetadata{}, NotFound
+	}
+
+	result := make(map[string]string, len(coll.propertyTypes))
+	for key, t := range coll.propertyTypes {
+		result[key] = t
+	}
+	return result, coll.metadata, nil
+}
+
+// handleQueryablesRequest describes the properties that can be used
+// in filter expressions, as a JSON Schema. Clients such as QGIS offer
+// them in their filter editors. Since any property can be filtered,
+// the schema allows additional properties.
+func (s *WebServer) handleQueryablesRequest(w http.ResponseWriter, req *http.Request, collection string) {
+	if !s.authorize(w, req, collection) {
+		return
+	}
+	if len(s.negotiate(w, req, "json")) == 0 {
+		return
+	}
+
+	types, metadata, err := s.index.GetQueryables(collection)
+	if err != nil {
+		w.WriteHeader(getHTTPStatus(err))
+		return
+	}
+
+	// Canaries get described under the name of their collection,
+	// since clients cannot reach them under their own.
+	name := collection
+	if s.isCanary(collection) {
+		name = strings.TrimSuffix(collection, CanarySuffix)
+	}
+	properties := map[string]interface{}{
+		"geometry": map[string]string{"format": "geometry-any"},
+	}
+	for key, t := range types {
+		schema := map[string]string{"title": key}
+		if len(t) > 0 {
+			schema["type"] = t
+		}
+		properties[key] = schema
+	}
+
+	encoded, err := json.Marshal(map[string]interface{}{
+		"$schema":              "https://json-schema.org/draft/2019-09/schema",
+		"$id":                  s.publicPath(req) + "collections/" + url.PathEscape(name) + "/queryables",
+		"type":                 "object",
+		"title":                name,
+		"properties":           properties,
+		"additionalProperties": true,
+	})
+	if err != nil {
+		httpLog.Error("json.Marshal failed", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Content-Type", "application/schema+json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(encoded)))
+	w.Header().Set("Last-Modified", metadata.LastModified.UTC().Format(http.TimeFormat))
+	w.WriteHeader(http.StatusOK)
+	w.Write(encoded)
+}
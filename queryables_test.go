@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestQueryables(t *testing.T) {
+	index, s := makeServer(t)
+	defer s.Shutdown()
+	defer index.Close()
+
+	req, _ := http.NewRequest("GET", "/collections/castles/queryables", nil)
+	resp := httptest.NewRecorder()
+	http.HandlerFunc(s.HandleRequest).ServeHTTP(resp, req)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", resp.Code)
+	}
+	if ct := resp.Header().Get("Content-Type"); ct != "application/schema+json" {
+		t.Errorf("expected Content-Type application/schema+json, got %q", ct)
+	}
+	expectJSON(t, getBody(resp), `{
+		"$id": "https://test.example.org/wfs/collections/castles/queryables",
+		"$schema": "https://json-schema.org/draft/2019-09/schema",
+		"additionalProperties": true,
+		"properties": {
+			"barrier": {"title": "barrier", "type": "string"},
+			"building": {"title": "building", "type": "string"},
+			"geometry": {"format": "geometry-any"},
+			"historic": {"title": "historic", "type": "string"},
+			"name": {"title": "name", "type": "string"},
+			"wikidata": {"title": "wikidata", "type": "string"},
+			"wikipedia": {"title": "wikipedia", "type": "string"}
+		},
+		"title": "castles",
+		"type": "object"
+	}`)
+
+	req, _ = http.NewRequest("GET", "/collections/nosuchcollection/queryables", nil)
+	resp = httptest.NewRecorder()
+	http.HandlerFunc(s.HandleRequest).ServeHTTP(resp, req)
+	if resp.Code != http.StatusNotFound {
+		t.Errorf("expected status 404 for unknown collection, got %d", resp.Code)
+	}
+}
+
+func TestPropertyTypes(t *testing.T) {
+	types := make(propertyTypes)
+	types.add(map[string]interface{}{"name": "A", "ele": 12.0, "open": true, "ref": "7", "note": nil})
+	types.add(map[string]interface{}{"name": "B", "ele": 13.0, "ref": 7.0, "note": "x"})
+	got, _ := json.Marshal(types)
+	expectJSON(t, string(got), `{"ele": "number", "name": "string", "note": "string", "open": "boolean", "ref": ""}`)
+}
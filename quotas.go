@@ -0,0 +1,217 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	numQuotaRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "miniwfs_apikey_requests_total",
+		Help: "Total number of requests per API key fingerprint, by outcome.",
+	},
+		[]string{"key", "outcome"})
+	numQuotaBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "miniwfs_apikey_response_bytes_total",
+		Help: "Total number of response bytes per API key fingerprint.",
+	},
+		[]string{"key"})
+)
+
+// QuotaLimits are the usage limits for one API key. Zero means unlimited.
+// Days and months are calendar periods in UTC.
+type QuotaLimits struct {
+	DailyRequests   int64
+	MonthlyRequests int64
+	DailyBytes      int64
+	MonthlyBytes    int64
+}
+
+// QuotaUsage is the usage of one API key in the current day and month.
+type QuotaUsage struct {
+	Day           string `json:"day"`   // such as "2019-03-28"
+	Month         string `json:"month"` // such as "2019-03"
+	DayRequests   int64  `json:"dayRequests"`
+	DayBytes      int64  `json:"dayBytes"`
+	MonthRequests int64  `json:"monthRequests"`
+	MonthBytes    int64  `json:"monthBytes"`
+}
+
+// QuotaTracker keeps track of API key usage in memory. Keys are only
+// stored as fingerprints, so neither metrics nor the persisted state
+// file reveal the secret keys.
+type QuotaTracker struct {
+	limits QuotaLimits
+	mutex  sync.Mutex
+	usage  map[string]*QuotaUsage // keyed by fingerprint
+}
+
+func MakeQuotaTracker(limits QuotaLimits) *QuotaTracker {
+	return &QuotaTracker{limits: limits, usage: make(map[string]*QuotaUsage)}
+}
+
+// keyFingerprint returns a short, non-secret identifier for an API key.
+func keyFingerprint(key string) string {
+	h := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(h[:4])
+}
+
+// Allow checks whether a request with an API key is within quota, and
+// counts it if it is. Quota headers are set on the response either way.
+func (q *QuotaTracker) Allow(key string, header http.Header, now time.Time) bool {
+	fp := keyFingerprint(key)
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	u := q.getUsage(fp, now)
+	exceeded := exceedsLimit(u.DayRequests, q.limits.DailyRequests) ||
+		exceedsLimit(u.MonthRequests, q.limits.MonthlyRequests) ||
+		exceedsLimit(u.DayBytes, q.limits.DailyBytes) ||
+		exceedsLimit(u.MonthBytes, q.limits.MonthlyBytes)
+	if !exceeded {
+		u.DayRequests++
+		u.MonthRequests++
+	}
+	q.setHeaders(u, header, now)
+
+	if exceeded {
+		numQuotaRequests.WithLabelValues(fp, "rejected").Inc()
+		return false
+	}
+	numQuotaRequests.WithLabelValues(fp, "accepted").Inc()
+	return true
+}
+
+// RecordBytes adds the size of a response to the usage of an API key.
+// Byte quotas are thus enforced on the request after the one that
+// crossed the limit.
+func (q *QuotaTracker) RecordBytes(key string, numBytes int64, now time.Time) {
+	fp := keyFingerprint(key)
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	u := q.getUsage(fp, now)
+	u.DayBytes += numBytes
+	u.MonthBytes += numBytes
+	numQuotaBytes.WithLabelValues(fp).Add(float64(numBytes))
+}
+
+// getUsage returns the usage record for a key fingerprint, resetting
+// the counters of periods that have ended. Must be called with the
+// mutex held.
+func (q *QuotaTracker) getUsage(fp string, now time.Time) *QuotaUsage {
+	now = now.UTC()
+	day, month := now.Format("2006-01-02"), now.Format("2006-01")
+	u := q.usage[fp]
+	if u == nil {
+		u = &QuotaUsage{}
+		q.usage[fp] = u
+	}
+	if u.Day != day {
+		u.Day, u.DayRequests, u.DayBytes = day, 0, 0
+	}
+	if u.Month != month {
+		u.Month, u.MonthRequests, u.MonthBytes = month, 0, 0
+	}
+	return u
+}
+
+// setHeaders reports the most constraining request quota in the
+// X-RateLimit-Limit, X-RateLimit-Remaining and X-RateLimit-Reset
+// (seconds) headers, and byte quotas in X-RateLimit-Bytes-Remaining.
+func (q *QuotaTracker) setHeaders(u *QuotaUsage, header http.Header, now time.Time) {
+	now = now.UTC()
+	dayEnd := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+	monthEnd := time.Date(now.Year(), now.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+
+	limit, remaining, reset := int64(-1), int64(-1), time.Time{}
+	consider := func(used, max int64, end time.Time) {
+		if max <= 0 {
+			return
+		}
+		left := max - used
+		if left < 0 {
+			left = 0
+		}
+		if remaining < 0 || left < remaining {
+			limit, remaining, reset = max, left, end
+		}
+	}
+	consider(u.DayRequests, q.limits.DailyRequests, dayEnd)
+	consider(u.MonthRequests, q.limits.MonthlyRequests, monthEnd)
+	if remaining >= 0 {
+		header.Set("X-RateLimit-Limit", strconv.FormatInt(limit, 10))
+		header.Set("X-RateLimit-Remaining", strconv.FormatInt(remaining, 10))
+		header.Set("X-RateLimit-Reset", strconv.FormatInt(int64(reset.Sub(now).Seconds()), 10))
+	}
+
+	remaining = -1
+	consider(u.DayBytes, q.limits.DailyBytes, dayEnd)
+	consider(u.MonthBytes, q.limits.MonthlyBytes, monthEnd)
+	if remaining >= 0 {
+		header.Set("X-RateLimit-Bytes-Remaining", strconv.FormatInt(remaining, 10))
+	}
+}
+
+func exceedsLimit(used, max int64) bool {
+	return max > 0 && used >= max
+}
+
+// Load reads the usage state that was written by Save. A missing
+// file is not an error, so the tracker can start from scratch.
+func (q *QuotaTracker) Load(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	usage := make(map[string]*QuotaUsage)
+	if err := json.Unmarshal(data, &usage); err != nil {
+		return err
+	}
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.usage = usage
+	return nil
+}
+
+// Save writes the usage state to a file, atomically replacing any
+// previous state.
+func (q *QuotaTracker) Save(path string) error {
+	q.mutex.Lock()
+	data, err := json.Marshal(q.usage)
+	q.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// byteCountingResponseWriter counts the bytes written to a response,
+// so they can be charged against byte quotas.
+type byteCountingResponseWriter struct {
+	http.ResponseWriter
+	numBytes int64
+}
+
+func (w *byteCountingResponseWriter) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	w.numBytes += int64(n)
+	return n, err
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestQuotaTracker_Requests(t *testing.T) {
+	q := MakeQuotaTracker(QuotaLimits{DailyRequests: 2, MonthlyRequests: 3})
+	day1 := time.Date(2019, 3, 28, 23, 0, 0, 0, time.UTC)
+	day2 := day1.Add(2 * time.Hour)
+
+	header := make(http.Header)
+	if !q.Allow("key", header, day1) {
+		t.Error("expected first request to be allowed")
+	}
+	if got := header.Get("X-RateLimit-Remaining"); got != "1" {
+		t.Errorf("expected X-RateLimit-Remaining: 1, got %s", got)
+	}
+	if got := header.Get("X-RateLimit-Reset"); got != "3600" {
+		t.Errorf("expected X-RateLimit-Reset: 3600, got %s", got)
+	}
+	q.Allow("key", header, day1)
+	if q.Allow("key", header, day1) {
+		t.Error("expected third request on the same day to be rejected")
+	}
+	if got := header.Get("X-RateLimit-Remaining"); got != "0" {
+		t.Errorf("expected X-RateLimit-Remaining: 0, got %s", got)
+	}
+	if !q.Allow("other-key", make(http.Header), day1) {
+		t.Error("expected quotas to be tracked per key")
+	}
+
+	// On the next day, the daily quota is reset but the monthly one is not.
+	header = make(http.Header)
+	if !q.Allow("key", header, day2) {
+		t.Error("expected request on the next day to be allowed")
+	}
+	if got := header.Get("X-RateLimit-Limit"); got != "3" {
+		t.Errorf("expected monthly X-RateLimit-Limit: 3, got %s", got)
+	}
+	if q.Allow("key", header, day2) {
+		t.Error("expected request beyond monthly quota to be rejected")
+	}
+}
+
+func TestQuotaTracker_Bytes(t *testing.T) {
+	q := MakeQuotaTracker(QuotaLimits{DailyBytes: 100})
+	now := time.Date(2019, 3, 28, 12, 0, 0, 0, time.UTC)
+	header := make(http.Header)
+	q.Allow("key", header, now)
+	if got := header.Get("X-RateLimit-Bytes-Remaining"); got != "100" {
+		t.Errorf("expected X-RateLimit-Bytes-Remaining: 100, got %s", got)
+	}
+	q.RecordBytes("key", 150, now)
+	if q.Allow("key", header, now) {
+		t.Error("expected request beyond byte quota to be rejected")
+	}
+	if got := header.Get("X-RateLimit-Bytes-Remaining"); got != "0" {
+		t.Errorf("expected X-RateLimit-Bytes-Remaining: 0, got %s", got)
+	}
+}
+
+func TestQuotaTracker_Persistence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "miniwfs-quotas")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "quotas.json")
+	now := time.Date(2019, 3, 28, 12, 0, 0, 0, time.UTC)
+	limits := QuotaLimits{DailyRequests: 1}
+
+	q := MakeQuotaTracker(limits)
+	if err := q.Load(path); err != nil {
+		t.Fatalf("expected missing state file to be ignored, got %v", err)
+	}
+	q.Allow("secret-key", make(http.Header), now)
+	if err := q.Save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	data, _ := ioutil.ReadFile(path)
+	if len(data) == 0 || bytes.Contains(data, []byte("secret-key")) {
+		t.Errorf("expected state to store key fingerprints only, got %s", data)
+	}
+
+	restored := MakeQuotaTracker(limits)
+	if err := restored.Load(path); err != nil {
+		t.Fatal(err)
+	}
+	if restored.Allow("secret-key", make(http.Header), now) {
+		t.Error("expected restored usage to count against quota")
+	}
+}
+
+func TestQuotas_WebServer(t *testing.T) {
+	index, s := makeServer(t)
+	defer s.Shutdown()
+	defer index.Close()
+	s.access = MakeAccessControl([]string{"key"}, nil, nil)
+	s.quotas = MakeQuotaTracker(QuotaLimits{DailyRequests: 1})
+
+	statuses := make([]int, 0, 3)
+	for i := 0; i < 2; i++ {
+		query, _ := http.NewRequest("GET", "/collections/lakes/items/N123", nil)
+		query.Header.Set("X-API-Key", "key")
+		resp := httptest.NewRecorder()
+		http.HandlerFunc(s.HandleRequest).ServeHTTP(resp, query)
+		statuses = append(statuses, resp.Code)
+	}
+
+	// Requests without API key are not subject to quotas.
+	query, _ := http.NewRequest("GET", "/collections/lakes/items/N123", nil)
+	resp := httptest.NewRecorder()
+	http.HandlerFunc(s.HandleRequest).ServeHTTP(resp, query)
+	statuses = append(statuses, resp.Code)
+
+	if statuses[0] != 200 || statuses[1] != 429 || statuses[2] != 200 {
+		t.Errorf("expected statuses [200 429 200], got %v", statuses)
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"strings"
+
+	"github.com/golang/geo/s2"
+	"github.com/paulmach/go.geojson"
+)
+
+// RegionTagger assigns ISO 3166 codes to features when their collection
+// gets loaded, so clients can split data by country or region with
+// property filters such as ?country=IT. The boundaries come from a
+// GeoJSON file whose areas carry the OpenStreetMap tags "ISO3166-1",
+// such as "IT", or "ISO3166-2", such as "IT-34".
+type RegionTagger struct {
+	regions     []taggedRegion
+	collections map[string]bool // nil for all collections
+}
+
+type taggedRegion struct {
+	country string // such as "IT"
+	region  string // such as "IT-34", or empty for countries
+	area    *s2.Polygon
+	bound   s2.Rect
+}
+
+// Properties set by RegionTagger. Features that already have them
+// keep their values.
+const (
+	countryProperty = "country"
+	regionProperty  = "region"
+)
+
+var MalformedRegionBoundaries error = errors.New("malformed region boundaries; expected a GeoJSON FeatureCollection with areas tagged ISO3166-1 or ISO3166-2")
+
+// ReadRegionTagger reads region boundaries from a GeoJSON file.
+func ReadRegionTagger(path string, collections []string) (*RegionTagger, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	fc, err := geojson.UnmarshalFeatureCollection(data)
+	if err != nil {
+		return nil, MalformedRegionBoundaries
+	}
+
+	t := &RegionTagger{}
+	for _, f := range fc.Features {
+		country, _ := f.Properties["ISO3166-1"].(string)
+		region, _ := f.Properties["ISO3166-2"].(string)
+		if len(country) == 0 && len(region) > 0 {
+			country = strings.SplitN(region, "-", 2)[0]
+		}
+		area := polygonFromGeometry(f.Geometry)
+		if len(country) == 0 || area == nil {
+			return nil, MalformedRegionBoundaries
+		}
+		t.regions = append(t.regions, taggedRegion{country, region, area, area.RectBound()})
+	}
+	if len(collections) > 0 {
+		t.collections = make(map[string]bool, len(collections))
+		for _, c := range collections {
+			t.collections[c] = true
+		}
+	}
+	return t, nil
+}
+
+// appliesTo returns true if a collection gets tagged.
+func (t *RegionTagger) appliesTo(collection string) bool {
+	return t != nil && (t.collections == nil || t.collections[collection])
+}
+
+// Enrich sets the country and region properties of features, taking
+// the first vertex of their geometry as representative location.
+func (t *RegionTagger) Enrich(collection string, features []*geojson.Feature) {
+	tagged := 0
+	for _, f := range features {
+		if f.Geometry == nil {
+			continue
+		}
+		var location []float64
+		forEachCoord(f.Geometry, func(c []float64) {
+			if location == nil && len(c) >= 2 {
+				location = c
+			}
+		})
+		if location == nil {
+			continue
+		}
+		country, region := t.lookup(s2.LatLngFromDegrees(location[1], location[0]))
+		if len(country) == 0 {
+			continue
+		}
+		if f.Properties == nil {
+			f.Properties = make(map[string]interface{})
+		}
+		if _, ok := f.Properties[countryProperty]; !ok {
+			f.Properties[countryProperty] = country
+		}
+		if _, ok := f.Properties[regionProperty]; !ok && len(region) > 0 {
+			f.Properties[regionProperty] = region
+		}
+		tagged++
+	}
+	loaderLog.Info("tagged countries and regions", "collection", collection,
+		"features", tagged, "untagged", len(features)-tagged)
+}
+
+// lookup returns the country and region containing a location. If the
+// boundaries have no region there, region is empty.
+func (t *RegionTagger) lookup(loc s2.LatLng) (country string, region string) {
+	p := s2.PointFromLatLng(loc)
+	for _, r := range t.regions {
+		if (len(region) > 0 || len(r.region) == 0) && len(country) > 0 {
+			continue // already known
+		}
+		if !r.bound.ContainsLatLng(loc) || !r.area.ContainsPoint(p) {
+			continue
+		}
+		if len(r.region) > 0 && len(region) == 0 {
+			country, region = r.country, r.region
+		} else if len(country) == 0 {
+			country = r.country
+		}
+	}
+	return country, region
+}
+
+// SetRegionTagger configures how to tag features with countries and
+// regions when loading collections, or disables it if t is nil. The
+// affected collections get reloaded right away.
+func (index *Index) SetRegionTagger(t *RegionTagger) {
+	index.mutex.Lock()
+	old := index.regions
+	index.regions = t
+	index.mutex.Unlock()
+
+	index.reloadCollections(func(name string) bool {
+		return t.appliesTo(name) || old.appliesTo(name)
+	})
+}
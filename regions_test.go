@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/golang/geo/s2"
+)
+
+func TestRegionTagger_Lookup(t *testing.T) {
+	tagger, err := ReadRegionTagger(filepath.Join("testdata", "regions.geojson"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		lng, lat        float64
+		country, region string
+	}{
+		{10.6848117, 45.6076336, "IT", "IT-34"},
+		{11.122, 46.067, "IT", "IT-32"},
+		{12.5, 41.9, "IT", ""},
+		{11.183468, 47.910414, "DE", ""},
+		{8.5, 47.43, "", ""},
+	} {
+		country, region := tagger.lookup(s2.LatLngFromDegrees(tc.lat, tc.lng))
+		if country != tc.country || region != tc.region {
+			t.Errorf("%g,%g: expected %q %q, got %q %q", tc.lng, tc.lat, tc.country, tc.region, country, region)
+		}
+	}
+}
+
+func TestReadRegionTagger_Malformed(t *testing.T) {
+	for _, content := range []string{
+		`not json`,
+		`{"type":"FeatureCollection","features":[{"type":"Feature","properties":{"ISO3166-1":"IT"},` +
+			`"geometry":{"type":"Point","coordinates":[12,42]}}]}`,
+		`{"type":"FeatureCollection","features":[{"type":"Feature","properties":{"name":"Italy"},` +
+			`"geometry":{"type":"Polygon","coordinates":[[[6,36],[18,36],[18,47],[6,36]]]}}]}`,
+	} {
+		file, _ := ioutil.TempFile("", "regions-*.geojson")
+		file.WriteString(content)
+		file.Close()
+		defer os.Remove(file.Name())
+		if _, err := ReadRegionTagger(file.Name(), nil); err != MalformedRegionBoundaries {
+			t.Errorf("expected MalformedRegionBoundaries for %s, got %v", content, err)
+		}
+	}
+}
+
+func TestIndex_SetRegionTagger(t *testing.T) {
+	index, s := makeServer(t)
+	defer s.Shutdown()
+	defer index.Close()
+
+	tagger, err := ReadRegionTagger(filepath.Join("testdata", "regions.geojson"), []string{"castles"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	index.SetRegionTagger(tagger)
+
+	for url, expected := range map[string]string{
+		"/collections/castles/items?country=IT":   "W418392510 W24785843",
+		"/collections/castles/items?country=DE":   "N34729562",
+		"/collections/castles/items?region=IT-34": "W418392510",
+		"/collections/castles/items?country=AT":   "",
+	} {
+		req, _ := http.NewRequest("GET", url, nil)
+		resp := httptest.NewRecorder()
+		http.HandlerFunc(s.HandleRequest).ServeHTTP(resp, req)
+		var got WFSFeatureCollection
+		if err := json.Unmarshal(resp.Body.Bytes(), &got); err != nil {
+			t.Fatal(err)
+		}
+		ids := make([]string, len(got.Features))
+		for i, f := range got.Features {
+			ids[i] = f.ID.(string)
+		}
+		if strings.Join(ids, " ") != expected {
+			t.Errorf("GET %s: expected %q, got %q", url, expected, strings.Join(ids, " "))
+		}
+	}
+
+	if f, _ := index.GetItem("lakes", "N123"); f == nil || f.Properties["country"] != nil {
+		t.Errorf("expected lakes to stay untagged, got %v", f)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ReloadResult tells what happened to a collection when reloading it
+// on request of an admin.
+type ReloadResult struct {
+	Collection string `json:"collection"`
+	Status     string `json:"status"` // "reloaded", "unchanged" or "failed"
+	Error      string `json:"error,omitempty"`
+}
+
+// handleReloadRequest reloads collections whose source has changed,
+// without waiting for file notifications or the refresh ticker. This
+// lets deployment pipelines that replace files atomically know when
+// the new data is being served. POST /admin/reload checks all
+// collections; ?collection=lakes only checks one. With ?force=true,
+// collections get reloaded even if their source looks unmodified.
+// If any reload fails, the response status is 500, and the body
+// tells which collections failed.
+func (s *WebServer) handleReloadRequest(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.authorizeWrite(w, req) {
+		return
+	}
+
+	params := req.URL.Query()
+	force := params.Get("force") == "true"
+	collection := params.Get("collection")
+	var reload []CollectionMetadata
+	for _, md := range s.index.GetCollections() {
+		if len(collection) == 0 || md.Name == collection {
+			reload = append(reload, md)
+		}
+	}
+	if len(collection) > 0 && len(reload) == 0 {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusNotFound)
+		io.WriteString(w, "collection not found\n")
+		return
+	}
+
+	status := http.StatusOK
+	results := make([]ReloadResult, 0, len(reload))
+	for _, md := range reload {
+		if force {
+			md.LastModified = time.Time{}
+		}
+		result := ReloadResult{Collection: md.Name, Status: "unchanged"}
+		if reloaded, err := s.index.reloadIfChanged(md); err != nil {
+			result.Status, result.Error = "failed", err.Error()
+			status = http.StatusInternalServerError
+		} else if reloaded {
+			result.Status = "reloaded"
+		}
+		results = append(results, result)
+	}
+
+	encoded, err := json.Marshal(struct {
+		Collections []ReloadResult `json:"collections"`
+	}{results})
+	if err != nil {
+		httpLog.Error("json.Marshal failed", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(encoded)))
+	w.WriteHeader(status)
+	w.Write(encoded)
+}
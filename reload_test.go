@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestReload(t *testing.T) {
+	index, s, path, cleanup := makeUploadServer(t)
+	defer cleanup()
+
+	type results struct {
+		Collections []ReloadResult `json:"collections"`
+	}
+	reload := func(query string, expectedStatus int) results {
+		resp := upload(s, "/admin/reload"+query, nil)
+		if resp.Code != expectedStatus {
+			t.Fatalf("POST /admin/reload%s: expected status %d, got %d", query, expectedStatus, resp.Code)
+		}
+		var r results
+		if err := json.Unmarshal(resp.Body.Bytes(), &r); err != nil {
+			t.Fatal(err)
+		}
+		return r
+	}
+
+	r := reload("", http.StatusOK)
+	if len(r.Collections) != 1 || r.Collections[0] != (ReloadResult{Collection: "lakes", Status: "unchanged"}) {
+		t.Errorf("expected lakes to be unchanged, got %+v", r)
+	}
+
+	// Forcing makes the reload independent of the file watcher, which
+	// might have picked up the change before our request.
+	content := `{"type":"FeatureCollection","features":[
+		{"type":"Feature","id":"N7","properties":{"name":"Greifensee"},"geometry":null}]}`
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	r = reload("?collection=lakes&force=true", http.StatusOK)
+	if len(r.Collections) != 1 || r.Collections[0].Status != "reloaded" {
+		t.Errorf("expected lakes to be reloaded, got %+v", r)
+	}
+	if f, _ := index.GetItem("lakes", "N7"); f == nil {
+		t.Error("expected new data to be served after reload")
+	}
+
+	if err := ioutil.WriteFile(path, []byte("garbage"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	r = reload("?force=true", http.StatusInternalServerError)
+	if len(r.Collections) != 1 || r.Collections[0].Status != "failed" || len(r.Collections[0].Error) == 0 {
+		t.Errorf("expected reload of lakes to fail, got %+v", r)
+	}
+	if f, _ := index.GetItem("lakes", "N7"); f == nil {
+		t.Error("expected previous data to be served after failed reload")
+	}
+
+	if resp := upload(s, "/admin/reload?collection=nosuchcollection", nil); resp.Code != http.StatusNotFound {
+		t.Errorf("expected status %d for unknown collection, got %d", http.StatusNotFound, resp.Code)
+	}
+
+	req, _ := http.NewRequest("POST", "/admin/reload?force=true", nil)
+	resp := httptest.NewRecorder()
+	http.HandlerFunc(s.HandleRequest).ServeHTTP(resp, req)
+	if resp.Code != http.StatusUnauthorized {
+		t.Errorf("expected status %d without API key, got %d", http.StatusUnauthorized, resp.Code)
+	}
+
+	req, _ = http.NewRequest("GET", "/admin/reload", nil)
+	resp = httptest.NewRecorder()
+	http.HandlerFunc(s.HandleRequest).ServeHTTP(resp, req)
+	if resp.Code != http.StatusMethodNotAllowed || resp.Header().Get("Allow") != "POST" {
+		t.Errorf("expected GET to be rejected, got status %d", resp.Code)
+	}
+
+	index.SetMaintenanceMode(MaintenanceMode{Enabled: true})
+	if resp := upload(s, "/admin/reload", nil); resp.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected status %d during maintenance, got %d", http.StatusServiceUnavailable, resp.Code)
+	}
+}
+
+func TestReload_NotOnPublicListener(t *testing.T) {
+	_, s, _, cleanup := makeUploadServer(t)
+	defer cleanup()
+	s.admin = false
+	if resp := upload(s, "/admin/reload", nil); resp.Code != http.StatusNotFound {
+		t.Errorf("expected status %d on public listener, got %d", http.StatusNotFound, resp.Code)
+	}
+}
@@ -0,0 +1,344 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Resumable uploads let clients send large datasets in chunks over
+// unreliable connections, loosely following the tus protocol:
+//
+//	POST   /admin/collections/{name}/uploads  start an upload session
+//	HEAD   /admin/uploads/{id}                ask for the Upload-Offset to resume from
+//	PATCH  /admin/uploads/{id}                append a chunk at Upload-Offset
+//	POST   /admin/uploads/{id}                finish, verifying Upload-Checksum: sha256 <hex>
+//	DELETE /admin/uploads/{id}                abort
+//
+// Chunks are appended to a temporary file next to the collection
+// source. When finished, the data is checked against the checksum and
+// installed just like a single-request upload.
+
+// MaxResumableUploadSize limits the size of resumable uploads, in bytes.
+const MaxResumableUploadSize = 64 * 1024 * 1024 * 1024
+
+// uploadSessionTimeout is how long an idle upload session is kept.
+const uploadSessionTimeout = 24 * time.Hour
+
+var UploadSessionNotFound error = errors.New("upload session not found")
+var UploadChecksumMismatch error = errors.New("upload checksum mismatch")
+
+// UploadOffsetMismatch is returned when a client sends a chunk for an
+// offset other than the current size of the upload.
+type UploadOffsetMismatch struct {
+	Offset int64
+}
+
+func (e *UploadOffsetMismatch) Error() string {
+	return "upload is at offset " + strconv.FormatInt(e.Offset, 10)
+}
+
+type uploadSession struct {
+	mutex      sync.Mutex
+	collection string
+	path       string // source path of the collection
+	file       *os.File
+	offset     int64
+	hash       hash.Hash // sha256 of the data received so far
+	lastActive time.Time // guarded by the mutex of UploadSessions
+}
+
+// UploadSessions keeps track of the resumable uploads in progress.
+type UploadSessions struct {
+	mutex    sync.Mutex
+	sessions map[string]*uploadSession
+}
+
+// StartUpload creates a session for uploading a collection in chunks,
+// returning the session ID.
+func (index *Index) StartUpload(collection string, now time.Time) (string, error) {
+	path, err := index.getUploadPath(collection)
+	if err != nil {
+		return "", err
+	}
+	var idBytes [16]byte
+	if _, err := rand.Read(idBytes[:]); err != nil {
+		return "", err
+	}
+	id := hex.EncodeToString(idBytes[:])
+
+	file, err := createUploadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	u := &index.uploads
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	u.expire(now)
+	if u.sessions == nil {
+		u.sessions = make(map[string]*uploadSession)
+	}
+	u.sessions[id] = &uploadSession{
+		collection: collection,
+		path:       path,
+		file:       file,
+		hash:       sha256.New(),
+		lastActive: now,
+	}
+	return id, nil
+}
+
+// expire removes idle sessions. Must be called with the mutex held.
+func (u *UploadSessions) expire(now time.Time) {
+	for id, session := range u.sessions {
+		if now.Sub(session.lastActive) > uploadSessionTimeout {
+			httpLog.Info("upload session has expired", "session", id, "collection", session.collection)
+			session.discard()
+			delete(u.sessions, id)
+		}
+	}
+}
+
+// closeAll discards all upload sessions.
+func (u *UploadSessions) closeAll() {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	for id, session := range u.sessions {
+		session.mutex.Lock()
+		session.discard()
+		session.mutex.Unlock()
+		delete(u.sessions, id)
+	}
+}
+
+func (u *UploadSessions) get(id string) *uploadSession {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	return u.sessions[id]
+}
+
+// touch marks a session as active, so it does not expire.
+func (u *UploadSessions) touch(id string, now time.Time) *uploadSession {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	session := u.sessions[id]
+	if session != nil {
+		session.lastActive = now
+	}
+	return session
+}
+
+func (u *UploadSessions) remove(id string) *uploadSession {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	session := u.sessions[id]
+	delete(u.sessions, id)
+	return session
+}
+
+func (session *uploadSession) discard() {
+	session.file.Close()
+	os.Remove(session.file.Name())
+}
+
+// GetUploadOffset returns how many bytes an upload session has received.
+func (index *Index) GetUploadOffset(id string) (int64, error) {
+	session := index.uploads.get(id)
+	if session == nil {
+		return 0, UploadSessionNotFound
+	}
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+	return session.offset, nil
+}
+
+// AppendUpload appends a chunk to an upload session, which must start
+// at the current offset. If reading the chunk fails halfway, the data
+// received so far is kept, so the client can resume after it.
+func (index *Index) AppendUpload(id string, offset int64, chunk io.Reader, now time.Time) (int64, error) {
+	session := index.uploads.touch(id, now)
+	if session == nil {
+		return 0, UploadSessionNotFound
+	}
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+	if offset != session.offset {
+		return session.offset, &UploadOffsetMismatch{session.offset}
+	}
+
+	remaining := MaxResumableUploadSize - session.offset
+	n, err := io.Copy(io.MultiWriter(session.file, session.hash), io.LimitReader(chunk, remaining+1))
+	session.offset += n
+	if err == nil && n > remaining {
+		err = UploadTooLarge
+	}
+	return session.offset, err
+}
+
+// FinishUpload verifies the SHA-256 checksum of a resumable upload
+// and installs the uploaded data as new content of its collection.
+// The session ends either way; after a checksum mismatch, the client
+// needs to start over.
+func (index *Index) FinishUpload(id string, checksum string) (string, CollectionMetadata, error) {
+	session := index.uploads.remove(id)
+	if session == nil {
+		return "", CollectionMetadata{}, UploadSessionNotFound
+	}
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+	defer session.discard()
+
+	if hex.EncodeToString(session.hash.Sum(nil)) != strings.ToLower(checksum) {
+		return session.collection, CollectionMetadata{}, UploadChecksumMismatch
+	}
+	if _, err := session.file.Seek(0, io.SeekStart); err != nil {
+		return session.collection, CollectionMetadata{}, err
+	}
+
+	// We always copy the data once more, to decompress it if needed.
+	// Since the copy is next to the source, the installation is atomic.
+	tmp, err := createUploadFile(session.path)
+	if err != nil {
+		return session.collection, CollectionMetadata{}, err
+	}
+	defer os.Remove(tmp.Name())
+	if err := copyUpload(tmp, session.file, MaxResumableUploadSize); err != nil {
+		tmp.Close()
+		return session.collection, CollectionMetadata{}, err
+	}
+	if err := tmp.Close(); err != nil {
+		return session.collection, CollectionMetadata{}, err
+	}
+	md, err := index.installUpload(session.collection, session.path, tmp.Name())
+	return session.collection, md, err
+}
+
+// AbortUpload discards an upload session.
+func (index *Index) AbortUpload(id string) error {
+	session := index.uploads.remove(id)
+	if session == nil {
+		return UploadSessionNotFound
+	}
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+	session.discard()
+	return nil
+}
+
+func (s *WebServer) handleStartUploadRequest(w http.ResponseWriter, req *http.Request, collection string) {
+	if req.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	id, err := s.index.StartUpload(collection, time.Now())
+	if err != nil {
+		writeUploadError(w, collection, err)
+		return
+	}
+
+	location := "/admin/uploads/" + id
+	encoded, err := json.Marshal(map[string]interface{}{
+		"id": id, "collection": collection, "location": location, "offset": 0,
+	})
+	if err != nil {
+		httpLog.Error("json.Marshal failed", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(encoded)))
+	w.Header().Set("Location", location)
+	w.Header().Set("Upload-Offset", "0")
+	w.WriteHeader(http.StatusCreated)
+	w.Write(encoded)
+}
+
+func (s *WebServer) handleUploadSessionRequest(w http.ResponseWriter, req *http.Request, id string) {
+	w.Header().Set("Cache-Control", "no-store")
+	switch req.Method {
+	case "HEAD":
+		offset, err := s.index.GetUploadOffset(id)
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+		w.WriteHeader(http.StatusOK)
+
+	case "PATCH":
+		offset, err := strconv.ParseInt(req.Header.Get("Upload-Offset"), 10, 64)
+		if err != nil || offset < 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		newOffset, err := s.index.AppendUpload(id, offset, req.Body, time.Now())
+		if err == UploadSessionNotFound {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Upload-Offset", strconv.FormatInt(newOffset, 10))
+		if _, isMismatch := err.(*UploadOffsetMismatch); isMismatch {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		if err == UploadTooLarge {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err != nil {
+			// Probably a broken connection; the client can resume.
+			httpLog.Warn("appending to upload session failed", "session", id, "error", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	case "POST":
+		checksum := strings.Fields(req.Header.Get("Upload-Checksum"))
+		if len(checksum) != 2 || strings.ToLower(checksum[0]) != "sha256" {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, "missing header Upload-Checksum: sha256 <hex>\n")
+			return
+		}
+		collection, md, err := s.index.FinishUpload(id, checksum[1])
+		switch err {
+		case nil:
+			writeUploadResult(w, collection, md)
+		case UploadSessionNotFound:
+			w.WriteHeader(http.StatusNotFound)
+		case UploadChecksumMismatch:
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, err.Error()+"\n")
+		default:
+			writeUploadError(w, collection, err)
+		}
+
+	case "DELETE":
+		if err := s.index.AbortUpload(id); err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		w.Header().Set("Allow", "HEAD, PATCH, POST, DELETE")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func uploadRequest(s *WebServer, method string, path string, body []byte, header map[string]string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(method, path, bytes.NewReader(body))
+	req.Header.Set("X-API-Key", adminAPIKey)
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	resp := httptest.NewRecorder()
+	http.HandlerFunc(s.HandleRequest).ServeHTTP(resp, req)
+	return resp
+}
+
+func startUpload(t *testing.T, s *WebServer) string {
+	resp := uploadRequest(s, "POST", "/admin/collections/lakes/uploads", nil, nil)
+	if resp.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d", resp.Code)
+	}
+	var started struct {
+		Location string `json:"location"`
+	}
+	if err := json.Unmarshal(resp.Body.Bytes(), &started); err != nil {
+		t.Fatal(err)
+	}
+	if loc := resp.Header().Get("Location"); loc != started.Location {
+		t.Errorf("expected Location header %s, got %s", started.Location, loc)
+	}
+	return started.Location
+}
+
+func TestResumableUpload(t *testing.T) {
+	index, s, path, cleanup := makeUploadServer(t)
+	defer cleanup()
+
+	content := []byte(`{"type":"FeatureCollection","features":[
+		{"type":"Feature","id":"N7","properties":{"name":"Greifensee"},"geometry":null}]}`)
+	sum := sha256.Sum256(content)
+	checksum := "sha256 " + hex.EncodeToString(sum[:])
+	loc := startUpload(t, s)
+
+	first, second := content[:20], content[20:]
+	resp := uploadRequest(s, "PATCH", loc, first, map[string]string{"Upload-Offset": "0"})
+	if resp.Code != http.StatusNoContent || resp.Header().Get("Upload-Offset") != "20" {
+		t.Fatalf("expected 204 with Upload-Offset 20, got %d %q", resp.Code, resp.Header().Get("Upload-Offset"))
+	}
+
+	// A client that lost track of the offset asks for it, and gets
+	// a conflict when sending a chunk at the wrong offset.
+	resp = uploadRequest(s, "HEAD", loc, nil, nil)
+	if resp.Code != http.StatusOK || resp.Header().Get("Upload-Offset") != "20" {
+		t.Errorf("expected HEAD to return Upload-Offset 20, got %d %q", resp.Code, resp.Header().Get("Upload-Offset"))
+	}
+	resp = uploadRequest(s, "PATCH", loc, second, map[string]string{"Upload-Offset": "0"})
+	if resp.Code != http.StatusConflict || resp.Header().Get("Upload-Offset") != "20" {
+		t.Errorf("expected 409 with Upload-Offset 20, got %d %q", resp.Code, resp.Header().Get("Upload-Offset"))
+	}
+
+	resp = uploadRequest(s, "PATCH", loc, second, map[string]string{"Upload-Offset": "20"})
+	if resp.Code != http.StatusNoContent {
+		t.Fatalf("expected status 204, got %d", resp.Code)
+	}
+	resp = uploadRequest(s, "POST", loc, nil, map[string]string{"Upload-Checksum": checksum})
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", resp.Code, getBody(resp))
+	}
+	if item, _ := index.GetItem("lakes", "N7"); item == nil {
+		t.Error("expected uploaded feature to be served")
+	}
+	if written, _ := ioutil.ReadFile(path); !bytes.Equal(written, content) {
+		t.Errorf("expected upload to be written to %s, got %s", path, written)
+	}
+	if matches, _ := filepath.Glob(filepath.Join(filepath.Dir(path), ".upload-*")); len(matches) != 0 {
+		t.Errorf("expected temporary files to be removed, got %v", matches)
+	}
+
+	resp = uploadRequest(s, "HEAD", loc, nil, nil)
+	if resp.Code != http.StatusNotFound {
+		t.Errorf("expected finished session to be gone, got %d", resp.Code)
+	}
+}
+
+func TestResumableUpload_ChecksumMismatch(t *testing.T) {
+	index, s, _, cleanup := makeUploadServer(t)
+	defer cleanup()
+
+	loc := startUpload(t, s)
+	content := []byte(`{"type":"FeatureCollection","features":[]}`)
+	uploadRequest(s, "PATCH", loc, content, map[string]string{"Upload-Offset": "0"})
+	resp := uploadRequest(s, "POST", loc, nil, map[string]string{"Upload-Checksum": "sha256 00"})
+	if resp.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for checksum mismatch, got %d", resp.Code)
+	}
+	if item, _ := index.GetItem("lakes", "N123"); item == nil {
+		t.Error("expected previous data to be kept")
+	}
+}
+
+func TestResumableUpload_APIKey(t *testing.T) {
+	_, s, _, cleanup := makeUploadServer(t)
+	defer cleanup()
+	id := startUpload(t, s)
+
+	for _, tc := range []struct {
+		method, path string
+	}{
+		{"POST", "/admin/collections/lakes/uploads"},
+		{"HEAD", id},
+		{"PATCH", id},
+		{"DELETE", id},
+	} {
+		req, _ := http.NewRequest(tc.method, tc.path, nil)
+		resp := httptest.NewRecorder()
+		http.HandlerFunc(s.HandleRequest).ServeHTTP(resp, req)
+		if resp.Code != http.StatusUnauthorized {
+			t.Errorf("%s %s without API key: expected status 401, got %d", tc.method, tc.path, resp.Code)
+		}
+	}
+}
+
+func TestResumableUpload_Expire(t *testing.T) {
+	index, s, path, cleanup := makeUploadServer(t)
+	defer cleanup()
+
+	now := time.Now()
+	id, err := index.StartUpload("lakes", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := index.StartUpload("lakes", now.Add(uploadSessionTimeout+time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := index.GetUploadOffset(id); err != UploadSessionNotFound {
+		t.Errorf("expected idle session to expire, got %v", err)
+	}
+	if matches, _ := filepath.Glob(filepath.Join(filepath.Dir(path), ".upload-*")); len(matches) != 1 {
+		t.Errorf("expected one remaining upload file, got %v", matches)
+	}
+
+	resp := uploadRequest(s, "DELETE", "/admin/uploads/"+id, nil, nil)
+	if resp.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for expired session, got %d", resp.Code)
+	}
+	resp = uploadRequest(s, "PATCH", "/admin/uploads/"+id, nil, map[string]string{"Upload-Offset": strconv.Itoa(0)})
+	if resp.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for expired session, got %d", resp.Code)
+	}
+}
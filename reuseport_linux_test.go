@@ -0,0 +1,75 @@
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestListenReusePort(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	listeners, err := listenReusePort(addr, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listeners) != 3 {
+		t.Fatalf("expected 3 listeners, got %d", len(listeners))
+	}
+	for _, l := range listeners {
+		if l.Addr().String() != addr {
+			t.Errorf("expected listener on %s, got %s", addr, l.Addr())
+		}
+		l.Close()
+	}
+}
+
+func TestListenAndServe_ReusePort(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+
+	index, s := makeServer(t)
+	defer index.Close()
+	s.listeners = 4
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", s.HandleRequest)
+	done := make(chan error)
+	go func() { done <- s.ListenAndServe(port, mux) }()
+
+	url := "http://127.0.0.1:" + strconv.Itoa(port) + "/collections"
+	for i := 0; i < 100; i++ {
+		if conn, err := net.Dial("tcp", "127.0.0.1:"+strconv.Itoa(port)); err == nil {
+			conn.Close()
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	for i := 0; i < 10; i++ {
+		resp, err := http.Get(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("expected status 200, got %d", resp.StatusCode)
+		}
+	}
+
+	s.Shutdown()
+	if err := <-done; err != http.ErrServerClosed {
+		t.Errorf("expected http.ErrServerClosed, got %v", err)
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"errors"
+	"net"
+)
+
+func listenReusePort(addr string, n int) ([]net.Listener, error) {
+	return nil, errors.New("multiple listeners need SO_REUSEPORT, which is only supported on Linux")
+}
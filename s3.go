@@ -0,0 +1,233 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Amazon S3 sources, such as s3://bucket/lakes.geojson, for servers
+// without a shared file system. Objects get fetched like http:// URLs,
+// with conditional requests on their ETag, and get refreshed on the
+// same interval. Configuration comes from the standard environment
+// variables of AWS: AWS_REGION (or AWS_DEFAULT_REGION), and for
+// S3-compatible stores, AWS_ENDPOINT_URL_S3 (or AWS_ENDPOINT_URL).
+// Credentials come from AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and
+// AWS_SESSION_TOKEN, or else from the container credentials endpoint
+// of Amazon ECS. Without credentials, requests are anonymous, which
+// works for public buckets. Requests are signed with AWS Signature
+// Version 4; we implement it here rather than pulling in the AWS SDK.
+
+func init() {
+	client := &http.Client{Timeout: 60 * time.Second}
+	store := &s3Store{client: client}
+	RegisterInputLoader(&httpLoader{name: "s3", client: client, store: store,
+		versionHeader: "ETag", ifNoneMatchHeader: "If-None-Match"}, nil, []string{"s3"})
+}
+
+// ecsCredentialsHost serves credentials to ECS tasks, at the path
+// given by AWS_CONTAINER_CREDENTIALS_RELATIVE_URI.
+const ecsCredentialsHost = "http://169.254.170.2"
+
+// emptyPayloadHash is the hex-encoded SHA-256 of an empty request body.
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+type s3Store struct {
+	client *http.Client
+
+	mutex       sync.Mutex
+	credentials awsCredentials // cached credentials from ECS
+}
+
+type awsCredentials struct {
+	AccessKeyID     string    `json:"AccessKeyId"`
+	SecretAccessKey string    `json:"SecretAccessKey"`
+	SessionToken    string    `json:"Token"`
+	Expiration      time.Time `json:"Expiration"`
+}
+
+// parseS3Source splits a source such as s3://bucket/path/key.geojson
+// into bucket and key.
+func parseS3Source(source string) (bucket string, key string, err error) {
+	if sourceScheme(source) == "s3" {
+		rest := source[len("s3://"):]
+		if i := strings.IndexByte(rest, '/'); i > 0 && i < len(rest)-1 {
+			return rest[:i], rest[i+1:], nil
+		}
+	}
+	return "", "", fmt.Errorf("malformed S3 source %s; expected s3://bucket/key", source)
+}
+
+func (s *s3Store) objectURL(source string) (string, error) {
+	bucket, key, err := parseS3Source(source)
+	if err != nil {
+		return "", err
+	}
+	if endpoint := s3Endpoint(); len(endpoint) > 0 {
+		return strings.TrimSuffix(endpoint, "/") + "/" + bucket + "/" + escapeObjectKey(key), nil
+	}
+
+	// Bucket names with dots do not match the wildcard certificate
+	// of virtual-hosted style URLs, so we use path style for them.
+	host := "s3." + s3Region() + ".amazonaws.com"
+	if strings.Contains(bucket, ".") {
+		return "https://" + host + "/" + bucket + "/" + escapeObjectKey(key), nil
+	}
+	return "https://" + bucket + "." + host + "/" + escapeObjectKey(key), nil
+}
+
+func (s *s3Store) sign(req *http.Request) error {
+	creds, err := s.getCredentials(time.Now())
+	if err != nil {
+		return err
+	}
+	if len(creds.AccessKeyID) == 0 {
+		return nil // anonymous access
+	}
+	signS3Request(req, creds, s3Region(), time.Now())
+	return nil
+}
+
+// getCredentials returns the credentials from the environment, or from
+// the ECS credentials endpoint if running as an ECS task. Returns empty
+// credentials if neither is available.
+func (s *s3Store) getCredentials(now time.Time) (awsCredentials, error) {
+	if id := os.Getenv("AWS_ACCESS_KEY_ID"); len(id) > 0 {
+		return awsCredentials{
+			AccessKeyID:     id,
+			SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		}, nil
+	}
+
+	var u string
+	if path := os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"); len(path) > 0 {
+		u = ecsCredentialsHost + path
+	} else {
+		u = os.Getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI")
+	}
+	if len(u) == 0 {
+		return awsCredentials{}, nil
+	}
+
+	// Temporary credentials get rotated well before they expire.
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if len(s.credentials.AccessKeyID) > 0 && now.Add(5*time.Minute).Before(s.credentials.Expiration) {
+		return s.credentials, nil
+	}
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	if token := os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN"); len(token) > 0 {
+		req.Header.Set("Authorization", token)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return awsCredentials{}, fmt.Errorf("fetching container credentials: %s", resp.Status)
+	}
+	var creds awsCredentials
+	if err := json.NewDecoder(resp.Body).Decode(&creds); err != nil {
+		return awsCredentials{}, fmt.Errorf("fetching container credentials: %v", err)
+	}
+	s.credentials = creds
+	return creds, nil
+}
+
+// signS3Request adds the headers of AWS Signature Version 4 to a
+// request without body.
+// https://docs.aws.amazon.com/IAM/latest/UserGuide/reference_aws-signing.html
+func signS3Request(req *http.Request, creds awsCredentials, region string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", emptyPayloadHash)
+	signed := []string{"host", "x-amz-content-sha256", "x-amz-date"}
+	if len(creds.SessionToken) > 0 {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+		signed = append(signed, "x-amz-security-token")
+	}
+
+	var canonicalHeaders strings.Builder
+	for _, h := range signed {
+		value := req.Header.Get(h)
+		if h == "host" {
+			value = req.URL.Host
+		}
+		canonicalHeaders.WriteString(h + ":" + strings.TrimSpace(value) + "\n")
+	}
+	signedHeaders := strings.Join(signed, ";")
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		emptyPayloadHash,
+	}, "\n")
+
+	scope := date + "/" + region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := []byte("AWS4" + creds.SecretAccessKey)
+	for _, part := range []string{date, region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		creds.AccessKeyID, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// escapeObjectKey escapes the key of an S3 or GCS object for URLs, the
+// way S3 Signature Version 4 expects: everything except unreserved
+// characters and slashes gets percent-encoded.
+func escapeObjectKey(key string) string {
+	var b strings.Builder
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		if ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' || c == '/' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func s3Region() string {
+	for _, v := range []string{"AWS_REGION", "AWS_DEFAULT_REGION"} {
+		if region := os.Getenv(v); len(region) > 0 {
+			return region
+		}
+	}
+	return "us-east-1"
+}
+
+func s3Endpoint() string {
+	for _, v := range []string{"AWS_ENDPOINT_URL_S3", "AWS_ENDPOINT_URL"} {
+		if endpoint := os.Getenv(v); len(endpoint) > 0 {
+			return endpoint
+		}
+	}
+	return ""
+}
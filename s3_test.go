@@ -0,0 +1,176 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// setenv sets environment variables for the duration of a test,
+// returning a function that restores the previous values.
+func setenv(vars map[string]string) func() {
+	saved := make(map[string]string)
+	for name, value := range vars {
+		if old, ok := os.LookupEnv(name); ok {
+			saved[name] = old
+		}
+		if len(value) > 0 {
+			os.Setenv(name, value)
+		} else {
+			os.Unsetenv(name)
+		}
+	}
+	return func() {
+		for name := range vars {
+			if old, ok := saved[name]; ok {
+				os.Setenv(name, old)
+			} else {
+				os.Unsetenv(name)
+			}
+		}
+	}
+}
+
+func TestS3Store_ObjectURL(t *testing.T) {
+	defer setenv(map[string]string{"AWS_REGION": "eu-central-1", "AWS_DEFAULT_REGION": "",
+		"AWS_ENDPOINT_URL_S3": "", "AWS_ENDPOINT_URL": ""})()
+
+	s := &s3Store{}
+	for source, expected := range map[string]string{
+		"s3://data/lakes.geojson":          "https://data.s3.eu-central-1.amazonaws.com/lakes.geojson",
+		"S3://data/2024/Lakes & Rivers.gz": "https://data.s3.eu-central-1.amazonaws.com/2024/Lakes%20%26%20Rivers.gz",
+		"s3://data.example.org/lakes":      "https://s3.eu-central-1.amazonaws.com/data.example.org/lakes",
+	} {
+		if got, err := s.objectURL(source); err != nil || got != expected {
+			t.Errorf("objectURL(%q): expected %s, got %s, %v", source, expected, got, err)
+		}
+	}
+	for _, source := range []string{"s3://data", "s3://data/", "s3:///lakes"} {
+		if _, err := s.objectURL(source); err == nil {
+			t.Errorf("objectURL(%q): expected error", source)
+		}
+	}
+
+	os.Setenv("AWS_ENDPOINT_URL_S3", "http://minio:9000/")
+	if got, _ := s.objectURL("s3://data/lakes.geojson"); got != "http://minio:9000/data/lakes.geojson" {
+		t.Errorf("expected path-style URL for custom endpoint, got %s", got)
+	}
+}
+
+func TestSignS3Request(t *testing.T) {
+	req, _ := http.NewRequest("GET", "https://data.s3.eu-central-1.amazonaws.com/lakes.geojson", nil)
+	creds := awsCredentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "secret", SessionToken: "token"}
+	now := time.Date(2024, 8, 6, 13, 30, 0, 0, time.UTC)
+	signS3Request(req, creds, "eu-central-1", now)
+
+	auth := req.Header.Get("Authorization")
+	prefix := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20240806/eu-central-1/s3/aws4_request, " +
+		"SignedHeaders=host;x-amz-content-sha256;x-amz-date;x-amz-security-token, Signature="
+	if !strings.HasPrefix(auth, prefix) || len(auth) != len(prefix)+64 {
+		t.Errorf("unexpected Authorization header %q", auth)
+	}
+	if got := req.Header.Get("X-Amz-Date"); got != "20240806T133000Z" {
+		t.Errorf("unexpected X-Amz-Date %q", got)
+	}
+	if got := req.Header.Get("X-Amz-Security-Token"); got != "token" {
+		t.Errorf("unexpected X-Amz-Security-Token %q", got)
+	}
+
+	// Signatures must depend on the secret and on the request.
+	other, _ := http.NewRequest("GET", "https://data.s3.eu-central-1.amazonaws.com/castles.geojson", nil)
+	signS3Request(other, creds, "eu-central-1", now)
+	if other.Header.Get("Authorization") == auth {
+		t.Error("expected signature to depend on object key")
+	}
+}
+
+func TestS3Loader(t *testing.T) {
+	data, _ := ioutil.ReadFile(filepath.Join("testdata", "lakes.geojson"))
+	var mutex sync.Mutex
+	var paths, auths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mutex.Lock()
+		paths = append(paths, req.Method+" "+req.URL.Path)
+		auths = append(auths, req.Header.Get("Authorization"))
+		mutex.Unlock()
+		w.Header().Set("ETag", `"abc"`)
+		if req.Header.Get("If-None-Match") == `"abc"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Write(data)
+	}))
+	defer server.Close()
+	defer setenv(map[string]string{"AWS_ENDPOINT_URL_S3": server.URL, "AWS_REGION": "eu-central-1",
+		"AWS_ACCESS_KEY_ID": "AKIDEXAMPLE", "AWS_SECRET_ACCESS_KEY": "secret", "AWS_SESSION_TOKEN": ""})()
+
+	source := "s3://data/lakes.geojson"
+	var t0 time.Time
+	coll, err := readCollection("lakes", source, t0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer coll.Close()
+	if coll.metadata.Path != source {
+		t.Errorf("expected path %s, got %s", source, coll.metadata.Path)
+	}
+	if _, err := readCollection("lakes", source, coll.metadata.LastModified); err != NotModified {
+		t.Errorf("expected NotModified, got %v", err)
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if got := strings.Join(paths, ","); got != "HEAD /data/lakes.geojson,GET /data/lakes.geojson,HEAD /data/lakes.geojson" {
+		t.Errorf("unexpected requests %s", got)
+	}
+	for _, auth := range auths {
+		if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/") {
+			t.Errorf("expected signed request, got Authorization %q", auth)
+		}
+	}
+}
+
+func TestS3Store_ContainerCredentials(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		calls++
+		if req.Header.Get("Authorization") != "ecs-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"AccessKeyId":"ASIAEXAMPLE","SecretAccessKey":"secret","Token":"session",` +
+			`"Expiration":"2024-08-06T14:30:00Z"}`))
+	}))
+	defer server.Close()
+	defer setenv(map[string]string{"AWS_ACCESS_KEY_ID": "", "AWS_CONTAINER_CREDENTIALS_RELATIVE_URI": "",
+		"AWS_CONTAINER_CREDENTIALS_FULL_URI": server.URL + "/creds", "AWS_CONTAINER_AUTHORIZATION_TOKEN": "ecs-token"})()
+
+	s := &s3Store{client: server.Client()}
+	now := time.Date(2024, 8, 6, 13, 30, 0, 0, time.UTC)
+	for i := 0; i < 2; i++ {
+		creds, err := s.getCredentials(now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if creds.AccessKeyID != "ASIAEXAMPLE" || creds.SessionToken != "session" {
+			t.Errorf("unexpected credentials %+v", creds)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("expected credentials to be cached, got %d calls", calls)
+	}
+
+	// Shortly before expiration, credentials get fetched again.
+	if _, err := s.getCredentials(now.Add(58 * time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 2 {
+		t.Errorf("expected credentials to be refreshed, got %d calls", calls)
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+)
+
+// SaveDataPolicy tells how to shape items responses for clients on slow
+// or metered connections, such as survey crews on mobile networks in
+// the field. Browsers send "Save-Data: on" when their user has asked
+// for reduced data usage; servers for field deployments can also shape
+// all responses. Shaped responses have coordinates of lower precision,
+// leave out optional properties, and contain fewer items per page
+// unless clients ask for a specific limit.
+// https://wicg.github.io/savedata/
+type SaveDataPolicy struct {
+	Always     bool                // shape all responses, not just those with Save-Data
+	Precision  int                 // decimal digits of coordinates; 5 is about a meter
+	Limit      int                 // default number of items per page
+	Properties map[string][]string // collection -> properties to keep; all if missing
+}
+
+// makeSaveDataPolicy builds the policy for the --saveData flags, or
+// returns nil if mode is "off". Properties are a list of
+// collection=property, such as "castles=name,castles=historic".
+func makeSaveDataPolicy(mode string, precision int, limit int, properties string) *SaveDataPolicy {
+	if mode != "off" && mode != "auto" && mode != "always" {
+		log.Fatalf("unsupported --saveData=%s; supported are off, auto and always", mode)
+	}
+	if mode == "off" {
+		return nil
+	}
+	if precision < 0 || precision > 15 {
+		log.Fatal("--saveDataPrecision must be between 0 and 15")
+	}
+	if limit < 1 || limit > MaxLimit {
+		log.Fatalf("--saveDataLimit must be between 1 and %d", MaxLimit)
+	}
+	p := &SaveDataPolicy{Always: mode == "always", Precision: precision, Limit: limit, Properties: make(map[string][]string)}
+	for _, s := range splitList(properties) {
+		kv := strings.SplitN(s, "=", 2)
+		if len(kv) != 2 || len(kv[0]) == 0 || len(kv[1]) == 0 {
+			log.Fatal("malformed --saveDataProperties command-line argument; pass something like --saveDataProperties=castles=name,castles=historic")
+		}
+		p.Properties[kv[0]] = append(p.Properties[kv[0]], kv[1])
+	}
+	return p
+}
+
+// applies returns true if the response to req should be shaped.
+func (p *SaveDataPolicy) applies(req *http.Request) bool {
+	if p == nil {
+		return false
+	}
+	return p.Always || strings.EqualFold(strings.TrimSpace(req.Header.Get("Save-Data")), "on")
+}
+
+// setVary tells caches that responses depend on the Save-Data header.
+func (p *SaveDataPolicy) setVary(header http.Header) {
+	if p != nil && !p.Always {
+		header.Add("Vary", "Save-Data")
+	}
+}
+
+// shapeFeatureCollection shapes the features of an encoded GeoJSON
+// FeatureCollection, keeping its other members such as links.
+func (p *SaveDataPolicy) shapeFeatureCollection(collection string, data []byte) ([]byte, error) {
+	return transformRawFeatures(data, func(f []byte) ([]byte, error) {
+		return p.shapeFeature(collection, f)
+	})
+}
+
+// shapeFeature shapes an encoded GeoJSON Feature.
+func (p *SaveDataPolicy) shapeFeature(collection string, data []byte) ([]byte, error) {
+	var members map[string]json.RawMessage
+	if err := json.Unmarshal(data, &members); err != nil {
+		return nil, err
+	}
+	for _, key := range []string{"geometry", "bbox"} {
+		raw, ok := members[key]
+		if !ok {
+			continue
+		}
+		var value interface{}
+		if err := json.Unmarshal(raw, &value); err != nil {
+			return nil, err
+		}
+		encoded, err := json.Marshal(roundCoordinates(value, p.Precision))
+		if err != nil {
+			return nil, err
+		}
+		members[key] = encoded
+	}
+
+	if keep, ok := p.Properties[collection]; ok {
+		var props map[string]json.RawMessage
+		if raw, ok := members["properties"]; ok {
+			if err := json.Unmarshal(raw, &props); err != nil {
+				return nil, err
+			}
+		}
+		if props != nil {
+			kept := make(map[string]json.RawMessage, len(keep))
+			for _, name := range keep {
+				if value, ok := props[name]; ok {
+					kept[name] = value
+				}
+			}
+			encoded, err := json.Marshal(kept)
+			if err != nil {
+				return nil, err
+			}
+			members["properties"] = encoded
+		}
+	}
+	return json.Marshal(members)
+}
+
+// roundCoordinates rounds the positions and bounding boxes in a decoded
+// GeoJSON geometry to digits decimal places. Other members, such as the
+// geometry type, are left alone.
+func roundCoordinates(value interface{}, digits int) interface{} {
+	switch v := value.(type) {
+	case float64:
+		scale := math.Pow(10, float64(digits))
+		return math.Round(v*scale) / scale
+	case []interface{}:
+		for i := range v {
+			v[i] = roundCoordinates(v[i], digits)
+		}
+	case map[string]interface{}:
+		for _, key := range []string{"coordinates", "bbox", "geometries"} {
+			if member, ok := v[key]; ok {
+				v[key] = roundCoordinates(member, digits)
+			}
+		}
+	}
+	return value
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSaveData(t *testing.T) {
+	index, s := makeServer(t)
+	defer s.Shutdown()
+	defer index.Close()
+	s.saveData = &SaveDataPolicy{Precision: 3, Limit: 2, Properties: map[string][]string{"castles": {"name"}}}
+
+	get := func(path string, saveData bool) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", path, nil)
+		if saveData {
+			req.Header.Set("Save-Data", "on")
+		}
+		resp := httptest.NewRecorder()
+		http.HandlerFunc(s.HandleRequest).ServeHTTP(resp, req)
+		if resp.Code != http.StatusOK {
+			t.Fatalf("GET %s: expected status %d, got %d", path, http.StatusOK, resp.Code)
+		}
+		if vary := strings.Join(resp.Header()["Vary"], ", "); !strings.HasPrefix(vary, "Accept, Accept-Profile") || !strings.HasSuffix(vary, ", Save-Data") {
+			t.Errorf("GET %s: expected Vary: Accept, Accept-Profile, ..., Save-Data, got %q", path, vary)
+		}
+		return resp
+	}
+
+	type collection struct {
+		Features []struct {
+			Geometry struct {
+				Coordinates json.RawMessage `json:"coordinates"`
+			} `json:"geometry"`
+			Properties map[string]interface{} `json:"properties"`
+		} `json:"features"`
+	}
+	var full, shaped collection
+	if err := json.Unmarshal([]byte(getBody(get("/collections/castles/items?limit=10", false))), &full); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal([]byte(getBody(get("/collections/castles/items", true))), &shaped); err != nil {
+		t.Fatal(err)
+	}
+	if len(full.Features) != 3 || len(shaped.Features) != 2 {
+		t.Fatalf("expected 3 features, and 2 with Save-Data, got %d and %d", len(full.Features), len(shaped.Features))
+	}
+	if len(full.Features[0].Properties) <= 1 {
+		t.Errorf("expected all properties without Save-Data, got %v", full.Features[0].Properties)
+	}
+	if got := string(shaped.Features[0].Geometry.Coordinates); got != "[11.183,47.91]" {
+		t.Errorf("expected coordinates [11.183,47.91], got %s", got)
+	}
+	if p := shaped.Features[0].Properties; len(p) != 1 || p["name"] != "Hochschloß Pähl" {
+		t.Errorf("expected only name property, got %v", p)
+	}
+
+	// Clients asking for a specific limit get it.
+	if err := json.Unmarshal([]byte(getBody(get("/collections/castles/items?limit=3", true))), &shaped); err != nil {
+		t.Fatal(err)
+	}
+	if len(shaped.Features) != 3 {
+		t.Errorf("expected 3 features with explicit limit, got %d", len(shaped.Features))
+	}
+
+	var item struct {
+		Geometry struct {
+			Coordinates json.RawMessage `json:"coordinates"`
+		} `json:"geometry"`
+		Properties map[string]interface{} `json:"properties"`
+	}
+	if err := json.Unmarshal([]byte(getBody(get("/collections/castles/items/N34729562", true))), &item); err != nil {
+		t.Fatal(err)
+	}
+	if got := string(item.Geometry.Coordinates); got != "[11.183,47.91]" || len(item.Properties) != 1 {
+		t.Errorf("expected shaped item, got %s and %v", got, item.Properties)
+	}
+}
+
+func TestSaveData_Always(t *testing.T) {
+	p := &SaveDataPolicy{Always: true}
+	req, _ := http.NewRequest("GET", "/collections/castles/items", nil)
+	if !p.applies(req) {
+		t.Error("expected policy to apply without Save-Data header")
+	}
+	header := make(http.Header)
+	p.setVary(header)
+	if vary := header.Get("Vary"); vary != "" {
+		t.Errorf("expected no Vary header, got %q", vary)
+	}
+
+	var off *SaveDataPolicy
+	req.Header.Set("Save-Data", "on")
+	if off.applies(req) {
+		t.Error("expected nil policy to never apply")
+	}
+}
+
+func TestRoundCoordinates(t *testing.T) {
+	var geometry interface{}
+	json.Unmarshal([]byte(`{"type":"GeometryCollection","geometries":[
+		{"type":"Point","coordinates":[8.123456,47.987654]},
+		{"type":"LineString","coordinates":[[1.55,2.44],[3.5,-4.45]],"bbox":[1.55,-4.45,3.5,2.44]}]}`), &geometry)
+	encoded, _ := json.Marshal(roundCoordinates(geometry, 1))
+	expected := `{"geometries":[{"coordinates":[8.1,48],"type":"Point"},` +
+		`{"bbox":[1.6,-4.5,3.5,2.4],"coordinates":[[1.6,2.4],[3.5,-4.5]],"type":"LineString"}],"type":"GeometryCollection"}`
+	if string(encoded) != expected {
+		t.Errorf("expected %s, got %s", expected, encoded)
+	}
+}
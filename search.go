@@ -0,0 +1,262 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxSearchTerms limits the number of distinct terms in the full-text
+// index of a collection, so memory stays bounded even for collections
+// with lots of free text. Terms beyond the limit are not searchable.
+const maxSearchTerms = 100000
+
+// maxSearchTermLength is the number of characters after which terms
+// get truncated, both when indexing and when searching.
+const maxSearchTermLength = 32
+
+// minFuzzyTermLength is the minimal length of query terms that also
+// match terms at edit distance 1. Shorter terms would match too much.
+const minFuzzyTermLength = 4
+
+// textIndex is an inverted index over the string property values of a
+// collection, supporting searches with ?q=. Terms are lowercased, and
+// diacritics are removed, so "Pähl" and "pahl" are the same term.
+type textIndex struct {
+	terms    []string // sorted, for finding terms by prefix
+	postings map[string][]textPosting
+}
+
+// textPosting tells how often a term occurs in a feature.
+type textPosting struct {
+	feature int32
+	count   uint16
+}
+
+func makeTextIndex() *textIndex {
+	return &textIndex{postings: make(map[string][]textPosting)}
+}
+
+// add indexes the string property values of the i-th feature.
+// Features must be added in ascending order.
+func (t *textIndex) add(i int, properties map[string]interface{}) {
+	for _, value := range properties {
+		s, ok := value.(string)
+		if !ok {
+			continue
+		}
+		for _, term := range tokenize(s) {
+			postings, known := t.postings[term]
+			if !known && len(t.postings) >= maxSearchTerms {
+				continue
+			}
+			if n := len(postings); n > 0 && postings[n-1].feature == int32(i) {
+				if postings[n-1].count < 0xffff {
+					postings[n-1].count++
+				}
+				continue
+			}
+			t.postings[term] = append(postings, textPosting{feature: int32(i), count: 1})
+		}
+	}
+}
+
+// finish prepares the index for searching, once all features are added.
+func (t *textIndex) finish() {
+	t.terms = make([]string, 0, len(t.postings))
+	for term := range t.postings {
+		t.terms = append(t.terms, term)
+	}
+	sort.Strings(t.terms)
+}
+
+// estimateMemory returns the approximate size of the index, in bytes.
+func (t *textIndex) estimateMemory() int64 {
+	if t == nil {
+		return 0
+	}
+	m := int64(16 * cap(t.terms))
+	for term, postings := range t.postings {
+		m += int64(len(term)) + mapEntryMemory + int64(8*cap(postings))
+	}
+	return m
+}
+
+// search returns the indices of the features matching every term of
+// a query, ranked by relevance. A query term matches index terms that
+// are equal to it, start with it, or for longer terms, differ from it
+// by one edit. Exact matches count twice as much as the others, and
+// each match is weighted by how often the term occurs in the feature.
+func (t *textIndex) search(query string) []int {
+	queryTerms := tokenize(query)
+	if t == nil || len(queryTerms) == 0 {
+		return []int{}
+	}
+
+	var scores map[int32]int
+	for _, q := range queryTerms {
+		termScores := make(map[int32]int)
+		for _, term := range t.matchingTerms(q) {
+			weight := 1
+			if term == q {
+				weight = 2
+			}
+			for _, p := range t.postings[term] {
+				if score := weight * int(p.count); score > termScores[p.feature] {
+					termScores[p.feature] = score
+				}
+			}
+		}
+		if scores == nil {
+			scores = termScores
+			continue
+		}
+		for feature, score := range scores {
+			if s, ok := termScores[feature]; ok {
+				scores[feature] = score + s
+			} else {
+				delete(scores, feature)
+			}
+		}
+	}
+
+	result := make([]int, 0, len(scores))
+	for feature := range scores {
+		result = append(result, int(feature))
+	}
+	sort.Slice(result, func(a, b int) bool {
+		sa, sb := scores[int32(result[a])], scores[int32(result[b])]
+		if sa != sb {
+			return sa > sb
+		}
+		return result[a] < result[b]
+	})
+	return result
+}
+
+// matchingTerms returns the index terms matched by a query term.
+func (t *textIndex) matchingTerms(q string) []string {
+	var result []string
+	start := sort.SearchStrings(t.terms, q)
+	for k := start; k < len(t.terms) && strings.HasPrefix(t.terms[k], q); k++ {
+		result = append(result, t.terms[k])
+	}
+	if utf8.RuneCountInString(q) < minFuzzyTermLength {
+		return result
+	}
+	for _, term := range t.terms {
+		if !strings.HasPrefix(term, q) && withinEditDistanceOne(term, q) {
+			result = append(result, term)
+		}
+	}
+	return result
+}
+
+// withinEditDistanceOne returns true if a and b differ by at most one
+// inserted, deleted or replaced character.
+func withinEditDistanceOne(a, b string) bool {
+	ra, rb := []rune(a), []rune(b)
+	if len(ra) < len(rb) {
+		ra, rb = rb, ra
+	}
+	if len(ra)-len(rb) > 1 {
+		return false
+	}
+	i := 0
+	for i < len(rb) && ra[i] == rb[i] {
+		i++
+	}
+	if len(ra) == len(rb) {
+		return i == len(ra) || string(ra[i+1:]) == string(rb[i+1:])
+	}
+	return string(ra[i+1:]) == string(rb[i:])
+}
+
+// tokenize splits text into lowercase terms without diacritics.
+func tokenize(text string) []string {
+	var terms []string
+	var term strings.Builder
+	length := 0
+	flush := func() {
+		if term.Len() > 0 {
+			terms = append(terms, term.String())
+			term.Reset()
+			length = 0
+		}
+	}
+	for _, r := range text {
+		if unicode.Is(unicode.Mn, r) {
+			continue // combining mark, such as in decomposed "ä"
+		}
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			flush()
+			continue
+		}
+		if length >= maxSearchTermLength {
+			continue
+		}
+		writeFolded(&term, r)
+		length++
+	}
+	flush()
+	return terms
+}
+
+// foldText lowercases text and removes its diacritics, like tokenize
+// but without splitting it into terms.
+func foldText(text string) string {
+	var b strings.Builder
+	for _, r := range text {
+		if !unicode.Is(unicode.Mn, r) {
+			writeFolded(&b, r)
+		}
+	}
+	return b.String()
+}
+
+// writeFolded writes a character in lowercase and without diacritics.
+func writeFolded(b *strings.Builder, r rune) {
+	r = unicode.ToLower(r)
+	if folded, ok := diacriticFolding[r]; ok {
+		b.WriteString(folded)
+	} else {
+		b.WriteRune(r)
+	}
+}
+
+var diacriticFolding = makeDiacriticFolding(map[string]string{
+	"a":  "àáâãäåāăą",
+	"c":  "çćĉċč",
+	"d":  "ďđð",
+	"e":  "èéêëēĕėęě",
+	"g":  "ĝğġģ",
+	"h":  "ĥħ",
+	"i":  "ìíîïĩīĭįı",
+	"j":  "ĵ",
+	"k":  "ķ",
+	"l":  "ĺļľŀł",
+	"n":  "ñńņňŉ",
+	"o":  "òóôõöøōŏő",
+	"r":  "ŕŗř",
+	"s":  "śŝşšș",
+	"t":  "ţťŧț",
+	"u":  "ùúûüũūŭůűų",
+	"w":  "ŵ",
+	"y":  "ýÿŷ",
+	"z":  "źżž",
+	"ae": "æ",
+	"oe": "œ",
+	"ss": "ß",
+	"th": "þ",
+})
+
+func makeDiacriticFolding(groups map[string]string) map[rune]string {
+	result := make(map[rune]string)
+	for folded, chars := range groups {
+		for _, c := range chars {
+			result[c] = folded
+		}
+	}
+	return result
+}
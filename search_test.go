@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	for text, expected := range map[string]string{
+		"Hochschloß Pähl":         "hochschloss pahl",
+		"Pa\u0308hl":              "pahl", // decomposed ä
+		"it:Castello (Torri del)": "it castello torri del",
+		"Łódź, Ærø":               "lodz aero",
+		"":                        "",
+		strings.Repeat("x", 40):   strings.Repeat("x", maxSearchTermLength),
+	} {
+		if got := strings.Join(tokenize(text), " "); got != expected {
+			t.Errorf("tokenize(%q): expected %q, got %q", text, expected, got)
+		}
+	}
+}
+
+func TestWithinEditDistanceOne(t *testing.T) {
+	for _, tc := range []struct {
+		a, b     string
+		expected bool
+	}{
+		{"castle", "castle", true},
+		{"castle", "castel", false},
+		{"castle", "cattle", true},
+		{"castle", "castles", true},
+		{"castle", "astle", true},
+		{"castle", "caste", true},
+		{"castle", "cast", false},
+		{"zürich", "zurich", true},
+	} {
+		if got := withinEditDistanceOne(tc.a, tc.b); got != tc.expected {
+			t.Errorf("withinEditDistanceOne(%q, %q): expected %v, got %v", tc.a, tc.b, tc.expected, got)
+		}
+	}
+}
+
+func TestTextIndex_Search(t *testing.T) {
+	index := makeTextIndex()
+	index.add(0, map[string]interface{}{"name": "Greifensee"})
+	index.add(1, map[string]interface{}{"name": "Lake Zurich", "note": "zurich zurich"})
+	index.add(2, map[string]interface{}{"name": "Zürichsee", "ele": 406.0})
+	index.add(3, map[string]interface{}{"name": "Lake Constance"})
+	index.finish()
+
+	for query, expected := range map[string][]int{
+		"zurich":       {1, 2}, // exact match ranks first
+		"Zürich":       {1, 2},
+		"zurihc":       {},
+		"zuric":        {1, 2},
+		"greifesee":    {0},
+		"lake":         {1, 3},
+		"lake zurich":  {1},
+		"lake geneva":  {},
+		"406":          {},
+		"  ":           {},
+		"constanze":    {3},
+		"lake constan": {3},
+	} {
+		if got := index.search(query); !reflect.DeepEqual(got, expected) {
+			t.Errorf("search(%q): expected %v, got %v", query, expected, got)
+		}
+	}
+}
+
+func TestTextIndex_MaxTerms(t *testing.T) {
+	index := makeTextIndex()
+	words := make([]string, maxSearchTerms+10)
+	for i := range words {
+		words[i] = "w" + strings.Repeat("x", i%5) + string(rune('a'+i%26)) + string(rune('a'+i/26%26)) +
+			string(rune('a'+i/676%26)) + string(rune('a'+i/17576%26))
+	}
+	index.add(0, map[string]interface{}{"text": strings.Join(words, " ")})
+	index.finish()
+	if n := len(index.terms); n > maxSearchTerms {
+		t.Errorf("expected at most %d terms, got %d", maxSearchTerms, n)
+	}
+}
+
+func TestCollection_Search(t *testing.T) {
+	index, s := makeServer(t)
+	defer s.Shutdown()
+	defer index.Close()
+	for q, expected := range map[string]string{
+		"pahl":             "N34729562",
+		"Pähl":             "N34729562",
+		"hochschloss":      "N34729562",
+		"scaliger":         "W418392510",
+		"castelo":          "W418392510",
+		"palazzo pretorio": "W24785843",
+		"palazzo pahl":     "",
+	} {
+		path := "/collections/castles/items?q=" + url.QueryEscape(q)
+		query, _ := http.NewRequest("GET", path, nil)
+		resp := httptest.NewRecorder()
+		http.HandlerFunc(s.HandleRequest).ServeHTTP(resp, query)
+		var got WFSFeatureCollection
+		if err := json.Unmarshal(resp.Body.Bytes(), &got); err != nil {
+			t.Fatal(err)
+		}
+		ids := make([]string, len(got.Features))
+		for i, f := range got.Features {
+			ids[i] = f.ID.(string)
+		}
+		if strings.Join(ids, " ") != expected {
+			t.Errorf("GET %s: expected %q, got %q", path, expected, strings.Join(ids, " "))
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+)
+
+// Streaming encodings with one GeoJSON feature per line and no
+// surrounding FeatureCollection, for bulk downloads that clients can
+// process feature by feature. "ndjson" is newline-delimited JSON;
+// "geojsonseq" are GeoJSON Text Sequences, which additionally start
+// every feature with an ASCII record separator, RFC 8142. Both copy
+// the stored GeoJSON of features without decoding it.
+
+func init() {
+	RegisterOutputEncoder(featureSeqEncoder{name: "ndjson", mediaType: "application/x-ndjson"}, false)
+	RegisterOutputEncoder(featureSeqEncoder{name: "geojsonseq", mediaType: "application/geo+json-seq", recordSeparator: true}, false)
+}
+
+type featureSeqEncoder struct {
+	name            string
+	mediaType       string
+	recordSeparator bool
+}
+
+func (e featureSeqEncoder) Name() string {
+	return e.name
+}
+
+func (e featureSeqEncoder) MediaType() string {
+	return e.mediaType
+}
+
+func (featureSeqEncoder) jsonOnly() {}
+
+func (e featureSeqEncoder) EncodeFeatureCollection(w io.Writer, collection string, features []RawFeature) error {
+	out := bufio.NewWriter(w)
+	for _, f := range features {
+		if err := e.writeFeature(out, f.JSON); err != nil {
+			return err
+		}
+	}
+	return out.Flush()
+}
+
+func (e featureSeqEncoder) EncodeFeature(w io.Writer, collection string, feature RawFeature) error {
+	out := bufio.NewWriter(w)
+	if err := e.writeFeature(out, feature.JSON); err != nil {
+		return err
+	}
+	return out.Flush()
+}
+
+func (e featureSeqEncoder) writeFeature(out *bufio.Writer, feature []byte) error {
+	if e.recordSeparator {
+		out.WriteByte(0x1e)
+	}
+	// Stored features are compact, but a line break would split them.
+	if bytes.IndexByte(feature, '\n') >= 0 {
+		var compact bytes.Buffer
+		if err := json.Compact(&compact, feature); err != nil {
+			return err
+		}
+		feature = compact.Bytes()
+	}
+	out.Write(feature)
+	return out.WriteByte('\n')
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestFeatureSeqEncoder(t *testing.T) {
+	features, err := splitRawFeatures([]byte(`{"features":[
+		{"type":"Feature","id":"a","geometry":null,"properties":{}},
+		{"type":"Feature",
+		 "id":"b","geometry":null,"properties":{}}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		format, expected string
+	}{
+		{"ndjson", `{"type":"Feature","id":"a","geometry":null,"properties":{}}` + "\n" +
+			`{"type":"Feature","id":"b","geometry":null,"properties":{}}` + "\n"},
+		{"geojsonseq", "\x1e" + `{"type":"Feature","id":"a","geometry":null,"properties":{}}` + "\n" +
+			"\x1e" + `{"type":"Feature","id":"b","geometry":null,"properties":{}}` + "\n"},
+	} {
+		var out strings.Builder
+		if err := GetOutputEncoder(tc.format).EncodeFeatureCollection(&out, "test", features); err != nil {
+			t.Fatal(err)
+		}
+		if got := out.String(); got != tc.expected {
+			t.Errorf("%s: expected %q, got %q", tc.format, tc.expected, got)
+		}
+	}
+}
+
+func TestItems_NDJSON(t *testing.T) {
+	index, s := makeServer(t)
+	defer s.Shutdown()
+	defer index.Close()
+
+	for _, tc := range []struct {
+		query, accept, contentType, prefix string
+	}{
+		{"f=ndjson", "", "application/x-ndjson", "{"},
+		{"", "application/geo+json-seq", "application/geo+json-seq", "\x1e{"},
+	} {
+		req, _ := http.NewRequest("GET", "/collections/castles/items?"+tc.query, nil)
+		if tc.accept != "" {
+			req.Header.Set("Accept", tc.accept)
+		}
+		resp := httptest.NewRecorder()
+		http.HandlerFunc(s.HandleRequest).ServeHTTP(resp, req)
+		if ct := resp.Header().Get("Content-Type"); ct != tc.contentType {
+			t.Errorf("expected Content-Type %s, got %q", tc.contentType, ct)
+		}
+		lines := strings.Split(strings.TrimSuffix(getBody(resp), "\n"), "\n")
+		if len(lines) != 3 {
+			t.Errorf("expected 3 features, got %q", lines)
+			continue
+		}
+		for _, line := range lines {
+			if !strings.HasPrefix(line, tc.prefix) || !strings.Contains(line, `"type":"Feature"`) {
+				t.Errorf("unexpected line %q", line)
+			}
+		}
+	}
+}
@@ -0,0 +1,27 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// shutdownSignal returns a channel that gets closed when the server
+// should shut down gracefully, which is upon SIGINT or SIGTERM.
+func shutdownSignal() <-chan struct{} {
+	stop := make(chan struct{})
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		close(stop)
+	}()
+	return stop
+}
+
+// serviceStopped is called after the server has shut down. Only the
+// Windows service manager needs to be told.
+func serviceStopped() {}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"golang.org/x/sys/windows/svc"
+)
+
+// windowsServiceName is passed to the service manager, which ignores
+// it for services running in their own process. Install the service
+// with sc.exe create miniwfs binPath= "C:\path\to\miniwfs.exe --port=..."
+const windowsServiceName = "miniwfs"
+
+// When running as a Windows service, serviceDone gets closed after the
+// server has shut down, and serviceExited after we have reported that
+// to the service manager.
+var serviceDone, serviceExited chan struct{}
+
+// shutdownSignal returns a channel that gets closed when the server
+// should shut down gracefully. In a console, this happens upon Ctrl+C,
+// Ctrl+Break, or closing the console window; the Go runtime delivers
+// CTRL_CLOSE_EVENT, CTRL_LOGOFF_EVENT and CTRL_SHUTDOWN_EVENT as
+// SIGTERM and keeps the process alive while we shut down. As a Windows
+// service, it happens when the service manager sends a stop or
+// shutdown request.
+func shutdownSignal() <-chan struct{} {
+	stop := make(chan struct{})
+	var once sync.Once
+	shutdown := func() { once.Do(func() { close(stop) }) }
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		shutdown()
+	}()
+
+	if isService, err := svc.IsWindowsService(); err == nil && isService {
+		serviceDone, serviceExited = make(chan struct{}), make(chan struct{})
+		go func() {
+			if err := svc.Run(windowsServiceName, windowsService{shutdown}); err != nil {
+				log.Printf("running as Windows service failed: %v", err)
+				shutdown()
+			}
+			close(serviceExited)
+		}()
+	}
+	return stop
+}
+
+// serviceStopped tells the Windows service manager, if we are running
+// as a service, that the server has shut down.
+func serviceStopped() {
+	if serviceDone != nil {
+		close(serviceDone)
+		<-serviceExited
+	}
+}
+
+type windowsService struct {
+	shutdown func()
+}
+
+func (s windowsService) Execute(args []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	const accepted = svc.AcceptStop | svc.AcceptShutdown
+	status <- svc.Status{State: svc.Running, Accepts: accepted}
+	for {
+		select {
+		case r := <-requests:
+			switch r.Cmd {
+			case svc.Interrogate:
+				status <- r.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				status <- svc.Status{State: svc.StopPending, WaitHint: 30000}
+				s.shutdown()
+				<-serviceDone
+				return false, 0
+			}
+		case <-serviceDone: // shut down for another reason
+			return false, 0
+		}
+	}
+}
@@ -0,0 +1,439 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/paulmach/go.geojson"
+)
+
+// Esri Shapefiles, as specified in the "ESRI Shapefile Technical
+// Description" of July 1998. A shapefile is a set of files: lakes.shp
+// has the geometries, lakes.dbf the attributes in dBASE format. The
+// coordinates must be WGS84 longitude and latitude; we reject files
+// whose lakes.prj declares a projected coordinate system, since we
+// do not reproject. Any of the files may be gzipped, as in lakes.shp.gz.
+
+func init() {
+	RegisterInputLoader(shapefileLoader{}, []string{".shp"}, nil)
+}
+
+var MalformedShapefile error = errors.New("malformed shapefile")
+var ProjectedShapefile error = errors.New("shapefile has projected coordinates; please convert it to WGS84 longitude and latitude")
+
+// Shape types. Types with Z also have heights; types with M, which we
+// ignore, also have measures.
+const (
+	shpNull        = 0
+	shpPoint       = 1
+	shpPolyLine    = 3
+	shpPolygon     = 5
+	shpMultiPoint  = 8
+	shpPointZ      = 11
+	shpPolyLineZ   = 13
+	shpPolygonZ    = 15
+	shpMultiPointZ = 18
+	shpPointM      = 21
+	shpPolyLineM   = 23
+	shpPolygonM    = 25
+	shpMultiPointM = 28
+)
+
+type shapefileLoader struct{}
+
+func (shapefileLoader) Name() string {
+	return "shapefile"
+}
+
+// ModTime returns the later modification time of the .shp and .dbf
+// files, since either can change on its own.
+func (shapefileLoader) ModTime(source string) (time.Time, error) {
+	modTime, err := fileModTime(source)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if dbfTime, err := fileModTime(shapefileSidecar(source, ".dbf")); err == nil && dbfTime.After(modTime) {
+		modTime = dbfTime
+	}
+	return modTime, nil
+}
+
+func (shapefileLoader) Load(source string) (*SourceData, error) {
+	if prj, err := ioutil.ReadFile(shapefileSidecar(source, ".prj")); err == nil {
+		if bytes.Contains(bytes.ToUpper(prj), []byte("PROJCS")) {
+			return nil, ProjectedShapefile
+		}
+	}
+
+	shp, err := readSource(source)
+	if err != nil {
+		return nil, err
+	}
+	geometries, err := parseShapes(shp)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", source, err)
+	}
+
+	var records []map[string]interface{}
+	dbf, err := readSource(shapefileSidecar(source, ".dbf"))
+	if err == nil {
+		if records, err = parseDBF(dbf); err != nil {
+			return nil, fmt.Errorf("%s: %v", shapefileSidecar(source, ".dbf"), err)
+		}
+		if len(records) != len(geometries) {
+			return nil, fmt.Errorf("%s has %d shapes, but its attributes have %d records",
+				source, len(geometries), len(records))
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	result := &SourceData{Features: make([]*geojson.Feature, len(geometries))}
+	for i, g := range geometries {
+		f := &geojson.Feature{Type: "Feature", Geometry: g, Properties: make(map[string]interface{})}
+		if records != nil {
+			f.Properties = records[i]
+		}
+		// Shapefiles have no feature IDs, so we take them from an "id"
+		// attribute, or number features like the shapefile records.
+		f.ID = strconv.Itoa(i + 1)
+		for key, value := range f.Properties {
+			if strings.EqualFold(key, "id") && value != nil {
+				f.ID = formatGMLValue(value)
+			}
+		}
+		result.Features[i] = f
+	}
+	return result, nil
+}
+
+// shapefileSidecar returns the path of another file of a shapefile,
+// such as lakes.dbf for lakes.shp. Like GDAL, we try both lower and
+// upper case extensions.
+func shapefileSidecar(shp string, ext string) string {
+	base := strings.TrimSuffix(shp, filepath.Ext(shp))
+	if strings.EqualFold(filepath.Ext(shp), ".gz") {
+		base = strings.TrimSuffix(base, filepath.Ext(base))
+	}
+	path := base + ext
+	if upper := base + strings.ToUpper(ext); !fileExists(path) && fileExists(upper) {
+		return upper
+	}
+	return path
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// parseShapes decodes the geometries of a .shp file. Null shapes
+// give nil geometries.
+func parseShapes(data []byte) ([]*geojson.Geometry, error) {
+	if len(data) < 100 || binary.BigEndian.Uint32(data) != 9994 {
+		return nil, MalformedShapefile
+	}
+
+	var result []*geojson.Geometry
+	for pos := 100; pos < len(data); {
+		if pos+8 > len(data) {
+			return nil, MalformedShapefile
+		}
+		length := 2 * int(binary.BigEndian.Uint32(data[pos+4:]))
+		start := pos + 8
+		if length < 4 || start+length > len(data) {
+			return nil, MalformedShapefile
+		}
+		g, err := parseShape(data[start : start+length])
+		if err != nil {
+			return nil, fmt.Errorf("record %d: %v", len(result)+1, err)
+		}
+		result = append(result, g)
+		pos = start + length
+	}
+	return result, nil
+}
+
+func parseShape(rec []byte) (*geojson.Geometry, error) {
+	r := &shapeReader{data: rec}
+	shapeType := r.int32()
+	switch shapeType {
+	case shpNull:
+		return nil, r.err
+
+	case shpPoint, shpPointM, shpPointZ:
+		p := []float64{r.float64(), r.float64()}
+		if shapeType == shpPointZ {
+			p = append(p, r.float64())
+		}
+		return geojson.NewPointGeometry(p), r.err
+
+	case shpMultiPoint, shpMultiPointM, shpMultiPointZ:
+		r.skip(32) // bounding box
+		points := r.points(r.int32(), shapeType == shpMultiPointZ)
+		return geojson.NewMultiPointGeometry(points...), r.err
+
+	case shpPolyLine, shpPolyLineM, shpPolyLineZ, shpPolygon, shpPolygonM, shpPolygonZ:
+		r.skip(32) // bounding box
+		numParts, numPoints := r.int32(), r.int32()
+		if numParts < 0 || numParts > len(rec)/4 {
+			return nil, MalformedShapefile
+		}
+		starts := make([]int, numParts+1)
+		for i := 0; i < numParts; i++ {
+			starts[i] = r.int32()
+		}
+		starts[numParts] = numPoints
+		points := r.points(numPoints, shapeType == shpPolyLineZ || shapeType == shpPolygonZ)
+		if r.err != nil {
+			return nil, r.err
+		}
+		parts := make([][][]float64, numParts)
+		for i := range parts {
+			if starts[i] < 0 || starts[i] > starts[i+1] || starts[i+1] > len(points) {
+				return nil, MalformedShapefile
+			}
+			parts[i] = points[starts[i]:starts[i+1]]
+		}
+		if shapeType == shpPolyLine || shapeType == shpPolyLineM || shapeType == shpPolyLineZ {
+			if len(parts) == 1 {
+				return geojson.NewLineStringGeometry(parts[0]), nil
+			}
+			return geojson.NewMultiLineStringGeometry(parts...), nil
+		}
+		return shapefilePolygon(parts), nil
+	}
+	return nil, fmt.Errorf("unsupported shape type %d", shapeType)
+}
+
+// shapefilePolygon assembles the rings of a shapefile polygon into a
+// GeoJSON Polygon or MultiPolygon. In shapefiles, outer rings run
+// clockwise and holes counterclockwise, with no telling which hole
+// belongs to which outer ring. GeoJSON wants the opposite orientation.
+func shapefilePolygon(rings [][][]float64) *geojson.Geometry {
+	var polygons [][][][]float64
+	var holes [][][]float64
+	for _, ring := range rings {
+		if len(ring) < 4 {
+			continue
+		}
+		reverseRing(ring)
+		if signedRingArea(ring) > 0 {
+			polygons = append(polygons, [][][]float64{ring})
+		} else {
+			holes = append(holes, ring)
+		}
+	}
+	for _, hole := range holes {
+		owner := -1
+		for i, poly := range polygons {
+			if ringContains(poly[0], hole[0]) {
+				owner = i
+				break
+			}
+		}
+		if owner < 0 {
+			// Hole outside all outer rings: probably a ring with the
+			// wrong orientation, so we treat it as an outer ring.
+			reverseRing(hole)
+			polygons = append(polygons, [][][]float64{hole})
+			continue
+		}
+		polygons[owner] = append(polygons[owner], hole)
+	}
+	if len(polygons) == 1 {
+		return geojson.NewPolygonGeometry(polygons[0])
+	}
+	return geojson.NewMultiPolygonGeometry(polygons...)
+}
+
+// signedRingArea returns twice the area of a ring, positive if it runs
+// counterclockwise.
+func signedRingArea(ring [][]float64) float64 {
+	var area float64
+	for i := 0; i+1 < len(ring); i++ {
+		area += ring[i][0]*ring[i+1][1] - ring[i+1][0]*ring[i][1]
+	}
+	return area
+}
+
+func reverseRing(ring [][]float64) {
+	for i, j := 0, len(ring)-1; i < j; i, j = i+1, j-1 {
+		ring[i], ring[j] = ring[j], ring[i]
+	}
+}
+
+// ringContains tests whether a point is inside a ring, by counting how
+// often a ray from the point crosses the ring.
+func ringContains(ring [][]float64, p []float64) bool {
+	inside := false
+	for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+		a, b := ring[i], ring[j]
+		if (a[1] > p[1]) != (b[1] > p[1]) &&
+			p[0] < (b[0]-a[0])*(p[1]-a[1])/(b[1]-a[1])+a[0] {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+// shapeReader decodes the little-endian values of a shape record. After
+// running out of data, it returns zeros and remembers the error.
+type shapeReader struct {
+	data []byte
+	pos  int
+	err  error
+}
+
+func (r *shapeReader) skip(n int) {
+	if r.pos+n > len(r.data) {
+		r.err = MalformedShapefile
+		r.pos = len(r.data)
+		return
+	}
+	r.pos += n
+}
+
+func (r *shapeReader) int32() int {
+	if r.pos+4 > len(r.data) {
+		r.err = MalformedShapefile
+		return 0
+	}
+	v := int32(binary.LittleEndian.Uint32(r.data[r.pos:]))
+	r.pos += 4
+	return int(v)
+}
+
+func (r *shapeReader) float64() float64 {
+	if r.pos+8 > len(r.data) {
+		r.err = MalformedShapefile
+		return 0
+	}
+	v := math.Float64frombits(binary.LittleEndian.Uint64(r.data[r.pos:]))
+	r.pos += 8
+	return v
+}
+
+// points reads n points, followed by their heights if hasZ is set.
+func (r *shapeReader) points(n int, hasZ bool) [][]float64 {
+	if n < 0 || r.pos+16*n > len(r.data) {
+		r.err = MalformedShapefile
+		return nil
+	}
+	points := make([][]float64, n)
+	for i := range points {
+		points[i] = []float64{r.float64(), r.float64()}
+	}
+	if hasZ && r.pos+16+8*n <= len(r.data) {
+		r.skip(16) // range of heights
+		for i := range points {
+			points[i] = append(points[i], r.float64())
+		}
+	}
+	return points
+}
+
+// parseDBF decodes the records of a dBASE file, skipping deleted ones.
+// Text that is not valid UTF-8 gets decoded as Latin-1, which older
+// shapefiles often use.
+func parseDBF(data []byte) ([]map[string]interface{}, error) {
+	if len(data) < 32 {
+		return nil, MalformedShapefile
+	}
+	numRecords := int(binary.LittleEndian.Uint32(data[4:]))
+	headerLen := int(binary.LittleEndian.Uint16(data[8:]))
+	recordLen := int(binary.LittleEndian.Uint16(data[10:]))
+	if headerLen > len(data) || recordLen < 1 {
+		return nil, MalformedShapefile
+	}
+	// The record count comes from the file, so we check it against
+	// the data before allocating anything for the records.
+	if numRecords > (len(data)-headerLen)/recordLen {
+		return nil, MalformedShapefile
+	}
+
+	type field struct {
+		name   string
+		ftype  byte
+		offset int
+		length int
+	}
+	var fields []field
+	offset := 1 // deletion flag
+	for pos := 32; pos+32 <= headerLen && data[pos] != 0x0d; pos += 32 {
+		name := data[pos : pos+11]
+		if i := bytes.IndexByte(name, 0); i >= 0 {
+			name = name[:i]
+		}
+		f := field{dbfText(name), data[pos+11], offset, int(data[pos+16])}
+		fields = append(fields, f)
+		offset += f.length
+	}
+	if offset > recordLen {
+		return nil, MalformedShapefile
+	}
+
+	records := make([]map[string]interface{}, 0, numRecords)
+	for i := 0; i < numRecords; i++ {
+		start := headerLen + i*recordLen
+		rec := data[start : start+recordLen]
+		if rec[0] == '*' {
+			continue
+		}
+		props := make(map[string]interface{}, len(fields))
+		for _, f := range fields {
+			raw := strings.TrimSpace(dbfText(rec[f.offset : f.offset+f.length]))
+			props[f.name] = dbfValue(f.ftype, raw)
+		}
+		records = append(records, props)
+	}
+	return records, nil
+}
+
+func dbfValue(ftype byte, raw string) interface{} {
+	switch ftype {
+	case 'N', 'F':
+		if v, err := strconv.ParseFloat(raw, 64); err == nil {
+			return v
+		}
+		return nil
+	case 'L':
+		switch raw {
+		case "T", "t", "Y", "y":
+			return true
+		case "F", "f", "N", "n":
+			return false
+		}
+		return nil
+	case 'D':
+		if len(raw) == 8 {
+			return raw[0:4] + "-" + raw[4:6] + "-" + raw[6:8]
+		}
+		return nil
+	}
+	if len(raw) == 0 {
+		return nil
+	}
+	return raw
+}
+
+func dbfText(b []byte) string {
+	if utf8.Valid(b) {
+		return string(b)
+	}
+	runes := make([]rune, len(b))
+	for i, c := range b {
+		runes[i] = rune(c)
+	}
+	return string(runes)
+}
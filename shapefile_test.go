@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestShapefileLoader(t *testing.T) {
+	data, err := shapefileLoader{}.Load(filepath.Join("testdata", "parks.shp"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data.Features) != 3 {
+		t.Fatalf("expected 3 features, got %d", len(data.Features))
+	}
+
+	park := data.Features[0]
+	if park.ID != "1" || park.Properties["NAME"] != "Stadtpark" || park.Properties["AREA"] != 1600.5 ||
+		park.Properties["OPEN"] != true || park.Properties["OPENED"] != "1984-05-01" {
+		t.Errorf("unexpected feature %v %v", park.ID, park.Properties)
+	}
+	if g := park.Geometry; g == nil || !g.IsPolygon() || len(g.Polygon) != 2 {
+		t.Fatalf("expected polygon with hole, got %v", g)
+	}
+	if outer, hole := park.Geometry.Polygon[0], park.Geometry.Polygon[1]; signedRingArea(outer) <= 0 || signedRingArea(hole) >= 0 {
+		t.Error("expected counterclockwise outer ring and clockwise hole")
+	}
+
+	shore := data.Features[1]
+	if shore.Properties["NAME"] != "Seeufer Zürich" || shore.Properties["AREA"] != nil || shore.Properties["OPEN"] != false {
+		t.Errorf("unexpected properties %v", shore.Properties)
+	}
+	if g := shore.Geometry; g == nil || !g.IsMultiPolygon() || len(g.MultiPolygon) != 2 {
+		t.Errorf("expected multipolygon with two parts, got %v", g)
+	}
+
+	if brache := data.Features[2]; brache.Geometry != nil || brache.Properties["OPEN"] != nil {
+		t.Errorf("expected null geometry and unknown OPEN, got %v %v", brache.Geometry, brache.Properties)
+	}
+}
+
+func TestShapefileLoader_Collection(t *testing.T) {
+	var t0 time.Time
+	coll, err := readCollection("parks", filepath.Join("testdata", "parks.shp"), t0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer coll.Close()
+	if len(coll.id) != 3 {
+		t.Errorf("expected 3 features, got %v", coll.id)
+	}
+}
+
+func TestShapefileLoader_Projected(t *testing.T) {
+	dir, err := ioutil.TempDir("", "miniwfs-shapefile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, ext := range []string{".shp", ".dbf"} {
+		data, _ := ioutil.ReadFile(filepath.Join("testdata", "parks"+ext))
+		ioutil.WriteFile(filepath.Join(dir, "parks"+ext), data, 0644)
+	}
+	prj := `PROJCS["CH1903+_LV95",GEOGCS["GCS_CH1903+",DATUM["D_CH1903+"]]]`
+	ioutil.WriteFile(filepath.Join(dir, "parks.prj"), []byte(prj), 0644)
+
+	if _, err := (shapefileLoader{}).Load(filepath.Join(dir, "parks.shp")); err != ProjectedShapefile {
+		t.Errorf("expected ProjectedShapefile, got %v", err)
+	}
+}
+
+func TestParseShapes_Malformed(t *testing.T) {
+	data, _ := ioutil.ReadFile(filepath.Join("testdata", "parks.shp"))
+	if _, err := parseShapes(data[:len(data)-10]); err == nil {
+		t.Error("expected error for truncated shapefile")
+	}
+	if _, err := parseShapes([]byte("not a shapefile")); err != MalformedShapefile {
+		t.Errorf("expected MalformedShapefile, got %v", err)
+	}
+}
+
+func TestParseDBF_Malformed(t *testing.T) {
+	data, _ := ioutil.ReadFile(filepath.Join("testdata", "parks.dbf"))
+	if _, err := parseDBF(data[:len(data)-10]); err != MalformedShapefile {
+		t.Errorf("expected MalformedShapefile for truncated file, got %v", err)
+	}
+
+	// A tiny file claiming billions of records must not make us
+	// allocate memory for all of them.
+	data = make([]byte, 64)
+	binary.LittleEndian.PutUint32(data[4:], 0xFFFFFFFF)
+	binary.LittleEndian.PutUint16(data[8:], 33)
+	binary.LittleEndian.PutUint16(data[10:], 1)
+	data[32] = 0x0d
+	if _, err := parseDBF(data); err != MalformedShapefile {
+		t.Errorf("expected MalformedShapefile for huge record count, got %v", err)
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	numShedRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "miniwfs_shed_requests_total",
+		Help: "Total number of requests rejected because the server was overloaded, by request class and collection priority.",
+	},
+		[]string{"class", "priority"})
+	overloaded = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "miniwfs_overloaded",
+		Help: "1 if the server is shedding expensive requests, 0 otherwise.",
+	})
+)
+
+// Requests that are expensive to serve, and get shed first when the
+// server is overloaded. Cheap requests, such as fetching single items,
+// are always served.
+const (
+	shedClassItems = "items" // more than shedMaxLimit features
+	shedClassTiles = "tiles" // tiles below shedMinZoom, tile batches and offline packages
+)
+
+const shedMaxLimit = 1000
+const shedMinZoom = 6
+
+// Collection priorities. When the server comes under strain, requests
+// for low-priority collections, such as bulk downloads, get shed first;
+// once it is overloaded, expensive requests for normal collections
+// follow. Requests for high-priority collections, such as interactive
+// map layers, are never shed.
+const (
+	priorityHigh   = "high"
+	priorityNormal = "normal"
+	priorityLow    = "low"
+)
+
+// shedStrainFraction is the fraction of the overload thresholds at
+// which the server counts as strained, shedding low-priority requests.
+const shedStrainFraction = 0.8
+
+// Load levels, as stored in LoadShedder.level.
+const (
+	loadNormal     = 0
+	loadStrained   = 1
+	loadOverloaded = 2
+)
+
+// numLatencySamples is how many recent handler latencies we keep for
+// computing the 99th percentile.
+const numLatencySamples = 1024
+
+// LoadShedder protects the server against overload. When the 99th
+// percentile of recent handler latencies, or the CPU usage of the
+// process, crosses its threshold, expensive request classes get
+// rejected with 503 Service Unavailable until the signals recover.
+// Collection priorities tell which requests get rejected first.
+type LoadShedder struct {
+	maxLatency time.Duration     // zero to ignore latency
+	maxCPU     float64           // fraction of all cores, zero to ignore CPU
+	priorities map[string]string // collection name -> priority, normal if missing
+
+	mutex     sync.Mutex
+	latencies [numLatencySamples]time.Duration
+	numSeen   int
+	lastCheck time.Time
+	lastCPU   time.Duration
+
+	level int32 // loadNormal, loadStrained or loadOverloaded; accessed atomically
+	stop  chan struct{}
+}
+
+func MakeLoadShedder(maxLatency time.Duration, maxCPU float64, priorities map[string]string) *LoadShedder {
+	return &LoadShedder{maxLatency: maxLatency, maxCPU: maxCPU, priorities: priorities, stop: make(chan struct{})}
+}
+
+// Start checks the overload signals once per second until Stop is called.
+func (ls *LoadShedder) Start() {
+	ticker := time.NewTicker(time.Second)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case now := <-ticker.C:
+				ls.check(now)
+			case <-ls.stop:
+				return
+			}
+		}
+	}()
+}
+
+func (ls *LoadShedder) Stop() {
+	close(ls.stop)
+}
+
+// Record remembers how long a request took to handle.
+func (ls *LoadShedder) Record(d time.Duration) {
+	ls.mutex.Lock()
+	defer ls.mutex.Unlock()
+	ls.latencies[ls.numSeen%numLatencySamples] = d
+	ls.numSeen++
+}
+
+// Allow returns false if a request of the given class, for the given
+// collection, should be shed. The collection may be empty for requests
+// that do not concern any particular collection.
+func (ls *LoadShedder) Allow(class string, collection string) bool {
+	if ls == nil {
+		return true
+	}
+	level := atomic.LoadInt32(&ls.level)
+	priority := ls.priorities[collection]
+	if len(priority) == 0 {
+		priority = priorityNormal
+	}
+
+	var shed bool
+	switch priority {
+	case priorityLow:
+		shed = level >= loadStrained
+	case priorityNormal:
+		shed = level >= loadOverloaded && len(class) > 0
+	}
+	if !shed {
+		return true
+	}
+	if len(class) == 0 {
+		class = "cheap"
+	}
+	numShedRequests.WithLabelValues(class, priority).Inc()
+	return false
+}
+
+// check updates the overload state from the current signals.
+func (ls *LoadShedder) check(now time.Time) {
+	cpuTime, haveCPU := processCPUTime()
+	ls.mutex.Lock()
+	p99 := ls.latencyPercentile(0.99)
+	var cpu float64
+	if haveCPU && !ls.lastCheck.IsZero() {
+		if wall := now.Sub(ls.lastCheck); wall > 0 {
+			cpu = float64(cpuTime-ls.lastCPU) / float64(wall) / float64(runtime.NumCPU())
+		}
+	}
+	ls.lastCheck, ls.lastCPU = now, cpuTime
+	ls.mutex.Unlock()
+	ls.update(p99, cpu)
+}
+
+// update sets the overload state from a latency percentile and the
+// CPU usage as a fraction of all cores.
+func (ls *LoadShedder) update(p99 time.Duration, cpu float64) {
+	exceeds := func(fraction float64) bool {
+		return (ls.maxLatency > 0 && float64(p99) > fraction*float64(ls.maxLatency)) ||
+			(ls.maxCPU > 0 && cpu > fraction*ls.maxCPU)
+	}
+	var level int32 = loadNormal
+	if exceeds(1.0) {
+		level = loadOverloaded
+	} else if exceeds(shedStrainFraction) {
+		level = loadStrained
+	}
+	atomic.StoreInt32(&ls.level, level)
+	if level == loadOverloaded {
+		overloaded.Set(1)
+	} else {
+		overloaded.Set(0)
+	}
+}
+
+// latencyPercentile returns a percentile of the recent latencies.
+// Must be called with the mutex held.
+func (ls *LoadShedder) latencyPercentile(p float64) time.Duration {
+	n := ls.numSeen
+	if n > numLatencySamples {
+		n = numLatencySamples
+	}
+	if n == 0 {
+		return 0
+	}
+	sorted := make([]time.Duration, n)
+	copy(sorted, ls.latencies[:n])
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted[int(p*float64(n-1))]
+}
+
+// collectionPathRegexp matches the paths of requests that concern a
+// single collection, for looking up its priority.
+var collectionPathRegexp = regexp.MustCompile(`^/(collections|tiles)/([^/]+)(/.*)?$`)
+
+// requestCollection returns the collection that a request is about,
+// or the empty string if there is none.
+func requestCollection(path string) string {
+	if m := collectionPathRegexp.FindStringSubmatch(path); len(m) == 4 {
+		return m[2]
+	}
+	return ""
+}
+
+// parseCollectionPriorities parses a list of collection=priority, such
+// as "basemap=high,buildings=low".
+func parseCollectionPriorities(priorities string) map[string]string {
+	result := make(map[string]string)
+	for _, s := range splitList(priorities) {
+		p := strings.SplitN(s, "=", 2)
+		if len(p) != 2 || len(p[0]) == 0 || (p[1] != priorityHigh && p[1] != priorityNormal && p[1] != priorityLow) {
+			log.Fatal("malformed --collectionPriorities command-line argument; pass something like --collectionPriorities=basemap=high,buildings=low")
+		}
+		result[p[0]] = p[1]
+	}
+	return result
+}
+
+// requestCostClass returns the class of expensive requests that a
+// request belongs to, or the empty string for cheap requests.
+func requestCostClass(path string, req *http.Request) string {
+	if m := tilesRegexp.FindStringSubmatch(path); len(m) == 5 {
+		if tile, ok := ParseTileKey(m[2], m[3], m[4]); ok && tile.Zoom < shedMinZoom {
+			return shedClassTiles
+		}
+		return ""
+	}
+	if tileBatchRegexp.MatchString(path) || offlineRegexp.MatchString(path) {
+		return shedClassTiles
+	}
+	if collectionRegexp.MatchString(path) {
+		if limit, err := strconv.Atoi(req.URL.Query().Get("limit")); err == nil && limit > shedMaxLimit {
+			return shedClassItems
+		}
+	}
+	return ""
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestLoadShedder(t *testing.T) {
+	index, s := makeServer(t)
+	defer s.Shutdown()
+	defer index.Close()
+	s.shedder = MakeLoadShedder(100*time.Millisecond, 0.9, nil)
+
+	get := func(path string) int {
+		req, _ := http.NewRequest("GET", path, nil)
+		resp := httptest.NewRecorder()
+		http.HandlerFunc(s.HandleRequest).ServeHTTP(resp, req)
+		return resp.Code
+	}
+
+	paths := map[string]bool{ // path -> expensive
+		"/collections/castles/items?limit=5000": true,
+		"/tiles/castles/2/2/1.png":              true,
+		"/collections/castles/items?limit=5":    false,
+		"/collections/castles/items/N34729562":  false,
+		"/tiles/castles/12/2138/1420.png":       false,
+	}
+
+	s.shedder.update(50*time.Millisecond, 0.5)
+	for path := range paths {
+		if code := get(path); code != http.StatusOK {
+			t.Errorf("GET %s without overload: expected status 200, got %d", path, code)
+		}
+	}
+
+	for _, signal := range []struct {
+		p99 time.Duration
+		cpu float64
+	}{{time.Second, 0.5}, {50 * time.Millisecond, 0.95}} {
+		s.shedder.update(signal.p99, signal.cpu)
+		for path, expensive := range paths {
+			expected := http.StatusOK
+			if expensive {
+				expected = http.StatusServiceUnavailable
+			}
+			if code := get(path); code != expected {
+				t.Errorf("GET %s with p99=%v, cpu=%v: expected status %d, got %d",
+					path, signal.p99, signal.cpu, expected, code)
+			}
+		}
+	}
+}
+
+func TestLoadShedder_LatencyPercentile(t *testing.T) {
+	ls := MakeLoadShedder(time.Second, 0, nil)
+	for i := 1; i <= 2*numLatencySamples; i++ {
+		ls.Record(time.Duration(i) * time.Millisecond)
+	}
+	ls.mutex.Lock()
+	defer ls.mutex.Unlock()
+	if got := ls.latencyPercentile(0.99); got < 2000*time.Millisecond || got > 2048*time.Millisecond {
+		t.Errorf("expected p99 of the most recent samples, got %v", got)
+	}
+}
+
+func TestLoadShedder_Priorities(t *testing.T) {
+	ls := MakeLoadShedder(100*time.Millisecond, 0, parseCollectionPriorities("basemap=high,buildings=low"))
+	requests := []struct {
+		class      string
+		collection string
+	}{
+		{shedClassItems, "basemap"},
+		{"", "basemap"},
+		{shedClassItems, "castles"},
+		{"", "castles"},
+		{shedClassItems, "buildings"},
+		{"", "buildings"},
+	}
+	for _, c := range []struct {
+		p99      time.Duration
+		expected []bool
+	}{
+		{50 * time.Millisecond, []bool{true, true, true, true, true, true}},
+		{90 * time.Millisecond, []bool{true, true, true, true, false, false}},
+		{time.Second, []bool{true, true, false, true, false, false}},
+	} {
+		ls.update(c.p99, 0)
+		for i, r := range requests {
+			if got := ls.Allow(r.class, r.collection); got != c.expected[i] {
+				t.Errorf("p99=%v, class=%q, collection=%s: expected %v, got %v",
+					c.p99, r.class, r.collection, c.expected[i], got)
+			}
+		}
+	}
+}
+
+func TestRequestCollection(t *testing.T) {
+	for path, expected := range map[string]string{
+		"/collections/castles/items":  "castles",
+		"/collections/castles":        "castles",
+		"/tiles/castles/12/1/2.png":   "castles",
+		"/collections":                "",
+		"/admin/collections/x/upload": "",
+	} {
+		if got := requestCollection(path); got != expected {
+			t.Errorf("requestCollection(%q): expected %q, got %q", path, expected, got)
+		}
+	}
+}
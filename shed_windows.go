@@ -0,0 +1,9 @@
+package main
+
+import "time"
+
+// processCPUTime is not implemented on Windows, so load shedding only
+// looks at latency there.
+func processCPUTime() (time.Duration, bool) {
+	return 0, false
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var numSlowQueries = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "miniwfs_slow_queries_total",
+	Help: "Total number of items requests exceeding the slow query thresholds.",
+},
+	[]string{"collection"})
+
+// slowQueryLogSize is how many slow queries we keep for /admin/slowlog.
+const slowQueryLogSize = 100
+
+// SlowQueryLog remembers items requests that took long or examined
+// many features, so we can find pathological query patterns. Queries
+// get logged, and the most recent ones are kept in memory.
+type SlowQueryLog struct {
+	latency time.Duration // 0 for no latency threshold
+	scanned int           // 0 for no threshold on examined features
+
+	mutex   sync.Mutex
+	entries []SlowQuery // ring buffer
+	next    int         // where the next entry goes
+}
+
+// SlowQuery describes an items request that exceeded a threshold.
+type SlowQuery struct {
+	Time       time.Time       `json:"time"`
+	Collection string          `json:"collection"`
+	Duration   float64         `json:"durationSeconds"`
+	Params     SlowQueryParams `json:"params"`
+	Plan       QueryPlan       `json:"plan"`
+}
+
+// SlowQueryParams are the parsed parameters of a slow query.
+type SlowQueryParams struct {
+	Bbox       []float64      `json:"bbox,omitempty"`
+	Properties PropertyFilter `json:"properties,omitempty"`
+	IDs        []string       `json:"ids,omitempty"`
+	Query      string         `json:"q,omitempty"`
+	Within     string         `json:"within,omitempty"`
+	Filter     string         `json:"filter,omitempty"`
+	StartID    string         `json:"startID,omitempty"`
+	Start      int            `json:"start"`
+	Limit      int            `json:"limit"`
+}
+
+func MakeSlowQueryLog(latency time.Duration, scanned int) *SlowQueryLog {
+	return &SlowQueryLog{latency: latency, scanned: scanned}
+}
+
+// Check logs a query if it exceeds a threshold. Does nothing if l is nil.
+func (l *SlowQueryLog) Check(start time.Time, collection string, params SlowQueryParams, plan QueryPlan) {
+	if l == nil {
+		return
+	}
+	duration := time.Since(start)
+	if !(l.latency > 0 && duration >= l.latency) && !(l.scanned > 0 && plan.Candidates >= l.scanned) {
+		return
+	}
+
+	numSlowQueries.WithLabelValues(collection).Inc()
+	httpLog.Warn("slow query", "collection", collection, "duration", duration,
+		"scanned", plan.Candidates, "plan", plan.String(), "params", params)
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	entry := SlowQuery{start.UTC(), collection, duration.Seconds(), params, plan}
+	if len(l.entries) < slowQueryLogSize {
+		l.entries = append(l.entries, entry)
+	} else {
+		l.entries[l.next] = entry
+	}
+	l.next = (l.next + 1) % slowQueryLogSize
+}
+
+// Entries returns the remembered slow queries, most recent first.
+func (l *SlowQueryLog) Entries() []SlowQuery {
+	result := []SlowQuery{}
+	if l == nil {
+		return result
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	for k := 1; k <= len(l.entries); k++ {
+		i := (l.next - k + len(l.entries)) % len(l.entries)
+		result = append(result, l.entries[i])
+	}
+	return result
+}
+
+// makeSlowQueryParams describes the parameters of an items request.
+func makeSlowQueryParams(sel *itemsSelection, startID string, start int, limit int) SlowQueryParams {
+	params := SlowQueryParams{
+		Bbox:       EncodeBbox(sel.bbox),
+		Properties: sel.filter,
+		IDs:        sel.ids,
+		Query:      sel.query,
+		StartID:    startID,
+		Start:      start,
+		Limit:      limit,
+	}
+	if sel.join != nil {
+		params.Within = sel.join.Collection + ":" + sel.join.ID
+	}
+	if sel.cql != nil {
+		params.Filter = sel.cql.Text
+	}
+	return params
+}
+
+// handleSlowLogRequest returns the most recent slow queries.
+func (s *WebServer) handleSlowLogRequest(w http.ResponseWriter, req *http.Request) {
+	encoded, err := json.Marshal(s.slowlog.Entries())
+	if err != nil {
+		httpLog.Error("json.Marshal failed", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(encoded)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(encoded)
+}
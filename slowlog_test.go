@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSlowQueryLog_Ring(t *testing.T) {
+	l := MakeSlowQueryLog(time.Hour, 10)
+	start := time.Now()
+	l.Check(start, "castles", SlowQueryParams{}, QueryPlan{Strategy: planScan, Candidates: 9})
+	if n := len(l.Entries()); n != 0 {
+		t.Fatalf("expected fast query to be ignored, got %d entries", n)
+	}
+
+	for i := 0; i < slowQueryLogSize+5; i++ {
+		l.Check(start, "castles", SlowQueryParams{Start: i}, QueryPlan{Strategy: planScan, Candidates: 10})
+	}
+	entries := l.Entries()
+	if len(entries) != slowQueryLogSize {
+		t.Fatalf("expected %d entries, got %d", slowQueryLogSize, len(entries))
+	}
+	if first, last := entries[0].Params.Start, entries[len(entries)-1].Params.Start; first != slowQueryLogSize+4 || last != 5 {
+		t.Errorf("expected entries 104..5, got %d..%d", first, last)
+	}
+
+	var nilLog *SlowQueryLog
+	nilLog.Check(start, "castles", SlowQueryParams{}, QueryPlan{})
+	if n := len(nilLog.Entries()); n != 0 {
+		t.Errorf("expected nil log to be empty, got %d entries", n)
+	}
+}
+
+func TestSlowQueryLog_AdminEndpoint(t *testing.T) {
+	index, s := makeServer(t)
+	defer s.Shutdown()
+	defer index.Close()
+	s.slowlog = MakeSlowQueryLog(0, 3)
+
+	for _, q := range []string{
+		"/collections/castles/items?name=Castello+Scaligero",
+		"/collections/castles/items?bbox=5,40,15,50&limit=2",
+	} {
+		query, _ := http.NewRequest("GET", q, nil)
+		http.HandlerFunc(s.HandleRequest).ServeHTTP(httptest.NewRecorder(), query)
+	}
+
+	query, _ := http.NewRequest("GET", "/admin/slowlog", nil)
+	resp := httptest.NewRecorder()
+	http.HandlerFunc(s.HandleRequest).ServeHTTP(resp, query)
+	if resp.Code != http.StatusNotFound {
+		t.Errorf("expected /admin/slowlog to be hidden on the public listener, got %d", resp.Code)
+	}
+
+	s.admin = true
+	resp = httptest.NewRecorder()
+	http.HandlerFunc(s.HandleRequest).ServeHTTP(resp, query)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", resp.Code)
+	}
+	var entries []SlowQuery
+	if err := json.Unmarshal(resp.Body.Bytes(), &entries); err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("expected only the bbox scan to be slow, got %d entries", len(entries))
+	}
+	e := entries[0]
+	if e.Collection != "castles" || e.Params.Limit != 2 || len(e.Params.Bbox) != 4 || e.Plan.Strategy != planScan || e.Plan.Candidates != 3 {
+		t.Errorf("unexpected entry %+v", e)
+	}
+}
@@ -0,0 +1,445 @@
+package main
+
+import (
+	"bufio"
+	"container/list"
+	"encoding/binary"
+	"hash/fnv"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/golang/geo/r1"
+	"github.com/golang/geo/r2"
+	"github.com/golang/geo/s1"
+	"github.com/golang/geo/s2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Spilled indexes, for collections too large to keep their per-feature
+// arrays and ID maps in memory. After loading, such a collection writes
+// bounding boxes, positions, hashes and IDs to a temporary index file,
+// together with two tables sorted by the hash of feature IDs and short
+// tokens, which take the role of byID and byShortToken. Lookups read
+// the file through a PageCache shared by all collections, so memory
+// stays bounded at the cost of slower queries. The property and
+// full-text indexes stay in memory; so does the loading itself.
+//
+// We read the file with ReadAt instead of memory-mapping it, because
+// mapped pages count against the process but not against our limit.
+
+var (
+	numSpillCacheHits = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "miniwfs_spillcache_hits_total",
+		Help: "Total number of spilled index pages found in the page cache.",
+	})
+	numSpillCacheMisses = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "miniwfs_spillcache_misses_total",
+		Help: "Total number of spilled index pages read from disk.",
+	})
+	numSpillReadErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "miniwfs_spill_read_errors_total",
+		Help: "Total number of failed reads from spilled index files.",
+	})
+)
+
+// spillPageSize is the unit in which index files get cached.
+const spillPageSize = 64 * 1024
+
+// Layout of a feature record in the index file, little endian.
+const (
+	spillDataOffset = 0  // int64, position of the feature in dataFile
+	spillDataEnd    = 8  // int64
+	spillIDOffset   = 16 // int64, position of the ID in the ID heap
+	spillIDEnd      = 24 // int64
+	spillBounds     = 32 // 4 float64: lat.lo, lat.hi, lng.lo, lng.hi in radians
+	spillPoint      = 64 // 2 float64: web mercator x, y
+	spillHash       = 80 // uint64
+	spillRecordSize = 88
+
+	spillKeySize = 12 // uint64 hash, uint32 feature index
+)
+
+// PageCache keeps recently used pages of spilled index files in memory,
+// evicting the least recently used page when full.
+type PageCache struct {
+	maxPages int
+	mutex    sync.Mutex
+	pages    map[spillPageKey]*list.Element
+	lru      *list.List // front is most recently used
+	nextFile uint64
+}
+
+type spillPageKey struct {
+	file uint64
+	page int64
+}
+
+type spillPage struct {
+	key  spillPageKey
+	data []byte
+}
+
+func MakePageCache(maxBytes int64) *PageCache {
+	maxPages := int(maxBytes / spillPageSize)
+	if maxPages < 1 {
+		maxPages = 1
+	}
+	return &PageCache{maxPages: maxPages, pages: make(map[spillPageKey]*list.Element), lru: list.New()}
+}
+
+// readAt fills b with the content of a file at offset off.
+func (c *PageCache) readAt(file *os.File, fileID uint64, b []byte, off int64) error {
+	for len(b) > 0 {
+		page, err := c.getPage(file, fileID, off/spillPageSize)
+		if err != nil {
+			return err
+		}
+		start := int(off % spillPageSize)
+		if start >= len(page) {
+			return io.ErrUnexpectedEOF
+		}
+		n := copy(b, page[start:])
+		b, off = b[n:], off+int64(n)
+	}
+	return nil
+}
+
+func (c *PageCache) getPage(file *os.File, fileID uint64, page int64) ([]byte, error) {
+	key := spillPageKey{fileID, page}
+	c.mutex.Lock()
+	if e, ok := c.pages[key]; ok {
+		c.lru.MoveToFront(e)
+		c.mutex.Unlock()
+		numSpillCacheHits.Inc()
+		return e.Value.(*spillPage).data, nil
+	}
+	c.mutex.Unlock()
+
+	// Read without holding the lock, so other lookups can proceed.
+	// If two goroutines miss the same page, both read it; that is
+	// cheaper than coordinating them.
+	numSpillCacheMisses.Inc()
+	data := make([]byte, spillPageSize)
+	n, err := file.ReadAt(data, page*spillPageSize)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	data = data[:n]
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if e, ok := c.pages[key]; ok {
+		c.lru.MoveToFront(e)
+		return e.Value.(*spillPage).data, nil
+	}
+	c.pages[key] = c.lru.PushFront(&spillPage{key, data})
+	for c.lru.Len() > c.maxPages {
+		oldest := c.lru.Back()
+		delete(c.pages, oldest.Value.(*spillPage).key)
+		c.lru.Remove(oldest)
+	}
+	return data, nil
+}
+
+// newFileID returns a number for identifying a file in the cache.
+func (c *PageCache) newFileID() uint64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.nextFile++
+	return c.nextFile
+}
+
+// forget drops the cached pages of a file that is about to be deleted.
+func (c *PageCache) forget(fileID uint64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for key, e := range c.pages {
+		if key.file == fileID {
+			delete(c.pages, key)
+			c.lru.Remove(e)
+		}
+	}
+}
+
+// countCached returns how many of the given pages of a file are
+// currently in the cache.
+func (c *PageCache) countCached(fileID uint64, pages map[int64]bool) int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	n := 0
+	for page := range pages {
+		if _, ok := c.pages[spillPageKey{fileID, page}]; ok {
+			n++
+		}
+	}
+	return n
+}
+
+// spilledIndex is the on-disk replacement for the per-feature arrays
+// and ID maps of a Collection.
+type spilledIndex struct {
+	cache       *PageCache
+	file        *os.File // temporary file, will be deleted
+	fileID      uint64
+	numFeatures int
+	numIDs      int
+	byID        int64 // offset of the table sorted by hash of ID
+	byToken     int64 // offset of the table sorted by hash of short token
+	idHeap      int64 // offset of the concatenated IDs
+}
+
+// spill moves the index of a collection to a temporary file, releasing
+// the in-memory arrays and maps.
+func (c *Collection) spill(cache *PageCache) error {
+	file, err := createTempFile("miniwfs-*.index")
+	if err != nil {
+		return err
+	}
+	s := &spilledIndex{cache: cache, file: file, fileID: cache.newFileID(), numFeatures: len(c.id)}
+	if err := s.write(c); err != nil {
+		s.close()
+		return err
+	}
+
+	c.spilled = s
+	c.offset, c.bbox, c.webMercator, c.id, c.hash = nil, nil, nil, nil, nil
+	c.byID, c.byShortToken = nil, nil
+	return nil
+}
+
+func (s *spilledIndex) write(c *Collection) error {
+	out := bufio.NewWriterSize(s.file, spillPageSize)
+	var pos int64
+	write := func(b []byte) {
+		n, _ := out.Write(b) // errors stick, and get returned by Flush
+		pos += int64(n)
+	}
+
+	var byID, byToken []spillKey
+	var idPos int64
+	record := make([]byte, spillRecordSize)
+	for i, id := range c.id {
+		rect, point := c.bbox[i], c.webMercator[i]
+		binary.LittleEndian.PutUint64(record[spillDataOffset:], uint64(c.offset[i]))
+		binary.LittleEndian.PutUint64(record[spillDataEnd:], uint64(c.offset[i+1]-2))
+		binary.LittleEndian.PutUint64(record[spillIDOffset:], uint64(idPos))
+		binary.LittleEndian.PutUint64(record[spillIDEnd:], uint64(idPos+int64(len(id))))
+		for k, v := range []float64{rect.Lat.Lo, rect.Lat.Hi, rect.Lng.Lo, rect.Lng.Hi, point.X, point.Y} {
+			binary.LittleEndian.PutUint64(record[spillBounds+8*k:], math.Float64bits(v))
+		}
+		binary.LittleEndian.PutUint64(record[spillHash:], c.hash[i])
+		write(record)
+
+		idPos += int64(len(id))
+		if len(id) > 0 && c.byID[id] == i {
+			byID = append(byID, spillKey{hashSpillKey(id), uint32(i)})
+			byToken = append(byToken, spillKey{hashSpillKey(ShortToken(c.metadata.Name, id)), uint32(i)})
+		}
+	}
+
+	s.numIDs = len(byID)
+	writeTable := func(table []spillKey) int64 {
+		start := pos
+		sort.Slice(table, func(a, b int) bool { return table[a].hash < table[b].hash })
+		var entry [spillKeySize]byte
+		for _, k := range table {
+			binary.LittleEndian.PutUint64(entry[0:], k.hash)
+			binary.LittleEndian.PutUint32(entry[8:], k.index)
+			write(entry[:])
+		}
+		return start
+	}
+	s.byID = writeTable(byID)
+	s.byToken = writeTable(byToken)
+
+	s.idHeap = pos
+	for _, id := range c.id {
+		write([]byte(id))
+	}
+	return out.Flush()
+}
+
+type spillKey struct {
+	hash  uint64
+	index uint32
+}
+
+func hashSpillKey(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	return h.Sum64()
+}
+
+func (s *spilledIndex) close() {
+	s.cache.forget(s.fileID)
+	removeTempFile(s.file)
+}
+
+// read fills b from the index file. Since the file is our own, read
+// errors are unexpected; we count them and return zeros, so that a
+// failing disk degrades results instead of crashing the server.
+func (s *spilledIndex) read(b []byte, off int64) []byte {
+	if err := s.cache.readAt(s.file, s.fileID, b, off); err != nil {
+		numSpillReadErrors.Inc()
+		loaderLog.Error("cannot read spilled index", "path", s.file.Name(), "error", err)
+		for k := range b {
+			b[k] = 0
+		}
+	}
+	return b
+}
+
+func (s *spilledIndex) readUint64(off int64) uint64 {
+	var b [8]byte
+	return binary.LittleEndian.Uint64(s.read(b[:], off))
+}
+
+func (s *spilledIndex) readFloat64(off int64) float64 {
+	return math.Float64frombits(s.readUint64(off))
+}
+
+func (s *spilledIndex) record(i int) int64 {
+	return int64(i) * spillRecordSize
+}
+
+func (s *spilledIndex) id(i int) string {
+	start, end := s.readUint64(s.record(i)+spillIDOffset), s.readUint64(s.record(i)+spillIDEnd)
+	if end <= start {
+		return ""
+	}
+	return string(s.read(make([]byte, end-start), s.idHeap+int64(start)))
+}
+
+// lookup finds a feature in a table sorted by key hash, checking
+// candidates with the same hash by calling matches.
+func (s *spilledIndex) lookup(table int64, key string, matches func(i int) bool) (int, bool) {
+	h := hashSpillKey(key)
+	var entry [spillKeySize]byte
+	readEntry := func(k int) (uint64, int) {
+		s.read(entry[:], table+int64(k)*spillKeySize)
+		return binary.LittleEndian.Uint64(entry[0:]), int(binary.LittleEndian.Uint32(entry[8:]))
+	}
+	k := sort.Search(s.numIDs, func(k int) bool {
+		hash, _ := readEntry(k)
+		return hash >= h
+	})
+	for ; k < s.numIDs; k++ {
+		hash, i := readEntry(k)
+		if hash != h {
+			break
+		}
+		if matches(i) {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// The following accessors work for both in-memory and spilled indexes.
+
+func (c *Collection) numFeatures() int {
+	if c.spilled != nil {
+		return c.spilled.numFeatures
+	}
+	return len(c.id)
+}
+
+func (c *Collection) featureID(i int) string {
+	if c.spilled != nil {
+		return c.spilled.id(i)
+	}
+	return c.id[i]
+}
+
+func (c *Collection) featureBounds(i int) s2.Rect {
+	if c.spilled != nil {
+		off := c.spilled.record(i) + spillBounds
+		return s2.Rect{
+			Lat: r1.Interval{Lo: c.spilled.readFloat64(off), Hi: c.spilled.readFloat64(off + 8)},
+			Lng: s1.Interval{Lo: c.spilled.readFloat64(off + 16), Hi: c.spilled.readFloat64(off + 24)},
+		}
+	}
+	return c.bbox[i]
+}
+
+// featurePoint returns the web mercator position of a feature's center.
+func (c *Collection) featurePoint(i int) r2.Point {
+	if c.spilled != nil {
+		off := c.spilled.record(i) + spillPoint
+		return r2.Point{X: c.spilled.readFloat64(off), Y: c.spilled.readFloat64(off + 8)}
+	}
+	return c.webMercator[i]
+}
+
+func (c *Collection) featureHash(i int) uint64 {
+	if c.spilled != nil {
+		return c.spilled.readUint64(c.spilled.record(i) + spillHash)
+	}
+	return c.hash[i]
+}
+
+// featureRange returns the position and length of a feature's GeoJSON
+// encoding in dataFile.
+func (c *Collection) featureRange(i int) (int64, int) {
+	if c.spilled != nil {
+		start := c.spilled.readUint64(c.spilled.record(i) + spillDataOffset)
+		end := c.spilled.readUint64(c.spilled.record(i) + spillDataEnd)
+		return int64(start), int(end - start)
+	}
+	return c.offset[i], int(c.offset[i+1] - c.offset[i] - 2)
+}
+
+func (c *Collection) lookupID(id string) (int, bool) {
+	if c.spilled != nil {
+		return c.spilled.lookup(c.spilled.byID, id, func(i int) bool {
+			return c.spilled.id(i) == id
+		})
+	}
+	i, ok := c.byID[id]
+	return i, ok
+}
+
+func (c *Collection) lookupShortToken(token string) (int, bool) {
+	if c.spilled != nil {
+		return c.spilled.lookup(c.spilled.byToken, token, func(i int) bool {
+			return ShortToken(c.metadata.Name, c.spilled.id(i)) == token
+		})
+	}
+	i, ok := c.byShortToken[token]
+	return i, ok
+}
+
+// webMercatorPoints returns the web mercator positions of all features.
+// The result must not be modified.
+func (c *Collection) webMercatorPoints() []r2.Point {
+	if c.spilled == nil {
+		return c.webMercator
+	}
+	points := make([]r2.Point, c.spilled.numFeatures)
+	for i := range points {
+		points[i] = c.featurePoint(i)
+	}
+	return points
+}
+
+// SetSpillThreshold makes collections with at least threshold features
+// keep their index on disk, caching up to cacheBytes of it in memory.
+// Zero disables spilling. The affected collections get reloaded.
+func (index *Index) SetSpillThreshold(threshold int, cacheBytes int64) {
+	index.mutex.Lock()
+	index.spillThreshold = threshold
+	if threshold > 0 {
+		index.spillCache = MakePageCache(cacheBytes)
+	}
+	index.mutex.Unlock()
+
+	// Called with the mutex held.
+	index.reloadCollections(func(name string) bool {
+		coll := index.Collections[name]
+		return coll.spilled != nil || (threshold > 0 && coll.numFeatures() >= threshold)
+	})
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/golang/geo/s2"
+)
+
+func TestSpillThreshold(t *testing.T) {
+	memIndex := loadTestIndex(t)
+	defer memIndex.Close()
+	index := loadTestIndex(t)
+	defer index.Close()
+	index.SetSpillThreshold(1, 1)
+
+	castles := index.Collections["castles"]
+	if castles.spilled == nil || castles.byID != nil {
+		t.Fatal("expected castles to be spilled")
+	}
+	path := castles.spilled.file.Name()
+
+	for _, tc := range []struct {
+		startID string
+		start   int
+		bbox    s2.Rect
+	}{
+		{"", 0, s2.FullRect()},
+		{"W418392510", 0, s2.FullRect()},
+		{"", 1, s2.RectFromLatLng(s2.LatLngFromDegrees(47.910414, 11.183468))},
+	} {
+		q := itemsQuery{itemsSelection: itemsSelection{bbox: tc.bbox}, startID: tc.startID, start: tc.start, limit: 2}
+		var expected, got bytes.Buffer
+		if _, _, err := memIndex.GetItems("castles", q,
+			noTime, noTime, "https://test.example.org/wfs/", false, &expected); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := index.GetItems("castles", q,
+			noTime, noTime, "https://test.example.org/wfs/", false, &got); err != nil {
+			t.Fatal(err)
+		}
+		if got.String() != expected.String() {
+			t.Errorf("startID=%q start=%d: expected %s, got %s", tc.startID, tc.start, expected.String(), got.String())
+		}
+	}
+
+	if f, _ := index.GetItem("castles", "W418392510"); f == nil || f.Properties["name"] != "Castello Scaligero" {
+		t.Errorf("expected W418392510, got %v", f)
+	}
+	if index.HasItem("castles", "N0") || !index.HasItem("castles", "N34729562") {
+		t.Error("HasItem does not work on spilled index")
+	}
+	if coll, id := index.ResolveShortToken(ShortToken("castles", "W24785843")); coll != "castles" || id != "W24785843" {
+		t.Errorf("expected castles/W24785843, got %s/%s", coll, id)
+	}
+	if diff := diffCollections(memIndex.Collections["castles"], castles); len(diff.Added)+len(diff.Removed)+len(diff.Changed) > 0 {
+		t.Errorf("expected no difference to in-memory index, got %v", diff)
+	}
+	if memory := castles.estimateDataMemory(); memory >= memIndex.Collections["castles"].estimateDataMemory() {
+		t.Errorf("expected spilled index to use less memory, got %d bytes", memory)
+	}
+
+	index.SetSpillThreshold(0, 0)
+	if index.Collections["castles"].spilled != nil {
+		t.Error("expected index to be back in memory")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("expected %s to be removed, got %v", path, err)
+	}
+}
+
+func TestPageCache(t *testing.T) {
+	coll, err := readMigratedCollection("castles", filepath.Join("testdata", "castles.geojson"), noTime,
+		loadOptions{spillThreshold: 1, spillCache: MakePageCache(spillPageSize)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer coll.Close()
+	cache := coll.spilled.cache
+
+	// Records and ID tables share the first page, the IDs follow.
+	if _, ok := coll.lookupID("W418392510"); !ok {
+		t.Fatal("lookupID failed")
+	}
+	if cache.lru.Len() != 1 {
+		t.Errorf("expected 1 cached page, got %d", cache.lru.Len())
+	}
+
+	other, err := readMigratedCollection("lakes", filepath.Join("testdata", "lakes.geojson"), noTime,
+		loadOptions{spillThreshold: 1, spillCache: cache})
+	if err != nil {
+		t.Fatal(err)
+	}
+	other.featureID(0)
+	if cache.lru.Len() != 1 || cache.pages[spillPageKey{other.spilled.fileID, 0}] == nil {
+		t.Error("expected least recently used page to be evicted")
+	}
+	other.Close()
+	if cache.lru.Len() != 0 {
+		t.Errorf("expected pages of closed file to be dropped, got %d", cache.lru.Len())
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"strconv"
+
+	"github.com/paulmach/go.geojson"
+)
+
+// Splitting multi-part geometries. A MultiPolygon of islands scattered
+// over an ocean has a huge bounding box, so it touches many tiles and
+// gets hit by feature-info queries far from any of its parts. When
+// loading configured collections, we replace each feature with a
+// MultiPoint, MultiLineString or MultiPolygon geometry by one feature
+// per part. The parts of W123 get IDs W123#1, W123#2, and so on, and
+// carry the properties of their parent plus its ID.
+
+// SplitParentProperty is the property holding the ID of the feature
+// that a part has been split from.
+const SplitParentProperty = "parent"
+
+// SetSplitMultiGeometries configures the collections whose multi-part
+// features get split into one feature per part. The affected
+// collections get reloaded right away.
+func (index *Index) SetSplitMultiGeometries(collections []string) {
+	split := makeSplitSet(collections)
+	index.mutex.Lock()
+	old := index.splitMulti
+	index.splitMulti = split
+	index.mutex.Unlock()
+
+	index.reloadCollections(func(name string) bool {
+		return split[name] != old[name]
+	})
+}
+
+// makeSplitSet returns the set of collections whose multi-part
+// features get split.
+func makeSplitSet(collections []string) map[string]bool {
+	split := make(map[string]bool, len(collections))
+	for _, c := range collections {
+		split[c] = true
+	}
+	return split
+}
+
+// splitMultiGeometry returns one feature for each part of a feature
+// with a multi-part geometry. Other features, and multi-part ones
+// without any parts, are returned as they are.
+func splitMultiGeometry(f *geojson.Feature) []*geojson.Feature {
+	g := f.Geometry
+	if g == nil {
+		return []*geojson.Feature{f}
+	}
+	var parts []*geojson.Geometry
+	switch g.Type {
+	case geojson.GeometryMultiPoint:
+		for _, p := range g.MultiPoint {
+			parts = append(parts, geojson.NewPointGeometry(p))
+		}
+	case geojson.GeometryMultiLineString:
+		for _, line := range g.MultiLineString {
+			parts = append(parts, geojson.NewLineStringGeometry(line))
+		}
+	case geojson.GeometryMultiPolygon:
+		for _, poly := range g.MultiPolygon {
+			parts = append(parts, geojson.NewPolygonGeometry(poly))
+		}
+	}
+	if len(parts) == 0 {
+		return []*geojson.Feature{f}
+	}
+
+	id := getIDString(f.ID)
+	result := make([]*geojson.Feature, len(parts))
+	for i, part := range parts {
+		r := geojson.NewFeature(part)
+		for key, value := range f.Properties {
+			r.Properties[key] = value
+		}
+		if len(id) > 0 {
+			r.ID = id + "#" + strconv.Itoa(i+1)
+			r.Properties[SplitParentProperty] = f.ID
+		}
+		result[i] = r
+	}
+	return result
+}
+
+// splitMultiGeometries splits the multi-part features of a list, see
+// splitMultiGeometry.
+func splitMultiGeometries(features []*geojson.Feature) []*geojson.Feature {
+	result := make([]*geojson.Feature, 0, len(features))
+	for _, f := range features {
+		result = append(result, splitMultiGeometry(f)...)
+	}
+	return result
+}
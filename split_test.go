@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/paulmach/go.geojson"
+)
+
+func TestSplitMultiGeometry(t *testing.T) {
+	for _, tc := range []struct{ feature, expected string }{
+		{
+			`{"type":"Feature","id":"W123","geometry":{"type":"MultiPolygon","coordinates":[[[[0,0],[1,0],[1,1],[0,0]]],[[[5,5],[6,5],[6,6],[5,5]]]]},"properties":{"name":"Islands"}}`,
+			`{"id":"W123#1","type":"Feature","geometry":{"type":"Polygon","coordinates":[[[0,0],[1,0],[1,1],[0,0]]]},"properties":{"name":"Islands","parent":"W123"}}
+{"id":"W123#2","type":"Feature","geometry":{"type":"Polygon","coordinates":[[[5,5],[6,5],[6,6],[5,5]]]},"properties":{"name":"Islands","parent":"W123"}}`,
+		},
+		{
+			`{"type":"Feature","id":7,"geometry":{"type":"MultiPoint","coordinates":[[1,2],[3,4]]},"properties":{}}`,
+			`{"id":"7#1","type":"Feature","geometry":{"type":"Point","coordinates":[1,2]},"properties":{"parent":7}}
+{"id":"7#2","type":"Feature","geometry":{"type":"Point","coordinates":[3,4]},"properties":{"parent":7}}`,
+		},
+		{
+			`{"type":"Feature","geometry":{"type":"MultiLineString","coordinates":[[[1,2],[3,4]]]},"properties":{}}`,
+			`{"type":"Feature","geometry":{"type":"LineString","coordinates":[[1,2],[3,4]]},"properties":null}`,
+		},
+		{
+			`{"type":"Feature","id":"N1","geometry":{"type":"Point","coordinates":[1,2]},"properties":{}}`,
+			`{"id":"N1","type":"Feature","geometry":{"type":"Point","coordinates":[1,2]},"properties":null}`,
+		},
+		{
+			`{"type":"Feature","id":"R1","geometry":{"type":"MultiPolygon","coordinates":[]},"properties":{}}`,
+			`{"id":"R1","type":"Feature","geometry":{"type":"MultiPolygon","coordinates":[]},"properties":null}`,
+		},
+	} {
+		f, err := geojson.UnmarshalFeature([]byte(tc.feature))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id, ok := f.ID.(float64); ok {
+			f.ID = int64(id) // like loaders that decode integer IDs
+		}
+		var got []string
+		for _, part := range splitMultiGeometry(f) {
+			b, _ := json.Marshal(part)
+			got = append(got, string(b))
+		}
+		if strings.Join(got, "\n") != tc.expected {
+			t.Errorf("splitting %s: expected\n%s\ngot\n%s", tc.feature, tc.expected, strings.Join(got, "\n"))
+		}
+	}
+}
+
+func TestIndex_SetSplitMultiGeometries(t *testing.T) {
+	dir, err := ioutil.TempDir("", "miniwfs-split")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	islands := `{"type":"Feature","id":"R9","geometry":{"type":"MultiPolygon","coordinates":[` +
+		`[[[0,0],[1,0],[1,1],[0,0]]],[[[50,50],[51,50],[51,51],[50,50]]]]},"properties":{"name":"Islands"}}`
+	ioutil.WriteFile(filepath.Join(dir, "islands.geojson"), []byte(`{"type":"FeatureCollection","features":[`+islands+`]}`), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "islands.geojsonl"), []byte(islands+"\n"), 0644)
+
+	// Streaming and non-streaming loaders.
+	index, err := MakeIndex(map[string]string{
+		"a": filepath.Join(dir, "islands.geojson"),
+		"b": filepath.Join(dir, "islands.geojsonl"),
+		"c": filepath.Join(dir, "islands.geojson"),
+	}, nil, IndexOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer index.Close()
+
+	index.SetSplitMultiGeometries([]string{"a", "b"})
+	for _, c := range []string{"a", "b"} {
+		f, _ := index.GetItem(c, "R9#2")
+		if f == nil || !f.Geometry.IsPolygon() || f.Properties["parent"] != "R9" || f.Properties["name"] != "Islands" {
+			t.Errorf("collection %s: expected second part of R9, got %v", c, f)
+		}
+		if f, _ := index.GetItem(c, "R9"); f != nil {
+			t.Errorf("collection %s: expected R9 to be split, got %v", c, f)
+		}
+	}
+	if f, _ := index.GetItem("c", "R9"); f == nil || !f.Geometry.IsMultiPolygon() {
+		t.Errorf("expected R9 to be unsplit in collection c, got %v", f)
+	}
+
+	index.SetSplitMultiGeometries(nil)
+	if f, _ := index.GetItem("a", "R9"); f == nil {
+		t.Error("expected R9 to be back after disabling splitting")
+	}
+}
@@ -0,0 +1,851 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/paulmach/go.geojson"
+)
+
+// SQLite databases with feature tables, as written by GDAL, QGIS and
+// SpatiaLite, so medium-sized collections can come in a single file
+// without running a database server. We read GeoPackages (OGC 12-128r18)
+// and SpatiaLite databases by decoding the documented SQLite file
+// format, rather than linking to the SQLite library.
+// https://www.sqlite.org/fileformat2.html
+//
+// A source like osm.gpkg#buildings selects the feature table; without
+// a table name, the database must have exactly one. The table gets
+// streamed row by row. Like for shapefiles, the coordinates must be
+// WGS84 longitude and latitude. Databases in WAL mode need to be
+// checkpointed, since we only read the main file.
+//
+// Like other sources, the table gets copied into a collection that is
+// indexed by miniwfs and serves all requests; the database is not read
+// per request. Its spatial R*Tree index is therefore not consulted, and
+// the whole table needs to fit into a loaded collection.
+
+func init() {
+	RegisterInputLoader(sqliteLoader{}, []string{".gpkg", ".sqlite", ".sqlite3"}, nil)
+}
+
+var MalformedSQLite error = errors.New("malformed SQLite database")
+
+type sqliteLoader struct{}
+
+func (sqliteLoader) Name() string {
+	return "sqlite"
+}
+
+func (sqliteLoader) ModTime(source string) (time.Time, error) {
+	path, _ := splitSQLiteSource(source)
+	return fileModTime(path)
+}
+
+func (l sqliteLoader) Load(source string) (*SourceData, error) {
+	result := &SourceData{}
+	_, err := l.Stream(source, func(f *geojson.Feature) error {
+		result.Features = append(result.Features, f)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (sqliteLoader) Stream(source string, emit func(*geojson.Feature) error) (map[string]interface{}, error) {
+	path, tableName := splitSQLiteSource(source)
+	if wal, err := os.Stat(path + "-wal"); err == nil && wal.Size() > 0 {
+		return nil, fmt.Errorf("%s has a write-ahead log; please run PRAGMA wal_checkpoint(TRUNCATE)", path)
+	}
+	file, err := openSQLiteFile(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	db, err := openSQLite(file)
+	if err != nil {
+		return nil, err
+	}
+	ft, err := db.featureTable(tableName)
+	if err != nil {
+		return nil, err
+	}
+
+	geomColumn := ft.table.column(ft.geometryColumn)
+	err = db.scanRows(ft.table, func(rowid int64, values []interface{}) error {
+		f := geojson.NewFeature(nil)
+		f.ID = rowid
+		for i, v := range values {
+			if i == geomColumn {
+				if blob, ok := v.([]byte); ok {
+					g, err := ft.decode(blob)
+					if err != nil {
+						return fmt.Errorf("row %d: %v", rowid, err)
+					}
+					f.Geometry = g
+				}
+				continue
+			}
+			switch v.(type) {
+			case nil, []byte:
+				continue
+			}
+			if i != ft.table.rowidColumn {
+				f.Properties[ft.table.columns[i]] = v
+			}
+		}
+		return emit(f)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", ft.table.name, err)
+	}
+	return nil, nil
+}
+
+// splitSQLiteSource splits a source like osm.gpkg#buildings into
+// the path of the database and the name of the table.
+func splitSQLiteSource(source string) (string, string) {
+	if i := strings.LastIndexByte(source, '#'); i >= 0 {
+		return source[:i], source[i+1:]
+	}
+	return source, ""
+}
+
+// openSQLiteFile opens a database for random access. Gzipped databases
+// get decompressed into memory.
+func openSQLiteFile(path string) (sqliteFile, error) {
+	if !strings.EqualFold(filepath.Ext(path), ".gz") {
+		return os.Open(path)
+	}
+	data, err := readSource(path)
+	if err != nil {
+		return nil, err
+	}
+	return sqliteBytes{bytes.NewReader(data)}, nil
+}
+
+type sqliteFile interface {
+	io.ReaderAt
+	io.Closer
+}
+
+type sqliteBytes struct {
+	*bytes.Reader
+}
+
+func (sqliteBytes) Close() error {
+	return nil
+}
+
+// sqliteDB reads the b-trees of an SQLite database file.
+type sqliteDB struct {
+	file       io.ReaderAt
+	pageSize   int
+	usableSize int
+}
+
+// B-tree page types.
+const (
+	sqliteInteriorTable = 0x05
+	sqliteLeafTable     = 0x0d
+)
+
+func openSQLite(file io.ReaderAt) (*sqliteDB, error) {
+	var header [100]byte
+	if _, err := file.ReadAt(header[:], 0); err != nil {
+		return nil, MalformedSQLite
+	}
+	if string(header[:16]) != "SQLite format 3\x00" {
+		return nil, MalformedSQLite
+	}
+	pageSize := int(binary.BigEndian.Uint16(header[16:]))
+	if pageSize == 1 {
+		pageSize = 65536
+	}
+	if pageSize < 512 || pageSize&(pageSize-1) != 0 {
+		return nil, MalformedSQLite
+	}
+	if encoding := binary.BigEndian.Uint32(header[56:]); encoding > 1 {
+		return nil, errors.New("SQLite database is not encoded in UTF-8")
+	}
+	return &sqliteDB{file: file, pageSize: pageSize, usableSize: pageSize - int(header[20])}, nil
+}
+
+func (db *sqliteDB) readPage(n uint32) ([]byte, error) {
+	if n == 0 {
+		return nil, MalformedSQLite
+	}
+	page := make([]byte, db.pageSize)
+	if _, err := db.file.ReadAt(page, int64(n-1)*int64(db.pageSize)); err != nil {
+		if err == io.EOF {
+			return nil, MalformedSQLite
+		}
+		return nil, err
+	}
+	return page, nil
+}
+
+// scanTree calls fn for each row of a table b-tree, in rowid order,
+// passing the rowid and the undecoded record.
+func (db *sqliteDB) scanTree(pageNum uint32, depth int, fn func(int64, []byte) error) error {
+	if depth > 64 {
+		return MalformedSQLite
+	}
+	page, err := db.readPage(pageNum)
+	if err != nil {
+		return err
+	}
+	header := 0
+	if pageNum == 1 {
+		header = 100 // after the file header
+	}
+	numCells := int(binary.BigEndian.Uint16(page[header+3:]))
+	switch page[header] {
+	case sqliteLeafTable:
+		cells := header + 8
+		if cells+2*numCells > len(page) {
+			return MalformedSQLite
+		}
+		for i := 0; i < numCells; i++ {
+			pos := int(binary.BigEndian.Uint16(page[cells+2*i:]))
+			size, n := sqliteVarint(page, pos)
+			if n == 0 || size < 0 || size > 1<<30 {
+				return MalformedSQLite
+			}
+			pos += n
+			rowid, n := sqliteVarint(page, pos)
+			if n == 0 {
+				return MalformedSQLite
+			}
+			record, err := db.payload(page, pos+n, int(size))
+			if err != nil {
+				return err
+			}
+			if err := fn(rowid, record); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case sqliteInteriorTable:
+		cells := header + 12
+		if cells+2*numCells > len(page) {
+			return MalformedSQLite
+		}
+		for i := 0; i < numCells; i++ {
+			pos := int(binary.BigEndian.Uint16(page[cells+2*i:]))
+			if pos+4 > len(page) {
+				return MalformedSQLite
+			}
+			if err := db.scanTree(binary.BigEndian.Uint32(page[pos:]), depth+1, fn); err != nil {
+				return err
+			}
+		}
+		return db.scanTree(binary.BigEndian.Uint32(page[header+8:]), depth+1, fn)
+
+	default:
+		return errors.New("table has no rowid; WITHOUT ROWID tables are not supported")
+	}
+}
+
+// payload returns the record of a table leaf cell, following the
+// chain of overflow pages for records that do not fit on their page.
+func (db *sqliteDB) payload(page []byte, pos int, size int) ([]byte, error) {
+	u := db.usableSize
+	local := size
+	if size > u-35 {
+		m := (u-12)*32/255 - 23
+		local = m + (size-m)%(u-4)
+		if local > u-35 {
+			local = m
+		}
+	}
+	if pos+local > len(page) {
+		return nil, MalformedSQLite
+	}
+	result := make([]byte, 0, size)
+	result = append(result, page[pos:pos+local]...)
+	if local == size {
+		return result, nil
+	}
+
+	if pos+local+4 > len(page) {
+		return nil, MalformedSQLite
+	}
+	next := binary.BigEndian.Uint32(page[pos+local:])
+	for len(result) < size {
+		overflow, err := db.readPage(next)
+		if err != nil {
+			return nil, err
+		}
+		n := size - len(result)
+		if n > u-4 {
+			n = u - 4
+		}
+		result = append(result, overflow[4:4+n]...)
+		next = binary.BigEndian.Uint32(overflow)
+	}
+	return result, nil
+}
+
+// sqliteVarint decodes a variable-length integer at pos, returning
+// its value and length; zero length if the data is truncated.
+func sqliteVarint(data []byte, pos int) (int64, int) {
+	var v uint64
+	for i := 0; i < 9 && pos+i < len(data); i++ {
+		b := data[pos+i]
+		if i == 8 {
+			return int64(v<<8 | uint64(b)), 9
+		}
+		v = v<<7 | uint64(b&0x7f)
+		if b < 0x80 {
+			return int64(v), i + 1
+		}
+	}
+	return 0, 0
+}
+
+// decodeSQLiteRecord decodes the column values of a record, which
+// are nil, int64, float64, string or []byte.
+func decodeSQLiteRecord(record []byte) ([]interface{}, error) {
+	headerSize, n := sqliteVarint(record, 0)
+	if n == 0 || headerSize < int64(n) || headerSize > int64(len(record)) {
+		return nil, MalformedSQLite
+	}
+	var values []interface{}
+	body := int(headerSize)
+	for pos := n; pos < int(headerSize); {
+		serialType, n := sqliteVarint(record, pos)
+		if n == 0 || serialType < 0 {
+			return nil, MalformedSQLite
+		}
+		pos += n
+
+		var size int
+		switch {
+		case serialType <= 4:
+			size = int(serialType)
+		case serialType == 5:
+			size = 6
+		case serialType == 6 || serialType == 7:
+			size = 8
+		case serialType >= 12:
+			size = int((serialType - 12) / 2)
+		}
+		if size > len(record)-body {
+			return nil, MalformedSQLite
+		}
+		data := record[body : body+size]
+		body += size
+
+		switch {
+		case serialType == 0:
+			values = append(values, nil)
+		case serialType <= 6:
+			v := int64(int8(data[0]))
+			for _, b := range data[1:] {
+				v = v<<8 | int64(b)
+			}
+			values = append(values, v)
+		case serialType == 7:
+			values = append(values, math.Float64frombits(binary.BigEndian.Uint64(data)))
+		case serialType == 8:
+			values = append(values, int64(0))
+		case serialType == 9:
+			values = append(values, int64(1))
+		case serialType >= 12 && serialType%2 == 0:
+			values = append(values, data)
+		case serialType >= 13:
+			values = append(values, string(data))
+		default:
+			return nil, MalformedSQLite
+		}
+	}
+	return values, nil
+}
+
+// sqliteTable describes a table from the schema of a database.
+type sqliteTable struct {
+	name        string
+	root        uint32
+	columns     []string
+	rowidColumn int // the INTEGER PRIMARY KEY column, which aliases the rowid; -1 if none
+}
+
+// column returns the index of a column, or -1 if the table has no
+// column by that name. Like in SQL, names are case-insensitive.
+func (t *sqliteTable) column(name string) int {
+	for i, c := range t.columns {
+		if strings.EqualFold(c, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+// table looks up a table in the schema, which is stored in the
+// sqlite_schema table on page 1.
+func (db *sqliteDB) table(name string) (*sqliteTable, error) {
+	var result *sqliteTable
+	err := db.scanTree(1, 0, func(rowid int64, record []byte) error {
+		values, err := decodeSQLiteRecord(record)
+		if err != nil {
+			return err
+		}
+		if len(values) < 5 || values[0] != "table" {
+			return nil
+		}
+		tableName, _ := values[1].(string)
+		if result != nil || !strings.EqualFold(tableName, name) {
+			return nil
+		}
+		root, _ := values[3].(int64)
+		sql, _ := values[4].(string)
+		if root <= 0 {
+			return fmt.Errorf("%s is a virtual table", tableName)
+		}
+		columns, rowidColumn, err := parseCreateTable(sql)
+		if err != nil {
+			return fmt.Errorf("%s: %v", tableName, err)
+		}
+		result = &sqliteTable{name: tableName, root: uint32(root), columns: columns, rowidColumn: rowidColumn}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if result == nil {
+		return nil, fmt.Errorf("no table %q", name)
+	}
+	return result, nil
+}
+
+// scanRows calls fn for each row of a table with its values, one for
+// each column of the table.
+func (db *sqliteDB) scanRows(t *sqliteTable, fn func(rowid int64, values []interface{}) error) error {
+	return db.scanTree(t.root, 0, func(rowid int64, record []byte) error {
+		values, err := decodeSQLiteRecord(record)
+		if err != nil {
+			return err
+		}
+		// Columns added by ALTER TABLE are missing from older rows.
+		for len(values) < len(t.columns) {
+			values = append(values, nil)
+		}
+		if t.rowidColumn >= 0 {
+			values[t.rowidColumn] = rowid
+		}
+		return fn(rowid, values[:len(t.columns)])
+	})
+}
+
+// parseCreateTable extracts the column names from the CREATE TABLE
+// statement that SQLite keeps in its schema.
+func parseCreateTable(sql string) ([]string, int, error) {
+	start, end := strings.IndexByte(sql, '('), strings.LastIndexByte(sql, ')')
+	if start < 0 || end < start {
+		return nil, -1, errors.New("cannot parse CREATE TABLE statement")
+	}
+	if strings.Contains(strings.ToUpper(sql[end:]), "WITHOUT") {
+		return nil, -1, errors.New("WITHOUT ROWID tables are not supported")
+	}
+
+	var columns []string
+	rowidColumn := -1
+	for _, def := range splitSQLDefinitions(sql[start+1 : end]) {
+		name, rest := sqlToken(def)
+		switch strings.ToUpper(name) {
+		case "CONSTRAINT", "PRIMARY", "UNIQUE", "CHECK", "FOREIGN":
+			continue
+		}
+		if len(name) == 0 {
+			return nil, -1, errors.New("cannot parse CREATE TABLE statement")
+		}
+		colType, _ := sqlToken(rest)
+		upper := strings.ToUpper(rest)
+		if strings.EqualFold(colType, "INTEGER") && strings.Contains(upper, "PRIMARY KEY") &&
+			!strings.Contains(upper, "DESC") {
+			rowidColumn = len(columns)
+		}
+		columns = append(columns, unquoteSQLIdentifier(name))
+	}
+	return columns, rowidColumn, nil
+}
+
+// splitSQLDefinitions splits a list of column definitions at commas
+// that are not nested in parentheses or quotes.
+func splitSQLDefinitions(s string) []string {
+	var result []string
+	depth, start := 0, 0
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == '[':
+			quote = ']'
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case c == ',' && depth == 0:
+			result = append(result, strings.TrimSpace(s[start:i]))
+			start = i + 1
+		}
+	}
+	return append(result, strings.TrimSpace(s[start:]))
+}
+
+// sqlToken splits the first word or quoted identifier off a string.
+func sqlToken(s string) (string, string) {
+	s = strings.TrimSpace(s)
+	if len(s) == 0 {
+		return "", ""
+	}
+	closing := map[byte]byte{'"': '"', '`': '`', '[': ']', '\'': '\''}[s[0]]
+	if closing != 0 {
+		if end := strings.IndexByte(s[1:], closing); end >= 0 {
+			return s[:end+2], s[end+2:]
+		}
+		return s, ""
+	}
+	end := strings.IndexAny(s, " \t\r\n(")
+	if end < 0 {
+		return s, ""
+	}
+	return s[:end], s[end:]
+}
+
+func unquoteSQLIdentifier(s string) string {
+	if len(s) >= 2 {
+		switch s[0] {
+		case '"', '`', '[', '\'':
+			return s[1 : len(s)-1]
+		}
+	}
+	return s
+}
+
+// sqliteFeatureTable is a table with a geometry column, as registered
+// in the metadata tables of GeoPackage or SpatiaLite.
+type sqliteFeatureTable struct {
+	table          *sqliteTable
+	geometryColumn string
+	decode         func([]byte) (*geojson.Geometry, error)
+}
+
+// featureTable returns the feature table with the given name, or the
+// only feature table of the database if name is empty.
+func (db *sqliteDB) featureTable(name string) (*sqliteFeatureTable, error) {
+	type candidate struct {
+		table, column string
+		srid          int64
+	}
+	var candidates []candidate
+	var decode func([]byte) (*geojson.Geometry, error)
+	for _, meta := range []struct {
+		table, tableColumn, geometryColumn, sridColumn string
+		decode                                         func([]byte) (*geojson.Geometry, error)
+	}{
+		{"gpkg_geometry_columns", "table_name", "column_name", "srs_id", decodeGeoPackageGeometry},
+		{"geometry_columns", "f_table_name", "f_geometry_column", "srid", decodeSpatiaLiteGeometry},
+	} {
+		t, err := db.table(meta.table)
+		if err != nil {
+			continue
+		}
+		tableColumn, geomColumn, sridColumn := t.column(meta.tableColumn), t.column(meta.geometryColumn), t.column(meta.sridColumn)
+		if tableColumn < 0 || geomColumn < 0 || sridColumn < 0 {
+			return nil, fmt.Errorf("%s: unexpected columns", meta.table)
+		}
+		err = db.scanRows(t, func(rowid int64, values []interface{}) error {
+			c := candidate{}
+			c.table, _ = values[tableColumn].(string)
+			c.column, _ = values[geomColumn].(string)
+			c.srid, _ = values[sridColumn].(int64)
+			if len(name) == 0 || strings.EqualFold(c.table, name) {
+				candidates = append(candidates, c)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		decode = meta.decode
+		break
+	}
+
+	if decode == nil {
+		return nil, errors.New("database has neither GeoPackage nor SpatiaLite metadata")
+	}
+	if len(candidates) == 0 && len(name) > 0 {
+		return nil, fmt.Errorf("no feature table %q", name)
+	}
+	if len(candidates) != 1 {
+		tables := make([]string, len(candidates))
+		for i, c := range candidates {
+			tables[i] = c.table
+		}
+		return nil, fmt.Errorf("database has %d feature tables; select one as in path.gpkg#table: %s",
+			len(candidates), strings.Join(tables, ", "))
+	}
+
+	// SRID 4326 is WGS84; 0 and -1 mean undefined.
+	c := candidates[0]
+	if c.srid != 4326 && c.srid != 0 && c.srid != -1 {
+		return nil, fmt.Errorf("table %s has SRID %d; please convert it to WGS84 longitude and latitude (EPSG:4326)", c.table, c.srid)
+	}
+	t, err := db.table(c.table)
+	if err != nil {
+		return nil, err
+	}
+	if t.column(c.column) < 0 {
+		return nil, fmt.Errorf("table %s has no geometry column %q", c.table, c.column)
+	}
+	return &sqliteFeatureTable{table: t, geometryColumn: c.column, decode: decode}, nil
+}
+
+// decodeGeoPackageGeometry decodes the geometry encoding of GeoPackage,
+// which is Well-Known Binary preceded by a header.
+func decodeGeoPackageGeometry(blob []byte) (*geojson.Geometry, error) {
+	if len(blob) < 8 || blob[0] != 'G' || blob[1] != 'P' {
+		return nil, MalformedSQLite
+	}
+	flags := blob[3]
+	if flags&0x20 != 0 {
+		return nil, errors.New("extended GeoPackage geometries are not supported")
+	}
+	if flags&0x10 != 0 {
+		return nil, nil // empty geometry
+	}
+	envelope := (flags >> 1) & 7
+	if envelope > 4 {
+		return nil, MalformedSQLite
+	}
+	start := 8 + []int{0, 32, 48, 48, 64}[envelope]
+	if start > len(blob) {
+		return nil, MalformedSQLite
+	}
+	r := &wkbReader{data: blob[start:]}
+	g := r.geometry(0)
+	return g, r.err
+}
+
+// decodeSpatiaLiteGeometry decodes the geometry encoding of SpatiaLite,
+// which resembles Well-Known Binary.
+// https://www.gaia-gis.it/gaia-sins/BLOB-Geometry.html
+func decodeSpatiaLiteGeometry(blob []byte) (*geojson.Geometry, error) {
+	if len(blob) < 44 || blob[0] != 0 || blob[38] != 0x7c || blob[len(blob)-1] != 0xfe {
+		return nil, MalformedSQLite
+	}
+	r := &wkbReader{data: blob[39 : len(blob)-1], spatialite: true}
+	switch blob[1] {
+	case 0:
+		r.order = binary.BigEndian
+	case 1:
+		r.order = binary.LittleEndian
+	default:
+		return nil, MalformedSQLite
+	}
+	g := r.body(r.uint32(), 0)
+	return g, r.err
+}
+
+// wkbReader decodes Well-Known Binary geometries, as specified in
+// OGC 06-103r4, with Z and M in either the ISO or the EWKB style.
+// After running out of data, it returns zeros and remembers the error.
+type wkbReader struct {
+	data       []byte
+	pos        int
+	order      binary.ByteOrder
+	spatialite bool // nested geometries start with 0x69 instead of the byte order
+	err        error
+}
+
+func (r *wkbReader) geometry(depth int) *geojson.Geometry {
+	if r.spatialite {
+		if r.byte() != 0x69 {
+			r.fail()
+		}
+	} else {
+		switch r.byte() {
+		case 0:
+			r.order = binary.BigEndian
+		case 1:
+			r.order = binary.LittleEndian
+		default:
+			r.fail()
+		}
+	}
+	if r.err != nil {
+		return nil
+	}
+	return r.body(r.uint32(), depth)
+}
+
+// body decodes a geometry of the given type, after its header.
+func (r *wkbReader) body(geomType uint32, depth int) *geojson.Geometry {
+	// EWKB flags for Z, M and SRID.
+	if geomType&0x20000000 != 0 {
+		r.uint32()
+	}
+	if geomType&0x80000000 != 0 {
+		geomType += 1000
+	}
+	if geomType&0x40000000 != 0 {
+		geomType += 2000
+	}
+	geomType &= 0x0fffffff
+	dims := geomType / 1000
+	hasZ, hasM := dims == 1 || dims == 3, dims == 2 || dims == 3
+	if dims > 3 || depth > 32 || r.err != nil {
+		r.fail()
+		return nil
+	}
+
+	switch geomType % 1000 {
+	case 1:
+		p := r.point(hasZ, hasM)
+		if math.IsNaN(p[0]) {
+			return nil // empty point
+		}
+		return geojson.NewPointGeometry(p)
+
+	case 2:
+		return geojson.NewLineStringGeometry(r.points(hasZ, hasM))
+
+	case 3:
+		return geojson.NewPolygonGeometry(r.rings(hasZ, hasM))
+
+	case 4, 5, 6, 7:
+		parts := make([]*geojson.Geometry, r.count(5))
+		for i := range parts {
+			if parts[i] = r.geometry(depth + 1); r.err != nil {
+				return nil
+			}
+		}
+		var points [][]float64
+		var lines [][][]float64
+		var polygons [][][][]float64
+		for _, p := range parts {
+			switch {
+			case geomType%1000 == 7:
+			case geomType%1000 == 4 && p != nil && p.IsPoint():
+				points = append(points, p.Point)
+			case geomType%1000 == 5 && p != nil && p.IsLineString():
+				lines = append(lines, p.LineString)
+			case geomType%1000 == 6 && p != nil && p.IsPolygon():
+				polygons = append(polygons, p.Polygon)
+			default:
+				r.fail()
+				return nil
+			}
+		}
+		switch geomType % 1000 {
+		case 4:
+			return geojson.NewMultiPointGeometry(points...)
+		case 5:
+			return geojson.NewMultiLineStringGeometry(lines...)
+		case 6:
+			return geojson.NewMultiPolygonGeometry(polygons...)
+		}
+		var geometries []*geojson.Geometry
+		for _, p := range parts {
+			if p != nil {
+				geometries = append(geometries, p)
+			}
+		}
+		return geojson.NewCollectionGeometry(geometries...)
+	}
+
+	r.fail()
+	return nil
+}
+
+func (r *wkbReader) fail() {
+	if r.err == nil {
+		r.err = MalformedSQLite
+	}
+	r.pos = len(r.data)
+}
+
+func (r *wkbReader) byte() byte {
+	if r.pos >= len(r.data) {
+		r.fail()
+		return 0
+	}
+	r.pos++
+	return r.data[r.pos-1]
+}
+
+func (r *wkbReader) uint32() uint32 {
+	if r.pos+4 > len(r.data) {
+		r.fail()
+		return 0
+	}
+	r.pos += 4
+	return r.order.Uint32(r.data[r.pos-4:])
+}
+
+func (r *wkbReader) float64() float64 {
+	if r.pos+8 > len(r.data) {
+		r.fail()
+		return 0
+	}
+	r.pos += 8
+	return math.Float64frombits(r.order.Uint64(r.data[r.pos-8:]))
+}
+
+// count reads the number of following items, each taking at least
+// minSize bytes, so that corrupt counts cannot exhaust memory.
+func (r *wkbReader) count(minSize int) int {
+	n := int(r.uint32())
+	if n < 0 || n > (len(r.data)-r.pos)/minSize {
+		r.fail()
+		return 0
+	}
+	return n
+}
+
+// point reads a point, keeping its height but dropping its measure.
+func (r *wkbReader) point(hasZ, hasM bool) []float64 {
+	p := []float64{r.float64(), r.float64()}
+	if hasZ {
+		p = append(p, r.float64())
+	}
+	if hasM {
+		r.float64()
+	}
+	return p
+}
+
+func (r *wkbReader) points(hasZ, hasM bool) [][]float64 {
+	points := make([][]float64, r.count(16))
+	for i := range points {
+		points[i] = r.point(hasZ, hasM)
+	}
+	return points
+}
+
+func (r *wkbReader) rings(hasZ, hasM bool) [][][]float64 {
+	rings := make([][][]float64, r.count(4))
+	for i := range rings {
+		rings[i] = r.points(hasZ, hasM)
+	}
+	return rings
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSQLiteLoader_GeoPackage(t *testing.T) {
+	source := filepath.Join("testdata", "castles.gpkg")
+	l, err := GetInputLoader(source + "#castles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.Name() != "sqlite" {
+		t.Errorf("expected sqlite loader, got %s", l.Name())
+	}
+
+	// The table spans several b-tree pages, and row 7 overflows its page.
+	for _, src := range []string{source, source + "#castles", source + "#CASTLES"} {
+		data, err := l.Load(src)
+		if err != nil {
+			t.Fatalf("%s: %v", src, err)
+		}
+		if len(data.Features) != 41 {
+			t.Fatalf("%s: expected 41 features, got %d", src, len(data.Features))
+		}
+	}
+
+	data, _ := l.Load(source)
+	f := data.Features[0]
+	got, _ := json.Marshal(f)
+	expected := `{"id":1,"type":"Feature","geometry":{"type":"Point","coordinates":[8.1,47.05,401]},` +
+		`"properties":{"height":1.5,"name":"Castle 1","year":1010}}`
+	if string(got) != expected {
+		t.Errorf("expected %s, got %s", expected, got)
+	}
+	if _, ok := data.Features[2].Properties["year"]; ok {
+		t.Errorf("expected NULL to be omitted, got %v", data.Features[2].Properties)
+	}
+	if notes := data.Features[6].Properties["notes"]; notes != strings.Repeat("Ruins. ", 300) {
+		t.Errorf("unexpected overflowing property %q", notes)
+	}
+	last := data.Features[40]
+	if last.ID != int64(100) || last.Geometry != nil || last.Properties["year"] != int64(-70000) {
+		t.Errorf("unexpected last feature %+v", last)
+	}
+
+	if _, err := l.Load(source + "#missing"); err == nil || !strings.Contains(err.Error(), `no feature table "missing"`) {
+		t.Errorf("expected error for missing table, got %v", err)
+	}
+}
+
+func TestSQLiteLoader_SpatiaLite(t *testing.T) {
+	source := filepath.Join("testdata", "parks.sqlite")
+	var l sqliteLoader
+	if _, err := l.Load(source); err == nil || !strings.Contains(err.Error(), "parks, trails") {
+		t.Errorf("expected error listing feature tables, got %v", err)
+	}
+	if _, err := l.Load(source + "#trails"); err == nil || !strings.Contains(err.Error(), "SRID 2056") {
+		t.Errorf("expected error for projected table, got %v", err)
+	}
+
+	data, err := l.Load(source + "#parks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	for _, f := range data.Features {
+		b, _ := json.Marshal(f)
+		buf.Write(b)
+		buf.WriteByte('\n')
+	}
+	expected := `{"id":1,"type":"Feature","geometry":{"type":"Polygon","coordinates":[[[8,47],[8.1,47],[8.1,47.1],[8,47]]]},"properties":{"name":"Stadtpark"}}
+{"id":2,"type":"Feature","geometry":{"type":"MultiPolygon","coordinates":[[[[9,46,500],[9.1,46,510],[9.1,46.1,520],[9,46,500]]],[[[9,46,500],[9.1,46,510],[9.1,46.1,520],[9,46,500]]]]},"properties":{"area":12.5,"name":"Bergpark"}}
+`
+	if buf.String() != expected {
+		t.Errorf("expected %s, got %s", expected, buf.String())
+	}
+}
+
+func TestSQLiteVarint(t *testing.T) {
+	for _, tc := range []struct {
+		data     []byte
+		expected int64
+		n        int
+	}{
+		{[]byte{0x00}, 0, 1},
+		{[]byte{0x7f}, 127, 1},
+		{[]byte{0x81, 0x00}, 128, 2},
+		{[]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, -1, 9},
+		{[]byte{0x81}, 0, 0},
+	} {
+		if got, n := sqliteVarint(tc.data, 0); got != tc.expected || n != tc.n {
+			t.Errorf("sqliteVarint(%x): expected %d, %d; got %d, %d", tc.data, tc.expected, tc.n, got, n)
+		}
+	}
+}
+
+func TestParseCreateTable(t *testing.T) {
+	columns, rowid, err := parseCreateTable(`CREATE TABLE "a b" (id INTEGER PRIMARY KEY, "x, y" TEXT, [z] NUMERIC(10, 2) DEFAULT ('('), ` +
+		"`w` BLOB, CONSTRAINT u UNIQUE (w), CHECK (z > 0))")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(columns, "|"); got != "id|x, y|z|w" || rowid != 0 {
+		t.Errorf("unexpected columns %q, rowid column %d", got, rowid)
+	}
+	if _, rowid, _ := parseCreateTable("CREATE TABLE t (id BIGINT PRIMARY KEY, v TEXT)"); rowid != -1 {
+		t.Errorf("expected no rowid alias for BIGINT, got %d", rowid)
+	}
+	if _, _, err := parseCreateTable("CREATE TABLE t (k TEXT PRIMARY KEY) WITHOUT ROWID"); err == nil {
+		t.Error("expected error for WITHOUT ROWID table")
+	}
+}
+
+func TestDecodeSQLiteGeometry_Malformed(t *testing.T) {
+	for _, blob := range [][]byte{
+		nil,
+		[]byte("GP"),
+		[]byte("GP\x00\x01\xe6\x10\x00\x00\x01\x01\x00\x00"),
+		[]byte("GP\x00\x01\xe6\x10\x00\x00\x01\x02\x00\x00\x00\xff\xff\xff\x7f"),
+	} {
+		if _, err := decodeGeoPackageGeometry(blob); err == nil {
+			t.Errorf("decodeGeoPackageGeometry(%x): expected error", blob)
+		}
+	}
+}
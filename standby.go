@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var standbyGauge = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "miniwfs_standby",
+	Help: "1 if this server is a standby waiting to take over, 0 otherwise.",
+})
+
+// Standby lets a second server take over from an active one, giving
+// small deployments high availability without an orchestrator. The
+// standby loads the same collection sources as the active server, so
+// its data and caches are warm, but it only answers /healthz. It gets
+// promoted to active by an admin call to /admin/promote, or when the
+// active server has not answered its /healthz for the failover timeout.
+// Promotion is final; to fail back, restart the old server as standby.
+type Standby struct {
+	health   string // /healthz of the active server
+	interval time.Duration
+	timeout  time.Duration
+	client   *http.Client
+
+	promoted int32 // accessed atomically; 1 once active
+
+	mutex         sync.Mutex
+	lastHeartbeat time.Time
+	stop          chan struct{}
+}
+
+func MakeStandby(active *url.URL, interval, timeout time.Duration) *Standby {
+	health := active.ResolveReference(&url.URL{Path: "healthz"})
+	standbyGauge.Set(1)
+	return &Standby{
+		health:   health.String(),
+		interval: interval,
+		timeout:  timeout,
+		client:   &http.Client{Timeout: interval},
+		stop:     make(chan struct{}),
+	}
+}
+
+// Start checks the heartbeat of the active server until Stop is called.
+func (sb *Standby) Start() {
+	sb.mutex.Lock()
+	sb.lastHeartbeat = time.Now()
+	sb.mutex.Unlock()
+
+	ticker := time.NewTicker(sb.interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case now := <-ticker.C:
+				if !sb.IsActive() {
+					sb.check(now)
+				}
+			case <-sb.stop:
+				return
+			}
+		}
+	}()
+}
+
+func (sb *Standby) Stop() {
+	close(sb.stop)
+}
+
+// IsActive returns true if the server should serve requests, which is
+// always the case for servers that have not been started as standby.
+func (sb *Standby) IsActive() bool {
+	return sb == nil || atomic.LoadInt32(&sb.promoted) == 1
+}
+
+// Promote makes the standby the active server.
+func (sb *Standby) Promote(reason string) {
+	if atomic.CompareAndSwapInt32(&sb.promoted, 0, 1) {
+		httpLog.Info("standby promoted to active", "reason", reason)
+		standbyGauge.Set(0)
+	}
+}
+
+// check asks the active server for its health, and takes over if it
+// has not been healthy for too long.
+func (sb *Standby) check(now time.Time) {
+	healthy := false
+	if resp, err := sb.client.Get(sb.health); err == nil {
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+		healthy = resp.StatusCode == http.StatusOK
+	}
+
+	sb.mutex.Lock()
+	if healthy {
+		sb.lastHeartbeat = now
+	}
+	lost := now.Sub(sb.lastHeartbeat) >= sb.timeout
+	sb.mutex.Unlock()
+
+	if lost {
+		sb.Promote("no heartbeat from " + sb.health)
+	}
+}
+
+// role returns "active" or "standby" for reporting in /healthz, or the
+// empty string for servers that are not part of a failover pair.
+func (sb *Standby) role() string {
+	switch {
+	case sb == nil:
+		return ""
+	case sb.IsActive():
+		return "active"
+	default:
+		return "standby"
+	}
+}
+
+func (s *WebServer) handlePromoteRequest(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.authorizeWrite(w, req) {
+		return
+	}
+	if s.standby == nil {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusConflict)
+		io.WriteString(w, "server has not been started as standby\n")
+		return
+	}
+	s.standby.Promote("promoted by admin request")
+
+	encoded, err := json.Marshal(map[string]string{"role": s.standby.role()})
+	if err != nil {
+		httpLog.Error("json.Marshal failed", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(encoded)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(encoded)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestStandby(t *testing.T) {
+	index, s := makeServer(t)
+	defer s.Shutdown()
+	defer index.Close()
+
+	healthy := true
+	active := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/wfs/healthz" || !healthy {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer active.Close()
+	activeURL, _ := url.Parse(active.URL + "/wfs/")
+	s.standby = MakeStandby(activeURL, time.Second, 30*time.Second)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", path, nil)
+		resp := httptest.NewRecorder()
+		http.HandlerFunc(s.HandleRequest).ServeHTTP(resp, req)
+		return resp
+	}
+	if resp := get("/collections/castles/items"); resp.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected standby to reject requests with 503, got %d", resp.Code)
+	}
+	resp := get("/healthz")
+	if resp.Code != http.StatusOK {
+		t.Errorf("expected standby to serve /healthz, got %d", resp.Code)
+	}
+	expectJSON(t, getBody(resp), `{"status": "ok", "role": "standby", "maintenance":
+		{"enabled": false, "readOnly": false, "retryAfter": 0}}`)
+
+	start := time.Now()
+	s.standby.lastHeartbeat = start
+	s.standby.check(start.Add(20 * time.Second))
+	healthy = false
+	s.standby.check(start.Add(45 * time.Second))
+	if s.standby.IsActive() {
+		t.Error("expected standby to wait for failover timeout after last heartbeat")
+	}
+	s.standby.check(start.Add(51 * time.Second))
+	if !s.standby.IsActive() {
+		t.Error("expected standby to take over after losing heartbeat")
+	}
+	if resp := get("/collections/castles/items"); resp.Code != http.StatusOK {
+		t.Errorf("expected promoted standby to serve requests, got %d", resp.Code)
+	}
+}
+
+func TestStandby_Promote(t *testing.T) {
+	index, s := makeServer(t)
+	defer s.Shutdown()
+	defer index.Close()
+	activeURL, _ := url.Parse("http://active.invalid/")
+	s.standby = MakeStandby(activeURL, time.Second, time.Minute)
+	admin := MakeWebServer(index)
+	admin.admin = true
+	admin.standby = s.standby
+	admin.access = MakeAccessControl([]string{adminAPIKey}, nil, nil)
+
+	// Promoting a standby whose active server is still running would
+	// make both active, so it needs an API key.
+	for apiKey, expected := range map[string]int{"": http.StatusUnauthorized, "wrong": http.StatusForbidden} {
+		req, _ := http.NewRequest("POST", "/admin/promote", nil)
+		if len(apiKey) > 0 {
+			req.Header.Set("X-API-Key", apiKey)
+		}
+		resp := httptest.NewRecorder()
+		http.HandlerFunc(admin.HandleRequest).ServeHTTP(resp, req)
+		if resp.Code != expected {
+			t.Errorf("API key %q: expected status %d, got %d", apiKey, expected, resp.Code)
+		}
+	}
+	if s.standby.IsActive() {
+		t.Fatal("expected standby to stay passive without valid API key")
+	}
+
+	req, _ := http.NewRequest("POST", "/admin/promote", nil)
+	req.Header.Set("X-API-Key", adminAPIKey)
+	resp := httptest.NewRecorder()
+	http.HandlerFunc(admin.HandleRequest).ServeHTTP(resp, req)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", resp.Code)
+	}
+	expectJSON(t, getBody(resp), `{"role": "active"}`)
+	if !s.standby.IsActive() {
+		t.Error("expected standby to be promoted")
+	}
+}
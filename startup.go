@@ -0,0 +1,258 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// StartupReport tells how the server has started: whether its
+// configuration made sense, its sources were reachable, its temporary
+// directory was writable, its clock was sane and its ports were free,
+// and how long each collection took to load. It gets logged as one
+// JSON object and served at /admin/startup, so that failed deployments
+// can be diagnosed from a single artifact.
+type StartupReport struct {
+	Started     time.Time           `json:"started"`
+	OK          bool                `json:"ok"` // false if any check has failed
+	Checks      []StartupCheck      `json:"checks"`
+	Collections []StartupCollection `json:"collections"`
+}
+
+// StartupCheck is the outcome of one self-check at startup.
+type StartupCheck struct {
+	Name    string `json:"name"`
+	Status  string `json:"status"` // StartupOK, StartupWarning or StartupFailed
+	Message string `json:"message,omitempty"`
+}
+
+const (
+	StartupOK      = "ok"
+	StartupWarning = "warning"
+	StartupFailed  = "failed"
+)
+
+// StartupCollection tells how a collection got loaded at startup.
+type StartupCollection struct {
+	Name        string  `json:"name"`
+	Source      string  `json:"source"`
+	Features    int     `json:"features"`
+	LoadSeconds float64 `json:"loadSeconds"`
+}
+
+// startupSourceTimeout limits how long we wait for remote sources
+// while checking whether they are reachable.
+const startupSourceTimeout = 10 * time.Second
+
+// maxClockSkew is how far our clock may differ from the Date header of
+// remote sources before we warn. Larger skews break Last-Modified
+// comparisons with caches, and refreshing remote sources.
+const maxClockSkew = time.Minute
+
+// earliestSaneTime is before any clock we trust. Machines without a
+// battery-backed clock start in 1970 until they have synchronized.
+var earliestSaneTime = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func MakeStartupReport(now time.Time) *StartupReport {
+	return &StartupReport{Started: now, OK: true}
+}
+
+// add records the outcome of a check.
+func (r *StartupReport) add(name string, status string, message string) {
+	r.Checks = append(r.Checks, StartupCheck{Name: name, Status: status, Message: message})
+	if status == StartupFailed {
+		r.OK = false
+	}
+}
+
+// CheckConfig checks the configured collections and public path.
+func (r *StartupReport) CheckConfig(collections map[string]string, publicPath *url.URL) {
+	switch {
+	case len(collections) == 0:
+		r.add("config", StartupWarning, "no collections are configured")
+	case len(publicPath.String()) > 0 && (!publicPath.IsAbs() || len(publicPath.Host) == 0):
+		r.add("config", StartupFailed, fmt.Sprintf("--pathPrefix %q is not an absolute URL", publicPath))
+	default:
+		r.add("config", StartupOK, fmt.Sprintf("%d collections", len(collections)))
+	}
+}
+
+// CheckSources checks that the sources of collections are reachable.
+// Remote sources over HTTP also tell whether our clock is in sync.
+func (r *StartupReport) CheckSources(collections map[string]string) {
+	names := make([]string, 0, len(collections))
+	for name := range collections {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	client := &http.Client{Timeout: startupSourceTimeout}
+	var skew time.Duration
+	for _, name := range names {
+		source := collections[name]
+		check := "source:" + name
+		switch scheme := sourceScheme(source); scheme {
+		case "":
+			if err := checkLocalSource(source); err != nil {
+				r.add(check, StartupFailed, err.Error())
+			} else {
+				r.add(check, StartupOK, "")
+			}
+		case "http", "https":
+			resp, err := client.Head(source)
+			if err != nil {
+				r.add(check, StartupFailed, err.Error())
+				continue
+			}
+			resp.Body.Close()
+			if date, err := http.ParseTime(resp.Header.Get("Date")); err == nil {
+				if d := time.Since(date); d > skew || -d > skew {
+					skew = d
+				}
+			}
+			// Some servers do not implement HEAD, but still serve GET.
+			if resp.StatusCode >= 400 && resp.StatusCode != http.StatusMethodNotAllowed {
+				r.add(check, StartupFailed, "HEAD "+resp.Status)
+			} else {
+				r.add(check, StartupOK, "")
+			}
+		default:
+			r.add(check, StartupOK, scheme+" sources get checked by loading")
+		}
+	}
+	r.checkClock(time.Now(), skew)
+}
+
+// checkLocalSource checks that a file, directory or glob pattern
+// refers to something we can read.
+func checkLocalSource(source string) error {
+	// A suffix such as #buildings selects part of a file.
+	if i := strings.LastIndexByte(source, '#'); i >= 0 && !strings.ContainsAny(source[i:], `./\`) {
+		source = source[:i]
+	}
+	if matches, err := filepath.Glob(source); err != nil {
+		return err
+	} else if len(matches) == 0 {
+		return fmt.Errorf("%s: no such file or directory", source)
+	}
+	if info, err := os.Stat(source); err == nil && !info.IsDir() {
+		f, err := os.Open(source)
+		if err != nil {
+			return err
+		}
+		f.Close()
+	}
+	return nil
+}
+
+// checkClock checks that our clock is plausible, given the largest
+// difference to the Date header of remote sources, or zero.
+func (r *StartupReport) checkClock(now time.Time, skew time.Duration) {
+	switch {
+	case now.Before(earliestSaneTime):
+		r.add("clock", StartupFailed, "clock is set to "+now.UTC().Format(time.RFC3339))
+	case skew > maxClockSkew || -skew > maxClockSkew:
+		r.add("clock", StartupWarning, fmt.Sprintf("clock differs by %v from remote sources", skew.Round(time.Second)))
+	default:
+		r.add("clock", StartupOK, "")
+	}
+}
+
+// CheckTempDir checks that we can write temporary files, which hold
+// the data of loaded collections.
+func (r *StartupReport) CheckTempDir() {
+	tempFiles.mutex.Lock()
+	dir := tempFiles.dir
+	tempFiles.mutex.Unlock()
+	if len(dir) == 0 {
+		dir = os.TempDir()
+	}
+
+	f, err := ioutil.TempFile(dir, "miniwfs-startup-*")
+	if err == nil {
+		_, err = f.Write([]byte("miniwfs"))
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		os.Remove(f.Name())
+	}
+	if err != nil {
+		r.add("tempDir", StartupFailed, err.Error())
+	} else {
+		r.add("tempDir", StartupOK, dir)
+	}
+}
+
+// CheckPort checks that a TCP port is free for listening. With more
+// than one listener, sockets get bound with SO_REUSEPORT, so another
+// process may legitimately be listening, and we do not check.
+func (r *StartupReport) CheckPort(name string, port int, listeners int) {
+	if listeners > 1 {
+		r.add(name, StartupOK, "not checked with SO_REUSEPORT")
+		return
+	}
+	addr := ":" + strconv.Itoa(port)
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		r.add(name, StartupFailed, err.Error())
+		return
+	}
+	l.Close()
+	r.add(name, StartupOK, addr)
+}
+
+// AddCollections records how the collections of an index got loaded.
+func (r *StartupReport) AddCollections(index *Index) {
+	index.mutex.RLock()
+	defer index.mutex.RUnlock()
+	r.Collections = make([]StartupCollection, 0, len(index.Collections))
+	for name, coll := range index.Collections {
+		r.Collections = append(r.Collections, StartupCollection{
+			Name:        name,
+			Source:      coll.metadata.Path,
+			Features:    coll.numFeatures(),
+			LoadSeconds: index.loadTimes[name].Seconds(),
+		})
+	}
+	sort.Slice(r.Collections, func(i, j int) bool { return r.Collections[i].Name < r.Collections[j].Name })
+}
+
+// Log writes the report as a single log record.
+func (r *StartupReport) Log() {
+	if logJSON {
+		slog.Info("startup report", "report", r)
+		return
+	}
+	encoded, _ := json.Marshal(r)
+	slog.Info("startup report", "report", string(encoded))
+}
+
+func (s *WebServer) handleStartupRequest(w http.ResponseWriter, req *http.Request) {
+	if s.startup == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	encoded, err := json.Marshal(s.startup)
+	if err != nil {
+		httpLog.Error("json.Marshal failed", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(encoded)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(encoded)
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestStartupReport(t *testing.T) {
+	remote := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/missing.geojson" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer remote.Close()
+
+	r := MakeStartupReport(time.Now())
+	r.CheckSources(map[string]string{
+		"castles": "testdata/castles.geojson",
+		"parks":   "testdata/parks.sqlite#parks",
+		"all":     "testdata/*.geojson",
+		"missing": "testdata/missing.geojson",
+		"remote":  remote.URL + "/lakes.geojson",
+		"gone":    remote.URL + "/missing.geojson",
+		"s3":      "s3://bucket/lakes.geojson",
+	})
+	r.CheckTempDir()
+
+	got := make(map[string]string)
+	for _, c := range r.Checks {
+		got[c.Name] = c.Status
+	}
+	for name, expected := range map[string]string{
+		"source:castles": StartupOK,
+		"source:parks":   StartupOK,
+		"source:all":     StartupOK,
+		"source:missing": StartupFailed,
+		"source:remote":  StartupOK,
+		"source:gone":    StartupFailed,
+		"source:s3":      StartupOK,
+		"clock":          StartupOK,
+		"tempDir":        StartupOK,
+	} {
+		if got[name] != expected {
+			t.Errorf("check %s: expected %q, got %q", name, expected, got[name])
+		}
+	}
+	if r.OK {
+		t.Error("expected report to fail with missing sources")
+	}
+}
+
+func TestStartupReport_Clock(t *testing.T) {
+	for _, tc := range []struct {
+		now      time.Time
+		skew     time.Duration
+		expected string
+	}{
+		{time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC), 0, StartupOK},
+		{time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC), -5 * time.Minute, StartupWarning},
+		{time.Date(1970, 1, 1, 0, 0, 12, 0, time.UTC), 0, StartupFailed},
+	} {
+		r := MakeStartupReport(tc.now)
+		r.checkClock(tc.now, tc.skew)
+		if got := r.Checks[0].Status; got != tc.expected {
+			t.Errorf("clock at %v with skew %v: expected %q, got %q", tc.now, tc.skew, tc.expected, got)
+		}
+	}
+}
+
+func TestStartupReport_Config(t *testing.T) {
+	for _, tc := range []struct {
+		collections map[string]string
+		publicPath  string
+		expected    string
+	}{
+		{map[string]string{"lakes": "lakes.geojson"}, "https://example.org/wfs/", StartupOK},
+		{map[string]string{"lakes": "lakes.geojson"}, "", StartupOK},
+		{map[string]string{"lakes": "lakes.geojson"}, "example.org/wfs/", StartupFailed},
+		{map[string]string{}, "https://example.org/wfs/", StartupWarning},
+	} {
+		u, _ := url.Parse(tc.publicPath)
+		r := MakeStartupReport(time.Now())
+		r.CheckConfig(tc.collections, u)
+		if got := r.Checks[0].Status; got != tc.expected {
+			t.Errorf("config %v with path prefix %q: expected %q, got %q", tc.collections, tc.publicPath, tc.expected, got)
+		}
+	}
+}
+
+func TestStartupReport_Port(t *testing.T) {
+	l, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	port := l.Addr().(*net.TCPAddr).Port
+
+	r := MakeStartupReport(time.Now())
+	r.CheckPort("port", port, 1)
+	r.CheckPort("shared", port, 4)
+	if r.Checks[0].Status != StartupFailed || r.Checks[1].Status != StartupOK || r.OK {
+		t.Errorf("expected busy port to fail unless shared, got %v", r.Checks)
+	}
+}
+
+func TestStartupReport_Served(t *testing.T) {
+	index, s := makeServer(t)
+	defer s.Shutdown()
+	defer index.Close()
+	s.admin = true
+	s.startup = MakeStartupReport(time.Now())
+	s.startup.AddCollections(index)
+
+	req, _ := http.NewRequest("GET", "/admin/startup", nil)
+	resp := httptest.NewRecorder()
+	http.HandlerFunc(s.HandleRequest).ServeHTTP(resp, req)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, resp.Code)
+	}
+	var report StartupReport
+	if err := json.Unmarshal(resp.Body.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Collections) != 2 || report.Collections[0].Name != "castles" ||
+		report.Collections[0].Features != 3 || report.Collections[0].LoadSeconds <= 0 {
+		t.Errorf("expected load times of castles and lakes, got %+v", report.Collections)
+	}
+
+	s.admin = false
+	resp = httptest.NewRecorder()
+	http.HandlerFunc(s.HandleRequest).ServeHTTP(resp, req)
+	if resp.Code == http.StatusOK {
+		t.Error("expected startup report to be served only on the admin listener")
+	}
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image/color"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/fogleman/gg"
+)
+
+// CollectionStyle configures how a collection gets rendered, both in
+// our PNG tiles and in the generated MapLibre style. If Property is
+// set, points get drawn with the marker of their category, such as
+// {"property": "historic", "categories": {"castle": {"symbol": "square"}}}.
+type CollectionStyle struct {
+	Color      string                   `json:"color,omitempty"` // such as "#c342f4"
+	Property   string                   `json:"property,omitempty"`
+	Categories map[string]CategoryStyle `json:"categories,omitempty"`
+}
+
+// CategoryStyle configures the marker for one value of a categorical
+// property. Missing fields fall back to the collection style.
+type CategoryStyle struct {
+	Color  string `json:"color,omitempty"`
+	Symbol string `json:"symbol,omitempty"` // one of Symbols
+}
+
+// DefaultColor is used for collections without a configured color.
+const DefaultColor = "#c342f4"
+
+// Symbols are the marker shapes we know how to draw.
+var Symbols = []string{"circle", "square", "triangle", "diamond"}
+
+// markerRadius is the size of categorized markers in tiles, in pixels.
+const markerRadius = 4
+
+// Marker is the resolved symbol and color for drawing a point.
+type Marker struct {
+	Symbol string
+	Color  color.Color
+}
+
+// SetCollectionStyles configures the rendering styles of collections,
+// keyed by collection name.
+func (index *Index) SetCollectionStyles(styles map[string]CollectionStyle) {
+	index.mutex.Lock()
+	defer index.mutex.Unlock()
+	index.styles = styles
+}
+
+// GetStyle returns the rendering style of a collection, with defaults
+// filled in for anything that has not been configured.
+func (index *Index) GetStyle(collection string) CollectionStyle {
+	index.mutex.RLock()
+	defer index.mutex.RUnlock()
+	return index.getStyle(collection)
+}
+
+// getStyle is like GetStyle, but must be called with the mutex held.
+func (index *Index) getStyle(collection string) CollectionStyle {
+	style := index.styles[collection]
+	if _, ok := parseHexColor(style.Color); !ok {
+		style.Color = DefaultColor
+	}
+	return style
+}
+
+// getMarker returns the marker for features whose categorical property
+// has the given value.
+func (style *CollectionStyle) getMarker(category string) Marker {
+	m := Marker{Symbol: "circle"}
+	m.Color, _ = parseHexColor(style.Color)
+	if cat, ok := style.Categories[category]; ok {
+		if c, ok := parseHexColor(cat.Color); ok {
+			m.Color = c
+		}
+		if isSymbol(cat.Symbol) {
+			m.Symbol = cat.Symbol
+		}
+	}
+	return m
+}
+
+// sortedCategories returns the configured category values in sorted
+// order, so that generated styles and sprites are deterministic.
+func (style *CollectionStyle) sortedCategories() []string {
+	result := make([]string, 0, len(style.Categories))
+	for cat := range style.Categories {
+		result = append(result, cat)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// getCategories returns the value of a property for every feature,
+// reading the features from disk on first use. Must be called with
+// the index mutex held.
+func (c *Collection) getCategories(property string) []string {
+	c.categoriesOnce.Do(func() {
+		c.categories = make([]string, c.numFeatures())
+		for i := range c.categories {
+			f, err := c.readFeature(i)
+			if err != nil {
+				log.Printf("collection %s: cannot read feature %d: %v", c.metadata.Name, i, err)
+				continue
+			}
+			c.categories[i] = categoryString(f.Properties[property])
+			c.addCacheMemory(16 + int64(len(c.categories[i])))
+		}
+	})
+	return c.categories
+}
+
+// categoryString formats a property value the same way as MapLibre's
+// "to-string" expression, so tiles and styles agree on categories.
+func categoryString(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func isSymbol(s string) bool {
+	for _, sym := range Symbols {
+		if s == sym {
+			return true
+		}
+	}
+	return false
+}
+
+// drawSymbol adds the outline of a marker symbol to the current path.
+func drawSymbol(dc *gg.Context, symbol string, x, y, r float64) {
+	switch symbol {
+	case "square":
+		dc.DrawRectangle(x-r, y-r, 2*r, 2*r)
+	case "triangle":
+		dc.DrawRegularPolygon(3, x, y, r*1.2, 0)
+	case "diamond":
+		dc.DrawRegularPolygon(4, x, y, r*1.2, math.Pi/4)
+	default:
+		dc.DrawCircle(x, y, r)
+	}
+}
+
+// parseHexColor parses colors of the form "#rrggbb" or "#rgb".
+func parseHexColor(s string) (color.NRGBA, bool) {
+	if !strings.HasPrefix(s, "#") {
+		return color.NRGBA{}, false
+	}
+	hex := s[1:]
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+	if len(hex) != 6 {
+		return color.NRGBA{}, false
+	}
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return color.NRGBA{}, false
+	}
+	return color.NRGBA{R: uint8(v >> 16), G: uint8(v >> 8), B: uint8(v), A: 255}, true
+}
+
+// makeMapLibreStyle builds a MapLibre GL style document for a
+// collection, with one layer per geometry type. We do not produce
+// vector tiles, so the style loads the items as a GeoJSON source.
+func (s *WebServer) makeMapLibreStyle(prefix string, collection string) map[string]interface{} {
+	type object map[string]interface{}
+	style := s.index.GetStyle(collection)
+	itemsURL := prefix + "collections/" + url.PathEscape(collection) +
+		"/items?limit=" + strconv.Itoa(MaxLimit)
+
+	source := object{"type": "geojson", "data": itemsURL}
+	if attr := s.index.GetAttribution(collection); len(attr.Attribution) > 0 {
+		source["attribution"] = attr.Attribution
+	}
+
+	isType := func(types ...string) []interface{} {
+		expr := []interface{}{"match", []string{"geometry-type"}}
+		for _, t := range types {
+			expr = append(expr, t)
+		}
+		return append(expr, true, false)
+	}
+
+	// For categorized collections, colors and icons depend on the
+	// property value; otherwise, everything gets the collection color.
+	var paintColor interface{} = style.Color
+	points := object{
+		"id": collection + "-points", "type": "circle", "source": collection,
+		"filter": isType("Point", "MultiPoint"),
+		"paint":  object{"circle-color": style.Color, "circle-radius": 4},
+	}
+	result := object{"version": 8, "name": collection, "sources": object{collection: source}}
+	if len(style.Property) > 0 && len(style.Categories) > 0 {
+		category := []interface{}{"to-string", []string{"get", style.Property}}
+		colors := []interface{}{"match", category}
+		icons := []interface{}{"match", category}
+		for _, cat := range style.sortedCategories() {
+			m := style.getMarker(cat)
+			colors = append(colors, cat, hexColor(m.Color))
+			icons = append(icons, cat, spriteIconName(cat))
+		}
+		paintColor = append(colors, style.Color)
+		points = object{
+			"id": collection + "-points", "type": "symbol", "source": collection,
+			"filter": isType("Point", "MultiPoint"),
+			"layout": object{"icon-image": append(icons, spriteIconName("")), "icon-allow-overlap": true},
+		}
+		result["sprite"] = prefix + "tiles/" + url.PathEscape(collection) + "/sprite"
+	}
+
+	result["layers"] = []object{
+		{
+			"id": collection + "-polygons", "type": "fill", "source": collection,
+			"filter": isType("Polygon", "MultiPolygon"),
+			"paint":  object{"fill-color": paintColor, "fill-opacity": 0.3, "fill-outline-color": paintColor},
+		},
+		{
+			"id": collection + "-lines", "type": "line", "source": collection,
+			"filter": isType("LineString", "MultiLineString", "Polygon", "MultiPolygon"),
+			"paint":  object{"line-color": paintColor, "line-width": 2},
+		},
+		points,
+	}
+	return result
+}
+
+// spriteIconSize is the width and height of sprite icons, in pixels
+// at pixel ratio 1.
+const spriteIconSize = 12
+
+type spriteIcon struct {
+	X          int `json:"x"`
+	Y          int `json:"y"`
+	Width      int `json:"width"`
+	Height     int `json:"height"`
+	PixelRatio int `json:"pixelRatio"`
+}
+
+// spriteIconName returns the name of the sprite icon for a category;
+// the empty category is the fallback for unlisted values.
+func spriteIconName(category string) string {
+	if len(category) == 0 {
+		return "default"
+	}
+	return "category-" + category
+}
+
+// renderSprite renders the marker symbols of a collection into a
+// MapLibre sprite sheet, returning the image and its icon index.
+func renderSprite(style CollectionStyle, pixelRatio int) ([]byte, map[string]spriteIcon) {
+	categories := append([]string{""}, style.sortedCategories()...)
+	size := spriteIconSize * pixelRatio
+	dc := gg.NewContext(size*len(categories), size)
+	dc.SetRGBA255(255, 255, 255, 0)
+	dc.Clear()
+
+	icons := make(map[string]spriteIcon, len(categories))
+	for i, cat := range categories {
+		m := style.getMarker(cat)
+		x := i * size
+		dc.SetColor(m.Color)
+		drawSymbol(dc, m.Symbol, float64(x)+float64(size)/2, float64(size)/2, float64(size)/2-float64(pixelRatio))
+		dc.Fill()
+		icons[spriteIconName(cat)] = spriteIcon{X: x, Width: size, Height: size, PixelRatio: pixelRatio}
+	}
+
+	var png bytes.Buffer
+	encodePNG(&png, dc.Image())
+	return png.Bytes(), icons
+}
+
+func hexColor(c color.Color) string {
+	n := color.NRGBAModel.Convert(c).(color.NRGBA)
+	return fmt.Sprintf("#%02x%02x%02x", n.R, n.G, n.B)
+}
+
+func (s *WebServer) handleStyleRequest(w http.ResponseWriter, req *http.Request, collection string) {
+	if !s.authorize(w, req, collection) {
+		return
+	}
+	if !s.index.HasCollection(collection) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	encoded, err := json.Marshal(s.makeMapLibreStyle(s.publicPath(req), collection))
+	if err != nil {
+		log.Printf("json.Marshal failed: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(encoded)
+}
+
+// handleSpriteRequest serves the sprite sheet referenced by the
+// MapLibre style, either as JSON index or as PNG image.
+func (s *WebServer) handleSpriteRequest(w http.ResponseWriter, req *http.Request, collection string, pixelRatio int, ext string) {
+	if !s.authorize(w, req, collection) {
+		return
+	}
+	if !s.index.HasCollection(collection) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	image, icons := renderSprite(s.index.GetStyle(collection), pixelRatio)
+	body, contentType := image, "image/png"
+	if ext == "json" {
+		encoded, err := json.Marshal(icons)
+		if err != nil {
+			log.Printf("json.Marshal failed: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		body, contentType = encoded, "application/json"
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
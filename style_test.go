@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"image/color"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseHexColor(t *testing.T) {
+	for _, tc := range []struct {
+		s    string
+		ok   bool
+		want color.NRGBA
+	}{
+		{"#c342f4", true, color.NRGBA{R: 0xc3, G: 0x42, B: 0xf4, A: 255}},
+		{"#F00", true, color.NRGBA{R: 0xff, A: 255}},
+		{"", false, color.NRGBA{}},
+		{"c342f4", false, color.NRGBA{}},
+		{"#c342f", false, color.NRGBA{}},
+		{"#zzzzzz", false, color.NRGBA{}},
+	} {
+		got, ok := parseHexColor(tc.s)
+		if ok != tc.ok || got != tc.want {
+			t.Errorf("parseHexColor(%q): expected %v, %v; got %v, %v", tc.s, tc.want, tc.ok, got, ok)
+		}
+	}
+}
+
+func TestStyle(t *testing.T) {
+	index, s := makeServer(t)
+	defer s.Shutdown()
+	defer index.Close()
+	index.SetCollectionStyles(map[string]CollectionStyle{"lakes": {Color: "#1f78b4"}})
+
+	var style struct {
+		Version int `json:"version"`
+		Sources map[string]struct {
+			Type string `json:"type"`
+			Data string `json:"data"`
+		} `json:"sources"`
+		Layers []struct {
+			ID     string                 `json:"id"`
+			Type   string                 `json:"type"`
+			Source string                 `json:"source"`
+			Paint  map[string]interface{} `json:"paint"`
+		} `json:"layers"`
+	}
+	query, _ := http.NewRequest("GET", "/tiles/lakes/style.json", nil)
+	resp := httptest.NewRecorder()
+	http.HandlerFunc(s.HandleRequest).ServeHTTP(resp, query)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", resp.Code)
+	}
+	expectCORSHeader(t, resp.Header())
+	if err := json.Unmarshal(resp.Body.Bytes(), &style); err != nil {
+		t.Fatal(err)
+	}
+
+	itemsURL := "https://test.example.org/wfs/collections/lakes/items?limit=10000"
+	if style.Version != 8 || style.Sources["lakes"].Type != "geojson" || style.Sources["lakes"].Data != itemsURL {
+		t.Errorf("unexpected style: %+v", style)
+	}
+	layerTypes := make(map[string]bool)
+	for _, layer := range style.Layers {
+		layerTypes[layer.Type] = true
+		if layer.Source != "lakes" {
+			t.Errorf("layer %s: expected source lakes, got %s", layer.ID, layer.Source)
+		}
+		if c := layer.Paint[layer.Type+"-color"]; c != "#1f78b4" {
+			t.Errorf("layer %s: expected configured color #1f78b4, got %v", layer.ID, c)
+		}
+	}
+	for _, typ := range []string{"fill", "line", "circle"} {
+		if !layerTypes[typ] {
+			t.Errorf("expected a %s layer", typ)
+		}
+	}
+
+	if got := index.GetStyle("castles").Color; got != DefaultColor {
+		t.Errorf("expected default color %s for unstyled collection, got %s", DefaultColor, got)
+	}
+
+	query, _ = http.NewRequest("GET", "/tiles/nosuchcollection/style.json", nil)
+	resp = httptest.NewRecorder()
+	http.HandlerFunc(s.HandleRequest).ServeHTTP(resp, query)
+	if resp.Code != http.StatusNotFound {
+		t.Errorf("expected status 404 for unknown collection, got %d", resp.Code)
+	}
+}
+
+func TestStyle_Categories(t *testing.T) {
+	index, s := makeServer(t)
+	defer s.Shutdown()
+	defer index.Close()
+	index.SetCollectionStyles(map[string]CollectionStyle{"castles": {
+		Property:   "historic",
+		Categories: map[string]CategoryStyle{"castle": {Color: "#ff0000", Symbol: "square"}},
+	}})
+
+	var style struct {
+		Sprite string `json:"sprite"`
+		Layers []struct {
+			Type   string                 `json:"type"`
+			Layout map[string]interface{} `json:"layout"`
+		} `json:"layers"`
+	}
+	query, _ := http.NewRequest("GET", "/tiles/castles/style.json", nil)
+	resp := httptest.NewRecorder()
+	http.HandlerFunc(s.HandleRequest).ServeHTTP(resp, query)
+	if err := json.Unmarshal(resp.Body.Bytes(), &style); err != nil {
+		t.Fatal(err)
+	}
+	if style.Sprite != "https://test.example.org/wfs/tiles/castles/sprite" {
+		t.Errorf("unexpected sprite URL: %s", style.Sprite)
+	}
+	points := style.Layers[len(style.Layers)-1]
+	icons, _ := json.Marshal(points.Layout["icon-image"])
+	expected := `["match",["to-string",["get","historic"]],"castle","category-castle","default"]`
+	if points.Type != "symbol" || string(icons) != expected {
+		t.Errorf("expected symbol layer with icon-image %s, got %s %s", expected, points.Type, icons)
+	}
+
+	var icons2x map[string]spriteIcon
+	query, _ = http.NewRequest("GET", "/tiles/castles/sprite@2x.json", nil)
+	resp = httptest.NewRecorder()
+	http.HandlerFunc(s.HandleRequest).ServeHTTP(resp, query)
+	if err := json.Unmarshal(resp.Body.Bytes(), &icons2x); err != nil {
+		t.Fatal(err)
+	}
+	if icon := icons2x["category-castle"]; icon.X != 2*spriteIconSize || icon.Width != 2*spriteIconSize || icon.PixelRatio != 2 {
+		t.Errorf("unexpected sprite icon: %+v", icon)
+	}
+
+	query, _ = http.NewRequest("GET", "/tiles/castles/sprite.png", nil)
+	resp = httptest.NewRecorder()
+	http.HandlerFunc(s.HandleRequest).ServeHTTP(resp, query)
+	img, err := png.Decode(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := img.Bounds().Dx(); w != 2*spriteIconSize {
+		t.Errorf("expected sprite width %d, got %d", 2*spriteIconSize, w)
+	}
+	if r, g, b, a := img.At(spriteIconSize+spriteIconSize/2, spriteIconSize/2).RGBA(); r != 0xffff || g != 0 || b != 0 || a != 0xffff {
+		t.Errorf("expected red castle icon, got %d %d %d %d", r, g, b, a)
+	}
+
+	// Hochschloß Pähl is tagged historic=castle, so it should be drawn in red.
+	tile, _, err := index.GetTile("castles", TileKey{Zoom: 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !hasColor(t, tile, color.NRGBA{R: 255, A: 255}) {
+		t.Error("expected categorized marker in tile")
+	}
+}
+
+func hasColor(t *testing.T, pngData []byte, c color.NRGBA) bool {
+	img, err := png.Decode(bytes.NewReader(pngData))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if color.NRGBAModel.Convert(img.At(x, y)) == c {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// maxSyncHistory limits how many versions we remember per collection.
+// Clients whose version is older need to download everything again.
+const maxSyncHistory = 100
+
+// UnknownVersion is returned when a client syncs from a version that
+// we do not remember, either because it is too old or invalid.
+var UnknownVersion error = errors.New("unknown FeatureCollection version")
+
+// versionChange records how a collection has changed from one version
+// to the next.
+type versionChange struct {
+	from, to string
+	diff     CollectionDiff
+}
+
+// SyncResult tells a client how to bring its local copy of a collection
+// from version Since to the current Version. Added and changed features
+// are sent with their current content.
+type SyncResult struct {
+	Collection string            `json:"collection"`
+	Since      string            `json:"since,omitempty"`
+	Version    string            `json:"version"`
+	Added      []json.RawMessage `json:"added"`
+	Changed    []json.RawMessage `json:"changed"`
+	Removed    []string          `json:"removed"`
+}
+
+// recordVersionChange remembers a diff between two versions for
+// syncing clients. Must be called with the mutex held.
+func (index *Index) recordVersionChange(collection string, from, to string, diff CollectionDiff) {
+	if index.history == nil {
+		index.history = make(map[string][]versionChange)
+	}
+	history := append(index.history[collection], versionChange{from: from, to: to, diff: diff})
+	if len(history) > maxSyncHistory {
+		history = history[len(history)-maxSyncHistory:]
+	}
+	index.history[collection] = history
+}
+
+// GetChanges returns the changes of a collection since a version. If
+// since is empty, all features are returned as added. Returns
+// UnknownVersion if we do not remember the version.
+func (index *Index) GetChanges(collection string, since string) (*SyncResult, error) {
+	index.mutex.RLock()
+	defer index.mutex.RUnlock()
+
+	coll := index.Collections[collection]
+	if coll == nil {
+		return nil, NotFound
+	}
+
+	result := &SyncResult{
+		Collection: collection,
+		Since:      since,
+		Version:    coll.metadata.Version,
+		Added:      []json.RawMessage{},
+		Changed:    []json.RawMessage{},
+		Removed:    []string{},
+	}
+
+	// Features without ID cannot be tracked across versions, so we
+	// do not sync them.
+	if len(since) == 0 {
+		for i := 0; i < coll.numFeatures(); i++ {
+			if len(coll.featureID(i)) == 0 {
+				continue
+			}
+			raw, err := coll.readRawFeature(i)
+			if err != nil {
+				return nil, err
+			}
+			result.Added = append(result.Added, raw)
+		}
+		return result, nil
+	}
+
+	if since == coll.metadata.Version {
+		return result, nil
+	}
+
+	// Find the first change after the client's version, and combine
+	// all following diffs into the net change for each feature.
+	history := index.history[collection]
+	start := -1
+	for i, c := range history {
+		if c.from == since {
+			start = i
+		}
+	}
+	if start < 0 {
+		return nil, UnknownVersion
+	}
+
+	const added, changed, removed = 1, 2, 3
+	state := make(map[string]int)
+	seen := make(map[string]bool)
+	var order []string
+	set := func(id string, s int) {
+		if !seen[id] {
+			seen[id] = true
+			order = append(order, id)
+		}
+		state[id] = s
+	}
+	for _, c := range history[start:] {
+		for _, id := range c.diff.Added {
+			if state[id] == removed {
+				set(id, changed) // existed at since, got removed, came back
+			} else {
+				set(id, added)
+			}
+		}
+		for _, id := range c.diff.Changed {
+			if state[id] != added {
+				set(id, changed)
+			}
+		}
+		for _, id := range c.diff.Removed {
+			if state[id] == added {
+				delete(state, id) // did not exist at since, so nothing to do
+			} else {
+				set(id, removed)
+			}
+		}
+	}
+
+	for _, id := range order {
+		s, ok := state[id]
+		if !ok {
+			continue
+		}
+		if s == removed {
+			result.Removed = append(result.Removed, id)
+			continue
+		}
+		i, ok := coll.lookupID(id)
+		if !ok {
+			continue
+		}
+		raw, err := coll.readRawFeature(i)
+		if err != nil {
+			return nil, err
+		}
+		if s == added {
+			result.Added = append(result.Added, raw)
+		} else {
+			result.Changed = append(result.Changed, raw)
+		}
+	}
+	return result, nil
+}
+
+func (s *WebServer) handleSyncRequest(w http.ResponseWriter, req *http.Request, collection string) {
+	if !s.authorize(w, req, collection) {
+		return
+	}
+	if len(s.negotiate(w, req, "json")) == 0 {
+		return
+	}
+
+	result, err := s.index.GetChanges(collection, req.URL.Query().Get("since"))
+	if status := getHTTPStatus(err); status != http.StatusOK {
+		w.WriteHeader(status)
+		return
+	}
+
+	encoded, err := json.Marshal(result)
+	if err != nil {
+		log.Printf("json.Marshal failed: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(encoded)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(encoded)
+}
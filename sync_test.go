@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestGetChanges(t *testing.T) {
+	index := loadTestIndex(t)
+	defer index.Close()
+
+	replace := func(content string) string {
+		c := readTestCollection(t, "lakes", content)
+		if err := index.replaceCollection(c); err != nil {
+			t.Fatal(err)
+		}
+		return c.metadata.Version
+	}
+	v1 := replace(`{"features":[
+		{"id":"A","properties":{"name":"a"},"type":"Feature"},
+		{"id":"B","properties":{"name":"b"},"type":"Feature"},
+		{"id":"C","properties":{"name":"c"},"type":"Feature"}]}`)
+	v2 := replace(`{"features":[
+		{"id":"A","properties":{"name":"a"},"type":"Feature"},
+		{"id":"C","properties":{"name":"c2"},"type":"Feature"},
+		{"id":"D","properties":{"name":"d"},"type":"Feature"},
+		{"id":"E","properties":{"name":"e"},"type":"Feature"}]}`)
+	v3 := replace(`{"features":[
+		{"id":"A","properties":{"name":"a2"},"type":"Feature"},
+		{"id":"C","properties":{"name":"c2"},"type":"Feature"},
+		{"id":"D","properties":{"name":"d2"},"type":"Feature"},
+		{"id":"B","properties":{"name":"b"},"type":"Feature"}]}`)
+
+	ids := func(features []json.RawMessage) []string {
+		result := []string{}
+		for _, f := range features {
+			var feature struct {
+				ID string `json:"id"`
+			}
+			json.Unmarshal(f, &feature)
+			result = append(result, feature.ID)
+		}
+		return result
+	}
+
+	for _, tc := range []struct {
+		since                   string
+		added, changed, removed []string
+	}{
+		{"", []string{"A", "C", "D", "B"}, []string{}, []string{}},
+		{v1, []string{"D"}, []string{"C", "B", "A"}, []string{}},
+		{v2, []string{"B"}, []string{"A", "D"}, []string{"E"}},
+		{v3, []string{}, []string{}, []string{}},
+	} {
+		got, err := index.GetChanges("lakes", tc.since)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Version != v3 || !reflect.DeepEqual(ids(got.Added), tc.added) ||
+			!reflect.DeepEqual(ids(got.Changed), tc.changed) || !reflect.DeepEqual(got.Removed, tc.removed) {
+			t.Errorf("since %q: expected added %v, changed %v, removed %v; got %v, %v, %v",
+				tc.since, tc.added, tc.changed, tc.removed, ids(got.Added), ids(got.Changed), got.Removed)
+		}
+	}
+
+	if _, err := index.GetChanges("lakes", "unknown"); err != UnknownVersion {
+		t.Errorf("expected UnknownVersion, got %v", err)
+	}
+}
+
+func TestSync(t *testing.T) {
+	index, s := makeServer(t)
+	defer s.Shutdown()
+	defer index.Close()
+
+	query, _ := http.NewRequest("GET", "/collections/lakes/sync", nil)
+	resp := httptest.NewRecorder()
+	http.HandlerFunc(s.HandleRequest).ServeHTTP(resp, query)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", resp.Code)
+	}
+	expectCORSHeader(t, resp.Header())
+	var result SyncResult
+	if err := json.Unmarshal(resp.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Added) != 1 || len(result.Version) == 0 {
+		t.Errorf("expected full sync with one feature, got %s", getBody(resp))
+	}
+
+	query, _ = http.NewRequest("GET", "/collections/lakes/sync?since=unknown", nil)
+	resp = httptest.NewRecorder()
+	http.HandlerFunc(s.HandleRequest).ServeHTTP(resp, query)
+	if resp.Code != http.StatusGone {
+		t.Errorf("expected status 410 for unknown version, got %d", resp.Code)
+	}
+}
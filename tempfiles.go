@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Temporary files, which hold the features and spilled indexes of
+// collections. They get deleted when their collection is replaced or
+// closed, but a crashing process leaves them behind. To clean up after
+// crashes, each process records the files it owns in a manifest named
+// miniwfs-<pid>.manifest. At startup, we delete the files listed by
+// manifests of processes that are no longer running.
+
+var tempFiles = &tempFileRegistry{files: make(map[string]bool)}
+
+type tempFileRegistry struct {
+	mutex sync.Mutex
+	dir   string          // empty for the system default, see os.TempDir
+	files map[string]bool // paths of the files we own
+}
+
+// SetTempDirectory configures where temporary files get created, and
+// deletes the files that crashed processes have left behind there.
+// Should be called at startup, before loading collections.
+func SetTempDirectory(dir string) error {
+	if len(dir) > 0 {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return err
+		}
+	}
+	tempFiles.mutex.Lock()
+	tempFiles.dir = dir
+	tempFiles.mutex.Unlock()
+	removeOrphanedTempFiles(dir)
+	return nil
+}
+
+// createTempFile creates a temporary file like ioutil.TempFile, and
+// records it in the manifest of our process.
+func createTempFile(pattern string) (*os.File, error) {
+	r := tempFiles
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	f, err := ioutil.TempFile(r.dir, pattern)
+	if err != nil {
+		return nil, err
+	}
+	r.files[f.Name()] = true
+	if err := r.writeManifest(); err != nil {
+		delete(r.files, f.Name())
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	return f, nil
+}
+
+// removeTempFile closes and deletes a file made by createTempFile.
+func removeTempFile(f *os.File) {
+	r := tempFiles
+	f.Close()
+	os.Remove(f.Name())
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.files, f.Name())
+	if err := r.writeManifest(); err != nil {
+		loaderLog.Warn("updating temp file manifest failed", "error", err)
+	}
+}
+
+// manifestPath returns the path of the manifest of a process.
+func manifestPath(dir string, pid int) string {
+	if len(dir) == 0 {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "miniwfs-"+strconv.Itoa(pid)+".manifest")
+}
+
+// manifestPID returns the process ID from the path of a manifest.
+func manifestPID(path string) (int, error) {
+	base := filepath.Base(path)
+	return strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(base, "miniwfs-"), ".manifest"))
+}
+
+// writeManifest atomically replaces our manifest by the current list
+// of files, so it is never seen half-written. Must be called with the
+// mutex held.
+func (r *tempFileRegistry) writeManifest() error {
+	path := manifestPath(r.dir, os.Getpid())
+	if len(r.files) == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	files := make([]string, 0, len(r.files))
+	for f := range r.files {
+		files = append(files, f)
+	}
+	sort.Strings(files)
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, []byte(strings.Join(files, "\n")+"\n"), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// removeOrphanedTempFiles deletes the files listed in the manifests of
+// processes that are no longer running, along with their manifests.
+func removeOrphanedTempFiles(dir string) {
+	if len(dir) == 0 {
+		dir = os.TempDir()
+	}
+	manifests, _ := filepath.Glob(filepath.Join(dir, "miniwfs-*.manifest"))
+	for _, manifest := range manifests {
+		pid, err := manifestPID(manifest)
+		if err != nil || pid == os.Getpid() || processExists(pid) {
+			continue
+		}
+
+		file, err := os.Open(manifest)
+		if err != nil {
+			continue
+		}
+		removed := 0
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			// Only delete our own kind of files, in case the manifest
+			// got corrupted.
+			path := scanner.Text()
+			if !strings.HasPrefix(filepath.Base(path), "miniwfs-") {
+				continue
+			}
+			if err := os.Remove(path); err == nil {
+				removed++
+			}
+		}
+		file.Close()
+		os.Remove(manifest)
+		loaderLog.Info("removed temp files of crashed process", "pid", pid, "files", removed)
+	}
+}
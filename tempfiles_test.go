@@ -0,0 +1,71 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTempFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "miniwfs-tempfiles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Files of a crashed process get removed at startup, but only if
+	// they look like ours.
+	orphan := filepath.Join(dir, "miniwfs-123.geojson")
+	other := filepath.Join(dir, "precious.txt")
+	for _, path := range []string{orphan, other} {
+		if err := ioutil.WriteFile(path, []byte("data"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	deadManifest := manifestPath(dir, 999999999)
+	ioutil.WriteFile(deadManifest, []byte(orphan+"\n"+other+"\n"), 0600)
+	ourManifest := manifestPath(dir, os.Getpid())
+
+	if err := SetTempDirectory(filepath.Join(dir, "new")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "new")); err != nil {
+		t.Errorf("expected temp directory to be created, got %v", err)
+	}
+	if err := SetTempDirectory(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		tempFiles.mutex.Lock()
+		tempFiles.dir = ""
+		tempFiles.mutex.Unlock()
+	}()
+	for path, expected := range map[string]bool{orphan: false, other: true, deadManifest: false} {
+		if _, err := os.Stat(path); (err == nil) != expected {
+			t.Errorf("%s: expected exists=%v, got error %v", path, expected, err)
+		}
+	}
+
+	f, err := createTempFile("miniwfs-*.geojson")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Dir(f.Name()) != dir {
+		t.Errorf("expected temp file in %s, got %s", dir, f.Name())
+	}
+	manifest, _ := ioutil.ReadFile(ourManifest)
+	if !strings.Contains(string(manifest), f.Name()+"\n") {
+		t.Errorf("expected manifest to list %s, got %q", f.Name(), manifest)
+	}
+
+	removeTempFile(f)
+	if _, err := os.Stat(f.Name()); !os.IsNotExist(err) {
+		t.Errorf("expected temp file to be removed, got %v", err)
+	}
+	manifest, _ = ioutil.ReadFile(ourManifest)
+	if strings.Contains(string(manifest), f.Name()) {
+		t.Errorf("expected manifest to not list removed %s, got %q", f.Name(), manifest)
+	}
+}
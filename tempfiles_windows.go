@@ -0,0 +1,14 @@
+package main
+
+import "os"
+
+// processExists returns true if a process with the given ID is running.
+// On Windows, FindProcess fails for processes that do not exist.
+func processExists(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	p.Release()
+	return true
+}
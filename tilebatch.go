@@ -0,0 +1,125 @@
+package main
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Tile batches, for native apps that prefetch the tiles of an area for
+// offline use. Instead of sending thousands of requests, clients POST
+// a JSON list of tile keys such as ["12/2145/1434", "12/2146/1434"] to
+// /tiles/{collection}/batch and get back a tar archive whose entries
+// are named {zoom}/{x}/{y}.png, like the paths of single tiles.
+
+var tileBatchRegexp = regexp.MustCompile(`^/tiles/([^/]+)/batch$`)
+
+// MaxTileBatch is the maximal number of tiles in a batch request.
+// Clients prefetching larger areas need to send several batches.
+const MaxTileBatch = 1000
+
+// maxTileBatchBody limits the size of batch requests, in bytes.
+const maxTileBatchBody = 64 * MaxTileBatch
+
+func (s *WebServer) handleTileBatchRequest(w http.ResponseWriter, req *http.Request, collection string) {
+	if req.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.authorize(w, req, collection) {
+		return
+	}
+
+	var keys []string
+	body := http.MaxBytesReader(w, req.Body, maxTileBatchBody)
+	if err := json.NewDecoder(body).Decode(&keys); err != nil {
+		replyTileBatchError(w, "malformed request; expected a JSON list such as [\"12/2145/1434\"]")
+		return
+	}
+	tiles, err := parseTileBatch(keys)
+	if err != nil {
+		replyTileBatchError(w, err.Error())
+		return
+	}
+
+	// We render the first tile before sending the response header,
+	// so that unknown collections get reported properly.
+	tile, metadata, err := s.index.GetTile(collection, tiles[0])
+	if status := getHTTPStatus(err); status != http.StatusOK {
+		w.WriteHeader(status)
+		return
+	}
+
+	header := w.Header()
+	header.Set("Access-Control-Allow-Origin", "*")
+	header.Set("Content-Type", "application/x-tar")
+	header.Set("Last-Modified", metadata.LastModified.UTC().Format(http.TimeFormat))
+	s.setLicenseLink(header, collection)
+	w.WriteHeader(http.StatusOK)
+
+	archive := tar.NewWriter(w)
+	for i, key := range tiles {
+		if i > 0 {
+			if tile, _, err = s.index.GetTile(collection, key); err != nil {
+				// The client will notice the truncated archive.
+				httpLog.Error("rendering batched tile failed", "collection", collection, "error", err)
+				return
+			}
+		}
+		err := archive.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     fmt.Sprintf("%d/%d/%d.png", key.Zoom, key.X, key.Y),
+			Mode:     0644,
+			Size:     int64(len(tile)),
+			ModTime:  metadata.LastModified,
+		})
+		if err == nil {
+			_, err = archive.Write(tile)
+		}
+		if err != nil {
+			return // client went away
+		}
+		s.usage.Record(time.Now(), collection, "png", tileSizeBucket(int(key.Zoom)))
+	}
+	archive.Close()
+}
+
+// parseTileBatch parses the tile keys of a batch request, dropping
+// duplicates.
+func parseTileBatch(keys []string) ([]TileKey, error) {
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no tiles requested")
+	}
+	if len(keys) > MaxTileBatch {
+		return nil, fmt.Errorf("too many tiles; at most %d are allowed per batch", MaxTileBatch)
+	}
+	seen := make(map[TileKey]bool, len(keys))
+	tiles := make([]TileKey, 0, len(keys))
+	for _, k := range keys {
+		p := strings.Split(k, "/")
+		if len(p) != 3 {
+			return nil, fmt.Errorf("malformed tile key %q; expected zoom/x/y", k)
+		}
+		key, ok := ParseTileKey(p[0], p[1], p[2])
+		if !ok {
+			return nil, fmt.Errorf("malformed tile key %q; expected zoom/x/y", k)
+		}
+		if !seen[key] {
+			seen[key] = true
+			tiles = append(tiles, key)
+		}
+	}
+	return tiles, nil
+}
+
+func replyTileBatchError(w http.ResponseWriter, msg string) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusBadRequest)
+	io.WriteString(w, msg+"\n")
+}
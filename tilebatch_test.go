@@ -0,0 +1,82 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTileBatch(t *testing.T) {
+	index, s := makeServer(t)
+	defer s.Shutdown()
+	defer index.Close()
+
+	body := `["8/136/91", "0/0/0", "8/136/91"]`
+	req, _ := http.NewRequest("POST", "/tiles/castles/batch", strings.NewReader(body))
+	resp := httptest.NewRecorder()
+	http.HandlerFunc(s.HandleRequest).ServeHTTP(resp, req)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, resp.Code)
+	}
+	if ct := resp.Header().Get("Content-Type"); ct != "application/x-tar" {
+		t.Errorf("expected Content-Type application/x-tar, got %s", ct)
+	}
+
+	var names []string
+	archive := tar.NewReader(resp.Body)
+	for {
+		h, err := archive.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, h.Name)
+		tile, _ := ioutil.ReadAll(archive)
+		single := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/tiles/castles/"+h.Name, nil)
+		http.HandlerFunc(s.HandleRequest).ServeHTTP(single, req)
+		if !bytes.Equal(tile, single.Body.Bytes()) {
+			t.Errorf("batched tile %s differs from single tile", h.Name)
+		}
+	}
+	if got := strings.Join(names, ","); got != "8/136/91.png,0/0/0.png" {
+		t.Errorf("expected tiles 8/136/91.png,0/0/0.png, got %s", got)
+	}
+}
+
+func TestTileBatch_Errors(t *testing.T) {
+	index, s := makeServer(t)
+	defer s.Shutdown()
+	defer index.Close()
+
+	tooMany := make([]string, MaxTileBatch+1)
+	for i := range tooMany {
+		tooMany[i] = fmt.Sprintf(`"12/%d/0"`, i)
+	}
+	for _, tc := range []struct {
+		method, path, body string
+		expected           int
+	}{
+		{"GET", "/tiles/castles/batch", "", http.StatusMethodNotAllowed},
+		{"POST", "/tiles/castles/batch", `{"tiles": []}`, http.StatusBadRequest},
+		{"POST", "/tiles/castles/batch", `[]`, http.StatusBadRequest},
+		{"POST", "/tiles/castles/batch", `["1/2/0"]`, http.StatusBadRequest},
+		{"POST", "/tiles/castles/batch", `["1/0"]`, http.StatusBadRequest},
+		{"POST", "/tiles/castles/batch", "[" + strings.Join(tooMany, ",") + "]", http.StatusBadRequest},
+		{"POST", "/tiles/nosuchcollection/batch", `["0/0/0"]`, http.StatusNotFound},
+	} {
+		req, _ := http.NewRequest(tc.method, tc.path, strings.NewReader(tc.body))
+		resp := httptest.NewRecorder()
+		http.HandlerFunc(s.HandleRequest).ServeHTTP(resp, req)
+		if resp.Code != tc.expected {
+			t.Errorf("%s %s %.40s: expected status %d, got %d", tc.method, tc.path, tc.body, tc.expected, resp.Code)
+		}
+	}
+}
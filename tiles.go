@@ -3,11 +3,18 @@ package main
 import (
 	"bytes"
 	"container/list"
+	"fmt"
+	"image/color"
+	"math"
+	"strconv"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/fogleman/gg"
+	"github.com/golang/geo/r1"
 	"github.com/golang/geo/r2"
+	"github.com/golang/geo/s1"
 	"github.com/golang/geo/s2"
 )
 
@@ -26,26 +33,66 @@ var emptyPNG []byte = []byte{
 }
 
 type Tile struct {
-	dc *gg.Context
+	Color color.Color
+	dc    *gg.Context
 }
 
 func (t *Tile) DrawPoint(p r2.Point) {
-	dc := t.dc
-	if dc == nil {
-		t.dc = gg.NewContext(256, 256)
-		dc = t.dc
-		dc.SetRGBA255(255, 255, 255, 0)
-		dc.Clear()
+	dc := t.context()
+	if t.Color != nil {
+		dc.SetColor(t.Color)
+	} else {
 		dc.SetRGB255(195, 66, 244)
 	}
 	dc.DrawCircle(p.X, p.Y, 2)
 	dc.Fill()
 }
 
+// DrawMarker draws a categorized point. Markers are larger than plain
+// points, so that their symbol shape remains recognizable.
+func (t *Tile) DrawMarker(p r2.Point, m Marker) {
+	dc := t.context()
+	dc.SetColor(m.Color)
+	drawSymbol(dc, m.Symbol, p.X, p.Y, markerRadius)
+	dc.Fill()
+}
+
+// DrawDebugOverlay draws the tile border and a label with the tile key,
+// the number of features and the time it took to render them.
+func (t *Tile) DrawDebugOverlay(key TileKey, numFeatures int, renderTime time.Duration) {
+	dc := t.context()
+	dc.SetRGB255(255, 0, 0)
+	dc.SetLineWidth(1)
+	dc.DrawRectangle(0.5, 0.5, TileSize-1, TileSize-1)
+	dc.Stroke()
+
+	lines := []string{
+		fmt.Sprintf("%d/%d/%d", key.Zoom, key.X, key.Y),
+		fmt.Sprintf("%d features", numFeatures),
+		fmt.Sprintf("rendered in %v", renderTime.Round(time.Microsecond)),
+	}
+	dc.SetRGBA255(255, 255, 255, 192)
+	dc.DrawRectangle(4, 4, 160, float64(len(lines))*14+6)
+	dc.Fill()
+	dc.SetRGB255(0, 0, 0)
+	for i, line := range lines {
+		dc.DrawString(line, 8, float64(i+1)*14+4)
+	}
+}
+
+func (t *Tile) context() *gg.Context {
+	if t.dc == nil {
+		t.dc = gg.NewContext(TileSize, TileSize)
+		t.dc.SetRGBA255(255, 255, 255, 0)
+		t.dc.Clear()
+	}
+	return t.dc
+}
+
 func (t *Tile) ToPNG() []byte {
 	if dc := t.dc; dc != nil {
 		var png bytes.Buffer
-		dc.EncodePNG(&png)
+		encodePNG(&png, dc.Image())
 		return png.Bytes()
 	} else {
 		return emptyPNG
@@ -58,17 +105,85 @@ type TileKey struct {
 	Zoom uint8
 }
 
+// MaxTileZoom is the highest zoom level for which we serve tiles.
+const MaxTileZoom = 30
+
+// ParseTileKey parses tile coordinates from the components of a URL
+// path. Returns false if they are malformed or out of range.
+func ParseTileKey(zoom string, x string, y string) (TileKey, bool) {
+	z, errZ := strconv.ParseUint(zoom, 10, 8)
+	tx, errX := strconv.ParseUint(x, 10, 32)
+	ty, errY := strconv.ParseUint(y, 10, 32)
+	if errZ != nil || errX != nil || errY != nil {
+		return TileKey{}, false
+	}
+	key := TileKey{X: uint32(tx), Y: uint32(ty), Zoom: uint8(z)}
+	return key, key.IsValid()
+}
+
+// IsValid returns true if the tile exists, meaning its zoom level is
+// supported and both x and y are less than 2^zoom.
+func (t *TileKey) IsValid() bool {
+	if t.Zoom > MaxTileZoom {
+		return false
+	}
+	n := uint64(1) << t.Zoom
+	return uint64(t.X) < n && uint64(t.Y) < n
+}
+
+// Bounds returns the area covered by a tile. We construct the rect
+// from its latitude and longitude intervals, because building it from
+// two corner points would let s2 choose the shorter way around the
+// globe, which is wrong for tiles that span 180 degrees or more.
 func (t *TileKey) Bounds() s2.Rect {
-	r := s2.RectFromLatLng(unprojectWebMercator(int(t.Zoom), float64(t.X), float64(t.Y)))
-	return r.AddPoint(unprojectWebMercator(int(t.Zoom), float64(t.X+1), float64(t.Y+1)))
+	tileBoundsCache.Lock()
+	defer tileBoundsCache.Unlock()
+	if r, ok := tileBoundsCache.bounds[*t]; ok {
+		return r
+	}
+
+	scale := math.Exp2(float64(t.Zoom))
+	nw := unprojectWebMercator(r2.Point{X: float64(t.X) / scale, Y: float64(t.Y) / scale})
+	se := unprojectWebMercator(r2.Point{X: float64(t.X+1) / scale, Y: float64(t.Y+1) / scale})
+	r := s2.Rect{
+		Lat: r1.Interval{Lo: se.Lat.Radians(), Hi: nw.Lat.Radians()},
+		Lng: s1.IntervalFromEndpoints(nw.Lng.Radians(), se.Lng.Radians()),
+	}
+
+	if len(tileBoundsCache.bounds) >= maxTileBoundsCacheSize {
+		tileBoundsCache.bounds = make(map[TileKey]s2.Rect)
+	}
+	tileBoundsCache.bounds[*t] = r
+	return r
+}
+
+// PixelLatLng returns the location of a pixel within a tile, where
+// (0, 0) is the top left corner.
+func (t *TileKey) PixelLatLng(x float64, y float64) s2.LatLng {
+	scale := math.Exp2(float64(t.Zoom))
+	return unprojectWebMercator(r2.Point{
+		X: (float64(t.X) + x/TileSize) / scale,
+		Y: (float64(t.Y) + y/TileSize) / scale,
+	})
 }
 
+// tileBoundsCache keeps recently computed tile bounds. When it gets
+// full, we simply start over; tile requests cluster around the areas
+// that clients are currently looking at.
+var tileBoundsCache = struct {
+	sync.Mutex
+	bounds map[TileKey]s2.Rect
+}{bounds: make(map[TileKey]s2.Rect)}
+
+const maxTileBoundsCacheSize = 10000
+
 type TileCache struct {
 	locks   [128]sync.Mutex
 	lists   [128]list.List
 	content [128]map[TileKey]*list.Element
 	size    int32
 	maxSize int32
+	bytes   int64 // total size of cached tiles, accessed atomically
 }
 
 type tileCacheEntry struct {
@@ -109,17 +224,42 @@ func (tc *TileCache) Put(key TileKey, value []byte) {
 
 	if e, hit := tc.content[shard][key]; hit {
 		list.MoveToFront(e)
-		e.Value.(*tileCacheEntry).value = value
+		entry := e.Value.(*tileCacheEntry)
+		atomic.AddInt64(&tc.bytes, int64(len(value)-len(entry.value)))
+		entry.value = value
 		return
 	}
 
 	e := list.PushFront(&tileCacheEntry{key, value})
 	tc.content[shard][key] = e
+	atomic.AddInt64(&tc.bytes, int64(len(value)))
 	if size := atomic.AddInt32(&tc.size, 1); size > tc.maxSize {
 		if oldest := list.Back(); oldest != e {
 			list.Remove(oldest)
-			delete(tc.content[shard], oldest.Value.(*tileCacheEntry).key)
+			entry := oldest.Value.(*tileCacheEntry)
+			delete(tc.content[shard], entry.key)
 			atomic.AddInt32(&tc.size, -1)
+			atomic.AddInt64(&tc.bytes, -int64(len(entry.value)))
 		}
 	}
 }
+
+// Clear removes all cached tiles.
+func (tc *TileCache) Clear() {
+	for shard := range tc.content {
+		tc.locks[shard].Lock()
+		for _, e := range tc.content[shard] {
+			entry := e.Value.(*tileCacheEntry)
+			atomic.AddInt32(&tc.size, -1)
+			atomic.AddInt64(&tc.bytes, -int64(len(entry.value)))
+		}
+		tc.content[shard] = make(map[TileKey]*list.Element)
+		tc.lists[shard].Init()
+		tc.locks[shard].Unlock()
+	}
+}
+
+// Bytes returns the total size of all cached tiles.
+func (tc *TileCache) Bytes() int64 {
+	return atomic.LoadInt64(&tc.bytes)
+}
@@ -72,7 +72,7 @@ func TestTile_Empty(t *testing.T) {
 
 func TestTile_DrawPoint(t *testing.T) {
 	var tile Tile
-	tile.DrawPoint(r2.Point{7.02, 22.95})
+	tile.DrawPoint(r2.Point{X: 7.02, Y: 22.95})
 	img, err := png.Decode(bytes.NewReader(tile.ToPNG()))
 	if err != nil {
 		t.Fatal(err)
@@ -117,4 +117,108 @@ func TestTileCache(t *testing.T) {
 	if cache.size != 2 {
 		t.Errorf("expected size 2, got %d", cache.size)
 	}
+	if b := cache.Bytes(); b != int64(len(bar)+len(foo)) {
+		t.Errorf("expected %d bytes, got %d", len(bar)+len(foo), b)
+	}
+
+	cache.Clear()
+	if cache.size != 0 || cache.Bytes() != 0 {
+		t.Errorf("expected empty cache, got size %d with %d bytes", cache.size, cache.Bytes())
+	}
+	if v := cache.Get(key); v != nil {
+		t.Errorf("expected nil after Clear, got %s", string(v))
+	}
+	cache.Put(key, foo)
+	if v := cache.Get(key); !reflect.DeepEqual(v, foo) {
+		t.Errorf("expected foo after Clear, got %s", string(v))
+	}
+}
+
+func TestTileKeyBounds(t *testing.T) {
+	for _, e := range []struct {
+		key      TileKey
+		expected []float64
+	}{
+		{TileKey{Zoom: 0, X: 0, Y: 0}, []float64{-180, -85.0511287798, 180, 85.0511287798}},
+		{TileKey{Zoom: 1, X: 0, Y: 0}, []float64{-180, 0, 0, 85.0511287798}},
+		{TileKey{Zoom: 1, X: 1, Y: 1}, []float64{0, -85.0511287798, 180, 0}},
+		{TileKey{Zoom: 2, X: 3, Y: 0}, []float64{90, 66.5132604431, 180, 85.0511287798}},
+		{TileKey{Zoom: 12, X: 2148, Y: 1436}, []float64{8.7890625, 47.2195681123, 8.876953125, 47.2792290026}},
+		{TileKey{Zoom: 17, X: 69585, Y: 46595}, []float64{11.1209106445, 46.0656084614, 11.1236572266, 46.0675141011}},
+		{TileKey{Zoom: 22, X: 2197815, Y: 1468004}, []float64{8.6399745941, 47.4222222940, 8.6400604248, 47.4222803662}},
+		{TileKey{Zoom: 22, X: 4194303, Y: 0}, []float64{179.9999141693, 85.0511213755, 180, 85.0511287798}},
+		{TileKey{Zoom: 22, X: 0, Y: 4194303}, []float64{-180, -85.0511287798, -179.9999141693, -85.0511213755}},
+	} {
+		// Call twice, so we also check the cached result.
+		for i := 0; i < 2; i++ {
+			got := EncodeBbox(e.key.Bounds())
+			for j := range e.expected {
+				// s2 represents longitude -180 as +180.
+				if math.Abs(math.Remainder(got[j]-e.expected[j], 360)) > 1e-9 {
+					t.Errorf("%v: expected %v, got %v", e.key, e.expected, got)
+					break
+				}
+			}
+		}
+	}
+}
+
+func TestTileKeyBounds_Neighbors(t *testing.T) {
+	// Adjacent tiles must share their edges, at every zoom level.
+	for zoom := uint8(1); zoom <= 22; zoom++ {
+		n := uint32(1) << zoom
+		for _, x := range []uint32{0, n/2 - 1, n - 2} {
+			for _, y := range []uint32{0, n / 3, n - 2} {
+				key := TileKey{Zoom: zoom, X: x, Y: y}
+				east := TileKey{Zoom: zoom, X: x + 1, Y: y}
+				south := TileKey{Zoom: zoom, X: x, Y: y + 1}
+				b, be, bs := key.Bounds(), east.Bounds(), south.Bounds()
+				if b.Lng.Hi != be.Lng.Lo {
+					t.Errorf("%v: eastern edge %v differs from western edge of %v: %v",
+						key, b.Lng.Hi, east, be.Lng.Lo)
+				}
+				if b.Lat.Lo != bs.Lat.Hi {
+					t.Errorf("%v: southern edge %v differs from northern edge of %v: %v",
+						key, b.Lat.Lo, south, bs.Lat.Hi)
+				}
+			}
+		}
+	}
+}
+
+func TestParseTileKey(t *testing.T) {
+	for _, e := range []struct {
+		zoom, x, y string
+		expected   TileKey
+		ok         bool
+	}{
+		{"0", "0", "0", TileKey{Zoom: 0, X: 0, Y: 0}, true},
+		{"17", "69585", "46595", TileKey{Zoom: 17, X: 69585, Y: 46595}, true},
+		{"1", "2", "0", TileKey{}, false},
+		{"1", "0", "2", TileKey{}, false},
+		{"31", "0", "0", TileKey{}, false},
+		{"300", "0", "0", TileKey{}, false},
+		{"3", "-1", "0", TileKey{}, false},
+		{"3", "x", "0", TileKey{}, false},
+	} {
+		got, ok := ParseTileKey(e.zoom, e.x, e.y)
+		if ok != e.ok || (ok && got != e.expected) {
+			t.Errorf("ParseTileKey(%s, %s, %s): expected %v %v, got %v %v",
+				e.zoom, e.x, e.y, e.expected, e.ok, got, ok)
+		}
+	}
+}
+
+func TestTileKeyPixelLatLng(t *testing.T) {
+	key := TileKey{Zoom: 12, X: 2148, Y: 1436}
+	b := key.Bounds()
+	if got := key.PixelLatLng(0, 0); got.Distance(b.Vertex(3)).Degrees() > 1e-9 {
+		t.Errorf("expected pixel (0, 0) at north-western corner, got %v", got)
+	}
+	if got := key.PixelLatLng(TileSize, TileSize); got.Distance(b.Vertex(1)).Degrees() > 1e-9 {
+		t.Errorf("expected pixel (256, 256) at south-eastern corner, got %v", got)
+	}
+	if !b.ContainsLatLng(key.PixelLatLng(100, 200)) {
+		t.Errorf("expected pixel (100, 200) inside tile")
+	}
 }
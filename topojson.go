@@ -0,0 +1,317 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"math"
+
+	"github.com/paulmach/go.geojson"
+)
+
+// Experimental TopoJSON encoder. For boundary datasets, adjacent polygons
+// share their borders; TopoJSON stores each shared edge only once, as an
+// arc that the polygons reference, so exports of such data shrink a lot.
+// Edges that should be shared but are not, because the upstream data has
+// gaps or overlaps, show up as pairs of arcs next to each other. We
+// detect shared edges among the features being encoded, which for
+// exports means within each page of items.
+// https://github.com/topojson/topojson-specification
+
+func init() {
+	RegisterOutputEncoder(topoJSONEncoder{}, true)
+}
+
+// topoJSONScale is the precision of quantized positions, in degrees.
+// Positions closer than this get merged, so edges shared up to rounding
+// errors still get detected.
+const topoJSONScale = 1e-7
+
+type topoJSONEncoder struct{}
+
+func (topoJSONEncoder) Name() string {
+	return "topojson"
+}
+
+func (topoJSONEncoder) MediaType() string {
+	return "application/topo+json"
+}
+
+type topoJSONDocument struct {
+	Type      string `json:"type"`
+	Transform struct {
+		Scale     [2]float64 `json:"scale"`
+		Translate [2]float64 `json:"translate"`
+	} `json:"transform"`
+	Objects map[string]*topoJSONGeometry `json:"objects"`
+	Arcs    [][][2]int64                 `json:"arcs"`
+}
+
+type topoJSONGeometry struct {
+	Type        interface{}            `json:"type"` // nil for features without geometry
+	ID          interface{}            `json:"id,omitempty"`
+	Properties  map[string]interface{} `json:"properties,omitempty"`
+	Coordinates interface{}            `json:"coordinates,omitempty"`
+	Arcs        interface{}            `json:"arcs,omitempty"`
+	Geometries  []*topoJSONGeometry    `json:"geometries,omitempty"`
+}
+
+// topoPoint is a quantized position.
+type topoPoint [2]int64
+
+// topoLine is a line string or polygon ring, which gets cut into arcs.
+// When marshalled, it turns into the list of its arc indexes.
+type topoLine struct {
+	points []topoPoint
+	closed bool
+	arcs   []int
+}
+
+func (l *topoLine) MarshalJSON() ([]byte, error) {
+	return json.Marshal(l.arcs)
+}
+
+// topoBuilder finds the arcs shared by lines and rings. Junctions are
+// where lines meet or part ways: the ends of line strings, and points
+// whose neighbors differ between the lines passing through them.
+type topoBuilder struct {
+	translate [2]float64
+	lines     []*topoLine
+	neighbors map[topoPoint][2]topoPoint
+	junctions map[topoPoint]bool
+	arcs      [][]topoPoint
+	arcIndex  map[string]int
+}
+
+func (e topoJSONEncoder) EncodeFeature(w io.Writer, collection string, feature RawFeature) error {
+	return e.EncodeFeatureCollection(w, collection, []RawFeature{feature})
+}
+
+func (topoJSONEncoder) EncodeFeatureCollection(w io.Writer, collection string, features []RawFeature) error {
+	b := &topoBuilder{
+		neighbors: make(map[topoPoint][2]topoPoint),
+		junctions: make(map[topoPoint]bool),
+		arcIndex:  make(map[string]int),
+	}
+	b.translate = topoJSONOrigin(features)
+
+	objects := &topoJSONGeometry{Type: "GeometryCollection", Geometries: []*topoJSONGeometry{}}
+	for _, f := range features {
+		g := b.geometry(f.Feature.Geometry)
+		g.ID = f.Feature.ID
+		if len(f.Feature.Properties) > 0 {
+			g.Properties = f.Feature.Properties
+		}
+		objects.Geometries = append(objects.Geometries, g)
+	}
+	b.findJunctions()
+	for _, line := range b.lines {
+		b.cut(line)
+	}
+
+	doc := topoJSONDocument{Type: "Topology", Objects: map[string]*topoJSONGeometry{collection: objects}}
+	doc.Transform.Scale = [2]float64{topoJSONScale, topoJSONScale}
+	doc.Transform.Translate = b.translate
+	doc.Arcs = make([][][2]int64, len(b.arcs))
+	for i, arc := range b.arcs {
+		// Arcs are delta-encoded.
+		encoded := make([][2]int64, len(arc))
+		var last topoPoint
+		for j, p := range arc {
+			encoded[j] = [2]int64{p[0] - last[0], p[1] - last[1]}
+			last = p
+		}
+		doc.Arcs[i] = encoded
+	}
+	return json.NewEncoder(w).Encode(doc)
+}
+
+// topoJSONOrigin returns the south-western corner of the features,
+// which becomes the origin of quantized positions.
+func topoJSONOrigin(features []RawFeature) [2]float64 {
+	origin := [2]float64{math.Inf(1), math.Inf(1)}
+	for _, f := range features {
+		if f.Feature.Geometry != nil {
+			forEachCoord(f.Feature.Geometry, func(p []float64) {
+				origin[0], origin[1] = math.Min(origin[0], p[0]), math.Min(origin[1], p[1])
+			})
+		}
+	}
+	if math.IsInf(origin[0], 1) {
+		return [2]float64{0, 0}
+	}
+	return origin
+}
+
+func (b *topoBuilder) quantize(p []float64) topoPoint {
+	return topoPoint{
+		int64(math.Round((p[0] - b.translate[0]) / topoJSONScale)),
+		int64(math.Round((p[1] - b.translate[1]) / topoJSONScale)),
+	}
+}
+
+// geometry converts a geometry, collecting its lines and rings.
+func (b *topoBuilder) geometry(g *geojson.Geometry) *topoJSONGeometry {
+	if g == nil {
+		return &topoJSONGeometry{}
+	}
+	result := &topoJSONGeometry{Type: string(g.Type)}
+	switch g.Type {
+	case geojson.GeometryPoint:
+		result.Coordinates = b.quantize(g.Point)
+	case geojson.GeometryMultiPoint:
+		points := make([]topoPoint, len(g.MultiPoint))
+		for i, p := range g.MultiPoint {
+			points[i] = b.quantize(p)
+		}
+		result.Coordinates = points
+	case geojson.GeometryLineString:
+		result.Arcs = b.line(g.LineString, false)
+	case geojson.GeometryMultiLineString:
+		result.Arcs = b.lineList(g.MultiLineString, false)
+	case geojson.GeometryPolygon:
+		result.Arcs = b.lineList(g.Polygon, true)
+	case geojson.GeometryMultiPolygon:
+		polygons := make([][]*topoLine, len(g.MultiPolygon))
+		for i, poly := range g.MultiPolygon {
+			polygons[i] = b.lineList(poly, true)
+		}
+		result.Arcs = polygons
+	case geojson.GeometryCollection:
+		result.Geometries = []*topoJSONGeometry{}
+		for _, child := range g.Geometries {
+			result.Geometries = append(result.Geometries, b.geometry(child))
+		}
+	}
+	return result
+}
+
+func (b *topoBuilder) lineList(lines [][][]float64, closed bool) []*topoLine {
+	result := make([]*topoLine, len(lines))
+	for i, line := range lines {
+		result[i] = b.line(line, closed)
+	}
+	return result
+}
+
+// line quantizes a line string or ring, dropping consecutive duplicate
+// positions; rings also lose their closing position.
+func (b *topoBuilder) line(coords [][]float64, closed bool) *topoLine {
+	line := &topoLine{closed: closed, arcs: []int{}}
+	for _, c := range coords {
+		p := b.quantize(c)
+		if n := len(line.points); n == 0 || line.points[n-1] != p {
+			line.points = append(line.points, p)
+		}
+	}
+	if closed && len(line.points) > 1 && line.points[0] == line.points[len(line.points)-1] {
+		line.points = line.points[:len(line.points)-1]
+	}
+	b.lines = append(b.lines, line)
+	return line
+}
+
+func (b *topoBuilder) findJunctions() {
+	for _, line := range b.lines {
+		n := len(line.points)
+		if n == 0 {
+			continue
+		}
+		if !line.closed {
+			b.junctions[line.points[0]] = true
+			b.junctions[line.points[n-1]] = true
+		}
+		for i, p := range line.points {
+			if !line.closed && (i == 0 || i == n-1) {
+				continue
+			}
+			prev, next := line.points[(i+n-1)%n], line.points[(i+1)%n]
+			if lessTopoPoint(next, prev) {
+				prev, next = next, prev
+			}
+			pair := [2]topoPoint{prev, next}
+			if seen, ok := b.neighbors[p]; !ok {
+				b.neighbors[p] = pair
+			} else if seen != pair {
+				b.junctions[p] = true
+			}
+		}
+	}
+}
+
+// cut splits a line at its junctions into arcs, reusing identical arcs
+// found before, possibly in reverse.
+func (b *topoBuilder) cut(line *topoLine) {
+	points := line.points
+	if len(points) == 0 {
+		return
+	}
+	if line.closed {
+		// Rings start at a junction, or else at their smallest point
+		// so that identical rings are found regardless of where they
+		// start. Either way, they end where they started.
+		start := -1
+		for i, p := range points {
+			if b.junctions[p] {
+				start = i
+				break
+			}
+		}
+		if start < 0 {
+			start = 0
+			for i, p := range points {
+				if lessTopoPoint(p, points[start]) {
+					start = i
+				}
+			}
+		}
+		rotated := make([]topoPoint, 0, len(points)+1)
+		rotated = append(rotated, points[start:]...)
+		rotated = append(rotated, points[:start]...)
+		points = append(rotated, points[start])
+	}
+
+	if len(points) == 1 {
+		line.arcs = append(line.arcs, b.arc([]topoPoint{points[0], points[0]}))
+		return
+	}
+	start := 0
+	for i := 1; i < len(points); i++ {
+		if i == len(points)-1 || b.junctions[points[i]] {
+			line.arcs = append(line.arcs, b.arc(points[start:i+1]))
+			start = i
+		}
+	}
+}
+
+// arc returns the index of an arc, which is negative (one's complement)
+// if an earlier arc runs the other way.
+func (b *topoBuilder) arc(points []topoPoint) int {
+	if i, ok := b.arcIndex[topoArcKey(points, false)]; ok {
+		return i
+	}
+	if i, ok := b.arcIndex[topoArcKey(points, true)]; ok {
+		return ^i
+	}
+	i := len(b.arcs)
+	b.arcs = append(b.arcs, points)
+	b.arcIndex[topoArcKey(points, false)] = i
+	return i
+}
+
+func topoArcKey(points []topoPoint, reverse bool) string {
+	key := make([]byte, 16*len(points))
+	for i, p := range points {
+		j := i
+		if reverse {
+			j = len(points) - 1 - i
+		}
+		binary.LittleEndian.PutUint64(key[16*j:], uint64(p[0]))
+		binary.LittleEndian.PutUint64(key[16*j+8:], uint64(p[1]))
+	}
+	return string(key)
+}
+
+func lessTopoPoint(a, b topoPoint) bool {
+	return a[0] < b[0] || (a[0] == b[0] && a[1] < b[1])
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTopoJSONEncoder(t *testing.T) {
+	// Two squares sharing an edge, which should become a single arc.
+	features, err := decodeRawFeatures([]byte(`{"features":[
+		{"type":"Feature","id":"A","properties":{"name":"A"},
+		 "geometry":{"type":"Polygon","coordinates":[[[0,0],[1,0],[1,1],[0,1],[0,0]]]}},
+		{"type":"Feature","id":"B","properties":{},
+		 "geometry":{"type":"Polygon","coordinates":[[[1,0],[2,0],[2,1],[1,1],[1,0]]]}},
+		{"type":"Feature","id":"d","properties":{},"geometry":{"type":"Point","coordinates":[2,1]}},
+		{"type":"Feature","id":"e","properties":{},"geometry":null}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out strings.Builder
+	if err := (topoJSONEncoder{}).EncodeFeatureCollection(&out, "regions", features); err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"type":"Topology","transform":{"scale":[1e-7,1e-7],"translate":[0,0]},` +
+		`"objects":{"regions":{"type":"GeometryCollection","geometries":[` +
+		`{"type":"Polygon","id":"A","properties":{"name":"A"},"arcs":[[0,1]]},` +
+		`{"type":"Polygon","id":"B","arcs":[[2,-1]]},` +
+		`{"type":"Point","id":"d","coordinates":[20000000,10000000]},` +
+		`{"type":null,"id":"e"}]}},` +
+		`"arcs":[[[10000000,0],[0,10000000]],` +
+		`[[10000000,10000000],[-10000000,0],[0,-10000000],[10000000,0]],` +
+		`[[10000000,0],[10000000,0],[0,10000000],[-10000000,0]]]}` + "\n"
+	if got := out.String(); got != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, got)
+	}
+}
+
+func TestTopoJSONEncoder_SharedRing(t *testing.T) {
+	// An island filling the hole of a lake, starting at another vertex
+	// and running the other way, shares the whole ring. The road along
+	// the shore shares the edge up to the corner of the lake.
+	features, err := decodeRawFeatures([]byte(`{"features":[
+		{"type":"Feature","id":"lake","properties":{},
+		 "geometry":{"type":"Polygon","coordinates":[[[0,0],[4,0],[4,4],[0,4],[0,0]],[[1,1],[1,2],[2,2],[2,1],[1,1]]]}},
+		{"type":"Feature","id":"island","properties":{},
+		 "geometry":{"type":"MultiPolygon","coordinates":[[[[2,2],[1,2],[1,1],[2,1],[2,2]]]]}},
+		{"type":"Feature","id":"road","properties":{},
+		 "geometry":{"type":"LineString","coordinates":[[0,0],[4,0],[4,0],[5,0]]}}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out strings.Builder
+	if err := (topoJSONEncoder{}).EncodeFeatureCollection(&out, "lakes", features); err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		`{"type":"Polygon","id":"lake","arcs":[[0,1],[2]]}`,
+		`{"type":"MultiPolygon","id":"island","arcs":[[[-3]]]}`,
+		`{"type":"LineString","id":"road","arcs":[0,3]}`,
+	} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("expected %s in:\n%s", expected, out.String())
+		}
+	}
+}
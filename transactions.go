@@ -0,0 +1,617 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/paulmach/go.geojson"
+)
+
+// Transactions, in the style of OGC API - Features - Part 4, so that
+// lightweight editing tools can write through miniwfs. On the admin
+// listener, POST /collections/{name}/items adds a feature, PUT to
+// /collections/{name}/items/{id} replaces one, and PATCH updates one
+// with a JSON merge patch. Like uploads, edits get validated by loading
+// the changed data, which also recomputes the bounds and web mercator
+// points of changed features. Then, they get persisted to the source
+// file, so they survive restarts. Only local GeoJSON and GeoJSON Lines
+// sources can be edited. To replace or update a feature, clients must
+// send the ETag they got for it in If-Match, so concurrent editors do
+// not silently overwrite each other's changes. Edits work on features
+// as they are in the source file, before migrations, enrichers and
+// splitting; parts of split features cannot be edited on their own.
+// https://docs.ogc.org/DRAFTS/20-002.html
+
+// MaxFeatureSize limits the size of features sent for writing, in bytes.
+const MaxFeatureSize = 16 * 1024 * 1024
+
+var NotEditable error = errors.New("FeatureCollection source is neither GeoJSON nor GeoJSON Lines")
+var DuplicateID error = errors.New("a feature with this ID already exists")
+var FeatureModified error = errors.New("feature has been modified since it was read")
+
+// SplitPart is returned for edits of features that only exist because
+// a multi-part feature got split when loading, see splitMultiGeometry.
+type SplitPart struct {
+	ID     string
+	Parent string
+}
+
+func (e *SplitPart) Error() string {
+	return fmt.Sprintf("feature %s is a part of feature %s, which gets split when loading; parts cannot be edited on their own", e.ID, e.Parent)
+}
+
+// InvalidFeature wraps the reason why a feature sent for writing was
+// rejected.
+type InvalidFeature struct {
+	Err error
+}
+
+func (e *InvalidFeature) Error() string {
+	return "invalid feature: " + e.Err.Error()
+}
+
+// CreateItem adds a feature to a collection and its source file. If
+// the feature has no ID, it gets a random one. Returns the ID of the
+// new feature.
+func (index *Index) CreateItem(collection string, f *geojson.Feature) (string, CollectionMetadata, error) {
+	if f.Type != "Feature" {
+		return "", CollectionMetadata{}, &InvalidFeature{fmt.Errorf("expected type Feature, got %q", f.Type)}
+	}
+	if f.ID == nil {
+		var idBytes [8]byte
+		if _, err := rand.Read(idBytes[:]); err != nil {
+			return "", CollectionMetadata{}, err
+		}
+		f.ID = hex.EncodeToString(idBytes[:])
+	}
+	id := getIDString(f.ID)
+	if len(id) == 0 {
+		return "", CollectionMetadata{}, &InvalidFeature{errors.New("feature ID must be a number or a non-empty string")}
+	}
+	encoded, err := json.Marshal(f)
+	if err != nil {
+		return "", CollectionMetadata{}, &InvalidFeature{err}
+	}
+
+	md, err := index.editSource(collection, func(features []json.RawMessage) ([]json.RawMessage, error) {
+		if findRawFeature(features, id) >= 0 {
+			return nil, DuplicateID
+		}
+		// Parts of split features are not in the source, but their
+		// IDs are taken nonetheless.
+		if existing, err := index.GetItem(collection, id); err != nil {
+			return nil, err
+		} else if existing != nil {
+			return nil, DuplicateID
+		}
+		return append(features, encoded), nil
+	})
+	if err != nil {
+		return "", CollectionMetadata{}, err
+	}
+	return id, md, nil
+}
+
+// ReplaceItem replaces a feature in a collection and its source file.
+// If the new feature has no ID, it gets the one of the replaced feature.
+// Unless the feature's current ETag matches ifMatch, the feature is
+// left alone and we return FeatureModified. Properties that enrichers
+// have added to the served feature do not get written to the source,
+// unless the client has changed them.
+func (index *Index) ReplaceItem(collection string, id string, ifMatch string, f *geojson.Feature) (CollectionMetadata, error) {
+	if f.Type != "Feature" {
+		return CollectionMetadata{}, &InvalidFeature{fmt.Errorf("expected type Feature, got %q", f.Type)}
+	}
+	if f.ID == nil {
+		f.ID = id
+	} else if getIDString(f.ID) != id {
+		return CollectionMetadata{}, &InvalidFeature{errors.New("feature ID differs from the one in the URL")}
+	}
+
+	return index.editSource(collection, func(features []json.RawMessage) ([]json.RawMessage, error) {
+		i, err := index.findEditableFeature(collection, features, id)
+		if err != nil {
+			return nil, err
+		}
+		served, err := index.checkItemETag(collection, features[i], ifMatch)
+		if err != nil {
+			return nil, err
+		}
+		source, err := geojson.UnmarshalFeature(features[i])
+		if err != nil {
+			return nil, err
+		}
+		removeEnrichedProperties(f, source, served, index.getMigrations(collection))
+		encoded, err := json.Marshal(f)
+		if err != nil {
+			return nil, &InvalidFeature{err}
+		}
+		features[i] = encoded
+		return features, nil
+	})
+}
+
+// UpdateItem applies a JSON merge patch to a feature in a collection
+// and its source file. Patches may not change the feature ID. Like
+// for ReplaceItem, the feature's current ETag must match ifMatch.
+// https://datatracker.ietf.org/doc/html/rfc7396
+func (index *Index) UpdateItem(collection string, id string, ifMatch string, patch []byte) (CollectionMetadata, error) {
+	decoder := json.NewDecoder(bytes.NewReader(patch))
+	decoder.UseNumber()
+	var p map[string]interface{}
+	if err := decoder.Decode(&p); err != nil {
+		return CollectionMetadata{}, &InvalidFeature{fmt.Errorf("merge patch must be a JSON object: %v", err)}
+	}
+
+	return index.editSource(collection, func(features []json.RawMessage) ([]json.RawMessage, error) {
+		i, err := index.findEditableFeature(collection, features, id)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := index.checkItemETag(collection, features[i], ifMatch); err != nil {
+			return nil, err
+		}
+		decoder := json.NewDecoder(bytes.NewReader(features[i]))
+		decoder.UseNumber()
+		var target interface{}
+		if err := decoder.Decode(&target); err != nil {
+			return nil, err
+		}
+		encoded, err := json.Marshal(mergePatch(target, p))
+		if err != nil {
+			return nil, &InvalidFeature{err}
+		}
+		f, err := geojson.UnmarshalFeature(encoded)
+		if err != nil {
+			return nil, &InvalidFeature{err}
+		}
+		if f.Type != "Feature" {
+			return nil, &InvalidFeature{fmt.Errorf("expected type Feature, got %q", f.Type)}
+		}
+		if getIDString(f.ID) != id {
+			return nil, &InvalidFeature{errors.New("merge patch may not change the feature ID")}
+		}
+		features[i] = encoded
+		return features, nil
+	})
+}
+
+// findEditableFeature returns the position of the feature with an ID
+// among the encoded features of a source file. For parts of split
+// features, which are not in the source, it returns a SplitPart error.
+func (index *Index) findEditableFeature(collection string, features []json.RawMessage, id string) (int, error) {
+	if i := findRawFeature(features, id); i >= 0 {
+		return i, nil
+	}
+	if k := strings.LastIndexByte(id, '#'); k > 0 && index.getLoadOptions(collection).splitMulti {
+		if parent := id[:k]; findRawFeature(features, parent) >= 0 {
+			return -1, &SplitPart{ID: id, Parent: parent}
+		}
+	}
+	return -1, NotFound
+}
+
+// checkItemETag returns FeatureModified unless an If-Match header
+// matches the ETag of a feature from the source file, as it gets
+// served once loaded. Returns the feature as it gets served. Callers
+// must hold uploadMutex, so the feature cannot change before they
+// write theirs.
+func (index *Index) checkItemETag(collection string, raw json.RawMessage, ifMatch string) (*geojson.Feature, error) {
+	f, err := index.loadSourceFeature(collection, raw)
+	if err != nil {
+		return nil, err
+	}
+	if f == nil {
+		return nil, NotFound
+	}
+	encoded, err := json.Marshal(f)
+	if err != nil {
+		return nil, err
+	}
+	if !matchETag(ifMatch, featureETag(encoded)) {
+		return nil, FeatureModified
+	}
+	return f, nil
+}
+
+// loadSourceFeature prepares a feature from the source file of a
+// collection like loading does, with migrations and enrichers. Returns
+// nil if the feature does not get served on its own, because it gets
+// split into parts.
+func (index *Index) loadSourceFeature(collection string, raw json.RawMessage) (*geojson.Feature, error) {
+	f, err := geojson.UnmarshalFeature(raw)
+	if err != nil {
+		return nil, err
+	}
+	opts := index.getLoadOptions(collection)
+	if opts.splitMulti {
+		if parts := splitMultiGeometry(f); len(parts) != 1 || parts[0] != f {
+			return nil, nil
+		}
+	}
+	if len(opts.migrations) > 0 {
+		makeFeatureMigrator(collection, opts.migrations).migrate(f)
+	}
+	for _, e := range opts.enrichers {
+		e.Enrich(collection, []*geojson.Feature{f})
+	}
+	return f, nil
+}
+
+// removeEnrichedProperties removes properties from a feature sent for
+// replacing a source feature, if enrichers have added them to the
+// served feature and the client has sent them back unchanged. Else,
+// they would get frozen into the source file. Properties renamed by
+// migrations are not in the source either, but must be kept.
+func removeEnrichedProperties(f *geojson.Feature, source *geojson.Feature, served *geojson.Feature, migrations []PropertyMigration) {
+	renamed := make(map[string]bool, len(migrations))
+	for _, m := range migrations {
+		if m.Op == "rename" {
+			renamed[m.To] = true
+		}
+	}
+	for key, value := range served.Properties {
+		if _, ok := source.Properties[key]; ok || renamed[key] {
+			continue
+		}
+		if v, ok := f.Properties[key]; ok && reflect.DeepEqual(v, value) {
+			delete(f.Properties, key)
+		}
+	}
+}
+
+// mergePatch applies a JSON merge patch to a decoded JSON value.
+func mergePatch(target interface{}, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	t, ok := target.(map[string]interface{})
+	if !ok {
+		t = make(map[string]interface{}, len(p))
+	}
+	for key, value := range p {
+		if value == nil {
+			delete(t, key)
+		} else {
+			t[key] = mergePatch(t[key], value)
+		}
+	}
+	return t
+}
+
+// findRawFeature returns the position of the feature with an ID among
+// encoded features, or -1 if there is none.
+func findRawFeature(features []json.RawMessage, id string) int {
+	for i, f := range features {
+		var feature struct {
+			ID interface{} `json:"id"`
+		}
+		if json.Unmarshal(f, &feature) == nil && getIDString(feature.ID) == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// editSource edits the features in the source file of a collection.
+// The changed data gets loaded, swapped in and written like an upload.
+// Holding uploadMutex from reading the source until the changed data
+// is in place keeps concurrent edits from getting lost.
+func (index *Index) editSource(collection string, edit func([]json.RawMessage) ([]json.RawMessage, error)) (CollectionMetadata, error) {
+	path, format, err := index.getEditPath(collection)
+	if err != nil {
+		return CollectionMetadata{}, err
+	}
+
+	index.uploadMutex.Lock()
+	defer index.uploadMutex.Unlock()
+	source, err := readSource(path)
+	if err != nil {
+		return CollectionMetadata{}, err
+	}
+	changed, err := editFeatures(source, format, edit)
+	if err != nil {
+		return CollectionMetadata{}, err
+	}
+
+	tmp, err := createUploadFile(path)
+	if err != nil {
+		return CollectionMetadata{}, err
+	}
+	defer os.Remove(tmp.Name()) // fails harmlessly after the rename
+	if _, err := tmp.Write(changed); err != nil {
+		tmp.Close()
+		return CollectionMetadata{}, err
+	}
+	if err := tmp.Close(); err != nil {
+		return CollectionMetadata{}, err
+	}
+	return index.installUploadLocked(collection, path, tmp.Name())
+}
+
+// getEditPath returns the source path of a collection that can be
+// edited, and the name of its loader.
+func (index *Index) getEditPath(collection string) (string, string, error) {
+	path, err := index.getUploadPath(collection)
+	if err != nil {
+		return "", "", err
+	}
+	loader, err := GetInputLoader(path)
+	if err != nil {
+		return "", "", err
+	}
+	if name := loader.Name(); name != "geojson" && name != "geojsonl" {
+		return "", "", NotEditable
+	}
+	return path, loader.Name(), nil
+}
+
+// editFeatures edits the features of GeoJSON or GeoJSON Lines data.
+// Edits may change features and append new ones. Only the bytes of
+// changed and new features get written; the rest of the source stays
+// as it was, so that edits do not reformat hand-maintained files.
+func editFeatures(source []byte, format string, edit func([]json.RawMessage) ([]json.RawMessage, error)) ([]byte, error) {
+	var layout *featureLayout
+	var err error
+	if format == "geojsonl" {
+		layout, err = locateFeatureLines(source)
+	} else {
+		layout, err = locateFeatures(source)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	features := layout.features
+	edited, err := edit(append([]json.RawMessage(nil), features...))
+	if err != nil {
+		return nil, err
+	}
+	if len(edited) < len(features) {
+		return nil, errors.New("features cannot be removed by editing")
+	}
+
+	var buf bytes.Buffer
+	var pos int64
+	for i, span := range layout.spans {
+		buf.Write(source[pos:span[0]])
+		buf.Write(edited[i])
+		pos = span[1]
+	}
+	buf.Write(source[pos:layout.insertAt])
+	if added := edited[len(features):]; len(added) > 0 {
+		buf.WriteString(layout.before)
+		for i, f := range added {
+			if i > 0 {
+				buf.WriteString(layout.separator)
+			}
+			buf.Write(f)
+		}
+		buf.WriteString(layout.after)
+	}
+	buf.Write(source[layout.insertAt:])
+	return buf.Bytes(), nil
+}
+
+// featureLayout tells where the features are in a source file, and how
+// to insert new ones.
+type featureLayout struct {
+	features  []json.RawMessage
+	spans     [][2]int64 // start and end offset of each feature
+	insertAt  int64      // where new features go
+	before    string     // written before new features
+	separator string     // written between new features
+	after     string     // written after new features
+}
+
+// locateFeatures finds the features of a GeoJSON FeatureCollection.
+func locateFeatures(source []byte) (*featureLayout, error) {
+	layout := &featureLayout{separator: ",\n"}
+	decoder := json.NewDecoder(bytes.NewReader(source))
+	if t, err := decoder.Token(); err != nil {
+		return nil, err
+	} else if t != json.Delim('{') {
+		return nil, errors.New("expected a GeoJSON object")
+	}
+
+	hasFeatures, hasMembers := false, false
+	for decoder.More() {
+		t, err := decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		hasMembers = true
+		if key, _ := t.(string); key != "features" || hasFeatures {
+			var value json.RawMessage
+			if err := decoder.Decode(&value); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		hasFeatures = true
+		if t, err := decoder.Token(); err != nil {
+			return nil, err
+		} else if t != json.Delim('[') {
+			return nil, errors.New("expected features to be an array")
+		}
+		for decoder.More() {
+			var f json.RawMessage
+			if err := decoder.Decode(&f); err != nil {
+				return nil, err
+			}
+			end := decoder.InputOffset()
+			layout.features = append(layout.features, f)
+			layout.spans = append(layout.spans, [2]int64{end - int64(len(f)), end})
+		}
+		if _, err := decoder.Token(); err != nil {
+			return nil, err
+		}
+		// New features go after the last one, or into the empty array.
+		if n := len(layout.spans); n > 0 {
+			layout.insertAt = layout.spans[n-1][1]
+			layout.before = ",\n"
+		} else {
+			layout.insertAt = decoder.InputOffset() - 1
+		}
+	}
+	if _, err := decoder.Token(); err != nil {
+		return nil, err
+	}
+
+	if !hasFeatures {
+		layout.insertAt = decoder.InputOffset() - 1
+		layout.before, layout.after = `"features":[`, "]"
+		if hasMembers {
+			layout.before = "," + layout.before
+		}
+	}
+	return layout, nil
+}
+
+// locateFeatureLines finds the features of newline-delimited GeoJSON.
+// Files with RFC 8142 record separators keep them for new features.
+func locateFeatureLines(source []byte) (*featureLayout, error) {
+	layout := &featureLayout{insertAt: int64(len(source)), separator: "\n", after: "\n"}
+	decoder := json.NewDecoder(recordSeparatorReader{bytes.NewReader(source)})
+	for {
+		var f json.RawMessage
+		if err := decoder.Decode(&f); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		end := decoder.InputOffset()
+		layout.features = append(layout.features, f)
+		layout.spans = append(layout.spans, [2]int64{end - int64(len(f)), end})
+	}
+	if len(source) > 0 && source[len(source)-1] != '\n' {
+		layout.before = "\n"
+	}
+	if len(source) > 0 && source[0] == 0x1e {
+		layout.separator += "\x1e"
+		layout.before += "\x1e"
+	}
+	return layout, nil
+}
+
+func (s *WebServer) handleCreateItemRequest(w http.ResponseWriter, req *http.Request, collection string) {
+	var f geojson.Feature
+	body := http.MaxBytesReader(w, req.Body, MaxFeatureSize)
+	if err := json.NewDecoder(body).Decode(&f); err != nil {
+		writeEditError(w, collection, &InvalidFeature{err})
+		return
+	}
+
+	id, _, err := s.index.CreateItem(collection, &f)
+	if err != nil {
+		writeEditError(w, collection, err)
+		return
+	}
+	w.Header().Set("Location", FormatItemURL(s.publicPath(req), collection, id))
+	s.writeEditedItem(w, collection, id, http.StatusCreated)
+}
+
+// handleEditItemRequest handles PUT and PATCH requests for an item.
+// Requests without If-Match get rejected with status 428, since they
+// could overwrite changes their client has not seen.
+// https://www.rfc-editor.org/rfc/rfc6585#section-3
+func (s *WebServer) handleEditItemRequest(w http.ResponseWriter, req *http.Request, collection string, item string) {
+	ifMatch := req.Header.Get("If-Match")
+	if len(ifMatch) == 0 {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusPreconditionRequired)
+		io.WriteString(w, "editing requires If-Match with the ETag of the feature\n")
+		return
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, req.Body, MaxFeatureSize))
+	if err != nil {
+		writeEditError(w, collection, &InvalidFeature{err})
+		return
+	}
+
+	if req.Method == "PATCH" {
+		mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+		if mediaType != "application/merge-patch+json" && mediaType != "application/json" {
+			w.Header().Set("Accept-Patch", "application/merge-patch+json")
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+			return
+		}
+		_, err = s.index.UpdateItem(collection, item, ifMatch, body)
+	} else {
+		var f geojson.Feature
+		if err := json.Unmarshal(body, &f); err != nil {
+			writeEditError(w, collection, &InvalidFeature{err})
+			return
+		}
+		_, err = s.index.ReplaceItem(collection, item, ifMatch, &f)
+	}
+	if err != nil {
+		writeEditError(w, collection, err)
+		return
+	}
+	s.writeEditedItem(w, collection, item, http.StatusOK)
+}
+
+// writeEditedItem replies with a feature as it is served after an edit.
+func (s *WebServer) writeEditedItem(w http.ResponseWriter, collection string, id string, status int) {
+	feature, err := s.index.GetItem(collection, id)
+	if err == nil && feature == nil {
+		err = NotFound // removed by a concurrent edit
+	}
+	if err != nil {
+		writeEditError(w, collection, err)
+		return
+	}
+	encoded, err := json.Marshal(feature)
+	if err != nil {
+		writeEditError(w, collection, err)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("ETag", featureETag(encoded))
+	w.Header().Set("Content-Type", "application/geo+json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(encoded)))
+	w.WriteHeader(status)
+	w.Write(encoded)
+}
+
+func writeEditError(w http.ResponseWriter, collection string, err error) {
+	status := http.StatusInternalServerError
+	switch err.(type) {
+	case *InvalidFeature, *InvalidUpload:
+		status = http.StatusBadRequest
+	case *SplitPart:
+		status = http.StatusConflict
+	default:
+		switch err {
+		case NotFound:
+			status = http.StatusNotFound
+		case NotLocal, NotEditable, DuplicateID:
+			status = http.StatusConflict
+		case FeatureModified:
+			status = http.StatusPreconditionFailed
+		case MemoryExceeded:
+			status = http.StatusInsufficientStorage
+		}
+	}
+	httpLog.Warn("editing collection failed", "collection", collection, "error", err)
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(status)
+	io.WriteString(w, err.Error()+"\n")
+}
@@ -0,0 +1,458 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/paulmach/go.geojson"
+)
+
+func TestCreateItem(t *testing.T) {
+	index, s, path, cleanup := makeUploadServer(t)
+	defer cleanup()
+
+	feature := `{"type":"Feature","id":"N7","properties":{"name":"Greifensee"},"geometry":{"type":"Point","coordinates":[8.68,47.35]}}`
+	resp := upload(s, "/collections/lakes/items", []byte(feature))
+	if resp.Code != http.StatusCreated {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusCreated, resp.Code, getBody(resp))
+	}
+	if loc := resp.Header().Get("Location"); loc != "https://test.example.org/wfs/collections/lakes/items/N7" {
+		t.Errorf("unexpected Location: %s", loc)
+	}
+	if item, _ := index.GetItem("lakes", "N7"); item == nil || item.Properties["name"] != "Greifensee" {
+		t.Errorf("expected created feature to be served, got %v", item)
+	}
+
+	// Features without ID get a random one.
+	resp = upload(s, "/collections/lakes/items", []byte(`{"type":"Feature","properties":{},"geometry":null}`))
+	if resp.Code != http.StatusCreated {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusCreated, resp.Code, getBody(resp))
+	}
+	var created geojson.Feature
+	if err := json.Unmarshal(resp.Body.Bytes(), &created); err != nil {
+		t.Fatal(err)
+	}
+	id, _ := created.ID.(string)
+	if len(id) != 16 || !strings.HasSuffix(resp.Header().Get("Location"), "/items/"+id) {
+		t.Errorf("expected random ID and matching Location, got %q and %s", id, resp.Header().Get("Location"))
+	}
+
+	// The source file must contain all features, so they survive restarts.
+	written, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fc geojson.FeatureCollection
+	if err := json.Unmarshal(written, &fc); err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, f := range fc.Features {
+		ids = append(ids, getIDString(f.ID))
+	}
+	if got := strings.Join(ids, ","); got != "N123,N7,"+id {
+		t.Errorf("expected features N123,N7,%s in %s, got %s", id, path, got)
+	}
+
+	for _, tc := range []struct {
+		path, body string
+		status     int
+	}{
+		{"/collections/lakes/items", feature, http.StatusConflict},
+		{"/collections/lakes/items", `{"type":"Feature","id":true,"geometry":null}`, http.StatusBadRequest},
+		{"/collections/lakes/items", `{"type":"Feature","id":"","geometry":null}`, http.StatusBadRequest},
+		{"/collections/lakes/items", `{"type":"FeatureCollection","features":[]}`, http.StatusBadRequest},
+		{"/collections/lakes/items", `garbage`, http.StatusBadRequest},
+		{"/collections/nosuchcollection/items", feature, http.StatusNotFound},
+	} {
+		if resp := upload(s, tc.path, []byte(tc.body)); resp.Code != tc.status {
+			t.Errorf("POST %s %s: expected status %d, got %d", tc.path, tc.body, tc.status, resp.Code)
+		}
+	}
+
+	index.SetMaintenanceMode(MaintenanceMode{Enabled: true})
+	if resp := upload(s, "/collections/lakes/items", []byte(feature)); resp.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected status %d during maintenance, got %d", http.StatusServiceUnavailable, resp.Code)
+	}
+}
+
+func TestCreateItem_NumericID(t *testing.T) {
+	index, s, path, cleanup := makeUploadServer(t)
+	defer cleanup()
+
+	feature := `{"type":"Feature","id":7,"properties":{"name":"Greifensee"},"geometry":null}`
+	resp := upload(s, "/collections/lakes/items", []byte(feature))
+	if resp.Code != http.StatusCreated {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusCreated, resp.Code, getBody(resp))
+	}
+	if loc := resp.Header().Get("Location"); loc != "https://test.example.org/wfs/collections/lakes/items/7" {
+		t.Errorf("unexpected Location: %s", loc)
+	}
+	if item, _ := index.GetItem("lakes", "7"); item == nil || item.Properties["name"] != "Greifensee" {
+		t.Errorf("expected created feature to be served, got %v", item)
+	}
+	if written, _ := ioutil.ReadFile(path); !strings.Contains(string(written), `"id":7`) {
+		t.Errorf("expected numeric ID to be kept in source, got %s", written)
+	}
+	if resp := upload(s, "/collections/lakes/items", []byte(feature)); resp.Code != http.StatusConflict {
+		t.Errorf("expected status %d for duplicate numeric ID, got %d", http.StatusConflict, resp.Code)
+	}
+}
+
+func TestCreateItem_NotOnPublicListener(t *testing.T) {
+	index, s, _, cleanup := makeUploadServer(t)
+	defer cleanup()
+	s.admin = false
+	resp := upload(s, "/collections/lakes/items", []byte(`{"type":"Feature","id":"N7","geometry":null}`))
+	if resp.Code != http.StatusMethodNotAllowed || resp.Header().Get("Allow") != "GET, HEAD" {
+		t.Errorf("expected status %d on public listener, got %d", http.StatusMethodNotAllowed, resp.Code)
+	}
+	if item, _ := index.GetItem("lakes", "N7"); item != nil {
+		t.Error("expected no feature to be created on public listener")
+	}
+}
+
+func TestCreateItem_APIKey(t *testing.T) {
+	index, s, _, cleanup := makeUploadServer(t)
+	defer cleanup()
+	req, _ := http.NewRequest("POST", "/collections/lakes/items",
+		strings.NewReader(`{"type":"Feature","id":"N7","geometry":null}`))
+	req.Header.Set("X-API-Key", "wrong-key")
+	resp := httptest.NewRecorder()
+	http.HandlerFunc(s.HandleRequest).ServeHTTP(resp, req)
+	if resp.Code != http.StatusForbidden {
+		t.Errorf("expected status %d with wrong API key, got %d", http.StatusForbidden, resp.Code)
+	}
+	if item, _ := index.GetItem("lakes", "N7"); item != nil {
+		t.Error("expected no feature to be created with wrong API key")
+	}
+}
+
+func TestReplaceItem(t *testing.T) {
+	index, s, path, cleanup := makeUploadServer(t)
+	defer cleanup()
+
+	feature := `{"type":"Feature","properties":{"name":"Katzensee","depth":8},"geometry":{"type":"Point","coordinates":[8.5,47.43]}}`
+	resp := edit(s, "PUT", "/collections/lakes/items/N123", "application/geo+json", feature)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, resp.Code, getBody(resp))
+	}
+	item, _ := index.GetItem("lakes", "N123")
+	if item == nil || item.Properties["depth"] != 8.0 {
+		t.Errorf("expected replaced feature to be served, got %v", item)
+	}
+
+	// The bounds and web mercator point of the feature must have been
+	// recomputed, or spatial queries would find it at its old place.
+	var buf bytes.Buffer
+	var noTime time.Time
+	bbox, _ := parseBbox("8.49,47.42,8.51,47.44")
+	if _, _, err := index.GetItems("lakes", itemsQuery{itemsSelection: itemsSelection{bbox: bbox}, limit: 10}, noTime, noTime, "", false, &buf); err != nil {
+		t.Fatal(err)
+	}
+	if features, _ := splitRawFeatures(buf.Bytes()); len(features) != 1 {
+		t.Errorf("expected replaced feature at its new position, got %s", buf.String())
+	}
+
+	written, _ := ioutil.ReadFile(path)
+	if !strings.Contains(string(written), `"depth":8`) || strings.Contains(string(written), "11.183468") {
+		t.Errorf("expected replaced feature to be written to %s, got %s", path, written)
+	}
+
+	for _, tc := range []struct {
+		path, body string
+		status     int
+	}{
+		{"/collections/lakes/items/N123", `{"type":"Feature","id":"N7","geometry":null}`, http.StatusBadRequest},
+		{"/collections/lakes/items/N123", `{"type":"Point","coordinates":[1,2]}`, http.StatusBadRequest},
+		{"/collections/lakes/items/N7", feature, http.StatusNotFound},
+		{"/collections/nosuchcollection/items/N123", feature, http.StatusNotFound},
+	} {
+		if resp := edit(s, "PUT", tc.path, "application/geo+json", tc.body); resp.Code != tc.status {
+			t.Errorf("PUT %s %s: expected status %d, got %d", tc.path, tc.body, tc.status, resp.Code)
+		}
+	}
+}
+
+func TestUpdateItem(t *testing.T) {
+	index, s, _, cleanup := makeUploadServer(t)
+	defer cleanup()
+
+	patch := `{"properties":{"natural":null,"depth":8},"geometry":{"coordinates":[8.5,47.43]}}`
+	resp := edit(s, "PATCH", "/collections/lakes/items/N123", "application/merge-patch+json", patch)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, resp.Code, getBody(resp))
+	}
+	expectJSON(t, getBody(resp), `{
+		"id": "N123",
+		"type": "Feature",
+		"geometry": {"type": "Point", "coordinates": [8.5, 47.43]},
+		"properties": {"depth": 8, "name": "Katzensee"}
+	}`)
+	if item, _ := index.GetItem("lakes", "N123"); item == nil || item.Properties["depth"] != 8.0 {
+		t.Errorf("expected updated feature to be served, got %v", item)
+	}
+
+	for _, tc := range []struct {
+		path, contentType, body string
+		status                  int
+	}{
+		{"/collections/lakes/items/N123", "application/merge-patch+json", `{"id":"N7"}`, http.StatusBadRequest},
+		{"/collections/lakes/items/N123", "application/merge-patch+json", `{"type":null}`, http.StatusBadRequest},
+		{"/collections/lakes/items/N123", "application/merge-patch+json", `[]`, http.StatusBadRequest},
+		{"/collections/lakes/items/N123", "text/plain", `{}`, http.StatusUnsupportedMediaType},
+		{"/collections/lakes/items/N7", "application/merge-patch+json", `{}`, http.StatusNotFound},
+	} {
+		if resp := edit(s, "PATCH", tc.path, tc.contentType, tc.body); resp.Code != tc.status {
+			t.Errorf("PATCH %s %s: expected status %d, got %d", tc.path, tc.body, tc.status, resp.Code)
+		}
+	}
+
+	s.admin = false
+	if resp := edit(s, "PATCH", "/collections/lakes/items/N123", "application/merge-patch+json", `{}`); resp.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected status %d on public listener, got %d", http.StatusMethodNotAllowed, resp.Code)
+	}
+}
+
+// edit sends a request for editing a feature, whatever its ETag.
+func edit(s *WebServer, method string, path string, contentType string, body string) *httptest.ResponseRecorder {
+	return editIfMatch(s, method, path, contentType, body, "*")
+}
+
+func editIfMatch(s *WebServer, method string, path string, contentType string, body string, ifMatch string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("X-API-Key", adminAPIKey)
+	if len(ifMatch) > 0 {
+		req.Header.Set("If-Match", ifMatch)
+	}
+	resp := httptest.NewRecorder()
+	http.HandlerFunc(s.HandleRequest).ServeHTTP(resp, req)
+	return resp
+}
+
+func TestEditItem_APIKey(t *testing.T) {
+	_, s, path, cleanup := makeUploadServer(t)
+	defer cleanup()
+	before, _ := ioutil.ReadFile(path)
+
+	for _, method := range []string{"PUT", "PATCH"} {
+		req, _ := http.NewRequest(method, "/collections/lakes/items/N123", strings.NewReader(`{}`))
+		req.Header.Set("If-Match", "*")
+		resp := httptest.NewRecorder()
+		http.HandlerFunc(s.HandleRequest).ServeHTTP(resp, req)
+		if resp.Code != http.StatusUnauthorized {
+			t.Errorf("%s without API key: expected status %d, got %d", method, http.StatusUnauthorized, resp.Code)
+		}
+	}
+	if after, _ := ioutil.ReadFile(path); !bytes.Equal(before, after) {
+		t.Error("expected source to be unchanged after unauthorized edits")
+	}
+}
+
+func TestEditItem_IfMatch(t *testing.T) {
+	_, s, _, cleanup := makeUploadServer(t)
+	defer cleanup()
+
+	req, _ := http.NewRequest("GET", "/collections/lakes/items/N123", nil)
+	resp := httptest.NewRecorder()
+	http.HandlerFunc(s.HandleRequest).ServeHTTP(resp, req)
+	etag := resp.Header().Get("ETag")
+	if resp.Code != http.StatusOK || len(etag) != 34 {
+		t.Fatalf("expected status %d with ETag, got %d and %q", http.StatusOK, resp.Code, etag)
+	}
+
+	path := "/collections/lakes/items/N123"
+	patch := `{"properties":{"depth":8}}`
+	for _, ifMatch := range []string{"", `"stale"`, "W/" + etag} {
+		resp := editIfMatch(s, "PATCH", path, "application/merge-patch+json", patch, ifMatch)
+		expected := http.StatusPreconditionFailed
+		if len(ifMatch) == 0 {
+			expected = http.StatusPreconditionRequired
+		}
+		if resp.Code != expected {
+			t.Errorf("If-Match %q: expected status %d, got %d", ifMatch, expected, resp.Code)
+		}
+	}
+
+	resp = editIfMatch(s, "PATCH", path, "application/merge-patch+json", patch, `"stale", `+etag)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, resp.Code, getBody(resp))
+	}
+	newETag := resp.Header().Get("ETag")
+	if len(newETag) != 34 || newETag == etag {
+		t.Errorf("expected new ETag after edit, got %q", newETag)
+	}
+
+	// A concurrent editor who has read the old version must not be able
+	// to overwrite the edit.
+	feature := `{"type":"Feature","properties":{"name":"Katzensee"},"geometry":null}`
+	if resp := editIfMatch(s, "PUT", path, "application/geo+json", feature, etag); resp.Code != http.StatusPreconditionFailed {
+		t.Errorf("expected status %d for outdated ETag, got %d", http.StatusPreconditionFailed, resp.Code)
+	}
+	if resp := editIfMatch(s, "PUT", path, "application/geo+json", feature, newETag); resp.Code != http.StatusOK {
+		t.Errorf("expected status %d for current ETag, got %d", http.StatusOK, resp.Code)
+	}
+}
+
+func TestMergePatch(t *testing.T) {
+	// Examples from RFC 7396, Appendix A.
+	for _, tc := range []struct{ target, patch, expected string }{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`["a","b"]`, `["c","d"]`, `["c","d"]`},
+		{`{"a":"b"}`, `["c"]`, `["c"]`},
+		{`{"e":null}`, `{"a":1}`, `{"a":1,"e":null}`},
+		{`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+		{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+	} {
+		var target, patch interface{}
+		json.Unmarshal([]byte(tc.target), &target)
+		json.Unmarshal([]byte(tc.patch), &patch)
+		got, _ := json.Marshal(mergePatch(target, patch))
+		if string(got) != tc.expected {
+			t.Errorf("patching %s with %s: expected %s, got %s", tc.target, tc.patch, tc.expected, got)
+		}
+	}
+}
+
+func TestEditFeatures(t *testing.T) {
+	appendB := func(features []json.RawMessage) ([]json.RawMessage, error) {
+		return append(features, json.RawMessage(`{"id":"b"}`)), nil
+	}
+	replaceA := func(features []json.RawMessage) ([]json.RawMessage, error) {
+		features[findRawFeature(features, "a")] = json.RawMessage(`{"id":"a","x":1}`)
+		return features, nil
+	}
+
+	// Only the edited features change; the rest keeps its formatting.
+	for _, tc := range []struct {
+		source   string
+		edit     func([]json.RawMessage) ([]json.RawMessage, error)
+		expected string
+	}{
+		{"{\"type\": \"FeatureCollection\", \"name\": \"lakes\",\n\t\"features\": [\n\t\t{\"id\": \"a\"},\n\t\t{\"id\": \"c\"}\n\t]\n}\n", replaceA,
+			"{\"type\": \"FeatureCollection\", \"name\": \"lakes\",\n\t\"features\": [\n\t\t{\"id\":\"a\",\"x\":1},\n\t\t{\"id\": \"c\"}\n\t]\n}\n"},
+		{"{\"type\":\"FeatureCollection\",\"features\":[{\"id\":\"a\"}], \"name\":\"lakes\"}", appendB,
+			"{\"type\":\"FeatureCollection\",\"features\":[{\"id\":\"a\"},\n{\"id\":\"b\"}], \"name\":\"lakes\"}"},
+		{"{\"type\":\"FeatureCollection\",\"features\":[ ]}\n", appendB,
+			"{\"type\":\"FeatureCollection\",\"features\":[ {\"id\":\"b\"}]}\n"},
+		{"{\"type\":\"FeatureCollection\"}", appendB,
+			"{\"type\":\"FeatureCollection\",\"features\":[{\"id\":\"b\"}]}"},
+		{"{}", appendB, "{\"features\":[{\"id\":\"b\"}]}"},
+	} {
+		got, err := editFeatures([]byte(tc.source), "geojson", tc.edit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != tc.expected {
+			t.Errorf("editing %q: expected %q, got %q", tc.source, tc.expected, got)
+		}
+	}
+	if _, err := editFeatures([]byte(`[]`), "geojson", appendB); err == nil {
+		t.Error("expected error for malformed FeatureCollection")
+	}
+
+	for _, tc := range []struct {
+		lines    string
+		edit     func([]json.RawMessage) ([]json.RawMessage, error)
+		expected string
+	}{
+		{"", appendB, "{\"id\":\"b\"}\n"},
+		{"{\"id\":\"a\"}", appendB, "{\"id\":\"a\"}\n{\"id\":\"b\"}\n"},
+		{"{\"id\":\"a\"}\n\n", appendB, "{\"id\":\"a\"}\n\n{\"id\":\"b\"}\n"},
+		{"\x1e{\"id\":\"a\"}\n", appendB, "\x1e{\"id\":\"a\"}\n\x1e{\"id\":\"b\"}\n"},
+		{"{\"id\": \"c\"}\n{\n  \"id\": \"a\"\n}\n{\"id\": \"d\"}\n", replaceA, "{\"id\": \"c\"}\n{\"id\":\"a\",\"x\":1}\n{\"id\": \"d\"}\n"},
+	} {
+		got, err := editFeatures([]byte(tc.lines), "geojsonl", tc.edit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != tc.expected {
+			t.Errorf("editing %q: expected %q, got %q", tc.lines, tc.expected, got)
+		}
+	}
+}
+
+func TestEditItem_SplitCollection(t *testing.T) {
+	index, s, path, cleanup := makeUploadServer(t)
+	defer cleanup()
+	islands := `{"type":"Feature","id":"R9","geometry":{"type":"MultiPoint","coordinates":[[8.5,47.4],[8.6,47.5]]},"properties":{"name":"Islands"}}`
+	if resp := upload(s, "/collections/lakes/items", []byte(islands)); resp.Code != http.StatusCreated {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusCreated, resp.Code, getBody(resp))
+	}
+	index.SetSplitMultiGeometries([]string{"lakes"})
+
+	resp := edit(s, "PATCH", "/collections/lakes/items/R9%231", "application/merge-patch+json", `{"properties":{"name":"Isle"}}`)
+	if resp.Code != http.StatusConflict || !strings.Contains(getBody(resp), "part of feature R9") {
+		t.Errorf("expected status %d explaining that R9#1 is a part of R9, got %d: %s", http.StatusConflict, resp.Code, getBody(resp))
+	}
+	if resp := edit(s, "PATCH", "/collections/lakes/items/R9", "application/merge-patch+json", `{}`); resp.Code != http.StatusNotFound {
+		t.Errorf("expected status %d for split feature, got %d", http.StatusNotFound, resp.Code)
+	}
+	if resp := upload(s, "/collections/lakes/items", []byte(strings.Replace(islands, `"R9"`, `"R9#2"`, 1))); resp.Code != http.StatusConflict {
+		t.Errorf("expected status %d for creating a feature with the ID of a part, got %d", http.StatusConflict, resp.Code)
+	}
+
+	// Features that do not get split can still be edited.
+	if resp := edit(s, "PATCH", "/collections/lakes/items/N123", "application/merge-patch+json", `{"properties":{"depth":8}}`); resp.Code != http.StatusOK {
+		t.Errorf("expected status %d, got %d: %s", http.StatusOK, resp.Code, getBody(resp))
+	}
+	if written, _ := ioutil.ReadFile(path); strings.Contains(string(written), "R9#") {
+		t.Errorf("expected parts not to be written to %s, got %s", path, written)
+	}
+}
+
+func TestReplaceItem_EnrichedProperties(t *testing.T) {
+	index, s, path, cleanup := makeUploadServer(t)
+	defer cleanup()
+	tagger, err := ReadRegionTagger(filepath.Join("testdata", "regions.geojson"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index.SetRegionTagger(tagger)
+	index.SetCollectionMigrations(map[string][]PropertyMigration{
+		"lakes": {{Op: "rename", Property: "natural", To: "kind"}},
+	})
+
+	// Clients send back the feature as served, with their changes.
+	// Its ETag must be accepted for editing the feature in the source.
+	req, _ := http.NewRequest("GET", "/collections/lakes/items/N123", nil)
+	resp := httptest.NewRecorder()
+	http.HandlerFunc(s.HandleRequest).ServeHTTP(resp, req)
+	var served geojson.Feature
+	json.Unmarshal(resp.Body.Bytes(), &served)
+	if served.Properties["country"] != "DE" || served.Properties["kind"] != "lake" {
+		t.Fatalf("expected enriched and migrated feature, got %v", served.Properties)
+	}
+	served.Properties["depth"] = 8
+	body, _ := json.Marshal(served)
+	resp = editIfMatch(s, "PUT", "/collections/lakes/items/N123", "application/geo+json", string(body), resp.Header().Get("ETag"))
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, resp.Code, getBody(resp))
+	}
+
+	written, _ := ioutil.ReadFile(path)
+	if !strings.Contains(string(written), `"kind":"lake"`) || !strings.Contains(string(written), `"depth":8`) {
+		t.Errorf("expected renamed and changed properties to be written to %s, got %s", path, written)
+	}
+	if strings.Contains(string(written), "country") {
+		t.Errorf("expected enriched properties not to be written to %s, got %s", path, written)
+	}
+	if item, _ := index.GetItem("lakes", "N123"); item == nil || item.Properties["country"] != "DE" {
+		t.Errorf("expected replaced feature to get enriched, got %v", item)
+	}
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// End-to-end tests with golden HTTP transcripts. Each file in
+// testdata/transcripts/*.txt lists requests, separated by blank lines:
+// a request line such as "GET /collections/castles/items?limit=1",
+// optionally followed by header lines. Lines starting with # are
+// comments. In requests, {next} is replaced by the path of the "next"
+// link in the previous response, and {Header-Name} by the value of
+// that header in the previous response, for following paging chains
+// and making conditional requests.
+//
+// The test boots the real server on a random port, sends the requests,
+// and compares the transcript of requests and responses with the
+// .golden file next to the requests. After intended changes to the
+// API, regenerate the golden files and review their diff:
+//
+//	go test -run TestTranscripts -update
+var updateGolden = flag.Bool("update", false, "rewrite the golden files of transcript tests")
+
+// maskedHeaders differ between runs or checkouts, so transcripts only
+// tell whether they are present.
+var maskedHeaders = map[string]bool{
+	"Date":          true,
+	"Last-Modified": true,
+}
+
+var transcriptPlaceholder = regexp.MustCompile(`\{([A-Za-z-]+)\}`)
+
+func TestTranscripts(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "transcripts", "*.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("no transcripts found")
+	}
+	for _, file := range files {
+		name := strings.TrimSuffix(filepath.Base(file), ".txt")
+		t.Run(name, func(t *testing.T) { runTranscript(t, file) })
+	}
+}
+
+func runTranscript(t *testing.T, file string) {
+	index, s := makeServer(t)
+	defer s.Shutdown()
+	defer index.Close()
+	mux := http.NewServeMux()
+	registerHandlers(mux, s)
+	server := httptest.NewServer(s.routeItemsDirectly(mux))
+	defer server.Close()
+
+	requests, err := readTranscriptRequests(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// We want to see responses as the server sent them, so the client
+	// must neither decompress bodies nor follow redirects.
+	transport := &http.Transport{DisableCompression: true}
+	defer transport.CloseIdleConnections()
+	client := &http.Client{
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	var transcript bytes.Buffer
+	var prev *http.Response
+	var prevBody []byte
+	for _, template := range requests {
+		// Transcripts record the requests with their placeholders,
+		// because the substituted values can differ between runs.
+		lines := make([]string, len(template))
+		for i, line := range template {
+			lines[i] = transcriptPlaceholder.ReplaceAllStringFunc(line, func(p string) string {
+				return resolvePlaceholder(t, p[1:len(p)-1], prev, prevBody)
+			})
+		}
+		request := strings.SplitN(lines[0], " ", 2)
+		if len(request) != 2 {
+			t.Fatalf("%s: malformed request line %q", file, lines[0])
+		}
+		req, err := http.NewRequest(request[0], server.URL+request[1], nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, h := range lines[1:] {
+			kv := strings.SplitN(h, ":", 2)
+			if len(kv) != 2 {
+				t.Fatalf("%s: malformed header %q", file, h)
+			}
+			req.Header.Set(strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1]))
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		writeTranscriptEntry(&transcript, template, resp, body)
+		prev, prevBody = resp, body
+	}
+
+	golden := strings.TrimSuffix(file, ".txt") + ".golden"
+	if *updateGolden {
+		if err := ioutil.WriteFile(golden, transcript.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	expected, err := ioutil.ReadFile(golden)
+	if err != nil {
+		t.Fatalf("%v; run go test -run TestTranscripts -update to create it", err)
+	}
+	if !bytes.Equal(transcript.Bytes(), expected) {
+		t.Errorf("transcript differs from %s; if this is intended, run go test -run TestTranscripts -update and review the diff\n%s",
+			golden, firstDifference(expected, transcript.Bytes()))
+	}
+}
+
+// readTranscriptRequests reads the requests of a transcript, each as
+// its request line followed by its header lines.
+func readTranscriptRequests(file string) ([][]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var requests [][]string
+	var current []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case strings.HasPrefix(line, "#"):
+		case len(line) == 0:
+			if len(current) > 0 {
+				requests = append(requests, current)
+				current = nil
+			}
+		default:
+			current = append(current, line)
+		}
+	}
+	if len(current) > 0 {
+		requests = append(requests, current)
+	}
+	return requests, scanner.Err()
+}
+
+func resolvePlaceholder(t *testing.T, name string, prev *http.Response, prevBody []byte) string {
+	if prev == nil {
+		t.Fatalf("{%s} used in first request", name)
+	}
+	if name != "next" {
+		value := prev.Header.Get(name)
+		if len(value) == 0 {
+			t.Fatalf("{%s}: previous response has no such header", name)
+		}
+		return value
+	}
+	var page struct {
+		Links []WFSLink `json:"links"`
+	}
+	json.Unmarshal(prevBody, &page)
+	for _, link := range page.Links {
+		if link.Rel == "next" {
+			return strings.TrimPrefix(link.Href, "https://test.example.org/wfs")
+		}
+	}
+	t.Fatal("{next}: previous response has no next link")
+	return ""
+}
+
+// writeTranscriptEntry appends a request and its response to a
+// transcript. JSON bodies get indented, so diffs of golden files are
+// readable; other bodies that are not text get summarized by their
+// size and hash.
+func writeTranscriptEntry(w *bytes.Buffer, request []string, resp *http.Response, body []byte) {
+	for _, line := range request {
+		fmt.Fprintf(w, "> %s\n", line)
+	}
+	fmt.Fprintf(w, "< %d\n", resp.StatusCode)
+	keys := make([]string, 0, len(resp.Header))
+	for key := range resp.Header {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		for _, value := range resp.Header[key] {
+			if maskedHeaders[key] {
+				value = "*"
+			}
+			fmt.Fprintf(w, "< %s: %s\n", key, value)
+		}
+	}
+	w.WriteString("<\n")
+
+	// Compressed bodies get recorded as decompressed, so transcripts
+	// tell what clients see.
+	encoding := resp.Header.Get("Content-Encoding")
+	if encoding == "gzip" && len(body) > 0 {
+		if r, err := gzip.NewReader(bytes.NewReader(body)); err == nil {
+			if decompressed, err := ioutil.ReadAll(r); err == nil {
+				body, encoding = decompressed, ""
+			}
+		}
+	}
+
+	contentType := resp.Header.Get("Content-Type")
+	var indented bytes.Buffer
+	switch {
+	case len(body) == 0:
+	case encoding == "" && strings.Contains(contentType, "json") &&
+		json.Indent(&indented, body, "", "  ") == nil:
+		w.Write(indented.Bytes())
+		w.WriteString("\n")
+	case encoding == "" && strings.HasPrefix(contentType, "text/"):
+		w.Write(body)
+		if body[len(body)-1] != '\n' {
+			w.WriteString("\n")
+		}
+	default:
+		fmt.Fprintf(w, "[%d bytes, sha256 %x]\n", len(body), sha256.Sum256(body))
+	}
+	w.WriteString("\n")
+}
+
+// firstDifference describes where two transcripts start to differ.
+func firstDifference(expected, got []byte) string {
+	e := strings.Split(string(expected), "\n")
+	g := strings.Split(string(got), "\n")
+	for i := 0; i < len(e) || i < len(g); i++ {
+		var el, gl string
+		if i < len(e) {
+			el = e[i]
+		}
+		if i < len(g) {
+			gl = g[i]
+		}
+		if el != gl {
+			return fmt.Sprintf("line %d:\n  expected: %s\n  got:      %s", i+1, el, gl)
+		}
+	}
+	return ""
+}
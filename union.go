@@ -0,0 +1,413 @@
+package main
+
+import (
+	"math"
+	"sort"
+)
+
+// This file implements the union of planar polygons, for the /process
+// endpoint. The algorithm overlays all polygon edges: it splits edges
+// where they intersect, drops the pieces lying inside another polygon
+// or between two adjacent polygons, and chains the remaining pieces
+// into rings. Because the inputs are small, we keep it simple rather
+// than implementing a full sweep-line overlay.
+
+type planarPoint struct{ X, Y float64 }
+
+// planarRing is a ring without the repeated closing vertex. Shells
+// run counterclockwise, holes clockwise.
+type planarRing []planarPoint
+
+// planarPolygon is a shell followed by its holes.
+type planarPolygon []planarRing
+
+func (r planarRing) signedArea() float64 {
+	var a float64
+	for i, p := range r {
+		q := r[(i+1)%len(r)]
+		a += p.X*q.Y - q.X*p.Y
+	}
+	return a / 2
+}
+
+func (r planarRing) reversed() planarRing {
+	result := make(planarRing, len(r))
+	for i, p := range r {
+		result[len(r)-1-i] = p
+	}
+	return result
+}
+
+// oriented returns the polygon with a counterclockwise shell and
+// clockwise holes, dropping degenerate rings.
+func (poly planarPolygon) oriented() planarPolygon {
+	result := make(planarPolygon, 0, len(poly))
+	for i, ring := range poly {
+		area := ring.signedArea()
+		if len(ring) < 3 || area == 0 {
+			if i == 0 {
+				return nil
+			}
+			continue
+		}
+		if (i == 0) != (area > 0) {
+			ring = ring.reversed()
+		}
+		result = append(result, ring)
+	}
+	return result
+}
+
+type planarBounds struct{ MinX, MinY, MaxX, MaxY float64 }
+
+func emptyPlanarBounds() planarBounds {
+	return planarBounds{math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)}
+}
+
+func (b *planarBounds) add(p planarPoint) {
+	b.MinX, b.MinY = math.Min(b.MinX, p.X), math.Min(b.MinY, p.Y)
+	b.MaxX, b.MaxY = math.Max(b.MaxX, p.X), math.Max(b.MaxY, p.Y)
+}
+
+func (b planarBounds) contains(p planarPoint, tolerance float64) bool {
+	return p.X >= b.MinX-tolerance && p.X <= b.MaxX+tolerance &&
+		p.Y >= b.MinY-tolerance && p.Y <= b.MaxY+tolerance
+}
+
+// overlayEdge is a directed edge of an input polygon, whose interior
+// lies to its left.
+type overlayEdge struct {
+	a, b    planarPoint
+	polygon int
+	splits  []planarPoint
+	bounds  planarBounds
+}
+
+// unionPolygons returns the union of polygons, as a list of polygons
+// whose shells are disjoint.
+func unionPolygons(polygons []planarPolygon) []planarPolygon {
+	oriented := make([]planarPolygon, 0, len(polygons))
+	extent := emptyPlanarBounds()
+	for _, poly := range polygons {
+		if poly = poly.oriented(); len(poly) > 0 {
+			oriented = append(oriented, poly)
+			for _, p := range poly[0] {
+				extent.add(p)
+			}
+		}
+	}
+	if len(oriented) == 0 {
+		return nil
+	}
+
+	// Points closer than the snapping grid get merged, which hides
+	// the rounding errors of computed intersection points.
+	size := math.Max(extent.MaxX-extent.MinX, extent.MaxY-extent.MinY)
+	if size == 0 {
+		size = 1
+	}
+	grid := size * 1e-9
+	snap := func(p planarPoint) planarPoint {
+		return planarPoint{math.Round(p.X/grid) * grid, math.Round(p.Y/grid) * grid}
+	}
+
+	var edges []*overlayEdge
+	bounds := make([]planarBounds, len(oriented))
+	for k, poly := range oriented {
+		bounds[k] = emptyPlanarBounds()
+		for _, ring := range poly {
+			for i, p := range ring {
+				e := &overlayEdge{a: snap(p), b: snap(ring[(i+1)%len(ring)]), polygon: k}
+				if e.a == e.b {
+					continue
+				}
+				e.bounds = emptyPlanarBounds()
+				e.bounds.add(e.a)
+				e.bounds.add(e.b)
+				bounds[k].add(e.a)
+				edges = append(edges, e)
+			}
+		}
+	}
+
+	splitEdges(edges, grid)
+
+	// Split edges into pieces between consecutive intersections.
+	type piece struct {
+		a, b    planarPoint
+		polygon int
+	}
+	var pieces []piece
+	for _, e := range edges {
+		points := append([]planarPoint{e.a}, e.splits...)
+		dx, dy := e.b.X-e.a.X, e.b.Y-e.a.Y
+		sort.Slice(points, func(i, j int) bool {
+			return (points[i].X-e.a.X)*dx+(points[i].Y-e.a.Y)*dy < (points[j].X-e.a.X)*dx+(points[j].Y-e.a.Y)*dy
+		})
+		points = append(points, e.b)
+		for i := 1; i < len(points); i++ {
+			a, b := snap(points[i-1]), snap(points[i])
+			if a != b {
+				pieces = append(pieces, piece{a, b, e.polygon})
+			}
+		}
+	}
+
+	// Drop pieces inside other polygons. Pieces on the boundary of
+	// another polygon are shared with it; we keep one of them if both
+	// polygons lie on the same side, and none if they lie on opposite
+	// sides, since the piece is then inside the union.
+	grid2 := makePolygonGrid(bounds)
+	tolerance := grid * 4
+	type pieceKey struct{ a, b planarPoint }
+	kept := make(map[pieceKey]bool)
+	for _, p := range pieces {
+		mid := planarPoint{(p.a.X + p.b.X) / 2, (p.a.Y + p.b.Y) / 2}
+		inside := false
+		for _, k := range grid2.candidates(mid) {
+			if k != p.polygon && bounds[k].contains(mid, tolerance) &&
+				pointInPolygon(mid, oriented[k], tolerance) > 0 {
+				inside = true
+				break
+			}
+		}
+		if !inside {
+			kept[pieceKey{p.a, p.b}] = true
+		}
+	}
+	out := make(map[planarPoint][]planarPoint)
+	for key := range kept {
+		if kept[pieceKey{key.b, key.a}] {
+			continue
+		}
+		out[key.a] = append(out[key.a], key.b)
+	}
+
+	return assembleRings(out)
+}
+
+// splitEdges records where edges intersect each other.
+func splitEdges(edges []*overlayEdge, grid float64) {
+	sort.Slice(edges, func(i, j int) bool { return edges[i].bounds.MinX < edges[j].bounds.MinX })
+	for i, e := range edges {
+		for j := i + 1; j < len(edges) && edges[j].bounds.MinX <= e.bounds.MaxX+grid; j++ {
+			f := edges[j]
+			if f.bounds.MinY > e.bounds.MaxY+grid || f.bounds.MaxY < e.bounds.MinY-grid {
+				continue
+			}
+			intersectEdges(e, f, grid)
+		}
+	}
+}
+
+func intersectEdges(e, f *overlayEdge, grid float64) {
+	r := planarPoint{e.b.X - e.a.X, e.b.Y - e.a.Y}
+	s := planarPoint{f.b.X - f.a.X, f.b.Y - f.a.Y}
+	ca := planarPoint{f.a.X - e.a.X, f.a.Y - e.a.Y}
+	denom := r.X*s.Y - r.Y*s.X
+	lenR, lenS := math.Hypot(r.X, r.Y), math.Hypot(s.X, s.Y)
+
+	if math.Abs(denom) > 1e-12*lenR*lenS {
+		t := (ca.X*s.Y - ca.Y*s.X) / denom
+		u := (ca.X*r.Y - ca.Y*r.X) / denom
+		et, eu := grid/lenR, grid/lenS
+		if t < -et || t > 1+et || u < -eu || u > 1+eu {
+			return
+		}
+		p := planarPoint{e.a.X + t*r.X, e.a.Y + t*r.Y}
+		if t > et && t < 1-et {
+			e.splits = append(e.splits, p)
+		}
+		if u > eu && u < 1-eu {
+			f.splits = append(f.splits, p)
+		}
+		return
+	}
+
+	// Parallel edges only split each other if they are collinear.
+	if math.Abs(ca.X*r.Y-ca.Y*r.X) > grid*lenR {
+		return
+	}
+	addCollinearSplit(e, f.a, grid)
+	addCollinearSplit(e, f.b, grid)
+	addCollinearSplit(f, e.a, grid)
+	addCollinearSplit(f, e.b, grid)
+}
+
+// addCollinearSplit splits an edge at a point on its line, if the
+// point lies strictly between the edge's endpoints.
+func addCollinearSplit(e *overlayEdge, p planarPoint, grid float64) {
+	dx, dy := e.b.X-e.a.X, e.b.Y-e.a.Y
+	length2 := dx*dx + dy*dy
+	t := ((p.X-e.a.X)*dx + (p.Y-e.a.Y)*dy) / length2
+	if eps := grid / math.Sqrt(length2); t > eps && t < 1-eps {
+		e.splits = append(e.splits, p)
+	}
+}
+
+// pointInPolygon returns 1 if p is inside a polygon, 0 if it is on
+// its boundary, and -1 if it is outside.
+func pointInPolygon(p planarPoint, poly planarPolygon, tolerance float64) int {
+	inside := false
+	for _, ring := range poly {
+		for i, a := range ring {
+			b := ring[(i+1)%len(ring)]
+			if distanceToSegment(p, a, b) <= tolerance {
+				return 0
+			}
+			if (a.Y > p.Y) != (b.Y > p.Y) && p.X < (b.X-a.X)*(p.Y-a.Y)/(b.Y-a.Y)+a.X {
+				inside = !inside
+			}
+		}
+	}
+	if inside {
+		return 1
+	}
+	return -1
+}
+
+func distanceToSegment(p, a, b planarPoint) float64 {
+	dx, dy := b.X-a.X, b.Y-a.Y
+	t := 0.0
+	if length2 := dx*dx + dy*dy; length2 > 0 {
+		t = math.Max(0, math.Min(1, ((p.X-a.X)*dx+(p.Y-a.Y)*dy)/length2))
+	}
+	return math.Hypot(p.X-(a.X+t*dx), p.Y-(a.Y+t*dy))
+}
+
+// polygonGrid finds the polygons whose bounds might contain a point.
+type polygonGrid struct {
+	extent     planarBounds
+	cellSize   float64
+	numColumns int
+	cells      map[int][]int
+}
+
+func makePolygonGrid(bounds []planarBounds) *polygonGrid {
+	g := &polygonGrid{extent: emptyPlanarBounds(), cells: make(map[int][]int)}
+	for _, b := range bounds {
+		g.extent.add(planarPoint{b.MinX, b.MinY})
+		g.extent.add(planarPoint{b.MaxX, b.MaxY})
+	}
+	size := math.Max(g.extent.MaxX-g.extent.MinX, g.extent.MaxY-g.extent.MinY)
+	g.numColumns = int(math.Ceil(math.Sqrt(float64(len(bounds))))) + 1
+	g.cellSize = size / float64(g.numColumns-1)
+	if g.cellSize == 0 {
+		g.cellSize = 1
+	}
+	for k, b := range bounds {
+		x0, y0 := g.cell(planarPoint{b.MinX, b.MinY})
+		x1, y1 := g.cell(planarPoint{b.MaxX, b.MaxY})
+		for x := x0; x <= x1; x++ {
+			for y := y0; y <= y1; y++ {
+				g.cells[y*g.numColumns+x] = append(g.cells[y*g.numColumns+x], k)
+			}
+		}
+	}
+	return g
+}
+
+func (g *polygonGrid) cell(p planarPoint) (int, int) {
+	clamp := func(v float64) int {
+		return int(math.Max(0, math.Min(float64(g.numColumns-1), v)))
+	}
+	return clamp((p.X - g.extent.MinX) / g.cellSize), clamp((p.Y - g.extent.MinY) / g.cellSize)
+}
+
+func (g *polygonGrid) candidates(p planarPoint) []int {
+	x, y := g.cell(p)
+	return g.cells[y*g.numColumns+x]
+}
+
+// assembleRings chains directed edges into rings, and groups them into
+// polygons. Where several rings touch at a vertex, we take the sharpest
+// left turn, which keeps touching rings apart.
+func assembleRings(out map[planarPoint][]planarPoint) []planarPolygon {
+	starts := make([]planarPoint, 0, len(out))
+	for p := range out {
+		starts = append(starts, p)
+	}
+	sort.Slice(starts, func(i, j int) bool {
+		if starts[i].X != starts[j].X {
+			return starts[i].X < starts[j].X
+		}
+		return starts[i].Y < starts[j].Y
+	})
+
+	var shells, holes []planarRing
+	for _, start := range starts {
+		for len(out[start]) > 0 {
+			ring := planarRing{start}
+			prev, cur := start, takeNext(out, start, planarPoint{start.X - 1, start.Y})
+			for cur != start {
+				ring = append(ring, cur)
+				if len(ring) > 4*len(out)+4 || len(out[cur]) == 0 {
+					ring = nil // broken chain, caused by numerical trouble
+					break
+				}
+				prev, cur = cur, takeNext(out, cur, prev)
+			}
+			if len(ring) < 3 {
+				continue
+			}
+			if area := ring.signedArea(); area > 0 {
+				shells = append(shells, ring)
+			} else if area < 0 {
+				holes = append(holes, ring)
+			}
+		}
+	}
+
+	result := make([]planarPolygon, len(shells))
+	for i, shell := range shells {
+		result[i] = planarPolygon{shell}
+	}
+	for _, hole := range holes {
+		best, bestArea := -1, math.Inf(1)
+		for i, shell := range shells {
+			area := shell.signedArea()
+			if area < bestArea && ringInsideShell(hole, shell) {
+				best, bestArea = i, area
+			}
+		}
+		if best >= 0 {
+			result[best] = append(result[best], hole)
+		}
+	}
+	return result
+}
+
+// takeNext removes and returns the edge leaving p that turns most to
+// the left, coming from prev.
+func takeNext(out map[planarPoint][]planarPoint, p planarPoint, prev planarPoint) planarPoint {
+	candidates := out[p]
+	back := math.Atan2(prev.Y-p.Y, prev.X-p.X)
+	best, bestAngle := 0, -1.0
+	for i, c := range candidates {
+		// Going back where we came from is the least preferred turn.
+		angle := math.Atan2(c.Y-p.Y, c.X-p.X) - back
+		for angle < 0 {
+			angle += 2 * math.Pi
+		}
+		if angle > bestAngle {
+			best, bestAngle = i, angle
+		}
+	}
+	next := candidates[best]
+	out[p] = append(candidates[:best], candidates[best+1:]...)
+	return next
+}
+
+// ringInsideShell returns true if a hole lies inside a shell. Holes
+// may touch their shell, so we test several vertices.
+func ringInsideShell(hole, shell planarRing) bool {
+	for _, p := range hole {
+		switch pointInPolygon(p, planarPolygon{shell}, 0) {
+		case 1:
+			return true
+		case -1:
+			return false
+		}
+	}
+	return false
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// MaxUploadSize limits the size of uploaded collection data, in bytes
+// after decompression.
+const MaxUploadSize = 256 * 1024 * 1024
+
+var NotLocal error = errors.New("FeatureCollection source is not a local file")
+var UploadTooLarge error = errors.New("upload exceeds maximal size")
+
+// InvalidUpload wraps the reason why uploaded data was rejected.
+type InvalidUpload struct {
+	Err error
+}
+
+func (e *InvalidUpload) Error() string {
+	return "invalid upload: " + e.Err.Error()
+}
+
+// UploadCollection replaces the data of a collection by the content
+// read from r, which may be gzip-compressed. The data gets validated
+// by loading it, then swapped in and atomically written to the source
+// path of the collection, so it also survives restarts. Only local
+// sources can be replaced this way.
+func (index *Index) UploadCollection(collection string, r io.Reader) (CollectionMetadata, error) {
+	path, err := index.getUploadPath(collection)
+	if err != nil {
+		return CollectionMetadata{}, err
+	}
+
+	tmp, err := createUploadFile(path)
+	if err != nil {
+		return CollectionMetadata{}, err
+	}
+	defer os.Remove(tmp.Name()) // fails harmlessly after the rename
+	if err := copyUpload(tmp, r, MaxUploadSize); err != nil {
+		tmp.Close()
+		return CollectionMetadata{}, err
+	}
+	if err := tmp.Close(); err != nil {
+		return CollectionMetadata{}, err
+	}
+	return index.installUpload(collection, path, tmp.Name())
+}
+
+// getUploadPath returns the source path of a collection that can
+// receive uploads.
+func (index *Index) getUploadPath(collection string) (string, error) {
+	index.mutex.RLock()
+	defer index.mutex.RUnlock()
+	coll := index.Collections[collection]
+	if coll == nil {
+		return "", NotFound
+	}
+	if !isLocalSource(coll.metadata.Path) || isMultiFileSource(coll.metadata.Path) {
+		return "", NotLocal
+	}
+	return coll.metadata.Path, nil
+}
+
+// createUploadFile creates a temporary file next to a collection
+// source, so the final rename stays within the same file system and
+// is atomic. The file keeps the extension of the source, so it gets
+// read with the same loader.
+func createUploadFile(path string) (*os.File, error) {
+	return ioutil.TempFile(filepath.Dir(path), ".upload-*"+filepath.Ext(path))
+}
+
+// installUpload validates uploaded data by loading it, swaps it into
+// the index, and moves it over the collection source.
+func (index *Index) installUpload(collection string, path string, uploaded string) (CollectionMetadata, error) {
+	// Concurrent uploads could otherwise swap in one version while
+	// writing the other to disk.
+	index.uploadMutex.Lock()
+	defer index.uploadMutex.Unlock()
+	return index.installUploadLocked(collection, path, uploaded)
+}
+
+// installUploadLocked is like installUpload, for callers that already
+// hold uploadMutex.
+func (index *Index) installUploadLocked(collection string, path string, uploaded string) (CollectionMetadata, error) {
+	var t0 time.Time
+	coll, err := readMigratedCollection(collection, uploaded, t0, index.getLoadOptions(collection))
+	if err != nil {
+		return CollectionMetadata{}, &InvalidUpload{err}
+	}
+	coll.metadata.Path = path
+	if err := index.replaceCollection(coll); err != nil {
+		return CollectionMetadata{}, err
+	}
+
+	// Renaming keeps the modification time, so the file watcher will
+	// see the source as unmodified and not reload it again.
+	if err := os.Rename(uploaded, path); err != nil {
+		loaderLog.Error("collection has been replaced, but writing its source failed", "collection", collection, "path", path, "error", err)
+		return CollectionMetadata{}, err
+	}
+	return coll.metadata, nil
+}
+
+// copyUpload copies at most maxSize bytes of uploaded data to a file,
+// decompressing it if it starts with the gzip magic number.
+func copyUpload(w io.Writer, r io.Reader, maxSize int64) error {
+	buffered := bufio.NewReader(r)
+	var in io.Reader = buffered
+	if magic, _ := buffered.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(buffered)
+		if err != nil {
+			return &InvalidUpload{err}
+		}
+		defer gz.Close()
+		in = gz
+	}
+
+	n, err := io.Copy(w, io.LimitReader(in, maxSize+1))
+	if err != nil {
+		if err == gzip.ErrChecksum || err == gzip.ErrHeader || err == io.ErrUnexpectedEOF {
+			return &InvalidUpload{err}
+		}
+		return err
+	}
+	if n > maxSize {
+		return UploadTooLarge
+	}
+	return nil
+}
+
+func (s *WebServer) handleUploadRequest(w http.ResponseWriter, req *http.Request, collection string) {
+	if req.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	md, err := s.index.UploadCollection(collection, req.Body)
+	if err != nil {
+		writeUploadError(w, collection, err)
+		return
+	}
+	writeUploadResult(w, collection, md)
+}
+
+func writeUploadError(w http.ResponseWriter, collection string, err error) {
+	status := http.StatusInternalServerError
+	switch err.(type) {
+	case *InvalidUpload:
+		status = http.StatusBadRequest
+	default:
+		switch err {
+		case NotFound:
+			status = http.StatusNotFound
+		case NotLocal:
+			status = http.StatusConflict
+		case UploadTooLarge:
+			status = http.StatusRequestEntityTooLarge
+		case MemoryExceeded:
+			status = http.StatusInsufficientStorage
+		}
+	}
+	httpLog.Warn("upload to collection failed", "collection", collection, "error", err)
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(status)
+	io.WriteString(w, err.Error()+"\n")
+}
+
+func writeUploadResult(w http.ResponseWriter, collection string, md CollectionMetadata) {
+	encoded, err := json.Marshal(map[string]interface{}{
+		"collection":   collection,
+		"version":      md.Version,
+		"lastModified": md.LastModified.UTC(),
+	})
+	if err != nil {
+		httpLog.Error("json.Marshal failed", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(encoded)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(encoded)
+}
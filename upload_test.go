@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// adminAPIKey is accepted by the servers of makeUploadServer for
+// changing data.
+const adminAPIKey = "admin-api-key"
+
+// makeUploadServer returns an admin server whose "lakes" collection
+// is read from a temporary copy of our test data.
+func makeUploadServer(t *testing.T) (*Index, *WebServer, string, func()) {
+	dir, err := ioutil.TempDir("", "miniwfs-upload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(filepath.Join("testdata", "lakes.geojson"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "lakes.geojson")
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	publicPath, _ := url.Parse("https://test.example.org/wfs/")
+	index, err := MakeIndex(map[string]string{"lakes": path}, publicPath, IndexOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := MakeWebServer(index)
+	s.admin = true
+	s.access = MakeAccessControl([]string{adminAPIKey}, nil, nil)
+	return index, s, path, func() { index.Close(); os.RemoveAll(dir) }
+}
+
+func upload(s *WebServer, path string, body []byte) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("POST", path, bytes.NewReader(body))
+	req.Header.Set("X-API-Key", adminAPIKey)
+	resp := httptest.NewRecorder()
+	http.HandlerFunc(s.HandleRequest).ServeHTTP(resp, req)
+	return resp
+}
+
+func TestUpload(t *testing.T) {
+	index, s, path, cleanup := makeUploadServer(t)
+	defer cleanup()
+
+	content := []byte(`{"type":"FeatureCollection","features":[
+		{"type":"Feature","id":"N7","properties":{"name":"Greifensee"},"geometry":null}]}`)
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	gz.Write(content)
+	gz.Close()
+
+	resp := upload(s, "/admin/collections/lakes/upload", compressed.Bytes())
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", resp.Code, getBody(resp))
+	}
+	if item, _ := index.GetItem("lakes", "N7"); item == nil || item.Properties["name"] != "Greifensee" {
+		t.Errorf("expected uploaded feature to be served, got %v", item)
+	}
+	if written, _ := ioutil.ReadFile(path); !bytes.Equal(written, content) {
+		t.Errorf("expected upload to be written to %s, got %s", path, written)
+	}
+	if matches, _ := filepath.Glob(filepath.Join(filepath.Dir(path), ".upload-*")); len(matches) != 0 {
+		t.Errorf("expected temporary files to be removed, got %v", matches)
+	}
+}
+
+func TestUpload_Invalid(t *testing.T) {
+	index, s, path, cleanup := makeUploadServer(t)
+	defer cleanup()
+	before, _ := ioutil.ReadFile(path)
+
+	resp := upload(s, "/admin/collections/lakes/upload", []byte(`"not a FeatureCollection"`))
+	if resp.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400 for invalid data, got %d", resp.Code)
+	}
+	if after, _ := ioutil.ReadFile(path); !bytes.Equal(before, after) {
+		t.Error("expected source to be unchanged after invalid upload")
+	}
+	if item, _ := index.GetItem("lakes", "N123"); item == nil {
+		t.Error("expected previous data to be kept after invalid upload")
+	}
+
+	resp = upload(s, "/admin/collections/nosuchcollection/upload", before)
+	if resp.Code != http.StatusNotFound {
+		t.Errorf("expected status 404 for unknown collection, got %d", resp.Code)
+	}
+
+	req, _ := http.NewRequest("GET", "/admin/collections/lakes/upload", nil)
+	req.Header.Set("X-API-Key", adminAPIKey)
+	resp = httptest.NewRecorder()
+	http.HandlerFunc(s.HandleRequest).ServeHTTP(resp, req)
+	if resp.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected status 405 for GET, got %d", resp.Code)
+	}
+}
+
+// Rejected uploads and edits, and finished upload sessions, remove
+// their temporary files next to the source. This must not stop
+// watching the source for changes.
+func TestUpload_KeepsWatchingSource(t *testing.T) {
+	index, s, path, cleanup := makeUploadServer(t)
+	defer cleanup()
+
+	if resp := upload(s, "/admin/collections/lakes/upload", []byte("not json")); resp.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400 for invalid upload, got %d", resp.Code)
+	}
+	index.SetMaxMemory(1)
+	if resp := edit(s, "PATCH", "/collections/lakes/items/N123", "application/merge-patch+json", `{"properties":{"depth":8}}`); resp.Code != http.StatusInsufficientStorage {
+		t.Fatalf("expected status 507 for edit exceeding --maxMemory, got %d", resp.Code)
+	}
+	index.SetMaxMemory(0)
+	loc := startUpload(t, s)
+	if resp := uploadRequest(s, "DELETE", loc, nil, nil); resp.Code != http.StatusNoContent {
+		t.Fatalf("expected status 204 for aborting upload, got %d", resp.Code)
+	}
+
+	content := []byte(`{"type":"FeatureCollection","features":[
+		{"type":"Feature","id":"N8","properties":{},"geometry":null}]}`)
+	if err := ioutil.WriteFile(path, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	future := time.Now().Add(time.Hour)
+	os.Chtimes(path, future, future)
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(10 * time.Millisecond) {
+		if item, _ := index.GetItem("lakes", "N8"); item != nil {
+			return
+		}
+	}
+	t.Error("expected change of source to be loaded")
+}
+
+func TestUpload_NotAdmin(t *testing.T) {
+	_, s, _, cleanup := makeUploadServer(t)
+	defer cleanup()
+	s.admin = false
+	resp := upload(s, "/admin/collections/lakes/upload", []byte(`{"features":[]}`))
+	if resp.Code != http.StatusNotFound {
+		t.Errorf("expected status 404 on public port, got %d", resp.Code)
+	}
+}
+
+func TestUpload_APIKey(t *testing.T) {
+	index, s, path, cleanup := makeUploadServer(t)
+	defer cleanup()
+	before, _ := ioutil.ReadFile(path)
+
+	for _, tc := range []struct {
+		header, value string
+		expected      int
+	}{
+		{"", "", http.StatusUnauthorized},
+		{"X-API-Key", "wrong-key", http.StatusForbidden},
+		{"Authorization", "Bearer wrong-key", http.StatusForbidden},
+	} {
+		req, _ := http.NewRequest("POST", "/admin/collections/lakes/upload",
+			bytes.NewReader([]byte(`{"type":"FeatureCollection","features":[]}`)))
+		if len(tc.header) > 0 {
+			req.Header.Set(tc.header, tc.value)
+		}
+		resp := httptest.NewRecorder()
+		http.HandlerFunc(s.HandleRequest).ServeHTTP(resp, req)
+		if resp.Code != tc.expected {
+			t.Errorf("%s: %s: expected status %d, got %d", tc.header, tc.value, tc.expected, resp.Code)
+		}
+	}
+	if after, _ := ioutil.ReadFile(path); !bytes.Equal(before, after) {
+		t.Error("expected source to be unchanged after unauthorized uploads")
+	}
+	if item, _ := index.GetItem("lakes", "N123"); item == nil {
+		t.Error("expected previous data to be kept after unauthorized uploads")
+	}
+}
+
+type spaceReader struct{}
+
+func (spaceReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = ' '
+	}
+	return len(p), nil
+}
+
+func TestCopyUpload_TooLarge(t *testing.T) {
+	in := io.LimitReader(spaceReader{}, MaxUploadSize+1)
+	if err := copyUpload(ioutil.Discard, in, MaxUploadSize); err != UploadTooLarge {
+		t.Errorf("expected UploadTooLarge, got %v", err)
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/golang/geo/s2"
+)
+
+// UsageRecorder keeps anonymous rollups of served requests, counted
+// per collection, per output format and per bbox size. No client
+// identifiers are recorded. Counts are kept in fixed-size time buckets
+// that get recycled, so memory use stays bounded.
+type UsageRecorder struct {
+	mutex   sync.Mutex
+	buckets []usageBucket
+}
+
+type usageBucket struct {
+	start  int64                       // Unix time of the bucket start
+	counts map[string]map[string]int64 // dimension -> value -> count
+}
+
+// UsageWindow is a rollup of the requests in a sliding time window.
+type UsageWindow struct {
+	Window      string           `json:"window"`
+	Requests    int64            `json:"requests"`
+	Collections map[string]int64 `json:"collections"`
+	Formats     map[string]int64 `json:"formats"`
+	BboxSizes   map[string]int64 `json:"bboxSizes"`
+}
+
+const usageBucketDuration = 5 * time.Minute
+
+// maxUsageZoom is the zoom level for the smallest bbox size bucket.
+const maxUsageZoom = 22
+
+var usageWindows = []struct {
+	name     string
+	duration time.Duration
+}{
+	{"1h", time.Hour},
+	{"24h", 24 * time.Hour},
+	{"7d", 7 * 24 * time.Hour},
+}
+
+func MakeUsageRecorder() *UsageRecorder {
+	longest := usageWindows[len(usageWindows)-1].duration
+	return &UsageRecorder{buckets: make([]usageBucket, longest/usageBucketDuration)}
+}
+
+// Record counts a request. The bbox size is a bucket name as returned
+// by bboxSizeBucket or tileSizeBucket.
+func (u *UsageRecorder) Record(now time.Time, collection string, format string, bboxSize string) {
+	if u == nil {
+		return
+	}
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	start := now.Truncate(usageBucketDuration).Unix()
+	b := &u.buckets[(start/int64(usageBucketDuration.Seconds()))%int64(len(u.buckets))]
+	if b.start != start || b.counts == nil {
+		b.start = start
+		b.counts = make(map[string]map[string]int64)
+	}
+	for dim, value := range map[string]string{"": "", "collection": collection, "format": format, "bboxSize": bboxSize} {
+		if b.counts[dim] == nil {
+			b.counts[dim] = make(map[string]int64)
+		}
+		b.counts[dim][value]++
+	}
+}
+
+// Rollup sums up the recorded requests for each sliding window.
+func (u *UsageRecorder) Rollup(now time.Time) []UsageWindow {
+	result := make([]UsageWindow, len(usageWindows))
+	for i, w := range usageWindows {
+		result[i] = UsageWindow{
+			Window:      w.name,
+			Collections: make(map[string]int64),
+			Formats:     make(map[string]int64),
+			BboxSizes:   make(map[string]int64),
+		}
+	}
+	if u == nil {
+		return result
+	}
+
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	current := now.Truncate(usageBucketDuration).Unix()
+	for _, b := range u.buckets {
+		if b.counts == nil || b.start > current {
+			continue
+		}
+		age := time.Duration(current-b.start) * time.Second
+		for i, w := range usageWindows {
+			if age >= w.duration {
+				continue
+			}
+			result[i].Requests += b.counts[""][""]
+			addCounts(result[i].Collections, b.counts["collection"])
+			addCounts(result[i].Formats, b.counts["format"])
+			addCounts(result[i].BboxSizes, b.counts["bboxSize"])
+		}
+	}
+	return result
+}
+
+func addCounts(dst map[string]int64, src map[string]int64) {
+	for key, n := range src {
+		dst[key] += n
+	}
+}
+
+// bboxSizeBucket classifies a bbox query by the web map zoom level
+// whose tiles have about the same width, such as "z12". Queries
+// without bbox are counted as "none".
+func bboxSizeBucket(bbox s2.Rect) string {
+	if bbox.IsFull() {
+		return "none"
+	}
+	size := bbox.Size()
+	width := math.Max(size.Lng.Degrees(), size.Lat.Degrees())
+	if width <= 0 {
+		return tileSizeBucket(maxUsageZoom)
+	}
+	zoom := int(math.Floor(math.Log2(360.0 / width)))
+	if zoom < 0 {
+		zoom = 0
+	} else if zoom > maxUsageZoom {
+		zoom = maxUsageZoom
+	}
+	return tileSizeBucket(zoom)
+}
+
+func tileSizeBucket(zoom int) string {
+	return "z" + strconv.Itoa(zoom)
+}
+
+// handleUsageRequest shows the usage rollups. It is only served on
+// the admin listener.
+func (s *WebServer) handleUsageRequest(w http.ResponseWriter, req *http.Request) {
+	encoded, err := json.Marshal(struct {
+		Windows []UsageWindow `json:"windows"`
+	}{s.usage.Rollup(time.Now())})
+	if err != nil {
+		log.Printf("json.Marshal failed: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(encoded)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/golang/geo/s2"
+)
+
+func TestUsageRecorder(t *testing.T) {
+	u := MakeUsageRecorder()
+	now := time.Date(2019, 3, 28, 12, 0, 0, 0, time.UTC)
+	u.Record(now.Add(-8*24*time.Hour), "castles", "json", "none") // too old
+	u.Record(now.Add(-2*24*time.Hour), "castles", "json", "z3")
+	u.Record(now.Add(-3*time.Hour), "lakes", "gml", "none")
+	u.Record(now.Add(-10*time.Minute), "castles", "png", "z12")
+	u.Record(now, "castles", "json", "z12")
+
+	rollup := u.Rollup(now)
+	if len(rollup) != 3 {
+		t.Fatalf("expected 3 windows, got %d", len(rollup))
+	}
+	for i, e := range []UsageWindow{
+		{"1h", 2, map[string]int64{"castles": 2},
+			map[string]int64{"json": 1, "png": 1}, map[string]int64{"z12": 2}},
+		{"24h", 3, map[string]int64{"castles": 2, "lakes": 1},
+			map[string]int64{"gml": 1, "json": 1, "png": 1}, map[string]int64{"none": 1, "z12": 2}},
+		{"7d", 4, map[string]int64{"castles": 3, "lakes": 1},
+			map[string]int64{"gml": 1, "json": 2, "png": 1}, map[string]int64{"none": 1, "z12": 2, "z3": 1}},
+	} {
+		if !reflect.DeepEqual(rollup[i], e) {
+			t.Errorf("expected %+v, got %+v", e, rollup[i])
+		}
+	}
+}
+
+func TestBboxSizeBucket(t *testing.T) {
+	for _, e := range []struct {
+		bbox     string
+		expected string
+	}{
+		{"", "none"},
+		{"-80,-60,80,60", "z1"},
+		{"8.5,47.3,8.6,47.4", "z11"},
+		{"8.5,47.3,8.5,47.3", "z22"},
+	} {
+		bbox, err := parseBbox(e.bbox)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := bboxSizeBucket(bbox); got != e.expected {
+			t.Errorf("expected %s for bbox %q, got %s", e.expected, e.bbox, got)
+		}
+	}
+	if got := bboxSizeBucket(s2.FullRect()); got != "none" {
+		t.Errorf("expected none for full rect, got %s", got)
+	}
+}
+
+func TestUsage_AdminEndpoint(t *testing.T) {
+	index, s := makeServer(t)
+	defer s.Shutdown()
+	defer index.Close()
+	s.usage = MakeUsageRecorder()
+
+	query, _ := http.NewRequest("GET", "/collections/castles/items?bbox=8.5,47.3,8.6,47.4", nil)
+	http.HandlerFunc(s.HandleRequest).ServeHTTP(httptest.NewRecorder(), query)
+
+	query, _ = http.NewRequest("GET", "/admin/usage", nil)
+	resp := httptest.NewRecorder()
+	http.HandlerFunc(s.HandleRequest).ServeHTTP(resp, query)
+	if resp.Code != http.StatusNotFound {
+		t.Errorf("expected /admin/usage to be hidden on the public listener, got %d", resp.Code)
+	}
+
+	s.admin = true
+	resp = httptest.NewRecorder()
+	http.HandlerFunc(s.HandleRequest).ServeHTTP(resp, query)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", resp.Code)
+	}
+	expectJSON(t, getBody(resp), `{"windows": [
+	  {"window": "1h", "requests": 1, "collections": {"castles": 1}, "formats": {"json": 1}, "bboxSizes": {"z11": 1}},
+	  {"window": "24h", "requests": 1, "collections": {"castles": 1}, "formats": {"json": 1}, "bboxSizes": {"z11": 1}},
+	  {"window": "7d", "requests": 1, "collections": {"castles": 1}, "formats": {"json": 1}, "bboxSizes": {"z11": 1}}
+	]}`)
+}
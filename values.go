@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DefaultValuesLimit and MaxValuesLimit bound how many suggestions
+// the property values endpoint returns.
+const DefaultValuesLimit = 10
+const MaxValuesLimit = 100
+
+// PropertyValueCount tells how many features have a property value.
+type PropertyValueCount struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+// GetPropertyValues returns the most frequent values of a property
+// that start with prefix, for suggesting values in filter forms.
+// The prefix is compared ignoring case and diacritics, so "pa"
+// matches "Pähl". Values of equal frequency are sorted by value.
+func (index *Index) GetPropertyValues(collection string, property string, prefix string, limit int) ([]PropertyValueCount, CollectionMetadata, error) {
+	index.mutex.RLock()
+	defer index.mutex.RUnlock()
+
+	coll := index.Collections[collection]
+	if coll == nil {
+		return nil, CollectionMetadata{}, NotFound
+	}
+
+	if limit < 1 {
+		limit = 1
+	} else if limit > MaxValuesLimit {
+		limit = MaxValuesLimit
+	}
+
+	prefix = foldText(prefix)
+	result := make([]PropertyValueCount, 0, limit)
+	for value, features := range coll.properties[property] {
+		if strings.HasPrefix(foldText(value), prefix) {
+			result = append(result, PropertyValueCount{Value: value, Count: len(features)})
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Count != result[j].Count {
+			return result[i].Count > result[j].Count
+		}
+		return result[i].Value < result[j].Value
+	})
+	if len(result) > limit {
+		result = result[:limit]
+	}
+	return result, coll.metadata, nil
+}
+
+func (s *WebServer) handlePropertyValuesRequest(w http.ResponseWriter, req *http.Request, collection string, property string) {
+	if !s.authorize(w, req, collection) {
+		return
+	}
+	if len(s.negotiate(w, req, "json")) == 0 {
+		return
+	}
+
+	params := req.URL.Query()
+	limit := DefaultValuesLimit
+	if limitParam := strings.TrimSpace(params.Get("limit")); len(limitParam) > 0 {
+		var err error
+		if limit, err = strconv.Atoi(limitParam); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+
+	values, metadata, err := s.index.GetPropertyValues(collection, property, params.Get("q"), limit)
+	if err != nil {
+		w.WriteHeader(getHTTPStatus(err))
+		return
+	}
+
+	encoded, err := json.Marshal(struct {
+		Values []PropertyValueCount `json:"values"`
+	}{values})
+	if err != nil {
+		httpLog.Error("json.Marshal failed", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(encoded)))
+	w.Header().Set("Last-Modified", metadata.LastModified.UTC().Format(http.TimeFormat))
+	w.WriteHeader(http.StatusOK)
+	w.Write(encoded)
+}
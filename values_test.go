@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestPropertyValues(t *testing.T) {
+	index, s := makeServer(t)
+	defer s.Shutdown()
+	defer index.Close()
+
+	for path, expected := range map[string][]PropertyValueCount{
+		"/collections/castles/properties/historic/values":           {{"castle", 3}},
+		"/collections/castles/properties/name/values?q=ho":          {{"Hochschloß Pähl", 1}},
+		"/collections/castles/properties/name/values?q=HOCHSCHLOSS": {{"Hochschloß Pähl", 1}},
+		"/collections/castles/properties/name/values?q=x":           {},
+		"/collections/castles/properties/name/values?limit=2": {
+			{"Castello Scaligero", 1}, {"Hochschloß Pähl", 1}},
+		"/collections/castles/properties/nosuchproperty/values": {},
+	} {
+		req, _ := http.NewRequest("GET", path, nil)
+		resp := httptest.NewRecorder()
+		http.HandlerFunc(s.HandleRequest).ServeHTTP(resp, req)
+		if resp.Code != http.StatusOK {
+			t.Errorf("GET %s: expected status 200, got %d", path, resp.Code)
+			continue
+		}
+		var got struct {
+			Values []PropertyValueCount `json:"values"`
+		}
+		if err := json.Unmarshal(resp.Body.Bytes(), &got); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got.Values, expected) {
+			t.Errorf("GET %s: expected %v, got %v", path, expected, got.Values)
+		}
+	}
+
+	for path, expected := range map[string]int{
+		"/collections/nosuchcollection/properties/name/values": http.StatusNotFound,
+		"/collections/castles/properties/name/values?limit=x":  http.StatusBadRequest,
+	} {
+		req, _ := http.NewRequest("GET", path, nil)
+		resp := httptest.NewRecorder()
+		http.HandlerFunc(s.HandleRequest).ServeHTTP(resp, req)
+		if resp.Code != expected {
+			t.Errorf("GET %s: expected status %d, got %d", path, expected, resp.Code)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// warmUpMaxZoom is the highest zoom level whose tiles get rendered
+// when warming up. Higher zoom levels have too many tiles, and are
+// cheap to render on demand anyway.
+const warmUpMaxZoom = 6
+
+// StartWarmUp marks the index as not ready, and then fills its caches
+// in the background. Once done, the index becomes ready again, so
+// load balancers only send traffic after the expensive work is over.
+func (index *Index) StartWarmUp() {
+	atomic.StoreInt32(&index.warmingUp, 1)
+	go func() {
+		index.warmUp(warmUpMaxZoom)
+		atomic.StoreInt32(&index.warmingUp, 0)
+	}()
+}
+
+// IsReady returns false while the index is warming up.
+func (index *Index) IsReady() bool {
+	return atomic.LoadInt32(&index.warmingUp) == 0
+}
+
+// warmUp renders the preview and the tiles up to maxZoom of every
+// collection, which also computes the style categories of styled
+// collections. Geometries and extents are already computed at load
+// time. Only tiles containing features are rendered.
+func (index *Index) warmUp(maxZoom int) {
+	start := time.Now()
+	numTiles := 0
+	for _, md := range index.GetCollections() {
+		if _, _, err := index.GetPreview(md.Name); err != nil {
+			continue // collection has been removed meanwhile
+		}
+		_, points, _ := index.getExportData(md.Name)
+		for zoom := 0; zoom <= maxZoom; zoom++ {
+			for key := range getExportTiles(points, zoom) {
+				if _, _, err := index.GetTile(md.Name, key); err == nil {
+					numTiles++
+				}
+			}
+		}
+	}
+	loaderLog.Info("warm-up rendered tiles", "tiles", numTiles, "duration", time.Since(start))
+}
+
+func (s *WebServer) handleReadyRequest(w http.ResponseWriter, req *http.Request) {
+	msg, status := "ready\n", http.StatusOK
+	if !s.index.IsReady() {
+		msg, status = "warming up\n", http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Content-Length", strconv.Itoa(len(msg)))
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	io.WriteString(w, msg)
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestWarmUp(t *testing.T) {
+	index, s := makeServer(t)
+	defer s.Shutdown()
+	defer index.Close()
+
+	getReady := func() int {
+		req, _ := http.NewRequest("GET", "/readyz", nil)
+		resp := httptest.NewRecorder()
+		http.HandlerFunc(s.HandleRequest).ServeHTTP(resp, req)
+		return resp.Code
+	}
+
+	if code := getReady(); code != http.StatusOK {
+		t.Errorf("GET /readyz without warm-up: expected status 200, got %d", code)
+	}
+
+	atomic.StoreInt32(&index.warmingUp, 1)
+	if code := getReady(); code != http.StatusServiceUnavailable {
+		t.Errorf("GET /readyz while warming up: expected status 503, got %d", code)
+	}
+
+	index.warmUp(2)
+	atomic.StoreInt32(&index.warmingUp, 0)
+	if code := getReady(); code != http.StatusOK {
+		t.Errorf("GET /readyz after warm-up: expected status 200, got %d", code)
+	}
+
+	// The castle at 11.183468,47.910414 is in tile 2/2/1.
+	coll := index.Collections["castles"]
+	if coll.tileCache.Get(TileKey{Zoom: 2, X: 2, Y: 1}) == nil {
+		t.Error("expected tile 2/2/1 of castles to be cached after warm-up")
+	}
+	if len(coll.preview) == 0 {
+		t.Error("expected preview of castles to be rendered after warm-up")
+	}
+}
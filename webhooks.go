@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	numWebhookDeliveries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "miniwfs_webhook_deliveries_total",
+		Help: "Total number of webhook deliveries, by outcome.",
+	},
+		[]string{"outcome"})
+)
+
+// WebhookDelivery records the outcome of posting one change event to
+// one webhook URL, for display in the admin view.
+type WebhookDelivery struct {
+	URL        string    `json:"url"`
+	Collection string    `json:"collection"`
+	Version    string    `json:"version"`
+	Attempts   int       `json:"attempts"`
+	Status     int       `json:"status"`
+	Error      string    `json:"error,omitempty"`
+	Delivered  bool      `json:"delivered"`
+	Timestamp  time.Time `json:"timestamp"`
+}
+
+// WebhookNotifier posts change events to webhook URLs. Each payload is
+// signed with HMAC-SHA256 in the X-Miniwfs-Signature header, so that
+// receivers can verify it came from us. Failed deliveries are retried
+// with exponential backoff.
+type WebhookNotifier struct {
+	urls        []string
+	secret      []byte
+	client      *http.Client
+	maxAttempts int
+	backoff     time.Duration // delay before the first retry, doubled for each further retry
+
+	mutex      sync.Mutex
+	deliveries []*WebhookDelivery // most recent last
+}
+
+// MaxWebhookDeliveries is the number of deliveries kept for the admin view.
+const MaxWebhookDeliveries = 100
+
+func MakeWebhookNotifier(urls []string, secret []byte) *WebhookNotifier {
+	return &WebhookNotifier{
+		urls:        urls,
+		secret:      secret,
+		client:      &http.Client{Timeout: 30 * time.Second},
+		maxAttempts: 5,
+		backoff:     time.Second,
+	}
+}
+
+// Notify delivers a change event to all webhooks in the background.
+func (n *WebhookNotifier) Notify(event ChangeEvent) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("json.Marshal failed: %v", err)
+		return
+	}
+	for _, url := range n.urls {
+		d := &WebhookDelivery{
+			URL:        url,
+			Collection: event.Collection,
+			Version:    event.Version,
+			Timestamp:  time.Now().UTC(),
+		}
+		n.addDelivery(d)
+		go n.deliver(d, payload)
+	}
+}
+
+// Deliveries returns a snapshot of the most recent deliveries.
+func (n *WebhookNotifier) Deliveries() []WebhookDelivery {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	result := make([]WebhookDelivery, len(n.deliveries))
+	for i, d := range n.deliveries {
+		result[i] = *d
+	}
+	return result
+}
+
+func (n *WebhookNotifier) addDelivery(d *WebhookDelivery) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	n.deliveries = append(n.deliveries, d)
+	if len(n.deliveries) > MaxWebhookDeliveries {
+		n.deliveries = n.deliveries[len(n.deliveries)-MaxWebhookDeliveries:]
+	}
+}
+
+func (n *WebhookNotifier) deliver(d *WebhookDelivery, payload []byte) {
+	backoff := n.backoff
+	for attempt := 1; attempt <= n.maxAttempts; attempt++ {
+		status, err := n.post(d.URL, payload)
+
+		n.mutex.Lock()
+		d.Attempts = attempt
+		d.Status = status
+		d.Timestamp = time.Now().UTC()
+		if err != nil {
+			d.Error = err.Error()
+		} else {
+			d.Error = ""
+			d.Delivered = true
+		}
+		n.mutex.Unlock()
+
+		if err == nil {
+			numWebhookDeliveries.WithLabelValues("success").Inc()
+			return
+		}
+		log.Printf("webhook delivery to %s failed, attempt %d: %v", d.URL, attempt, err)
+		if attempt < n.maxAttempts {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+	numWebhookDeliveries.WithLabelValues("failure").Inc()
+}
+
+func (n *WebhookNotifier) post(url string, payload []byte) (int, error) {
+	req, err := http.NewRequest("POST", url, bytes.NewReader(payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "MiniWFS")
+	if len(n.secret) > 0 {
+		mac := hmac.New(sha256.New, n.secret)
+		mac.Write(payload)
+		req.Header.Set("X-Miniwfs-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("got HTTP status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
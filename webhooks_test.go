@@ -0,0 +1,72 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWebhookNotifier(t *testing.T) {
+	var mutex sync.Mutex
+	var calls int
+	done := make(chan []byte, 1)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mutex.Lock()
+		calls++
+		attempt := calls
+		mutex.Unlock()
+		if attempt == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		body, _ := ioutil.ReadAll(req.Body)
+		mac := hmac.New(sha256.New, []byte("webhook-secret"))
+		mac.Write(body)
+		expected := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+		if sig := req.Header.Get("X-Miniwfs-Signature"); sig != expected {
+			t.Errorf("expected signature %s, got %s", expected, sig)
+		}
+		w.WriteHeader(http.StatusNoContent)
+		done <- body
+	}))
+	defer receiver.Close()
+
+	n := MakeWebhookNotifier([]string{receiver.URL}, []byte("webhook-secret"))
+	n.backoff = time.Millisecond
+	n.Notify(ChangeEvent{Collection: "castles", Version: "v2", NumFeatures: 3})
+
+	select {
+	case body := <-done:
+		expectJSON(t, string(body), `{
+			"collection": "castles",
+			"version": "v2",
+			"previousVersion": "",
+			"timestamp": "0001-01-01T00:00:00Z",
+			"numFeatures": 3,
+			"numAdded": 0,
+			"numRemoved": 0,
+			"numChanged": 0
+		}`)
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook was not delivered")
+	}
+
+	// The delivery status gets updated right after the receiver has responded.
+	for i := 0; i < 100; i++ {
+		if d := n.Deliveries(); len(d) == 1 && d[0].Delivered {
+			if d[0].Attempts != 2 {
+				t.Errorf("expected 2 attempts, got %d", d[0].Attempts)
+			}
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Errorf("expected successful delivery, got %+v", n.Deliveries())
+}
@@ -7,18 +7,39 @@ import (
 	"errors"
 	//"fmt"
 	"html"
-	"log"
+	"io"
+	"net"
 	"net/http"
+	"net/url"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/golang/geo/s1"
 	"github.com/golang/geo/s2"
+	"github.com/paulmach/go.geojson"
+	"github.com/skip2/go-qrcode"
 )
 
 type WebServer struct {
 	index                *Index
+	access               *AccessControl // nil if all collections are public
+	admin                bool           // admin listener, serving private collections
+	webhooks             *WebhookNotifier
+	upstream             *UpstreamProxy    // nil if not proxying to an upstream server
+	quotas               *QuotaTracker     // nil if API keys have no usage quotas
+	usage                *UsageRecorder    // nil if usage is not recorded
+	canaries             map[string]bool   // collections that have a canary
+	shedder              *LoadShedder      // nil if not protecting against overload
+	idempotency          *IdempotencyCache // nil if Idempotency-Key is ignored
+	standby              *Standby          // nil if not part of a failover pair
+	slowlog              *SlowQueryLog     // nil if slow queries are not logged
+	saveData             *SaveDataPolicy   // nil if responses are never shaped for low bandwidth
+	startup              *StartupReport    // nil if not started from the command line
+	listeners            int               // number of SO_REUSEPORT sockets; 0 or 1 for a plain listener
+	offline              offlineLimiter
 	httpServer           http.Server
 	shutdownHasCompleted chan struct{}
 }
@@ -29,125 +50,732 @@ func MakeWebServer(index *Index) *WebServer {
 }
 
 var collectionRegexp = regexp.MustCompile(`^/collections/([^/]+)/items$`)
-var itemRegexp = regexp.MustCompile(`^/collections/([^/]+)/items/(.+)$`)
+var itemRegexp = regexp.MustCompile(`^/collections/([^/]+)/items/([^/]+)$`)
+var itemQRCodeRegexp = regexp.MustCompile(`^/collections/([^/]+)/items/([^/]+)/qr\.png$`)
+var previewRegexp = regexp.MustCompile(`^/collections/([^/]+)/preview\.png$`)
+var propertyValuesRegexp = regexp.MustCompile(`^/collections/([^/]+)/properties/([^/]+)/values$`)
+var queryablesRegexp = regexp.MustCompile(`^/collections/([^/]+)/queryables$`)
+var processRegexp = regexp.MustCompile(`^/collections/([^/]+)/process$`)
+var examplesRegexp = regexp.MustCompile(`^/collections/([^/]+)/examples$`)
+var styleRegexp = regexp.MustCompile(`^/tiles/([^/]+)/style\.json$`)
+var spriteRegexp = regexp.MustCompile(`^/tiles/([^/]+)/sprite(@2x)?\.(json|png)$`)
+var uploadRegexp = regexp.MustCompile(`^/admin/collections/([^/]+)/upload$`)
+var startUploadRegexp = regexp.MustCompile(`^/admin/collections/([^/]+)/uploads$`)
+var uploadSessionRegexp = regexp.MustCompile(`^/admin/uploads/([0-9a-f]+)$`)
+var syncRegexp = regexp.MustCompile(`^/collections/([^/]+)/sync$`)
+var signCollectionRegexp = regexp.MustCompile(`^/collections/([^/]+)/sign$`)
 var listCollectionsRegexp = regexp.MustCompile(`^/collections/?$`)
+var collectionInfoRegexp = regexp.MustCompile(`^/collections/([^/]+)/?$`)
+var shortLinkRegexp = regexp.MustCompile(`^/f/([^/]+)/([^/]+)$`)
+var shortTokenRegexp = regexp.MustCompile(`^/f/([A-Za-z0-9_-]+)$`)
 var tilesRegexp = regexp.MustCompile(
 	`^/tiles/([^/]+)/([^/]+)/([^/]+)/([^/]+)\.png$`)
 var tileFeatureInfoRegexp = regexp.MustCompile(
 	`^/tiles/([^/]+)/([0-9]+)/([0-9]+)/([0-9]+)/([0-9]+)/([0-9]+)\.geojson$`)
 
-func (s *WebServer) ListenAndServe(port int) error {
+// matchEscapedPath matches a route against an escaped request path,
+// and decodes the captured path segments.
+func matchEscapedPath(route *regexp.Regexp, escapedPath string) ([]string, bool) {
+	m := route.FindStringSubmatch(escapedPath)
+	if m == nil {
+		return nil, false
+	}
+	for i, segment := range m[1:] {
+		s, err := url.PathUnescape(segment)
+		if err != nil {
+			return nil, false
+		}
+		m[i+1] = s
+	}
+	return m, true
+}
+
+// routeItemsDirectly hands requests for features straight to our own
+// router, and all others to next. ServeMux cleans the decoded request
+// path, so it would redirect requests for features with IDs like ".."
+// or "a//b" to some other URL.
+func (s *WebServer) routeItemsDirectly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		path := req.URL.EscapedPath()
+		if itemRegexp.MatchString(path) || itemQRCodeRegexp.MatchString(path) || shortLinkRegexp.MatchString(path) {
+			s.HandleRequest(w, req)
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+// ListenAndServe serves requests on a TCP port until the server gets
+// shut down. If handler is nil, http.DefaultServeMux is used. With
+// more than one listener, each runs its own accept loop on a socket
+// bound with SO_REUSEPORT, which scales better on many cores.
+func (s *WebServer) ListenAndServe(port int, handler http.Handler) error {
+	if handler == nil {
+		handler = http.DefaultServeMux
+	}
 	s.httpServer.Addr = ":" + strconv.Itoa(port)
-	err := s.httpServer.ListenAndServe()
+	s.httpServer.Handler = s.routeItemsDirectly(handler)
+	var err error
+	if s.listeners > 1 {
+		err = s.serveReusePort()
+	} else {
+		err = s.httpServer.ListenAndServe()
+	}
 	<-s.shutdownHasCompleted
 	return err
 }
 
+func (s *WebServer) serveReusePort() error {
+	listeners, err := listenReusePort(s.httpServer.Addr, s.listeners)
+	if err != nil {
+		return err
+	}
+	errs := make(chan error, len(listeners))
+	for _, l := range listeners {
+		go func(l net.Listener) { errs <- s.httpServer.Serve(l) }(l)
+	}
+	return <-errs
+}
+
 func (s *WebServer) Shutdown() {
 	s.httpServer.Shutdown(context.Background())
 	close(s.shutdownHasCompleted)
 }
 
 func (s *WebServer) HandleRequest(w http.ResponseWriter, req *http.Request) {
-	path := req.URL.Path
+	endpoint, start := endpointName(req.URL.EscapedPath()), time.Now()
+	recorder := &statusRecordingResponseWriter{ResponseWriter: w}
+	w = recorder
+	numHTTPRequestsInFlight.Inc()
+	defer func() {
+		numHTTPRequestsInFlight.Dec()
+		recordRequest(endpoint, recorder.status, time.Since(start), time.Now())
+	}()
+
+	if !s.admin && !s.access.AllowsIP(req, "") {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	if !s.admin && !s.standby.IsActive() && req.URL.Path != "/healthz" {
+		w.Header().Set("Retry-After", "1")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	if s.shedder != nil {
+		if !s.shedder.Allow(requestCostClass(req.URL.Path, req), requestCollection(req.URL.Path)) {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		start := time.Now()
+		defer func() { s.shedder.Record(time.Since(start)) }()
+	}
+
+	if s.quotas != nil && !s.admin {
+		if key := s.access.GetAPIKey(req); len(key) > 0 {
+			now := time.Now()
+			if !s.quotas.Allow(key, w.Header(), now) {
+				w.WriteHeader(http.StatusTooManyRequests)
+				return
+			}
+			counter := &byteCountingResponseWriter{ResponseWriter: w}
+			defer func() { s.quotas.RecordBytes(key, counter.numBytes, now) }()
+			w = counter
+		}
+	}
+
+	// Feature IDs may contain slashes, so item routes get matched
+	// against the escaped path; all others against the decoded one.
+	escapedPath := s.routingPath(w, req)
+	path, err := url.PathUnescape(escapedPath)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if s.rejectForMaintenance(w, req, path) {
+		return
+	}
+
 	if m := tilesRegexp.FindStringSubmatch(path); len(m) == 5 {
-		zoom, _ := strconv.Atoi(m[2])
-		x, _ := strconv.Atoi(m[3])
-		y, _ := strconv.Atoi(m[4])
-		s.handleTileRequest(w, req, m[1], zoom, x, y)
+		tile, ok := ParseTileKey(m[2], m[3], m[4])
+		if !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		s.handleTileRequest(w, req, m[1], tile)
+		return
+	}
+
+	if m := tileBatchRegexp.FindStringSubmatch(path); len(m) == 2 {
+		s.handleTileBatchRequest(w, req, m[1])
+		return
+	}
+
+	if m := offlineRegexp.FindStringSubmatch(path); len(m) == 2 {
+		s.handleOfflineRequest(w, req, m[1])
 		return
 	}
 
 	if m := tileFeatureInfoRegexp.FindStringSubmatch(path); len(m) == 7 {
-		collection := m[1]
-		zoom, _ := strconv.ParseUint(m[2], 10, 8)
-		x, _ := strconv.ParseUint(m[3], 10, 32)
-		y, _ := strconv.ParseUint(m[4], 10, 32)
-		i, _ := strconv.ParseUint(m[5], 10, 32)
-		j, _ := strconv.ParseUint(m[6], 10, 32)
-		tile := &TileKey{X: uint32(x), Y: uint32(y), Zoom: uint8(zoom)}
-		s.handleTileFeatureInfoRequest(w, req, collection, tile, int(i), int(j))
+		tile, ok := ParseTileKey(m[2], m[3], m[4])
+		i, errI := strconv.Atoi(m[5])
+		j, errJ := strconv.Atoi(m[6])
+		if !ok || errI != nil || errJ != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		s.handleTileFeatureInfoRequest(w, req, m[1], tile, i, j)
 		return
 	}
 
 	if m := collectionRegexp.FindStringSubmatch(path); len(m) == 2 {
+		if req.Method == "POST" {
+			if !s.admin {
+				w.Header().Set("Allow", "GET, HEAD")
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+			if !s.authorizeWrite(w, req) {
+				return
+			}
+			s.idempotency.Serve(w, req, func(w http.ResponseWriter, req *http.Request) {
+				s.handleCreateItemRequest(w, req, m[1])
+			})
+			return
+		}
 		s.handleCollectionRequest(w, req, m[1])
 		return
 	}
 
-	if m := itemRegexp.FindStringSubmatch(path); len(m) == 3 {
+	if m, ok := matchEscapedPath(itemQRCodeRegexp, escapedPath); ok {
+		s.handleItemQRCodeRequest(w, req, m[1], m[2])
+		return
+	}
+
+	if m, ok := matchEscapedPath(itemRegexp, escapedPath); ok {
+		if req.Method == "PUT" || req.Method == "PATCH" {
+			if !s.admin {
+				w.Header().Set("Allow", "GET, HEAD")
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+			if !s.authorizeWrite(w, req) {
+				return
+			}
+			s.handleEditItemRequest(w, req, m[1], m[2])
+			return
+		}
 		s.handleItemRequest(w, req, m[1], m[2])
 		return
 	}
 
+	if m := previewRegexp.FindStringSubmatch(path); len(m) == 2 {
+		s.handlePreviewRequest(w, req, m[1])
+		return
+	}
+
+	if m := propertyValuesRegexp.FindStringSubmatch(path); len(m) == 3 {
+		s.handlePropertyValuesRequest(w, req, m[1], m[2])
+		return
+	}
+
+	if m := queryablesRegexp.FindStringSubmatch(path); len(m) == 2 {
+		s.handleQueryablesRequest(w, req, m[1])
+		return
+	}
+
+	if m := processRegexp.FindStringSubmatch(path); len(m) == 2 {
+		s.handleProcessRequest(w, req, m[1])
+		return
+	}
+
+	if m := examplesRegexp.FindStringSubmatch(path); len(m) == 2 {
+		s.handleExamplesRequest(w, req, m[1])
+		return
+	}
+
+	if m := styleRegexp.FindStringSubmatch(path); len(m) == 2 {
+		s.handleStyleRequest(w, req, m[1])
+		return
+	}
+
+	if m := spriteRegexp.FindStringSubmatch(path); len(m) == 4 {
+		pixelRatio := 1
+		if m[2] == "@2x" {
+			pixelRatio = 2
+		}
+		s.handleSpriteRequest(w, req, m[1], pixelRatio, m[3])
+		return
+	}
+
+	if m := syncRegexp.FindStringSubmatch(path); len(m) == 2 {
+		s.handleSyncRequest(w, req, m[1])
+		return
+	}
+
+	if m := signCollectionRegexp.FindStringSubmatch(path); len(m) == 2 {
+		s.handleSignCollectionRequest(w, req, m[1])
+		return
+	}
+
+	if m, ok := matchEscapedPath(shortLinkRegexp, escapedPath); ok {
+		s.handleShortLinkRequest(w, req, m[1], m[2])
+		return
+	}
+
+	if m := shortTokenRegexp.FindStringSubmatch(path); len(m) == 2 {
+		collection, item := s.index.ResolveShortToken(m[1])
+		s.handleShortLinkRequest(w, req, collection, item)
+		return
+	}
+
 	if m := listCollectionsRegexp.FindStringSubmatch(path); len(m) == 1 {
 		s.handleListCollectionsRequest(w, req)
 		return
 	}
 
+	if m := collectionInfoRegexp.FindStringSubmatch(path); len(m) == 2 {
+		s.handleCollectionInfoRequest(w, req, m[1])
+		return
+	}
+
+	if path == "/api" {
+		s.handleAPIRequest(w, req)
+		return
+	}
+
+	if path == "/readyz" {
+		s.handleReadyRequest(w, req)
+		return
+	}
+
+	if path == "/healthz" {
+		s.handleHealthRequest(w, req)
+		return
+	}
+
+	if path == "/conformance" {
+		s.handleConformanceRequest(w, req)
+		return
+	}
+
+	if path == "/wfs" {
+		s.handleWFS2Request(w, req)
+		return
+	}
+
+	if path == "/admin/webhooks" && s.admin {
+		s.handleWebhooksRequest(w, req)
+		return
+	}
+
+	if path == "/admin/usage" && s.admin {
+		s.handleUsageRequest(w, req)
+		return
+	}
+
+	if path == "/admin/slowlog" && s.admin {
+		s.handleSlowLogRequest(w, req)
+		return
+	}
+
+	if path == "/admin/alerts" && s.admin {
+		s.handleAlertsRequest(w, req)
+		return
+	}
+
+	if path == "/admin/promote" && s.admin {
+		s.handlePromoteRequest(w, req)
+		return
+	}
+
+	if path == "/admin/maintenance" && s.admin {
+		s.handleMaintenanceRequest(w, req)
+		return
+	}
+
+	if path == "/admin/loglevels" && s.admin {
+		s.handleLogLevelsRequest(w, req)
+		return
+	}
+
+	if path == "/admin/reload" && s.admin {
+		s.handleReloadRequest(w, req)
+		return
+	}
+
+	if path == "/admin/compare" && s.admin {
+		s.handleCompareRequest(w, req)
+		return
+	}
+
+	if path == "/admin/startup" && s.admin {
+		s.handleStartupRequest(w, req)
+		return
+	}
+
+	if m := uploadRegexp.FindStringSubmatch(path); len(m) == 2 && s.admin {
+		if !s.authorizeWrite(w, req) {
+			return
+		}
+		s.idempotency.Serve(w, req, func(w http.ResponseWriter, req *http.Request) {
+			s.handleUploadRequest(w, req, m[1])
+		})
+		return
+	}
+
+	if m := startUploadRegexp.FindStringSubmatch(path); len(m) == 2 && s.admin {
+		if !s.authorizeWrite(w, req) {
+			return
+		}
+		s.idempotency.Serve(w, req, func(w http.ResponseWriter, req *http.Request) {
+			s.handleStartUploadRequest(w, req, m[1])
+		})
+		return
+	}
+
+	if m := uploadSessionRegexp.FindStringSubmatch(path); len(m) == 2 && s.admin {
+		if !s.authorizeWrite(w, req) {
+			return
+		}
+		s.idempotency.Serve(w, req, func(w http.ResponseWriter, req *http.Request) {
+			s.handleUploadSessionRequest(w, req, m[1])
+		})
+		return
+	}
+
 	if req.URL.Path == "/" {
 		s.handleHomeRequest(w, req)
+		return
 	}
 
 	w.WriteHeader(http.StatusNotFound)
 }
 
+// handleLandingPageRequest serves the OGC API landing page, which
+// points clients to the other resources of the API.
+func (s *WebServer) handleLandingPageRequest(w http.ResponseWriter, req *http.Request) {
+	prefix := s.publicPath(req)
+	landingPage := struct {
+		Title       string     `json:"title"`
+		Description string     `json:"description"`
+		Links       []*WFSLink `json:"links"`
+	}{
+		Title:       "MiniWFS",
+		Description: "Access to geospatial data via OGC API - Features",
+		Links: []*WFSLink{
+			{Href: prefix + "?f=json", Rel: "self", Type: "application/json", Title: "this document"},
+			{Href: prefix + "?f=html", Rel: "alternate", Type: "text/html", Title: "this document as HTML"},
+			{Href: prefix + "api", Rel: "service-desc", Type: "application/vnd.oai.openapi+json;version=3.0", Title: "API definition"},
+			{Href: prefix + "conformance", Rel: "conformance", Type: "application/json", Title: "conformance classes"},
+			{Href: prefix + "collections", Rel: "data", Type: "application/json", Title: "collections"},
+		},
+	}
+
+	encoded, err := json.Marshal(landingPage)
+	if err != nil {
+		httpLog.Error("json.Marshal failed", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(encoded)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(encoded)
+}
+
+// conformanceClasses are the parts of OGC API - Features that we
+// implement. Clients such as QGIS check them before using the server.
+var conformanceClasses = []string{
+	"http://www.opengis.net/spec/ogcapi-features-1/1.0/conf/core",
+	"http://www.opengis.net/spec/ogcapi-features-1/1.0/conf/geojson",
+	"http://www.opengis.net/spec/ogcapi-features-1/1.0/conf/html",
+	"http://www.opengis.net/spec/ogcapi-features-1/1.0/conf/oas30",
+	"http://www.opengis.net/spec/ogcapi-features-3/1.0/conf/filter",
+	"http://www.opengis.net/spec/ogcapi-features-3/1.0/conf/features-filter",
+	"http://www.opengis.net/spec/cql2/1.0/conf/cql2-text",
+	"http://www.opengis.net/spec/cql2/1.0/conf/cql2-json",
+	"http://www.opengis.net/spec/cql2/1.0/conf/basic-cql2",
+}
+
+func (s *WebServer) handleConformanceRequest(w http.ResponseWriter, req *http.Request) {
+	if len(s.negotiate(w, req, "json")) == 0 {
+		return
+	}
+
+	encoded, err := json.Marshal(map[string][]string{"conformsTo": conformanceClasses})
+	if err != nil {
+		httpLog.Error("json.Marshal failed", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(encoded)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(encoded)
+}
+
+// formatMediaTypes tells which media types in an Accept header select
+// the formats of responses. Feature formats that are missing here get
+// selected by the media type of their output encoder.
+var formatMediaTypes = map[string][]string{
+	"json": {"application/json", "application/geo+json"},
+	"html": {"text/html"},
+}
+
+// negotiateFormat picks the format of a response among the formats
+// offered by an endpoint, such as "json" and "html". An explicit f=
+// query parameter takes precedence over the Accept header. Returns ""
+// if the f= parameter asks for a format that is not offered. If nothing
+// in the Accept header matches, we fall back to the first offered format.
+func negotiateFormat(req *http.Request, offered ...string) string {
+	if f := req.URL.Query().Get("f"); len(f) > 0 {
+		if f == "geojson" {
+			f = "json"
+		}
+		for _, format := range offered {
+			if format == f {
+				return f
+			}
+		}
+		return ""
+	}
+
+	for _, mediaType := range parseAccept(req.Header.Get("Accept")) {
+		for _, format := range offered {
+			if formatHasMediaType(format, mediaType) {
+				return format
+			}
+		}
+	}
+	return offered[0]
+}
+
+func formatHasMediaType(format string, mediaType string) bool {
+	if mediaTypes, ok := formatMediaTypes[format]; ok {
+		for _, m := range mediaTypes {
+			if m == mediaType {
+				return true
+			}
+		}
+		return false
+	}
+	e := GetOutputEncoderForMediaType(mediaType)
+	return e != nil && e.Name() == format
+}
+
+// negotiate picks the format of a response like negotiateFormat, and
+// tells caches that the response depends on the Accept header. If the
+// client asks for a format that is not offered, negotiate replies with
+// status 400 and returns "".
+func (s *WebServer) negotiate(w http.ResponseWriter, req *http.Request, offered ...string) string {
+	w.Header().Add("Vary", "Accept")
+	format := negotiateFormat(req, offered...)
+	if len(format) == 0 {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusBadRequest)
+		io.WriteString(w, "unsupported format; supported are "+strings.Join(offered, ", ")+"\n")
+	}
+	return format
+}
+
 func (s *WebServer) handleHomeRequest(w http.ResponseWriter, req *http.Request) {
-	url := html.EscapeString(s.index.PublicPath.String() + "collections")
+	// Browsers get HTML, even if they do not send an Accept header.
+	switch s.negotiate(w, req, "html", "json") {
+	case "":
+		return
+	case "json":
+		s.handleLandingPageRequest(w, req)
+		return
+	}
+
+	prefix := s.publicPath(req)
+	url := html.EscapeString(prefix + "collections")
 
 	var out bytes.Buffer
 	out.WriteString(
-		"<html><body><h1>MiniWFS</h1>" +
+		"<html><head><link rel=\"alternate\" type=\"application/json\" href=\"" +
+			html.EscapeString(prefix) + "?f=json\"></head>" +
+			"<body><h1>MiniWFS</h1>" +
 			"<p>Hello! This is a <a href=\"https://github.com/brawer/miniwfs\">" +
 			"MiniWFS</a> server. To use it, point any WFS3 client to <a href=\"")
 	out.WriteString(url)
 	out.WriteString("\">")
 	out.WriteString(url)
-	out.WriteString("</a>.</p></html>")
+	out.WriteString("</a>.</p>")
+
+	collections := s.listedCollectionsByGroup()
+	for i, c := range collections {
+		if i == 0 || c.Group != collections[i-1].Group {
+			if i > 0 {
+				out.WriteString("</ul>")
+			}
+			if len(c.Group) > 0 {
+				out.WriteString("<h2>" + html.EscapeString(c.Group) + "</h2>")
+			}
+			out.WriteString("<ul>")
+		}
+		collURL := url + "/" + html.EscapeString(c.Name)
+		out.WriteString("<li><a href=\"" + collURL + "\">")
+		if !s.access.IsProtected(c.Name) {
+			out.WriteString("<img src=\"" + collURL + "/preview.png\" width=\"64\" height=\"64\" alt=\"\"> ")
+		}
+		title := c.Title
+		if len(title) == 0 {
+			title = c.Name
+		}
+		out.WriteString(html.EscapeString(title) + "</a></li>")
+	}
+	if len(collections) > 0 {
+		out.WriteString("</ul>")
+	}
+	out.WriteString("</body></html>")
 
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	w.WriteHeader(http.StatusOK)
 	out.WriteTo(w)
+}
+
+// listedCollectionsByGroup returns the metadata of all collections that
+// are listed to clients of this server, sorted by group and then by name.
+// Ungrouped collections come first.
+func (s *WebServer) listedCollectionsByGroup() []CollectionMetadata {
+	var result []CollectionMetadata
+	for _, c := range s.index.GetCollections() {
+		if s.access.IsListed(c.Name, s.admin) && (s.admin || !s.isCanary(c.Name)) {
+			result = append(result, c)
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool { return result[i].Group < result[j].Group })
+	return result
+}
+
+type WFSSpatialExtent struct {
+	Bbox [][]float64 `json:"bbox"`
+	Crs  string      `json:"crs"`
+}
 
+type WFSExtent struct {
+	Spatial WFSSpatialExtent `json:"spatial"`
+}
+
+type WFSCollection struct {
+	Name        string     `json:"name"`
+	Title       string     `json:"title,omitempty"`
+	Description string     `json:"description,omitempty"`
+	Group       string     `json:"group,omitempty"`
+	Extent      *WFSExtent `json:"extent,omitempty"`
+	Links       []WFSLink  `json:"links"`
+
+	// ItemOrder tells clients in which order items get paged, and
+	// whether that order is stable across reloads of identical data.
+	ItemOrder string `json:"itemOrder"`
+}
+
+// makeWFSCollection describes a collection for clients, as listed
+// in /collections and returned by /collections/{collectionId}.
+func (s *WebServer) makeWFSCollection(prefix string, c CollectionMetadata) WFSCollection {
+	link := WFSLink{
+		Href:  prefix + "collections/" + c.Name,
+		Rel:   "item",
+		Type:  "application/geo+json",
+		Title: c.Name,
+	}
+	previewLink := WFSLink{
+		Href:  prefix + "collections/" + c.Name + "/preview.png",
+		Rel:   "preview",
+		Type:  "image/png",
+		Title: c.Name,
+	}
+	queryablesLink := WFSLink{
+		Href:  prefix + "collections/" + c.Name + "/queryables",
+		Rel:   QueryablesRel,
+		Type:  "application/schema+json",
+		Title: c.Name,
+	}
+	wfsColl := WFSCollection{
+		Name:        c.Name,
+		Title:       c.Title,
+		Description: c.Description,
+		Group:       c.Group,
+		Links:       []WFSLink{link, previewLink, queryablesLink},
+		ItemOrder:   s.index.GetItemOrder(),
+	}
+	if bbox := EncodeBbox(c.Bbox); bbox != nil {
+		wfsColl.Extent = &WFSExtent{Spatial: WFSSpatialExtent{
+			Bbox: [][]float64{bbox},
+			Crs:  "http://www.opengis.net/def/crs/OGC/1.3/CRS84",
+		}}
+	}
+	return wfsColl
 }
 
 func (s *WebServer) handleListCollectionsRequest(w http.ResponseWriter, req *http.Request) {
-	type WFSCollection struct {
-		Name  string    `json:"name"`
-		Links []WFSLink `json:"links"`
+	type WFSCollectionGroup struct {
+		Name        string   `json:"name"`
+		Collections []string `json:"collections"`
 	}
 
 	type WFSCollectionResponse struct {
-		Links       []WFSLink       `json:"links"`
-		Collections []WFSCollection `json:"collections"`
+		Links       []WFSLink            `json:"links"`
+		Collections []WFSCollection      `json:"collections"`
+		Groups      []WFSCollectionGroup `json:"groups,omitempty"`
+	}
+
+	format := s.negotiate(w, req, "json", "html")
+	if len(format) == 0 {
+		return
+	}
+
+	prefix := s.publicPath(req)
+	collections := s.listedCollectionsByGroup()
+	if format == "html" {
+		var buf bytes.Buffer
+		if err := s.writeCollectionsHTML(&buf, prefix, collections); err != nil {
+			httpLog.Error("rendering HTML failed", "error", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+		w.WriteHeader(http.StatusOK)
+		buf.WriteTo(w)
+		return
 	}
 
-	collections := s.index.GetCollections()
 	wfsCollections := make([]WFSCollection, 0, len(collections))
+	var groups []WFSCollectionGroup
 	for _, c := range collections {
-		link := WFSLink{
-			Href:  s.index.PublicPath.String() + "collections/" + c.Name,
-			Rel:   "item",
-			Type:  "application/geo+json",
-			Title: c.Name,
+		if len(c.Group) > 0 {
+			if n := len(groups); n == 0 || groups[n-1].Name != c.Group {
+				groups = append(groups, WFSCollectionGroup{Name: c.Group})
+			}
+			g := &groups[len(groups)-1]
+			g.Collections = append(g.Collections, c.Name)
 		}
-		wfsColl := WFSCollection{Name: c.Name, Links: []WFSLink{link}}
-		wfsCollections = append(wfsCollections, wfsColl)
+		wfsCollections = append(wfsCollections, s.makeWFSCollection(prefix, c))
 	}
 
 	selfLink := WFSLink{
-		Href: s.index.PublicPath.String() + "collections",
+		Href: prefix + "collections",
 		Rel:  "self", Type: "application/json", Title: "Collections",
 	}
 
 	result := WFSCollectionResponse{
 		Links:       []WFSLink{selfLink},
 		Collections: wfsCollections,
+		Groups:      groups,
 	}
 
 	encoded, err := json.Marshal(result)
 	if err != nil {
-		log.Printf("json.Marshal failed: %v", err)
+		httpLog.Error("json.Marshal failed", "error", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
@@ -158,8 +786,71 @@ func (s *WebServer) handleListCollectionsRequest(w http.ResponseWriter, req *htt
 	w.Write(encoded)
 }
 
+// handleCollectionInfoRequest describes a single collection, in JSON
+// or as an HTML page with a preview and links to its items.
+func (s *WebServer) handleCollectionInfoRequest(w http.ResponseWriter, req *http.Request,
+	collection string) {
+	if !s.authorize(w, req, collection) {
+		return
+	}
+
+	format := s.negotiate(w, req, "json", "html")
+	if len(format) == 0 {
+		return
+	}
+
+	var metadata *CollectionMetadata
+	collections := s.index.GetCollections()
+	for i := range collections {
+		if collections[i].Name == collection {
+			metadata = &collections[i]
+		}
+	}
+	if metadata == nil {
+		if s.upstream != nil {
+			s.upstream.Forward(w, req)
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	var buf bytes.Buffer
+	contentType := "application/json"
+	if format == "html" {
+		contentType = "text/html; charset=utf-8"
+		if err := s.writeCollectionHTML(&buf, s.publicPath(req), *metadata); err != nil {
+			httpLog.Error("rendering HTML failed", "error", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	} else {
+		encoded, err := json.Marshal(s.makeWFSCollection(s.publicPath(req), *metadata))
+		if err != nil {
+			httpLog.Error("json.Marshal failed", "error", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		buf.Write(encoded)
+	}
+
+	header := w.Header()
+	header.Set("Access-Control-Allow-Origin", "*")
+	header.Set("Content-Length", strconv.Itoa(buf.Len()))
+	header.Set("Content-Type", contentType)
+	header.Set("Last-Modified", metadata.LastModified.UTC().Format(http.TimeFormat))
+	s.setLicenseLink(header, collection)
+	w.WriteHeader(http.StatusOK)
+	buf.WriteTo(w)
+}
+
 func (s *WebServer) handleCollectionRequest(w http.ResponseWriter, req *http.Request,
 	collection string) {
+	started := time.Now()
+	if !s.authorize(w, req, collection) {
+		return
+	}
+
 	params := req.URL.Query()
 
 	ifModifiedSince, _ := http.ParseTime(req.Header.Get("If-Modified-Since"))
@@ -178,7 +869,11 @@ func (s *WebServer) handleCollectionRequest(w http.ResponseWriter, req *http.Req
 
 	startID := params.Get("startID")
 
+	saveData := s.saveData.applies(req)
 	limit := DefaultLimit
+	if saveData {
+		limit = s.saveData.Limit
+	}
 	limitParam := strings.TrimSpace(params.Get("limit"))
 	if len(limitParam) > 0 {
 		var err error
@@ -189,29 +884,242 @@ func (s *WebServer) handleCollectionRequest(w http.ResponseWriter, req *http.Req
 		}
 	}
 
-	bbox, err := parseBbox(params.Get("bbox"))
-	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+	sel, ok := s.parseItemsSelection(w, req)
+	if !ok {
+		return
+	}
+
+	if params.Get("explain") == "true" {
+		s.handleExplainRequest(w, collection, sel)
 		return
 	}
 
+	format := s.negotiate(w, req, featureFormats()...)
+	if len(format) == 0 {
+		return
+	}
+	encoder := GetOutputEncoder(format)
+	profile, ok := negotiateProfile(w, req)
+	if !ok {
+		return
+	}
+
+	// Revalidation with ETags needs no query, as long as the collection
+	// has not been reloaded. When clients send If-None-Match, we must
+	// ignore If-Modified-Since. https://www.rfc-editor.org/rfc/rfc9110#section-13.1.3
+	variant := []string{encoder.Name(), profile, strconv.FormatBool(saveData), s.publicPath(req)}
+
+	// Large pages of items compress well, and dominate our egress.
+	// Compressed responses need their own ETags, as these are strong.
+	w.Header().Add("Vary", "Accept-Encoding")
+	gzipped := acceptsGzip(req)
+	if gzipped {
+		variant = append(variant, "gzip")
+	}
+	if ifNoneMatch := req.Header.Get("If-None-Match"); len(ifNoneMatch) > 0 {
+		ifModifiedSince = time.Time{}
+		if md, err := s.index.GetMetadata(collection); err == nil {
+			if etag := collectionETag(md.Version, variant...); matchIfNoneMatch(ifNoneMatch, etag) {
+				w.Header().Set("ETag", etag)
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		}
+	}
+
 	var buf bytes.Buffer
-	includeLinks := true
-	metadata, err := s.index.GetItems(collection, startID, start, limit, bbox,
-		ifModifiedSince, ifUnmodifiedSince, includeLinks, &buf)
+	includeDeleted := params.Get("includeDeleted") == "true"
+	q := itemsQuery{itemsSelection: *sel, startID: startID, start: start, limit: limit,
+		linkParams: itemsLinkParams(params)}
+	// Canaries are reachable only under the name of their collection,
+	// so their links keep asking for canary data.
+	if s.isCanary(collection) {
+		q.linkCollection = strings.TrimSuffix(collection, CanarySuffix)
+		if q.linkParams == nil {
+			q.linkParams = make(url.Values)
+		}
+		q.linkParams.Set("canary", "true")
+	}
+	metadata, plan, err := s.index.GetItems(collection, q,
+		ifModifiedSince, ifUnmodifiedSince, s.publicPath(req), includeDeleted, &buf)
+	if err == NotFound && s.upstream != nil {
+		s.upstream.Forward(w, req)
+		return
+	}
+	if err == NotModified {
+		w.Header().Set("ETag", collectionETag(metadata.Version, variant...))
+	}
 	if status := getHTTPStatus(err); status != http.StatusOK {
 		w.WriteHeader(status)
 		return
 	}
 
+	if profile != ProfileFull {
+		shaped, err := transformRawFeatures(buf.Bytes(), func(f []byte) ([]byte, error) {
+			return shapeProfile(profile, f)
+		})
+		if err != nil {
+			httpLog.Error("shaping features failed", "profile", profile, "error", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		buf.Reset()
+		buf.Write(shaped)
+	}
+
+	if saveData {
+		shaped, err := s.saveData.shapeFeatureCollection(collection, buf.Bytes())
+		if err != nil {
+			httpLog.Error("shaping features failed", "error", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		buf.Reset()
+		buf.Write(shaped)
+	}
+
+	if _, isGeoJSON := encoder.(geoJSONEncoder); !isGeoJSON {
+		split := decodeRawFeatures
+		if _, ok := encoder.(jsonOnlyEncoder); ok {
+			split = splitRawFeatures
+		}
+		features, err := split(buf.Bytes())
+		if err != nil {
+			httpLog.Error("decoding features failed", "error", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		buf.Reset()
+		if err := encoder.EncodeFeatureCollection(&buf, collection, features); err != nil {
+			httpLog.Error("encoding features failed", "format", encoder.Name(), "error", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+
 	header := w.Header()
+	if gzipped {
+		var compressed bytes.Buffer
+		if err := gzipBytes(&compressed, buf.Bytes()); err != nil {
+			httpLog.Error("compressing features failed", "error", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		buf = compressed
+		header.Set("Content-Encoding", "gzip")
+	}
 	header.Set("Access-Control-Allow-Origin", "*")
 	header.Set("Content-Length", strconv.Itoa(buf.Len()))
-	header.Set("Content-Type", "application/geo+json")
+	header.Set("Content-Type", encoder.MediaType())
 	header.Set("Last-Modified", metadata.LastModified.UTC().Format(http.TimeFormat))
+	header.Set("ETag", collectionETag(metadata.Version, variant...))
+	s.setLicenseLink(header, collection)
+	setProfileHeaders(header, profile)
+	s.saveData.setVary(header)
 
+	// With ?debug=1, we tell how the query was executed, for tuning.
+	if params.Get("debug") == "1" {
+		header.Set(PlanHeader, plan.String())
+		header.Set("Cache-Control", "no-store")
+	}
+
+	s.usage.Record(time.Now(), collection, encoder.Name(), bboxSizeBucket(sel.bbox))
 	w.WriteHeader(http.StatusOK)
 	buf.WriteTo(w)
+	s.slowlog.Check(started, collection, makeSlowQueryParams(sel, startID, start, limit), plan)
+}
+
+// handleExplainRequest tells how an items request would get executed,
+// instead of returning the items.
+func (s *WebServer) handleExplainRequest(w http.ResponseWriter, collection string, sel *itemsSelection) {
+	explanation, err := s.index.ExplainQuery(collection, sel.bbox, sel.filter, sel.ids, sel.query, sel.join, sel.cql)
+	if status := getHTTPStatus(err); status != http.StatusOK {
+		w.WriteHeader(status)
+		return
+	}
+
+	encoded, err := json.Marshal(explanation)
+	if err != nil {
+		httpLog.Error("json.Marshal failed", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	header := w.Header()
+	header.Set("Access-Control-Allow-Origin", "*")
+	header.Set("Cache-Control", "no-store")
+	header.Set("Content-Type", "application/json")
+	header.Set("Content-Length", strconv.Itoa(len(encoded)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(encoded)
+}
+
+// itemsSelection holds the query parameters that select which items
+// get returned, as opposed to those for paging and formatting.
+type itemsSelection struct {
+	bbox   s2.Rect
+	filter PropertyFilter
+	ids    []string
+	query  string
+	join   *SpatialJoin
+	cql    *CQLFilter
+}
+
+// isEmpty tells whether the selection lets all items through.
+func (sel *itemsSelection) isEmpty() bool {
+	return sel.bbox.IsFull() && len(sel.filter) == 0 && len(sel.ids) == 0 &&
+		len(sel.query) == 0 && sel.join == nil && sel.cql == nil
+}
+
+// parseItemsSelection parses the query parameters that select items.
+// If they are malformed, or refer to a collection that the client may
+// not access, it writes an error response and returns false.
+func (s *WebServer) parseItemsSelection(w http.ResponseWriter, req *http.Request) (*itemsSelection, bool) {
+	params := req.URL.Query()
+	bbox, err := parseBbox(params.Get("bbox"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return nil, false
+	}
+
+	sel := &itemsSelection{
+		bbox:   bbox,
+		filter: parsePropertyFilter(params),
+		ids:    parseIDs(params.Get("ids")),
+		query:  strings.TrimSpace(params.Get("q")),
+	}
+	if text := params.Get("filter"); len(text) > 0 {
+		switch params.Get("filter-lang") {
+		case "", "cql2-text":
+			sel.cql, err = ParseCQL(text)
+		case "cql2-json":
+			sel.cql, err = ParseCQLJSON(text)
+		default:
+			err = errors.New("unsupported filter-lang; supported are cql2-text and cql2-json")
+		}
+		if err != nil {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, err.Error()+"\n")
+			return nil, false
+		}
+	}
+	if within := params.Get("within"); len(within) > 0 {
+		sel.join, err = s.index.ResolveSpatialJoin(within)
+		if err == nil && !s.authorize(w, req, sel.join.Collection) {
+			return nil, false
+		}
+		if err == NotFound {
+			w.WriteHeader(http.StatusNotFound)
+			return nil, false
+		} else if err != nil {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.WriteHeader(http.StatusBadRequest)
+			io.WriteString(w, err.Error()+"\n")
+			return nil, false
+		}
+	}
+	return sel, true
 }
 
 var malformedBbox error = errors.New("malformed bbox parameter")
@@ -254,6 +1162,20 @@ func parseBbox(s string) (s2.Rect, error) {
 
 func (s *WebServer) handleItemRequest(w http.ResponseWriter, req *http.Request,
 	collection string, item string) {
+	if !s.authorize(w, req, collection) {
+		return
+	}
+
+	format := s.negotiate(w, req, featureFormats()...)
+	if len(format) == 0 {
+		return
+	}
+	encoder := GetOutputEncoder(format)
+	profile, ok := negotiateProfile(w, req)
+	if !ok {
+		return
+	}
+
 	feature, err := s.index.GetItem(collection, item)
 
 	if err != nil {
@@ -261,26 +1183,120 @@ func (s *WebServer) handleItemRequest(w http.ResponseWriter, req *http.Request,
 		return
 	}
 	if feature == nil {
+		if s.upstream != nil {
+			s.upstream.Forward(w, req)
+			return
+		}
 		w.WriteHeader(http.StatusNotFound)
 		return
 	}
 
 	encoded, err := json.Marshal(feature)
 	if err != nil {
-		log.Printf("json.Marshal failed: %v", err)
+		httpLog.Error("json.Marshal failed", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	etag := itemETag(encoded, encoder.Name(), profile, s.saveData.applies(req))
+	if profile != ProfileFull || s.saveData.applies(req) {
+		encoded, err = shapeProfile(profile, encoded)
+		if err == nil && s.saveData.applies(req) {
+			encoded, err = s.saveData.shapeFeature(collection, encoded)
+		}
+		if err == nil {
+			feature, err = geojson.UnmarshalFeature(encoded)
+		}
+		if err != nil {
+			httpLog.Error("shaping feature failed", "profile", profile, "error", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := encoder.EncodeFeature(&buf, collection, RawFeature{JSON: encoded, Feature: feature}); err != nil {
+		httpLog.Error("encoding feature failed", "format", encoder.Name(), "error", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
 	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Content-Type", "application/geo+json")
+	w.Header().Set("Content-Type", encoder.MediaType())
+	w.Header().Set("ETag", etag)
+	s.setLicenseLink(w.Header(), collection)
+	setProfileHeaders(w.Header(), profile)
+	s.saveData.setVary(w.Header())
+	s.usage.Record(time.Now(), collection, encoder.Name(), "none")
 	w.WriteHeader(http.StatusOK)
-	w.Write(encoded)
+	buf.WriteTo(w)
+}
+
+// handleItemQRCodeRequest renders a QR code that encodes the canonical
+// URL of an item, for printing on asset tags.
+func (s *WebServer) handleItemQRCodeRequest(w http.ResponseWriter, req *http.Request,
+	collection string, item string) {
+	if !s.authorize(w, req, collection) {
+		return
+	}
+
+	if !s.index.HasItem(collection, item) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	u := FormatItemURL(s.publicPath(req), collection, item)
+	png, err := qrcode.Encode(u, qrcode.Medium, 256)
+	if err != nil {
+		httpLog.Error("qrcode.Encode failed", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	header := w.Header()
+	header.Set("Access-Control-Allow-Origin", "*")
+	header.Set("Content-Length", strconv.Itoa(len(png)))
+	header.Set("Content-Type", "image/png")
+	w.WriteHeader(http.StatusOK)
+	w.Write(png)
+}
+
+// handleShortLinkRequest redirects short permalinks, such as those
+// printed in reports or encoded in QR codes, to the canonical item URL.
+func (s *WebServer) handleShortLinkRequest(w http.ResponseWriter, req *http.Request,
+	collection string, item string) {
+	if len(collection) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	// Clients without access must not learn which items exist.
+	if !s.authorize(w, req, collection) {
+		return
+	}
+
+	if !s.index.HasItem(collection, item) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Location", FormatItemURL(s.publicPath(req), collection, item))
+	w.WriteHeader(http.StatusFound)
 }
 
 func (s *WebServer) handleTileRequest(w http.ResponseWriter, req *http.Request,
-	collection string, zoom int, x int, y int) {
-	tile, metadata, err := s.index.GetTile(collection, zoom, x, y)
+	collection string, tileKey TileKey) {
+	if !s.authorize(w, req, collection) {
+		return
+	}
+
+	// With ?debug=1, we render an overlay for diagnosing tiling bugs.
+	debug := req.URL.Query().Get("debug") == "1"
+	getTile := s.index.GetTile
+	if debug {
+		getTile = s.index.GetDebugTile
+	}
+	tile, metadata, err := getTile(collection, tileKey)
 	if status := getHTTPStatus(err); status != http.StatusOK {
 		w.WriteHeader(status)
 		return
@@ -291,24 +1307,30 @@ func (s *WebServer) handleTileRequest(w http.ResponseWriter, req *http.Request,
 	header.Set("Content-Length", strconv.Itoa(len(tile)))
 	header.Set("Content-Type", "image/png")
 	header.Set("Last-Modified", metadata.LastModified.UTC().Format(http.TimeFormat))
+	if debug {
+		header.Set("Cache-Control", "no-store")
+	}
+	s.setLicenseLink(header, collection)
+	s.usage.Record(time.Now(), collection, "png", tileSizeBucket(int(tileKey.Zoom)))
 	w.WriteHeader(http.StatusOK)
 	w.Write(tile)
 }
 
 func (s *WebServer) handleTileFeatureInfoRequest(
 	w http.ResponseWriter, req *http.Request,
-	collection string, tile *TileKey, i int, j int) {
-	if i < 0 || i > 256 || j < 0 || j >= 256 {
+	collection string, tile TileKey, i int, j int) {
+	if !s.authorize(w, req, collection) {
+		return
+	}
+
+	if i < 0 || i >= TileSize || j < 0 || j >= TileSize {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
-	tileBounds := tile.Bounds()
-	tileSize := tileBounds.Size()
-	pixelSize := s2.LatLng{Lat: tileSize.Lat / 256, Lng: tileSize.Lng / 256}
-	center := s2.LatLng{
-		Lat: s1.Angle(tileBounds.Hi().Lat.Radians() - pixelSize.Lat.Radians()*float64(j)),
-		Lng: s1.Angle(tileBounds.Lo().Lng.Radians() + pixelSize.Lng.Radians()*float64(i))}
+	tileSize := tile.Bounds().Size()
+	pixelSize := s2.LatLng{Lat: tileSize.Lat / TileSize, Lng: tileSize.Lng / TileSize}
+	center := tile.PixelLatLng(float64(i), float64(j))
 	maxSignatureWidth := 8.0 // pixels
 	bboxSize := s2.LatLng{
 		Lat: s1.Angle(pixelSize.Lat.Radians() * maxSignatureWidth),
@@ -318,10 +1340,11 @@ func (s *WebServer) handleTileFeatureInfoRequest(
 	ifModifiedSince, _ := http.ParseTime(req.Header.Get("If-Modified-Since"))
 	ifUnmodifiedSince, _ := http.ParseTime(req.Header.Get("If-Unmodified-Since"))
 	limit := 10
-	includeLinks := false
+	includeDeleted := false
 	var buf bytes.Buffer
-	metadata, err := s.index.GetItems(collection, "", 0, limit, bbox,
-		ifModifiedSince, ifUnmodifiedSince, includeLinks, &buf)
+	metadata, _, err := s.index.GetItems(collection,
+		itemsQuery{itemsSelection: itemsSelection{bbox: bbox}, limit: limit},
+		ifModifiedSince, ifUnmodifiedSince, "", includeDeleted, &buf)
 	if status := getHTTPStatus(err); status != http.StatusOK {
 		w.WriteHeader(status)
 		return
@@ -337,6 +1360,154 @@ func (s *WebServer) handleTileFeatureInfoRequest(
 	buf.WriteTo(w)
 }
 
+// itemsLinkParams returns the query parameters that links to other
+// pages of items need to keep, so that clients can follow the links
+// of a signed URL without signing each page.
+func itemsLinkParams(params url.Values) url.Values {
+	var linkParams url.Values
+	for _, key := range []string{"expires", "signature"} {
+		if value := params.Get(key); len(value) > 0 {
+			if linkParams == nil {
+				linkParams = make(url.Values)
+			}
+			linkParams.Set(key, value)
+		}
+	}
+	return linkParams
+}
+
+// authorize checks whether the request may access a collection.
+// If not, it sends an error status to the client and returns false.
+func (s *WebServer) authorize(w http.ResponseWriter, req *http.Request, collection string) bool {
+	// Crawlers may find unlisted collections through links shared
+	// elsewhere, but should not put them into search results.
+	if s.access.IsUnlisted(strings.TrimSuffix(collection, CanarySuffix)) {
+		w.Header().Set("X-Robots-Tag", "noindex")
+	}
+	if s.admin {
+		return true
+	}
+	if s.isCanary(collection) {
+		if !wantsCanary(req) {
+			w.WriteHeader(http.StatusNotFound)
+			return false
+		}
+		// Canaries are subject to the same access rules as the
+		// collections they are going to replace.
+		collection = collection[:len(collection)-len(CanarySuffix)]
+	}
+	if s.access.IsPrivate(collection) {
+		w.WriteHeader(http.StatusNotFound)
+		return false
+	}
+	if !s.access.AllowsIP(req, collection) {
+		w.WriteHeader(http.StatusForbidden)
+		return false
+	}
+	if status := s.access.Authorize(req, collection, time.Now()); status != http.StatusOK {
+		w.WriteHeader(status)
+		return false
+	}
+	return true
+}
+
+// authorizeWrite checks that a request for changing data carries a
+// valid API key, since the admin port may be reachable by anyone.
+// If not, it sends an error status to the client and returns false.
+func (s *WebServer) authorizeWrite(w http.ResponseWriter, req *http.Request) bool {
+	if s.access.HasValidAPIKey(req) {
+		return true
+	}
+	if len(req.Header.Get("X-API-Key")) > 0 || len(req.Header.Get("Authorization")) > 0 {
+		w.WriteHeader(http.StatusForbidden)
+		return false
+	}
+	w.Header().Set("WWW-Authenticate", "Bearer")
+	w.WriteHeader(http.StatusUnauthorized)
+	return false
+}
+
+// handleSignCollectionRequest hands out signed query parameters for a
+// collection to callers with a valid API key, typically a backend that
+// then embeds the signed URLs into a web map.
+func (s *WebServer) handleSignCollectionRequest(w http.ResponseWriter, req *http.Request,
+	collection string) {
+	if s.access == nil || len(s.access.signingKey) == 0 || !s.index.HasCollection(collection) ||
+		(s.access.IsPrivate(collection) && !s.admin) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	if !s.access.HasValidAPIKey(req) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	ttl := time.Hour
+	if ttlParam := strings.TrimSpace(req.URL.Query().Get("ttl")); len(ttlParam) > 0 {
+		seconds, err := strconv.Atoi(ttlParam)
+		if err != nil || seconds <= 0 || time.Duration(seconds)*time.Second > MaxSignatureTTL {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		ttl = time.Duration(seconds) * time.Second
+	}
+
+	expires := time.Now().Add(ttl)
+	result := struct {
+		Collection string `json:"collection"`
+		Expires    string `json:"expires"`
+		Query      string `json:"query"`
+	}{
+		Collection: collection,
+		Expires:    expires.UTC().Format(time.RFC3339),
+		Query:      s.access.SignURLQuery(collection, expires).Encode(),
+	}
+
+	encoded, err := json.Marshal(result)
+	if err != nil {
+		httpLog.Error("json.Marshal failed", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(encoded)
+}
+
+// handleWebhooksRequest shows the status of recent webhook deliveries.
+// It is only served on the admin listener.
+func (s *WebServer) handleWebhooksRequest(w http.ResponseWriter, req *http.Request) {
+	deliveries := []WebhookDelivery{}
+	if s.webhooks != nil {
+		deliveries = s.webhooks.Deliveries()
+	}
+
+	encoded, err := json.Marshal(struct {
+		Deliveries []WebhookDelivery `json:"deliveries"`
+	}{deliveries})
+	if err != nil {
+		httpLog.Error("json.Marshal failed", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(encoded)
+}
+
+// setLicenseLink points clients to the license of a collection,
+// if one has been configured.
+func (s *WebServer) setLicenseLink(header http.Header, collection string) {
+	if license := s.index.GetAttribution(collection).License; len(license) > 0 {
+		header.Add("Link", "<"+license+`>; rel="license"`)
+	}
+}
+
 func getHTTPStatus(err error) int {
 	switch err {
 	case nil:
@@ -351,6 +1522,10 @@ func getHTTPStatus(err error) int {
 	case NotModified:
 		return http.StatusNotModified
 
+	case UnknownVersion:
+		// The client needs to start over by syncing without a version.
+		return http.StatusGone
+
 	default:
 		return http.StatusInternalServerError
 	}
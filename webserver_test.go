@@ -4,10 +4,13 @@ import (
 	"bytes"
 	"compress/gzip"
 	"encoding/json"
+	"image"
+	"image/png"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
@@ -123,6 +126,130 @@ func TestHome(t *testing.T) {
 	}
 }
 
+func TestNegotiateFormat(t *testing.T) {
+	index, s := makeServer(t)
+	defer s.Shutdown()
+	defer index.Close()
+
+	for _, tc := range []struct {
+		url, accept string
+		status      int
+		contentType string
+	}{
+		{"/", "", 200, "text/html; charset=utf-8"},
+		{"/?f=xml", "", 400, "text/plain; charset=utf-8"},
+		{"/conformance", "text/html", 200, "application/json"},
+		{"/conformance?f=html", "", 400, "text/plain; charset=utf-8"},
+		{"/api?f=json", "", 200, "application/vnd.oai.openapi+json;version=3.0"},
+		{"/collections", "text/html;q=0.5, application/json", 200, "application/json"},
+		{"/collections/castles", "application/xhtml+xml, text/html", 200, "text/html; charset=utf-8"},
+		{"/collections/castles/items", "application/json", 200, "application/geo+json"},
+		{"/collections/castles/items?f=geojson", "text/html", 200, "application/geo+json"},
+		{"/collections/castles/items?f=xml", "", 400, "text/plain; charset=utf-8"},
+		{"/collections/castles/items/N34729562", "text/html", 200, "text/html; charset=utf-8"},
+		{"/collections/castles/sync?f=html", "", 400, "text/plain; charset=utf-8"},
+		{"/collections/castles/properties/name/values?f=json", "", 200, "application/json"},
+	} {
+		req, _ := http.NewRequest("GET", tc.url, nil)
+		if len(tc.accept) > 0 {
+			req.Header.Set("Accept", tc.accept)
+		}
+		resp := httptest.NewRecorder()
+		http.HandlerFunc(s.HandleRequest).ServeHTTP(resp, req)
+		if resp.Code != tc.status || resp.Header().Get("Content-Type") != tc.contentType {
+			t.Errorf("GET %s with Accept: %s: expected status %d with Content-Type %q, got %d with %q",
+				tc.url, tc.accept, tc.status, tc.contentType, resp.Code, resp.Header().Get("Content-Type"))
+		}
+		if vary := resp.Header().Get("Vary"); vary != "Accept" {
+			t.Errorf("GET %s: expected Vary: Accept, got %q", tc.url, vary)
+		}
+	}
+}
+
+// TestHome_Registered checks that the landing page is reachable
+// through the handlers registered by main, and that unknown paths are not.
+func TestHome_Registered(t *testing.T) {
+	index, s := makeServer(t)
+	defer s.Shutdown()
+	defer index.Close()
+	mux := http.NewServeMux()
+	registerHandlers(mux, s)
+	handler := s.routeItemsDirectly(mux)
+
+	for _, tc := range []struct {
+		url, contentType string
+		status           int
+	}{
+		{"/?f=json", "application/json", http.StatusOK},
+		{"/?f=html", "text/html; charset=utf-8", http.StatusOK},
+		{"/no-such-page", "", http.StatusNotFound},
+	} {
+		query := httptest.NewRequest("GET", tc.url, nil)
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, query)
+		if resp.Code != tc.status {
+			t.Errorf("GET %s: expected status %d, got %d", tc.url, tc.status, resp.Code)
+			continue
+		}
+		if ct := resp.Header().Get("Content-Type"); tc.status == http.StatusOK && ct != tc.contentType {
+			t.Errorf("GET %s: expected Content-Type %q, got %q", tc.url, tc.contentType, ct)
+		}
+	}
+}
+
+func TestHome_JSON(t *testing.T) {
+	index, s := makeServer(t)
+	defer s.Shutdown()
+	defer index.Close()
+
+	for _, tc := range []struct {
+		url, accept string
+		json        bool
+	}{
+		{"/", "application/json", true},
+		{"/", "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", false},
+		{"/", "text/html;q=0.5,application/json", true},
+		{"/?f=json", "text/html", true},
+		{"/?f=html", "application/json", false},
+	} {
+		query, _ := http.NewRequest("GET", tc.url, nil)
+		query.Header.Set("Accept", tc.accept)
+		resp := httptest.NewRecorder()
+		http.HandlerFunc(s.HandleRequest).ServeHTTP(resp, query)
+		isJSON := resp.Header().Get("Content-Type") == "application/json"
+		if isJSON != tc.json {
+			t.Errorf("%s with Accept: %s: expected JSON=%v, got Content-Type %s",
+				tc.url, tc.accept, tc.json, resp.Header().Get("Content-Type"))
+		}
+		if vary := resp.Header().Get("Vary"); vary != "Accept" {
+			t.Errorf("expected Vary: Accept, got %q", vary)
+		}
+	}
+
+	query, _ := http.NewRequest("GET", "/?f=json", nil)
+	resp := httptest.NewRecorder()
+	http.HandlerFunc(s.HandleRequest).ServeHTTP(resp, query)
+	var landingPage struct {
+		Links []WFSLink `json:"links"`
+	}
+	if err := json.Unmarshal(resp.Body.Bytes(), &landingPage); err != nil {
+		t.Fatal(err)
+	}
+	links := make(map[string]string)
+	for _, link := range landingPage.Links {
+		links[link.Rel] = link.Href
+	}
+	for rel, href := range map[string]string{
+		"data":         "https://test.example.org/wfs/collections",
+		"conformance":  "https://test.example.org/wfs/conformance",
+		"service-desc": "https://test.example.org/wfs/api",
+	} {
+		if links[rel] != href {
+			t.Errorf("expected %s link to %s, got %q", rel, href, links[rel])
+		}
+	}
+}
+
 func TestListCollections(t *testing.T) {
 	index, s := makeServer(t)
 	defer s.Shutdown()
@@ -149,30 +276,153 @@ func TestListCollections(t *testing.T) {
           "collections": [
             {
               "name": "castles",
+              "extent": {
+                "spatial": {
+                  "bbox": [[10.6848117, 45.6076336, 11.183468, 47.910414]],
+                  "crs": "http://www.opengis.net/def/crs/OGC/1.3/CRS84"
+                }
+              },
               "links": [
                 {
                   "href": "https://test.example.org/wfs/collections/castles",
                   "rel": "item",
                   "type": "application/geo+json",
                   "title": "castles"
+                },
+                {
+                  "href": "https://test.example.org/wfs/collections/castles/preview.png",
+                  "rel": "preview",
+                  "type": "image/png",
+                  "title": "castles"
+                },
+                {
+                  "href": "https://test.example.org/wfs/collections/castles/queryables",
+                  "rel": "http://www.opengis.net/def/rel/ogc/1.0/queryables",
+                  "type": "application/schema+json",
+                  "title": "castles"
                 }
-              ]
+              ],
+              "itemOrder": "source"
             },
             {
               "name": "lakes",
+              "extent": {
+                "spatial": {
+                  "bbox": [[11.183468, 47.910414, 11.183468, 47.910414]],
+                  "crs": "http://www.opengis.net/def/crs/OGC/1.3/CRS84"
+                }
+              },
               "links": [
                 {
                   "href": "https://test.example.org/wfs/collections/lakes",
                   "rel": "item",
                   "type": "application/geo+json",
                   "title": "lakes"
+                },
+                {
+                  "href": "https://test.example.org/wfs/collections/lakes/preview.png",
+                  "rel": "preview",
+                  "type": "image/png",
+                  "title": "lakes"
+                },
+                {
+                  "href": "https://test.example.org/wfs/collections/lakes/queryables",
+                  "rel": "http://www.opengis.net/def/rel/ogc/1.0/queryables",
+                  "type": "application/schema+json",
+                  "title": "lakes"
                 }
-              ]
+              ],
+              "itemOrder": "source"
             }
           ]
         }`)
 }
 
+func TestListCollections_Groups(t *testing.T) {
+	index, s := makeServer(t)
+	defer s.Shutdown()
+	defer index.Close()
+	index.SetCollectionGroups(map[string]string{"lakes": "hydrography"})
+	query, _ := http.NewRequest("GET", "/collections", nil)
+	resp := httptest.NewRecorder()
+	http.HandlerFunc(s.HandleRequest).ServeHTTP(resp, query)
+
+	var result struct {
+		Collections []struct {
+			Name  string `json:"name"`
+			Group string `json:"group"`
+		} `json:"collections"`
+		Groups []struct {
+			Name        string   `json:"name"`
+			Collections []string `json:"collections"`
+		} `json:"groups"`
+	}
+	if err := json.Unmarshal(resp.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Collections) != 2 || result.Collections[1].Name != "lakes" ||
+		result.Collections[1].Group != "hydrography" || result.Collections[0].Group != "" {
+		t.Errorf("expected lakes in group hydrography, got %v", result.Collections)
+	}
+	if len(result.Groups) != 1 || result.Groups[0].Name != "hydrography" ||
+		strings.Join(result.Groups[0].Collections, ",") != "lakes" {
+		t.Errorf("expected group hydrography with lakes, got %v", result.Groups)
+	}
+
+	query, _ = http.NewRequest("GET", "/", nil)
+	resp = httptest.NewRecorder()
+	http.HandlerFunc(s.HandleRequest).ServeHTTP(resp, query)
+	body := getBody(resp)
+	if !strings.Contains(body, "<h2>hydrography</h2><ul><li><a href=\"https://test.example.org/wfs/collections/lakes\">"+
+		"<img src=\"https://test.example.org/wfs/collections/lakes/preview.png\" width=\"64\" height=\"64\" alt=\"\"> lakes</a></li></ul>") {
+		t.Errorf("expected grouped collections on home page, got %s", body)
+	}
+}
+
+func TestListCollections_Descriptions(t *testing.T) {
+	index, s := makeServer(t)
+	defer s.Shutdown()
+	defer index.Close()
+	index.SetCollectionDescriptions(map[string]CollectionDescription{
+		"lakes": {Title: "Lakes & Ponds", Description: "Standing water."},
+	})
+
+	query, _ := http.NewRequest("GET", "/collections/lakes", nil)
+	resp := httptest.NewRecorder()
+	http.HandlerFunc(s.HandleRequest).ServeHTTP(resp, query)
+	var coll struct {
+		Title       string `json:"title"`
+		Description string `json:"description"`
+	}
+	if err := json.Unmarshal(resp.Body.Bytes(), &coll); err != nil {
+		t.Fatal(err)
+	}
+	if coll.Title != "Lakes & Ponds" || coll.Description != "Standing water." {
+		t.Errorf("expected title and description, got %+v", coll)
+	}
+
+	query, _ = http.NewRequest("GET", "/collections/castles", nil)
+	resp = httptest.NewRecorder()
+	http.HandlerFunc(s.HandleRequest).ServeHTTP(resp, query)
+	if body := getBody(resp); strings.HasPrefix(body, `{"name":"castles","title"`) || strings.Contains(body, `"description"`) {
+		t.Errorf("expected no title or description for castles, got %s", body)
+	}
+
+	query, _ = http.NewRequest("GET", "/collections/lakes?f=html", nil)
+	resp = httptest.NewRecorder()
+	http.HandlerFunc(s.HandleRequest).ServeHTTP(resp, query)
+	if body := getBody(resp); !strings.Contains(body, "<h1>Lakes &amp; Ponds</h1>\n<p>Standing water.</p>") {
+		t.Errorf("expected title and description on HTML page, got %s", body)
+	}
+
+	query, _ = http.NewRequest("GET", "/", nil)
+	resp = httptest.NewRecorder()
+	http.HandlerFunc(s.HandleRequest).ServeHTTP(resp, query)
+	if body := getBody(resp); !strings.Contains(body, "> Lakes &amp; Ponds</a></li>") {
+		t.Errorf("expected title on home page, got %s", body)
+	}
+}
+
 func TestCollection(t *testing.T) {
 	index, s := makeServer(t)
 	defer s.Shutdown()
@@ -219,6 +469,18 @@ func TestCollection(t *testing.T) {
               "rel": "self",
               "type": "application/geo+json",
               "title": "self"
+            },
+            {
+              "href": "https://test.example.org/wfs/collections/castles/items?bbox=11.1834670,47.9104130,11.1834690,47.9104150",
+              "rel": "first",
+              "type": "application/geo+json",
+              "title": "first"
+            },
+            {
+              "href": "https://test.example.org/wfs/collections/castles/items?bbox=11.1834670,47.9104130,11.1834690,47.9104150",
+              "rel": "last",
+              "type": "application/geo+json",
+              "title": "last"
             }
           ],
           "bbox": [
@@ -244,6 +506,77 @@ func TestCollection_NotFound(t *testing.T) {
 	}
 }
 
+func TestCollection_PropertyFilter(t *testing.T) {
+	index, s := makeServer(t)
+	defer s.Shutdown()
+	defer index.Close()
+	for path, expected := range map[string]string{
+		"/collections/castles/items?building=yes":                                   "W24785843",
+		"/collections/castles/items?historic=castle&name=Hochschlo%C3%9F+P%C3%A4hl": "N34729562",
+		"/collections/castles/items?historic=castle&limit=2":                        "N34729562 W418392510",
+		"/collections/castles/items?historic=ruins":                                 "",
+	} {
+		query, _ := http.NewRequest("GET", path, nil)
+		resp := httptest.NewRecorder()
+		http.HandlerFunc(s.HandleRequest).ServeHTTP(resp, query)
+		var got WFSFeatureCollection
+		if err := json.Unmarshal(resp.Body.Bytes(), &got); err != nil {
+			t.Fatal(err)
+		}
+		ids := make([]string, len(got.Features))
+		for i, f := range got.Features {
+			ids[i] = f.ID.(string)
+		}
+		if strings.Join(ids, " ") != expected {
+			t.Errorf("GET %s: expected %q, got %q", path, expected, strings.Join(ids, " "))
+		}
+	}
+
+	// The link to the next page keeps the filter.
+	query, _ := http.NewRequest("GET", "/collections/castles/items?historic=castle&limit=2", nil)
+	resp := httptest.NewRecorder()
+	http.HandlerFunc(s.HandleRequest).ServeHTTP(resp, query)
+	if body := getBody(resp); !strings.Contains(body, `start=2\u0026limit=2\u0026historic=castle`) {
+		t.Errorf("expected next link with filter, got %s", body)
+	}
+}
+
+func TestCollection_IDs(t *testing.T) {
+	index, s := makeServer(t)
+	defer s.Shutdown()
+	defer index.Close()
+	for path, expected := range map[string]string{
+		"/collections/castles/items?ids=W24785843,N34729562":              "N34729562 W24785843",
+		"/collections/castles/items?ids=W24785843,N0":                     "W24785843",
+		"/collections/castles/items?ids=N0":                               "",
+		"/collections/castles/items?ids=N34729562,W24785843&limit=1":      "N34729562",
+		"/collections/castles/items?ids=N34729562,W24785843&building=yes": "W24785843",
+	} {
+		query, _ := http.NewRequest("GET", path, nil)
+		resp := httptest.NewRecorder()
+		http.HandlerFunc(s.HandleRequest).ServeHTTP(resp, query)
+		var got WFSFeatureCollection
+		if err := json.Unmarshal(resp.Body.Bytes(), &got); err != nil {
+			t.Fatal(err)
+		}
+		ids := make([]string, len(got.Features))
+		for i, f := range got.Features {
+			ids[i] = f.ID.(string)
+		}
+		if strings.Join(ids, " ") != expected {
+			t.Errorf("GET %s: expected %q, got %q", path, expected, strings.Join(ids, " "))
+		}
+	}
+
+	// The link to the next page keeps the IDs.
+	query, _ := http.NewRequest("GET", "/collections/castles/items?ids=N34729562,W24785843&limit=1", nil)
+	resp := httptest.NewRecorder()
+	http.HandlerFunc(s.HandleRequest).ServeHTTP(resp, query)
+	if body := getBody(resp); !strings.Contains(body, `limit=1\u0026ids=N34729562,W24785843`) {
+		t.Errorf("expected next link with ids, got %s", body)
+	}
+}
+
 func TestCollection_IfModifiedSince(t *testing.T) {
 	stat, _ := os.Stat(filepath.Join("testdata", "castles.geojson"))
 	past := stat.ModTime().Add(-time.Hour).UTC().Format(http.TimeFormat)
@@ -324,6 +657,70 @@ func TestItem(t *testing.T) {
         }`)
 }
 
+// nastyIDs are feature IDs that are easy to get wrong in URLs.
+var nastyIDs = []string{"a/b", "a/qr.png", "what?", "100%", "#1", "a b", "Zürich", "東京",
+	".", "..", "../lakes", "a//b", "semi;colon", "plus+sign", "é/../..", "%2F"}
+
+func TestItem_EscapedIDs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "miniwfs-nasty-ids")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var features []string
+	for _, id := range nastyIDs {
+		idJSON, _ := json.Marshal(id)
+		features = append(features, `{"type":"Feature","id":`+string(idJSON)+
+			`,"geometry":{"type":"Point","coordinates":[8.5,47.4]},"properties":{}}`)
+	}
+	path := filepath.Join(dir, "nasty.geojson")
+	data := `{"type":"FeatureCollection","features":[` + strings.Join(features, ",") + `]}`
+	if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	publicPath, _ := url.Parse("https://test.example.org/")
+	index, err := MakeIndex(map[string]string{"nasty": path}, publicPath, IndexOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer index.Close()
+	s := MakeWebServer(index)
+	defer s.Shutdown()
+
+	// Route like ListenAndServe does, since ServeMux cleans paths.
+	mux := http.NewServeMux()
+	registerHandlers(mux, s)
+	handler := s.routeItemsDirectly(mux)
+	for _, id := range nastyIDs {
+		u := FormatItemURL(publicPath.String(), "nasty", id)
+		for _, suffix := range []string{"", "/qr.png"} {
+			req := httptest.NewRequest("GET", u+suffix, nil)
+			resp := httptest.NewRecorder()
+			handler.ServeHTTP(resp, req)
+			if resp.Code != http.StatusOK {
+				t.Errorf("id %q: GET %s: expected status 200, got %d", id, u+suffix, resp.Code)
+				continue
+			}
+			if suffix != "" {
+				continue
+			}
+			var feature struct {
+				ID string `json:"id"`
+			}
+			if err := json.Unmarshal(resp.Body.Bytes(), &feature); err != nil || feature.ID != id {
+				t.Errorf("id %q: GET %s returned feature %q, %v", id, u, feature.ID, err)
+			}
+		}
+
+		req := httptest.NewRequest("GET", "/f/nasty/"+EscapeItemID(id), nil)
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+		if loc := resp.Header().Get("Location"); loc != u {
+			t.Errorf("id %q: expected short link to redirect to %s, got %q", id, u, loc)
+		}
+	}
+}
+
 func TestTilesFeatureInfo(t *testing.T) {
 	index, s := makeServer(t)
 	defer s.Shutdown()
@@ -477,3 +874,234 @@ func expectCORSHeader(t *testing.T, header http.Header) {
 		t.Errorf("expected header \"Access-Control-Allow-Origin: *\", got %s", cors)
 	}
 }
+
+func TestShortLink(t *testing.T) {
+	index, s := makeServer(t)
+	defer s.Shutdown()
+	defer index.Close()
+	for _, path := range []string{"/f/castles/W418392510", "/f/" + ShortToken("castles", "W418392510")} {
+		query, _ := http.NewRequest("GET", path, nil)
+		handler := http.HandlerFunc(s.HandleRequest)
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, query)
+		if status := resp.Result().StatusCode; status != http.StatusFound {
+			t.Errorf("expected %d for %s, got %d", http.StatusFound, path, status)
+		}
+		expected := "https://test.example.org/wfs/collections/castles/items/W418392510"
+		if loc := resp.Header().Get("Location"); loc != expected {
+			t.Errorf("expected Location: %s for %s, got %s", expected, path, loc)
+		}
+	}
+}
+
+func TestShortLink_NotFound(t *testing.T) {
+	index, s := makeServer(t)
+	defer s.Shutdown()
+	defer index.Close()
+	for _, path := range []string{"/f/castles/unknown-id", "/f/nosuchcollection/W418392510", "/f/AAAAAAAA"} {
+		query, _ := http.NewRequest("GET", path, nil)
+		handler := http.HandlerFunc(s.HandleRequest)
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, query)
+		if status := resp.Result().StatusCode; status != http.StatusNotFound {
+			t.Errorf("expected %d for %s, got %d", http.StatusNotFound, path, status)
+		}
+	}
+}
+
+func TestShortLink_Protected(t *testing.T) {
+	index, s := makeServer(t)
+	defer s.Shutdown()
+	defer index.Close()
+	s.access = makeTestAccessControl()
+	for _, path := range []string{"/f/castles/W418392510", "/f/castles/unknown-id"} {
+		query, _ := http.NewRequest("GET", path, nil)
+		resp := httptest.NewRecorder()
+		http.HandlerFunc(s.HandleRequest).ServeHTTP(resp, query)
+		if status := resp.Result().StatusCode; status != http.StatusUnauthorized {
+			t.Errorf("expected %d for %s, got %d", http.StatusUnauthorized, path, status)
+		}
+	}
+}
+
+func TestItemQRCode(t *testing.T) {
+	index, s := makeServer(t)
+	defer s.Shutdown()
+	defer index.Close()
+	query, _ := http.NewRequest("GET", "/collections/lakes/items/N123/qr.png", nil)
+	handler := http.HandlerFunc(s.HandleRequest)
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, query)
+	if ct := resp.Header().Get("Content-Type"); ct != "image/png" {
+		t.Errorf("Expected Content-Type: image/png, got %s", ct)
+	}
+	expectCORSHeader(t, resp.Header())
+	img, err := png.Decode(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := img.Bounds().Size(); size.X != 256 || size.Y != 256 {
+		t.Errorf("expected 256x256 image, got %v", size)
+	}
+}
+
+func TestItemQRCode_NotFound(t *testing.T) {
+	index, s := makeServer(t)
+	defer s.Shutdown()
+	defer index.Close()
+	query, _ := http.NewRequest("GET", "/collections/lakes/items/unknown-id/qr.png", nil)
+	handler := http.HandlerFunc(s.HandleRequest)
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, query)
+	if status := resp.Result().StatusCode; status != http.StatusNotFound {
+		t.Errorf("expected %d, got %d", http.StatusNotFound, status)
+	}
+}
+
+func TestCollectionAttribution(t *testing.T) {
+	index, s := makeServer(t)
+	defer s.Shutdown()
+	defer index.Close()
+	license := "https://opendatacommons.org/licenses/odbl/1-0/"
+	index.SetCollectionAttributions(map[string]CollectionAttribution{
+		"lakes": {License: license, Attribution: "© OpenStreetMap contributors"},
+	})
+
+	query, _ := http.NewRequest("GET", "/collections/lakes/items", nil)
+	resp := httptest.NewRecorder()
+	http.HandlerFunc(s.HandleRequest).ServeHTTP(resp, query)
+	expectedLink := "<" + license + `>; rel="license"`
+	if link := resp.Header().Get("Link"); link != expectedLink {
+		t.Errorf("expected Link: %s, got %s", expectedLink, link)
+	}
+	var fc struct {
+		License     string `json:"license"`
+		Attribution string `json:"attribution"`
+	}
+	if err := json.Unmarshal(resp.Body.Bytes(), &fc); err != nil {
+		t.Fatal(err)
+	}
+	if fc.License != license || fc.Attribution != "© OpenStreetMap contributors" {
+		t.Errorf("expected license and attribution members, got %+v", fc)
+	}
+
+	query, _ = http.NewRequest("GET", "/collections/lakes/items/N123", nil)
+	resp = httptest.NewRecorder()
+	http.HandlerFunc(s.HandleRequest).ServeHTTP(resp, query)
+	if link := resp.Header().Get("Link"); link != expectedLink {
+		t.Errorf("expected Link: %s on single item, got %s", expectedLink, link)
+	}
+
+	query, _ = http.NewRequest("GET", "/collections/castles/items", nil)
+	resp = httptest.NewRecorder()
+	http.HandlerFunc(s.HandleRequest).ServeHTTP(resp, query)
+	if link := resp.Header().Get("Link"); link != "" {
+		t.Errorf("expected no Link header for collection without license, got %s", link)
+	}
+	if strings.Contains(getBody(resp), `"license"`) {
+		t.Error("expected no license member for collection without license")
+	}
+}
+
+func TestTiles_BadRequest(t *testing.T) {
+	index, s := makeServer(t)
+	defer s.Shutdown()
+	defer index.Close()
+	for _, path := range []string{
+		"/tiles/castles/1/2/0.png",
+		"/tiles/castles/1/0/2.png",
+		"/tiles/castles/31/0/0.png",
+		"/tiles/castles/x/0/0.png",
+		"/tiles/castles/1/2/0/10/5.geojson",
+		"/tiles/castles/1/0/0/256/5.geojson",
+	} {
+		query, _ := http.NewRequest("GET", path, nil)
+		resp := httptest.NewRecorder()
+		http.HandlerFunc(s.HandleRequest).ServeHTTP(resp, query)
+		if resp.Code != http.StatusBadRequest {
+			t.Errorf("expected status 400 for %s, got %d", path, resp.Code)
+		}
+	}
+}
+
+func TestTiles_Debug(t *testing.T) {
+	index, s := makeServer(t)
+	defer s.Shutdown()
+	defer index.Close()
+
+	// Tile 1/0/1 covers the south-western quadrant, where there are
+	// no castles; the debug overlay should still be drawn.
+	query, _ := http.NewRequest("GET", "/tiles/castles/1/0/1.png?debug=1", nil)
+	resp := httptest.NewRecorder()
+	http.HandlerFunc(s.HandleRequest).ServeHTTP(resp, query)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", resp.Code)
+	}
+	if cc := resp.Header().Get("Cache-Control"); cc != "no-store" {
+		t.Errorf("expected Cache-Control: no-store, got %q", cc)
+	}
+	img, err := png.Decode(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []image.Point{{0, 0}, {TileSize - 1, TileSize / 2}, {TileSize / 2, TileSize - 1}} {
+		if r, g, b, a := img.At(p.X, p.Y).RGBA(); r != 0xffff || g != 0 || b != 0 || a != 0xffff {
+			t.Errorf("expected red tile border at %v, got %d %d %d %d", p, r, g, b, a)
+		}
+	}
+
+	// Without the debug parameter, the same tile is empty.
+	query, _ = http.NewRequest("GET", "/tiles/castles/1/0/1.png", nil)
+	resp = httptest.NewRecorder()
+	http.HandlerFunc(s.HandleRequest).ServeHTTP(resp, query)
+	if !bytes.Equal(resp.Body.Bytes(), emptyPNG) {
+		t.Error("expected empty tile without debug overlay")
+	}
+}
+
+// TestConformance_Registered checks that the conformance declaration
+// is reachable through the handlers registered by main.
+func TestConformance_Registered(t *testing.T) {
+	index, s := makeServer(t)
+	defer s.Shutdown()
+	defer index.Close()
+	mux := http.NewServeMux()
+	registerHandlers(mux, s)
+	server := httptest.NewServer(s.routeItemsDirectly(mux))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/conformance")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("GET /conformance: expected status 200, got %d", resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+		t.Errorf("GET /conformance: expected Content-Type application/json, got %q", ct)
+	}
+}
+
+func TestConformance(t *testing.T) {
+	index, s := makeServer(t)
+	defer s.Shutdown()
+	defer index.Close()
+	query, _ := http.NewRequest("GET", "/conformance", nil)
+	resp := httptest.NewRecorder()
+	http.HandlerFunc(s.HandleRequest).ServeHTTP(resp, query)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", resp.Code)
+	}
+	expectCORSHeader(t, resp.Header())
+	expectJSON(t, getBody(resp), `{"conformsTo": [
+		"http://www.opengis.net/spec/ogcapi-features-1/1.0/conf/core",
+		"http://www.opengis.net/spec/ogcapi-features-1/1.0/conf/geojson",
+		"http://www.opengis.net/spec/ogcapi-features-1/1.0/conf/html",
+		"http://www.opengis.net/spec/ogcapi-features-1/1.0/conf/oas30",
+		"http://www.opengis.net/spec/ogcapi-features-3/1.0/conf/filter",
+		"http://www.opengis.net/spec/ogcapi-features-3/1.0/conf/features-filter",
+		"http://www.opengis.net/spec/cql2/1.0/conf/cql2-text",
+		"http://www.opengis.net/spec/cql2/1.0/conf/cql2-json",
+		"http://www.opengis.net/spec/cql2/1.0/conf/basic-cql2"]}`)
+}
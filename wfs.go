@@ -1,17 +1,22 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/base64"
 	"fmt"
 	"net/url"
 	"strings"
+	"time"
 
-	"github.com/golang/geo/s2"
 	"github.com/paulmach/go.geojson"
 )
 
 const DefaultLimit = 10
 const MaxLimit = 10000
 
+// MaxSignatureTTL is the longest validity period for signed URLs.
+const MaxSignatureTTL = 7 * 24 * time.Hour
+
 type WFSLink struct {
 	Href  string `json:"href"`
 	Rel   string `json:"rel"`
@@ -26,25 +31,80 @@ type WFSFeatureCollection struct {
 	Features    []*geojson.Feature `json:"features"`
 }
 
-func FormatItemsURL(prefix string, collection string,
-	startID string, start int, limit int, bbox s2.Rect) string {
+// ShortTokenLength is the number of characters in a short token.
+// 8 characters of base64 encode 48 bits, which makes collisions very
+// unlikely for the collection sizes we serve.
+const ShortTokenLength = 8
+
+// ShortToken returns a short, URL-safe token for a feature which can be
+// resolved at /f/{token}. Tokens are derived by hashing, so they stay
+// stable across data reloads as long as the feature keeps its ID.
+func ShortToken(collection string, id string) string {
+	hash := sha256.Sum256([]byte(collection + "/" + id))
+	return base64.RawURLEncoding.EncodeToString(hash[:])[:ShortTokenLength]
+}
+
+func FormatItemURL(prefix string, collection string, id string) string {
+	return prefix + "collections/" + url.PathEscape(collection) + "/items/" + EscapeItemID(id)
+}
+
+// EscapeItemID encodes a feature ID as a single URL path segment.
+// Feature IDs can contain any character, so slashes and question marks
+// get percent-encoded along with non-ASCII characters. IDs "." and ".."
+// get encoded entirely, since clients and routers would otherwise treat
+// them as relative path segments. The web server routes on escaped
+// paths, so an ID always gets decoded from exactly one segment.
+func EscapeItemID(id string) string {
+	switch id {
+	case ".":
+		return "%2E"
+	case "..":
+		return "%2E%2E"
+	}
+	return url.PathEscape(id)
+}
+
+func FormatItemsURL(prefix string, collection string, q itemsQuery) string {
 	params := make([]string, 0, 4)
-	if len(startID) > 0 {
-		params = append(params, "startID="+url.QueryEscape(startID))
+	if len(q.startID) > 0 {
+		params = append(params, "startID="+url.QueryEscape(q.startID))
 	}
-	if start > 0 {
-		params = append(params, fmt.Sprintf("start=%d", start))
+	if q.start > 0 {
+		params = append(params, fmt.Sprintf("start=%d", q.start))
 	}
-	if limit != DefaultLimit {
-		params = append(params, fmt.Sprintf("limit=%d", limit))
+	if q.limit != DefaultLimit {
+		params = append(params, fmt.Sprintf("limit=%d", q.limit))
 	}
-	if !bbox.IsFull() {
-		r := EncodeBbox(bbox)
+	if !q.bbox.IsFull() {
+		r := EncodeBbox(q.bbox)
 		if r != nil {
 			boxParam := fmt.Sprintf("bbox=%.7f,%.7f,%.7f,%.7f", r[0], r[1], r[2], r[3])
 			params = append(params, boxParam)
 		}
 	}
+	params = append(params, q.filter.encode()...)
+	if len(q.ids) > 0 {
+		escaped := make([]string, len(q.ids))
+		for i, id := range q.ids {
+			escaped[i] = url.QueryEscape(id)
+		}
+		params = append(params, "ids="+strings.Join(escaped, ","))
+	}
+	if len(q.query) > 0 {
+		params = append(params, "q="+url.QueryEscape(q.query))
+	}
+	if q.join != nil {
+		params = append(params, "within="+q.join.encode())
+	}
+	if q.cql != nil {
+		if q.cql.Lang != "cql2-text" {
+			params = append(params, "filter-lang="+url.QueryEscape(q.cql.Lang))
+		}
+		params = append(params, "filter="+url.QueryEscape(q.cql.Text))
+	}
+	if len(q.linkParams) > 0 {
+		params = append(params, q.linkParams.Encode())
+	}
 	u := prefix + "collections/" + url.PathEscape(collection) + "/items"
 	if len(params) > 0 {
 		return u + "?" + strings.Join(params, "&")
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/geo/s2"
+)
+
+// Compatibility shim for legacy clients that only speak WFS 2.0 with
+// key-value-pair encoding. We support GetCapabilities and GetFeature,
+// translated onto the same index that serves the WFS3 API.
+// http://docs.opengeospatial.org/is/09-025r2/09-025r2.html
+
+func (s *WebServer) handleWFS2Request(w http.ResponseWriter, req *http.Request) {
+	params := make(map[string]string)
+	for key, values := range req.URL.Query() {
+		if len(values) > 0 {
+			params[strings.ToLower(key)] = values[0]
+		}
+	}
+
+	switch strings.ToLower(params["request"]) {
+	case "getcapabilities":
+		s.handleWFS2GetCapabilities(w, req)
+
+	case "getfeature":
+		s.handleWFS2GetFeature(w, req, params)
+
+	default:
+		writeWFS2Exception(w, http.StatusBadRequest, "OperationNotSupported",
+			"request", "supported requests are GetCapabilities and GetFeature")
+	}
+}
+
+func (s *WebServer) handleWFS2GetCapabilities(w http.ResponseWriter, req *http.Request) {
+	endpoint := s.publicPath(req) + "wfs"
+	var buf bytes.Buffer
+	out := bufio.NewWriter(&buf)
+	writeXMLHeader(out)
+	out.WriteString(`<wfs:WFS_Capabilities version="2.0.0"` +
+		` xmlns:wfs="http://www.opengis.net/wfs/2.0"` +
+		` xmlns:ows="http://www.opengis.net/ows/1.1"` +
+		` xmlns:xlink="http://www.w3.org/1999/xlink"` +
+		` xmlns:miniwfs="` + miniwfsNamespace + `">`)
+	out.WriteString(`<ows:ServiceIdentification><ows:Title>MiniWFS</ows:Title>` +
+		`<ows:ServiceType>WFS</ows:ServiceType><ows:ServiceTypeVersion>2.0.0</ows:ServiceTypeVersion>` +
+		`</ows:ServiceIdentification>`)
+	out.WriteString(`<ows:OperationsMetadata>`)
+	for _, op := range []string{"GetCapabilities", "GetFeature"} {
+		out.WriteString(`<ows:Operation name="` + op + `"><ows:DCP><ows:HTTP><ows:Get xlink:href="`)
+		xml.EscapeText(out, []byte(endpoint))
+		out.WriteString(`"/></ows:HTTP></ows:DCP></ows:Operation>`)
+	}
+	out.WriteString(`</ows:OperationsMetadata><wfs:FeatureTypeList>`)
+	for _, md := range s.index.GetCollections() {
+		if !s.access.IsListed(md.Name, s.admin) || (!s.admin && s.isCanary(md.Name)) {
+			continue
+		}
+		out.WriteString(`<wfs:FeatureType><wfs:Name>miniwfs:`)
+		xml.EscapeText(out, []byte(xmlName(md.Name)))
+		out.WriteString(`</wfs:Name><wfs:Title>`)
+		xml.EscapeText(out, []byte(md.Name))
+		out.WriteString(`</wfs:Title><wfs:DefaultCRS>` + gmlSRSName + `</wfs:DefaultCRS>` +
+			`<wfs:OutputFormats><wfs:Format>application/gml+xml; version=3.2</wfs:Format></wfs:OutputFormats>` +
+			`</wfs:FeatureType>`)
+	}
+	out.WriteString(`</wfs:FeatureTypeList></wfs:WFS_Capabilities>`)
+	out.Flush()
+
+	header := w.Header()
+	header.Set("Access-Control-Allow-Origin", "*")
+	header.Set("Content-Length", strconv.Itoa(buf.Len()))
+	header.Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	buf.WriteTo(w)
+}
+
+// wfs2Collection returns the collection for a feature type name, which
+// GetCapabilities has built with xmlName. Collections whose names are
+// valid XML names are their own type names, and get picked first.
+func (s *WebServer) wfs2Collection(typeName string) string {
+	collections := s.index.GetCollections()
+	for _, md := range collections {
+		if md.Name == typeName {
+			return md.Name
+		}
+	}
+	for _, md := range collections {
+		if xmlName(md.Name) == typeName {
+			return md.Name
+		}
+	}
+	return typeName
+}
+
+func (s *WebServer) handleWFS2GetFeature(w http.ResponseWriter, req *http.Request, params map[string]string) {
+	typeNames := params["typenames"]
+	if len(typeNames) == 0 {
+		typeNames = params["typename"] // WFS 1.x spelling, still sent by some clients
+	}
+	if len(typeNames) == 0 || strings.Contains(typeNames, ",") {
+		writeWFS2Exception(w, http.StatusBadRequest, "InvalidParameterValue",
+			"typeNames", "exactly one feature type must be given")
+		return
+	}
+	typeName := typeNames
+	if i := strings.IndexByte(typeName, ':'); i >= 0 {
+		typeName = typeName[i+1:]
+	}
+	collection := s.wfs2Collection(typeName)
+
+	if !s.authorize(w, req, collection) {
+		return
+	}
+
+	limit := DefaultLimit
+	if count := params["count"]; len(count) > 0 {
+		var err error
+		if limit, err = strconv.Atoi(count); err != nil {
+			writeWFS2Exception(w, http.StatusBadRequest, "InvalidParameterValue", "count", err.Error())
+			return
+		}
+	}
+
+	start := 0
+	if startIndex := params["startindex"]; len(startIndex) > 0 {
+		var err error
+		if start, err = strconv.Atoi(startIndex); err != nil {
+			writeWFS2Exception(w, http.StatusBadRequest, "InvalidParameterValue", "startIndex", err.Error())
+			return
+		}
+	}
+
+	bbox, err := parseWFS2Bbox(params["bbox"])
+	if err != nil {
+		writeWFS2Exception(w, http.StatusBadRequest, "InvalidParameterValue", "bbox", err.Error())
+		return
+	}
+
+	var noTime time.Time
+	var items bytes.Buffer
+	includeDeleted := false
+	metadata, _, err := s.index.GetItems(collection,
+		itemsQuery{itemsSelection: itemsSelection{bbox: bbox}, start: start, limit: limit},
+		noTime, noTime, "", includeDeleted, &items)
+	if err == NotFound {
+		writeWFS2Exception(w, http.StatusNotFound, "InvalidParameterValue", "typeNames", "unknown feature type")
+		return
+	} else if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	features, err := decodeRawFeatures(items.Bytes())
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := (gmlEncoder{}).EncodeFeatureCollection(&buf, collection, features); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	header := w.Header()
+	header.Set("Access-Control-Allow-Origin", "*")
+	header.Set("Content-Length", strconv.Itoa(buf.Len()))
+	header.Set("Content-Type", "application/gml+xml; version=3.2")
+	header.Set("Last-Modified", metadata.LastModified.UTC().Format(http.TimeFormat))
+	w.WriteHeader(http.StatusOK)
+	buf.WriteTo(w)
+}
+
+// parseWFS2Bbox parses a WFS 2.0 bbox parameter. Without a CRS suffix,
+// or with the EPSG:4326 URN, coordinates are in latitude/longitude
+// order; with CRS84 or the legacy "EPSG:4326" spelling, they are in
+// longitude/latitude order like in WFS3.
+func parseWFS2Bbox(s string) (s2.Rect, error) {
+	parts := strings.Split(strings.TrimSpace(s), ",")
+	if len(parts) != 5 && len(parts) != 4 {
+		return parseBbox(s)
+	}
+
+	latLonOrder := true
+	if len(parts) == 5 {
+		crs := strings.ToUpper(strings.TrimSpace(parts[4]))
+		latLonOrder = !strings.HasSuffix(crs, "CRS84") && crs != "EPSG:4326"
+		parts = parts[:4]
+	}
+	if latLonOrder {
+		parts = []string{parts[1], parts[0], parts[3], parts[2]}
+	}
+	return parseBbox(strings.Join(parts, ","))
+}
+
+func writeWFS2Exception(w http.ResponseWriter, status int, code string, locator string, text string) {
+	var buf bytes.Buffer
+	out := bufio.NewWriter(&buf)
+	writeXMLHeader(out)
+	out.WriteString(`<ows:ExceptionReport version="2.0.0" xmlns:ows="http://www.opengis.net/ows/1.1">` +
+		`<ows:Exception exceptionCode="` + code + `" locator="` + locator + `"><ows:ExceptionText>`)
+	xml.EscapeText(out, []byte(text))
+	out.WriteString(`</ows:ExceptionText></ows:Exception></ows:ExceptionReport>`)
+	out.Flush()
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	buf.WriteTo(w)
+}
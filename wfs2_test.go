@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWFS2GetCapabilities(t *testing.T) {
+	index, s := makeServer(t)
+	defer s.Shutdown()
+	defer index.Close()
+	query, _ := http.NewRequest("GET", "/wfs?SERVICE=WFS&REQUEST=GetCapabilities", nil)
+	resp := httptest.NewRecorder()
+	http.HandlerFunc(s.HandleRequest).ServeHTTP(resp, query)
+	if ct := resp.Header().Get("Content-Type"); ct != "application/xml" {
+		t.Errorf("Expected Content-Type: application/xml, got %s", ct)
+	}
+	body := getBody(resp)
+	expectWellFormedXML(t, body)
+	for _, name := range []string{"<wfs:Name>miniwfs:castles</wfs:Name>", "<wfs:Name>miniwfs:lakes</wfs:Name>"} {
+		if !strings.Contains(body, name) {
+			t.Errorf("expected %s in capabilities, got %s", name, body)
+		}
+	}
+}
+
+func TestWFS2GetFeature(t *testing.T) {
+	index, s := makeServer(t)
+	defer s.Shutdown()
+	defer index.Close()
+	query, _ := http.NewRequest("GET", "/wfs?service=WFS&version=2.0.0&request=GetFeature"+
+		"&typeNames=miniwfs:castles&count=5&bbox=46.0,11.0,46.1,11.2", nil)
+	resp := httptest.NewRecorder()
+	http.HandlerFunc(s.HandleRequest).ServeHTTP(resp, query)
+	if ct := resp.Header().Get("Content-Type"); ct != "application/gml+xml; version=3.2" {
+		t.Errorf("Expected Content-Type: application/gml+xml; version=3.2, got %s", ct)
+	}
+	expectCORSHeader(t, resp.Header())
+	body := getBody(resp)
+	expectWellFormedXML(t, body)
+	for _, expected := range []string{
+		`numberReturned="1"`,
+		`<miniwfs:castles gml:id="castles.W24785843">`,
+		`<miniwfs:name>Palazzo Pretorio</miniwfs:name>`,
+		`<gml:exterior><gml:LinearRing><gml:posList>46.0670118 11.1221624 46.0670507 11.1221546 `,
+	} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("expected %s in response, got %s", expected, body)
+		}
+	}
+}
+
+func TestWFS2GetFeature_UnknownType(t *testing.T) {
+	index, s := makeServer(t)
+	defer s.Shutdown()
+	defer index.Close()
+	query, _ := http.NewRequest("GET", "/wfs?request=GetFeature&typeNames=nosuchcollection", nil)
+	resp := httptest.NewRecorder()
+	http.HandlerFunc(s.HandleRequest).ServeHTTP(resp, query)
+	if status := resp.Result().StatusCode; status != http.StatusNotFound {
+		t.Errorf("expected %d, got %d", http.StatusNotFound, status)
+	}
+	expectWellFormedXML(t, getBody(resp))
+}
+
+func TestWFS2GetFeature_XMLName(t *testing.T) {
+	publicPath, _ := url.Parse("https://test.example.org/wfs/")
+	index, err := MakeIndex(map[string]string{"castles 2019": filepath.Join("testdata", "castles.geojson")},
+		publicPath, IndexOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer index.Close()
+	s := MakeWebServer(index)
+
+	query, _ := http.NewRequest("GET", "/wfs?request=GetCapabilities", nil)
+	resp := httptest.NewRecorder()
+	http.HandlerFunc(s.HandleRequest).ServeHTTP(resp, query)
+	if body := getBody(resp); !strings.Contains(body, "<wfs:Name>miniwfs:castles_2019</wfs:Name>") {
+		t.Fatalf("expected feature type miniwfs:castles_2019, got %s", body)
+	}
+
+	query, _ = http.NewRequest("GET", "/wfs?request=GetFeature&typeNames=miniwfs:castles_2019", nil)
+	resp = httptest.NewRecorder()
+	http.HandlerFunc(s.HandleRequest).ServeHTTP(resp, query)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected status 200 for advertised type name, got %d: %s", resp.Code, getBody(resp))
+	}
+	if body := getBody(resp); !strings.Contains(body, `numberReturned="3"`) {
+		t.Errorf("expected 3 features, got %s", body)
+	}
+}
+
+func TestWFS2GetCapabilities_Canary(t *testing.T) {
+	index, s := makeCanaryServer(t)
+	defer index.Close()
+	query, _ := http.NewRequest("GET", "/wfs?request=GetCapabilities", nil)
+	resp := httptest.NewRecorder()
+	http.HandlerFunc(s.HandleRequest).ServeHTTP(resp, query)
+	if body := getBody(resp); strings.Contains(body, "castles-next") {
+		t.Errorf("expected canary to be unlisted, got %s", body)
+	}
+}
+
+func TestParseWFS2Bbox(t *testing.T) {
+	for _, s := range []string{
+		"47.9,8.5,49.2,8.9",
+		"47.9,8.5,49.2,8.9,urn:ogc:def:crs:EPSG::4326",
+		"8.5,47.9,8.9,49.2,urn:ogc:def:crs:OGC:1.3:CRS84",
+		"8.5,47.9,8.9,49.2,EPSG:4326",
+	} {
+		bbox, err := parseWFS2Bbox(s)
+		if err != nil {
+			t.Errorf("unexpected error for %s: %v", s, err)
+			continue
+		}
+		expectBbox("8.5,47.9,8.9,49.2", EncodeBbox(bbox), t)
+	}
+}
+
+func TestXMLName(t *testing.T) {
+	for input, expected := range map[string]string{
+		"name":      "name",
+		"addr:city": "addr_city",
+		"1st":       "_st",
+		"":          "_",
+		"Pähl-2":    "Pähl-2",
+	} {
+		if got := xmlName(input); got != expected {
+			t.Errorf("expected xmlName(%q) = %q, got %q", input, expected, got)
+		}
+	}
+}
+
+func expectWellFormedXML(t *testing.T, s string) {
+	d := xml.NewDecoder(strings.NewReader(s))
+	for {
+		_, err := d.Token()
+		if err != nil {
+			if err != io.EOF {
+				t.Errorf("malformed XML: %v\n%s", err, s)
+			}
+			return
+		}
+	}
+}
@@ -1,21 +1,30 @@
 package main
 
 import (
-	"github.com/golang/geo/s2"
+	"net/url"
 	"testing"
+
+	"github.com/golang/geo/s2"
 )
 
 func TestFormatItemsURL(t *testing.T) {
 	bbox, _ := parseBbox("8.5,47.9,8.9,49.2")
-	got := FormatItemsURL("http://foo.org/bar/", "lakés", "ä123", 123, 99, bbox)
-	expected := "http://foo.org/bar/collections/lak%C3%A9s/items?startID=%C3%A4123&start=123&limit=99&bbox=8.5000000,47.9000000,8.9000000,49.2000000"
+	filter := PropertyFilter{"natural": "lake", "name": "Zürichsee"}
+	got := FormatItemsURL("http://foo.org/bar/", "lakés", itemsQuery{
+		itemsSelection: itemsSelection{bbox: bbox, filter: filter},
+		startID:        "ä123",
+		start:          123,
+		limit:          99,
+	})
+	expected := "http://foo.org/bar/collections/lak%C3%A9s/items?startID=%C3%A4123&start=123&limit=99&bbox=8.5000000,47.9000000,8.9000000,49.2000000&name=Z%C3%BCrichsee&natural=lake"
 	if expected != got {
 		t.Errorf("expected \"%s\", got \"%s\"", expected, got)
 	}
 }
 
 func TestFormatItemsURL_DefaultParams(t *testing.T) {
-	got := FormatItemsURL("http://foo.org/bar/", "lakes", "", 0, DefaultLimit, s2.FullRect())
+	got := FormatItemsURL("http://foo.org/bar/", "lakes",
+		itemsQuery{itemsSelection: itemsSelection{bbox: s2.FullRect()}, limit: DefaultLimit})
 	expected := "http://foo.org/bar/collections/lakes/items"
 	if expected != got {
 		t.Errorf("expected \"%s\", got \"%s\"", expected, got)
@@ -23,9 +32,56 @@ func TestFormatItemsURL_DefaultParams(t *testing.T) {
 }
 
 func TestFormatItemsURL_EmptyBbox(t *testing.T) {
-	got := FormatItemsURL("http://foo.org/bar/", "lakes", "", 0, DefaultLimit, s2.EmptyRect())
+	got := FormatItemsURL("http://foo.org/bar/", "lakes",
+		itemsQuery{itemsSelection: itemsSelection{bbox: s2.EmptyRect()}, limit: DefaultLimit})
 	expected := "http://foo.org/bar/collections/lakes/items"
 	if expected != got {
 		t.Errorf("expected \"%s\", got \"%s\"", expected, got)
 	}
 }
+
+func TestShortToken(t *testing.T) {
+	got := ShortToken("castles", "W418392510")
+	if len(got) != ShortTokenLength {
+		t.Errorf("expected %d characters, got \"%s\"", ShortTokenLength, got)
+	}
+	if got != ShortToken("castles", "W418392510") {
+		t.Error("expected ShortToken to be deterministic")
+	}
+	if got == ShortToken("lakes", "W418392510") {
+		t.Error("expected ShortToken to depend on collection")
+	}
+}
+
+func TestFormatItemsURL_CQLFilter(t *testing.T) {
+	cql, _ := ParseCQLJSON(`{"op":"=","args":[{"property":"a"},1]}`)
+	got := FormatItemsURL("http://foo.org/bar/", "lakes",
+		itemsQuery{itemsSelection: itemsSelection{bbox: s2.FullRect(), cql: cql}, limit: DefaultLimit})
+	expected := "http://foo.org/bar/collections/lakes/items?filter-lang=cql2-json&filter=%7B%22op%22%3A%22%3D%22%2C%22args%22%3A%5B%7B%22property%22%3A%22a%22%7D%2C1%5D%7D"
+	if expected != got {
+		t.Errorf("expected \"%s\", got \"%s\"", expected, got)
+	}
+}
+
+func TestEscapeItemID(t *testing.T) {
+	for id, expected := range map[string]string{
+		"N123":     "N123",
+		"a/b":      "a%2Fb",
+		"what?":    "what%3F",
+		"100%":     "100%25",
+		"#1":       "%231",
+		"Zürich":   "Z%C3%BCrich",
+		".":        "%2E",
+		"..":       "%2E%2E",
+		"../lakes": "..%2Flakes",
+	} {
+		if got := EscapeItemID(id); got != expected {
+			t.Errorf("EscapeItemID(%q): expected %q, got %q", id, expected, got)
+		}
+	}
+	for _, id := range nastyIDs {
+		if got, err := url.PathUnescape(EscapeItemID(id)); err != nil || got != id {
+			t.Errorf("EscapeItemID(%q) does not round-trip, got %q, %v", id, got, err)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Zero-config mode, for running in containers. When started without
+// any command-line flags, the server serves every file in dataDirectory
+// that it knows how to load, listens on all interfaces at port 8080,
+// derives its public path from incoming requests, and logs JSON.
+
+// dataDirectory is where zero-config mode looks for collections.
+const dataDirectory = "/data"
+
+// scanDataDirectory finds collections in a directory, returning a map
+// from collection name to file path. Each regular file with a supported
+// format becomes a collection named after the file without extension,
+// so /data/castles.geojson and /data/castles.geojson.gz get served as
+// castles. Hidden files and subdirectories are ignored. If two files
+// have the same name, such as lakes.geojson and lakes.shp, the first
+// in alphabetical order wins.
+func scanDataDirectory(dir string) (map[string]string, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Name() < files[j].Name() })
+
+	result := make(map[string]string)
+	for _, f := range files {
+		if !f.Mode().IsRegular() || strings.HasPrefix(f.Name(), ".") {
+			continue
+		}
+		path := filepath.Join(dir, f.Name())
+		if _, err := GetInputLoader(path); err != nil {
+			continue
+		}
+		name := strings.TrimSuffix(f.Name(), filepath.Ext(f.Name()))
+		if strings.EqualFold(filepath.Ext(f.Name()), ".gz") {
+			name = strings.TrimSuffix(name, filepath.Ext(name))
+		}
+		if other, exists := result[name]; exists {
+			loaderLog.Warn("ignoring file with same collection name", "collection", name,
+				"path", path, "served", other)
+			continue
+		}
+		result[name] = path
+	}
+	return result, nil
+}
+
+// publicPath returns the externally accessible URL of this server,
+// such as "https://example.org/wfs/". Unless configured by --pathPrefix,
+// it gets derived from the request, taking X-Forwarded-Proto and
+// X-Forwarded-Host from reverse proxies into account.
+func (s *WebServer) publicPath(req *http.Request) string {
+	if s.index.PublicPath != nil {
+		if prefix := s.index.PublicPath.String(); len(prefix) > 0 {
+			return prefix
+		}
+	}
+
+	scheme := "http"
+	if req.TLS != nil {
+		scheme = "https"
+	}
+	if proto := firstHeaderValue(req, "X-Forwarded-Proto"); proto == "http" || proto == "https" {
+		scheme = proto
+	}
+	host := req.Host
+	if forwarded := firstHeaderValue(req, "X-Forwarded-Host"); len(forwarded) > 0 {
+		host = forwarded
+	}
+	return scheme + "://" + host + "/"
+}
+
+// firstHeaderValue returns the first entry of a comma-separated
+// header, as set by chains of proxies, or the empty string.
+func firstHeaderValue(req *http.Request, header string) string {
+	value := strings.SplitN(req.Header.Get(header), ",", 2)[0]
+	return strings.TrimSpace(value)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestScanDataDirectory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "miniwfs-data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"castles.geojson", "lakes.geojson", "lakes.shp", "parks.shp", "parks.dbf", "trees.csv.gz", ".hidden.geojson", "README.md"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, "rivers.geojson"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := scanDataDirectory(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		"castles": filepath.Join(dir, "castles.geojson"),
+		"lakes":   filepath.Join(dir, "lakes.geojson"),
+		"parks":   filepath.Join(dir, "parks.shp"),
+		"trees":   filepath.Join(dir, "trees.csv.gz"),
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+
+	if _, err := scanDataDirectory(filepath.Join(dir, "missing")); err == nil {
+		t.Error("expected error for missing directory")
+	}
+}
+
+func TestPublicPath(t *testing.T) {
+	index, s := makeServer(t)
+	defer index.Close()
+	defer s.Shutdown()
+
+	req := httptest.NewRequest("GET", "http://wfs.example.com/collections", nil)
+	if got := s.publicPath(req); got != "https://test.example.org/wfs/" {
+		t.Errorf("with --pathPrefix, expected configured path, got %q", got)
+	}
+
+	index.PublicPath = nil
+	for _, tc := range []struct {
+		headers  map[string]string
+		tls      bool
+		expected string
+	}{
+		{nil, false, "http://wfs.example.com/"},
+		{nil, true, "https://wfs.example.com/"},
+		{map[string]string{"X-Forwarded-Proto": "https"}, false, "https://wfs.example.com/"},
+		{map[string]string{"X-Forwarded-Proto": "gopher"}, false, "http://wfs.example.com/"},
+		{map[string]string{"X-Forwarded-Host": "maps.example.org, proxy.internal"}, false, "http://maps.example.org/"},
+	} {
+		req := httptest.NewRequest("GET", "http://wfs.example.com/collections", nil)
+		for key, value := range tc.headers {
+			req.Header.Set(key, value)
+		}
+		if tc.tls {
+			req.TLS = &tls.ConnectionState{}
+		} else {
+			req.TLS = nil
+		}
+		if got := s.publicPath(req); got != tc.expected {
+			t.Errorf("headers %v, tls=%v: expected %q, got %q", tc.headers, tc.tls, tc.expected, got)
+		}
+	}
+}